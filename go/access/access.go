@@ -0,0 +1,178 @@
+//Package access decides which entities may do what with activities, based on their participations.
+//
+//A Policy maps the roles of participations (see participation.ParticipationRole) to permissions. The roles an
+//entity has in an activity are granted to it in all of the activity's Subs, transitively, so that e.g. the
+//lead of a project can administer all activities that are part of it. A participation only grants its role
+//while its Period contains the time of the request. With a directory.Directory (see Authorizer.SetDirectory),
+//the participations of a group grant their roles to the members of the group, e.g. a participation of "Team
+//Blue" to everyone in the team.
+//
+//When several grants apply to the same entity and permission, the decision is made as follows:
+//
+//  - if any applying grant has an Override role, only those grants count, and a denial among them wins;
+//  - otherwise the grants on the nearest activity count (the activity itself, then its Supers, then theirs,
+//    ...), and a denial among them wins;
+//  - without applying grants, the permission is denied.
+//
+//An Authorizer makes and explains these decisions, and Store enforces them on every operation of a
+//store.Store for the entity in the context (see WithEntity).
+package access
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	//ErrorCodeDenied is returned when the entity doesn't have the permission for the operation. The details
+	//contain the "entity", the "activity" and the "permission".
+	ErrorCodeDenied = "access-denied"
+	//ErrorCodeUnauthenticated is returned when an operation is done without an entity in the context.
+	ErrorCodeUnauthenticated = "access-unauthenticated"
+	ErrorCodeInvalidPolicy   = "access-invalid-policy"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeDenied, Status: http.StatusForbidden, Message: errcode.En("{entity} doesn't have the {permission} permission for {activity}")},
+		errcode.Code{Code: ErrorCodeUnauthenticated, Status: http.StatusUnauthorized, Message: errcode.En("authentication required")},
+		errcode.Code{Code: ErrorCodeInvalidPolicy, Status: http.StatusInternalServerError, Message: errcode.En("invalid access policy")},
+	)
+}
+
+//Permission is something an entity can do with an activity.
+type Permission string
+
+const (
+	//PermissionRead allows reading the activity, its versions and its parts.
+	PermissionRead Permission = "read"
+	//PermissionWrite allows creating new versions of the activity and adding Subs to it. It implies
+	//PermissionRead.
+	PermissionWrite Permission = "write"
+	//PermissionParticipate allows an entity to add participations of itself to the activity, with roles
+	//that grant no more than PermissionParticipate. It implies PermissionRead.
+	PermissionParticipate Permission = "participate"
+	//PermissionAdminister allows changing who has access to the activity: its participations and its
+	//Supers, and deleting it. It implies all other permissions.
+	PermissionAdminister Permission = "administer"
+)
+
+//Implies checks that having p means having other too.
+func (p Permission) Implies(other Permission) bool {
+	switch p {
+	case PermissionAdminister:
+		return true
+	case PermissionWrite, PermissionParticipate:
+		return other == p || other == PermissionRead
+	default:
+		return other == p
+	}
+}
+
+//IsValid checks that p is one of the Permission constants.
+func (p Permission) IsValid() bool {
+	switch p {
+	case PermissionRead, PermissionWrite, PermissionParticipate, PermissionAdminister:
+		return true
+	}
+	return false
+}
+
+//Role is what a participation role grants.
+type Role struct {
+	//Permissions are the permissions granted (or denied, if Deny) to the entity of the participation.
+	Permissions []Permission `json:"permissions"`
+	//Deny makes the role take the Permissions away instead of granting them, together with the permissions
+	//that imply them, e.g. to exclude a member of a project from one of its activities by denying
+	//PermissionRead.
+	Deny bool `json:"deny,omitempty"`
+	//Override makes the role take precedence over the roles without Override, wherever they are in the
+	//activity graph, e.g. to ban someone from everything in a project.
+	Override bool `json:"override,omitempty"`
+}
+
+//Concerns checks that the role grants p (one of its Permissions implies p) or, if it is a Deny role, that it
+//denies p (p implies one of its Permissions).
+func (r Role) Concerns(p Permission) bool {
+	for _, has := range r.Permissions {
+		if !r.Deny && has.Implies(p) || r.Deny && p.Implies(has) {
+			return true
+		}
+	}
+	return false
+}
+
+//Policy maps the ids of participation roles (see participation.ParticipationRoleRef) to what they grant.
+//Roles that aren't in the Policy grant nothing.
+type Policy struct {
+	Roles map[string]Role `json:"roles"`
+}
+
+//Validate checks that every role has valid permissions.
+func (p Policy) Validate() error {
+	for id, r := range p.Roles {
+		if len(r.Permissions) == 0 {
+			return aldberr.New(ErrorCodeInvalidPolicy, "role has no permissions", map[string]interface{}{"role": id})
+		}
+		for _, perm := range r.Permissions {
+			if !perm.IsValid() {
+				return aldberr.New(ErrorCodeInvalidPolicy, "role has an unknown permission", map[string]interface{}{"role": id, "permission": string(perm)})
+			}
+		}
+	}
+	return nil
+}
+
+//ReadPolicy reads a Policy from a JSON file, e.g.
+//
+//	{"roles": {"http://uius.org/apps/projects/roles/lead": {"permissions": ["administer"]}}}
+func ReadPolicy(path string) (Policy, error) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, aldberr.Wrap(err, ErrorCodeInvalidPolicy, "cannot read policy", map[string]interface{}{"path": path})
+	}
+	out := Policy{}
+	if err := json.Unmarshal(bts, &out); err != nil {
+		return Policy{}, aldberr.Wrap(err, ErrorCodeInvalidPolicy, "cannot unmarshal policy", map[string]interface{}{"path": path})
+	}
+	return out, out.Validate()
+}
+
+//region context
+
+type contextKey int
+
+const (
+	entityKey contextKey = iota
+	systemKey
+)
+
+//WithEntity returns a context for operations done by (or on behalf of) the entity.
+func WithEntity(ctx context.Context, e participation.EntityRef) context.Context {
+	return context.WithValue(ctx, entityKey, e)
+}
+
+//EntityFrom returns the entity of WithEntity, if any.
+func EntityFrom(ctx context.Context) (participation.EntityRef, bool) {
+	e, found := ctx.Value(entityKey).(participation.EntityRef)
+	return e, found && e.IsComplete()
+}
+
+//AsSystem returns a context for operations done by the system itself (e.g. loading data or collecting
+//garbage), which Store doesn't check.
+func AsSystem(ctx context.Context) context.Context {
+	return context.WithValue(ctx, systemKey, true)
+}
+
+func isSystem(ctx context.Context) bool {
+	system, _ := ctx.Value(systemKey).(bool)
+	return system
+}
+
+//endregion
@@ -0,0 +1,259 @@
+package access
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/common/datetime"
+	"github.com/vital-dhaveloose/aldb/directory"
+	"github.com/vital-dhaveloose/aldb/graph"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+	"github.com/vital-dhaveloose/aldb/store/storetest"
+)
+
+var testPolicy = Policy{Roles: map[string]Role{
+	"lead":     {Permissions: []Permission{PermissionAdminister}},
+	"member":   {Permissions: []Permission{PermissionWrite, PermissionParticipate}},
+	"guest":    {Permissions: []Permission{PermissionRead}},
+	"trusted":  {Permissions: []Permission{PermissionRead}, Override: true},
+	"excluded": {Permissions: []Permission{PermissionRead}, Deny: true},
+	"banned":   {Permissions: []Permission{PermissionRead}, Deny: true, Override: true},
+}}
+
+var past = datetime.Period{Start: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+func entity(id string) participation.EntityRef {
+	return participation.EntityRef{EntityId: id}
+}
+
+func participant(id, role string, period datetime.Period) participation.Participation {
+	return participation.Participation{
+		Entity: &participation.Person{Ref: entity(id)},
+		Role:   &participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: role}},
+		Period: period,
+	}
+}
+
+//newTestStore creates org > project > task, where alice leads org, bob is a member of project but excluded
+//from task, carol was a member of project, and dave leads task but is banned from org.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	gs, err := graph.NewStore(context.Background(), store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore(gs, testPolicy)
+	ctx := AsSystem(context.Background())
+	org := storetest.NewActivity("org", "", "Organisation")
+	org.Participations = []participation.Participation{participant("alice", "lead", datetime.Period{}), participant("dave", "banned", datetime.Period{})}
+	project := storetest.NewActivity("project", "", "Project")
+	project.Supers = []*activity.Activity{{ActivityRef: storetest.Ref("org", "")}}
+	project.Participations = []participation.Participation{participant("bob", "member", datetime.Period{}), participant("carol", "member", past)}
+	task := storetest.NewActivity("task", "", "Task")
+	task.Supers = []*activity.Activity{{ActivityRef: storetest.Ref("project", "")}}
+	task.Participations = []participation.Participation{participant("bob", "excluded", datetime.Period{}), participant("dave", "lead", datetime.Period{})}
+	for _, a := range []activity.Activity{org, project, task} {
+		if _, err := s.Create(ctx, store.CreateRequest{ToCreate: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestExplain(t *testing.T) {
+	s := newTestStore(t)
+	for _, c := range []struct {
+		entity     string
+		activity   string
+		permission Permission
+		allowed    bool
+		reason     string
+	}{
+		{"alice", "task", PermissionAdminister, true, "role lead in activities/https:%2F%2Faldb.test%2Factivities%2Forg, of which the activity is part (distance 2)"},
+		{"bob", "project", PermissionWrite, true, "role member in the activity itself"},
+		{"bob", "project", PermissionAdminister, false, "no participation"},
+		{"bob", "task", PermissionRead, false, "denied by the role excluded"},
+		{"carol", "project", PermissionRead, false, "aren't current"},
+		{"dave", "task", PermissionRead, false, "overriding role banned"},
+		{"eve", "org", PermissionRead, false, "no participation"},
+	} {
+		res, err := s.Authorizer().Explain(context.Background(), ExplainRequest{Entity: entity(c.entity), Activity: storetest.Ref(c.activity, ""), Permission: c.permission})
+		if err != nil {
+			t.Fatal(err)
+		}
+		x := res.Explanation
+		if x.Allowed != c.allowed || !strings.Contains(x.Reason, c.reason) {
+			t.Errorf("%s %s %s: expected %t (%s), got %t (%s)", c.entity, c.permission, c.activity, c.allowed, c.reason, x.Allowed, x.Reason)
+		}
+	}
+
+	res, err := s.Authorizer().Explain(context.Background(), ExplainRequest{Entity: entity("carol"), Activity: storetest.Ref("task", ""), Permission: PermissionWrite, At: past.Start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := res.Explanation; !x.Allowed || x.Decisive == nil || x.Decisive.Distance != 1 {
+		t.Errorf("expected carol to have been allowed to write by her past membership, got %+v", x)
+	}
+}
+
+func TestGroupParticipations(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	d := directory.NewMemoryDirectory()
+	for _, e := range []participation.Entity{
+		&participation.Person{Ref: entity("erin")},
+		&participation.Person{Ref: entity("frank")},
+		&participation.Group{Ref: entity("team-blue")},
+		&participation.Group{Ref: entity("frontend")},
+	} {
+		if _, err := d.Create(ctx, directory.CreateRequest{ToCreate: e}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for group, member := range map[string]string{"team-blue": "frontend", "frontend": "erin"} {
+		if _, err := d.SetMembers(ctx, directory.SetMembersRequest{Group: entity(group), Members: []participation.EntityRef{entity(member)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Authorizer().SetDirectory(d)
+	org := readLatest(t, s, "org")
+	org.Version, org.ParentVersions = "", nil
+	org.Participations = append(org.Participations, participation.Participation{
+		Entity: &participation.Group{Ref: entity("team-blue")},
+		Role:   &participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: "member"}},
+	})
+	if _, err := s.Create(AsSystem(ctx), store.CreateRequest{ToCreate: org}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		entity  string
+		allowed bool
+		reason  string
+	}{
+		{"erin", true, "granted by the role member of entities/team-blue in activities/https:%2F%2Faldb.test%2Factivities%2Forg"},
+		{"frank", false, "no participation"},
+		{"ghost", false, "no participation"},
+	} {
+		res, err := s.Authorizer().Explain(ctx, ExplainRequest{Entity: entity(c.entity), Activity: storetest.Ref("task", ""), Permission: PermissionWrite})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x := res.Explanation; x.Allowed != c.allowed || !strings.Contains(x.Reason, c.reason) {
+			t.Errorf("%s: expected %t (%s), got %t (%s)", c.entity, c.allowed, c.reason, x.Allowed, x.Reason)
+		}
+	}
+	if _, err := s.Read(WithEntity(ctx, entity("erin")), store.ReadRequest{Ref: storetest.Ref("project", "")}); err != nil {
+		t.Errorf("expected erin to read the project as a member of team blue, got %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := newTestStore(t)
+	bg := context.Background()
+	alice, bob := WithEntity(bg, entity("alice")), WithEntity(bg, entity("bob"))
+
+	_, err := s.Read(bg, store.ReadRequest{Ref: storetest.Ref("org", "")})
+	storetest.AssertErrorCode(t, err, ErrorCodeUnauthenticated)
+	_, err = s.Read(bob, store.ReadRequest{Ref: storetest.Ref("task", "")})
+	storetest.AssertErrorCode(t, err, ErrorCodeDenied)
+	_, err = s.Read(bob, store.ReadRequest{Ref: storetest.Ref("missing", "")})
+	storetest.AssertErrorCode(t, err, store.ErrorCodeNotFound)
+
+	list, err := s.List(bob, store.ListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Activities) != 1 || list.Activities[0].Id.String() != storetest.Ref("project", "").Id.String() {
+		t.Errorf("expected bob to list only the project, got %d activities", len(list.Activities))
+	}
+	subs, err := s.ReadSubs(alice, store.ReadLinksRequest{Ref: storetest.Ref("project", "")})
+	if err != nil || len(subs.Activities) != 1 {
+		t.Errorf("expected alice to read the task, got %v (%v)", subs.Activities, err)
+	}
+
+	project := readLatest(t, s, "project")
+	next := project
+	next.Version, next.ParentVersions, next.Participations = "", nil, nil
+	if _, err := s.Create(bob, store.CreateRequest{ToCreate: next}); err != nil {
+		t.Errorf("expected bob to write the project, got %v", err)
+	}
+	next.Participations = append(append([]participation.Participation{}, project.Participations...), participant("eve", "guest", datetime.Period{}))
+	_, err = s.Create(bob, store.CreateRequest{ToCreate: next})
+	storetest.AssertErrorCode(t, err, ErrorCodeDenied)
+	next.Participations = append(append([]participation.Participation{}, project.Participations...), participant("bob", "guest", datetime.Period{}))
+	if _, err := s.Create(bob, store.CreateRequest{ToCreate: next}); err != nil {
+		t.Errorf("expected bob to participate as guest, got %v", err)
+	}
+	//an override role would cancel the exclusion of bob from the task
+	next.Participations = append(append([]participation.Participation{}, project.Participations...), participant("bob", "trusted", datetime.Period{}))
+	_, err = s.Create(bob, store.CreateRequest{ToCreate: next})
+	storetest.AssertErrorCode(t, err, ErrorCodeDenied)
+	_, err = s.Read(bob, store.ReadRequest{Ref: storetest.Ref("task", "")})
+	storetest.AssertErrorCode(t, err, ErrorCodeDenied)
+	next.Participations = append(append([]participation.Participation{}, project.Participations...), participant("bob", "lead", datetime.Period{}))
+	_, err = s.Create(bob, store.CreateRequest{ToCreate: next})
+	storetest.AssertErrorCode(t, err, ErrorCodeDenied)
+	next.Participations, next.Supers = nil, []*activity.Activity{}
+	_, err = s.Create(bob, store.CreateRequest{ToCreate: next})
+	storetest.AssertErrorCode(t, err, ErrorCodeDenied)
+
+	sub := storetest.NewActivity("sub", "", "Sub")
+	sub.Supers = []*activity.Activity{{ActivityRef: storetest.Ref("task", "")}}
+	_, err = s.Create(bob, store.CreateRequest{ToCreate: sub})
+	storetest.AssertErrorCode(t, err, ErrorCodeDenied)
+	sub.Supers = []*activity.Activity{{ActivityRef: storetest.Ref("project", "")}}
+	if _, err := s.Create(bob, store.CreateRequest{ToCreate: sub}); err != nil {
+		t.Errorf("expected bob to create a sub of the project, got %v", err)
+	}
+
+	_, err = s.Delete(bob, store.DeleteRequest{Ref: storetest.Ref("project", "")})
+	storetest.AssertErrorCode(t, err, ErrorCodeDenied)
+	if _, err := s.Delete(alice, store.DeleteRequest{Ref: storetest.Ref("task", "")}); err != nil {
+		t.Errorf("expected alice to delete the task, got %v", err)
+	}
+}
+
+//TestStoreOlderParent checks that the participations carried over from an older parent version are checked,
+//as they would undo the changes of the versions after it.
+func TestStoreOlderParent(t *testing.T) {
+	s := newTestStore(t)
+	system, bob := AsSystem(context.Background()), WithEntity(context.Background(), entity("bob"))
+
+	lead := readLatest(t, s, "project")
+	lead.Version, lead.ParentVersions = "", nil
+	lead.Participations = []participation.Participation{participant("bob", "lead", datetime.Period{})}
+	v1, err := s.Create(system, store.CreateRequest{ToCreate: lead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member := v1.Created
+	member.Version, member.ParentVersions = "", nil
+	member.Participations = []participation.Participation{participant("bob", "member", datetime.Period{})}
+	if _, err := s.Create(system, store.CreateRequest{ToCreate: member}); err != nil {
+		t.Fatal(err)
+	}
+
+	next := v1.Created
+	next.Version, next.ParentVersions, next.Participations = "", []string{v1.Created.Version}, nil
+	_, err = s.Create(bob, store.CreateRequest{ToCreate: next})
+	storetest.AssertErrorCode(t, err, ErrorCodeDenied)
+	next.Participations = member.Participations
+	if _, err := s.Create(bob, store.CreateRequest{ToCreate: next}); err != nil {
+		t.Errorf("expected bob to write the project keeping his participations, got %v", err)
+	}
+}
+
+func readLatest(t *testing.T, s *Store, id string) activity.Activity {
+	t.Helper()
+	res, err := s.Read(AsSystem(context.Background()), store.ReadRequest{Ref: ref.ActivityRef{Id: storetest.Ref(id, "").Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Activity
+}
@@ -0,0 +1,284 @@
+package access
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/datetime"
+	"github.com/vital-dhaveloose/aldb/directory"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+//Authorizer decides on permissions according to a Policy. It reads the participations of the activities and
+//their Supers from a Store, which it doesn't check itself. An Authorizer is safe for concurrent use.
+type Authorizer struct {
+	store     store.Store
+	policy    Policy
+	directory directory.Directory
+	now       func() time.Time
+}
+
+func NewAuthorizer(s store.Store, p Policy) *Authorizer {
+	return &Authorizer{store: s, policy: p, now: time.Now}
+}
+
+//SetDirectory makes the participations of groups in d apply to their members, also to the members of groups
+//that are members (see directory.ReadGroupsRequest). Entities that aren't in d only have their own
+//participations. It must be called before the Authorizer is used.
+func (a *Authorizer) SetDirectory(d directory.Directory) {
+	a.directory = d
+}
+
+//Policy returns the policy the Authorizer decides by.
+func (a *Authorizer) Policy() Policy {
+	return a.policy
+}
+
+type ExplainRequest struct {
+	Entity participation.EntityRef
+	//Activity is the activity to decide on. Its Version is ignored: access is always decided by the latest
+	//versions of the activity and its Supers, so that revoking access also revokes it for older versions.
+	Activity   ref.ActivityRef
+	Permission Permission
+	//At is the time at which the participations must be current. If zero, the current time is used.
+	At time.Time
+}
+
+type ExplainResponse struct {
+	Explanation Explanation
+}
+
+//Explanation tells whether an entity has a permission for an activity, and why.
+type Explanation struct {
+	Entity     participation.EntityRef `json:"entity"`
+	Activity   ref.ActivityRef         `json:"activity"`
+	Permission Permission              `json:"permission"`
+	At         time.Time               `json:"at"`
+	Allowed    bool                    `json:"allowed"`
+	//Reason summarizes the decision.
+	Reason string `json:"reason"`
+	//Decisive is the grant that made the decision, or nil if no grant applies.
+	Decisive *Grant `json:"decisive,omitempty"`
+	//Grants contains every grant that applies, nearest first.
+	Grants []Grant `json:"grants"`
+	//Ignored contains the grants of the entity that concern the permission but don't apply at At.
+	Ignored []Grant `json:"ignored,omitempty"`
+}
+
+//Grant is a participation of an entity in an activity, with a role that concerns a permission.
+type Grant struct {
+	//Activity is the activity that has the participation: the activity that is decided on or one of its
+	//(indirect) Supers.
+	Activity ref.ActivityRef `json:"activity"`
+	//Distance is the number of Supers links from the activity that is decided on to Activity, 0 if it is
+	//the activity itself.
+	Distance int             `json:"distance"`
+	Role     string          `json:"role"`
+	Period   datetime.Period `json:"period"`
+	Deny     bool            `json:"deny,omitempty"`
+	Override bool            `json:"override,omitempty"`
+	//Via is the group the participation is of, if it isn't of the entity itself but of a group the entity is
+	//(indirectly) a member of.
+	Via *participation.EntityRef `json:"via,omitempty"`
+}
+
+//Explain decides whether the entity has the permission for the activity.
+func (a *Authorizer) Explain(ctx context.Context, req ExplainRequest) (ExplainResponse, error) {
+	res, err := a.store.Read(ctx, store.ReadRequest{Ref: ref.ActivityRef{Id: req.Activity.Id}})
+	if err != nil {
+		return ExplainResponse{}, err
+	}
+	at := req.At
+	if at.IsZero() {
+		at = a.now()
+	}
+	x, err := a.explain(ctx, req.Entity, res.Activity, req.Permission, at)
+	if err != nil {
+		return ExplainResponse{}, err
+	}
+	return ExplainResponse{Explanation: x}, nil
+}
+
+//Check returns an ErrorCodeDenied error if the entity doesn't have the permission for the activity now.
+func (a *Authorizer) Check(ctx context.Context, e participation.EntityRef, r ref.ActivityRef, p Permission) error {
+	res, err := a.Explain(ctx, ExplainRequest{Entity: e, Activity: r, Permission: p})
+	if err != nil {
+		return err
+	}
+	return res.Explanation.Err()
+}
+
+//CheckContext is Check for the entity in the context (see WithEntity). It returns an
+//ErrorCodeUnauthenticated error if there is none, and nil for a context of AsSystem.
+func (a *Authorizer) CheckContext(ctx context.Context, r ref.ActivityRef, p Permission) error {
+	if isSystem(ctx) {
+		return nil
+	}
+	e, found := EntityFrom(ctx)
+	if !found {
+		return unauthenticated()
+	}
+	return a.Check(ctx, e, r, p)
+}
+
+//Err returns nil if the permission is allowed, and an ErrorCodeDenied error otherwise.
+func (x Explanation) Err() error {
+	if x.Allowed {
+		return nil
+	}
+	return deniedError(x.Entity, x.Activity, x.Permission, x.Reason)
+}
+
+//explain decides on the permission for a, which may be a version that is about to be created, by the
+//participations in a and its (indirect) Supers. These are visited breadth-first, so that the grants are
+//ordered by distance.
+func (a *Authorizer) explain(ctx context.Context, e participation.EntityRef, act activity.Activity, p Permission, at time.Time) (Explanation, error) {
+	x := Explanation{Entity: e, Activity: ref.ActivityRef{Id: act.Id}, Permission: p, At: at, Grants: []Grant{}}
+	groups, err := a.groups(ctx, e)
+	if err != nil {
+		return Explanation{}, err
+	}
+	type visit struct {
+		a        activity.Activity
+		distance int
+	}
+	queue := []visit{{a: act}}
+	visited := map[string]bool{act.Id.String(): true}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, g := range a.grants(e, groups, v.a, p) {
+			g.Distance = v.distance
+			if g.Period.Contains(at) {
+				x.Grants = append(x.Grants, g)
+			} else {
+				x.Ignored = append(x.Ignored, g)
+			}
+		}
+		for _, super := range v.a.Supers {
+			if super == nil || super.Id == nil || visited[super.Id.String()] {
+				continue
+			}
+			visited[super.Id.String()] = true
+			res, err := a.store.Read(ctx, store.ReadRequest{Ref: ref.ActivityRef{Id: super.Id}})
+			if err != nil {
+				if isNotFound(err) {
+					continue
+				}
+				return Explanation{}, err
+			}
+			queue = append(queue, visit{a: res.Activity, distance: v.distance + 1})
+		}
+	}
+	x.Decisive = decide(x.Grants)
+	x.Allowed = x.Decisive != nil && !x.Decisive.Deny
+	x.Reason = reason(x)
+	return x, nil
+}
+
+//groups returns the groups e is (indirectly) a member of in the directory, if any.
+func (a *Authorizer) groups(ctx context.Context, e participation.EntityRef) (map[participation.EntityRef]bool, error) {
+	out := map[participation.EntityRef]bool{}
+	if a.directory == nil {
+		return out, nil
+	}
+	res, err := a.directory.ReadGroups(ctx, directory.ReadGroupsRequest{Member: e, Transitive: true})
+	if err != nil {
+		if aldberr.IsCode(err, directory.ErrorCodeNotFound) {
+			return out, nil
+		}
+		return nil, err
+	}
+	for _, g := range res.Groups {
+		out[g.EntityRef()] = true
+	}
+	return out, nil
+}
+
+//grants returns the grants of the participations of e, and of the groups it is a member of, in act that
+//concern p.
+func (a *Authorizer) grants(e participation.EntityRef, groups map[participation.EntityRef]bool, act activity.Activity, p Permission) []Grant {
+	out := []Grant{}
+	for _, part := range act.Participations {
+		if part.Entity == nil || part.Role == nil {
+			continue
+		}
+		var via *participation.EntityRef
+		if pe := part.Entity.EntityRef(); pe != e {
+			if !groups[pe] {
+				continue
+			}
+			via = &pe
+		}
+		role, found := a.policy.Roles[part.Role.ParticipationRoleId]
+		if !found || !role.Concerns(p) {
+			continue
+		}
+		out = append(out, Grant{
+			Activity: ref.ActivityRef{Id: act.Id},
+			Role:     part.Role.ParticipationRoleId,
+			Period:   part.Period,
+			Deny:     role.Deny,
+			Override: role.Override,
+			Via:      via,
+		})
+	}
+	return out
+}
+
+//decide returns the decisive grant amongst grants ordered by distance, see the package documentation.
+func decide(grants []Grant) *Grant {
+	candidates := []Grant{}
+	for _, g := range grants {
+		if g.Override {
+			candidates = append(candidates, g)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, g := range grants {
+			if g.Distance == grants[0].Distance {
+				candidates = append(candidates, g)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	for _, g := range candidates {
+		if g.Deny {
+			return &g
+		}
+	}
+	return &candidates[0]
+}
+
+func reason(x Explanation) string {
+	g := x.Decisive
+	if g == nil {
+		if len(x.Ignored) > 0 {
+			return "the participations of the entity that concern the permission aren't current"
+		}
+		return "no participation of the entity grants the permission"
+	}
+	verb := "granted"
+	if g.Deny {
+		verb = "denied"
+	}
+	role := "the role " + g.Role
+	if g.Override {
+		role = "the overriding role " + g.Role
+	}
+	if g.Via != nil {
+		role += " of " + g.Via.ToName()
+	}
+	where := "the activity itself"
+	if g.Distance > 0 {
+		where = fmt.Sprintf("%s, of which the activity is part (distance %d)", g.Activity.ToName(), g.Distance)
+	}
+	return fmt.Sprintf("%s by %s in %s", verb, role, where)
+}
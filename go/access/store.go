@@ -0,0 +1,342 @@
+package access
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+//Store is a store.Store that checks every operation against the permissions of the entity in the context
+//(see WithEntity), and leaves out the activities it cannot read from lists. Operations in a context of
+//AsSystem aren't checked.
+//
+//Creating a version of an existing activity requires PermissionWrite, and changing its participations
+//requires PermissionAdminister (or PermissionParticipate, for participations of the entity itself). Linking
+//or unlinking a Super requires PermissionWrite on the Super and PermissionAdminister on the activity, and
+//linking or unlinking a Sub requires PermissionAdminister on the Sub, as these change who has access. A new
+//activity can be created by any entity, under Supers it can write. Omitted participations and links are
+//checked as the ones carried over from the first parent version.
+type Store struct {
+	inner      store.Store
+	authorizer *Authorizer
+}
+
+//NewStore wraps inner, reading the participations from inner.
+func NewStore(inner store.Store, p Policy) *Store {
+	return &Store{inner: inner, authorizer: NewAuthorizer(inner, p)}
+}
+
+//Authorizer returns the Authorizer of the Store, e.g. to explain its decisions.
+func (s *Store) Authorizer() *Authorizer {
+	return s.authorizer
+}
+
+func (s *Store) Create(ctx context.Context, req store.CreateRequest) (store.CreateResponse, error) {
+	if err := s.checkCreate(ctx, req.ToCreate); err != nil {
+		return store.CreateResponse{}, err
+	}
+	return s.inner.Create(ctx, req)
+}
+
+func (s *Store) Read(ctx context.Context, req store.ReadRequest) (store.ReadResponse, error) {
+	if err := s.authorizer.CheckContext(ctx, req.Ref, PermissionRead); err != nil {
+		return store.ReadResponse{}, err
+	}
+	return s.inner.Read(ctx, req)
+}
+
+func (s *Store) List(ctx context.Context, req store.ListRequest) (store.ListResponse, error) {
+	resp, err := s.inner.List(ctx, req)
+	if err != nil {
+		return store.ListResponse{}, err
+	}
+	resp.Activities, err = s.readable(ctx, resp.Activities)
+	return resp, err
+}
+
+//Delete requires PermissionAdminister, also to delete a single version.
+func (s *Store) Delete(ctx context.Context, req store.DeleteRequest) (store.DeleteResponse, error) {
+	if err := s.authorizer.CheckContext(ctx, req.Ref, PermissionAdminister); err != nil {
+		return store.DeleteResponse{}, err
+	}
+	return s.inner.Delete(ctx, req)
+}
+
+func (s *Store) ReadSubs(ctx context.Context, req store.ReadLinksRequest) (store.ReadLinksResponse, error) {
+	if err := s.authorizer.CheckContext(ctx, req.Ref, PermissionRead); err != nil {
+		return store.ReadLinksResponse{}, err
+	}
+	resp, err := s.inner.ReadSubs(ctx, req)
+	if err != nil {
+		return store.ReadLinksResponse{}, err
+	}
+	resp.Activities, err = s.readable(ctx, resp.Activities)
+	return resp, err
+}
+
+func (s *Store) ReadSupers(ctx context.Context, req store.ReadLinksRequest) (store.ReadLinksResponse, error) {
+	if err := s.authorizer.CheckContext(ctx, req.Ref, PermissionRead); err != nil {
+		return store.ReadLinksResponse{}, err
+	}
+	resp, err := s.inner.ReadSupers(ctx, req)
+	if err != nil {
+		return store.ReadLinksResponse{}, err
+	}
+	resp.Activities, err = s.readable(ctx, resp.Activities)
+	return resp, err
+}
+
+func (s *Store) History(ctx context.Context, req store.HistoryRequest) (store.HistoryResponse, error) {
+	if err := s.authorizer.CheckContext(ctx, req.Ref, PermissionRead); err != nil {
+		return store.HistoryResponse{}, err
+	}
+	return s.inner.History(ctx, req)
+}
+
+//readable returns the activities the entity in the context can read.
+func (s *Store) readable(ctx context.Context, as []activity.Activity) ([]activity.Activity, error) {
+	if isSystem(ctx) {
+		return as, nil
+	}
+	e, found := EntityFrom(ctx)
+	if !found {
+		return nil, unauthenticated()
+	}
+	out := make([]activity.Activity, 0, len(as))
+	for _, a := range as {
+		err := s.authorizer.Check(ctx, e, a.ActivityRef, PermissionRead)
+		switch {
+		case err == nil:
+			out = append(out, a)
+		case !isDenied(err) && !isNotFound(err):
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (s *Store) checkCreate(ctx context.Context, toCreate activity.Activity) error {
+	if isSystem(ctx) || toCreate.Id == nil {
+		return nil
+	}
+	e, found := EntityFrom(ctx)
+	if !found {
+		return unauthenticated()
+	}
+	check := func(r ref.ActivityRef, p Permission) error {
+		err := s.authorizer.Check(ctx, e, r, p)
+		if isNotFound(err) {
+			//links to missing activities are left to the inner Store
+			return nil
+		}
+		return err
+	}
+
+	latest, err := s.inner.Read(ctx, store.ReadRequest{Ref: ref.ActivityRef{Id: toCreate.Id}})
+	if isNotFound(err) {
+		for _, super := range linkRefs(toCreate.Supers) {
+			if err := check(super, PermissionWrite); err != nil {
+				return err
+			}
+		}
+		for _, sub := range linkRefs(toCreate.Subs) {
+			if err := check(sub, PermissionAdminister); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r := ref.ActivityRef{Id: toCreate.Id}
+	if err := check(r, PermissionWrite); err != nil {
+		return err
+	}
+	toCreate, err = s.carryOver(ctx, toCreate, latest.Activity)
+	if err != nil {
+		return err
+	}
+	if toCreate.Participations != nil {
+		if p, changed := s.participationsPermission(e, latest.Activity.Participations, toCreate.Participations); changed {
+			if err := check(r, p); err != nil {
+				return err
+			}
+		}
+	}
+	if toCreate.Supers != nil {
+		changed := changedLinks(linkRefs(latest.Activity.Supers), linkRefs(toCreate.Supers))
+		for _, super := range changed {
+			if err := check(super, PermissionWrite); err != nil {
+				return err
+			}
+		}
+		if len(changed) > 0 {
+			if err := check(r, PermissionAdminister); err != nil {
+				return err
+			}
+		}
+	}
+	if toCreate.Subs != nil {
+		for _, sub := range changedLinks(linkRefs(latest.Activity.Subs), linkRefs(toCreate.Subs)) {
+			if err := check(sub, PermissionAdminister); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//carryOver gives the participations and links that toCreate omits the ones of the parent version they will be
+//carried over from (see store.PrepareVersion), so that they are checked against latest as well. The parent
+//can be older than latest, and carrying over its participations would undo the changes made since.
+func (s *Store) carryOver(ctx context.Context, toCreate, latest activity.Activity) (activity.Activity, error) {
+	parent := latest
+	if len(toCreate.ParentVersions) > 0 && toCreate.ParentVersions[0] != latest.Version {
+		res, err := s.inner.Read(ctx, store.ReadRequest{Ref: ref.ActivityRef{Id: toCreate.Id, Version: toCreate.ParentVersions[0]}})
+		if isNotFound(err) {
+			//a missing parent is left to the inner Store
+			return toCreate, nil
+		}
+		if err != nil {
+			return activity.Activity{}, err
+		}
+		parent = res.Activity
+	}
+	if toCreate.Participations == nil {
+		toCreate.Participations = parent.Participations
+	}
+	if toCreate.Supers == nil {
+		toCreate.Supers = parent.Supers
+	}
+	if toCreate.Subs == nil {
+		toCreate.Subs = parent.Subs
+	}
+	return toCreate, nil
+}
+
+//participationsPermission returns the permission needed to change the participations from before to after,
+//and whether they changed at all. Adding or removing participations of e itself with roles that grant no
+//more than PermissionParticipate only needs PermissionParticipate, except for removing denials and for roles
+//with Override, which would outweigh the roles of others.
+func (s *Store) participationsPermission(e participation.EntityRef, before, after []participation.Participation) (Permission, bool) {
+	beforeKeys, afterKeys := participationKeys(before), participationKeys(after)
+	changed := false
+	needed := PermissionParticipate
+	check := func(p participation.Participation, removed bool) {
+		changed = true
+		if p.Entity == nil || p.Entity.EntityRef() != e {
+			needed = PermissionAdminister
+			return
+		}
+		if p.Role == nil {
+			return
+		}
+		role, found := s.authorizer.policy.Roles[p.Role.ParticipationRoleId]
+		switch {
+		case !found:
+		case role.Override:
+			needed = PermissionAdminister
+		case role.Deny:
+			if removed {
+				needed = PermissionAdminister
+			}
+		default:
+			for _, granted := range role.Permissions {
+				if !PermissionParticipate.Implies(granted) {
+					needed = PermissionAdminister
+				}
+			}
+		}
+	}
+	for i, k := range afterKeys {
+		if !contains(beforeKeys, k) {
+			check(after[i], false)
+		}
+	}
+	for i, k := range beforeKeys {
+		if !contains(afterKeys, k) {
+			check(before[i], true)
+		}
+	}
+	return needed, changed
+}
+
+//participationKeys returns the JSON of every participation, to compare them.
+func participationKeys(ps []participation.Participation) []string {
+	out := make([]string, 0, len(ps))
+	for _, p := range ps {
+		bts, _ := json.Marshal(p)
+		out = append(out, string(bts))
+	}
+	return out
+}
+
+//changedLinks returns the refs that are in only one of before and after.
+func changedLinks(before, after []ref.ActivityRef) []ref.ActivityRef {
+	key := func(rs []ref.ActivityRef) []string {
+		out := make([]string, 0, len(rs))
+		for _, r := range rs {
+			out = append(out, r.Id.String())
+		}
+		return out
+	}
+	beforeKeys, afterKeys := key(before), key(after)
+	out := []ref.ActivityRef{}
+	for i, k := range afterKeys {
+		if !contains(beforeKeys, k) {
+			out = append(out, after[i])
+		}
+	}
+	for i, k := range beforeKeys {
+		if !contains(afterKeys, k) {
+			out = append(out, before[i])
+		}
+	}
+	return out
+}
+
+func linkRefs(as []*activity.Activity) []ref.ActivityRef {
+	out := make([]ref.ActivityRef, 0, len(as))
+	for _, a := range as {
+		if a != nil && a.Id != nil {
+			out = append(out, ref.ActivityRef{Id: a.Id})
+		}
+	}
+	return out
+}
+
+func contains(ss []string, s string) bool {
+	for _, candidate := range ss {
+		if candidate == s {
+			return true
+		}
+	}
+	return false
+}
+
+func deniedError(e participation.EntityRef, r ref.ActivityRef, p Permission, reason string) error {
+	return aldberr.New(ErrorCodeDenied, "permission denied: "+reason, map[string]interface{}{
+		"entity":     e.ToName(),
+		"activity":   ref.ActivityRef{Id: r.Id}.ToName(),
+		"permission": string(p),
+	})
+}
+
+func unauthenticated() error {
+	return aldberr.New(ErrorCodeUnauthenticated, "no entity to check the permissions of", nil)
+}
+
+func isDenied(err error) bool {
+	return aldberr.IsCode(err, ErrorCodeDenied)
+}
+
+func isNotFound(err error) bool {
+	return aldberr.IsCode(err, store.ErrorCodeNotFound)
+}
@@ -1,6 +1,8 @@
 package activity
 
 import (
+	"time"
+
 	"github.com/vital-dhaveloose/aldb/activity/attributes"
 	"github.com/vital-dhaveloose/aldb/activity/blob"
 	"github.com/vital-dhaveloose/aldb/activity/participation"
@@ -11,6 +13,9 @@ import (
 
 type Activity struct {
 	ref.ActivityRef
+	//ParentVersions are the versions of the same activity that this version was derived from. The first
+	//version of an activity has none, a merge of diverged versions has several.
+	ParentVersions []string
 	//Label is a localizable name for the activity.
 	Label lang.Localizable
 	//Period during which the activiy is considered "current".
@@ -29,3 +34,13 @@ type Activity struct {
 	//Blob contains the unstructured content of the activity.
 	Blob *blob.Blob
 }
+
+//IsCurrentAt tells whether the activity is current at t, i.e. whether its Period contains t.
+func (a Activity) IsCurrentAt(t time.Time) bool {
+	return a.Period.Contains(t)
+}
+
+//IsCurrentDuring tells whether the activity is current at some time during p.
+func (a Activity) IsCurrentDuring(p datetime.Period) bool {
+	return a.Period.Overlaps(p)
+}
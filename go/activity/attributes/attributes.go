@@ -1,17 +1,159 @@
 package attributes
 
-import "github.com/vital-dhaveloose/aldb/ref"
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
 
 const (
 	AttrSetIdBlob = "blob-attrs"
 )
 
+const (
+	ErrorCodeInvalidAttributeSet = "activity-attributes-invalid-set"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidAttributeSet, Status: http.StatusBadRequest, Message: errcode.En("invalid attribute set")},
+	)
+}
+
 type AttributeSet struct {
 	Manifest *Manifest
-	// Attributes string --> ( nil | string | float64 | bool | map[string]interface{} | []interface{} )
+	// Attributes string --> ( nil | string | int64 | float64 | bool | map[string]interface{} | []interface{} ), see Value
 	Attributes map[string]interface{}
 }
 
+//Manifest describes the attributes of an AttributeSet. The attributes must satisfy the JSON Schema of the
+//manifest, which is either given inline as Schema or published under the ManifestRef in a registry (see
+//package manifest).
 type Manifest struct {
 	ref.ManifestRef
+	//Schema is an inline JSON Schema, which takes precedence over the one in a registry.
+	Schema json.RawMessage
+}
+
+type attributeSetJSON struct {
+	Manifest   *manifestJSON          `json:"manifest,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type manifestJSON struct {
+	Id      string          `json:"id,omitempty"`
+	Version string          `json:"version,omitempty"`
+	Schema  json.RawMessage `json:"schema,omitempty"`
+}
+
+func (as AttributeSet) MarshalJSON() ([]byte, error) {
+	asj := attributeSetJSON{Attributes: as.Attributes}
+	if as.Manifest != nil {
+		mj := as.Manifest.toJSON()
+		asj.Manifest = &mj
+	}
+	return json.Marshal(asj)
+}
+
+func (as *AttributeSet) UnmarshalJSON(bts []byte) error {
+	asj := attributeSetJSON{}
+	if err := json.Unmarshal(bts, &asj); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidAttributeSet, "cannot unmarshal AttributeSet", nil)
+	}
+	out := AttributeSet{Attributes: asj.Attributes}
+	if asj.Manifest != nil {
+		m, err := asj.Manifest.toManifest()
+		if err != nil {
+			return err
+		}
+		out.Manifest = &m
+	}
+	*as = out
+	return nil
+}
+
+//MarshalJSON writes the manifest as an object, not as the name of its (embedded) ManifestRef.
+func (m Manifest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.toJSON())
+}
+
+func (m *Manifest) UnmarshalJSON(bts []byte) error {
+	mj := manifestJSON{}
+	if err := json.Unmarshal(bts, &mj); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidAttributeSet, "cannot unmarshal Manifest", nil)
+	}
+	out, err := mj.toManifest()
+	if err != nil {
+		return err
+	}
+	*m = out
+	return nil
+}
+
+func (m Manifest) toJSON() manifestJSON {
+	mj := manifestJSON{Version: m.Version, Schema: m.Schema}
+	if m.Id != nil {
+		mj.Id = m.Id.String()
+	}
+	return mj
+}
+
+func (mj manifestJSON) toManifest() (Manifest, error) {
+	out := Manifest{ManifestRef: ref.ManifestRef{Version: mj.Version}, Schema: mj.Schema}
+	if len(mj.Id) > 0 {
+		id, err := url.Parse(mj.Id)
+		if err != nil {
+			return Manifest{}, aldberr.Wrap(err, ErrorCodeInvalidAttributeSet, "cannot unmarshal AttributeSet: invalid manifest id", map[string]interface{}{"id": mj.Id})
+		}
+		out.Id = id
+	}
+	return out, nil
+}
+
+//Value returns the attributes as a Value, a map (possibly empty).
+func (as AttributeSet) Value() Value {
+	if as.Attributes == nil {
+		return Val(map[string]interface{}{})
+	}
+	return Val(as.Attributes)
+}
+
+//Get returns the attribute value at the given path, see Value.Get.
+func (as AttributeSet) Get(path Path) (Value, error) {
+	return (&itfWrappingValue{actual: as.Attributes}).Get(path)
+}
+
+//GetAll returns the attribute values at the given path, see Value.GetAll.
+func (as AttributeSet) GetAll(path Path) []Value {
+	return (&itfWrappingValue{actual: as.Attributes}).GetAll(path)
+}
+
+//Set sets the attribute value at the given path, normalised with Val, creating the attributes map if
+//needed. The empty path can't be used, because the attributes must remain a map.
+func (as *AttributeSet) Set(path Path, val interface{}) error {
+	if path.Len() == 0 {
+		return aldberr.New(ErrorCodeInvalidPath, "cannot set the attributes themselves", nil)
+	}
+	if as.Attributes == nil {
+		as.Attributes = map[string]interface{}{}
+	}
+	newVal, err := (&itfWrappingValue{actual: as.Attributes}).Set(path, val)
+	if err != nil {
+		return err
+	}
+	as.Attributes = newVal.CastAny().(map[string]interface{})
+	return nil
+}
+
+//Ref returns the activity that the attribute at the given path refers to, see Value.CastRef.
+func (as AttributeSet) Ref(path Path) (ref.ActivityRef, error) {
+	v, err := as.Get(path)
+	if err != nil {
+		return ref.ActivityRef{}, err
+	}
+	return v.CastRef()
 }
@@ -0,0 +1,453 @@
+package attributes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+func TestParsePath(t *testing.T) {
+	cases := []struct {
+		input string
+		segs  []Segment
+	}{
+		{"", nil},
+		{"totalBudget", []Segment{KeySegment("totalBudget")}},
+		{"totalBudget.amount", []Segment{KeySegment("totalBudget"), KeySegment("amount")}},
+		{"items[3]", []Segment{KeySegment("items"), IndexSegment(3)}},
+		{"items[3].name", []Segment{KeySegment("items"), IndexSegment(3), KeySegment("name")}},
+		{"[0][1]", []Segment{IndexSegment(0), IndexSegment(1)}},
+		{"items[-1]", []Segment{KeySegment("items"), IndexSegment(-1)}},
+		{"items[*].name", []Segment{KeySegment("items"), AnyIndexSegment(), KeySegment("name")}},
+		{`labels["en-GB"]`, []Segment{KeySegment("labels"), KeySegment("en-GB")}},
+		{`["a.b"]["x\"y"]`, []Segment{KeySegment("a.b"), KeySegment(`x"y`)}},
+		{`[""]`, []Segment{KeySegment("")}},
+		{"_a-1.b_2", []Segment{KeySegment("_a-1"), KeySegment("b_2")}},
+	}
+	for _, c := range cases {
+		p, err := ParsePath(c.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", c.input, err)
+			continue
+		}
+		if !p.Equal(PathOf(c.segs...)) {
+			t.Errorf("%q: expected %v, got %v", c.input, c.segs, p.Segments())
+		}
+		if p.String() != c.input && c.input != `labels["en-GB"]` {
+			t.Errorf("%q: printed as %q", c.input, p.String())
+		}
+		if reparsed := MustParsePath(p.String()); !reparsed.Equal(p) {
+			t.Errorf("%q: doesn't round trip via %q", c.input, p.String())
+		}
+	}
+	if p := PathOf(KeySegment("labels"), KeySegment("en-GB")); p.String() != "labels.en-GB" {
+		t.Errorf("expected labels.en-GB, got %s", p)
+	}
+
+	errorCases := map[string]int{
+		".a":           0,
+		"a.":           2,
+		"a..b":         2,
+		"1a":           0,
+		"a[":           1,
+		"a[x]":         1,
+		"a[1":          3,
+		"a[-]":         1,
+		"a[*":          3,
+		`a["b`:         1,
+		`a["b"`:        5,
+		"items[3]x":    8,
+		"a b":          0,
+		"a.b c":        2,
+		"a.b[0]]":      6,
+		"totalBudget.": 12,
+	}
+	for input, pos := range errorCases {
+		_, err := ParsePath(input)
+		cErr := expectCode(t, err, ErrorCodeInvalidPath)
+		if cErr.Details()["position"] != pos {
+			t.Errorf("%q: expected error at %d, got %v", input, pos, cErr.Details()["position"])
+		}
+	}
+}
+
+func TestPathOrder(t *testing.T) {
+	ordered := []string{"", "[*]", "[-1]", "[0]", "[2]", "[10]", "a", "a[1]", "a.b", "b"}
+	for i := range ordered {
+		for j := range ordered {
+			a, b := MustParsePath(ordered[i]), MustParsePath(ordered[j])
+			if a.Less(b) != (i < j) {
+				t.Errorf("expected %q < %q to be %v", ordered[i], ordered[j], i < j)
+			}
+		}
+	}
+	p := MustParsePath("a")
+	q := p.Append(KeySegment("b"))
+	p.Append(KeySegment("c"))
+	if p.String() != "a" || q.String() != "a.b" {
+		t.Errorf("Append changed its receiver: %s, %s", p, q)
+	}
+	head, tail, ok := q.HeadTail()
+	if !ok || head.Key() != "a" || tail.String() != "b" {
+		t.Errorf("unexpected HeadTail %v %v %v", head, tail, ok)
+	}
+	if _, _, ok := EmptyPath().HeadTail(); ok {
+		t.Error("empty path should have no head")
+	}
+}
+
+func TestNormalizeYAML(t *testing.T) {
+	//as decoded by YAML libraries that use map[interface{}]interface{} for mappings
+	input := map[interface{}]interface{}{
+		"name":  "Project X",
+		"count": 3,
+		"ratio": float32(0.5),
+		"tags":  []interface{}{"a", map[interface{}]interface{}{"nested": true}},
+		"byNumber": map[interface{}]interface{}{
+			1:    "one",
+			true: "yes",
+			2.5:  []interface{}{map[interface{}]interface{}{uint8(7): nil}},
+		},
+		"deep": map[interface{}]interface{}{
+			"deeper": map[interface{}]interface{}{
+				"deepest": []interface{}{[]interface{}{map[interface{}]interface{}{"x": int32(-1)}}},
+			},
+		},
+		"strings": []string{"p", "q"},
+		"typed":   map[string]int{"a": 1},
+		"when":    time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC),
+		"null":    nil,
+	}
+	expected := map[string]interface{}{
+		"name":  "Project X",
+		"count": int64(3),
+		"ratio": 0.5,
+		"tags":  []interface{}{"a", map[string]interface{}{"nested": true}},
+		"byNumber": map[string]interface{}{
+			"1":    "one",
+			"true": "yes",
+			"2.5":  []interface{}{map[string]interface{}{"7": nil}},
+		},
+		"deep": map[string]interface{}{
+			"deeper": map[string]interface{}{
+				"deepest": []interface{}{[]interface{}{map[string]interface{}{"x": int64(-1)}}},
+			},
+		},
+		"strings": []interface{}{"p", "q"},
+		"typed":   map[string]interface{}{"a": int64(1)},
+		"when":    "2020-02-29T12:00:00Z",
+		"null":    nil,
+	}
+	v := Val(input)
+	if !reflect.DeepEqual(v.CastAny(), expected) {
+		t.Fatalf("unexpected normalisation\nexpected: %#v\nactual:   %#v", expected, v.CastAny())
+	}
+	if _, err := json.Marshal(v.CastAny()); err != nil {
+		t.Errorf("normalised value should be marshallable: %v", err)
+	}
+
+	x, err := v.Get(MustParsePath("deep.deeper.deepest[0][0].x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, err := x.CastInt64(); err != nil || i != -1 {
+		t.Errorf("expected -1, got %v %v", i, err)
+	}
+	if s, err := mustGet(t, v, `byNumber["1"]`).CastString(); err != nil || s != "one" {
+		t.Errorf("expected one, got %v %v", s, err)
+	}
+	if !mustGet(t, v, `byNumber["2.5"][0]["7"]`).IsNull() {
+		t.Error("expected null")
+	}
+	when, err := mustGet(t, v, "when").CastTime()
+	if err != nil || !when.Equal(time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected time %v %v", when, err)
+	}
+
+	//normalising doesn't change the input
+	if _, isMap := input["tags"].([]interface{})[1].(map[interface{}]interface{}); !isMap {
+		t.Error("input was changed")
+	}
+	//normalising is idempotent
+	if !reflect.DeepEqual(Val(v).CastAny(), expected) {
+		t.Error("normalising a normalised value changed it")
+	}
+}
+
+func TestNormalizeNumbers(t *testing.T) {
+	cases := []struct {
+		input    interface{}
+		expected interface{}
+	}{
+		{int8(-8), int64(-8)},
+		{uint16(16), int64(16)},
+		{uint64(1 << 63), float64(1 << 63)},
+		{json.Number("42"), int64(42)},
+		{json.Number("4.2"), 4.2},
+		{json.Number("1e3"), 1000.0},
+		{float32(1.25), 1.25},
+	}
+	for _, c := range cases {
+		if actual := Val(c.input).CastAny(); actual != c.expected {
+			t.Errorf("%#v: expected %#v, got %#v", c.input, c.expected, actual)
+		}
+	}
+}
+
+func TestCasts(t *testing.T) {
+	if i, err := Val(3.0).CastInt64(); err != nil || i != 3 {
+		t.Errorf("expected integral float to cast to int64, got %v %v", i, err)
+	}
+	if f, err := Val(3).CastFloat64(); err != nil || f != 3 {
+		t.Errorf("expected int to cast to float64, got %v %v", f, err)
+	}
+	_, err := Val(3.5).CastInt64()
+	expectCode(t, err, ErrorCodeWrongType)
+	_, err = Val(nil).CastString()
+	expectCode(t, err, ErrorCodeNullCast)
+	_, err = Val("x").CastBool()
+	expectCode(t, err, ErrorCodeWrongType)
+	_, err = Val("yesterday").CastTime()
+	expectCode(t, err, ErrorCodeWrongType)
+	_, err = Val([]interface{}{}).CastMap()
+	expectCode(t, err, ErrorCodeWrongType)
+	if slc, err := Val([]int{1}).CastSlice(); err != nil || len(slc) != 1 {
+		t.Errorf("unexpected slice %v %v", slc, err)
+	}
+}
+
+func TestRefs(t *testing.T) {
+	id, _ := url.Parse("https://doe.eu/activities/42")
+	r := ref.ActivityRef{Id: id, Version: "7"}
+
+	as := AttributeSet{}
+	if err := as.Set(MustParsePath("dependsOn[0]"), r); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.Set(MustParsePath("dependsOn[1]"), ref.ActivityRef{Id: id}); err != nil {
+		t.Fatal(err)
+	}
+	bts, err := json.Marshal(as)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bts) != `{"attributes":{"dependsOn":[{"@activity":"https://doe.eu/activities/42","@version":"7"},{"@activity":"https://doe.eu/activities/42"}]}}` {
+		t.Errorf("unexpected JSON %s", bts)
+	}
+
+	unmarshalled := AttributeSet{}
+	if err := json.Unmarshal(bts, &unmarshalled); err != nil {
+		t.Fatal(err)
+	}
+	first, err := unmarshalled.Ref(MustParsePath("dependsOn[0]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Id.String() != id.String() || first.Version != "7" {
+		t.Errorf("unexpected ref %v", first)
+	}
+	latest, err := unmarshalled.Ref(MustParsePath("dependsOn[1]"))
+	if err != nil || latest.Version != "" {
+		t.Errorf("expected ref to latest version, got %v %v", latest, err)
+	}
+
+	for _, notARef := range []interface{}{
+		"https://doe.eu/activities/42",
+		map[string]interface{}{"@version": "7"},
+		map[string]interface{}{"@activity": 42},
+		map[string]interface{}{"@activity": "https://doe.eu/activities/42", "@version": 7},
+		map[string]interface{}{"@activity": "https://doe.eu/activities/42", "other": true},
+	} {
+		_, err := Val(notARef).CastRef()
+		expectCode(t, err, ErrorCodeWrongType)
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	as := AttributeSet{Attributes: map[string]interface{}{
+		"totalBudget": map[string]interface{}{"currency": "EUR", "amount": 456000.0},
+		"items":       []interface{}{"a", "b"},
+	}}
+	amount, err := as.Get(MustParsePath("totalBudget.amount"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, err := amount.CastInt64(); err != nil || i != 456000 {
+		t.Errorf("unexpected amount %v %v", i, err)
+	}
+	if last, err := as.Get(MustParsePath("items[-1]")); err != nil || last.CastAny() != "b" {
+		t.Errorf("expected the last item, got %v %v", last, err)
+	}
+	_, err = as.Get(MustParsePath("items[*]"))
+	expectCode(t, err, ErrorCodeInvalidPath)
+	root, err := as.Get(EmptyPath())
+	if err != nil || !reflect.DeepEqual(root.CastAny(), as.Attributes) {
+		t.Errorf("empty path should refer to the attributes, got %v %v", root, err)
+	}
+
+	for path, missing := range map[string]string{
+		"nope":                 "nope",
+		"totalBudget.nope.x":   "totalBudget.nope",
+		"items[2]":             "items[2]",
+		"items[-3]":            "items[-3]",
+		"items.a":              "items.a",
+		"totalBudget[0]":       "totalBudget[0]",
+		"totalBudget.amount.x": "totalBudget.amount.x",
+	} {
+		_, err := as.Get(MustParsePath(path))
+		cErr := expectCode(t, err, ErrorCodePathNotFound)
+		if cErr.Details()["missing"] != missing {
+			t.Errorf("%s: expected %s to be missing, got %v", path, missing, cErr.Details()["missing"])
+		}
+	}
+
+	all := as.GetAll(MustParsePath("items[*]"))
+	if len(all) != 2 || all[0].CastAny() != "a" || all[1].CastAny() != "b" {
+		t.Errorf("expected every item, got %v", all)
+	}
+	if missing := as.GetAll(MustParsePath("totalBudget[*]")); len(missing) != 0 {
+		t.Errorf("expected no values for a map, got %v", missing)
+	}
+
+	if err := as.Set(MustParsePath("items[3]"), "d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.Set(MustParsePath("totalBudget.amount"), 500000); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.Set(MustParsePath("new.list[1].name"), "x"); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"totalBudget": map[string]interface{}{"currency": "EUR", "amount": int64(500000)},
+		"items":       []interface{}{"a", "b", nil, "d"},
+		"new":         map[string]interface{}{"list": []interface{}{nil, map[string]interface{}{"name": "x"}}},
+	}
+	if !reflect.DeepEqual(as.Attributes, expected) {
+		t.Errorf("unexpected attributes after Set %#v", as.Attributes)
+	}
+
+	expectCode(t, as.Set(MustParsePath("items.a"), 1), ErrorCodeWrongType)
+	expectCode(t, as.Set(MustParsePath("totalBudget[0]"), 1), ErrorCodeWrongType)
+	expectCode(t, as.Set(PathOf(KeySegment("items"), IndexSegment(-1)), 1), ErrorCodeWrongType)
+	expectCode(t, as.Set(MustParsePath("items[*]"), 1), ErrorCodeInvalidPath)
+	expectCode(t, as.Set(EmptyPath(), 1), ErrorCodeInvalidPath)
+}
+
+func TestConstruct(t *testing.T) {
+	v, err := Construct([]Path{MustParsePath("a.b"), MustParsePath("a.c[1]"), MustParsePath("d")}, []interface{}{1, true, map[interface{}]interface{}{"e": "f"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"a": map[string]interface{}{"b": int64(1), "c": []interface{}{nil, true}},
+		"d": map[string]interface{}{"e": "f"},
+	}
+	if !reflect.DeepEqual(v.CastAny(), expected) {
+		t.Errorf("unexpected constructed value %#v", v.CastAny())
+	}
+	if v, err := Construct([]Path{EmptyPath()}, []interface{}{"x"}); err != nil || v.CastAny() != "x" {
+		t.Errorf("expected root to be set, got %v %v", v, err)
+	}
+	_, err = Construct([]Path{EmptyPath()}, nil)
+	expectCode(t, err, ErrorCodeInvalidValue)
+}
+
+func TestTraverseDepthFirst(t *testing.T) {
+	root := Val(map[string]interface{}{
+		"b": []interface{}{1, 2, 3},
+		"a": map[string]interface{}{"y": "Y", "x": "X"},
+		"c": "C",
+	})
+	visited := []string{}
+	record := func(_ Value, p Path, val Value) (Value, error) {
+		visited = append(visited, p.String())
+		return val, nil
+	}
+	if _, err := TraverseDepthFirst(root, record); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(visited, " ") != " a a.x a.y b b[0] b[1] b[2] c" {
+		t.Errorf("unexpected order %q", visited)
+	}
+
+	visited = nil
+	_, err := TraverseDepthFirst(root, func(r Value, p Path, val Value) (Value, error) {
+		record(r, p, val)
+		switch p.String() {
+		case "a.x", "b[1]":
+			return val, ErrorBreak
+		case "c":
+			return val, ErrorStop
+		}
+		return val, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(visited, " ") != " a a.x b b[0] b[1] c" {
+		t.Errorf("unexpected order with break %q", visited)
+	}
+
+	visited = nil
+	if _, err := TraverseDepthFirst(root, func(r Value, p Path, val Value) (Value, error) {
+		record(r, p, val)
+		if p.String() == "a" {
+			return val, ErrorStop
+		}
+		return val, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(visited, " ") != " a" {
+		t.Errorf("unexpected order with stop %q", visited)
+	}
+
+	doubled, err := TraverseDepthFirst(root, func(_ Value, _ Path, val Value) (Value, error) {
+		if i, err := val.CastInt64(); err == nil {
+			return Val(i * 2), nil
+		}
+		return val, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := mustGet(t, doubled, "b").CastAny(); !reflect.DeepEqual(b, []interface{}{int64(2), int64(4), int64(6)}) {
+		t.Errorf("unexpected manipulated value %v", b)
+	}
+
+	boom := errors.New("boom")
+	if _, err := TraverseDepthFirst(root, func(_ Value, p Path, val Value) (Value, error) {
+		if p.String() == "a.y" {
+			return nil, boom
+		}
+		return val, nil
+	}); !errors.Is(err, boom) {
+		t.Errorf("expected error to be returned, got %v", err)
+	}
+}
+
+func mustGet(t *testing.T, v Value, path string) Value {
+	t.Helper()
+	out, err := v.Get(MustParsePath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func expectCode(t *testing.T, err error, code string) aldberr.CanvigaError {
+	t.Helper()
+	cErr := aldberr.CanvigaError{}
+	if !errors.As(err, &cErr) || cErr.Code() != code {
+		t.Fatalf("expected error %s, got %v", code, err)
+	}
+	return cErr
+}
@@ -0,0 +1,279 @@
+package attributes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	ErrorCodeInvalidPath = "activity-attributes-invalid-path"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidPath, Status: http.StatusBadRequest, Message: errcode.En("invalid attribute path")},
+	)
+}
+
+//Segment is a step in a Path: either the key of a map entry or the index of an array item, or every item of
+//an array (see AnyIndexSegment).
+type Segment struct {
+	key     string
+	index   int
+	isIndex bool
+	isAny   bool
+}
+
+func KeySegment(key string) Segment {
+	return Segment{key: key}
+}
+
+//IndexSegment returns the segment of the array item at idx. A negative index counts from the end of the
+//array (-1 being the last item), which is only supported for reading.
+func IndexSegment(idx int) Segment {
+	return Segment{index: idx, isIndex: true}
+}
+
+//AnyIndexSegment returns the segment that refers to every item of an array, written "[*]". Paths with it
+//refer to several values, see AttributeSet.GetAll.
+func AnyIndexSegment() Segment {
+	return Segment{isAny: true}
+}
+
+//Key returns the key of a key segment, or "" for an index segment.
+func (s Segment) Key() string {
+	return s.key
+}
+
+//Index returns the index of an index segment, and whether it is one.
+func (s Segment) Index() (int, bool) {
+	return s.index, s.isIndex
+}
+
+//IsAnyIndex tells whether s is the AnyIndexSegment.
+func (s Segment) IsAnyIndex() bool {
+	return s.isAny
+}
+
+func (s Segment) String() string {
+	if s.isAny {
+		return "[*]"
+	}
+	if s.isIndex {
+		return "[" + strconv.Itoa(s.index) + "]"
+	}
+	if isIdentifier(s.key) {
+		return s.key
+	}
+	return "[" + strconv.Quote(s.key) + "]"
+}
+
+//Path is the location of a value within the attributes of an AttributeSet, relative to the root map. Paths
+//are immutable values, the zero Path is the empty path that refers to the root.
+//
+//Its string representation joins keys with dots and writes indexes in brackets, e.g.
+//"totalBudget.amount", "items[3].name", "items[-1]" or "items[*].name". Keys that are not identifiers (letters, digits, "_" and "-", not
+//starting with a digit or "-") are written as quoted strings in brackets, e.g. `labels["en-GB"]` or
+//`["a.b"]`.
+type Path struct {
+	segments []Segment
+}
+
+//EmptyPath returns the path that refers to the root value.
+func EmptyPath() Path {
+	return Path{}
+}
+
+//PathOf returns the path consisting of the given segments.
+func PathOf(segs ...Segment) Path {
+	return Path{segments: append([]Segment{}, segs...)}
+}
+
+//ParsePath parses the string representation of a Path, "" being the empty path.
+func ParsePath(s string) (Path, error) {
+	segs := []Segment{}
+	pos := 0
+	errorAt := func(msg string, args ...interface{}) error {
+		return aldberr.New(ErrorCodeInvalidPath, fmt.Sprintf(msg, args...)+fmt.Sprintf(" at position %d", pos), map[string]interface{}{"path": s, "position": pos})
+	}
+	parseKey := func() (string, error) {
+		end := pos
+		for end < len(s) && s[end] != '.' && s[end] != '[' {
+			end++
+		}
+		if !isIdentifier(s[pos:end]) {
+			return "", errorAt("expected a key")
+		}
+		key := s[pos:end]
+		pos = end
+		return key, nil
+	}
+	for pos < len(s) {
+		switch {
+		case s[pos] == '[':
+			end := pos + 1
+			if end < len(s) && s[end] == '"' {
+				end++
+				for end < len(s) && s[end] != '"' {
+					if s[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(s) {
+					return Path{}, errorAt("unterminated key")
+				}
+				key, err := strconv.Unquote(s[pos+1 : end+1])
+				if err != nil {
+					return Path{}, errorAt("invalid key")
+				}
+				segs = append(segs, KeySegment(key))
+				end++
+			} else if end < len(s) && s[end] == '*' {
+				segs = append(segs, AnyIndexSegment())
+				end++
+			} else {
+				if end < len(s) && s[end] == '-' {
+					end++
+				}
+				for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+					end++
+				}
+				idx, err := strconv.Atoi(s[pos+1 : end])
+				if err != nil {
+					return Path{}, errorAt("expected an index, '*' or a quoted key")
+				}
+				segs = append(segs, IndexSegment(idx))
+			}
+			if end >= len(s) || s[end] != ']' {
+				pos = end
+				return Path{}, errorAt("expected ']'")
+			}
+			pos = end + 1
+		case s[pos] == '.' && len(segs) > 0:
+			pos++
+			key, err := parseKey()
+			if err != nil {
+				return Path{}, err
+			}
+			segs = append(segs, KeySegment(key))
+		case len(segs) > 0:
+			return Path{}, errorAt("expected '.' or '['")
+		default:
+			key, err := parseKey()
+			if err != nil {
+				return Path{}, err
+			}
+			segs = append(segs, KeySegment(key))
+		}
+	}
+	return Path{segments: segs}, nil
+}
+
+//MustParsePath is ParsePath that panics on errors, for paths that are known to be valid.
+func MustParsePath(s string) Path {
+	p, err := ParsePath(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p Path) Len() int {
+	return len(p.segments)
+}
+
+//HeadTail returns the first segment and the rest of the path. ok is false for the empty path.
+func (p Path) HeadTail() (head Segment, tail Path, ok bool) {
+	if len(p.segments) == 0 {
+		return Segment{}, Path{}, false
+	}
+	return p.segments[0], Path{segments: p.segments[1:]}, true
+}
+
+//Append returns a new path with the segments appended, p is not changed.
+func (p Path) Append(segs ...Segment) Path {
+	out := make([]Segment, 0, len(p.segments)+len(segs))
+	out = append(out, p.segments...)
+	return Path{segments: append(out, segs...)}
+}
+
+//HasAnyIndex tells whether the path has an AnyIndexSegment, so that it can refer to several values.
+func (p Path) HasAnyIndex() bool {
+	for _, s := range p.segments {
+		if s.isAny {
+			return true
+		}
+	}
+	return false
+}
+
+//Segments returns a copy of the segments.
+func (p Path) Segments() []Segment {
+	return append([]Segment{}, p.segments...)
+}
+
+func (p Path) Equal(other Path) bool {
+	if len(p.segments) != len(other.segments) {
+		return false
+	}
+	for i := range p.segments {
+		if p.segments[i] != other.segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//Less orders paths segment by segment, index segments before key segments (the AnyIndexSegment before the
+//other ones), and a path before the paths that it is a prefix of.
+func (p Path) Less(other Path) bool {
+	for i := 0; i < len(p.segments) && i < len(other.segments); i++ {
+		a, b := p.segments[i], other.segments[i]
+		if a == b {
+			continue
+		}
+		if a.isAny || b.isAny {
+			return a.isAny
+		}
+		if a.isIndex != b.isIndex {
+			return a.isIndex
+		}
+		if a.isIndex {
+			return a.index < b.index
+		}
+		return a.key < b.key
+	}
+	return len(p.segments) < len(other.segments)
+}
+
+func (p Path) String() string {
+	sb := strings.Builder{}
+	for i, s := range p.segments {
+		if i > 0 && !s.isIndex && isIdentifier(s.key) {
+			sb.WriteString(".")
+		}
+		sb.WriteString(s.String())
+	}
+	return sb.String()
+}
+
+func isIdentifier(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r == '-' || (r >= '0' && r <= '9')):
+		default:
+			return false
+		}
+	}
+	return true
+}
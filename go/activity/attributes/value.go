@@ -0,0 +1,526 @@
+package attributes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+const (
+	ErrorCodeNullCast     = "activity-attributes-null-cast"
+	ErrorCodeWrongType    = "activity-attributes-wrong-type"
+	ErrorCodePathNotFound = "activity-attributes-path-not-found"
+	ErrorCodeInvalidValue = "activity-attributes-invalid-value"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeNullCast, Status: http.StatusBadRequest, Message: errcode.En("the attribute value is null")},
+		errcode.Code{Code: ErrorCodeWrongType, Status: http.StatusBadRequest, Message: errcode.En("the attribute value has the wrong type")},
+		errcode.Code{Code: ErrorCodePathNotFound, Status: http.StatusBadRequest, Message: errcode.En("no attribute value at path {path}")},
+		errcode.Code{Code: ErrorCodeInvalidValue, Status: http.StatusBadRequest, Message: errcode.En("invalid attribute value")},
+	)
+}
+
+const (
+	//RefKeyActivity and RefKeyVersion are the keys of the object that represents a reference to (a version
+	//of) another activity in attributes, e.g. {"@activity": "https://doe.eu/activities/42", "@version": "7"}.
+	RefKeyActivity = "@activity"
+	RefKeyVersion  = "@version"
+)
+
+//Value is a read-only view on an attribute value, which is nil (null), a bool, an int64, a float64, a
+//string, a []interface{} or a map[string]interface{} of such values (see Val).
+type Value interface {
+	//IsNull returns whether the Value represents null.
+	IsNull() bool
+
+	//CastBool, CastInt64, CastFloat64, CastString, CastSlice and CastMap return the value as the given
+	//type. Numbers are cast to the other numeric type if that doesn't lose information. The errors have code
+	//ErrorCodeNullCast for null values and ErrorCodeWrongType for values of another type.
+	CastBool() (bool, error)
+	CastInt64() (int64, error)
+	CastFloat64() (float64, error)
+	CastString() (string, error)
+	//CastTime parses a string in RFC 3339 format.
+	CastTime() (time.Time, error)
+	CastSlice() ([]interface{}, error)
+	CastMap() (map[string]interface{}, error)
+	//CastRef returns the activity that a reference object (see RefKeyActivity) refers to.
+	CastRef() (ref.ActivityRef, error)
+
+	//CastAny returns the underlying value.
+	CastAny() interface{}
+
+	//Get returns the value at the given path relative to this value, or an error with code
+	//ErrorCodePathNotFound if there is none. The path can't have an AnyIndexSegment, see GetAll.
+	Get(path Path) (Value, error)
+
+	//GetAll returns the values at the given path relative to this value, in order, an AnyIndexSegment
+	//referring to every item of an array. Values that are missing are left out.
+	GetAll(path Path) []Value
+
+	//Set returns the value with the value at the given path replaced by val, which is normalised with Val.
+	//Missing maps and array items along the path are created, arrays are extended with nulls. The path can't
+	//have negative indexes or an AnyIndexSegment. The underlying maps and slices of this value may be changed, so don't keep using this value afterwards.
+	Set(path Path, val interface{}) (Value, error)
+}
+
+//Val wraps a value, normalising it to the types listed at Value: integers become int64, other numbers
+//float64, time.Time an RFC 3339 string, ref.ActivityRef a reference object and maps with keys of any type
+//(e.g. map[interface{}]interface{} as produced by YAML decoders) map[string]interface{} with the keys
+//formatted by fmt.Sprint. Other slices and maps are converted recursively. Values that can't be
+//normalised (e.g. structs or functions) are kept as they are.
+func Val(itf interface{}) Value {
+	return &itfWrappingValue{actual: Normalize(itf)}
+}
+
+//Normalize returns the normalised form of a value, see Val.
+func Normalize(itf interface{}) interface{} {
+	switch c := itf.(type) {
+	case nil, bool, int64, float64, string:
+		return c
+	case []interface{}:
+		res := make([]interface{}, len(c))
+		for i := range c {
+			res[i] = Normalize(c[i])
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(c))
+		for k := range c {
+			res[k] = Normalize(c[k])
+		}
+		return res
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(c))
+		for k := range c {
+			res[fmt.Sprint(k)] = Normalize(c[k])
+		}
+		return res
+	case int:
+		return int64(c)
+	case int8:
+		return int64(c)
+	case int16:
+		return int64(c)
+	case int32:
+		return int64(c)
+	case uint8:
+		return int64(c)
+	case uint16:
+		return int64(c)
+	case uint32:
+		return int64(c)
+	case uint:
+		if uint64(c) > math.MaxInt64 {
+			return float64(c)
+		}
+		return int64(c)
+	case uint64:
+		if c > math.MaxInt64 {
+			return float64(c)
+		}
+		return int64(c)
+	case float32:
+		return float64(c)
+	case json.Number:
+		if i, err := c.Int64(); err == nil {
+			return i
+		}
+		if f, err := c.Float64(); err == nil {
+			return f
+		}
+		return c.String()
+	case time.Time:
+		return c.Format(time.RFC3339Nano)
+	case ref.ActivityRef:
+		return RefVal(c)
+	case *ref.ActivityRef:
+		if c == nil {
+			return nil
+		}
+		return RefVal(*c)
+	case Value:
+		return Normalize(c.CastAny())
+	}
+
+	rv := reflect.ValueOf(itf)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return Normalize(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		res := make([]interface{}, rv.Len())
+		for i := range res {
+			res[i] = Normalize(rv.Index(i).Interface())
+		}
+		return res
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+		res := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			res[fmt.Sprint(iter.Key().Interface())] = Normalize(iter.Value().Interface())
+		}
+		return res
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	return itf
+}
+
+//RefVal returns the reference object that refers to r, to be used as attribute value.
+func RefVal(r ref.ActivityRef) map[string]interface{} {
+	out := map[string]interface{}{}
+	if r.Id != nil {
+		out[RefKeyActivity] = r.Id.String()
+	}
+	if len(r.Version) > 0 {
+		out[RefKeyVersion] = r.Version
+	}
+	return out
+}
+
+//Construct builds a value by setting every path to the value at the same index in vals.
+func Construct(paths []Path, vals []interface{}) (Value, error) {
+	if len(paths) != len(vals) {
+		return nil, aldberr.New(ErrorCodeInvalidValue, "number of paths and values differ", map[string]interface{}{"paths": len(paths), "values": len(vals)})
+	}
+	var out Value = &itfWrappingValue{}
+	for i, pth := range paths {
+		var err error
+		if out, err = out.Set(pth, vals[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+type itfWrappingValue struct {
+	actual interface{}
+}
+
+func (v *itfWrappingValue) IsNull() bool {
+	return v.actual == nil
+}
+
+//region Cast*
+
+func (v *itfWrappingValue) castError(expected string) error {
+	if v.actual == nil {
+		return aldberr.New(ErrorCodeNullCast, "cannot cast null to "+expected, nil)
+	}
+	return aldberr.New(ErrorCodeWrongType, fmt.Sprintf("cannot cast %T to %s", v.actual, expected), map[string]interface{}{"type": fmt.Sprintf("%T", v.actual)})
+}
+
+func (v *itfWrappingValue) CastBool() (bool, error) {
+	b, castOk := v.actual.(bool)
+	if !castOk {
+		return false, v.castError("bool")
+	}
+	return b, nil
+}
+
+func (v *itfWrappingValue) CastInt64() (int64, error) {
+	switch n := v.actual.(type) {
+	case int64:
+		return n, nil
+	case float64:
+		if n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+			return int64(n), nil
+		}
+	}
+	return 0, v.castError("int64")
+}
+
+func (v *itfWrappingValue) CastFloat64() (float64, error) {
+	switch n := v.actual.(type) {
+	case float64:
+		return n, nil
+	case int64:
+		if f := float64(n); int64(f) == n {
+			return f, nil
+		}
+	}
+	return 0, v.castError("float64")
+}
+
+func (v *itfWrappingValue) CastString() (string, error) {
+	str, castOk := v.actual.(string)
+	if !castOk {
+		return "", v.castError("string")
+	}
+	return str, nil
+}
+
+func (v *itfWrappingValue) CastTime() (time.Time, error) {
+	str, castOk := v.actual.(string)
+	if !castOk {
+		return time.Time{}, v.castError("time")
+	}
+	t, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return time.Time{}, aldberr.Wrap(err, ErrorCodeWrongType, "cannot cast string to time", map[string]interface{}{"value": str})
+	}
+	return t, nil
+}
+
+func (v *itfWrappingValue) CastSlice() ([]interface{}, error) {
+	slc, castOk := v.actual.([]interface{})
+	if !castOk {
+		return nil, v.castError("slice")
+	}
+	return slc, nil
+}
+
+func (v *itfWrappingValue) CastMap() (map[string]interface{}, error) {
+	m, castOk := v.actual.(map[string]interface{})
+	if !castOk {
+		return nil, v.castError("map")
+	}
+	return m, nil
+}
+
+func (v *itfWrappingValue) CastRef() (ref.ActivityRef, error) {
+	m, castOk := v.actual.(map[string]interface{})
+	if !castOk {
+		return ref.ActivityRef{}, v.castError("ref")
+	}
+	rawId, isString := m[RefKeyActivity].(string)
+	if !isString {
+		return ref.ActivityRef{}, aldberr.New(ErrorCodeWrongType, "cannot cast map without "+RefKeyActivity+" to ref", nil)
+	}
+	for k := range m {
+		if k != RefKeyActivity && k != RefKeyVersion {
+			return ref.ActivityRef{}, aldberr.New(ErrorCodeWrongType, "cannot cast map with other keys than "+RefKeyActivity+" and "+RefKeyVersion+" to ref", map[string]interface{}{"key": k})
+		}
+	}
+	id, err := url.Parse(rawId)
+	if err != nil {
+		return ref.ActivityRef{}, aldberr.Wrap(err, ErrorCodeWrongType, "cannot cast map with invalid "+RefKeyActivity+" to ref", map[string]interface{}{"id": rawId})
+	}
+	out := ref.ActivityRef{Id: id}
+	if version, found := m[RefKeyVersion]; found {
+		if out.Version, isString = version.(string); !isString {
+			return ref.ActivityRef{}, aldberr.New(ErrorCodeWrongType, "cannot cast map with non-string "+RefKeyVersion+" to ref", nil)
+		}
+	}
+	return out, nil
+}
+
+func (v *itfWrappingValue) CastAny() interface{} {
+	return v.actual
+}
+
+//endregion
+
+func (v *itfWrappingValue) Get(path Path) (Value, error) {
+	if path.HasAnyIndex() {
+		return nil, aldberr.New(ErrorCodeInvalidPath, "path refers to several values", map[string]interface{}{"path": path.String()})
+	}
+	cur := v.actual
+	for i, seg := range path.segments {
+		found := false
+		if cur, found = child(cur, seg); !found {
+			return nil, aldberr.New(ErrorCodePathNotFound, "no value at path", map[string]interface{}{"path": path.String(), "missing": PathOf(path.segments[:i+1]...).String()})
+		}
+	}
+	return &itfWrappingValue{actual: cur}, nil
+}
+
+func (v *itfWrappingValue) GetAll(path Path) []Value {
+	return getAll(v.actual, path.segments, []Value{})
+}
+
+func getAll(cur interface{}, segs []Segment, out []Value) []Value {
+	if len(segs) == 0 {
+		return append(out, &itfWrappingValue{actual: cur})
+	}
+	if segs[0].IsAnyIndex() {
+		slc, _ := cur.([]interface{})
+		for _, item := range slc {
+			out = getAll(item, segs[1:], out)
+		}
+		return out
+	}
+	if next, found := child(cur, segs[0]); found {
+		return getAll(next, segs[1:], out)
+	}
+	return out
+}
+
+//child returns the map entry or array item of cur that seg refers to, a negative index counting from the end.
+func child(cur interface{}, seg Segment) (interface{}, bool) {
+	if idx, isIdx := seg.Index(); isIdx {
+		slc, isSlice := cur.([]interface{})
+		if idx < 0 {
+			idx += len(slc)
+		}
+		if !isSlice || idx < 0 || idx >= len(slc) {
+			return nil, false
+		}
+		return slc[idx], true
+	}
+	m, isMap := cur.(map[string]interface{})
+	if !isMap {
+		return nil, false
+	}
+	out, found := m[seg.Key()]
+	return out, found
+}
+
+func (v *itfWrappingValue) Set(path Path, val interface{}) (Value, error) {
+	if path.HasAnyIndex() {
+		return nil, aldberr.New(ErrorCodeInvalidPath, "cannot set a path that refers to several values", map[string]interface{}{"path": path.String()})
+	}
+	newVal, err := set(v.actual, path, Normalize(val))
+	if err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeWrongType, "cannot set value at path: "+err.Error(), map[string]interface{}{"path": path.String()})
+	}
+	return &itfWrappingValue{actual: newVal}, nil
+}
+
+func set(cur interface{}, path Path, val interface{}) (interface{}, error) {
+	head, tail, ok := path.HeadTail()
+	if !ok {
+		return val, nil
+	}
+	if idx, isIdx := head.Index(); isIdx {
+		if idx < 0 {
+			return nil, errors.New("negative index")
+		}
+		slc, isSlice := cur.([]interface{})
+		if !isSlice && cur != nil {
+			return nil, fmt.Errorf("%T is not an array", cur)
+		}
+		if len(slc) < idx+1 {
+			old := slc
+			slc = make([]interface{}, idx+1)
+			copy(slc, old)
+		}
+		item, err := set(slc[idx], tail, val)
+		if err != nil {
+			return nil, err
+		}
+		slc[idx] = item
+		return slc, nil
+	}
+	m, isMap := cur.(map[string]interface{})
+	if !isMap {
+		if cur != nil {
+			return nil, fmt.Errorf("%T is not a map", cur)
+		}
+		m = map[string]interface{}{}
+	}
+	entry, err := set(m[head.Key()], tail, val)
+	if err != nil {
+		return nil, err
+	}
+	m[head.Key()] = entry
+	return m, nil
+}
+
+//region Traverse
+
+var (
+	//ErrorBreak stops the traversal of the array or map that contains the visited value.
+	ErrorBreak = errors.New("break")
+	//ErrorStop stops the traversal completely.
+	ErrorStop = errors.New("stop")
+)
+
+//VisitFunc is called for every value in a traversal. The return value must be the new value for the
+//current location in the structure, return val to only inspect (not manipulate) the structure. Return
+//(val, ErrorStop) to stop the traversal completely, or (val, ErrorBreak) to stop the traversal of the
+//array or map that contains val.
+type VisitFunc func(root Value, pathFromRoot Path, val Value) (newVal Value, err error)
+
+//TraverseDepthFirst visits root and the values in it, depth first and in Path order (array items by index,
+//map entries by key). The children of a value are visited after the value itself, so those of the value
+//returned by visit are visited. The returned value is the new root.
+func TraverseDepthFirst(root Value, visit VisitFunc) (Value, error) {
+	newVal, err := traverseDepthFirstStep(root, EmptyPath(), root, visit)
+	if err != nil && !errors.Is(err, ErrorBreak) && !errors.Is(err, ErrorStop) {
+		return nil, err
+	}
+	return newVal, nil
+}
+
+func traverseDepthFirstStep(root Value, pathFromRoot Path, val Value, visit VisitFunc) (Value, error) {
+	newVal, err := visit(root, pathFromRoot, val)
+	if err != nil {
+		return newVal, err
+	}
+	if newVal == nil {
+		newVal = &itfWrappingValue{}
+	}
+	visitChild := func(seg Segment, child interface{}) (interface{}, error) {
+		newChild, err := traverseDepthFirstStep(root, pathFromRoot.Append(seg), &itfWrappingValue{actual: child}, visit)
+		if newChild == nil {
+			return nil, err
+		}
+		return newChild.CastAny(), err
+	}
+	switch nv := newVal.CastAny().(type) {
+	case []interface{}:
+		for i := range nv {
+			newItem, err := visitChild(IndexSegment(i), nv[i])
+			if err != nil && !errors.Is(err, ErrorBreak) && !errors.Is(err, ErrorStop) {
+				return nil, err
+			}
+			nv[i] = newItem
+			if errors.Is(err, ErrorBreak) {
+				break
+			}
+			if errors.Is(err, ErrorStop) {
+				return newVal, err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(nv))
+		for k := range nv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			newEntry, err := visitChild(KeySegment(k), nv[k])
+			if err != nil && !errors.Is(err, ErrorBreak) && !errors.Is(err, ErrorStop) {
+				return nil, err
+			}
+			nv[k] = newEntry
+			if errors.Is(err, ErrorBreak) {
+				break
+			}
+			if errors.Is(err, ErrorStop) {
+				return newVal, err
+			}
+		}
+	}
+	return newVal, nil
+}
+
+//endregion
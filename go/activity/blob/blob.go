@@ -1,15 +1,166 @@
 package blob
 
 import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
 	"github.com/vital-dhaveloose/aldb/common/mediatype"
 )
 
+const (
+	ErrorCodeInvalidBlob = "activity-blob-invalid"
+	//ErrorCodeChecksumMismatch is returned when content doesn't match the SHA256 that its manifest expects.
+	ErrorCodeChecksumMismatch = "activity-blob-invalid-checksum"
+	ErrorCodeIO               = "activity-blob-io-error"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidBlob, Status: http.StatusBadRequest, Message: errcode.En("invalid blob")},
+		errcode.Code{Code: ErrorCodeChecksumMismatch, Status: http.StatusBadRequest, Message: errcode.En("the blob content doesn't match its checksum")},
+		errcode.Code{Code: ErrorCodeIO, Status: http.StatusInternalServerError, Message: errcode.En("cannot read or write the blob content")},
+	)
+}
+
+//RenditionFunction is the purpose of a rendition of a blob.
+type RenditionFunction string
+
+const (
+	//RenditionFunctionMain is the rendition that is the content itself, the other ones are derived from it.
+	RenditionFunctionMain      = RenditionFunction("main")
+	RenditionFunctionThumbnail = RenditionFunction("thumbnail")
+	RenditionFunctionOcrText   = RenditionFunction("ocr-text")
+)
+
 type BlobManifest struct {
-	MediaType mediatype.MediaType
-	Size      int
+	MediaType mediatype.MediaType `json:"mediaType"`
+	//Size is the length of the content in bytes. It is computed by the Store when the content is written.
+	Size int64 `json:"size,omitempty"`
+	//SHA256 is the hex encoded SHA-256 checksum of the content. It is computed by the Store when the content
+	//is written; if it is set beforehand, the content must match it.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+//Rendition is a representation of the content of a blob for a certain purpose, e.g. the main content or a
+//thumbnail of it.
+type Rendition struct {
+	Function RenditionFunction
+	Manifest BlobManifest
+	//Content is nil when a rendition is described but its content isn't available, e.g. after unmarshalling
+	//it from JSON without bytesBase64.
+	Content Content
 }
 
+//Blob is the unstructured content of an activity, in one or more renditions that each have a different
+//Function: the main one and the ones derived from it.
 type Blob struct {
-	Manifest *BlobManifest
-	Bytes    []byte
+	Renditions []Rendition
+}
+
+//Rendition returns the rendition with the given function.
+func (b *Blob) Rendition(fn RenditionFunction) (Rendition, bool) {
+	if b == nil {
+		return Rendition{}, false
+	}
+	for _, r := range b.Renditions {
+		if r.Function == fn {
+			return r, true
+		}
+	}
+	return Rendition{}, false
+}
+
+//Main returns the main rendition.
+func (b *Blob) Main() (Rendition, bool) {
+	return b.Rendition(RenditionFunctionMain)
+}
+
+//WithRendition returns a copy of b in which the rendition with the same function as r is replaced by r, or
+//to which r is added.
+func (b Blob) WithRendition(r Rendition) Blob {
+	out := Blob{Renditions: make([]Rendition, 0, len(b.Renditions)+1)}
+	for _, existing := range b.Renditions {
+		if existing.Function != r.Function {
+			out.Renditions = append(out.Renditions, existing)
+		}
+	}
+	out.Renditions = append(out.Renditions, r)
+	return out
+}
+
+//WithoutRendition returns a copy of b without the rendition with the given function.
+func (b Blob) WithoutRendition(fn RenditionFunction) Blob {
+	out := Blob{Renditions: make([]Rendition, 0, len(b.Renditions))}
+	for _, existing := range b.Renditions {
+		if existing.Function != fn {
+			out.Renditions = append(out.Renditions, existing)
+		}
+	}
+	return out
+}
+
+//blobJSON keeps the main rendition at the top level, so that documents from before renditions existed
+//remain valid.
+type blobJSON struct {
+	Manifest    *BlobManifest                        `json:"manifest,omitempty"`
+	BytesBase64 []byte                               `json:"bytesBase64,omitempty"`
+	Renditions  map[RenditionFunction]*renditionJSON `json:"renditions,omitempty"`
+}
+
+type renditionJSON struct {
+	Manifest    *BlobManifest `json:"manifest,omitempty"`
+	BytesBase64 []byte        `json:"bytesBase64,omitempty"`
+}
+
+//MarshalJSON encodes the manifests of the renditions, with the main one under "manifest" and the other ones
+//under "renditions" by function. The content is never encoded, it is served separately.
+func (b Blob) MarshalJSON() ([]byte, error) {
+	bj := blobJSON{}
+	for _, r := range b.Renditions {
+		m := r.Manifest
+		if r.Function == RenditionFunctionMain {
+			bj.Manifest = &m
+			continue
+		}
+		if bj.Renditions == nil {
+			bj.Renditions = map[RenditionFunction]*renditionJSON{}
+		}
+		bj.Renditions[r.Function] = &renditionJSON{Manifest: &m}
+	}
+	return json.Marshal(bj)
+}
+
+//UnmarshalJSON decodes the renditions, of which the content can be given inline in base64 under
+//"bytesBase64". Size and SHA256 are only computed when the content is written to a Store.
+func (b *Blob) UnmarshalJSON(bts []byte) error {
+	bj := blobJSON{}
+	if err := json.Unmarshal(bts, &bj); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidBlob, "cannot unmarshal Blob", nil)
+	}
+	out := Blob{}
+	add := func(fn RenditionFunction, rj renditionJSON) {
+		r := Rendition{Function: fn}
+		if rj.Manifest != nil {
+			r.Manifest = *rj.Manifest
+		}
+		if rj.BytesBase64 != nil {
+			r.Content = Bytes(rj.BytesBase64)
+		}
+		out.Renditions = append(out.Renditions, r)
+	}
+	if bj.Manifest != nil || bj.BytesBase64 != nil {
+		add(RenditionFunctionMain, renditionJSON{Manifest: bj.Manifest, BytesBase64: bj.BytesBase64})
+	}
+	for _, fn := range sortedFunctions(bj.Renditions) {
+		if fn == RenditionFunctionMain {
+			return aldberr.New(ErrorCodeInvalidBlob, "cannot unmarshal Blob: the main rendition must be given at the top level", nil)
+		}
+		if bj.Renditions[fn] != nil {
+			add(fn, *bj.Renditions[fn])
+		}
+	}
+	*b = out
+	return nil
 }
@@ -0,0 +1,129 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//Content is the payload of a rendition. It is immutable: every reader that it opens yields the same bytes.
+type Content interface {
+	//Open returns a reader of the content from its start, which the caller must close. The readers of content
+	//read from a Store also implement io.Seeker, so that byte ranges can be served.
+	Open() (io.ReadCloser, error)
+}
+
+//Bytes returns Content that holds bts in memory. bts must not be changed afterwards.
+func Bytes(bts []byte) Content {
+	return bytesContent(bts)
+}
+
+type bytesContent []byte
+
+func (c bytesContent) Open() (io.ReadCloser, error) {
+	return bytesReadCloser{Reader: bytes.NewReader(c)}, nil
+}
+
+type bytesReadCloser struct {
+	*bytes.Reader
+}
+
+func (bytesReadCloser) Close() error {
+	return nil
+}
+
+//FileContent is Content that reads a file, which must not be changed as long as the content is in use.
+type FileContent struct {
+	Path string
+}
+
+func File(path string) *FileContent {
+	return &FileContent{Path: path}
+}
+
+func (c *FileContent) Open() (io.ReadCloser, error) {
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeIO, "cannot open blob content", map[string]interface{}{"path": c.Path})
+	}
+	return f, nil
+}
+
+//FromReader returns Content that can be opened only once, to stream content (e.g. a request body) into a
+//Store without holding it in memory.
+func FromReader(r io.Reader) Content {
+	return &readerContent{r: r}
+}
+
+type readerContent struct {
+	mu     sync.Mutex
+	r      io.Reader
+	opened bool
+}
+
+func (c *readerContent) Open() (io.ReadCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.opened {
+		return nil, aldberr.New(ErrorCodeIO, "blob content from a reader can only be read once", nil)
+	}
+	c.opened = true
+	return io.NopCloser(c.r), nil
+}
+
+//ReadAll reads the whole content into memory, for small content only.
+func ReadAll(c Content) ([]byte, error) {
+	rc, err := c.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	bts, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeIO, "cannot read blob content", nil)
+	}
+	return bts, nil
+}
+
+//Ingest copies the content of r to w, and returns r with the Size and SHA256 of its Manifest computed from
+//the content. If the Manifest already has a SHA256, an error with code ErrorCodeChecksumMismatch is
+//returned when the content doesn't match it. The Content of the returned rendition is left as is, Stores
+//replace it with the content they wrote.
+func Ingest(w io.Writer, r Rendition) (Rendition, error) {
+	if r.Content == nil {
+		return Rendition{}, aldberr.New(ErrorCodeInvalidBlob, "rendition has no content", map[string]interface{}{"function": string(r.Function)})
+	}
+	rc, err := r.Content.Open()
+	if err != nil {
+		return Rendition{}, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, h), rc)
+	if err != nil {
+		return Rendition{}, aldberr.Wrap(err, ErrorCodeIO, "cannot write blob content", map[string]interface{}{"function": string(r.Function)})
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if len(r.Manifest.SHA256) > 0 && !strings.EqualFold(r.Manifest.SHA256, sum) {
+		return Rendition{}, aldberr.New(ErrorCodeChecksumMismatch, "blob content doesn't match its checksum", map[string]interface{}{"function": string(r.Function), "expected": r.Manifest.SHA256, "actual": sum})
+	}
+	r.Manifest.Size = size
+	r.Manifest.SHA256 = sum
+	return r, nil
+}
+
+func sortedFunctions[V any](m map[RenditionFunction]V) []RenditionFunction {
+	out := make([]RenditionFunction, 0, len(m))
+	for fn := range m {
+		out = append(out, fn)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
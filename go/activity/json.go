@@ -0,0 +1,178 @@
+package activity
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/vital-dhaveloose/aldb/activity/attributes"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/common/datetime"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+const (
+	ErrorCodeInvalidActivity = "activity-invalid"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidActivity, Status: http.StatusBadRequest, Message: errcode.En("invalid activity")},
+	)
+}
+
+//activityJSON is the representation of an Activity as described by api/activity.schema.json.
+type activityJSON struct {
+	//Schema is only read to accept documents that reference the schema, it is never written.
+	Schema         string                             `json:"$schema,omitempty"`
+	Id             string                             `json:"id,omitempty"`
+	Version        string                             `json:"version,omitempty"`
+	ParentVersions []string                           `json:"parentVersions,omitempty"`
+	Label          lang.LocalizableString             `json:"label,omitempty"`
+	Period         *datetime.Period                   `json:"period,omitempty"`
+	Participations participation.Participations       `json:"participations,omitempty"`
+	Subs           []*activityJSON                    `json:"subs,omitempty"`
+	Supers         []*activityJSON                    `json:"supers,omitempty"`
+	AttributeSets  map[string]attributes.AttributeSet `json:"attributeSets,omitempty"`
+	Blob           *blob.Blob                         `json:"blob,omitempty"`
+}
+
+//MarshalJSON encodes the Activity according to api/activity.schema.json. Subs and Supers are nested
+//recursively. An Activity that is reached again while it is being encoded (e.g. a Sub that lists its
+//parent under Supers) is written as a reference only, i.e. with just its id and version. Activities are
+//recognised by their id and version, or by pointer if they don't have an id.
+func (a Activity) MarshalJSON() ([]byte, error) {
+	aj, err := activityToJSON(&a, map[interface{}]bool{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(aj)
+}
+
+func (a *Activity) UnmarshalJSON(bts []byte) error {
+	aj := activityJSON{}
+	if err := json.Unmarshal(bts, &aj); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidActivity, "cannot unmarshal Activity", nil)
+	}
+	out, err := activityFromJSON(&aj)
+	if err != nil {
+		return err
+	}
+	*a = *out
+	return nil
+}
+
+func activityToJSON(a *Activity, onPath map[interface{}]bool) (*activityJSON, error) {
+	aj := &activityJSON{Version: a.Version}
+	var key interface{} = a
+	if a.Id != nil {
+		aj.Id = a.Id.String()
+		key = aj.Id + "|" + a.Version
+	}
+	if onPath[key] {
+		return aj, nil
+	}
+	onPath[key] = true
+	defer delete(onPath, key)
+
+	switch l := a.Label.(type) {
+	case nil:
+	case lang.LocalizableString:
+		aj.Label = l
+	default:
+		s, err := l.Localize(lang.LangAny, nil)
+		if err != nil {
+			return nil, aldberr.Wrap(err, ErrorCodeInvalidActivity, "cannot marshal Activity: label cannot be localized", map[string]interface{}{"type": fmt.Sprintf("%T", l)})
+		}
+		aj.Label = lang.LocalizableString{lang.LangAny: s}
+	}
+	if a.Period != (datetime.Period{}) {
+		period := a.Period
+		aj.Period = &period
+	}
+	aj.ParentVersions = a.ParentVersions
+	aj.Participations = a.Participations
+	aj.AttributeSets = a.AttributeSets
+	aj.Blob = a.Blob
+
+	var err error
+	if aj.Subs, err = activitiesToJSON(a.Subs, onPath); err != nil {
+		return nil, err
+	}
+	if aj.Supers, err = activitiesToJSON(a.Supers, onPath); err != nil {
+		return nil, err
+	}
+	return aj, nil
+}
+
+func activitiesToJSON(as []*Activity, onPath map[interface{}]bool) ([]*activityJSON, error) {
+	if as == nil {
+		return nil, nil
+	}
+	out := make([]*activityJSON, 0, len(as))
+	for _, a := range as {
+		if a == nil {
+			continue
+		}
+		aj, err := activityToJSON(a, onPath)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, aj)
+	}
+	return out, nil
+}
+
+func activityFromJSON(aj *activityJSON) (*Activity, error) {
+	a := &Activity{
+		ActivityRef:    ref.ActivityRef{Version: aj.Version},
+		ParentVersions: aj.ParentVersions,
+		Participations: aj.Participations,
+		AttributeSets:  aj.AttributeSets,
+		Blob:           aj.Blob,
+	}
+	if len(aj.Id) > 0 {
+		id, err := url.Parse(aj.Id)
+		if err != nil {
+			return nil, aldberr.Wrap(err, ErrorCodeInvalidActivity, "cannot unmarshal Activity: invalid id", map[string]interface{}{"id": aj.Id})
+		}
+		a.Id = id
+	}
+	if aj.Label != nil {
+		a.Label = aj.Label
+	}
+	if aj.Period != nil {
+		a.Period = *aj.Period
+	}
+	for i := range a.Participations {
+		a.Participations[i].ActivityRef = a.ActivityRef
+	}
+	var err error
+	if a.Subs, err = activitiesFromJSON(aj.Subs); err != nil {
+		return nil, err
+	}
+	if a.Supers, err = activitiesFromJSON(aj.Supers); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func activitiesFromJSON(ajs []*activityJSON) ([]*Activity, error) {
+	if ajs == nil {
+		return nil, nil
+	}
+	out := make([]*Activity, len(ajs))
+	for i := range ajs {
+		a, err := activityFromJSON(ajs[i])
+		if err != nil {
+			return nil, err
+		}
+		out[i] = a
+	}
+	return out, nil
+}
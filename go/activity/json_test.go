@@ -0,0 +1,109 @@
+package activity
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+func TestJSONRoundTripsApiExamples(t *testing.T) {
+	paths, err := filepath.Glob("../../api/examples/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no examples found")
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			original, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := Activity{}
+			if err := json.Unmarshal(original, &a); err != nil {
+				t.Fatal(err)
+			}
+			marshalled, err := json.Marshal(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var expected, actual map[string]interface{}
+			if err := json.Unmarshal(original, &expected); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(marshalled, &actual); err != nil {
+				t.Fatal(err)
+			}
+			//$schema is a meta field that isn't part of the model, and blob content is write-only
+			delete(expected, "$schema")
+			if b, isMap := expected["blob"].(map[string]interface{}); isMap {
+				delete(b, "bytesBase64")
+			}
+			//the display of a person is derived from its name, and read-only
+			ps, _ := actual["participations"].([]interface{})
+			for _, p := range ps {
+				if participator, isMap := p.(map[string]interface{})["participator"].(map[string]interface{}); isMap && participator["kind"] == nil {
+					delete(participator, "display")
+				}
+			}
+			if !reflect.DeepEqual(expected, actual) {
+				t.Errorf("round trip mismatch\nexpected: %s\nactual:   %s", original, marshalled)
+			}
+		})
+	}
+}
+
+func TestJSONBlobRenditions(t *testing.T) {
+	a := Activity{}
+	err := json.Unmarshal([]byte(`{"blob":{"manifest":{"mediaType":"image/png","size":3},"bytesBase64":"cG5n",`+
+		`"renditions":{"thumbnail":{"manifest":{"mediaType":"image/png"},"bytesBase64":"dGh1bWI="}}}}`), &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, found := a.Blob.Main()
+	if !found || main.Manifest.Size != 3 || main.Manifest.MediaType.String() != "image/png" {
+		t.Fatalf("unexpected main rendition: %+v", main)
+	}
+	if bts, err := blob.ReadAll(main.Content); err != nil || string(bts) != "png" {
+		t.Errorf("expected inline content, got '%s' (%v)", bts, err)
+	}
+	thumb, found := a.Blob.Rendition(blob.RenditionFunctionThumbnail)
+	if !found || thumb.Content == nil {
+		t.Errorf("expected a thumbnail with content")
+	}
+
+	bts, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bts) != `{"blob":{"manifest":{"mediaType":"image/png","size":3},"renditions":{"thumbnail":{"manifest":{"mediaType":"image/png"}}}}}` {
+		t.Errorf("unexpected JSON: %s", bts)
+	}
+
+	err = json.Unmarshal([]byte(`{"blob":{"renditions":{"main":{"manifest":{"mediaType":"image/png"}}}}}`), &a)
+	if err == nil {
+		t.Errorf("expected an error for a main rendition under renditions")
+	}
+}
+
+func TestJSONMarshalBreaksCycles(t *testing.T) {
+	parent := &Activity{ActivityRef: ref.ActivityRef{Id: &url.URL{Scheme: "https", Host: "doe.eu", Path: "/activities/parent"}}}
+	child := &Activity{Supers: []*Activity{parent}}
+	parent.Subs = []*Activity{child}
+
+	bts, err := json.Marshal(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bts) != `{"id":"https://doe.eu/activities/parent","subs":[{"supers":[{"id":"https://doe.eu/activities/parent"}]}]}` {
+		t.Errorf("unexpected JSON: %s", bts)
+	}
+}
@@ -0,0 +1,131 @@
+package participation
+
+import (
+	"sort"
+	"strings"
+)
+
+//ContactDetails are the ways to contact an entity besides its primary email address.
+type ContactDetails struct {
+	//Emails are the email addresses besides the primary one.
+	Emails    []ContactPoint `json:"emails,omitempty"`
+	Phones    []ContactPoint `json:"phones,omitempty"`
+	Addresses []Address      `json:"addresses,omitempty"`
+}
+
+//ContactPoint is an email address or a phone number.
+type ContactPoint struct {
+	Value string `json:"value"`
+	//Types describe what the contact point is for, e.g. "work" or "home", and for phones "cell", "voice" or
+	//"fax" (as the TYPE parameter of vCard).
+	Types []string `json:"types,omitempty"`
+	//Pref is the preference of the contact point, from 1 (most preferred) to 100, or 0 if it has none.
+	Pref int `json:"pref,omitempty"`
+}
+
+//Address is a postal address.
+type Address struct {
+	//Street is the street address, of which the lines are separated by "\n".
+	Street     string `json:"street,omitempty"`
+	Locality   string `json:"locality,omitempty"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postalCode,omitempty"`
+	Country    string `json:"country,omitempty"`
+	//Label is the address as it is written on an envelope, if given.
+	Label string   `json:"label,omitempty"`
+	Types []string `json:"types,omitempty"`
+	Pref  int      `json:"pref,omitempty"`
+}
+
+//Clone returns a deep copy of c.
+func (c ContactDetails) Clone() ContactDetails {
+	points := func(ps []ContactPoint) []ContactPoint {
+		if ps == nil {
+			return nil
+		}
+		out := make([]ContactPoint, len(ps))
+		for i, p := range ps {
+			out[i] = p
+			if p.Types != nil {
+				out[i].Types = append([]string{}, p.Types...)
+			}
+		}
+		return out
+	}
+	out := ContactDetails{Emails: points(c.Emails), Phones: points(c.Phones)}
+	if c.Addresses != nil {
+		out.Addresses = make([]Address, len(c.Addresses))
+		for i, a := range c.Addresses {
+			out.Addresses[i] = a
+			if a.Types != nil {
+				out.Addresses[i].Types = append([]string{}, a.Types...)
+			}
+		}
+	}
+	return out
+}
+
+func (c ContactDetails) IsZero() bool {
+	return len(c.Emails) == 0 && len(c.Phones) == 0 && len(c.Addresses) == 0
+}
+
+//Merged returns the contact details of c and o, without duplicates: email addresses and addresses are compared
+//without case, and phone numbers only by their digits. Of duplicates, the one of c is kept.
+func (c ContactDetails) Merged(o ContactDetails) ContactDetails {
+	out := ContactDetails{}
+	out.Emails = mergePoints(c.Emails, o.Emails, strings.ToLower)
+	out.Phones = mergePoints(c.Phones, o.Phones, phoneDigits)
+	seen := map[string]bool{}
+	for _, a := range append(append([]Address{}, c.Addresses...), o.Addresses...) {
+		key := strings.ToLower(strings.Join([]string{a.Street, a.Locality, a.Region, a.PostalCode, a.Country}, "\x00"))
+		if !seen[key] {
+			seen[key] = true
+			out.Addresses = append(out.Addresses, a)
+		}
+	}
+	return out
+}
+
+func mergePoints(a, b []ContactPoint, key func(string) string) []ContactPoint {
+	var out []ContactPoint
+	seen := map[string]bool{}
+	for _, p := range append(append([]ContactPoint{}, a...), b...) {
+		if k := key(p.Value); !seen[k] {
+			seen[k] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+//phoneDigits returns the digits of a phone number and a leading "+", e.g. "+3221234567" for "+32 2 123 45 67".
+func phoneDigits(number string) string {
+	sb := strings.Builder{}
+	for i, r := range strings.TrimPrefix(number, "tel:") {
+		if (r >= '0' && r <= '9') || (r == '+' && i == 0) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+//emails returns the primary email address and the other ones, without empty ones.
+func emails(primary string, c ContactDetails) []string {
+	out := []string{}
+	if len(primary) > 0 {
+		out = append(out, primary)
+	}
+	for _, p := range c.Emails {
+		out = append(out, p.Value)
+	}
+	return out
+}
+
+//byPref sorts contact points from most to least preferred, keeping the order of the ones without preference
+//(which come last).
+func byPref(ps []ContactPoint) {
+	sort.SliceStable(ps, func(i, j int) bool {
+		pi, pj := ps[i].Pref, ps[j].Pref
+		return pi > 0 && (pj == 0 || pi < pj)
+	})
+}
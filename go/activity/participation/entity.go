@@ -1,48 +1,166 @@
 package participation
 
 import (
-	"errors"
-	"fmt"
-	"strings"
-
 	"github.com/vital-dhaveloose/aldb/base/aldberr"
 	"github.com/vital-dhaveloose/aldb/common/lang"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/util"
 )
 
+//EntityRef refers to an Entity, known on a Host (e.g. "viwi.eu"). An empty Host is the host of the system
+//itself.
 type EntityRef struct {
 	Host     string
 	EntityId string
 }
 
-func (r *EntityRef) ToName() string {
-	if !r.IsComplete() {
-		return ""
-	}
-	return fmt.Sprintf("entities/%s", r.EntityId)
+//EntityPattern is the pattern of the names of an EntityRef.
+var EntityPattern = ref.MustPattern("[hosts/{host}/]entities/{entityId}")
+
+func (r EntityRef) ToName() string {
+	return ref.PatternName(&r)
 }
 
 func (r *EntityRef) FromName(name string) error {
-	if len(name) == 0 {
-		return nil
-	}
-	if r == nil {
-		*r = EntityRef{}
-	}
-	if !strings.HasPrefix(name, "entity/") {
-		return errors.New("cannot convert name to EntityRef: name doesn't start with entity/")
-	}
-	r.EntityId = strings.TrimPrefix(name, "persons/")
-	return nil
+	return ref.FromPatternName(r, r == nil, name)
+}
+
+//IsComplete checks that the EntityId is set, the Host is optional.
+func (r EntityRef) IsComplete() bool {
+	return ref.IsPatternComplete(&r)
 }
 
-func (r *EntityRef) IsComplete() bool {
-	return r != nil && len(r.EntityId) > 0
+func (r EntityRef) MarshalText() ([]byte, error) {
+	return ref.MarshalName(&r, r == EntityRef{})
+}
+
+func (r *EntityRef) UnmarshalText(text []byte) error {
+	return r.FromName(string(text))
+}
+
+func (r EntityRef) Pattern() ref.Pattern {
+	return EntityPattern
+}
+
+func (r EntityRef) IdMap() map[string]string {
+	return map[string]string{"host": r.Host, "entityId": r.EntityId}
+}
+
+func (r *EntityRef) FromIdMap(ids map[string]string) error {
+	*r = EntityRef{Host: ids["host"], EntityId: ids["entityId"]}
+	return nil
 }
 
 //Entity is a person, a group of people, an organisation or a computer system. It can be authenticated
 // and or referenced from the model (notably in Participation).
 type Entity interface {
 	EntityRef() EntityRef
+	//DisplayName returns the name to show for the entity to a reader of language l, which is never empty.
+	DisplayName(l lang.Lang) string
+}
+
+//Localized returns a copy of e of which the display name in JSON is in the language that suits the
+//preferences best: organisations only keep the name in that language, and the names of persons are formatted
+//for the most preferred language.
+func Localized(e Entity, p lang.Preferences) Entity {
+	switch et := e.(type) {
+	case *Person:
+		if et == nil {
+			return e
+		}
+		out := *et
+		out.displayLang = lang.LangAny
+		for _, pref := range p {
+			if pref.Quality > 0 && pref.Lang != lang.LangAny {
+				out.displayLang = pref.Lang
+				break
+			}
+		}
+		return &out
+	case *Organisation:
+		if et == nil {
+			return e
+		}
+		l, found := p.Match(et.Name.Langs())
+		if !found {
+			return e
+		}
+		out := *et
+		out.Name = LocalizableOrganisationName{l: et.Name[l]}
+		return &out
+	case *Group:
+		if et == nil {
+			return e
+		}
+		l, found := p.Match(et.Name.Langs())
+		if !found {
+			return e
+		}
+		out := *et
+		out.Name = lang.LocalizableString{l: et.Name[l]}
+		return &out
+	}
+	return e
+}
+
+//CloneEntity returns a deep copy of e, which shares no mutable state with it. Entities of types other than the
+//ones of this package are returned as is.
+func CloneEntity(e Entity) Entity {
+	switch et := e.(type) {
+	case *Person:
+		if et == nil {
+			return e
+		}
+		out := *et
+		if et.Name.OtherGivens != nil {
+			out.Name.OtherGivens = append([]string{}, et.Name.OtherGivens...)
+		}
+		out.Contact = et.Contact.Clone()
+		return &out
+	case *Organisation:
+		if et == nil {
+			return e
+		}
+		out := *et
+		if et.Name != nil {
+			out.Name = make(LocalizableOrganisationName, len(et.Name))
+			for l, on := range et.Name {
+				out.Name[l] = on
+			}
+		}
+		out.Contact = et.Contact.Clone()
+		return &out
+	case *Group:
+		if et == nil {
+			return e
+		}
+		out := *et
+		out.Name = cloneString(et.Name)
+		out.Contact = et.Contact.Clone()
+		return &out
+	case *User:
+		if et == nil {
+			return e
+		}
+		out := *et
+		out.Entity = CloneEntity(et.Entity)
+		out.Context.Organisation = *CloneEntity(&et.Context.Organisation).(*Organisation)
+		out.Context.ValidPeriod = et.Context.ValidPeriod.Clone()
+		out.Context.Description = cloneString(et.Context.Description)
+		return &out
+	}
+	return e
+}
+
+func cloneString(ls lang.LocalizableString) lang.LocalizableString {
+	if ls == nil {
+		return nil
+	}
+	out := make(lang.LocalizableString, len(ls))
+	for l, str := range ls {
+		out[l] = str
+	}
+	return out
 }
 
 //region Person
@@ -50,6 +168,12 @@ type Entity interface {
 type Person struct {
 	Ref  EntityRef
 	Name PersonName
+	//Email is the primary email address, Contact has the other ones.
+	Email   string
+	Contact ContactDetails
+
+	//displayLang is the language the name is formatted for in JSON, see Localized.
+	displayLang lang.Lang
 }
 
 func (p *Person) EntityRef() EntityRef {
@@ -59,10 +183,35 @@ func (p *Person) EntityRef() EntityRef {
 	return p.Ref
 }
 
+//DisplayName returns the full name (see PersonName.Format), or else the email address or the name of the
+//EntityRef.
+func (p *Person) DisplayName(l lang.Lang) string {
+	if p == nil {
+		return ""
+	}
+	if s := p.Name.Format(l, NamePatternFull); len(s) > 0 {
+		return s
+	}
+	if len(p.Email) > 0 {
+		return p.Email
+	}
+	return p.Ref.ToName()
+}
+
+//Emails returns the email addresses of the person, the primary one first.
+func (p *Person) Emails() []string {
+	if p == nil {
+		return nil
+	}
+	return emails(p.Email, p.Contact)
+}
+
 type PersonName struct {
 	Given, Family  string
 	OtherGivens    []string
 	Prefix, Suffix string
+	//Lang is the language of the name, if known, which decides the order of its parts (see Format).
+	Lang lang.Lang
 }
 
 //endregion
@@ -72,6 +221,9 @@ type PersonName struct {
 type Organisation struct {
 	Ref  EntityRef
 	Name LocalizableOrganisationName
+	//Email is the primary email address, Contact has the other ones.
+	Email   string
+	Contact ContactDetails
 }
 
 func (p *Organisation) EntityRef() EntityRef {
@@ -81,6 +233,28 @@ func (p *Organisation) EntityRef() EntityRef {
 	return p.Ref
 }
 
+//DisplayName returns the short name in language l or any other language, or else the name of the EntityRef.
+func (p *Organisation) DisplayName(l lang.Lang) string {
+	if p == nil {
+		return ""
+	}
+	if s, err := p.Name.Localize(l, nil); err == nil && len(s) > 0 {
+		return s
+	}
+	if s, err := p.Name.Localize(lang.LangAny, nil); err == nil && len(s) > 0 {
+		return s
+	}
+	return p.Ref.ToName()
+}
+
+//Emails returns the email addresses of the organisation, the primary one first.
+func (p *Organisation) Emails() []string {
+	if p == nil {
+		return nil
+	}
+	return emails(p.Email, p.Contact)
+}
+
 type LocalizableOrganisationName map[lang.Lang]OrganisationName
 
 type OrganisationName struct {
@@ -88,20 +262,104 @@ type OrganisationName struct {
 }
 
 const (
-	//LocizeParamKeyField allows specifying the field to give localize. Allowed values are "short" (default),
-	//"abbreviation" and "long".
-	LocizeParamKeyField = "pattern"
+	//LocalizeParamKeyPattern allows specifying the form of the name to give localize. For the name of an
+	//organisation, the allowed values are "short" (default), "abbreviation" and "long" and the patterns of
+	//PersonName.Format (see OrganisationName.Format). For the name of a person, they are the patterns of
+	//PersonName.Format, "full" being the default.
+	LocalizeParamKeyPattern = "pattern"
+	//Deprecated: LocizeParamKeyField is LocalizeParamKeyPattern.
+	LocizeParamKeyField = LocalizeParamKeyPattern
+
+	OrganisationNamePatternShort        = NamePatternShort
+	OrganisationNamePatternAbbreviation = "abbreviation"
+	OrganisationNamePatternLong         = "long"
 )
 
+//Localize localizes the name like lang.LocalizableString, supporting lang.LocalizeParamKeyStrict and
+//LocalizeParamKeyPattern.
 func (lon LocalizableOrganisationName) Localize(language lang.Lang, params map[string]interface{}) (string, error) {
 	errDet := map[string]interface{}{"lang": string(language)}
-	//TODO support for strict param
-	//TODO support for pattern param
-	on, found := lon[language]
+	pattern := util.GetEntryString(params, LocalizeParamKeyPattern, OrganisationNamePatternShort)
+	switch pattern {
+	case OrganisationNamePatternShort, OrganisationNamePatternAbbreviation, OrganisationNamePatternLong,
+		NamePatternFull, NamePatternInitials, NamePatternSortable, NamePatternFormal:
+	default:
+		errDet["pattern"] = pattern
+		return "", aldberr.New(ErrorCodeInvalidParticipation, "cannot localize organisation name: unknown pattern", errDet)
+	}
+	on, found := lang.Lookup(lon, language, util.GetEntryBool(params, lang.LocalizeParamKeyStrict, false))
 	if !found {
 		return "", aldberr.New(lang.ErrorCodeLanguageNotFound, "cannot localize organisation name: language not found", errDet)
 	}
-	return on.Short, nil
+	return on.Format(pattern), nil
+}
+
+//Format returns the field of the name for a pattern: the short name for "short", the abbreviation for
+//"abbreviation" and "initials", and the long name for the other patterns (e.g. "long" and "full"). When the
+//name doesn't have the field, the short name is given, or else the first of the long name and the
+//abbreviation that the name has.
+func (on OrganisationName) Format(pattern string) string {
+	candidates := []string{on.Long, on.Short, on.Abbreviation}
+	switch pattern {
+	case OrganisationNamePatternShort:
+		candidates = []string{on.Short, on.Long, on.Abbreviation}
+	case OrganisationNamePatternAbbreviation, NamePatternInitials:
+		candidates = []string{on.Abbreviation, on.Short, on.Long}
+	}
+	for _, c := range candidates {
+		if len(c) > 0 {
+			return c
+		}
+	}
+	return ""
+}
+
+//Langs returns the languages of the name, in order.
+func (lon LocalizableOrganisationName) Langs() []lang.Lang {
+	return lang.Langs(lon)
+}
+
+//endregion
+
+//region Group
+
+//Group is a group of entities, e.g. "Team Blue". Its members are kept in a directory (see
+//directory.Directory), and a participation of a group applies to its members.
+type Group struct {
+	Ref  EntityRef
+	Name lang.LocalizableString
+	//Email is the primary email address, Contact has the other ones.
+	Email   string
+	Contact ContactDetails
+}
+
+func (g *Group) EntityRef() EntityRef {
+	if g == nil {
+		return EntityRef{}
+	}
+	return g.Ref
+}
+
+//DisplayName returns the name in language l or any other language, or else the name of the EntityRef.
+func (g *Group) DisplayName(l lang.Lang) string {
+	if g == nil {
+		return ""
+	}
+	if s, err := g.Name.Localize(l, nil); err == nil && len(s) > 0 {
+		return s
+	}
+	if s, err := g.Name.Localize(lang.LangAny, nil); err == nil && len(s) > 0 {
+		return s
+	}
+	return g.Ref.ToName()
+}
+
+//Emails returns the email addresses of the group, the primary one first.
+func (g *Group) Emails() []string {
+	if g == nil {
+		return nil
+	}
+	return emails(g.Email, g.Contact)
 }
 
 //endregion
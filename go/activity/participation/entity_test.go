@@ -0,0 +1,216 @@
+package participation
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/common/lang"
+)
+
+func TestLocalizeOrganisationName(t *testing.T) {
+	name := LocalizableOrganisationName{
+		"en":    {Abbreviation: "EC", Short: "Commission", Long: "European Commission"},
+		"nl-BE": {Abbreviation: "EC", Long: "Europese Commissie"},
+	}
+	for _, c := range []struct {
+		lang     lang.Lang
+		params   map[string]interface{}
+		expected string
+		found    bool
+	}{
+		{"en-GB", nil, "Commission", true},
+		{"en", map[string]interface{}{LocalizeParamKeyPattern: OrganisationNamePatternLong}, "European Commission", true},
+		{"en", map[string]interface{}{LocalizeParamKeyPattern: OrganisationNamePatternAbbreviation}, "EC", true},
+		{"nl-be", nil, "Europese Commissie", true},
+		{"nl", nil, "", false},
+		{"en-GB", map[string]interface{}{lang.LocalizeParamKeyStrict: true}, "", false},
+		{lang.LangAny, nil, "Commission", true},
+		{"en", map[string]interface{}{LocalizeParamKeyPattern: "nickname"}, "", false},
+	} {
+		s, err := name.Localize(c.lang, c.params)
+		if s != c.expected || (err == nil) != c.found {
+			t.Errorf("%q %v: expected %q, got %q (%v)", c.lang, c.params, c.expected, s, err)
+		}
+	}
+}
+
+func TestFormatPersonName(t *testing.T) {
+	tolkien := PersonName{Prefix: "Dr.", Given: "John", OtherGivens: []string{"Ronald", "Reuel"}, Family: "Tolkien", Suffix: "Jr."}
+	kovacs := PersonName{Given: "János", Family: "Kovács"}
+	mao := PersonName{Given: "泽东", Family: "毛"}
+	sartre := PersonName{Given: "Jean-Paul", Family: "Sartre", Lang: "fr"}
+	for _, c := range []struct {
+		name     PersonName
+		lang     lang.Lang
+		pattern  string
+		expected string
+	}{
+		{tolkien, "en-GB", NamePatternFull, "Dr. John Ronald Reuel Tolkien Jr."},
+		{tolkien, "en-GB", NamePatternShort, "John"},
+		{tolkien, "en-GB", NamePatternInitials, "J.R.R.T."},
+		{tolkien, "en-GB", NamePatternSortable, "Tolkien, John Ronald Reuel, Jr."},
+		{tolkien, "en-GB", NamePatternFormal, "Dr. Tolkien Jr."},
+		{kovacs, lang.LangAny, NamePatternFull, "János Kovács"},
+		{kovacs, "hu-HU", NamePatternFull, "Kovács János"},
+		{kovacs, "hu", NamePatternSortable, "Kovács János"},
+		{kovacs, "hu", NamePatternInitials, "K.J."},
+		{kovacs, "nl", NamePatternFormal, "János Kovács"},
+		{mao, "en", NamePatternFull, "毛泽东"},
+		{mao, "en", NamePatternInitials, "毛泽"},
+		{sartre, "ja", NamePatternFull, "Jean-Paul Sartre"},
+		{sartre, "ja", NamePatternInitials, "J.-P.S."},
+		{PersonName{Family: "Tolkien"}, "en", NamePatternShort, "Tolkien"},
+		{PersonName{}, "en", NamePatternFull, ""},
+	} {
+		if s := c.name.Format(c.lang, c.pattern); s != c.expected {
+			t.Errorf("%+v in %q as %s: expected %q, got %q", c.name, c.lang, c.pattern, c.expected, s)
+		}
+	}
+	if s, err := tolkien.Localize("en", map[string]interface{}{LocalizeParamKeyPattern: NamePatternSortable}); s != "Tolkien, John Ronald Reuel, Jr." || err != nil {
+		t.Errorf("unexpected sortable name %q (%v)", s, err)
+	}
+	if _, err := tolkien.Localize("en", map[string]interface{}{LocalizeParamKeyPattern: "nickname"}); err == nil {
+		t.Errorf("expected an error for an unknown pattern")
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	for _, c := range []struct {
+		entity   Entity
+		expected string
+	}{
+		{&Person{Name: PersonName{Given: "Alice", Family: "Doe"}}, "Alice Doe"},
+		{&Person{Email: "alice@doe.eu"}, "alice@doe.eu"},
+		{&Person{Ref: EntityRef{EntityId: "alice"}}, "entities/alice"},
+		{&Organisation{Name: LocalizableOrganisationName{"nl": {Short: "Commissie"}}}, "Commissie"},
+		{&Organisation{Ref: EntityRef{EntityId: "ec"}}, "entities/ec"},
+	} {
+		if s := c.entity.DisplayName("en"); s != c.expected {
+			t.Errorf("%+v: expected %q, got %q", c.entity, c.expected, s)
+		}
+	}
+}
+
+func TestPersonJSON(t *testing.T) {
+	kovacs := &Person{Ref: EntityRef{EntityId: "kovacs"}, Name: PersonName{Prefix: "Dr.", Given: "János", OtherGivens: []string{"Péter"}, Family: "Kovács"}}
+	bts, err := json.Marshal(Participation{Entity: Localized(kovacs, lang.ParseAcceptLanguage("hu-HU, en;q=0.5"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bts), `"display":"Dr. Kovács János Péter"`) {
+		t.Errorf("expected a display name in Hungarian order, got %s", bts)
+	}
+	p := Participation{}
+	if err := json.Unmarshal(bts, &p); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Entity, kovacs) {
+		t.Errorf("expected %+v, got %+v", kovacs, p.Entity)
+	}
+	if err := json.Unmarshal([]byte(`{"participator": {"display": "Team Blue"}}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if _, isGroup := p.Entity.(*Group); !isGroup {
+		t.Errorf("expected a participator with only a display to be a group, got %+v", p.Entity)
+	}
+	if err := json.Unmarshal([]byte(`{"participator": {"kind": "organisation", "display": "Doe"}}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if _, isOrganisation := p.Entity.(*Organisation); !isOrganisation {
+		t.Errorf("expected a participator of kind organisation to be an organisation, got %+v", p.Entity)
+	}
+	if err := json.Unmarshal([]byte(`{"participator": {"email": "blue@doe.eu"}}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if _, isGroup := p.Entity.(*Group); !isGroup {
+		t.Errorf("expected a participator without kind and name to be a group, got %+v", p.Entity)
+	}
+
+	nameless := &Person{Ref: EntityRef{EntityId: "doe"}, Email: "doe@doe.eu"}
+	if bts, err = MarshalEntity(nameless); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := UnmarshalEntity(bts); err != nil || !reflect.DeepEqual(e, nameless) {
+		t.Errorf("expected %+v, got %+v (%v) from %s", nameless, e, err, bts)
+	}
+}
+
+func TestOrganisationJSON(t *testing.T) {
+	for _, o := range []*Organisation{
+		{Ref: EntityRef{EntityId: "ec"}, Name: LocalizableOrganisationName{lang.LangAny: {Short: "Commission"}}},
+		{Ref: EntityRef{EntityId: "ec"}, Name: LocalizableOrganisationName{
+			"en": {Abbreviation: "EC", Short: "Commission", Long: "European Commission"},
+			"nl": {Abbreviation: "EC", Short: "Commissie", Long: "Europese Commissie"},
+		}},
+	} {
+		bts, err := MarshalEntity(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := UnmarshalEntity(bts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(e, o) {
+			t.Errorf("expected %+v, got %+v from %s", o, e, bts)
+		}
+	}
+}
+
+func TestGroupJSON(t *testing.T) {
+	g := &Group{Ref: EntityRef{EntityId: "team-blue"}, Name: lang.LocalizableString{lang.LangAny: "Team Blue"}, Email: "blue@doe.eu"}
+	bts, err := MarshalEntity(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bts), `"kind":"group"`) {
+		t.Errorf("expected the kind in %s", bts)
+	}
+	e, err := UnmarshalEntity(bts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(e, g) {
+		t.Errorf("expected %+v, got %+v", g, e)
+	}
+	if e.DisplayName(lang.LangEn) != "Team Blue" {
+		t.Errorf("unexpected display name %q", e.DisplayName(lang.LangEn))
+	}
+}
+
+func TestParticipationRoles(t *testing.T) {
+	ps := Participations{}
+	in := `[{"participator": {"givenName": "Alice", "display": "Alice"}, "roles": ["https://projo.com/roles/lead", "https://projo.com/roles/author"]}, {"participator": {"kind": "group", "display": "Team Blue"}}]`
+	if err := json.Unmarshal([]byte(in), &ps); err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 3 || ps[0].Role.ParticipationRoleId != "https://projo.com/roles/lead" || ps[1].Role.ParticipationRoleId != "https://projo.com/roles/author" || ps[2].Role != nil {
+		t.Fatalf("expected one participation per role, got %+v", ps)
+	}
+	if ps[0].Entity == ps[1].Entity || !reflect.DeepEqual(ps[0].Entity, ps[1].Entity) {
+		t.Errorf("expected equal copies of the participator, got %+v and %+v", ps[0].Entity, ps[1].Entity)
+	}
+	bts, err := MarshalParticipation(ps[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bts), `"roles":["https://projo.com/roles/lead","https://projo.com/roles/author"]`) {
+		t.Errorf("expected both roles in %s", bts)
+	}
+	p := Participation{}
+	if err := json.Unmarshal(bts, &p); err == nil {
+		t.Error("expected an error for a single Participation with several roles")
+	}
+
+	if bts, err = json.Marshal(ps); err != nil {
+		t.Fatal(err)
+	}
+	out, want := []interface{}{}, []interface{}{}
+	json.Unmarshal(bts, &out)
+	json.Unmarshal([]byte(in), &want)
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("expected the participations to be written as read, got %s", bts)
+	}
+}
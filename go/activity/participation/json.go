@@ -0,0 +1,404 @@
+package participation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/common/datetime"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+)
+
+const (
+	ErrorCodeInvalidParticipation = "activity-participation-invalid"
+)
+
+//Kinds of the participator forms of activity.schema.json. The "group" form is a Group, unless its kind is
+//KindOrganisation. KindPerson is only written for a person without a name, which would be read as a group
+//otherwise.
+const (
+	KindPerson       = "person"
+	KindGroup        = "group"
+	KindOrganisation = "organisation"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidParticipation, Status: http.StatusBadRequest, Message: errcode.En("invalid participation")},
+	)
+}
+
+//participatorJSON is the union of the "person" and "group" participator forms of activity.schema.json. The
+//form is given by the kind, or else by the name fields of a person: a participator without kind and name
+//fields is a group. The display of a person is its formatted full name, which is ignored when unmarshalling.
+//The display of a group or an organisation is its (short) name, and Names are its names by language (of which a
+//group only has short names), left out if it has no other names.
+type participatorJSON struct {
+	Kind            string                             `json:"kind,omitempty"`
+	Entity          *EntityRef                         `json:"entity,omitempty"`
+	GivenName       string                             `json:"givenName,omitempty"`
+	OtherGivenNames []string                           `json:"otherGivenNames,omitempty"`
+	FamilyName      string                             `json:"familyName,omitempty"`
+	NamePrefix      string                             `json:"namePrefix,omitempty"`
+	NameSuffix      string                             `json:"nameSuffix,omitempty"`
+	NameLang        lang.Lang                          `json:"nameLang,omitempty"`
+	Display         string                             `json:"display,omitempty"`
+	Names           map[lang.Lang]organisationNameJSON `json:"names,omitempty"`
+	Email           string                             `json:"email,omitempty"`
+	Contact         *ContactDetails                    `json:"contact,omitempty"`
+}
+
+type organisationNameJSON struct {
+	Abbreviation string `json:"abbreviation,omitempty"`
+	Short        string `json:"short,omitempty"`
+	Long         string `json:"long,omitempty"`
+}
+
+func (pj participatorJSON) personName() PersonName {
+	return PersonName{Given: pj.GivenName, OtherGivens: pj.OtherGivenNames, Family: pj.FamilyName, Prefix: pj.NamePrefix, Suffix: pj.NameSuffix, Lang: pj.NameLang.Canonical()}
+}
+
+type participationJSON struct {
+	Participator *participatorJSON `json:"participator,omitempty"`
+	Roles        []string          `json:"roles,omitempty"`
+	Period       *datetime.Period  `json:"period,omitempty"`
+}
+
+func (p Person) MarshalJSON() ([]byte, error) {
+	return json.Marshal(personToJSON(&p))
+}
+
+func (p *Person) UnmarshalJSON(bts []byte) error {
+	pj := participatorJSON{}
+	if err := json.Unmarshal(bts, &pj); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidParticipation, "cannot unmarshal Person", nil)
+	}
+	*p = *pj.toPerson()
+	return nil
+}
+
+func (o Organisation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(organisationToJSON(&o))
+}
+
+func (o *Organisation) UnmarshalJSON(bts []byte) error {
+	pj := participatorJSON{}
+	if err := json.Unmarshal(bts, &pj); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidParticipation, "cannot unmarshal Organisation", nil)
+	}
+	*o = *pj.toOrganisation()
+	return nil
+}
+
+func (g Group) MarshalJSON() ([]byte, error) {
+	return json.Marshal(groupToJSON(&g))
+}
+
+func (g *Group) UnmarshalJSON(bts []byte) error {
+	pj := participatorJSON{}
+	if err := json.Unmarshal(bts, &pj); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidParticipation, "cannot unmarshal Group", nil)
+	}
+	*g = *pj.toGroup()
+	return nil
+}
+
+//MarshalEntity returns the JSON form of a Person, an Organisation or a Group.
+func MarshalEntity(e Entity) ([]byte, error) {
+	switch et := e.(type) {
+	case *Person:
+		return json.Marshal(personToJSON(et))
+	case *Organisation:
+		return json.Marshal(organisationToJSON(et))
+	case *Group:
+		return json.Marshal(groupToJSON(et))
+	}
+	return nil, aldberr.New(ErrorCodeInvalidParticipation, "cannot marshal entity: unsupported entity type", map[string]interface{}{"type": fmt.Sprintf("%T", e)})
+}
+
+//UnmarshalEntity reads the JSON form of a participator (see MarshalEntity) as a Person, an Organisation or a
+//Group.
+func UnmarshalEntity(bts []byte) (Entity, error) {
+	pj := participatorJSON{}
+	if err := json.Unmarshal(bts, &pj); err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalidParticipation, "cannot unmarshal entity", nil)
+	}
+	return pj.toEntity(), nil
+}
+
+func personToJSON(p *Person) participatorJSON {
+	displayLang := p.displayLang
+	if len(displayLang) == 0 {
+		displayLang = lang.LangAny
+	}
+	kind := ""
+	if p.Name.isZero() {
+		kind = KindPerson
+	}
+	return participatorJSON{
+		Kind:            kind,
+		Entity:          entityRefToJSON(p.Ref),
+		GivenName:       p.Name.Given,
+		OtherGivenNames: p.Name.OtherGivens,
+		FamilyName:      p.Name.Family,
+		NamePrefix:      p.Name.Prefix,
+		NameSuffix:      p.Name.Suffix,
+		NameLang:        p.Name.Lang,
+		Display:         p.Name.Format(displayLang, NamePatternFull),
+		Email:           p.Email,
+		Contact:         contactToJSON(p.Contact),
+	}
+}
+
+func organisationToJSON(o *Organisation) participatorJSON {
+	display, _ := o.Name.Localize(lang.LangAny, nil)
+	var names map[lang.Lang]organisationNameJSON
+	onlyDisplay := len(o.Name) == 0 || len(o.Name) == 1 && o.Name[lang.LangAny] == OrganisationName{Short: display}
+	if !onlyDisplay {
+		names = make(map[lang.Lang]organisationNameJSON, len(o.Name))
+		for l, n := range o.Name {
+			names[l] = organisationNameJSON{Abbreviation: n.Abbreviation, Short: n.Short, Long: n.Long}
+		}
+	}
+	return participatorJSON{Kind: KindOrganisation, Entity: entityRefToJSON(o.Ref), Display: display, Names: names, Email: o.Email, Contact: contactToJSON(o.Contact)}
+}
+
+func groupToJSON(g *Group) participatorJSON {
+	display, _ := g.Name.Localize(lang.LangAny, nil)
+	var names map[lang.Lang]organisationNameJSON
+	_, hasAny := g.Name[lang.LangAny]
+	onlyDisplay := len(g.Name) == 0 || len(g.Name) == 1 && hasAny
+	if !onlyDisplay {
+		names = make(map[lang.Lang]organisationNameJSON, len(g.Name))
+		for l, n := range g.Name {
+			names[l] = organisationNameJSON{Short: n}
+		}
+	}
+	return participatorJSON{Kind: KindGroup, Entity: entityRefToJSON(g.Ref), Display: display, Names: names, Email: g.Email, Contact: contactToJSON(g.Contact)}
+}
+
+func (pj participatorJSON) toEntity() Entity {
+	switch {
+	case pj.Kind == KindOrganisation:
+		return pj.toOrganisation()
+	case pj.Kind == KindGroup:
+		return pj.toGroup()
+	case pj.Kind == KindPerson || !pj.personName().isZero():
+		return pj.toPerson()
+	}
+	return pj.toGroup()
+}
+
+func (pj participatorJSON) toPerson() *Person {
+	return &Person{Ref: pj.entityRef(), Name: pj.personName(), Email: pj.Email, Contact: pj.contact()}
+}
+
+func (pj participatorJSON) toOrganisation() *Organisation {
+	name := LocalizableOrganisationName{}
+	for l, n := range pj.Names {
+		name[l.Canonical()] = OrganisationName{Abbreviation: n.Abbreviation, Short: n.Short, Long: n.Long}
+	}
+	if len(name) == 0 && len(pj.Display) > 0 {
+		name[lang.LangAny] = OrganisationName{Short: pj.Display}
+	}
+	return &Organisation{Ref: pj.entityRef(), Name: name, Email: pj.Email, Contact: pj.contact()}
+}
+
+func (pj participatorJSON) toGroup() *Group {
+	name := lang.LocalizableString{}
+	for l, n := range pj.Names {
+		name[l.Canonical()] = n.Short
+	}
+	if len(name) == 0 && len(pj.Display) > 0 {
+		name[lang.LangAny] = pj.Display
+	}
+	return &Group{Ref: pj.entityRef(), Name: name, Email: pj.Email, Contact: pj.contact()}
+}
+
+func contactToJSON(c ContactDetails) *ContactDetails {
+	if c.IsZero() {
+		return nil
+	}
+	return &c
+}
+
+func (pj participatorJSON) contact() ContactDetails {
+	if pj.Contact == nil {
+		return ContactDetails{}
+	}
+	return *pj.Contact
+}
+
+func entityRefToJSON(r EntityRef) *EntityRef {
+	if r == (EntityRef{}) {
+		return nil
+	}
+	return &r
+}
+
+func (pj participatorJSON) entityRef() EntityRef {
+	if pj.Entity == nil {
+		return EntityRef{}
+	}
+	return *pj.Entity
+}
+
+func (p Participation) MarshalJSON() ([]byte, error) {
+	pj, err := participationToJSON(p)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(pj)
+}
+
+//MarshalParticipation writes participations that only differ in their Role (e.g. the ones returned by
+//UnmarshalParticipation) as a single participation with all their roles.
+func MarshalParticipation(ps []Participation) ([]byte, error) {
+	if len(ps) == 0 {
+		return json.Marshal(participationJSON{})
+	}
+	pj, err := participationToJSON(ps[0])
+	if err != nil {
+		return nil, err
+	}
+	pj.Roles = nil
+	for _, p := range ps {
+		if p.Role != nil {
+			pj.Roles = append(pj.Roles, p.Role.ParticipationRoleId)
+		}
+	}
+	return json.Marshal(pj)
+}
+
+func participationToJSON(p Participation) (participationJSON, error) {
+	pj := participationJSON{}
+	switch e := p.Entity.(type) {
+	case nil:
+	case *Person:
+		j := personToJSON(e)
+		pj.Participator = &j
+	case *Organisation:
+		j := organisationToJSON(e)
+		pj.Participator = &j
+	case *Group:
+		j := groupToJSON(e)
+		pj.Participator = &j
+	default:
+		return participationJSON{}, aldberr.New(ErrorCodeInvalidParticipation, "cannot marshal Participation: unsupported entity type", map[string]interface{}{"type": fmt.Sprintf("%T", e)})
+	}
+	if p.Role != nil {
+		pj.Roles = []string{p.Role.ParticipationRoleId}
+	}
+	if p.Period != (datetime.Period{}) {
+		pj.Period = &p.Period
+	}
+	return pj, nil
+}
+
+//UnmarshalJSON reads a participation with at most one role. Use UnmarshalParticipation or Participations for
+//participations that may have several roles.
+func (p *Participation) UnmarshalJSON(bts []byte) error {
+	ps, err := UnmarshalParticipation(bts)
+	if err != nil {
+		return err
+	}
+	switch len(ps) {
+	case 0:
+		*p = Participation{}
+	case 1:
+		*p = ps[0]
+	default:
+		return aldberr.New(ErrorCodeInvalidParticipation, "cannot unmarshal a Participation with several roles, unmarshal it as Participations", map[string]interface{}{"roles": len(ps)})
+	}
+	return nil
+}
+
+//UnmarshalParticipation reads a participation as one Participation per role, with the same participator and
+//period, as a Participation has a single Role. A participation without roles is a single Participation
+//without Role.
+func UnmarshalParticipation(bts []byte) ([]Participation, error) {
+	pj := participationJSON{}
+	if err := json.Unmarshal(bts, &pj); err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalidParticipation, "cannot unmarshal Participation", nil)
+	}
+	out := Participation{}
+	if pj.Participator != nil {
+		out.Entity = pj.Participator.toEntity()
+	}
+	if pj.Period != nil {
+		out.Period = *pj.Period
+	}
+	if len(pj.Roles) == 0 {
+		return []Participation{out}, nil
+	}
+	ps := make([]Participation, len(pj.Roles))
+	for i, role := range pj.Roles {
+		ps[i] = out
+		if i > 0 {
+			ps[i].Entity = CloneEntity(out.Entity)
+			ps[i].Period = out.Period.Clone()
+		}
+		ps[i].Role = &ParticipationRole{ParticipationRoleRef: ParticipationRoleRef{ParticipationRoleId: role}}
+	}
+	return ps, nil
+}
+
+//Participations is a list of participations of which the JSON form may have participations with several
+//roles, which are read as one Participation per role (see UnmarshalParticipation). They are written back as
+//one participation per participator and period, with all their roles (see MarshalParticipation).
+type Participations []Participation
+
+func (ps Participations) MarshalJSON() ([]byte, error) {
+	if ps == nil {
+		return []byte("null"), nil
+	}
+	out := make([]participationJSON, 0, len(ps))
+	//index gives the participation in out of each participator and period that has roles
+	index := map[string]int{}
+	for _, p := range ps {
+		pj, err := participationToJSON(p)
+		if err != nil {
+			return nil, err
+		}
+		if p.Role == nil {
+			out = append(out, pj)
+			continue
+		}
+		pj.Roles = nil
+		key, err := json.Marshal(pj)
+		if err != nil {
+			return nil, err
+		}
+		if i, found := index[string(key)]; found {
+			out[i].Roles = append(out[i].Roles, p.Role.ParticipationRoleId)
+			continue
+		}
+		index[string(key)] = len(out)
+		pj.Roles = []string{p.Role.ParticipationRoleId}
+		out = append(out, pj)
+	}
+	return json.Marshal(out)
+}
+
+func (ps *Participations) UnmarshalJSON(bts []byte) error {
+	raws := []json.RawMessage(nil)
+	if err := json.Unmarshal(bts, &raws); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidParticipation, "cannot unmarshal Participations", nil)
+	}
+	if raws == nil {
+		*ps = nil
+		return nil
+	}
+	out := make(Participations, 0, len(raws))
+	for _, raw := range raws {
+		split, err := UnmarshalParticipation(raw)
+		if err != nil {
+			return err
+		}
+		out = append(out, split...)
+	}
+	*ps = out
+	return nil
+}
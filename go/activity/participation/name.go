@@ -0,0 +1,159 @@
+package participation
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+	"github.com/vital-dhaveloose/aldb/util"
+)
+
+//The values of LocalizeParamKeyPattern for the name of a Person. The examples are for "Dr. John Ronald Reuel
+//Tolkien Jr." in English.
+const (
+	//NamePatternFull is the whole name, e.g. "Dr. John Ronald Reuel Tolkien Jr.".
+	NamePatternFull = "full"
+	//NamePatternShort is the name a person is informally called by, e.g. "John".
+	NamePatternShort = "short"
+	//NamePatternInitials are the initials of the given and family names, e.g. "J.R.R.T.".
+	NamePatternInitials = "initials"
+	//NamePatternSortable is the name for sorting by family name, e.g. "Tolkien, John Ronald Reuel, Jr.".
+	NamePatternSortable = "sortable"
+	//NamePatternFormal is the name to address a person formally, e.g. "Dr. Tolkien", or "John Tolkien"
+	//without a Prefix.
+	NamePatternFormal = "formal"
+)
+
+//familyFirstLangs are the languages in which the family name comes before the given names.
+var familyFirstLangs = map[string]bool{
+	"hu": true,
+	"ja": true,
+	"ko": true,
+	"mn": true,
+	"vi": true,
+	"zh": true,
+}
+
+//Localize formats the name with the pattern of the LocalizeParamKeyPattern param (NamePatternFull by
+//default) for language l, see Format. The lang.LocalizeParamKeyStrict param is ignored, as a name isn't
+//translated.
+func (n PersonName) Localize(l lang.Lang, params map[string]interface{}) (string, error) {
+	pattern := util.GetEntryString(params, LocalizeParamKeyPattern, NamePatternFull)
+	switch pattern {
+	case NamePatternFull, NamePatternShort, NamePatternInitials, NamePatternSortable, NamePatternFormal:
+		return n.Format(l, pattern), nil
+	}
+	return "", aldberr.New(ErrorCodeInvalidParticipation, "cannot localize person name: unknown pattern", map[string]interface{}{"lang": string(l), "pattern": pattern})
+}
+
+//Format formats the name with a pattern (NamePatternFull for unknown patterns) for a reader of language l.
+//The family name comes first if the name has a Lang in which it does (e.g. "hu" or "ja"), if it is written in
+//a CJK script, or else if it does in l. Parts in a CJK script are not separated by spaces, e.g. "毛泽东".
+func (n PersonName) Format(l lang.Lang, pattern string) string {
+	familyFirst := n.familyFirst(l)
+	givens := append([]string{n.Given}, n.OtherGivens...)
+	switch pattern {
+	case NamePatternShort:
+		if len(strings.TrimSpace(n.Given)) > 0 {
+			return strings.TrimSpace(n.Given)
+		}
+		return joinName(n.Prefix, n.Family)
+	case NamePatternInitials:
+		parts := append(givens, n.Family)
+		if familyFirst {
+			parts = append([]string{n.Family}, givens...)
+		}
+		out := ""
+		for _, part := range parts {
+			out += initials(part)
+		}
+		return out
+	case NamePatternSortable:
+		if familyFirst {
+			return joinName(append(append([]string{n.Family}, givens...), n.Suffix)...)
+		}
+		given := joinName(givens...)
+		if len(strings.TrimSpace(n.Family)) == 0 || len(given) == 0 {
+			return joinName(append(append([]string{n.Family}, givens...), n.Suffix)...)
+		}
+		out := strings.TrimSpace(n.Family) + ", " + given
+		if suffix := strings.TrimSpace(n.Suffix); len(suffix) > 0 {
+			out += ", " + suffix
+		}
+		return out
+	case NamePatternFormal:
+		if len(strings.TrimSpace(n.Prefix)) > 0 && len(strings.TrimSpace(n.Family)) > 0 {
+			return joinName(n.Prefix, n.Family, n.Suffix)
+		}
+		if familyFirst {
+			return joinName(n.Family, n.Given)
+		}
+		return joinName(n.Given, n.Family)
+	}
+	if familyFirst {
+		return joinName(append(append([]string{n.Prefix, n.Family}, givens...), n.Suffix)...)
+	}
+	return joinName(append(append([]string{n.Prefix}, givens...), n.Family, n.Suffix)...)
+}
+
+func (n PersonName) familyFirst(l lang.Lang) bool {
+	if len(n.Lang) > 0 && n.Lang != lang.LangAny {
+		return familyFirstLangs[n.Lang.Base()]
+	}
+	if isCJK(firstRune(n.Family)) || isCJK(firstRune(n.Given)) {
+		return true
+	}
+	return familyFirstLangs[l.Base()]
+}
+
+//joinName joins the parts that aren't empty with spaces, except between parts in a CJK script.
+func joinName(parts ...string) string {
+	out := ""
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		if len(out) > 0 && !(isCJK(lastRune(out)) && isCJK(firstRune(part))) {
+			out += " "
+		}
+		out += part
+	}
+	return out
+}
+
+//initials returns the initials of a name part, keeping hyphens, e.g. "J.-P." for "Jean-Paul". Parts in a CJK
+//script have no dots.
+func initials(part string) string {
+	out := ""
+	for i, word := range strings.Split(strings.TrimSpace(part), "-") {
+		r := firstRune(word)
+		if r == utf8.RuneError {
+			continue
+		}
+		if i > 0 {
+			out += "-"
+		}
+		out += string(unicode.ToUpper(r))
+		if !isCJK(r) {
+			out += "."
+		}
+	}
+	return out
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(strings.TrimSpace(s))
+	return r
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
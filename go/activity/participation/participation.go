@@ -12,10 +12,48 @@ type Participation struct {
 	Role   *ParticipationRole
 }
 
+//ParticipationRoleRef refers to a role, of which the id is typically a URI defined by an application, e.g.
+//"http://uius.org/apps/projects/roles/lead".
 type ParticipationRoleRef struct {
 	ParticipationRoleId string
 }
 
+//ParticipationRolePattern is the pattern of the names of a ParticipationRoleRef.
+var ParticipationRolePattern = ref.MustPattern("participation-roles/{participationRoleId}")
+
+func (r ParticipationRoleRef) ToName() string {
+	return ref.PatternName(&r)
+}
+
+func (r *ParticipationRoleRef) FromName(name string) error {
+	return ref.FromPatternName(r, r == nil, name)
+}
+
+func (r ParticipationRoleRef) IsComplete() bool {
+	return ref.IsPatternComplete(&r)
+}
+
+func (r ParticipationRoleRef) MarshalText() ([]byte, error) {
+	return ref.MarshalName(&r, r == ParticipationRoleRef{})
+}
+
+func (r *ParticipationRoleRef) UnmarshalText(text []byte) error {
+	return r.FromName(string(text))
+}
+
+func (r ParticipationRoleRef) Pattern() ref.Pattern {
+	return ParticipationRolePattern
+}
+
+func (r ParticipationRoleRef) IdMap() map[string]string {
+	return map[string]string{"participationRoleId": r.ParticipationRoleId}
+}
+
+func (r *ParticipationRoleRef) FromIdMap(ids map[string]string) error {
+	*r = ParticipationRoleRef{ParticipationRoleId: ids["participationRoleId"]}
+	return nil
+}
+
 type ParticipationRole struct {
 	ParticipationRoleRef
 }
@@ -0,0 +1,95 @@
+package participation
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEntityRefNames(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		expected EntityRef
+	}{
+		{"entities/vital.dhaveloose", EntityRef{EntityId: "vital.dhaveloose"}},
+		{"hosts/viwi.eu/entities/vital.dhaveloose", EntityRef{Host: "viwi.eu", EntityId: "vital.dhaveloose"}},
+		{"", EntityRef{}},
+	} {
+		r := EntityRef{}
+		if err := r.FromName(c.name); err != nil || r != c.expected {
+			t.Errorf("expected '%s' to give %+v, got %+v (%v)", c.name, c.expected, r, err)
+		}
+		if r.ToName() != c.name {
+			t.Errorf("expected name '%s', got '%s'", c.name, r.ToName())
+		}
+	}
+	for _, name := range []string{"entity/x", "persons/x", "hosts/viwi.eu", "entities/x/hosts/y"} {
+		r := EntityRef{}
+		if err := r.FromName(name); err == nil {
+			t.Errorf("expected '%s' to be invalid, got %+v", name, r)
+		}
+	}
+	var nilRef *EntityRef
+	if err := nilRef.FromName("entities/x"); err == nil {
+		t.Error("expected an error for a nil receiver")
+	}
+
+	ucr := UserContextRef{EntityRef: EntityRef{Host: "viwi.eu", EntityId: "vital.dhaveloose"}, UserContextId: "work"}
+	bts, err := json.Marshal(map[string]interface{}{"context": ucr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"context":"hosts/viwi.eu/entities/vital.dhaveloose/user-contexts/work"}`; string(bts) != expected {
+		t.Errorf("expected %s, got %s", expected, bts)
+	}
+}
+
+func FuzzEntityRef(f *testing.F) {
+	f.Add("viwi.eu", "vital.dhaveloose")
+	f.Add("", "a/b")
+	f.Fuzz(func(t *testing.T, host, entityId string) {
+		in := EntityRef{Host: host, EntityId: entityId}
+		if !in.IsComplete() {
+			return
+		}
+		out := EntityRef{}
+		if err := out.FromName(in.ToName()); err != nil || out != in {
+			t.Fatalf("expected %+v, got %+v (%v)", in, out, err)
+		}
+	})
+}
+
+func FuzzUserContextRef(f *testing.F) {
+	f.Add("viwi.eu", "vital.dhaveloose", "work")
+	f.Fuzz(func(t *testing.T, host, entityId, userContextId string) {
+		in := UserContextRef{EntityRef: EntityRef{Host: host, EntityId: entityId}, UserContextId: userContextId}
+		if !in.IsComplete() {
+			return
+		}
+		out := UserContextRef{}
+		if err := out.FromName(in.ToName()); err != nil || out != in {
+			t.Fatalf("expected %+v, got %+v (%v)", in, out, err)
+		}
+		text, err := in.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = UserContextRef{}
+		if err := out.UnmarshalText(text); err != nil || out != in {
+			t.Fatalf("expected %+v, got %+v (%v)", in, out, err)
+		}
+	})
+}
+
+func FuzzParticipationRoleRef(f *testing.F) {
+	f.Add("http://uius.org/apps/projects/roles/lead")
+	f.Fuzz(func(t *testing.T, roleId string) {
+		in := ParticipationRoleRef{ParticipationRoleId: roleId}
+		if !in.IsComplete() {
+			return
+		}
+		out := ParticipationRoleRef{}
+		if err := out.FromName(in.ToName()); err != nil || out != in {
+			t.Fatalf("expected %+v, got %+v (%v)", in, out, err)
+		}
+	})
+}
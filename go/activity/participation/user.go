@@ -13,7 +13,7 @@ type User struct {
 }
 
 type UserContextRef struct {
-	EntityRef     ref.Ref
+	EntityRef     EntityRef
 	UserContextId string
 }
 
@@ -23,3 +23,49 @@ type UserContext struct {
 	ValidPeriod  datetime.Period
 	Description  lang.LocalizableString
 }
+
+//region Ref
+
+//UserContextPattern is the pattern of the names of a UserContextRef.
+var UserContextPattern = EntityPattern.Child("user-contexts/{userContextId}")
+
+func (r UserContextRef) ToName() string {
+	return ref.PatternName(&r)
+}
+
+func (r *UserContextRef) FromName(name string) error {
+	return ref.FromPatternName(r, r == nil, name)
+}
+
+func (r UserContextRef) IsComplete() bool {
+	return ref.IsPatternComplete(&r)
+}
+
+func (r UserContextRef) MarshalText() ([]byte, error) {
+	return ref.MarshalName(&r, r == UserContextRef{})
+}
+
+func (r *UserContextRef) UnmarshalText(text []byte) error {
+	return r.FromName(string(text))
+}
+
+func (r UserContextRef) Pattern() ref.Pattern {
+	return UserContextPattern
+}
+
+func (r UserContextRef) IdMap() map[string]string {
+	out := r.EntityRef.IdMap()
+	out["userContextId"] = r.UserContextId
+	return out
+}
+
+func (r *UserContextRef) FromIdMap(ids map[string]string) error {
+	er := EntityRef{}
+	if err := er.FromIdMap(ids); err != nil {
+		return err
+	}
+	*r = UserContextRef{EntityRef: er, UserContextId: ids["userContextId"]}
+	return nil
+}
+
+//endregion
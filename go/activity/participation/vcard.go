@@ -0,0 +1,545 @@
+package participation
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/common/contentline"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+	"github.com/vital-dhaveloose/aldb/common/mediatype"
+)
+
+const (
+	//ErrorCodeInvalidVCard is returned for a vCard that cannot be read or an entity that cannot be written as
+	//one.
+	ErrorCodeInvalidVCard = "activity-participation-invalid-vcard"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidVCard, Status: http.StatusBadRequest, Message: errcode.En("invalid vCard")},
+	)
+}
+
+//VCardMediaType is the media type of vCards.
+var VCardMediaType = mediatype.MustParse("text/vcard; charset=utf-8")
+
+//region decoding
+
+//DecodeVCards reads the vCards (RFC 6350, and the similar version 3.0 of RFC 2426) in r as entities, in order:
+//a card of KIND "org" is an Organisation, a card of KIND "group" is a Group, a card of KIND "location" is left
+//out, and other cards are Persons.
+//
+//The UID of a card is the EntityRef if it is the name of one (see EntityRef.ToName). The N of a person is its
+//PersonName, in the language of its LANGUAGE parameter, or else its FN is its given name. The FN of an
+//organisation is its short name, its ORG the long name and its NICKNAME the abbreviation, in the language of
+//their LANGUAGE parameter. The FN of a group is its name. The most preferred EMAIL (see PREF) is the primary email address, the other ones,
+//the TELs and the ADRs are the ContactDetails.
+func DecodeVCards(r io.Reader) ([]Entity, error) {
+	cs, err := contentline.Decode(r)
+	if err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalidVCard, "cannot read vCards", nil)
+	}
+	out := make([]Entity, 0, len(cs))
+	for i, c := range cs {
+		e, err := decodeVCard(c)
+		if err != nil {
+			return nil, aldberr.Wrap(err, ErrorCodeInvalidVCard, "invalid vCard", map[string]interface{}{"card": i + 1})
+		}
+		if e != nil {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+//decodeVCard returns the entity of a card, or nil if the card isn't one of an entity.
+func decodeVCard(c *contentline.Component) (Entity, error) {
+	if c.Name != "VCARD" {
+		return nil, aldberr.New(ErrorCodeInvalidVCard, "expected VCARD", map[string]interface{}{"component": c.Name})
+	}
+	if v, _ := c.Prop("VERSION"); v.Value != "4.0" && v.Value != "3.0" {
+		return nil, aldberr.New(ErrorCodeInvalidVCard, "unsupported vCard version", map[string]interface{}{"version": v.Value})
+	}
+	ref := EntityRef{}
+	if p, found := c.Prop("UID"); found {
+		if err := ref.FromName(p.Text()); err != nil {
+			ref = EntityRef{}
+		}
+	}
+	primary, contact := decodeContact(c)
+
+	kind, _ := c.Prop("KIND")
+	switch strings.ToLower(kind.Value) {
+	case "location":
+		return nil, nil
+	case "group":
+		g := &Group{Ref: ref, Name: lang.LocalizableString{}, Email: primary, Contact: contact}
+		for _, p := range c.Props("FN") {
+			l, err := vcardLang(p)
+			if err != nil {
+				return nil, err
+			}
+			g.Name[l] = p.Text()
+		}
+		return g, nil
+	case "org":
+		o := &Organisation{Ref: ref, Name: LocalizableOrganisationName{}, Email: primary, Contact: contact}
+		for _, prop := range []string{"FN", "ORG", "NICKNAME"} {
+			for _, p := range c.Props(prop) {
+				l, err := vcardLang(p)
+				if err != nil {
+					return nil, err
+				}
+				on := o.Name[l]
+				switch prop {
+				case "FN":
+					on.Short = p.Text()
+				case "ORG":
+					on.Long = p.Fields()[0][0]
+				case "NICKNAME":
+					on.Abbreviation = p.Texts()[0]
+				}
+				o.Name[l] = on
+			}
+		}
+		return o, nil
+	}
+
+	person := &Person{Ref: ref, Email: primary, Contact: contact}
+	if p, found := c.Prop("N"); found {
+		fields := p.Fields()
+		for len(fields) < 5 {
+			fields = append(fields, []string{""})
+		}
+		givens := append(nonEmpty(fields[1]), nonEmpty(fields[2])...)
+		person.Name = PersonName{
+			Family: strings.Join(nonEmpty(fields[0]), " "),
+			Prefix: strings.Join(nonEmpty(fields[3]), " "),
+			Suffix: strings.Join(nonEmpty(fields[4]), " "),
+		}
+		if len(givens) > 0 {
+			person.Name.Given, person.Name.OtherGivens = givens[0], givens[1:]
+		}
+		if len(person.Name.OtherGivens) == 0 {
+			person.Name.OtherGivens = nil
+		}
+		if raw := p.Param("LANGUAGE"); len(raw) > 0 {
+			l, err := lang.ParseLang(raw)
+			if err != nil {
+				return nil, err
+			}
+			person.Name.Lang = l
+		}
+	}
+	if p, found := c.Prop("FN"); found && person.Name.isZero() {
+		person.Name.Given = p.Text()
+	}
+	return person, nil
+}
+
+//decodeContact returns the most preferred email address of a card and its other contact details.
+func decodeContact(c *contentline.Component) (string, ContactDetails) {
+	contact := ContactDetails{}
+	for _, p := range c.Props("EMAIL") {
+		contact.Emails = append(contact.Emails, ContactPoint{Value: p.Text(), Types: vcardTypes(p), Pref: vcardPref(p)})
+	}
+	byPref(contact.Emails)
+	primary := ""
+	if len(contact.Emails) > 0 {
+		primary = contact.Emails[0].Value
+		contact.Emails = contact.Emails[1:]
+	}
+	if len(contact.Emails) == 0 {
+		contact.Emails = nil
+	}
+	for _, p := range c.Props("TEL") {
+		number := p.Text()
+		if scheme, rest, found := strings.Cut(number, ":"); found && strings.EqualFold(scheme, "tel") {
+			number = rest
+		}
+		contact.Phones = append(contact.Phones, ContactPoint{Value: number, Types: vcardTypes(p), Pref: vcardPref(p)})
+	}
+	for _, p := range c.Props("ADR") {
+		fields := p.Fields()
+		for len(fields) < 7 {
+			fields = append(fields, []string{""})
+		}
+		//the post office box and the extended address (fields 0 and 1) should be empty
+		street := append(append(nonEmpty(fields[0]), nonEmpty(fields[1])...), nonEmpty(fields[2])...)
+		contact.Addresses = append(contact.Addresses, Address{
+			Street:     strings.Join(street, "\n"),
+			Locality:   strings.Join(nonEmpty(fields[3]), " "),
+			Region:     strings.Join(nonEmpty(fields[4]), " "),
+			PostalCode: strings.Join(nonEmpty(fields[5]), " "),
+			Country:    strings.Join(nonEmpty(fields[6]), " "),
+			Label:      p.Param("LABEL"),
+			Types:      vcardTypes(p),
+			Pref:       vcardPref(p),
+		})
+	}
+	return primary, contact
+}
+
+//vcardTypes returns the lower case TYPEs of p, which may be comma separated, except "pref" (see vcardPref).
+func vcardTypes(p contentline.Property) []string {
+	var out []string
+	for _, v := range p.Params["TYPE"] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.ToLower(strings.TrimSpace(t)); len(t) > 0 && t != "pref" {
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
+
+//vcardPref returns the PREF of p, or 1 for a TYPE of "pref" (vCard 3.0), or 0.
+func vcardPref(p contentline.Property) int {
+	if pref, err := strconv.Atoi(p.Param("PREF")); err == nil && pref >= 1 && pref <= 100 {
+		return pref
+	}
+	for _, v := range p.Params["TYPE"] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), "pref") {
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+//vcardLang returns the language of the LANGUAGE parameter of p, or LangAny.
+func vcardLang(p contentline.Property) (lang.Lang, error) {
+	if raw := p.Param("LANGUAGE"); len(raw) > 0 {
+		return lang.ParseLang(raw)
+	}
+	return lang.LangAny, nil
+}
+
+func nonEmpty(values []string) []string {
+	out := []string{}
+	for _, v := range values {
+		if len(v) > 0 {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (n PersonName) isZero() bool {
+	return len(n.Given) == 0 && len(n.Family) == 0 && len(n.OtherGivens) == 0 && len(n.Prefix) == 0 && len(n.Suffix) == 0
+}
+
+//endregion
+
+//region encoding
+
+//EncodeVCards writes the entities as vCards of version 4.0, as DecodeVCards reads them. The FN of a person is
+//its full name (see PersonName.Format), and the names of an organisation in several languages are written as
+//alternatives (ALTID) of each other.
+func EncodeVCards(w io.Writer, es ...Entity) error {
+	cs := make([]*contentline.Component, 0, len(es))
+	for _, e := range es {
+		c, err := encodeVCard(e)
+		if err != nil {
+			return err
+		}
+		cs = append(cs, c)
+	}
+	if err := contentline.Encode(w, cs...); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidVCard, "cannot write vCards", nil)
+	}
+	return nil
+}
+
+func encodeVCard(e Entity) (*contentline.Component, error) {
+	c := &contentline.Component{Name: "VCARD"}
+	c.Add("VERSION", "4.0")
+	switch et := e.(type) {
+	case *Person:
+		if et == nil {
+			break
+		}
+		encodeUID(c, et.Ref)
+		nameLang := et.Name.Lang
+		if len(nameLang) == 0 {
+			nameLang = lang.LangAny
+		}
+		c.AddText("FN", et.DisplayName(nameLang))
+		if !et.Name.isZero() {
+			n := c.Add("N", "").SetFields([]string{et.Name.Family}, []string{et.Name.Given}, et.Name.OtherGivens, []string{et.Name.Prefix}, []string{et.Name.Suffix})
+			if len(et.Name.Lang) > 0 {
+				n.SetParam("LANGUAGE", string(et.Name.Lang))
+			}
+		}
+		encodeContact(c, et.Email, et.Contact)
+		return c, nil
+	case *Organisation:
+		if et == nil {
+			break
+		}
+		c.Add("KIND", "org")
+		encodeUID(c, et.Ref)
+		langs := et.Name.Langs()
+		for _, prop := range []string{"FN", "ORG", "NICKNAME"} {
+			for _, l := range langs {
+				on := et.Name[l]
+				var p *contentline.Property
+				switch {
+				case prop == "FN" && len(on.Short) > 0:
+					p = c.AddText("FN", on.Short)
+				case prop == "ORG" && len(on.Long) > 0:
+					p = c.Add("ORG", "").SetFields([]string{on.Long})
+				case prop == "NICKNAME" && len(on.Abbreviation) > 0:
+					p = c.AddText("NICKNAME", on.Abbreviation)
+				default:
+					continue
+				}
+				if l != lang.LangAny {
+					p.SetParam("LANGUAGE", string(l))
+				}
+				if len(langs) > 1 {
+					p.SetParam("ALTID", prop)
+				}
+			}
+		}
+		//FN is required
+		if _, found := c.Prop("FN"); !found {
+			c.AddText("FN", et.DisplayName(lang.LangAny))
+		}
+		encodeContact(c, et.Email, et.Contact)
+		return c, nil
+	case *Group:
+		if et == nil {
+			break
+		}
+		c.Add("KIND", "group")
+		encodeUID(c, et.Ref)
+		langs := et.Name.Langs()
+		for _, l := range langs {
+			if len(et.Name[l]) == 0 {
+				continue
+			}
+			p := c.AddText("FN", et.Name[l])
+			if l != lang.LangAny {
+				p.SetParam("LANGUAGE", string(l))
+			}
+			if len(langs) > 1 {
+				p.SetParam("ALTID", "FN")
+			}
+		}
+		if _, found := c.Prop("FN"); !found {
+			c.AddText("FN", et.DisplayName(lang.LangAny))
+		}
+		encodeContact(c, et.Email, et.Contact)
+		return c, nil
+	}
+	return nil, aldberr.New(ErrorCodeInvalidVCard, "cannot write entity as vCard: unsupported entity type", map[string]interface{}{"type": fmt.Sprintf("%T", e)})
+}
+
+//encodeUID writes the name of r as UID, which is text rather than a URI.
+func encodeUID(c *contentline.Component, r EntityRef) {
+	if r.IsComplete() {
+		c.AddText("UID", r.ToName()).SetParam("VALUE", "text")
+	}
+}
+
+func encodeContact(c *contentline.Component, primary string, contact ContactDetails) {
+	if len(primary) > 0 {
+		p := c.AddText("EMAIL", primary)
+		if len(contact.Emails) > 0 {
+			p.SetParam("PREF", "1")
+		}
+	}
+	encodeParams := func(p *contentline.Property, types []string, pref int) {
+		if len(types) > 0 {
+			p.SetParam("TYPE", types...)
+		}
+		if pref > 0 {
+			p.SetParam("PREF", strconv.Itoa(pref))
+		}
+	}
+	for _, e := range contact.Emails {
+		encodeParams(c.AddText("EMAIL", e.Value), e.Types, e.Pref)
+	}
+	for _, t := range contact.Phones {
+		p := c.Add("TEL", "tel:"+strings.Join(strings.Fields(t.Value), "-")).SetParam("VALUE", "uri")
+		encodeParams(p, t.Types, t.Pref)
+	}
+	for _, a := range contact.Addresses {
+		p := c.Add("ADR", "").SetFields(nil, nil, strings.Split(a.Street, "\n"), []string{a.Locality}, []string{a.Region}, []string{a.PostalCode}, []string{a.Country})
+		if len(a.Label) > 0 {
+			p.SetParam("LABEL", a.Label)
+		}
+		encodeParams(p, a.Types, a.Pref)
+	}
+}
+
+//endregion
+
+//region import
+
+//ImportedEntity is an entity of an address book (see ImportAddressBook).
+type ImportedEntity struct {
+	Entity Entity
+	//Existing is the existing entity that Entity is a duplicate of and was merged into, or nil if Entity is new.
+	Existing Entity
+}
+
+//ImportAddressBook reads the vCards in r (see DecodeVCards) and deduplicates them against existing and each
+//other: entities of the same type are duplicates if they have the same complete EntityRef or share an email
+//address (without case). Duplicates are merged (see Merge). It returns the new entities and the merged existing
+//ones, in the order in which they first appear in r.
+func ImportAddressBook(r io.Reader, existing []Entity) ([]ImportedEntity, error) {
+	decoded, err := DecodeVCards(r)
+	if err != nil {
+		return nil, err
+	}
+	out := []ImportedEntity{}
+	//index maps the keys of the entities to their index in out, or to -1-i for existing[i] that isn't in out
+	index := map[string]int{}
+	for i, e := range existing {
+		for _, k := range dedupKeys(e) {
+			if _, found := index[k]; !found {
+				index[k] = -1 - i
+			}
+		}
+	}
+	for _, e := range decoded {
+		i, found := -1, false
+		for _, k := range dedupKeys(e) {
+			if i, found = index[k]; found {
+				break
+			}
+		}
+		switch {
+		case !found:
+			out = append(out, ImportedEntity{Entity: e})
+			i = len(out) - 1
+		case i < 0:
+			ex := existing[-1-i]
+			out = append(out, ImportedEntity{Entity: Merge(ex, e), Existing: ex})
+			i = len(out) - 1
+		default:
+			out[i].Entity = Merge(out[i].Entity, e)
+		}
+		for _, k := range dedupKeys(out[i].Entity) {
+			index[k] = i
+		}
+	}
+	return out, nil
+}
+
+//dedupKeys returns the keys by which duplicates of e are found: its EntityRef and its email addresses, for its
+//type.
+func dedupKeys(e Entity) []string {
+	prefix := fmt.Sprintf("%T ", e)
+	out := []string{}
+	if r := e.EntityRef(); r.IsComplete() {
+		out = append(out, prefix+"ref "+r.ToName())
+	}
+	var addresses []string
+	switch et := e.(type) {
+	case *Person:
+		addresses = et.Emails()
+	case *Organisation:
+		addresses = et.Emails()
+	case *Group:
+		addresses = et.Emails()
+	}
+	for _, a := range addresses {
+		out = append(out, prefix+"email "+strings.ToLower(a))
+	}
+	return out
+}
+
+//Merge returns a copy of into with what it doesn't have of from, if they are of the same type (otherwise it
+//returns into): the EntityRef and the name (for an organisation or a group, in the languages it doesn't have),
+//and the contact details (see ContactDetails.Merged).
+func Merge(into, from Entity) Entity {
+	switch it := into.(type) {
+	case *Person:
+		ft, isPerson := from.(*Person)
+		if it == nil || !isPerson || ft == nil {
+			return into
+		}
+		out := *it
+		if !out.Ref.IsComplete() {
+			out.Ref = ft.Ref
+		}
+		if out.Name.isZero() {
+			out.Name = ft.Name
+		}
+		out.Email, out.Contact = mergeContact(it.Email, it.Contact, ft.Email, ft.Contact)
+		return &out
+	case *Organisation:
+		ft, isOrganisation := from.(*Organisation)
+		if it == nil || !isOrganisation || ft == nil {
+			return into
+		}
+		out := *it
+		if !out.Ref.IsComplete() {
+			out.Ref = ft.Ref
+		}
+		out.Name = LocalizableOrganisationName{}
+		for l, on := range ft.Name {
+			out.Name[l] = on
+		}
+		for l, on := range it.Name {
+			out.Name[l] = on
+		}
+		out.Email, out.Contact = mergeContact(it.Email, it.Contact, ft.Email, ft.Contact)
+		return &out
+	case *Group:
+		ft, isGroup := from.(*Group)
+		if it == nil || !isGroup || ft == nil {
+			return into
+		}
+		out := *it
+		if !out.Ref.IsComplete() {
+			out.Ref = ft.Ref
+		}
+		out.Name = lang.LocalizableString{}
+		for l, n := range ft.Name {
+			out.Name[l] = n
+		}
+		for l, n := range it.Name {
+			out.Name[l] = n
+		}
+		out.Email, out.Contact = mergeContact(it.Email, it.Contact, ft.Email, ft.Contact)
+		return &out
+	}
+	return into
+}
+
+//mergeContact merges the email addresses and contact details of from into the ones of into.
+func mergeContact(intoPrimary string, into ContactDetails, fromPrimary string, from ContactDetails) (string, ContactDetails) {
+	primary := intoPrimary
+	if len(primary) == 0 {
+		primary = fromPrimary
+	}
+	others := ContactDetails{}
+	if len(fromPrimary) > 0 {
+		others.Emails = []ContactPoint{{Value: fromPrimary}}
+	}
+	out := into.Merged(others.Merged(from))
+	emails := out.Emails[:0:0]
+	for _, e := range out.Emails {
+		if !strings.EqualFold(e.Value, primary) {
+			emails = append(emails, e)
+		}
+	}
+	out.Emails = emails
+	if len(out.Emails) == 0 {
+		out.Emails = nil
+	}
+	return primary, out
+}
+
+//endregion
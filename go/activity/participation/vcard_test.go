@@ -0,0 +1,194 @@
+package participation
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/common/lang"
+)
+
+var addressBook = strings.Join([]string{
+	"BEGIN:VCARD",
+	"VERSION:4.0",
+	"UID:urn:uuid:4fbe8971-0bc3-424c-9c26-36c3e1eff6b1",
+	"FN:Dr. János Péter Kovács",
+	"N;LANGUAGE=hu-HU:Kovács;János;Péter;Dr.;",
+	"EMAIL;TYPE=home:janos@kovacs.hu",
+	"EMAIL;PREF=1;TYPE=work:kovacs@viwi.eu",
+	"TEL;VALUE=uri;TYPE=cell,voice:tel:+36-1-234-5678",
+	"ADR;TYPE=work;LABEL=\"Váci utca 1^nBudapest\":;;Váci utca 1;Budapest;;1052;Hungary",
+	"END:VCARD",
+	"BEGIN:VCARD",
+	"VERSION:3.0",
+	"FN:Jane Doe",
+	"EMAIL;TYPE=INTERNET,PREF:jane@doe.eu",
+	"TEL;TYPE=WORK:+32 2 123 45 67",
+	"END:VCARD",
+	"BEGIN:VCARD",
+	"VERSION:4.0",
+	"KIND:org",
+	"UID:entities/viwi",
+	"FN;ALTID=1;LANGUAGE=en:Viwi",
+	"FN;ALTID=1;LANGUAGE=nl:Viwi",
+	"ORG;ALTID=2;LANGUAGE=en:Virtual Widgets",
+	"ORG;ALTID=2;LANGUAGE=nl:Virtuele Widgets",
+	"NICKNAME:VW",
+	"EMAIL:info@viwi.eu",
+	"END:VCARD",
+	"BEGIN:VCARD",
+	"VERSION:4.0",
+	"KIND:location",
+	"FN:Room 1",
+	"END:VCARD",
+	"",
+}, "\r\n")
+
+func TestDecodeVCards(t *testing.T) {
+	es, err := DecodeVCards(strings.NewReader(addressBook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 3 {
+		t.Fatalf("expected 3 entities, got %d", len(es))
+	}
+
+	kovacs := es[0].(*Person)
+	expected := &Person{
+		Name:  PersonName{Prefix: "Dr.", Given: "János", OtherGivens: []string{"Péter"}, Family: "Kovács", Lang: "hu-HU"},
+		Email: "kovacs@viwi.eu",
+		Contact: ContactDetails{
+			Emails:    []ContactPoint{{Value: "janos@kovacs.hu", Types: []string{"home"}}},
+			Phones:    []ContactPoint{{Value: "+36-1-234-5678", Types: []string{"cell", "voice"}}},
+			Addresses: []Address{{Street: "Váci utca 1", Locality: "Budapest", PostalCode: "1052", Country: "Hungary", Label: "Váci utca 1\nBudapest", Types: []string{"work"}}},
+		},
+	}
+	if !reflect.DeepEqual(kovacs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, kovacs)
+	}
+
+	jane := es[1].(*Person)
+	if jane.Name.Given != "Jane Doe" || jane.Email != "jane@doe.eu" || len(jane.Contact.Phones) != 1 || jane.Contact.Phones[0].Types[0] != "work" {
+		t.Errorf("unexpected version 3.0 card %+v", jane)
+	}
+
+	viwi := es[2].(*Organisation)
+	if viwi.Ref != (EntityRef{EntityId: "viwi"}) || viwi.Email != "info@viwi.eu" {
+		t.Errorf("unexpected organisation %+v", viwi)
+	}
+	if on := viwi.Name["nl"]; on.Short != "Viwi" || on.Long != "Virtuele Widgets" {
+		t.Errorf("unexpected Dutch name %+v", on)
+	}
+	if on := viwi.Name[lang.LangAny]; on.Abbreviation != "VW" {
+		t.Errorf("unexpected abbreviation %+v", on)
+	}
+
+	for _, in := range []string{
+		"BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCARD\r\nVERSION:2.1\r\nFN:x\r\nEND:VCARD\r\n",
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nN;LANGUAGE=???:x;y;;;\r\nEND:VCARD\r\n",
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nFN:x\r\n",
+	} {
+		if _, err := DecodeVCards(strings.NewReader(in)); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestEncodeVCards(t *testing.T) {
+	es, err := DecodeVCards(strings.NewReader(addressBook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	es[0].(*Person).Ref = EntityRef{Host: "viwi.eu", EntityId: "kovacs"}
+	buf := &bytes.Buffer{}
+	if err := EncodeVCards(buf, es...); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"UID;VALUE=text:hosts/viwi.eu/entities/kovacs",
+		"FN:Dr. Kovács János Péter",
+		"N;LANGUAGE=hu-HU:Kovács;János;Péter;Dr.;",
+		"EMAIL;PREF=1:kovacs@viwi.eu",
+		"TEL;TYPE=cell,voice;VALUE=uri:tel:+36-1-234-5678",
+		"TEL;TYPE=work;VALUE=uri:tel:+32-2-123-45-67",
+		"KIND:org",
+		"FN;ALTID=FN;LANGUAGE=nl:Viwi",
+		"NICKNAME;ALTID=NICKNAME:VW",
+	} {
+		if !strings.Contains(buf.String(), line+"\r\n") {
+			t.Errorf("expected %q in\n%s", line, buf)
+		}
+	}
+
+	again, err := DecodeVCards(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again[0], es[0]) || !reflect.DeepEqual(again[2], es[2]) {
+		t.Errorf("unexpected round trip %+v, %+v", again[0], again[2])
+	}
+	if err := EncodeVCards(buf, &User{}); err == nil {
+		t.Error("expected an error for an unsupported entity type")
+	}
+}
+
+func TestImportAddressBook(t *testing.T) {
+	existing := []Entity{
+		&Person{Ref: EntityRef{EntityId: "jane"}, Name: PersonName{Given: "Jane", Family: "Doe"}, Email: "Jane@Doe.eu"},
+		&Organisation{Ref: EntityRef{EntityId: "viwi"}, Name: LocalizableOrganisationName{"en": {Short: "Viwi Inc."}}},
+	}
+	in := addressBook + strings.Join([]string{
+		"BEGIN:VCARD",
+		"VERSION:4.0",
+		"FN:János Kovács",
+		"EMAIL:janos@kovacs.hu",
+		"TEL:+36 1 234 5678",
+		"TEL:+36 30 123 4567",
+		"END:VCARD",
+		"BEGIN:VCARD",
+		"VERSION:4.0",
+		"KIND:org",
+		"FN:Doe",
+		"EMAIL:jane@doe.eu",
+		"END:VCARD",
+		"",
+	}, "\r\n")
+	imported, err := ImportAddressBook(strings.NewReader(in), existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 4 {
+		t.Fatalf("expected 4 entities, got %d: %+v", len(imported), imported)
+	}
+
+	kovacs := imported[0].Entity.(*Person)
+	if imported[0].Existing != nil || kovacs.Name.Family != "Kovács" || len(kovacs.Contact.Phones) != 2 {
+		t.Errorf("expected the cards of Kovács to be merged, got %+v", kovacs)
+	}
+
+	jane := imported[1]
+	if jane.Existing != existing[0] {
+		t.Errorf("expected Jane to be merged into the existing one, got %+v", jane)
+	}
+	if p := jane.Entity.(*Person); p.Ref.EntityId != "jane" || p.Name.Family != "Doe" || p.Email != "Jane@Doe.eu" || len(p.Contact.Emails) != 0 || len(p.Contact.Phones) != 1 {
+		t.Errorf("unexpected merged Jane %+v", p)
+	}
+	if existing[0].(*Person).Contact.Phones != nil {
+		t.Error("expected the existing entity to be unchanged")
+	}
+
+	viwi := imported[2]
+	if viwi.Existing != existing[1] {
+		t.Errorf("expected Viwi to be merged into the existing one, got %+v", viwi)
+	}
+	if o := viwi.Entity.(*Organisation); o.Name["en"].Short != "Viwi Inc." || o.Name["nl"].Long != "Virtuele Widgets" || o.Email != "info@viwi.eu" {
+		t.Errorf("unexpected merged Viwi %+v", o)
+	}
+
+	//an organisation doesn't duplicate a person with the same email address
+	if _, isOrganisation := imported[3].Entity.(*Organisation); !isOrganisation || imported[3].Existing != nil {
+		t.Errorf("expected a new organisation, got %+v", imported[3])
+	}
+}
@@ -0,0 +1,64 @@
+//Command blobctl maintains the blob content of a filesystem store (see store.FilesystemStore):
+//
+//	blobctl -data <dir> verify            re-hashes all content and reports corrupt and missing content
+//	blobctl -data <dir> [-grace 1h] gc    deletes the content that no version refers to anymore
+//
+//It exits with status 1 if verify finds problems.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+func main() {
+	dataDir := flag.String("data", "", "directory of the filesystem store")
+	grace := flag.Duration("grace", store.DefaultBlobGracePeriod, "gc: age under which unreferenced content is kept, as it may belong to a version that is being created")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -data <dir> [flags] verify|gc\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if len(*dataDir) == 0 || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	fs, err := store.NewFilesystemStore(*dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch flag.Arg(0) {
+	case "verify":
+		report, err := store.VerifyBlobs(ctx, fs, fs.Blobs())
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range report.Problems {
+			log.Printf("%s: %v", p.Error(), p.Details())
+		}
+		log.Printf("verified %d contents, found %d problems", report.Verified, len(report.Problems))
+		if len(report.Problems) > 0 {
+			os.Exit(1)
+		}
+	case "gc":
+		report, err := store.CollectBlobs(ctx, fs, fs.Blobs(), *grace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		freed := int64(0)
+		for _, info := range report.Deleted {
+			freed += info.Size
+		}
+		log.Printf("deleted %d contents (%d bytes), kept %d", len(report.Deleted), freed, report.Kept)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
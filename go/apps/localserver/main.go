@@ -1,18 +1,190 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"time"
 
+	"github.com/vital-dhaveloose/aldb/access"
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/auth"
+	"github.com/vital-dhaveloose/aldb/blobstore"
+	"github.com/vital-dhaveloose/aldb/directory"
 	"github.com/vital-dhaveloose/aldb/examples"
+	"github.com/vital-dhaveloose/aldb/graph"
+	"github.com/vital-dhaveloose/aldb/manifest"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/server"
+	"github.com/vital-dhaveloose/aldb/store"
 )
 
 func main() {
-	http.HandleFunc("/activities", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Content-Type", "application/json")
-		bts, _ := json.Marshal(examples.CreateExampleData())
-		w.Write(bts)
-	})
+	addr := flag.String("addr", ":8080", "address to listen on")
+	dataDir := flag.String("data", "", "directory to store activities in; if empty, activities are kept in memory and the example data is loaded")
+	manifestDir := flag.String("manifests", "", "directory to store attribute set manifests in; if empty, manifests are kept in memory")
+	gcInterval := flag.Duration("gc", store.DefaultBlobGracePeriod, "interval at which blob content that no version refers to anymore is deleted; 0 disables it")
+	uploadDir := flag.String("uploads", "", "directory to keep unfinished blob uploads in; if empty, a directory in the temporary directory of the OS is used")
+	usersFile := flag.String("users", "", "JSON file with the users that can authenticate (see auth.Users); if empty, requests are anonymous")
+	basicAuth := flag.Bool("basic-auth", false, "accept HTTP basic auth with the passwords of the users, for development only")
+	jwksFile := flag.String("jwks", "", "JWKS file with the keys to verify JWT bearer tokens with; if empty, JWTs are not accepted")
+	jwtIssuer := flag.String("jwt-issuer", "", "required issuer of JWT bearer tokens")
+	jwtAudience := flag.String("jwt-audience", "", "required audience of JWT bearer tokens")
+	policyFile := flag.String("policy", "", "JSON file with the access policy (see access.Policy); if empty, access is not controlled")
+	auditFile := flag.String("audit", "", "file to append the audit log to; if empty, there is no audit log")
+	directoryFile := flag.String("directory", "", "JSON file with the entities and group members of the directory (see directory.ReadMemoryDirectory), to which changes are written back; if empty, the entities are not served")
+	directoryAdmin := flag.String("directory-admin", "", "id of the activity whose administrators may change the directory, when access is controlled")
+	flag.Parse()
 
-	http.ListenAndServe(":8080", nil)
+	ctx := context.Background()
+	var registry manifest.Registry = manifest.NewMemoryRegistry()
+	if len(*manifestDir) > 0 {
+		fr, err := manifest.NewFilesystemRegistry(*manifestDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		registry = fr
+	}
+	var inner store.Store
+	var blobs blobstore.Store
+	if len(*dataDir) > 0 {
+		fs, err := store.NewFilesystemStore(*dataDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		report, err := fs.Scan(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range report.Problems {
+			log.Printf("%s: %v", p.Error(), p.Details())
+		}
+		inner, blobs = fs, fs.Blobs()
+	} else {
+		ms := store.NewMemoryStore()
+		inner, blobs = ms, ms.Blobs()
+	}
+	gs, err := graph.NewStore(ctx, inner)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var d directory.Directory
+	if len(*directoryFile) > 0 {
+		md, err := directory.ReadMemoryDirectory(*directoryFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		d = md
+	}
+	var s store.Store = manifest.NewValidatingStore(gs, registry)
+	if len(*policyFile) > 0 {
+		policy, err := access.ReadPolicy(*policyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		as := access.NewStore(s, policy)
+		if d != nil {
+			as.Authorizer().SetDirectory(d)
+		}
+		s = as
+		ctx = access.AsSystem(ctx)
+	}
+	if len(*dataDir) == 0 {
+		for _, m := range examples.CreateExampleManifests() {
+			if _, err := registry.Publish(ctx, manifest.PublishRequest{ToPublish: m}); err != nil {
+				log.Fatal(err)
+			}
+		}
+		example := examples.CreateExampleData()
+		if err := seed(ctx, s, &example, map[string]bool{}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *gcInterval > 0 {
+		go collectBlobs(ctx, inner, blobs, *gcInterval)
+	}
+
+	srv := server.New(s, registry)
+	if len(*uploadDir) > 0 {
+		srv.SetUploadDir(*uploadDir)
+	}
+	if d != nil {
+		admin := ref.ActivityRef{}
+		if len(*directoryAdmin) > 0 {
+			id, err := url.Parse(*directoryAdmin)
+			if err != nil {
+				log.Fatal(err)
+			}
+			admin.Id = id
+		}
+		srv.SetDirectory(d, admin)
+	}
+	if len(*usersFile) > 0 {
+		users, err := auth.ReadUsers(*usersFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		authenticators := []auth.Authenticator{auth.NewTokenAuthenticator(users)}
+		if len(*jwksFile) > 0 {
+			keys, err := auth.ReadJWKS(*jwksFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			authenticators = append(authenticators, auth.NewJWTAuthenticator(keys, auth.JWTOptions{Issuer: *jwtIssuer, Audience: *jwtAudience, Leeway: time.Minute}))
+		}
+		if *basicAuth {
+			authenticators = append(authenticators, auth.NewBasicAuthenticator(users))
+		}
+		srv.SetAuthentication(auth.New(users, authenticators...))
+	}
+	if len(*auditFile) > 0 {
+		f, err := os.OpenFile(*auditFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		srv.SetAuditLog(f)
+	}
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv))
+}
+
+//collectBlobs deletes unreferenced blob content every interval.
+func collectBlobs(ctx context.Context, s store.Store, blobs blobstore.Store, interval time.Duration) {
+	for range time.Tick(interval) {
+		report, err := store.CollectBlobs(ctx, s, blobs, store.DefaultBlobGracePeriod)
+		if err != nil {
+			log.Printf("cannot collect blobs: %v", err)
+			continue
+		}
+		if len(report.Deleted) > 0 {
+			log.Printf("deleted %d unreferenced blob contents", len(report.Deleted))
+		}
+	}
+}
+
+//seed creates a and the activities it is linked to, Supers before Subs.
+func seed(ctx context.Context, s store.Store, a *activity.Activity, done map[string]bool) error {
+	if done[a.Id.String()] {
+		return nil
+	}
+	done[a.Id.String()] = true
+	for _, super := range a.Supers {
+		if err := seed(ctx, s, super, done); err != nil {
+			return err
+		}
+	}
+	if _, err := s.Create(ctx, store.CreateRequest{ToCreate: *a}); err != nil {
+		return err
+	}
+	for _, sub := range a.Subs {
+		if err := seed(ctx, s, sub, done); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,172 @@
+//Package auth attributes HTTP requests to users.
+//
+//An Authenticator recognizes the credentials of one scheme in a request (see TokenAuthenticator,
+//JWTAuthenticator and BasicAuthenticator) and tells which entity they belong to. Authentication then reads
+//the Account of that entity from a Directory (e.g. Users) and selects the participation.UserContext the
+//request is done in: the one the credentials are bound to, the one in the UserContextHeader, or else the
+//first one of the account that is valid now. A context is only accepted during its ValidPeriod.
+package auth
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	//ErrorCodeBadCredentials is returned when a request has credentials that no Authenticator accepts.
+	ErrorCodeBadCredentials = "auth-bad-credentials"
+	//ErrorCodeUnknownEntity is returned when the credentials belong to an entity that has no account.
+	ErrorCodeUnknownEntity = "auth-unknown-entity"
+	//ErrorCodeUnknownContext is returned when the selected user context isn't one of the account.
+	ErrorCodeUnknownContext = "auth-unknown-context"
+	//ErrorCodeContextNotValid is returned when the selected user context isn't valid at the time of the
+	//request, or when no context of the account is.
+	ErrorCodeContextNotValid = "auth-context-not-valid"
+	ErrorCodeInvalidConfig   = "auth-invalid-config"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeBadCredentials, Status: http.StatusUnauthorized, Message: errcode.En("the credentials are not valid")},
+		errcode.Code{Code: ErrorCodeUnknownEntity, Status: http.StatusUnauthorized, Message: errcode.En("{entity} has no account")},
+		errcode.Code{Code: ErrorCodeUnknownContext, Status: http.StatusUnauthorized, Message: errcode.En("{entity} has no user context {userContext}")},
+		errcode.Code{Code: ErrorCodeContextNotValid, Status: http.StatusUnauthorized, Message: errcode.En("no valid user context of {entity}")},
+		errcode.Code{Code: ErrorCodeInvalidConfig, Status: http.StatusInternalServerError, Message: errcode.En("invalid authentication configuration")},
+	)
+}
+
+//UserContextHeader is the request header that selects the user context by its UserContextId.
+const UserContextHeader = "ALDB-User-Context"
+
+//Identity is what an Authenticator learns from credentials.
+type Identity struct {
+	Entity participation.EntityRef
+	//UserContextId is the id of the user context the credentials are bound to, if any.
+	UserContextId string
+}
+
+//Authenticator recognizes the credentials of one scheme.
+type Authenticator interface {
+	//Authenticate returns found false if the request has no credentials the Authenticator recognizes, and
+	//an ErrorCodeBadCredentials error if it recognizes them but they are not valid.
+	Authenticate(r *http.Request) (id Identity, found bool, err error)
+}
+
+//Account is an entity that can authenticate, with the contexts it can use the system in.
+type Account struct {
+	//Entity is a *participation.Person or a *participation.Organisation.
+	Entity participation.Entity
+	//Contexts are the contexts of the account, the first valid one being the default.
+	Contexts []participation.UserContext
+	//TokenDigests are the hex SHA-256 digests of the static API tokens of the account (see
+	//TokenAuthenticator).
+	TokenDigests []string
+	//PasswordDigest is the hex SHA-256 digest of the password of the account for BasicAuthenticator, if
+	//any.
+	PasswordDigest string
+}
+
+//Directory provides the accounts of the entities that can authenticate.
+type Directory interface {
+	//ReadAccount returns an ErrorCodeUnknownEntity error if the entity has no account.
+	ReadAccount(ctx context.Context, req ReadAccountRequest) (ReadAccountResponse, error)
+}
+
+type ReadAccountRequest struct {
+	Entity participation.EntityRef
+}
+
+type ReadAccountResponse struct {
+	Account Account
+}
+
+//Authentication resolves the user of requests with Authenticators that are tried in order, and a
+//Directory. It is safe for concurrent use if the Directory is.
+type Authentication struct {
+	directory      Directory
+	authenticators []Authenticator
+	now            func() time.Time
+}
+
+func New(d Directory, as ...Authenticator) *Authentication {
+	return &Authentication{directory: d, authenticators: as, now: time.Now}
+}
+
+//Authenticate returns the user of the request, with the selected context. It returns found false if the
+//request has no credentials at all, so that the request is anonymous.
+func (a *Authentication) Authenticate(r *http.Request) (u participation.User, found bool, err error) {
+	for _, authenticator := range a.authenticators {
+		id, found, err := authenticator.Authenticate(r)
+		if err != nil {
+			return participation.User{}, false, err
+		}
+		if found {
+			u, err := a.resolve(r, id)
+			return u, err == nil, err
+		}
+	}
+	if len(r.Header.Get("Authorization")) > 0 {
+		return participation.User{}, false, aldberr.New(ErrorCodeBadCredentials, "credentials not recognized", nil)
+	}
+	return participation.User{}, false, nil
+}
+
+func (a *Authentication) resolve(r *http.Request, id Identity) (participation.User, error) {
+	res, err := a.directory.ReadAccount(r.Context(), ReadAccountRequest{Entity: id.Entity})
+	if err != nil {
+		return participation.User{}, err
+	}
+	errDet := map[string]interface{}{"entity": id.Entity.ToName()}
+	selected := id.UserContextId
+	if header := r.Header.Get(UserContextHeader); len(header) > 0 {
+		if len(selected) > 0 && header != selected {
+			errDet["userContext"] = header
+			return participation.User{}, aldberr.New(ErrorCodeUnknownContext, "the credentials are bound to another user context", errDet)
+		}
+		selected = header
+	}
+	now := a.now()
+	for _, uc := range res.Account.Contexts {
+		if len(selected) == 0 && !uc.ValidPeriod.Contains(now) {
+			continue
+		}
+		if len(selected) > 0 && uc.UserContextId != selected {
+			continue
+		}
+		if !uc.ValidPeriod.Contains(now) {
+			errDet["userContext"] = selected
+			return participation.User{}, aldberr.New(ErrorCodeContextNotValid, "user context is not valid now", errDet)
+		}
+		uc.EntityRef = res.Account.Entity.EntityRef()
+		return participation.User{Entity: res.Account.Entity, Context: uc}, nil
+	}
+	if len(selected) > 0 {
+		errDet["userContext"] = selected
+		return participation.User{}, aldberr.New(ErrorCodeUnknownContext, "user context not found", errDet)
+	}
+	return participation.User{}, aldberr.New(ErrorCodeContextNotValid, "no user context of the entity is valid now", errDet)
+}
+
+//region context
+
+type contextKey int
+
+const userKey contextKey = iota
+
+//WithUser returns a context for operations done by the user.
+func WithUser(ctx context.Context, u participation.User) context.Context {
+	return context.WithValue(ctx, userKey, u)
+}
+
+//UserFrom returns the user of WithUser, if any.
+func UserFrom(ctx context.Context) (participation.User, bool) {
+	u, found := ctx.Value(userKey).(participation.User)
+	return u, found
+}
+
+//endregion
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/store/storetest"
+)
+
+const usersJSONFile = `{"users": [
+	{
+		"person": {"entity": "entities/alice", "givenName": "Alice", "familyName": "Doe"},
+		"contexts": [
+			{"id": "old", "validPeriod": {"endTime": "2020-01-01T00:00:00Z"}},
+			{"id": "doe", "organisation": {"entity": "entities/doe", "display": "Doe"}, "description": {"en-GB": "Working for Doe"}},
+			{"id": "future", "validPeriod": {"startTime": "2999-01-01T00:00:00Z"}}
+		],
+		"tokenDigests": ["` + "%ALICE_TOKEN%" + `"],
+		"passwordDigest": "` + "%ALICE_PASSWORD%" + `"
+	},
+	{
+		"person": {"entity": "entities/bob"},
+		"contexts": [{"id": "old", "validPeriod": {"endTime": "2020-01-01T00:00:00Z"}}],
+		"tokenDigests": ["` + "%BOB_TOKEN%" + `"]
+	}
+]}`
+
+func readTestUsers(t *testing.T) *Users {
+	t.Helper()
+	content := usersJSONFile
+	for placeholder, secret := range map[string]string{"%ALICE_TOKEN%": "alice-token", "%ALICE_PASSWORD%": "secret", "%BOB_TOKEN%": "bob-token"} {
+		content = strings.ReplaceAll(content, placeholder, Digest(secret))
+	}
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	u, err := ReadUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestAuthentication(t *testing.T) {
+	u := readTestUsers(t)
+	a := New(u, NewTokenAuthenticator(u), NewBasicAuthenticator(u))
+	for _, c := range []struct {
+		name          string
+		authorization string
+		basic         []string
+		userContext   string
+		entity        string
+		context       string
+		errCode       string
+	}{
+		{name: "anonymous"},
+		{name: "token", authorization: "Bearer alice-token", entity: "alice", context: "doe"},
+		{name: "lower case scheme", authorization: "bearer alice-token", entity: "alice", context: "doe"},
+		{name: "selected context", authorization: "Bearer alice-token", userContext: "doe", entity: "alice", context: "doe"},
+		{name: "expired context", authorization: "Bearer alice-token", userContext: "old", errCode: ErrorCodeContextNotValid},
+		{name: "future context", authorization: "Bearer alice-token", userContext: "future", errCode: ErrorCodeContextNotValid},
+		{name: "unknown context", authorization: "Bearer alice-token", userContext: "other", errCode: ErrorCodeUnknownContext},
+		{name: "no valid context", authorization: "Bearer bob-token", errCode: ErrorCodeContextNotValid},
+		{name: "unknown token", authorization: "Bearer mallory-token", errCode: ErrorCodeBadCredentials},
+		{name: "unknown scheme", authorization: "Digest username=alice", errCode: ErrorCodeBadCredentials},
+		{name: "basic", basic: []string{"alice", "secret"}, entity: "alice", context: "doe"},
+		{name: "basic entity name", basic: []string{"entities/alice", "secret"}, entity: "alice", context: "doe"},
+		{name: "wrong password", basic: []string{"alice", "guess"}, errCode: ErrorCodeBadCredentials},
+		{name: "no password", basic: []string{"bob", ""}, errCode: ErrorCodeBadCredentials},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/activities", nil)
+			if len(c.authorization) > 0 {
+				r.Header.Set("Authorization", c.authorization)
+			}
+			if c.basic != nil {
+				r.SetBasicAuth(c.basic[0], c.basic[1])
+			}
+			if len(c.userContext) > 0 {
+				r.Header.Set(UserContextHeader, c.userContext)
+			}
+			user, found, err := a.Authenticate(r)
+			if len(c.errCode) > 0 {
+				storetest.AssertErrorCode(t, err, c.errCode)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != (len(c.entity) > 0) {
+				t.Fatalf("expected found %t, got %t", len(c.entity) > 0, found)
+			}
+			if !found {
+				return
+			}
+			if user.Entity.EntityRef().EntityId != c.entity || user.Context.UserContextId != c.context {
+				t.Errorf("expected %s in %s, got %+v", c.entity, c.context, user)
+			}
+			if user.Context.EntityRef != user.Entity.EntityRef() {
+				t.Errorf("expected the context to refer to the user, got %+v", user.Context.UserContextRef)
+			}
+		})
+	}
+}
+
+func TestUsers(t *testing.T) {
+	u := readTestUsers(t)
+	res, err := u.ReadAccount(nil, ReadAccountRequest{Entity: participation.EntityRef{EntityId: "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	person, isPerson := res.Account.Entity.(*participation.Person)
+	if !isPerson || person.Name.Given != "Alice" {
+		t.Errorf("unexpected entity %+v", res.Account.Entity)
+	}
+	if doe := res.Account.Contexts[1]; doe.Organisation.Ref.EntityId != "doe" || doe.Description["en-GB"] != "Working for Doe" {
+		t.Errorf("unexpected context %+v", doe)
+	}
+	_, err = u.ReadAccount(nil, ReadAccountRequest{Entity: participation.EntityRef{EntityId: "mallory"}})
+	storetest.AssertErrorCode(t, err, ErrorCodeUnknownEntity)
+
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, []byte(`{"users": [{"contexts": []}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadUsers(path)
+	storetest.AssertErrorCode(t, err, ErrorCodeInvalidConfig)
+}
+
+//testKey is a private key with its public JWK.
+type testKey struct {
+	alg     string
+	private crypto.Signer
+	jwk     JWK
+}
+
+func newTestKeys(t *testing.T) []testKey {
+	t.Helper()
+	b64 := func(bts []byte) string {
+		return base64.RawURLEncoding.EncodeToString(bts)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []testKey{
+		{alg: "RS256", private: rsaKey, jwk: JWK{Kty: "RSA", Kid: "rsa", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())}},
+		{alg: "ES256", private: ecKey, jwk: JWK{Kty: "EC", Kid: "ec", Crv: "P-256", X: b64(ecKey.X.FillBytes(make([]byte, 32))), Y: b64(ecKey.Y.FillBytes(make([]byte, 32)))}},
+		{alg: "EdDSA", private: edKey, jwk: JWK{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: b64(edPublic)}},
+	}
+}
+
+//sign creates a JWT with the claims.
+func (k testKey) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	enc := func(v interface{}) string {
+		bts, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(bts)
+	}
+	signed := enc(map[string]string{"alg": k.alg, "kid": k.jwk.Kid, "typ": "JWT"}) + "." + enc(claims)
+	var signature []byte
+	var err error
+	switch key := k.private.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signed))
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		r, s, signErr := ecdsa.Sign(rand.Reader, key, digest[:])
+		signature, err = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), signErr
+	default:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = k.private.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWT(t *testing.T) {
+	keys := newTestKeys(t)
+	jwks := JWKS{}
+	for _, k := range keys {
+		jwks.Keys = append(jwks.Keys, k.jwk)
+	}
+	bts, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, bts, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := readTestUsers(t)
+	a := New(u, NewTokenAuthenticator(u), NewJWTAuthenticator(read, JWTOptions{Issuer: "https://idp.test", Audience: "aldb", Leeway: time.Minute}))
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{"sub": "entities/alice", "iss": "https://idp.test", "aud": []string{"other", "aldb"}, "exp": time.Now().Add(time.Hour).Unix()}
+	}
+	authenticate := func(token, userContext string) (participation.User, error) {
+		r := httptest.NewRequest(http.MethodGet, "/activities", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		if len(userContext) > 0 {
+			r.Header.Set(UserContextHeader, userContext)
+		}
+		user, _, err := a.Authenticate(r)
+		return user, err
+	}
+
+	for _, k := range keys {
+		user, err := authenticate(k.sign(t, valid()), "")
+		if err != nil {
+			t.Errorf("%s: %v", k.alg, err)
+		} else if user.Entity.EntityRef().EntityId != "alice" || user.Context.UserContextId != "doe" {
+			t.Errorf("%s: unexpected user %+v", k.alg, user)
+		}
+	}
+	if _, err := authenticate("alice-token", ""); err != nil {
+		t.Errorf("expected static tokens to work besides JWTs, got %v", err)
+	}
+
+	key := keys[0]
+	bound := valid()
+	bound[UserContextClaim] = "old"
+	_, err = authenticate(key.sign(t, bound), "")
+	storetest.AssertErrorCode(t, err, ErrorCodeContextNotValid)
+	bound[UserContextClaim] = "doe"
+	_, err = authenticate(key.sign(t, bound), "future")
+	storetest.AssertErrorCode(t, err, ErrorCodeUnknownContext)
+
+	for name, change := range map[string]func(map[string]interface{}){
+		"expired":       func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no exp":        func(c map[string]interface{}) { delete(c, "exp") },
+		"not yet valid": func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"wrong issuer":  func(c map[string]interface{}) { c["iss"] = "https://evil.test" },
+		"wrong aud":     func(c map[string]interface{}) { c["aud"] = "other" },
+		"no sub":        func(c map[string]interface{}) { delete(c, "sub") },
+	} {
+		claims := valid()
+		change(claims)
+		_, err := authenticate(key.sign(t, claims), "")
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+			continue
+		}
+		storetest.AssertErrorCode(t, err, ErrorCodeBadCredentials)
+	}
+
+	token := strings.Split(key.sign(t, valid()), ".")
+	bob := valid()
+	bob["sub"] = "entities/bob"
+	token[1] = strings.Split(key.sign(t, bob), ".")[1]
+	_, err = authenticate(strings.Join(token, "."), "")
+	storetest.AssertErrorCode(t, err, ErrorCodeBadCredentials)
+	other := keys[1]
+	other.jwk.Kid = key.jwk.Kid
+	_, err = authenticate(other.sign(t, valid()), "")
+	storetest.AssertErrorCode(t, err, ErrorCodeBadCredentials)
+
+	if _, err := (JWK{Kty: "EC", Crv: "P-256", X: keys[1].jwk.X, Y: keys[0].jwk.N[:43]}).PublicKey(); err == nil {
+		t.Errorf("expected a point that is not on the curve to be refused")
+	}
+}
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//UserContextClaim is the JWT claim that binds a token to a user context, by its UserContextId.
+const UserContextClaim = "user_context"
+
+//JWK is a public key of a JSON Web Key Set (RFC 7517). RSA, EC (P-256, P-384 and P-521) and OKP (Ed25519)
+//keys are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	//N and E are the modulus and exponent of RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	//Crv, X and Y are the curve and coordinates of EC keys, and the curve and key of OKP keys.
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+//ReadJWKS reads a JSON Web Key Set from a file, and checks that its keys can be used.
+func ReadJWKS(path string) (JWKS, error) {
+	errDet := map[string]interface{}{"path": path}
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return JWKS{}, aldberr.Wrap(err, ErrorCodeInvalidConfig, "cannot read JWKS", errDet)
+	}
+	out := JWKS{}
+	if err := json.Unmarshal(bts, &out); err != nil {
+		return JWKS{}, aldberr.Wrap(err, ErrorCodeInvalidConfig, "cannot unmarshal JWKS", errDet)
+	}
+	for _, k := range out.Keys {
+		if _, err := k.PublicKey(); err != nil {
+			return JWKS{}, err
+		}
+	}
+	return out, nil
+}
+
+//PublicKey returns the *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey of the JWK.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	errDet := map[string]interface{}{"kid": k.Kid, "kty": k.Kty}
+	invalid := func(err error) error {
+		return aldberr.Wrap(err, ErrorCodeInvalidConfig, "invalid JWK", errDet)
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, invalid(err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, invalid(err)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, found := curves[k.Crv]
+		if !found {
+			errDet["crv"] = k.Crv
+			return nil, aldberr.New(ErrorCodeInvalidConfig, "unsupported JWK curve", errDet)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, invalid(err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, invalid(err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, aldberr.New(ErrorCodeInvalidConfig, "invalid JWK: point is not on the curve", errDet)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, invalid(err)
+		}
+		if k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			errDet["crv"] = k.Crv
+			return nil, aldberr.New(ErrorCodeInvalidConfig, "unsupported JWK curve", errDet)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, aldberr.New(ErrorCodeInvalidConfig, "unsupported JWK key type", errDet)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	bts, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(bts) == 0 {
+		return nil, aldberr.New(ErrorCodeInvalidConfig, "empty JWK parameter", nil)
+	}
+	return new(big.Int).SetBytes(bts), nil
+}
+
+//JWTOptions are the claims a JWTAuthenticator requires, besides a valid "exp".
+type JWTOptions struct {
+	//Issuer is the required "iss" claim, if not empty.
+	Issuer string
+	//Audience must be one of the "aud" claim, if not empty.
+	Audience string
+	//Leeway is the clock skew that is tolerated on "exp" and "nbf".
+	Leeway time.Duration
+}
+
+//JWTAuthenticator accepts JSON Web Tokens (RFC 7519) in "Authorization: Bearer <token>" that are signed with
+//a key of a JWKS (RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA). The "sub" claim
+//is the name of the entity (e.g. "entities/alice") or only its EntityId, and the UserContextClaim can bind
+//the token to a user context. Bearer tokens that aren't JWTs are left to the next Authenticator.
+type JWTAuthenticator struct {
+	keys    JWKS
+	options JWTOptions
+	now     func() time.Time
+}
+
+func NewJWTAuthenticator(keys JWKS, options JWTOptions) *JWTAuthenticator {
+	return &JWTAuthenticator{keys: keys, options: options, now: time.Now}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Sub         string          `json:"sub"`
+	Iss         string          `json:"iss"`
+	Aud         json.RawMessage `json:"aud"`
+	Exp         *float64        `json:"exp"`
+	Nbf         *float64        `json:"nbf"`
+	UserContext string          `json:"user_context"`
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (Identity, bool, error) {
+	token, found := bearerToken(r)
+	parts := strings.Split(token, ".")
+	if !found || len(parts) != 3 {
+		return Identity{}, false, nil
+	}
+	bad := func(msg string, err error) (Identity, bool, error) {
+		if err != nil {
+			return Identity{}, false, aldberr.Wrap(err, ErrorCodeBadCredentials, "invalid bearer token: "+msg, nil)
+		}
+		return Identity{}, false, aldberr.New(ErrorCodeBadCredentials, "invalid bearer token: "+msg, nil)
+	}
+	header := jwtHeader{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return bad("cannot decode header", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return bad("cannot decode signature", err)
+	}
+	if !a.verify(header, []byte(parts[0]+"."+parts[1]), signature) {
+		return bad("signature not verified", nil)
+	}
+	claims := jwtClaims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return bad("cannot decode claims", err)
+	}
+	now := a.now()
+	switch {
+	case claims.Exp == nil:
+		return bad("no exp claim", nil)
+	case now.After(unixTime(*claims.Exp).Add(a.options.Leeway)):
+		return bad("expired", nil)
+	case claims.Nbf != nil && now.Before(unixTime(*claims.Nbf).Add(-a.options.Leeway)):
+		return bad("not valid yet", nil)
+	case len(a.options.Issuer) > 0 && claims.Iss != a.options.Issuer:
+		return bad("wrong issuer", nil)
+	case len(a.options.Audience) > 0 && !hasAudience(claims.Aud, a.options.Audience):
+		return bad("wrong audience", nil)
+	case len(claims.Sub) == 0:
+		return bad("no sub claim", nil)
+	}
+	return Identity{Entity: parseEntity(claims.Sub), UserContextId: claims.UserContext}, true, nil
+}
+
+//verify checks the signature with the keys that match the header.
+func (a *JWTAuthenticator) verify(header jwtHeader, signed, signature []byte) bool {
+	for _, k := range a.keys.Keys {
+		if len(header.Kid) > 0 && k.Kid != header.Kid || len(k.Alg) > 0 && k.Alg != header.Alg {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err == nil && verifySignature(header.Alg, pub, signed, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+func verifySignature(alg string, pub crypto.PublicKey, signed, signature []byte) bool {
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	if alg == "EdDSA" {
+		key, isEd25519 := pub.(ed25519.PublicKey)
+		return isEd25519 && ed25519.Verify(key, signed, signature)
+	}
+	if len(alg) != 5 {
+		return false
+	}
+	hash, found := hashes[alg[2:]]
+	if !found {
+		return false
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+		case "PS":
+			return rsa.VerifyPSS(key, hash, digest, signature, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		bits := key.Curve.Params().BitSize
+		size := (bits + 7) / 8
+		curveHashes := map[int]crypto.Hash{256: crypto.SHA256, 384: crypto.SHA384, 521: crypto.SHA512}
+		if alg[:2] != "ES" || curveHashes[bits] != hash || len(signature) != 2*size {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	bts, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bts, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+//hasAudience checks the "aud" claim, which is a string or an array of strings.
+func hasAudience(raw json.RawMessage, audience string) bool {
+	single := ""
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return single == audience
+	}
+	multiple := []string{}
+	if err := json.Unmarshal(raw, &multiple); err != nil {
+		return false
+	}
+	for _, candidate := range multiple {
+		if candidate == audience {
+			return true
+		}
+	}
+	return false
+}
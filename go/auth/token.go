@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//TokenAuthenticator accepts the static API tokens of Users in "Authorization: Bearer <token>". Bearer
+//tokens it doesn't know are left to the next Authenticator, e.g. a JWTAuthenticator.
+type TokenAuthenticator struct {
+	users *Users
+}
+
+func NewTokenAuthenticator(u *Users) *TokenAuthenticator {
+	return &TokenAuthenticator{users: u}
+}
+
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (Identity, bool, error) {
+	token, found := bearerToken(r)
+	if !found {
+		return Identity{}, false, nil
+	}
+	e, found := a.users.byTokenDigest(Digest(token))
+	return Identity{Entity: e}, found, nil
+}
+
+//BasicAuthenticator accepts HTTP basic auth with the password of one of the Users. The user name is the
+//name of the entity (e.g. "entities/alice") or only its EntityId. As passwords are sent with every request,
+//it is meant for development only.
+type BasicAuthenticator struct {
+	users *Users
+}
+
+func NewBasicAuthenticator(u *Users) *BasicAuthenticator {
+	return &BasicAuthenticator{users: u}
+}
+
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (Identity, bool, error) {
+	name, password, found := r.BasicAuth()
+	if !found {
+		return Identity{}, false, nil
+	}
+	e := parseEntity(name)
+	res, err := a.users.ReadAccount(r.Context(), ReadAccountRequest{Entity: e})
+	if err != nil || len(res.Account.PasswordDigest) == 0 || !digestsEqual(res.Account.PasswordDigest, Digest(password)) {
+		return Identity{}, false, aldberr.New(ErrorCodeBadCredentials, "wrong user name or password", map[string]interface{}{"user": name})
+	}
+	return Identity{Entity: e}, true, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || strings.ToLower(header[:len(prefix)]) != prefix {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+//parseEntity parses the name of an entity, or else takes s as the EntityId of an entity of this host.
+func parseEntity(s string) participation.EntityRef {
+	out := participation.EntityRef{}
+	if err := out.FromName(s); err == nil && out.IsComplete() {
+		return out
+	}
+	return participation.EntityRef{EntityId: s}
+}
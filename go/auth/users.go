@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/datetime"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+)
+
+//Users is a Directory of accounts that are configured in a file, e.g.
+//
+//	{"users": [{
+//		"person": {"entity": "entities/alice", "givenName": "Alice", "familyName": "Doe"},
+//		"contexts": [{"id": "doe", "organisation": {"entity": "entities/doe", "display": "Doe"},
+//			"validPeriod": {"endTime": "2030-01-01T00:00:00Z"}}],
+//		"tokenDigests": ["9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"]
+//	}]}
+type Users struct {
+	accounts []Account
+}
+
+type usersJSON struct {
+	Users []accountJSON `json:"users"`
+}
+
+type accountJSON struct {
+	Person         *participation.Person       `json:"person,omitempty"`
+	Organisation   *participation.Organisation `json:"organisation,omitempty"`
+	Contexts       []userContextJSON           `json:"contexts"`
+	TokenDigests   []string                    `json:"tokenDigests,omitempty"`
+	PasswordDigest string                      `json:"passwordDigest,omitempty"`
+}
+
+type userContextJSON struct {
+	Id           string                      `json:"id"`
+	Organisation *participation.Organisation `json:"organisation,omitempty"`
+	ValidPeriod  *datetime.Period            `json:"validPeriod,omitempty"`
+	Description  lang.LocalizableString      `json:"description,omitempty"`
+}
+
+func NewUsers(accounts ...Account) *Users {
+	return &Users{accounts: accounts}
+}
+
+//ReadUsers reads Users from a JSON file.
+func ReadUsers(path string) (*Users, error) {
+	errDet := map[string]interface{}{"path": path}
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalidConfig, "cannot read users", errDet)
+	}
+	uj := usersJSON{}
+	if err := json.Unmarshal(bts, &uj); err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalidConfig, "cannot unmarshal users", errDet)
+	}
+	out := &Users{}
+	for i, aj := range uj.Users {
+		a := Account{TokenDigests: aj.TokenDigests, PasswordDigest: aj.PasswordDigest}
+		switch {
+		case aj.Person != nil:
+			a.Entity = aj.Person
+		case aj.Organisation != nil:
+			a.Entity = aj.Organisation
+		}
+		if a.Entity == nil || !a.Entity.EntityRef().IsComplete() {
+			errDet["user"] = i
+			return nil, aldberr.New(ErrorCodeInvalidConfig, "user has no person or organisation with an entity", errDet)
+		}
+		for _, cj := range aj.Contexts {
+			uc := participation.UserContext{
+				UserContextRef: participation.UserContextRef{EntityRef: a.Entity.EntityRef(), UserContextId: cj.Id},
+				Description:    cj.Description,
+			}
+			if cj.Organisation != nil {
+				uc.Organisation = *cj.Organisation
+			}
+			if cj.ValidPeriod != nil {
+				uc.ValidPeriod = *cj.ValidPeriod
+			}
+			a.Contexts = append(a.Contexts, uc)
+		}
+		out.accounts = append(out.accounts, a)
+	}
+	return out, nil
+}
+
+func (u *Users) ReadAccount(ctx context.Context, req ReadAccountRequest) (ReadAccountResponse, error) {
+	for _, a := range u.accounts {
+		if a.Entity.EntityRef() == req.Entity {
+			return ReadAccountResponse{Account: a}, nil
+		}
+	}
+	return ReadAccountResponse{}, aldberr.New(ErrorCodeUnknownEntity, "entity has no account", map[string]interface{}{"entity": req.Entity.ToName()})
+}
+
+//byTokenDigest returns the entity with a token with the digest.
+func (u *Users) byTokenDigest(digest string) (participation.EntityRef, bool) {
+	for _, a := range u.accounts {
+		for _, candidate := range a.TokenDigests {
+			if digestsEqual(candidate, digest) {
+				return a.Entity.EntityRef(), true
+			}
+		}
+	}
+	return participation.EntityRef{}, false
+}
+
+//Digest returns the hex SHA-256 digest of a token or password, as used in Account.
+func Digest(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+//digestsEqual compares digests in constant time.
+func digestsEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -1,6 +1,9 @@
 package aldberr
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 func New(code, msg string, details map[string]interface{}) CanvigaError {
 	return CanvigaError{code: code, msg: msg, details: details}
@@ -33,6 +36,33 @@ func (e CanvigaError) Details() map[string]interface{} {
 	return e.details
 }
 
+//Unwrap returns the error that was wrapped with Wrap, if any, so that errors.Is and errors.As see the whole
+//chain.
+func (e CanvigaError) Unwrap() error {
+	return e.inner
+}
+
+//Is makes errors.Is(err, target) match a CanvigaError in the chain of err with the same code as target, e.g.
+//errors.Is(err, aldberr.New(store.ErrorCodeNotFound, "", nil)).
+func (e CanvigaError) Is(target error) bool {
+	t, isCanviga := target.(CanvigaError)
+	return isCanviga && len(t.code) > 0 && t.code == e.code
+}
+
+//IsCode checks that err or an error it wraps is a CanvigaError with the code.
+func IsCode(err error, code string) bool {
+	return errors.Is(err, CanvigaError{code: code})
+}
+
+//CodeOf returns the code of the outermost CanvigaError in the chain of err.
+func CodeOf(err error) (string, bool) {
+	cErr := CanvigaError{}
+	if !errors.As(err, &cErr) {
+		return "", false
+	}
+	return cErr.code, true
+}
+
 func (e CanvigaError) Det(key string, val interface{}) CanvigaError {
 	if e.details == nil {
 		e.details = map[string]interface{}{key: val}
@@ -0,0 +1,34 @@
+package aldberr
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestWrapChain(t *testing.T) {
+	_, osErr := os.Open("/does/not/exist")
+	inner := Wrap(osErr, "store-fs-error", "cannot open activity file", nil)
+	outer := Wrap(inner, "server-internal", "cannot read activity", map[string]interface{}{"id": "42"})
+
+	if !errors.Is(outer, fs.ErrNotExist) {
+		t.Errorf("expected the os error to be in the chain")
+	}
+	if !errors.Is(outer, New("store-fs-error", "", nil)) || !IsCode(outer, "server-internal") {
+		t.Errorf("expected both codes to be in the chain")
+	}
+	if IsCode(outer, "store-not-found") || errors.Is(outer, CanvigaError{}) {
+		t.Errorf("expected other codes not to be in the chain")
+	}
+	pathErr := &fs.PathError{}
+	if !errors.As(outer, &pathErr) || pathErr.Path != "/does/not/exist" {
+		t.Errorf("expected to find the *fs.PathError, got %v", pathErr)
+	}
+	if code, found := CodeOf(outer); !found || code != "server-internal" {
+		t.Errorf("expected the outermost code, got %q", code)
+	}
+	if _, found := CodeOf(osErr); found {
+		t.Errorf("expected no code for a plain error")
+	}
+}
@@ -0,0 +1,129 @@
+//Package errcode is the registry of the codes of aldberr.CanvigaError (the aldberr.CodeSystCanviga code
+//system). Every package registers the codes it declares in an init function, with the HTTP status
+//(aldberr.CodeSysHttpStatus) they map to and a localizable message template, e.g.
+//
+//	func init() {
+//		errcode.Register(errcode.Code{Code: ErrorCodeNotFound, Status: http.StatusNotFound, Message: errcode.En("activity not found")})
+//	}
+//
+//The codes of common/lang are registered here, as lang cannot import errcode.
+package errcode
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+)
+
+//Code describes an error code.
+type Code struct {
+	Code string
+	//Status is the HTTP status of errors with the code.
+	Status int
+	//Message is the template of a summary of the error, in which "{key}" is replaced by the detail with that
+	//key. Placeholders of details that an error doesn't have are left as they are.
+	Message lang.Localizable
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Code{}
+)
+
+//Register adds codes to the registry. It panics if a code is registered twice or lacks a Status or
+//Message, as that is a programming error.
+func Register(codes ...Code) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, c := range codes {
+		if len(c.Code) == 0 || c.Status < 100 || c.Status > 599 || c.Message == nil {
+			panic(fmt.Sprintf("errcode: incomplete registration of code %q", c.Code))
+		}
+		if _, found := registry[c.Code]; found {
+			panic(fmt.Sprintf("errcode: code %q registered twice", c.Code))
+		}
+		registry[c.Code] = c
+	}
+}
+
+//Lookup returns the registered Code.
+func Lookup(code string) (Code, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, found := registry[code]
+	return c, found
+}
+
+//Codes returns all registered codes, ordered by code.
+func Codes() []Code {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	out := make([]Code, 0, len(registry))
+	for _, c := range registry {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Code < out[j].Code })
+	return out
+}
+
+//En returns a message template in English, which is also used for other languages.
+func En(template string) lang.LocalizableString {
+	return lang.LocalizableString{lang.LangEn: template, lang.LangAny: template}
+}
+
+//Localize returns the message of the code in the language, with the details filled in.
+func (c Code) Localize(l lang.Lang, details map[string]interface{}) (string, error) {
+	template, err := c.Message.Localize(l, nil)
+	if err != nil {
+		return "", err
+	}
+	return fill(template, details), nil
+}
+
+//Negotiate returns the message of the code in the language that suits the preferences best (see
+//lang.Negotiate), with the details filled in.
+func (c Code) Negotiate(p lang.Preferences, details map[string]interface{}) (string, lang.Lang, error) {
+	template, l, err := lang.Negotiate(c.Message, p, nil)
+	if err != nil {
+		return "", "", err
+	}
+	return fill(template, details), l, nil
+}
+
+func fill(template string, details map[string]interface{}) string {
+	if len(details) == 0 {
+		return template
+	}
+	replacements := make([]string, 0, 2*len(details))
+	for k, v := range details {
+		replacements = append(replacements, "{"+k+"}", fmt.Sprint(v))
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+//StatusOf returns the HTTP status of the outermost aldberr.CanvigaError in the chain of err, or 500 if it
+//has none or its code isn't registered.
+func StatusOf(err error) int {
+	code, found := aldberr.CodeOf(err)
+	if !found {
+		return http.StatusInternalServerError
+	}
+	c, found := Lookup(code)
+	if !found {
+		return http.StatusInternalServerError
+	}
+	return c.Status
+}
+
+func init() {
+	Register(
+		Code{Code: lang.ErrorCodeLanguageNotFound, Status: http.StatusNotAcceptable, Message: En("not available in language {lang}")},
+		Code{Code: lang.ErrorCodeInvalidLocalizableString, Status: http.StatusBadRequest, Message: En("invalid localizable string")},
+		Code{Code: lang.ErrorCodeInvalidLang, Status: http.StatusBadRequest, Message: En("invalid language tag {lang}")},
+	)
+}
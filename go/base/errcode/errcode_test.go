@@ -0,0 +1,161 @@
+package errcode_test
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	_ "github.com/vital-dhaveloose/aldb/access"
+	_ "github.com/vital-dhaveloose/aldb/activity"
+	_ "github.com/vital-dhaveloose/aldb/auth"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	_ "github.com/vital-dhaveloose/aldb/blobstore"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+	_ "github.com/vital-dhaveloose/aldb/common/mediatype"
+	_ "github.com/vital-dhaveloose/aldb/graph"
+	_ "github.com/vital-dhaveloose/aldb/manifest"
+	_ "github.com/vital-dhaveloose/aldb/query"
+	_ "github.com/vital-dhaveloose/aldb/selection"
+	_ "github.com/vital-dhaveloose/aldb/server"
+	_ "github.com/vital-dhaveloose/aldb/store"
+)
+
+//declaredCodes returns the values of the ErrorCode constants in the module, by the file they are declared in.
+func declaredCodes(t *testing.T) map[string]string {
+	t.Helper()
+	out := map[string]string{}
+	root := filepath.Join("..", "..")
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+		if err != nil {
+			return err
+		}
+		for _, decl := range f.Decls {
+			gd, isGen := decl.(*ast.GenDecl)
+			if !isGen || gd.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for i, name := range vs.Names {
+					if !strings.HasPrefix(name.Name, "ErrorCode") || i >= len(vs.Values) {
+						continue
+					}
+					lit, isLit := vs.Values[i].(*ast.BasicLit)
+					if !isLit || lit.Kind != token.STRING {
+						continue
+					}
+					code, _ := strconv.Unquote(lit.Value)
+					out[code] = path
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+var placeholder = regexp.MustCompile(`\{[A-Za-z0-9]+\}`)
+
+func TestEveryCodeIsRegistered(t *testing.T) {
+	declared := declaredCodes(t)
+	if len(declared) < 60 {
+		t.Fatalf("expected to find the codes of the module, found %d", len(declared))
+	}
+	for code, path := range declared {
+		c, found := errcode.Lookup(code)
+		if !found {
+			t.Errorf("code %s of %s is not registered", code, path)
+			continue
+		}
+		if len(http.StatusText(c.Status)) == 0 {
+			t.Errorf("code %s has unknown status %d", code, c.Status)
+		}
+		for _, l := range []lang.Lang{lang.LangEn, lang.LangAny} {
+			msg, err := c.Localize(l, nil)
+			if err != nil || len(msg) == 0 {
+				t.Errorf("code %s has no message in %s: %v", code, l, err)
+			}
+		}
+		details := map[string]interface{}{}
+		for _, key := range placeholder.FindAllString(mustLocalize(t, c), -1) {
+			details[strings.Trim(key, "{}")] = "x"
+		}
+		if msg, _ := c.Localize(lang.LangEn, details); placeholder.MatchString(msg) {
+			t.Errorf("code %s: placeholders left in %q", code, msg)
+		}
+		p := errcode.NewProblem(aldberr.Wrap(errors.New("cause"), code, "occurrence", details), lang.Prefer(lang.LangEn))
+		detail := "occurrence"
+		if c.Status >= http.StatusInternalServerError {
+			detail = ""
+		}
+		if p.Status != c.Status || p.Code != code || p.Type != "urn:canviga-error-codes:"+code || p.Detail != detail || len(p.Title) == 0 {
+			t.Errorf("code %s: unexpected problem %+v", code, p)
+		}
+		if c.Status >= http.StatusInternalServerError && p.Details != nil {
+			t.Errorf("code %s: expected the details not to be disclosed, got %v", code, p.Details)
+		}
+	}
+	for _, c := range errcode.Codes() {
+		if _, found := declared[c.Code]; !found {
+			t.Errorf("code %s is registered, but not declared", c.Code)
+		}
+	}
+}
+
+func mustLocalize(t *testing.T, c errcode.Code) string {
+	t.Helper()
+	msg, err := c.Localize(lang.LangEn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestProblem(t *testing.T) {
+	err := aldberr.New("access-denied", "permission denied: no participation", map[string]interface{}{
+		"entity": "entities/bob", "activity": "activities/x", "permission": "write",
+	})
+	p := errcode.NewProblem(aldberr.Wrap(err, "access-denied", "cannot create version", nil), lang.Prefer(lang.LangEn))
+	if p.Status != http.StatusForbidden || p.Detail != "cannot create version" {
+		t.Errorf("expected the outermost error to be described, got %+v", p)
+	}
+	p = errcode.NewProblem(err, lang.ParseAcceptLanguage("nl-BE, en-GB;q=0.5"))
+	if p.Title != "entities/bob doesn't have the write permission for activities/x" || p.Lang != lang.LangEn {
+		t.Errorf("unexpected title %q in %q", p.Title, p.Lang)
+	}
+	if p = errcode.NewProblem(errors.New("boom"), nil); p.Status != http.StatusInternalServerError || p.Type != "about:blank" || len(p.Detail) > 0 {
+		t.Errorf("unexpected problem for a plain error %+v", p)
+	}
+	if p = errcode.NewProblem(aldberr.New("unregistered-code", "?", nil), nil); p.Status != http.StatusInternalServerError || p.Code != "unregistered-code" {
+		t.Errorf("unexpected problem for an unregistered code %+v", p)
+	}
+	if status := errcode.StatusOf(aldberr.Wrap(os.ErrNotExist, "store-not-found", "not found", nil)); status != http.StatusNotFound {
+		t.Errorf("unexpected status %d", status)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering a code twice to panic")
+		}
+	}()
+	errcode.Register(errcode.Code{Code: "store-not-found", Status: http.StatusNotFound, Message: errcode.En("again")})
+}
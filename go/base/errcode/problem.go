@@ -0,0 +1,67 @@
+package errcode
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+)
+
+//ProblemMediaType is the media type of a Problem.
+const ProblemMediaType = "application/problem+json"
+
+//Problem is a problem details object (RFC 7807), extended with the code and the details of the error and the
+//id of the request it occurred in.
+type Problem struct {
+	//Type is TypeURI of the code, or "about:blank" for errors without a code.
+	Type string `json:"type"`
+	//Title is the localized message of the code.
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	//Detail is the message of the error itself, which is in English.
+	Detail    string                 `json:"detail,omitempty"`
+	Instance  string                 `json:"instance,omitempty"`
+	Code      string                 `json:"code,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestId string                 `json:"requestId,omitempty"`
+	//Lang is the language of Title, or lang.LangAny if it isn't known.
+	Lang lang.Lang `json:"-"`
+}
+
+//TypeURI returns the URI that identifies the code as problem type, e.g. "urn:canviga-error-codes:store-not-found".
+func TypeURI(code string) string {
+	return "urn:" + string(aldberr.CodeSystCanviga) + ":" + code
+}
+
+//NewProblem describes err, using the outermost aldberr.CanvigaError in its chain, in the language that suits
+//the preferences best. Errors with codes that aren't registered have status 500. The message and the details
+//of errors with a status of 500 or more, and errors without a code, aren't disclosed, as they can reveal e.g.
+//paths on the server, so log those instead.
+func NewProblem(err error, p lang.Preferences) Problem {
+	cErr := aldberr.CanvigaError{}
+	if !errors.As(err, &cErr) {
+		return Problem{Type: "about:blank", Title: http.StatusText(http.StatusInternalServerError), Status: http.StatusInternalServerError, Lang: lang.LangEn}
+	}
+	out := Problem{Type: TypeURI(cErr.Code()), Status: http.StatusInternalServerError, Detail: cErr.Message(), Code: cErr.Code(), Details: cErr.Details(), Lang: lang.LangEn}
+	c, found := Lookup(cErr.Code())
+	if !found {
+		out.Title = http.StatusText(out.Status)
+		out.Detail, out.Details = "", nil
+		return out
+	}
+	out.Status = c.Status
+	title, l, err := c.Negotiate(p, cErr.Details())
+	if err != nil {
+		title, err = c.Localize(lang.LangEn, cErr.Details())
+		l = lang.LangEn
+	}
+	if err != nil {
+		title, l = http.StatusText(c.Status), lang.LangEn
+	}
+	out.Title, out.Lang = title, l
+	if out.Status >= http.StatusInternalServerError {
+		out.Detail, out.Details = "", nil
+	}
+	return out
+}
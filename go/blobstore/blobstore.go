@@ -0,0 +1,230 @@
+//Package blobstore keeps blob content addressed by its SHA-256 digest, so that identical content (e.g. the
+//same picture in a document and in a slide deck, or the unchanged blob of a new version) is stored once.
+//
+//Content is never referenced from within a blob store: the activity stores refer to it by the SHA256 of
+//blob.BlobManifest. Content that is no longer referenced is removed by Collect (mark-and-sweep), and Verify
+//re-hashes stored content to detect corruption.
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	ErrorCodeNotFound = "blobstore-not-found"
+	//ErrorCodeInvalidDigest is returned for a digest that isn't a lowercase hex encoded SHA-256.
+	ErrorCodeInvalidDigest = "blobstore-invalid-digest"
+	ErrorCodeFilesystem    = "blobstore-fs-error"
+)
+
+//Codes of the problems reported by Verify.
+const (
+	//ErrorCodeCorrupt indicates stored content that doesn't match its digest. The details contain the
+	//"sha256" under which it is stored and the "actual" digest of the content.
+	ErrorCodeCorrupt = "blobstore-corrupt"
+	//ErrorCodeMissing indicates referenced content that isn't stored.
+	ErrorCodeMissing = "blobstore-missing"
+	//ErrorCodeUnreadable indicates stored content that cannot be read.
+	ErrorCodeUnreadable = "blobstore-unreadable"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeNotFound, Status: http.StatusNotFound, Message: errcode.En("blob content not found")},
+		errcode.Code{Code: ErrorCodeInvalidDigest, Status: http.StatusBadRequest, Message: errcode.En("invalid SHA-256 digest")},
+		errcode.Code{Code: ErrorCodeFilesystem, Status: http.StatusInternalServerError, Message: errcode.En("cannot access the blob content on the filesystem")},
+		errcode.Code{Code: ErrorCodeCorrupt, Status: http.StatusInternalServerError, Message: errcode.En("stored blob content {sha256} doesn't match its digest")},
+		errcode.Code{Code: ErrorCodeMissing, Status: http.StatusInternalServerError, Message: errcode.En("referenced blob content {sha256} isn't stored")},
+		errcode.Code{Code: ErrorCodeUnreadable, Status: http.StatusInternalServerError, Message: errcode.En("stored blob content {sha256} cannot be read")},
+	)
+}
+
+//Store keeps content by its SHA-256 digest. Implementations must be safe for concurrent use.
+type Store interface {
+	Put(ctx context.Context, req PutRequest) (PutResponse, error)
+	Get(ctx context.Context, req GetRequest) (GetResponse, error)
+	Delete(ctx context.Context, req DeleteRequest) (DeleteResponse, error)
+	List(ctx context.Context, req ListRequest) (ListResponse, error)
+}
+
+//PutRequest stores the content of a rendition. If content with the same digest is already stored, it is kept
+//instead (and its Stored time is updated).
+type PutRequest struct {
+	//Rendition is the rendition of which to store the Content. If its Manifest has a SHA256, the content must
+	//match it, see blob.Ingest.
+	Rendition blob.Rendition
+}
+
+type PutResponse struct {
+	//Rendition is the rendition with the Size and SHA256 of its Manifest computed, and the stored content as
+	//Content.
+	Rendition blob.Rendition
+}
+
+type GetRequest struct {
+	SHA256 string
+}
+
+type GetResponse struct {
+	//Content is the stored content, of which the readers implement io.Seeker.
+	Content blob.Content
+	Info    Info
+}
+
+type DeleteRequest struct {
+	SHA256 string
+}
+
+type DeleteResponse struct {
+}
+
+type ListRequest struct {
+}
+
+type ListResponse struct {
+	//Infos describes all stored content, sorted by digest.
+	Infos []Info
+}
+
+//Info describes stored content.
+type Info struct {
+	SHA256 string
+	Size   int64
+	//Stored is the last time the content was put.
+	Stored time.Time
+}
+
+//region verify and collect
+
+type VerifyReport struct {
+	//Verified is the number of stored contents that were re-hashed.
+	Verified int
+	//Problems found, each with the "sha256" of the content in its details.
+	Problems []aldberr.CanvigaError
+}
+
+//Verify re-hashes all content in s and reports the content that doesn't match its digest. Referenced
+//content that isn't stored is reported as well, unless referenced is nil.
+func Verify(ctx context.Context, s Store, referenced map[string]bool) (VerifyReport, error) {
+	list, err := s.List(ctx, ListRequest{})
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	report := VerifyReport{}
+	stored := make(map[string]bool, len(list.Infos))
+	for _, info := range list.Infos {
+		if err := ctx.Err(); err != nil {
+			return VerifyReport{}, err
+		}
+		stored[info.SHA256] = true
+		actual, err := hash(ctx, s, info.SHA256)
+		report.Verified++
+		switch {
+		case err != nil:
+			report.Problems = append(report.Problems, aldberr.New(ErrorCodeUnreadable, "content cannot be read", map[string]interface{}{"sha256": info.SHA256, "error": err.Error()}))
+		case actual != info.SHA256:
+			report.Problems = append(report.Problems, aldberr.New(ErrorCodeCorrupt, "content doesn't match its digest", map[string]interface{}{"sha256": info.SHA256, "actual": actual}))
+		}
+	}
+	for _, sha := range sortedKeys(referenced) {
+		if !stored[sha] {
+			report.Problems = append(report.Problems, aldberr.New(ErrorCodeMissing, "referenced content isn't stored", map[string]interface{}{"sha256": sha}))
+		}
+	}
+	return report, nil
+}
+
+type CollectReport struct {
+	//Deleted describes the content that was deleted.
+	Deleted []Info
+	//Kept is the number of stored contents that were kept.
+	Kept int
+}
+
+//Collect deletes the content in s that isn't referenced and was stored before the given time.
+//
+//Content is put before the activity version that refers to it is created, so content that is being written
+//while the references are gathered must be spared: before should be the time at which gathering the
+//references started, minus a grace period that is longer than any create takes.
+func Collect(ctx context.Context, s Store, referenced map[string]bool, before time.Time) (CollectReport, error) {
+	list, err := s.List(ctx, ListRequest{})
+	if err != nil {
+		return CollectReport{}, err
+	}
+	report := CollectReport{}
+	for _, info := range list.Infos {
+		if referenced[info.SHA256] || !info.Stored.Before(before) {
+			report.Kept++
+			continue
+		}
+		if _, err := s.Delete(ctx, DeleteRequest{SHA256: info.SHA256}); err != nil {
+			if !aldberr.IsCode(err, ErrorCodeNotFound) {
+				return report, err
+			}
+		}
+		report.Deleted = append(report.Deleted, info)
+	}
+	return report, nil
+}
+
+func hash(ctx context.Context, s Store, sha string) (string, error) {
+	got, err := s.Get(ctx, GetRequest{SHA256: sha})
+	if err != nil {
+		return "", err
+	}
+	rc, err := got.Content.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//endregion
+
+//IsDigest checks that sha is a lowercase hex encoded SHA-256 digest.
+func IsDigest(sha string) bool {
+	if len(sha) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range sha {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func checkDigest(sha string) error {
+	if !IsDigest(sha) {
+		return aldberr.New(ErrorCodeInvalidDigest, "invalid SHA-256 digest", map[string]interface{}{"sha256": sha})
+	}
+	return nil
+}
+
+func notFound(sha string) error {
+	return aldberr.New(ErrorCodeNotFound, "content not found", map[string]interface{}{"sha256": sha})
+}
+
+func sortedKeys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
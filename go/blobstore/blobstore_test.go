@@ -0,0 +1,188 @@
+package blobstore_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/blobstore"
+	"github.com/vital-dhaveloose/aldb/common/mediatype"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) blobstore.Store { return blobstore.NewMemoryStore() })
+}
+
+func TestFilesystemStore(t *testing.T) {
+	testStore(t, func(t *testing.T) blobstore.Store {
+		s, err := blobstore.NewFilesystemStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func testStore(t *testing.T, newStore func(t *testing.T) blobstore.Store) {
+	ctx := context.Background()
+
+	t.Run("PutDeduplicates", func(t *testing.T) {
+		s := newStore(t)
+		first := put(t, s, "picture")
+		second := put(t, s, "picture")
+		put(t, s, "other")
+		if first.Manifest.SHA256 != digest("picture") || first.Manifest.Size != 7 {
+			t.Errorf("unexpected manifest %+v", first.Manifest)
+		}
+		if second.Manifest.SHA256 != first.Manifest.SHA256 {
+			t.Errorf("expected the same digest for the same content")
+		}
+		list := list(t, s)
+		if len(list) != 2 || list[0].SHA256 > list[1].SHA256 {
+			t.Errorf("expected 2 contents sorted by digest, got %v", list)
+		}
+		got, err := s.Get(ctx, blobstore.GetRequest{SHA256: digest("picture")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bts, err := blob.ReadAll(got.Content); err != nil || string(bts) != "picture" || got.Info.Size != 7 {
+			t.Errorf("unexpected content '%s' (%v)", bts, err)
+		}
+	})
+
+	t.Run("PutChecksDigest", func(t *testing.T) {
+		s := newStore(t)
+		r := rendition("picture")
+		r.Manifest.SHA256 = digest("other")
+		_, err := s.Put(ctx, blobstore.PutRequest{Rendition: r})
+		assertErrorCode(t, err, blob.ErrorCodeChecksumMismatch)
+		if len(list(t, s)) != 0 {
+			t.Errorf("expected content not to be stored")
+		}
+	})
+
+	t.Run("GetAndDelete", func(t *testing.T) {
+		s := newStore(t)
+		put(t, s, "picture")
+		_, err := s.Get(ctx, blobstore.GetRequest{SHA256: digest("other")})
+		assertErrorCode(t, err, blobstore.ErrorCodeNotFound)
+		_, err = s.Get(ctx, blobstore.GetRequest{SHA256: "../../etc/passwd"})
+		assertErrorCode(t, err, blobstore.ErrorCodeInvalidDigest)
+		if _, err := s.Delete(ctx, blobstore.DeleteRequest{SHA256: digest("picture")}); err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.Delete(ctx, blobstore.DeleteRequest{SHA256: digest("picture")})
+		assertErrorCode(t, err, blobstore.ErrorCodeNotFound)
+	})
+
+	t.Run("Collect", func(t *testing.T) {
+		s := newStore(t)
+		put(t, s, "kept")
+		put(t, s, "garbage")
+		report, err := blobstore.Collect(ctx, s, map[string]bool{digest("kept"): true}, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Deleted) != 0 || report.Kept != 2 {
+			t.Errorf("expected recently stored content to be spared, got %+v", report)
+		}
+		report, err = blobstore.Collect(ctx, s, map[string]bool{digest("kept"): true}, time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Deleted) != 1 || report.Deleted[0].SHA256 != digest("garbage") || report.Kept != 1 {
+			t.Errorf("expected unreferenced content to be deleted, got %+v", report)
+		}
+		if l := list(t, s); len(l) != 1 || l[0].SHA256 != digest("kept") {
+			t.Errorf("unexpected contents after collect %v", l)
+		}
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		s := newStore(t)
+		put(t, s, "picture")
+		report, err := blobstore.Verify(ctx, s, map[string]bool{digest("picture"): true, digest("gone"): true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Verified != 1 || len(report.Problems) != 1 || report.Problems[0].Code() != blobstore.ErrorCodeMissing {
+			t.Errorf("expected the missing content to be reported, got %+v", report)
+		}
+	})
+}
+
+func TestFilesystemStoreVerifyDetectsCorruption(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	s, err := blobstore.NewFilesystemStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, "picture")
+	put(t, s, "other")
+	path := filepath.Join(root, "sha256", digest("picture")[:2], digest("picture"))
+	if err := os.WriteFile(path, []byte("pict"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := blobstore.Verify(ctx, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Verified != 2 || len(report.Problems) != 1 {
+		t.Fatalf("expected one problem, got %+v", report)
+	}
+	if p := report.Problems[0]; p.Code() != blobstore.ErrorCodeCorrupt || p.Details()["actual"] != digest("pict") {
+		t.Errorf("unexpected problem %v %v", p, p.Details())
+	}
+
+	//putting the content again doesn't repair it, as the content is only stored once
+	put(t, s, "picture")
+	if entries, err := os.ReadDir(filepath.Join(root, "tmp")); err != nil || len(entries) != 0 {
+		t.Errorf("expected no temporary files left, got %v (%v)", entries, err)
+	}
+}
+
+func put(t *testing.T, s blobstore.Store, content string) blob.Rendition {
+	t.Helper()
+	res, err := s.Put(context.Background(), blobstore.PutRequest{Rendition: rendition(content)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Rendition
+}
+
+func list(t *testing.T, s blobstore.Store) []blobstore.Info {
+	t.Helper()
+	res, err := s.List(context.Background(), blobstore.ListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Infos
+}
+
+func rendition(content string) blob.Rendition {
+	return blob.Rendition{
+		Function: blob.RenditionFunctionMain,
+		Manifest: blob.BlobManifest{MediaType: mediatype.MustParse("text/plain")},
+		Content:  blob.FromReader(strings.NewReader(content)),
+	}
+}
+
+func digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func assertErrorCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if !aldberr.IsCode(err, code) {
+		t.Errorf("expected error with code %s, got %v", code, err)
+	}
+}
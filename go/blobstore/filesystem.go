@@ -0,0 +1,163 @@
+package blobstore
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+const (
+	fsContentDir = "sha256"
+	fsTmpDir     = "tmp"
+)
+
+//FilesystemStore is a Store that keeps content in files in a directory:
+//
+//	sha256/<first two hex digits>/<hex digest>  the content
+//	tmp/                                        content that is being put
+//
+//Content is written to a temporary file while it is hashed, and then moved into place, so a file under
+//sha256/ always holds complete content. The modification time of a content file is its Stored time.
+type FilesystemStore struct {
+	root string
+}
+
+//NewFilesystemStore creates a FilesystemStore on the given root directory, creating it if needed.
+func NewFilesystemStore(root string) (*FilesystemStore, error) {
+	for _, dir := range []string{root, filepath.Join(root, fsContentDir), filepath.Join(root, fsTmpDir)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fsError(err, "cannot create directory", dir)
+		}
+	}
+	return &FilesystemStore{root: root}, nil
+}
+
+func (s *FilesystemStore) Put(ctx context.Context, req PutRequest) (PutResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return PutResponse{}, err
+	}
+	//content that was read from this store is not copied again
+	r := req.Rendition
+	if fc, isFile := r.Content.(*blob.FileContent); isFile && IsDigest(r.Manifest.SHA256) && fc.Path == s.contentPath(r.Manifest.SHA256) {
+		if info, err := s.touch(fc.Path); err == nil {
+			r.Manifest.Size = info.Size()
+			return PutResponse{Rendition: r}, nil
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Join(s.root, fsTmpDir), "put-*")
+	if err != nil {
+		return PutResponse{}, fsError(err, "cannot create temporary file", filepath.Join(s.root, fsTmpDir))
+	}
+	defer os.Remove(f.Name())
+	r, err = blob.Ingest(f, r)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fsError(closeErr, "cannot write temporary file", f.Name())
+	}
+	if err != nil {
+		return PutResponse{}, err
+	}
+
+	target := s.contentPath(r.Manifest.SHA256)
+	r.Content = blob.File(target)
+	if _, err := s.touch(target); err == nil {
+		return PutResponse{Rendition: r}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return PutResponse{}, fsError(err, "cannot create directory", filepath.Dir(target))
+	}
+	if err := os.Rename(f.Name(), target); err != nil {
+		return PutResponse{}, fsError(err, "cannot store content", target)
+	}
+	return PutResponse{Rendition: r}, nil
+}
+
+func (s *FilesystemStore) Get(ctx context.Context, req GetRequest) (GetResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return GetResponse{}, err
+	}
+	if err := checkDigest(req.SHA256); err != nil {
+		return GetResponse{}, err
+	}
+	path := s.contentPath(req.SHA256)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return GetResponse{}, notFound(req.SHA256)
+	}
+	if err != nil {
+		return GetResponse{}, fsError(err, "cannot read content", path)
+	}
+	return GetResponse{Content: blob.File(path), Info: Info{SHA256: req.SHA256, Size: info.Size(), Stored: info.ModTime()}}, nil
+}
+
+func (s *FilesystemStore) Delete(ctx context.Context, req DeleteRequest) (DeleteResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return DeleteResponse{}, err
+	}
+	if err := checkDigest(req.SHA256); err != nil {
+		return DeleteResponse{}, err
+	}
+	path := s.contentPath(req.SHA256)
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return DeleteResponse{}, notFound(req.SHA256)
+	}
+	if err != nil {
+		return DeleteResponse{}, fsError(err, "cannot delete content", path)
+	}
+	return DeleteResponse{}, nil
+}
+
+//List lists the content files. Files that aren't named after a digest are ignored.
+func (s *FilesystemStore) List(ctx context.Context, req ListRequest) (ListResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ListResponse{}, err
+	}
+	out := []Info{}
+	dir := filepath.Join(s.root, fsContentDir)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !IsDigest(d.Name()) || path != s.contentPath(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		out = append(out, Info{SHA256: d.Name(), Size: info.Size(), Stored: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return ListResponse{}, fsError(err, "cannot list content", dir)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SHA256 < out[j].SHA256 })
+	return ListResponse{Infos: out}, nil
+}
+
+//touch updates the Stored time of the content file at path, so that Collect spares it.
+func (s *FilesystemStore) touch(path string) (os.FileInfo, error) {
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return nil, err
+	}
+	return os.Stat(path)
+}
+
+func (s *FilesystemStore) contentPath(sha string) string {
+	return filepath.Join(s.root, fsContentDir, sha[:2], sha)
+}
+
+func fsError(err error, msg, path string) error {
+	return aldberr.Wrap(err, ErrorCodeFilesystem, msg, map[string]interface{}{"path": path})
+}
@@ -0,0 +1,97 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+)
+
+//MemoryStore is a Store that keeps content in memory.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	contents map[string]*memoryContent
+}
+
+type memoryContent struct {
+	bts    []byte
+	stored time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{contents: map[string]*memoryContent{}}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, req PutRequest) (PutResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return PutResponse{}, err
+	}
+	buf := bytes.Buffer{}
+	r, err := blob.Ingest(&buf, req.Rendition)
+	if err != nil {
+		return PutResponse{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mc, found := s.contents[r.Manifest.SHA256]
+	if !found {
+		mc = &memoryContent{bts: buf.Bytes()}
+		s.contents[r.Manifest.SHA256] = mc
+	}
+	mc.stored = time.Now()
+	r.Content = blob.Bytes(mc.bts)
+	return PutResponse{Rendition: r}, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, req GetRequest) (GetResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return GetResponse{}, err
+	}
+	if err := checkDigest(req.SHA256); err != nil {
+		return GetResponse{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	mc, found := s.contents[req.SHA256]
+	if !found {
+		return GetResponse{}, notFound(req.SHA256)
+	}
+	return GetResponse{Content: blob.Bytes(mc.bts), Info: mc.info(req.SHA256)}, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, req DeleteRequest) (DeleteResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return DeleteResponse{}, err
+	}
+	if err := checkDigest(req.SHA256); err != nil {
+		return DeleteResponse{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.contents[req.SHA256]; !found {
+		return DeleteResponse{}, notFound(req.SHA256)
+	}
+	delete(s.contents, req.SHA256)
+	return DeleteResponse{}, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, req ListRequest) (ListResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ListResponse{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Info, 0, len(s.contents))
+	for sha, mc := range s.contents {
+		out = append(out, mc.info(sha))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SHA256 < out[j].SHA256 })
+	return ListResponse{Infos: out}, nil
+}
+
+func (mc *memoryContent) info(sha string) Info {
+	return Info{SHA256: sha, Size: int64(len(mc.bts)), Stored: mc.stored}
+}
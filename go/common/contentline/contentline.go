@@ -0,0 +1,412 @@
+//Package contentline reads and writes the content lines that iCalendar (RFC 5545 section 3.1) and vCard (RFC
+//6350 section 3.3) objects consist of, e.g. "DTSTART;TZID=Europe/Brussels:20190725T100000", grouped in
+//components by BEGIN and END lines. It knows nothing about the meaning of the properties, apart from the
+//escaping of TEXT values (see Text and SetText).
+package contentline
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	ErrorCodeInvalid = "common-contentline-invalid"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalid, Status: http.StatusBadRequest, Message: errcode.En("invalid iCalendar or vCard content")},
+	)
+}
+
+//MaxLineLength is the length in octets of the lines that Encode folds content lines into, without the line
+//break.
+const MaxLineLength = 75
+
+//Component is a BEGIN:<Name> ... END:<Name> block, e.g. a VCALENDAR, a VEVENT or a VCARD. Names of
+//components, properties and parameters are upper case.
+type Component struct {
+	Name       string
+	Properties []Property
+	Components []*Component
+}
+
+//Property is a content line: a name, parameters and a value, of which the meaning depends on the name.
+type Property struct {
+	//Group is the group prefix of a vCard property (e.g. "item1" in "item1.EMAIL"), which is rare.
+	Group  string
+	Name   string
+	Params map[string][]string
+	//Value is the raw value, see Text for TEXT values.
+	Value string
+}
+
+//Prop returns the first property with the given name.
+func (c *Component) Prop(name string) (Property, bool) {
+	for _, p := range c.Properties {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Property{}, false
+}
+
+//Props returns all properties with the given name, in order.
+func (c *Component) Props(name string) []Property {
+	out := []Property{}
+	for _, p := range c.Properties {
+		if p.Name == name {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+//Children returns the sub-components with the given name, in order.
+func (c *Component) Children(name string) []*Component {
+	out := []*Component{}
+	for _, sub := range c.Components {
+		if sub.Name == name {
+			out = append(out, sub)
+		}
+	}
+	return out
+}
+
+//Add appends a property and returns it, so that parameters can be set on it.
+func (c *Component) Add(name, value string) *Property {
+	c.Properties = append(c.Properties, Property{Name: name, Value: value})
+	return &c.Properties[len(c.Properties)-1]
+}
+
+//AddText appends a property with a TEXT value, escaping it (see SetText).
+func (c *Component) AddText(name, text string) *Property {
+	p := c.Add(name, "")
+	p.SetText(text)
+	return p
+}
+
+//Param returns the first value of a parameter, or "".
+func (p Property) Param(name string) string {
+	if vs := p.Params[name]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+//SetParam replaces the values of a parameter.
+func (p *Property) SetParam(name string, values ...string) *Property {
+	if p.Params == nil {
+		p.Params = map[string][]string{}
+	}
+	p.Params[name] = values
+	return p
+}
+
+//Text returns the value unescaped as a TEXT value, in which "\n", "\,", "\;" and "\\" are escapes.
+func (p Property) Text() string {
+	return unescape(p.Value)
+}
+
+//Texts returns the value as a comma separated list of TEXT values, e.g. the CATEGORIES of an event.
+func (p Property) Texts() []string {
+	out := []string{}
+	for _, v := range splitUnescaped(p.Value, ',') {
+		out = append(out, unescape(v))
+	}
+	return out
+}
+
+//Fields returns the value as a structured value of which the fields are separated by semicolons, and the
+//components of a field by commas (e.g. the N of a vCard: family;given;additional;prefixes;suffixes).
+func (p Property) Fields() [][]string {
+	out := [][]string{}
+	for _, field := range splitUnescaped(p.Value, ';') {
+		components := []string{}
+		for _, v := range splitUnescaped(field, ',') {
+			components = append(components, unescape(v))
+		}
+		out = append(out, components)
+	}
+	return out
+}
+
+//SetText sets the value to text, escaped as a TEXT value.
+func (p *Property) SetText(text string) *Property {
+	p.Value = escape(text)
+	return p
+}
+
+//SetFields sets the value to a structured value, see Fields.
+func (p *Property) SetFields(fields ...[]string) *Property {
+	escaped := make([]string, len(fields))
+	for i, field := range fields {
+		components := make([]string, len(field))
+		for j, v := range field {
+			components[j] = escape(v)
+		}
+		escaped[i] = strings.Join(components, ",")
+	}
+	p.Value = strings.Join(escaped, ";")
+	return p
+}
+
+func escape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+func unescape(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	sb := strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			sb.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			sb.WriteByte('\n')
+		default:
+			sb.WriteByte(value[i])
+		}
+	}
+	return sb.String()
+}
+
+//splitUnescaped splits value at the separators that aren't escaped with a backslash.
+func splitUnescaped(value string, sep byte) []string {
+	out := []string{}
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case sep:
+			out = append(out, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(out, value[start:])
+}
+
+//region decoding
+
+//Decode reads the components at the top level of r, e.g. the VCALENDAR of an .ics file or the VCARDs of a
+//.vcf file. Lines may end in CRLF or LF, and folded lines (continued on a line that starts with a space or a
+//tab) are unfolded. Lines outside of a component are not allowed, apart from empty ones.
+func Decode(r io.Reader) ([]*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	out := []*Component{}
+	stack := []*Component{}
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, aldberr.Wrap(err, ErrorCodeInvalid, "invalid content line", map[string]interface{}{"line": i + 1})
+		}
+		switch p.Name {
+		case "BEGIN":
+			c := &Component{Name: strings.ToUpper(p.Value)}
+			if len(stack) == 0 {
+				out = append(out, c)
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, c)
+			}
+			stack = append(stack, c)
+			continue
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return nil, aldberr.New(ErrorCodeInvalid, "END without matching BEGIN", map[string]interface{}{"line": i + 1, "component": p.Value})
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if len(stack) == 0 {
+			return nil, aldberr.New(ErrorCodeInvalid, "property outside of a component", map[string]interface{}{"line": i + 1, "property": p.Name})
+		}
+		c := stack[len(stack)-1]
+		c.Properties = append(c.Properties, p)
+	}
+	if len(stack) > 0 {
+		return nil, aldberr.New(ErrorCodeInvalid, "BEGIN without END", map[string]interface{}{"component": stack[len(stack)-1].Name})
+	}
+	return out, nil
+}
+
+//unfold reads the logical lines of r.
+func unfold(r io.Reader) ([]string, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), 1<<20)
+	out := []string{}
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if len(out) > 0 && len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			out[len(out)-1] += line[1:]
+			continue
+		}
+		out = append(out, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalid, "cannot read content lines", nil)
+	}
+	return out, nil
+}
+
+//parseLine parses name *(";" param) ":" value, in which parameter values may be quoted.
+func parseLine(line string) (Property, error) {
+	p := Property{}
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return p, aldberr.New(ErrorCodeInvalid, "content line without name or value", nil)
+	}
+	p.Name = strings.ToUpper(line[:i])
+	if group, name, found := strings.Cut(p.Name, "."); found {
+		p.Group, p.Name = group, name
+	}
+	rest := line[i:]
+	for rest[0] == ';' {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return p, aldberr.New(ErrorCodeInvalid, "parameter without value", map[string]interface{}{"property": p.Name})
+		}
+		name := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+		values := []string{}
+		for {
+			v := ""
+			if len(rest) > 0 && rest[0] == '"' {
+				end := strings.IndexByte(rest[1:], '"')
+				if end < 0 {
+					return p, aldberr.New(ErrorCodeInvalid, "unterminated quoted parameter value", map[string]interface{}{"property": p.Name, "param": name})
+				}
+				v, rest = rest[1:end+1], rest[end+2:]
+			} else {
+				end := strings.IndexAny(rest, ",;:")
+				if end < 0 {
+					return p, aldberr.New(ErrorCodeInvalid, "content line without value", map[string]interface{}{"property": p.Name})
+				}
+				v, rest = rest[:end], rest[end:]
+			}
+			values = append(values, unescapeParam(v))
+			if len(rest) == 0 || rest[0] != ',' {
+				break
+			}
+			rest = rest[1:]
+		}
+		if len(rest) == 0 {
+			return p, aldberr.New(ErrorCodeInvalid, "content line without value", map[string]interface{}{"property": p.Name})
+		}
+		p.SetParam(name, append(p.Params[name], values...)...)
+	}
+	if rest[0] != ':' {
+		return p, aldberr.New(ErrorCodeInvalid, "content line without value", map[string]interface{}{"property": p.Name})
+	}
+	p.Value = rest[1:]
+	return p, nil
+}
+
+//unescapeParam decodes the ^-escapes of parameter values (RFC 6868).
+func unescapeParam(v string) string {
+	if !strings.Contains(v, "^") {
+		return v
+	}
+	return strings.NewReplacer("^n", "\n", "^'", `"`, "^^", "^").Replace(v)
+}
+
+//endregion
+
+//region encoding
+
+//Encode writes the components as content lines ending in CRLF, folded at MaxLineLength octets without
+//splitting UTF-8 sequences. Parameters are written in the order of their names, so that the output is
+//stable.
+func Encode(w io.Writer, cs ...*Component) error {
+	buf := &bytes.Buffer{}
+	for _, c := range cs {
+		encodeComponent(buf, c)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalid, "cannot write content lines", nil)
+	}
+	return nil
+}
+
+func encodeComponent(buf *bytes.Buffer, c *Component) {
+	writeFolded(buf, "BEGIN:"+c.Name)
+	for _, p := range c.Properties {
+		writeFolded(buf, formatLine(p))
+	}
+	for _, sub := range c.Components {
+		encodeComponent(buf, sub)
+	}
+	writeFolded(buf, "END:"+c.Name)
+}
+
+func formatLine(p Property) string {
+	sb := strings.Builder{}
+	if len(p.Group) > 0 {
+		sb.WriteString(p.Group + ".")
+	}
+	sb.WriteString(p.Name)
+	names := make([]string, 0, len(p.Params))
+	for name := range p.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sb.WriteString(";" + name + "=")
+		for i, v := range p.Params[name] {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(formatParam(v))
+		}
+	}
+	sb.WriteString(":" + p.Value)
+	return sb.String()
+}
+
+//formatParam quotes parameter values that contain separators, and ^-escapes the characters that can't be
+//quoted (RFC 6868).
+func formatParam(v string) string {
+	v = strings.NewReplacer("^", "^^", "\r\n", "^n", "\n", "^n", `"`, "^'").Replace(v)
+	if strings.ContainsAny(v, ",;:") {
+		return `"` + v + `"`
+	}
+	return v
+}
+
+func writeFolded(buf *bytes.Buffer, line string) {
+	limit := MaxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		//the space that starts a continuation line counts
+		limit = MaxLineLength - 1
+	}
+	buf.WriteString(line + "\r\n")
+}
+
+//endregion
@@ -0,0 +1,83 @@
+package contentline
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	in := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"summary;LANGUAGE=en-GB:Lunch\\, then a walk\\; bring shoes\r\n" +
+		"DESCRIPTION:first line\\nsecond \r\n line\n" +
+		"ATTENDEE;CN=\"Doe, John\";ROLE=REQ-PARTICIPANT;DELEGATED-FROM=\"mailto:a@doe.eu\",\"mailto:b@doe.eu\":mailto:john@doe.eu\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	cs, err := Decode(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 1 || len(cs[0].Children("VEVENT")) != 1 {
+		t.Fatalf("unexpected components %v", cs)
+	}
+	ev := cs[0].Children("VEVENT")[0]
+	if p, _ := ev.Prop("SUMMARY"); p.Text() != "Lunch, then a walk; bring shoes" || p.Param("LANGUAGE") != "en-GB" {
+		t.Errorf("unexpected summary %+v", p)
+	}
+	if p, _ := ev.Prop("DESCRIPTION"); p.Text() != "first line\nsecond line" {
+		t.Errorf("unexpected description %q", p.Text())
+	}
+	p, _ := ev.Prop("ATTENDEE")
+	if p.Param("CN") != "Doe, John" || p.Value != "mailto:john@doe.eu" || !reflect.DeepEqual(p.Params["DELEGATED-FROM"], []string{"mailto:a@doe.eu", "mailto:b@doe.eu"}) {
+		t.Errorf("unexpected attendee %+v", p)
+	}
+
+	for _, in := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"END:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nEND:VEVENT\r\n",
+		"SUMMARY:outside\r\n",
+		"BEGIN:VCALENDAR\r\nSUMMARY\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nATTENDEE;CN=\"open:x\r\nEND:VCALENDAR\r\n",
+	} {
+		if _, err := Decode(strings.NewReader(in)); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	c := &Component{Name: "VCARD"}
+	c.Add("VERSION", "4.0")
+	c.AddText("NOTE", strings.Repeat("é", 50)+"\nsecond; line")
+	c.Add("N", "").SetFields([]string{"Doe"}, []string{"John"}, nil, []string{"Dr.", "Prof."}, nil)
+	c.Add("EMAIL", "john@doe.eu").SetParam("TYPE", "work", "pref")
+	c.Add("X-ADR", "x").SetParam("LABEL", "Street 1; Town")
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, c); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > MaxLineLength {
+			t.Errorf("line longer than %d octets: %q", MaxLineLength, line)
+		}
+	}
+	for _, expected := range []string{"N:Doe;John;;Dr.,Prof.;\r\n", "EMAIL;TYPE=work,pref:john@doe.eu\r\n", "X-ADR;LABEL=\"Street 1; Town\":x\r\n"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in\n%s", expected, buf)
+		}
+	}
+
+	cs, err := Decode(buf)
+	if err != nil || len(cs) != 1 {
+		t.Fatalf("cannot decode %v (%v)", cs, err)
+	}
+	if p, _ := cs[0].Prop("NOTE"); p.Text() != strings.Repeat("é", 50)+"\nsecond; line" {
+		t.Errorf("unexpected round trip of folded text %q", p.Text())
+	}
+	if p, _ := cs[0].Prop("N"); !reflect.DeepEqual(p.Fields(), [][]string{{"Doe"}, {"John"}, {""}, {"Dr.", "Prof."}, {""}}) {
+		t.Errorf("unexpected fields %q", p.Fields())
+	}
+}
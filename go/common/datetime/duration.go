@@ -0,0 +1,159 @@
+package datetime
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//Duration is an ISO 8601 duration, e.g. "P1Y2M10DT2H30M". Its calendar parts (years, months and days) don't
+//have a fixed length: they are added as calendar units in the time zone of the time they are added to (see
+//AddTo), so that a day is 23 or 25 hours when the clocks change for daylight saving time.
+type Duration struct {
+	Years, Months, Days int
+	//Time is the part after "T", e.g. 2h30m for "PT2H30M".
+	Time time.Duration
+}
+
+//ParseDuration parses an ISO 8601 duration, e.g. "P3W", "P1Y2M10DT2H30M" or "PT0.5S". Only the seconds
+//can have a fraction.
+func ParseDuration(s string) (Duration, error) {
+	errDet := map[string]interface{}{"raw": s}
+	rest, found := cutPrefixFold(s, "P")
+	if !found || len(rest) == 0 {
+		return Duration{}, aldberr.New(ErrorCodeInvalidDuration, "cannot parse duration: doesn't start with P", errDet)
+	}
+	d := Duration{}
+	inTime := false
+	//units are the designators that may still follow, in order
+	units := "YMWD"
+	for len(rest) > 0 {
+		if rest[0] == 'T' || rest[0] == 't' {
+			if inTime || len(rest) == 1 {
+				return Duration{}, aldberr.New(ErrorCodeInvalidDuration, "cannot parse duration: misplaced T", errDet)
+			}
+			inTime, units, rest = true, "HMS", rest[1:]
+			continue
+		}
+		end := 0
+		for end < len(rest) && (rest[end] >= '0' && rest[end] <= '9' || rest[end] == '.' || rest[end] == ',') {
+			end++
+		}
+		if end == 0 || end == len(rest) {
+			return Duration{}, aldberr.New(ErrorCodeInvalidDuration, "cannot parse duration: expected a number and a designator", errDet)
+		}
+		number, unit := strings.ReplaceAll(rest[:end], ",", "."), strings.ToUpper(rest[end:end+1])
+		rest = rest[end+1:]
+		i := strings.Index(units, unit)
+		if i < 0 {
+			return Duration{}, aldberr.New(ErrorCodeInvalidDuration, "cannot parse duration: unexpected designator", errDet).Det("designator", unit)
+		}
+		units = units[i+1:]
+		if inTime && unit == "S" {
+			seconds, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return Duration{}, aldberr.Wrap(err, ErrorCodeInvalidDuration, "cannot parse duration: invalid seconds", errDet)
+			}
+			d.Time += time.Duration(seconds * float64(time.Second))
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return Duration{}, aldberr.Wrap(err, ErrorCodeInvalidDuration, "cannot parse duration: invalid number", errDet).Det("designator", unit)
+		}
+		switch {
+		case inTime && unit == "H":
+			d.Time += time.Duration(n) * time.Hour
+		case inTime && unit == "M":
+			d.Time += time.Duration(n) * time.Minute
+		case unit == "Y":
+			d.Years = n
+		case unit == "M":
+			d.Months = n
+		case unit == "W":
+			d.Days += 7 * n
+		case unit == "D":
+			d.Days += n
+		}
+	}
+	return d, nil
+}
+
+//MustParseDuration is like ParseDuration, but panics if s isn't a valid duration. It is meant for constants.
+func MustParseDuration(s string) Duration {
+	d, err := ParseDuration(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+//IsZero tells whether the duration is empty, e.g. "PT0S".
+func (d Duration) IsZero() bool {
+	return d == Duration{}
+}
+
+//AddTo returns t plus the duration: first the calendar parts in the time zone of t, then the Time.
+func (d Duration) AddTo(t time.Time) time.Time {
+	return t.AddDate(d.Years, d.Months, d.Days).Add(d.Time)
+}
+
+//SubtractFrom returns t minus the duration, the reverse of AddTo.
+func (d Duration) SubtractFrom(t time.Time) time.Time {
+	return t.Add(-d.Time).AddDate(-d.Years, -d.Months, -d.Days)
+}
+
+//String formats the duration in ISO 8601, e.g. "P1Y2M10DT2H30M". The zero Duration is "PT0S".
+func (d Duration) String() string {
+	if d.IsZero() {
+		return "PT0S"
+	}
+	out := "P"
+	for _, part := range []struct {
+		n    int
+		unit string
+	}{{d.Years, "Y"}, {d.Months, "M"}, {d.Days, "D"}} {
+		if part.n != 0 {
+			out += strconv.Itoa(part.n) + part.unit
+		}
+	}
+	if d.Time == 0 {
+		return out
+	}
+	out += "T"
+	rest := d.Time
+	if h := rest / time.Hour; h != 0 {
+		out += strconv.FormatInt(int64(h), 10) + "H"
+		rest -= h * time.Hour
+	}
+	if m := rest / time.Minute; m != 0 {
+		out += strconv.FormatInt(int64(m), 10) + "M"
+		rest -= m * time.Minute
+	}
+	if rest != 0 {
+		out += strconv.FormatFloat(rest.Seconds(), 'f', -1, 64) + "S"
+	}
+	return out
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(bts []byte) error {
+	parsed, err := ParseDuration(string(bts))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
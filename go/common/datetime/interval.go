@@ -0,0 +1,135 @@
+package datetime
+
+import (
+	"strings"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//openEnd is the ISO 8601 (2019 revision) notation of the side of an interval that is unbounded.
+const openEnd = ".."
+
+//localLayouts are the layouts of times without time zone offset that ParseInterval accepts, which are in the
+//time zone of the period.
+var localLayouts = []string{"2006-01-02T15:04:05.999999999", "2006-01-02T15:04"}
+
+const dateLayout = "2006-01-02"
+
+//ParseInterval parses an ISO 8601 time interval: "start/end", "start/duration", "duration/end", with ".." for
+//a side without bound (e.g. "2019-07-25/.."). The period is half-open. Times without time zone offset, and
+//dates, are in the time zone loc, which becomes the TimeZone of the period. If loc is nil, they are in UTC and
+//the period keeps the offsets of the text. An end that is a date includes that day, e.g.
+//"2019-07-01/2019-07-31" is all of July.
+func ParseInterval(s string, loc *time.Location) (Period, error) {
+	errDet := map[string]interface{}{"raw": s}
+	zone := loc
+	if zone == nil {
+		zone = time.UTC
+	}
+	startText, endText, found := strings.Cut(strings.TrimSpace(s), "/")
+	if !found {
+		return Period{}, aldberr.New(ErrorCodeInvalidPeriod, "cannot parse interval: no /", errDet)
+	}
+	p := Period{}
+	var startDuration, endDuration *Duration
+	switch {
+	case startText == openEnd:
+	case isDuration(startText):
+		d, err := ParseDuration(startText)
+		if err != nil {
+			return Period{}, aldberr.Wrap(err, ErrorCodeInvalidPeriod, "cannot parse interval: invalid duration", errDet)
+		}
+		startDuration = &d
+	default:
+		t, _, err := parseTime(startText, zone)
+		if err != nil {
+			return Period{}, aldberr.Wrap(err, ErrorCodeInvalidPeriod, "cannot parse interval: invalid start", errDet)
+		}
+		p.Start = t
+	}
+	switch {
+	case endText == openEnd:
+	case isDuration(endText):
+		d, err := ParseDuration(endText)
+		if err != nil {
+			return Period{}, aldberr.Wrap(err, ErrorCodeInvalidPeriod, "cannot parse interval: invalid duration", errDet)
+		}
+		endDuration = &d
+	default:
+		t, isDate, err := parseTime(endText, zone)
+		if err != nil {
+			return Period{}, aldberr.Wrap(err, ErrorCodeInvalidPeriod, "cannot parse interval: invalid end", errDet)
+		}
+		if isDate {
+			t = t.AddDate(0, 0, 1)
+		}
+		p.End = t
+	}
+	switch {
+	case startDuration != nil && endDuration != nil:
+		return Period{}, aldberr.New(ErrorCodeInvalidPeriod, "cannot parse interval: two durations", errDet)
+	case startDuration != nil && !p.HasEnd(), endDuration != nil && !p.HasStart():
+		return Period{}, aldberr.New(ErrorCodeInvalidPeriod, "cannot parse interval: a duration needs a time on the other side", errDet)
+	case startDuration != nil:
+		p.Start = startDuration.SubtractFrom(p.End)
+	case endDuration != nil:
+		p.End = endDuration.AddTo(p.Start)
+	}
+	if loc != nil {
+		p = p.In(loc)
+	}
+	if err := p.Validate(); err != nil {
+		return Period{}, aldberr.Wrap(err, ErrorCodeInvalidPeriod, "cannot parse interval", errDet)
+	}
+	return p, nil
+}
+
+func isDuration(s string) bool {
+	return strings.HasPrefix(s, "P") || strings.HasPrefix(s, "p")
+}
+
+//parseTime parses an RFC 3339 time, a time without offset or a date, and tells whether it was a date.
+func parseTime(s string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, false, nil
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, false, nil
+		}
+	}
+	t, err := time.ParseInLocation(dateLayout, s, loc)
+	return t, true, err
+}
+
+//String formats the period as an ISO 8601 time interval of RFC 3339 times, in its half-open form (see
+//Normalized) and in its TimeZone, e.g. "2019-07-25T00:00:00+02:00/..". The zero Period is "../..".
+func (p Period) String() string {
+	if loc, err := p.Location(); err == nil && len(p.TimeZone) > 0 {
+		p = p.In(loc)
+	}
+	p = p.Normalized()
+	start, end := openEnd, openEnd
+	if p.HasStart() {
+		start = p.Start.Format(time.RFC3339Nano)
+	}
+	if p.HasEnd() {
+		end = p.End.Format(time.RFC3339Nano)
+	}
+	return start + "/" + end
+}
+
+func (p Period) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+//UnmarshalText parses an ISO 8601 time interval, see ParseInterval. Times without offset are in UTC.
+func (p *Period) UnmarshalText(bts []byte) error {
+	parsed, err := ParseInterval(string(bts), nil)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
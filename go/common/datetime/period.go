@@ -1,7 +1,373 @@
+//Package datetime models periods of time, with ISO 8601 text for them (see Period.String) and for durations
+//(see Duration).
 package datetime
 
-import "time"
+import (
+	"encoding/json"
+	"net/http"
+	"time"
 
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	ErrorCodeInvalidPeriod = "common-datetime-invalid-period"
+	//ErrorCodeInvalidDuration is returned for text that isn't an ISO 8601 duration, see ParseDuration.
+	ErrorCodeInvalidDuration = "common-datetime-invalid-duration"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidPeriod, Status: http.StatusBadRequest, Message: errcode.En("invalid period")},
+		errcode.Code{Code: ErrorCodeInvalidDuration, Status: http.StatusBadRequest, Message: errcode.En("invalid duration")},
+		errcode.Code{Code: ErrorCodeInvalidRecurrence, Status: http.StatusBadRequest, Message: errcode.En("invalid recurrence")},
+	)
+}
+
+//Period is a period of time. By default it is half-open: Start is in the period and End isn't, which
+//StartExclusive and EndInclusive change.
+//
+//A period can be unbounded on either side: a zero Start means the period has no start (it extends into the
+//past indefinitely), a zero End that it has no end. The zero Period is all of time, see Always. Use HasStart
+//and HasEnd rather than comparing with zero times, and the constructors (Between, Since, Until and At) to
+//make the intent explicit.
+//
+//A period with a Recurrence is repeated, e.g. every Monday from 9:00 to 9:15. Contains and Overlaps consider
+//all of its occurrences, the other operations only the first one.
 type Period struct {
 	Start, End time.Time
+	//StartExclusive tells that Start itself is not in the period.
+	StartExclusive bool
+	//EndInclusive tells that End itself is in the period.
+	EndInclusive bool
+	//TimeZone is the IANA name of the time zone of the period (e.g. "Europe/Brussels"), in which it is
+	//written and calendar days are counted. If empty, the zones of Start and End are used.
+	TimeZone string
+	//Recurrence, if not nil, repeats the period, see Occurrences.
+	Recurrence *Recurrence
+}
+
+//Always is the period without start or end, which contains every time.
+var Always = Period{}
+
+//Between returns the half-open period from start up to (not including) end.
+func Between(start, end time.Time) Period {
+	return Period{Start: start, End: end}
+}
+
+//Since returns the period from start on, without end.
+func Since(start time.Time) Period {
+	return Period{Start: start}
+}
+
+//Until returns the period up to (not including) end, without start.
+func Until(end time.Time) Period {
+	return Period{End: end}
+}
+
+//At returns the period that only contains t.
+func At(t time.Time) Period {
+	return Period{Start: t, End: t, EndInclusive: true}
+}
+
+//HasStart tells whether the period has a start, i.e. is bounded in the past.
+func (p Period) HasStart() bool {
+	return !p.Start.IsZero()
+}
+
+//HasEnd tells whether the period has an end, i.e. is bounded in the future.
+func (p Period) HasEnd() bool {
+	return !p.End.IsZero()
+}
+
+//lower returns the first time in the period, and false if the period has no start.
+func (p Period) lower() (time.Time, bool) {
+	switch {
+	case !p.HasStart():
+		return time.Time{}, false
+	case p.StartExclusive:
+		return p.Start.Add(time.Nanosecond), true
+	}
+	return p.Start, true
+}
+
+//upper returns the first time after the period, and false if the period has no end.
+func (p Period) upper() (time.Time, bool) {
+	switch {
+	case !p.HasEnd():
+		return time.Time{}, false
+	case p.EndInclusive:
+		return p.End.Add(time.Nanosecond), true
+	}
+	return p.End, true
+}
+
+//Normalized returns the period in its half-open form, which contains the same times: as times have a
+//resolution of a nanosecond, an exclusive Start is the inclusive Start a nanosecond later, and an inclusive
+//End the exclusive End a nanosecond later.
+func (p Period) Normalized() Period {
+	out := Period{TimeZone: p.TimeZone, Recurrence: p.Recurrence}
+	out.Start, _ = p.lower()
+	out.End, _ = p.upper()
+	return out
+}
+
+//IsEmpty tells whether the period contains no time at all, e.g. because it ends when it starts.
+func (p Period) IsEmpty() bool {
+	lower, hasStart := p.lower()
+	upper, hasEnd := p.upper()
+	return hasStart && hasEnd && !lower.Before(upper)
+}
+
+//Validate checks that the period doesn't end before it starts, that its TimeZone exists, and that a recurring
+//period has a start, an end and a valid rule.
+func (p Period) Validate() error {
+	lower, hasStart := p.lower()
+	upper, hasEnd := p.upper()
+	if hasStart && hasEnd && upper.Before(lower) {
+		return aldberr.New(ErrorCodeInvalidPeriod, "period ends before it starts", map[string]interface{}{"startTime": p.Start.Format(time.RFC3339Nano), "endTime": p.End.Format(time.RFC3339Nano)})
+	}
+	if _, err := p.Location(); err != nil {
+		return err
+	}
+	return p.validateRecurrence()
+}
+
+//Location returns the time zone of the period: that of TimeZone, or else the one of Start or End, or else
+//UTC.
+func (p Period) Location() (*time.Location, error) {
+	switch {
+	case len(p.TimeZone) > 0:
+		loc, err := time.LoadLocation(p.TimeZone)
+		if err != nil {
+			return nil, aldberr.Wrap(err, ErrorCodeInvalidPeriod, "unknown time zone", map[string]interface{}{"timeZone": p.TimeZone})
+		}
+		return loc, nil
+	case p.HasStart():
+		return p.Start.Location(), nil
+	case p.HasEnd():
+		return p.End.Location(), nil
+	}
+	return time.UTC, nil
 }
+
+//In returns the period with its Start and End in the time zone loc, which becomes its TimeZone. The period
+//contains the same times.
+func (p Period) In(loc *time.Location) Period {
+	if p.HasStart() {
+		p.Start = p.Start.In(loc)
+	}
+	if p.HasEnd() {
+		p.End = p.End.In(loc)
+	}
+	p.TimeZone = loc.String()
+	return p
+}
+
+//Contains checks that t is in the period, or in one of its occurrences if it recurs.
+func (p Period) Contains(t time.Time) bool {
+	if p.Recurrence != nil {
+		return len(p.Occurrences(At(t), 1)) > 0
+	}
+	lower, hasStart := p.lower()
+	upper, hasEnd := p.upper()
+	return (!hasStart || !t.Before(lower)) && (!hasEnd || t.Before(upper))
+}
+
+//ContainsPeriod checks that every time of o is in the period. An empty o is in every period.
+func (p Period) ContainsPeriod(o Period) bool {
+	if o.IsEmpty() {
+		return true
+	}
+	lower, hasStart := p.lower()
+	upper, hasEnd := p.upper()
+	oLower, oHasStart := o.lower()
+	oUpper, oHasEnd := o.upper()
+	startsBefore := !hasStart || oHasStart && !oLower.Before(lower)
+	endsAfter := !hasEnd || oHasEnd && !upper.Before(oUpper)
+	return startsBefore && endsAfter
+}
+
+//Overlaps checks that the periods have a time in common, considering all occurrences of recurring periods.
+//If both periods recur without end, only the first MaxOccurrences of o that start during p are considered.
+func (p Period) Overlaps(o Period) bool {
+	switch {
+	case p.Recurrence == nil && o.Recurrence == nil:
+		_, found := p.Intersection(o)
+		return found
+	case p.Recurrence == nil:
+		return o.Overlaps(p)
+	case o.Recurrence == nil:
+		return len(p.Occurrences(o, 1)) > 0
+	}
+	for _, occ := range o.Occurrences(p.Span(), 0) {
+		if p.Overlaps(occ) {
+			return true
+		}
+	}
+	return false
+}
+
+//Intersection returns the (half-open) period of the times that are in both periods, and false if there are
+//none.
+func (p Period) Intersection(o Period) (Period, bool) {
+	out := Period{TimeZone: p.TimeZone}
+	out.Start, _ = laterLower(p, o)
+	out.End, _ = earlierUpper(p, o)
+	if out.IsEmpty() || p.IsEmpty() || o.IsEmpty() {
+		return Period{}, false
+	}
+	return out, true
+}
+
+//Union returns the (half-open) period of the times that are in either period, and false if there is a gap
+//between them, so that their union isn't a period.
+func (p Period) Union(o Period) (Period, bool) {
+	switch {
+	case p.IsEmpty():
+		return o.First().Normalized(), true
+	case o.IsEmpty():
+		return p.First().Normalized(), true
+	}
+	if _, found := p.Gap(o); found {
+		return Period{}, false
+	}
+	out := Period{TimeZone: p.TimeZone}
+	if pLower, hasStart := p.lower(); hasStart {
+		if oLower, oHasStart := o.lower(); oHasStart {
+			out.Start = pLower
+			if oLower.Before(pLower) {
+				out.Start = oLower
+			}
+		}
+	}
+	if pUpper, hasEnd := p.upper(); hasEnd {
+		if oUpper, oHasEnd := o.upper(); oHasEnd {
+			out.End = pUpper
+			if oUpper.After(pUpper) {
+				out.End = oUpper
+			}
+		}
+	}
+	return out, true
+}
+
+//Gap returns the (half-open) period between the periods, and false if they overlap or if one starts when
+//the other ends.
+func (p Period) Gap(o Period) (Period, bool) {
+	if p.IsEmpty() || o.IsEmpty() {
+		return Period{}, false
+	}
+	//the gap starts at the earliest end, and ends at the latest start
+	out := Period{TimeZone: p.TimeZone}
+	var hasStart, hasEnd bool
+	out.Start, hasStart = earlierUpper(p, o)
+	out.End, hasEnd = laterLower(p, o)
+	if !hasStart || !hasEnd || !out.Start.Before(out.End) {
+		return Period{}, false
+	}
+	return out, true
+}
+
+//Duration returns the length of the period, and false if it has no start or no end. Periods longer than
+//about 292 years have the maximum time.Duration.
+func (p Period) Duration() (time.Duration, bool) {
+	lower, hasStart := p.lower()
+	upper, hasEnd := p.upper()
+	if !hasStart || !hasEnd {
+		return 0, false
+	}
+	if p.IsEmpty() {
+		return 0, true
+	}
+	return upper.Sub(lower), true
+}
+
+//laterLower returns the latest first time of the periods, and false if neither has a start.
+func laterLower(p, o Period) (time.Time, bool) {
+	pLower, pHasStart := p.lower()
+	oLower, oHasStart := o.lower()
+	if !pHasStart || oHasStart && oLower.After(pLower) {
+		return oLower, oHasStart
+	}
+	return pLower, true
+}
+
+//earlierUpper returns the earliest first time after the periods, and false if neither has an end.
+func earlierUpper(p, o Period) (time.Time, bool) {
+	pUpper, pHasEnd := p.upper()
+	oUpper, oHasEnd := o.upper()
+	if !pHasEnd || oHasEnd && oUpper.Before(pUpper) {
+		return oUpper, oHasEnd
+	}
+	return pUpper, true
+}
+
+//region JSON
+
+type periodJSON struct {
+	StartTime      string `json:"startTime,omitempty"`
+	EndTime        string `json:"endTime,omitempty"`
+	StartExclusive bool   `json:"startExclusive,omitempty"`
+	EndInclusive   bool   `json:"endInclusive,omitempty"`
+	TimeZone       string `json:"timeZone,omitempty"`
+	//Recurrence is {"rrule": "FREQ=WEEKLY;BYDAY=MO", "exdates": [...]}.
+	Recurrence *recurrenceJSON `json:"recurrence,omitempty"`
+}
+
+//MarshalJSON encodes the Period as {"startTime": ..., "endTime": ...} with RFC 3339 timestamps, in the
+//TimeZone if any. A Start or End that the period doesn't have is omitted.
+func (p Period) MarshalJSON() ([]byte, error) {
+	pj := periodJSON{StartExclusive: p.StartExclusive, EndInclusive: p.EndInclusive, TimeZone: p.TimeZone}
+	if p.Recurrence != nil {
+		pj.Recurrence = p.Recurrence.toJSON()
+	}
+	if loc, err := p.Location(); err == nil && len(p.TimeZone) > 0 {
+		p = p.In(loc)
+	}
+	if p.HasStart() {
+		pj.StartTime = p.Start.Format(time.RFC3339Nano)
+	}
+	if p.HasEnd() {
+		pj.EndTime = p.End.Format(time.RFC3339Nano)
+	}
+	return json.Marshal(pj)
+}
+
+func (p *Period) UnmarshalJSON(bts []byte) error {
+	pj := periodJSON{}
+	if err := json.Unmarshal(bts, &pj); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidPeriod, "cannot unmarshal Period", nil)
+	}
+	out := Period{StartExclusive: pj.StartExclusive, EndInclusive: pj.EndInclusive, TimeZone: pj.TimeZone}
+	var err error
+	if pj.Recurrence != nil {
+		if out.Recurrence, err = pj.Recurrence.toRecurrence(); err != nil {
+			return aldberr.Wrap(err, ErrorCodeInvalidPeriod, "cannot unmarshal Period: invalid recurrence", nil)
+		}
+	}
+	if len(pj.StartTime) > 0 {
+		out.Start, err = time.Parse(time.RFC3339Nano, pj.StartTime)
+		if err != nil {
+			return aldberr.Wrap(err, ErrorCodeInvalidPeriod, "cannot unmarshal Period: invalid startTime", map[string]interface{}{"startTime": pj.StartTime})
+		}
+	}
+	if len(pj.EndTime) > 0 {
+		out.End, err = time.Parse(time.RFC3339Nano, pj.EndTime)
+		if err != nil {
+			return aldberr.Wrap(err, ErrorCodeInvalidPeriod, "cannot unmarshal Period: invalid endTime", map[string]interface{}{"endTime": pj.EndTime})
+		}
+	}
+	if err := out.Validate(); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidPeriod, "cannot unmarshal Period", nil)
+	}
+	if len(out.TimeZone) > 0 {
+		loc, _ := out.Location()
+		out = out.In(loc)
+	}
+	*p = out
+	return nil
+}
+
+//endregion
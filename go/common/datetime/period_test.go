@@ -0,0 +1,145 @@
+package datetime
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func day(d int) time.Time {
+	return time.Date(2019, 7, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestContains(t *testing.T) {
+	for _, c := range []struct {
+		p        Period
+		t        time.Time
+		expected bool
+	}{
+		{Always, day(1), true},
+		{Between(day(1), day(5)), day(1), true},
+		{Between(day(1), day(5)), day(5), false},
+		{Period{Start: day(1), End: day(5), StartExclusive: true}, day(1), false},
+		{Period{Start: day(1), End: day(5), EndInclusive: true}, day(5), true},
+		{Since(day(5)), day(31), true},
+		{Until(day(5)), day(4), true},
+		{At(day(5)), day(5), true},
+		{At(day(5)), day(5).Add(time.Nanosecond), false},
+	} {
+		if c.p.Contains(c.t) != c.expected {
+			t.Errorf("%s contains %s: expected %v", c.p, c.t, c.expected)
+		}
+	}
+}
+
+func TestOperations(t *testing.T) {
+	july := Between(day(1), day(31))
+	if p, found := july.Intersection(Since(day(25))); !found || p != Between(day(25), day(31)) {
+		t.Errorf("unexpected intersection %s", p)
+	}
+	if _, found := Between(day(1), day(5)).Intersection(Between(day(5), day(10))); found {
+		t.Errorf("expected no intersection of adjacent periods")
+	}
+	if !Until(day(5)).Overlaps(At(day(4))) || Until(day(5)).Overlaps(At(day(5))) {
+		t.Errorf("unexpected overlap with an instant")
+	}
+	if p, found := Between(day(1), day(5)).Union(Between(day(5), day(10))); !found || p != Between(day(1), day(10)) {
+		t.Errorf("unexpected union %s", p)
+	}
+	if p, found := Until(day(5)).Union(Between(day(3), day(10))); !found || p != Until(day(10)) {
+		t.Errorf("unexpected union %s", p)
+	}
+	if _, found := Between(day(1), day(5)).Union(Between(day(6), day(10))); found {
+		t.Errorf("expected no union of disjoint periods")
+	}
+	if p, found := Between(day(6), day(10)).Gap(Until(day(5))); !found || p != Between(day(5), day(6)) {
+		t.Errorf("unexpected gap %s", p)
+	}
+	if _, found := july.Gap(Since(day(31))); found {
+		t.Errorf("expected no gap between adjacent periods")
+	}
+	if !july.ContainsPeriod(At(day(30))) || july.ContainsPeriod(Since(day(30))) || !Always.ContainsPeriod(july) {
+		t.Errorf("unexpected containment")
+	}
+	if d, found := (Period{Start: day(1), End: day(2), EndInclusive: true}).Duration(); !found || d != 24*time.Hour+time.Nanosecond {
+		t.Errorf("unexpected duration %s", d)
+	}
+	if _, found := Since(day(1)).Duration(); found {
+		t.Errorf("expected no duration of an unbounded period")
+	}
+	if err := Between(day(5), day(1)).Validate(); err == nil {
+		t.Errorf("expected an error for a period that ends before it starts")
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	brussels, err := time.LoadLocation("Europe/Brussels")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, c := range []struct {
+		in       string
+		loc      *time.Location
+		expected string
+	}{
+		{"2019-07-25/..", nil, "2019-07-25T00:00:00Z/.."},
+		{"../2019-07-25T10:00:00+02:00", nil, "../2019-07-25T10:00:00+02:00"},
+		{"../..", nil, "../.."},
+		{"2019-07-01/2019-07-31", nil, "2019-07-01T00:00:00Z/2019-08-01T00:00:00Z"},
+		{"2019-07-01T10:00/PT1H30M", nil, "2019-07-01T10:00:00Z/2019-07-01T11:30:00Z"},
+		{"P1W/2019-07-08T00:00:00Z", nil, "2019-07-01T00:00:00Z/2019-07-08T00:00:00Z"},
+		{"2019-07-25/..", brussels, "2019-07-25T00:00:00+02:00/.."},
+		//a calendar day is 23 hours when the clocks go forward
+		{"2019-03-30T12:00/P1D", brussels, "2019-03-30T12:00:00+01:00/2019-03-31T12:00:00+02:00"},
+	} {
+		p, err := ParseInterval(c.in, c.loc)
+		if err != nil || p.String() != c.expected {
+			t.Errorf("%q: expected %q, got %q (%v)", c.in, c.expected, p, err)
+		}
+	}
+	for _, in := range []string{"2019-07-25", "2019-07-25/nope", "P1D/P2D", "P1D/..", "2019-07-25/2019-07-01", "2019-07-25/P1X"} {
+		if p, err := ParseInterval(in, nil); err == nil {
+			t.Errorf("%q: expected an error, got %s", in, p)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for in, expected := range map[string]Duration{
+		"P1Y2M10DT2H30M": {Years: 1, Months: 2, Days: 10, Time: 2*time.Hour + 30*time.Minute},
+		"P3W":            {Days: 21},
+		"PT0.5S":         {Time: 500 * time.Millisecond},
+		"PT0S":           {},
+	} {
+		d, err := ParseDuration(in)
+		if err != nil || d != expected {
+			t.Errorf("%q: expected %v, got %v (%v)", in, expected, d, err)
+		}
+	}
+	if s := MustParseDuration("P1Y2M10DT2H30M1.5S").String(); s != "P1Y2M10DT2H30M1.5S" {
+		t.Errorf("unexpected string %q", s)
+	}
+	for _, in := range []string{"", "P", "1D", "PT", "P1H", "PT1D", "P1D2Y", "P1.5D"} {
+		if d, err := ParseDuration(in); err == nil {
+			t.Errorf("%q: expected an error, got %v", in, d)
+		}
+	}
+}
+
+func TestPeriodJSON(t *testing.T) {
+	p := Period{Start: day(1), End: day(5), EndInclusive: true, TimeZone: "Europe/Brussels"}
+	bts, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bts) != `{"startTime":"2019-07-01T02:00:00+02:00","endTime":"2019-07-05T02:00:00+02:00","endInclusive":true,"timeZone":"Europe/Brussels"}` {
+		t.Errorf("unexpected JSON %s", bts)
+	}
+	out := Period{}
+	if err := json.Unmarshal(bts, &out); err != nil || !out.Start.Equal(p.Start) || !out.End.Equal(p.End) || !out.EndInclusive || out.TimeZone != p.TimeZone {
+		t.Errorf("unexpected round trip %s (%v)", out, err)
+	}
+	if err := json.Unmarshal([]byte(`{"startTime":"2019-07-05T00:00:00Z","endTime":"2019-07-01T00:00:00Z"}`), &out); err == nil {
+		t.Errorf("expected an error for a period that ends before it starts")
+	}
+}
@@ -0,0 +1,189 @@
+package datetime
+
+import (
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//MaxOccurrences is the number of occurrences that Occurrences lists at most when neither the window nor the
+//recurrence has an end.
+const MaxOccurrences = 10000
+
+//Recurrence repeats a period (RFC 5545 section 3.8.5): the Start and End of the period are those of its first
+//occurrence, and the Rule repeats it, at the same clock time in the time zone of the period (also when the
+//clocks change for daylight saving time) and with the same length in calendar days and clock time.
+type Recurrence struct {
+	Rule RRule
+	//ExDates are the starts of occurrences that are excluded (EXDATE), e.g. a stand-up that is cancelled.
+	ExDates []time.Time
+}
+
+//Clone returns a deep copy of r, nil if r is nil.
+func (r *Recurrence) Clone() *Recurrence {
+	if r == nil {
+		return nil
+	}
+	out := *r
+	ints := func(is []int) []int {
+		if is == nil {
+			return nil
+		}
+		return append([]int{}, is...)
+	}
+	out.Rule.BySecond, out.Rule.ByMinute, out.Rule.ByHour = ints(r.Rule.BySecond), ints(r.Rule.ByMinute), ints(r.Rule.ByHour)
+	out.Rule.ByMonthDay, out.Rule.ByYearDay, out.Rule.ByWeekNo = ints(r.Rule.ByMonthDay), ints(r.Rule.ByYearDay), ints(r.Rule.ByWeekNo)
+	out.Rule.ByMonth, out.Rule.BySetPos = ints(r.Rule.ByMonth), ints(r.Rule.BySetPos)
+	if r.Rule.ByDay != nil {
+		out.Rule.ByDay = append([]WeekdayNum{}, r.Rule.ByDay...)
+	}
+	if r.Rule.WeekStart != nil {
+		ws := *r.Rule.WeekStart
+		out.Rule.WeekStart = &ws
+	}
+	if r.ExDates != nil {
+		out.ExDates = append([]time.Time{}, r.ExDates...)
+	}
+	return &out
+}
+
+//Clone returns a copy of p that doesn't share its Recurrence.
+func (p Period) Clone() Period {
+	p.Recurrence = p.Recurrence.Clone()
+	return p
+}
+
+func (r Recurrence) excludes(start time.Time) bool {
+	for _, ex := range r.ExDates {
+		if ex.Equal(start) {
+			return true
+		}
+	}
+	return false
+}
+
+//First returns the period without its Recurrence, i.e. its first occurrence.
+func (p Period) First() Period {
+	p.Recurrence = nil
+	return p
+}
+
+//Span returns the (half-open) period from the start of the first occurrence to the end of the last one, which
+//has no end if the recurrence has none (neither COUNT nor UNTIL). It is the period itself if it doesn't recur.
+func (p Period) Span() Period {
+	if p.Recurrence == nil {
+		return p.Normalized()
+	}
+	out := p.First().Normalized()
+	rule := p.Recurrence.Rule
+	if rule.Count == 0 && rule.Until.IsZero() {
+		out.End = time.Time{}
+		return out
+	}
+	for _, occ := range p.Occurrences(Always, 0) {
+		out.End = occ.End
+	}
+	return out
+}
+
+//Occurrences lists the (half-open) occurrences of a recurring period that overlap window, in order, at most
+//limit of them if limit is positive. For a period without Recurrence, it is the period itself if it overlaps
+//window. Excluded occurrences (see Recurrence.ExDates) are left out. If neither the window nor the recurrence
+//has an end, at most MaxOccurrences are listed.
+func (p Period) Occurrences(window Period, limit int) []Period {
+	if p.Recurrence == nil {
+		if p.Overlaps(window) {
+			return []Period{p.Normalized()}
+		}
+		return nil
+	}
+	first := p.First().Normalized()
+	if !first.HasStart() || !first.HasEnd() {
+		return nil
+	}
+	loc, err := p.Location()
+	if err != nil {
+		loc = time.UTC
+	}
+	start, end := first.Start.In(loc), first.End.In(loc)
+	length := nominalDuration(start, end)
+	rule := p.Recurrence.Rule
+	windowLower, hasWindowStart := window.lower()
+	windowUpper, hasWindowEnd := window.upper()
+	if limit <= 0 && !hasWindowEnd && rule.Count == 0 && rule.Until.IsZero() {
+		limit = MaxOccurrences
+	}
+	from := time.Time{}
+	if hasWindowStart {
+		//an occurrence that starts a length (and a DST change) before the window still overlaps it
+		from = length.SubtractFrom(windowLower.In(loc)).Add(-time.Hour)
+	}
+	out := []Period{}
+	rule.each(start, from, func(occStart time.Time) bool {
+		if hasWindowEnd && !occStart.Before(windowUpper) {
+			return false
+		}
+		if p.Recurrence.excludes(occStart) {
+			return true
+		}
+		occ := Period{Start: occStart, End: length.AddTo(occStart), TimeZone: p.TimeZone}
+		if occ.Overlaps(window) {
+			out = append(out, occ)
+		}
+		return limit <= 0 || len(out) < limit
+	})
+	return out
+}
+
+//nominalDuration returns the length of a period from start to end in calendar days and clock time, e.g. 1
+//day for a period from midnight to midnight, also when that day is 23 hours long.
+func nominalDuration(start, end time.Time) Duration {
+	clock := func(t time.Time) time.Duration {
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	}
+	return Duration{Days: civilDay(end) - civilDay(start), Time: clock(end) - clock(start)}
+}
+
+//validateRecurrence checks that a recurring period has a start and an end, and a valid rule.
+func (p Period) validateRecurrence() error {
+	if p.Recurrence == nil {
+		return nil
+	}
+	if !p.HasStart() || !p.HasEnd() {
+		return aldberr.New(ErrorCodeInvalidRecurrence, "a recurring period must have a start and an end", nil)
+	}
+	return p.Recurrence.Rule.Validate()
+}
+
+//region JSON
+
+type recurrenceJSON struct {
+	RRule   string   `json:"rrule"`
+	ExDates []string `json:"exdates,omitempty"`
+}
+
+func (r Recurrence) toJSON() *recurrenceJSON {
+	out := &recurrenceJSON{RRule: r.Rule.String()}
+	for _, ex := range r.ExDates {
+		out.ExDates = append(out.ExDates, ex.Format(time.RFC3339Nano))
+	}
+	return out
+}
+
+func (rj recurrenceJSON) toRecurrence() (*Recurrence, error) {
+	rule, err := ParseRRule(rj.RRule)
+	if err != nil {
+		return nil, err
+	}
+	out := &Recurrence{Rule: rule}
+	for _, raw := range rj.ExDates {
+		ex, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return nil, aldberr.Wrap(err, ErrorCodeInvalidRecurrence, "invalid exdate", map[string]interface{}{"exdate": raw})
+		}
+		out.ExDates = append(out.ExDates, ex)
+	}
+	return out, nil
+}
+
+//endregion
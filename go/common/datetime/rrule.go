@@ -0,0 +1,696 @@
+package datetime
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//ErrorCodeInvalidRecurrence is returned for an invalid recurrence rule, see ParseRRule.
+const ErrorCodeInvalidRecurrence = "common-datetime-invalid-recurrence"
+
+//Frequency is the FREQ of a recurrence rule: the unit of the intervals at which it repeats.
+type Frequency string
+
+const (
+	Secondly Frequency = "SECONDLY"
+	Minutely Frequency = "MINUTELY"
+	Hourly   Frequency = "HOURLY"
+	Daily    Frequency = "DAILY"
+	Weekly   Frequency = "WEEKLY"
+	Monthly  Frequency = "MONTHLY"
+	Yearly   Frequency = "YEARLY"
+)
+
+var frequencies = map[Frequency]bool{Secondly: true, Minutely: true, Hourly: true, Daily: true, Weekly: true, Monthly: true, Yearly: true}
+
+//WeekdayNum is a BYDAY value of a recurrence rule: a weekday and, if N isn't 0, the Nth such day of the month
+//or year (counting from the end if negative), e.g. {-1, time.Friday} for "-1FR", the last Friday.
+type WeekdayNum struct {
+	N       int
+	Weekday time.Weekday
+}
+
+var weekdayNames = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+func (wn WeekdayNum) String() string {
+	if wn.N == 0 {
+		return weekdayNames[wn.Weekday]
+	}
+	return strconv.Itoa(wn.N) + weekdayNames[wn.Weekday]
+}
+
+//RRule is a recurrence rule (RFC 5545 section 3.3.10), e.g. "FREQ=WEEKLY;BYDAY=MO,WE", which repeats the
+//first occurrence of a period, see Recurrence.
+type RRule struct {
+	Freq Frequency
+	//Interval is the number of Freq units between the repetitions, 1 if 0.
+	Interval int
+	//Count, if not 0, is the number of occurrences, including the first one.
+	Count int
+	//Until, if not zero, is the last time at which an occurrence can start.
+	Until time.Time
+	//untilLayout is the layout in which Until was written: without "Z" it is a date or a local time, in the
+	//time zone of the period.
+	untilLayout string
+
+	BySecond   []int
+	ByMinute   []int
+	ByHour     []int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByYearDay  []int
+	ByWeekNo   []int
+	ByMonth    []int
+	BySetPos   []int
+	//WeekStart is the first day of the week (WKST), Monday if nil.
+	WeekStart *time.Weekday
+}
+
+const (
+	untilLayoutUTC   = "20060102T150405Z"
+	untilLayoutLocal = "20060102T150405"
+	untilLayoutDate  = "20060102"
+)
+
+//ParseRRule parses a recurrence rule, e.g. "FREQ=MONTHLY;BYDAY=-1FR;COUNT=10", optionally prefixed by "RRULE:".
+func ParseRRule(s string) (RRule, error) {
+	errDet := map[string]interface{}{"raw": s}
+	r := RRule{}
+	body := strings.TrimSpace(s)
+	if rest, found := cutPrefixFold(body, "RRULE:"); found {
+		body = rest
+	}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(body, ";") {
+		name, value, found := strings.Cut(part, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if !found || len(value) == 0 {
+			return RRule{}, aldberr.New(ErrorCodeInvalidRecurrence, "cannot parse recurrence rule: expected NAME=VALUE", errDet).Det("part", part)
+		}
+		if seen[name] {
+			return RRule{}, aldberr.New(ErrorCodeInvalidRecurrence, "cannot parse recurrence rule: repeated part", errDet).Det("part", name)
+		}
+		seen[name] = true
+		var err error
+		switch name {
+		case "FREQ":
+			r.Freq = Frequency(strings.ToUpper(value))
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+		case "UNTIL":
+			for _, layout := range []string{untilLayoutUTC, untilLayoutLocal, untilLayoutDate} {
+				if r.Until, err = time.Parse(layout, value); err == nil {
+					r.untilLayout = layout
+					break
+				}
+			}
+		case "BYSECOND":
+			r.BySecond, err = parseInts(value)
+		case "BYMINUTE":
+			r.ByMinute, err = parseInts(value)
+		case "BYHOUR":
+			r.ByHour, err = parseInts(value)
+		case "BYDAY":
+			r.ByDay, err = parseWeekdayNums(value)
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseInts(value)
+		case "BYYEARDAY":
+			r.ByYearDay, err = parseInts(value)
+		case "BYWEEKNO":
+			r.ByWeekNo, err = parseInts(value)
+		case "BYMONTH":
+			r.ByMonth, err = parseInts(value)
+		case "BYSETPOS":
+			r.BySetPos, err = parseInts(value)
+		case "WKST":
+			var wd time.Weekday
+			if wd, err = parseWeekday(value); err == nil {
+				r.WeekStart = &wd
+			}
+		default:
+			return RRule{}, aldberr.New(ErrorCodeInvalidRecurrence, "cannot parse recurrence rule: unknown part", errDet).Det("part", name)
+		}
+		if err != nil {
+			return RRule{}, aldberr.Wrap(err, ErrorCodeInvalidRecurrence, "cannot parse recurrence rule: invalid value", errDet).Det("part", name)
+		}
+	}
+	if err := r.Validate(); err != nil {
+		return RRule{}, aldberr.Wrap(err, ErrorCodeInvalidRecurrence, "cannot parse recurrence rule", errDet)
+	}
+	return r, nil
+}
+
+func parseInts(s string) ([]int, error) {
+	out := []int{}
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimPrefix(part, "+"))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func parseWeekdayNums(s string) ([]WeekdayNum, error) {
+	out := []WeekdayNum{}
+	for _, part := range strings.Split(s, ",") {
+		if len(part) < 2 {
+			return nil, aldberr.New(ErrorCodeInvalidRecurrence, "invalid weekday", map[string]interface{}{"weekday": part})
+		}
+		wn := WeekdayNum{}
+		var err error
+		if wn.Weekday, err = parseWeekday(part[len(part)-2:]); err != nil {
+			return nil, err
+		}
+		if n := strings.TrimPrefix(part[:len(part)-2], "+"); len(n) > 0 {
+			if wn.N, err = strconv.Atoi(n); err != nil || wn.N == 0 {
+				return nil, aldberr.New(ErrorCodeInvalidRecurrence, "invalid weekday number", map[string]interface{}{"weekday": part})
+			}
+		}
+		out = append(out, wn)
+	}
+	return out, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for i, name := range weekdayNames {
+		if strings.EqualFold(s, name) {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, aldberr.New(ErrorCodeInvalidRecurrence, "invalid weekday", map[string]interface{}{"weekday": s})
+}
+
+//Validate checks the rule: its parts must be in range, and only be used with the frequencies that RFC 5545
+//allows them for.
+func (r RRule) Validate() error {
+	errDet := map[string]interface{}{"freq": string(r.Freq)}
+	switch {
+	case !frequencies[r.Freq]:
+		return aldberr.New(ErrorCodeInvalidRecurrence, "missing or unknown FREQ", errDet)
+	case r.Interval < 0 || r.Count < 0:
+		return aldberr.New(ErrorCodeInvalidRecurrence, "negative INTERVAL or COUNT", errDet)
+	case r.Count > 0 && !r.Until.IsZero():
+		return aldberr.New(ErrorCodeInvalidRecurrence, "both COUNT and UNTIL", errDet)
+	}
+	for _, c := range []struct {
+		name     string
+		values   []int
+		min, max int
+		signed   bool
+	}{
+		{"BYSECOND", r.BySecond, 0, 60, false},
+		{"BYMINUTE", r.ByMinute, 0, 59, false},
+		{"BYHOUR", r.ByHour, 0, 23, false},
+		{"BYMONTHDAY", r.ByMonthDay, 1, 31, true},
+		{"BYYEARDAY", r.ByYearDay, 1, 366, true},
+		{"BYWEEKNO", r.ByWeekNo, 1, 53, true},
+		{"BYMONTH", r.ByMonth, 1, 12, false},
+		{"BYSETPOS", r.BySetPos, 1, 366, true},
+	} {
+		for _, v := range c.values {
+			if c.signed && v < 0 {
+				v = -v
+			}
+			if v < c.min || v > c.max {
+				return aldberr.New(ErrorCodeInvalidRecurrence, "value out of range", errDet).Det("part", c.name).Det("value", v)
+			}
+		}
+	}
+	hasDayNumbers := false
+	for _, wn := range r.ByDay {
+		if wn.N < -53 || wn.N > 53 || wn.Weekday < time.Sunday || wn.Weekday > time.Saturday {
+			return aldberr.New(ErrorCodeInvalidRecurrence, "value out of range", errDet).Det("part", "BYDAY").Det("value", wn.String())
+		}
+		hasDayNumbers = hasDayNumbers || wn.N != 0
+	}
+	switch {
+	case hasDayNumbers && r.Freq != Monthly && r.Freq != Yearly:
+		return aldberr.New(ErrorCodeInvalidRecurrence, "BYDAY with numbers is only for MONTHLY and YEARLY rules", errDet)
+	case hasDayNumbers && r.Freq == Yearly && len(r.ByWeekNo) > 0:
+		return aldberr.New(ErrorCodeInvalidRecurrence, "BYDAY with numbers cannot be combined with BYWEEKNO", errDet)
+	case len(r.ByWeekNo) > 0 && r.Freq != Yearly:
+		return aldberr.New(ErrorCodeInvalidRecurrence, "BYWEEKNO is only for YEARLY rules", errDet)
+	case len(r.ByYearDay) > 0 && (r.Freq == Daily || r.Freq == Weekly || r.Freq == Monthly):
+		return aldberr.New(ErrorCodeInvalidRecurrence, "BYYEARDAY is not for DAILY, WEEKLY and MONTHLY rules", errDet)
+	case len(r.ByMonthDay) > 0 && r.Freq == Weekly:
+		return aldberr.New(ErrorCodeInvalidRecurrence, "BYMONTHDAY is not for WEEKLY rules", errDet)
+	case len(r.BySetPos) > 0 && len(r.BySecond)+len(r.ByMinute)+len(r.ByHour)+len(r.ByDay)+len(r.ByMonthDay)+len(r.ByYearDay)+len(r.ByWeekNo)+len(r.ByMonth) == 0:
+		return aldberr.New(ErrorCodeInvalidRecurrence, "BYSETPOS needs another BYxxx part", errDet)
+	}
+	return nil
+}
+
+//String formats the rule, e.g. "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE".
+func (r RRule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		layout := r.untilLayout
+		until := r.Until
+		if len(layout) == 0 || layout == untilLayoutUTC {
+			layout, until = untilLayoutUTC, until.UTC()
+		}
+		parts = append(parts, "UNTIL="+until.Format(layout))
+	}
+	for _, c := range []struct {
+		name   string
+		values []int
+	}{
+		{"BYMONTH", r.ByMonth},
+		{"BYWEEKNO", r.ByWeekNo},
+		{"BYYEARDAY", r.ByYearDay},
+		{"BYMONTHDAY", r.ByMonthDay},
+	} {
+		if len(c.values) > 0 {
+			parts = append(parts, c.name+"="+formatInts(c.values))
+		}
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, wn := range r.ByDay {
+			days[i] = wn.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	for _, c := range []struct {
+		name   string
+		values []int
+	}{
+		{"BYHOUR", r.ByHour},
+		{"BYMINUTE", r.ByMinute},
+		{"BYSECOND", r.BySecond},
+		{"BYSETPOS", r.BySetPos},
+	} {
+		if len(c.values) > 0 {
+			parts = append(parts, c.name+"="+formatInts(c.values))
+		}
+	}
+	if r.WeekStart != nil {
+		parts = append(parts, "WKST="+weekdayNames[*r.WeekStart])
+	}
+	return strings.Join(parts, ";")
+}
+
+func formatInts(values []int) string {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = strconv.Itoa(v)
+	}
+	return strings.Join(strs, ",")
+}
+
+func (r RRule) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *RRule) UnmarshalText(bts []byte) error {
+	parsed, err := ParseRRule(string(bts))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+//region expansion
+
+//maxEmptyYears is how long the expansion looks for a next occurrence, so that a rule that doesn't have any more
+//occurrences (e.g. the 30th of February) ends. The Gregorian calendar repeats every 400 years.
+const maxEmptyYears = 400
+
+//each calls yield with the starts of the occurrences of the rule in order, starting with dtstart (which is
+//in the time zone of the period), until yield returns false or the rule ends. If the rule has no COUNT, the
+//occurrences that start before from are skipped (not necessarily all of them).
+func (r RRule) each(dtstart time.Time, from time.Time, yield func(time.Time) bool) {
+	loc := dtstart.Location()
+	r = r.withDefaults(dtstart)
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+	until, hasUntil := r.untilIn(loc)
+	if !yield(dtstart) || r.Count == 1 {
+		return
+	}
+	count := 1
+	k := 0
+	if r.Count == 0 && from.After(dtstart) {
+		if n := r.periodsBetween(dtstart, from.In(loc)); n > interval {
+			k = (n/interval - 1) * interval
+		}
+	}
+	lastFound := dtstart
+	for {
+		periodStart, candidates, next := r.period(dtstart, k, interval)
+		if periodStart.After(lastFound.AddDate(maxEmptyYears, 0, 0)) {
+			return
+		}
+		if len(r.BySetPos) > 0 {
+			candidates = setPositions(candidates, r.BySetPos)
+		}
+		for _, c := range candidates {
+			if !c.After(dtstart) {
+				continue
+			}
+			if hasUntil && c.After(until) {
+				return
+			}
+			lastFound = c
+			count++
+			if !yield(c) || r.Count > 0 && count >= r.Count {
+				return
+			}
+		}
+		k = next
+	}
+}
+
+//withDefaults returns the rule with the parts that RFC 5545 takes from dtstart when they are missing, e.g.
+//the day of the month of a MONTHLY rule without BYDAY or BYMONTHDAY. The lists are sorted.
+func (r RRule) withDefaults(dtstart time.Time) RRule {
+	hasDay := len(r.ByDay)+len(r.ByMonthDay)+len(r.ByYearDay) > 0
+	switch r.Freq {
+	case Yearly:
+		switch {
+		case hasDay:
+		case len(r.ByWeekNo) > 0:
+			r.ByDay = []WeekdayNum{{Weekday: dtstart.Weekday()}}
+		case len(r.ByMonth) > 0:
+			r.ByMonthDay = []int{dtstart.Day()}
+		default:
+			r.ByMonth, r.ByMonthDay = []int{int(dtstart.Month())}, []int{dtstart.Day()}
+		}
+	case Monthly:
+		if !hasDay {
+			r.ByMonthDay = []int{dtstart.Day()}
+		}
+	case Weekly:
+		if len(r.ByDay) == 0 {
+			r.ByDay = []WeekdayNum{{Weekday: dtstart.Weekday()}}
+		}
+	}
+	if r.isSubDaily(Hourly) && len(r.ByHour) == 0 {
+		r.ByHour = []int{dtstart.Hour()}
+	}
+	if r.isSubDaily(Minutely) && len(r.ByMinute) == 0 {
+		r.ByMinute = []int{dtstart.Minute()}
+	}
+	if r.Freq != Secondly && len(r.BySecond) == 0 {
+		r.BySecond = []int{dtstart.Second()}
+	}
+	r.BySecond, r.ByMinute, r.ByHour = sortedInts(r.BySecond), sortedInts(r.ByMinute), sortedInts(r.ByHour)
+	return r
+}
+
+//isSubDaily tells whether the unit is expanded rather than iterated by the rule, i.e. whether the rule is
+//less frequent than unit.
+func (r RRule) isSubDaily(unit Frequency) bool {
+	order := map[Frequency]int{Secondly: 0, Minutely: 1, Hourly: 2, Daily: 3, Weekly: 4, Monthly: 5, Yearly: 6}
+	return order[r.Freq] > order[unit]
+}
+
+func (r RRule) untilIn(loc *time.Location) (time.Time, bool) {
+	switch {
+	case r.Until.IsZero():
+		return time.Time{}, false
+	case r.untilLayout == untilLayoutDate:
+		y, m, d := r.Until.Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, loc).Add(-time.Nanosecond), true
+	case r.untilLayout == untilLayoutLocal:
+		y, m, d := r.Until.Date()
+		return localTime(y, m, d, r.Until.Hour(), r.Until.Minute(), r.Until.Second(), loc), true
+	}
+	return r.Until, true
+}
+
+//periodsBetween returns the number of Freq units from the one of dtstart to the one of t, rounded down.
+func (r RRule) periodsBetween(dtstart, t time.Time) int {
+	switch r.Freq {
+	case Yearly:
+		return t.Year() - dtstart.Year()
+	case Monthly:
+		return (t.Year()-dtstart.Year())*12 + int(t.Month()) - int(dtstart.Month())
+	case Weekly:
+		return (civilDay(t) - civilDay(r.weekStartOf(dtstart))) / 7
+	case Daily:
+		return civilDay(t) - civilDay(dtstart)
+	}
+	return int(t.Sub(r.subDailyBase(dtstart)) / r.unit())
+}
+
+func (r RRule) unit() time.Duration {
+	switch r.Freq {
+	case Hourly:
+		return time.Hour
+	case Minutely:
+		return time.Minute
+	}
+	return time.Second
+}
+
+//subDailyBase returns dtstart without the units that the rule expands.
+func (r RRule) subDailyBase(dtstart time.Time) time.Time {
+	return truncateClock(dtstart, r.unit())
+}
+
+//truncateClock rounds t down to a whole hour, minute or second of its clock time, which differs from
+//time.Truncate in time zones with an offset that isn't a whole number of hours.
+func truncateClock(t time.Time, unit time.Duration) time.Time {
+	out := t.Add(-time.Duration(t.Nanosecond()))
+	if unit >= time.Minute {
+		out = out.Add(-time.Duration(t.Second()) * time.Second)
+	}
+	if unit >= time.Hour {
+		out = out.Add(-time.Duration(t.Minute()) * time.Minute)
+	}
+	return out
+}
+
+func (r RRule) weekStartOf(t time.Time) time.Time {
+	wkst := time.Monday
+	if r.WeekStart != nil {
+		wkst = *r.WeekStart
+	}
+	y, m, d := t.Date()
+	return time.Date(y, m, d-(int(t.Weekday())-int(wkst)+7)%7, 0, 0, 0, 0, t.Location())
+}
+
+//period returns the start and the candidate occurrences of the kth period of the rule, and the index of the
+//next period to look at.
+func (r RRule) period(dtstart time.Time, k, interval int) (time.Time, []time.Time, int) {
+	loc := dtstart.Location()
+	y, m, d := dtstart.Date()
+	var days []time.Time
+	switch r.Freq {
+	case Yearly:
+		start := time.Date(y+k, 1, 1, 0, 0, 0, 0, loc)
+		days = daysFrom(start, start.AddDate(1, 0, 0))
+	case Monthly:
+		start := time.Date(y, m+time.Month(k), 1, 0, 0, 0, 0, loc)
+		days = daysFrom(start, start.AddDate(0, 1, 0))
+	case Weekly:
+		ws := r.weekStartOf(dtstart)
+		start := time.Date(ws.Year(), ws.Month(), ws.Day()+7*k, 0, 0, 0, 0, loc)
+		days = daysFrom(start, start.AddDate(0, 0, 7))
+	case Daily:
+		days = []time.Time{time.Date(y, m, d+k, 0, 0, 0, 0, loc)}
+	default:
+		return r.subDailyPeriod(dtstart, k, interval)
+	}
+	out := []time.Time{}
+	for _, day := range days {
+		if !r.dayMatches(day) {
+			continue
+		}
+		dy, dm, dd := day.Date()
+		for _, h := range r.ByHour {
+			for _, mi := range r.ByMinute {
+				for _, s := range r.BySecond {
+					out = append(out, localTime(dy, dm, dd, h, mi, s, loc))
+				}
+			}
+		}
+	}
+	return days[0], out, k + interval
+}
+
+//subDailyPeriod is period for HOURLY, MINUTELY and SECONDLY rules, of which the periods are a fixed duration
+//apart. Periods on days or in hours that the rule excludes are skipped at once.
+func (r RRule) subDailyPeriod(dtstart time.Time, k, interval int) (time.Time, []time.Time, int) {
+	step := time.Duration(interval) * r.unit()
+	start := r.subDailyBase(dtstart).Add(time.Duration(k) * r.unit())
+	skipTo := func(t time.Time) int {
+		return k + int((t.Sub(start)+step-1)/step)*interval
+	}
+	y, m, d := start.Date()
+	if !r.dayMatches(time.Date(y, m, d, 0, 0, 0, 0, start.Location())) {
+		return start, nil, skipTo(time.Date(y, m, d+1, 0, 0, 0, 0, start.Location()))
+	}
+	if r.Freq != Hourly && len(r.ByHour) > 0 && !containsInt(r.ByHour, start.Hour()) {
+		return start, nil, skipTo(truncateClock(start, time.Hour).Add(time.Hour))
+	}
+	out := []time.Time{}
+	switch r.Freq {
+	case Hourly:
+		if len(r.ByHour) > 0 && !containsInt(r.ByHour, start.Hour()) {
+			break
+		}
+		for _, mi := range r.ByMinute {
+			for _, s := range r.BySecond {
+				out = append(out, start.Add(time.Duration(mi)*time.Minute+time.Duration(s)*time.Second))
+			}
+		}
+	case Minutely:
+		if len(r.ByMinute) > 0 && !containsInt(r.ByMinute, start.Minute()) {
+			break
+		}
+		for _, s := range r.BySecond {
+			out = append(out, start.Add(time.Duration(s)*time.Second))
+		}
+	default:
+		if len(r.ByMinute) > 0 && !containsInt(r.ByMinute, start.Minute()) || len(r.BySecond) > 0 && !containsInt(r.BySecond, start.Second()) {
+			break
+		}
+		out = append(out, start)
+	}
+	return start, out, k + interval
+}
+
+//dayMatches tells whether the rule allows occurrences on day (at midnight in the time zone of the period).
+func (r RRule) dayMatches(day time.Time) bool {
+	y, m, d := day.Date()
+	if len(r.ByMonth) > 0 && !containsInt(r.ByMonth, int(m)) {
+		return false
+	}
+	daysInYear := time.Date(y, 12, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	daysInMonth := time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	yday := day.YearDay()
+	if len(r.ByYearDay) > 0 && !containsInt(r.ByYearDay, yday) && !containsInt(r.ByYearDay, yday-daysInYear-1) {
+		return false
+	}
+	if len(r.ByMonthDay) > 0 && !containsInt(r.ByMonthDay, d) && !containsInt(r.ByMonthDay, d-daysInMonth-1) {
+		return false
+	}
+	if len(r.ByWeekNo) > 0 {
+		weekNo, weeksInYear := r.weekNo(day)
+		if !containsInt(r.ByWeekNo, weekNo) && !containsInt(r.ByWeekNo, weekNo-weeksInYear-1) {
+			return false
+		}
+	}
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	//the Nth weekday is counted in the month for MONTHLY rules, and YEARLY rules with BYMONTH
+	index, count := yday, daysInYear
+	if r.Freq == Monthly || len(r.ByMonth) > 0 {
+		index, count = d, daysInMonth
+	}
+	for _, wn := range r.ByDay {
+		if wn.Weekday != day.Weekday() {
+			continue
+		}
+		if wn.N == 0 || r.Freq != Monthly && r.Freq != Yearly || wn.N == (index-1)/7+1 || wn.N == -((count-index)/7+1) {
+			return true
+		}
+	}
+	return false
+}
+
+//weekNo returns the number of the week of day in its year, and the number of weeks in the year. Week 1 is the
+//first week (starting on WeekStart) with at least 4 days in the year. Days before it are in the last week
+//of the previous year, and days in week 1 of the next year have number 1.
+func (r RRule) weekNo(day time.Time) (int, int) {
+	week1 := func(year int) int {
+		jan1 := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+		start := civilDay(r.weekStartOf(jan1))
+		if civilDay(jan1)-start > 3 {
+			start += 7
+		}
+		return start
+	}
+	y := day.Year()
+	start, next := week1(y), week1(y+1)
+	n := civilDay(day)
+	switch {
+	case n >= next:
+		return 1, (next - start) / 7
+	case n < start:
+		prev := week1(y - 1)
+		return (n-prev)/7 + 1, (start - prev) / 7
+	}
+	return (n-start)/7 + 1, (next - start) / 7
+}
+
+//setPositions returns the candidates at the positions (BYSETPOS), in order.
+func setPositions(candidates []time.Time, positions []int) []time.Time {
+	out := []time.Time{}
+	for i, c := range candidates {
+		if containsInt(positions, i+1) || containsInt(positions, i-len(candidates)) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func daysFrom(start, end time.Time) []time.Time {
+	out := []time.Time{}
+	y, m, d := start.Date()
+	for i := 0; ; i++ {
+		day := time.Date(y, m, d+i, 0, 0, 0, 0, start.Location())
+		if !day.Before(end) {
+			return out
+		}
+		out = append(out, day)
+	}
+}
+
+//localTime returns the time with the clock time in loc. As RFC 5545 requires, a clock time that is skipped
+//when the clocks go forward is taken with the offset from before (e.g. 02:30 becomes 03:30), and of a clock
+//time that occurs twice when the clocks go back the first one is taken.
+func localTime(y int, m time.Month, d, h, mi, s int, loc *time.Location) time.Time {
+	t := time.Date(y, m, d, h, mi, s, 0, loc)
+	_, offset := t.Zone()
+	_, offsetBefore := t.Add(-12 * time.Hour).Zone()
+	if offsetBefore > offset {
+		if earlier := t.Add(-time.Duration(offsetBefore-offset) * time.Second); earlier.Hour() == h && earlier.Minute() == mi {
+			return earlier
+		}
+	}
+	return t
+}
+
+//civilDay returns the number of days since 1970-01-01 of the date of t, in its time zone.
+func civilDay(t time.Time) int {
+	y, m, d := t.Date()
+	return int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+func sortedInts(values []int) []int {
+	out := append([]int{}, values...)
+	sort.Ints(out)
+	return out
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+//endregion
@@ -0,0 +1,183 @@
+package datetime
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skip(err)
+	}
+	return loc
+}
+
+//recurring returns a recurring period of an hour that starts at start (e.g. "1997-09-02T09:00") in loc.
+func recurring(t *testing.T, start string, loc *time.Location, rule string, exdates ...time.Time) Period {
+	t.Helper()
+	s, err := time.ParseInLocation("2006-01-02T15:04", start, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ParseRRule(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Period{Start: s, End: s.Add(time.Hour), TimeZone: loc.String(), Recurrence: &Recurrence{Rule: r, ExDates: exdates}}
+}
+
+func starts(ps []Period, layout string) string {
+	out := []string{}
+	for _, p := range ps {
+		out = append(out, p.Start.Format(layout))
+	}
+	return strings.Join(out, " ")
+}
+
+//TestRFC5545Examples checks examples of RFC 5545 section 3.8.5.3.
+func TestRFC5545Examples(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	for _, c := range []struct {
+		start, rule string
+		limit       int
+		expected    string
+	}{
+		{"1997-09-02T09:00", "FREQ=DAILY;COUNT=10", 0, "09-02 09-03 09-04 09-05 09-06 09-07 09-08 09-09 09-10 09-11"},
+		{"1997-09-02T09:00", "FREQ=DAILY;INTERVAL=10;COUNT=5", 0, "09-02 09-12 09-22 10-02 10-12"},
+		{"1997-09-02T09:00", "FREQ=WEEKLY;UNTIL=19971007T000000Z;WKST=SU;BYDAY=TU,TH", 0, "09-02 09-04 09-09 09-11 09-16 09-18 09-23 09-25 09-30 10-02"},
+		{"1997-09-01T09:00", "FREQ=WEEKLY;INTERVAL=2;UNTIL=19971224T000000Z;WKST=SU;BYDAY=MO,WE,FR", 6, "09-01 09-03 09-05 09-15 09-17 09-19"},
+		{"1997-09-05T09:00", "FREQ=MONTHLY;COUNT=10;BYDAY=1FR", 0, "09-05 10-03 11-07 12-05 01-02 02-06 03-06 04-03 05-01 06-05"},
+		{"1997-09-07T09:00", "FREQ=MONTHLY;INTERVAL=2;COUNT=10;BYDAY=1SU,-1SU", 0, "09-07 09-28 11-02 11-30 01-04 01-25 03-01 03-29 05-03 05-31"},
+		{"1997-09-28T09:00", "FREQ=MONTHLY;BYMONTHDAY=-3", 6, "09-28 10-29 11-28 12-29 01-29 02-26"},
+		{"1997-03-10T09:00", "FREQ=YEARLY;INTERVAL=1;COUNT=10;BYMONTH=1,2,3", 0, "03-10 01-10 02-10 03-10 01-10 02-10 03-10 01-10 02-10 03-10"},
+		{"1997-05-19T09:00", "FREQ=YEARLY;BYDAY=20MO", 3, "05-19 05-18 05-17"},
+		{"1997-05-12T09:00", "FREQ=YEARLY;BYWEEKNO=20;BYDAY=MO", 3, "05-12 05-11 05-17"},
+		{"1997-09-04T09:00", "FREQ=MONTHLY;COUNT=3;BYDAY=TU,WE,TH;BYSETPOS=3", 0, "09-04 10-07 11-06"},
+		{"1997-09-29T09:00", "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-2", 4, "09-29 10-30 11-27 12-30"},
+		{"1997-09-02T09:00", "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13", 4, "09-02 02-13 03-13 11-13"},
+		{"1996-11-05T09:00", "FREQ=YEARLY;INTERVAL=4;BYMONTH=11;BYDAY=TU;BYMONTHDAY=2,3,4,5,6,7,8", 3, "11-05 11-07 11-02"},
+		{"2007-01-15T09:00", "FREQ=MONTHLY;BYMONTHDAY=15,30;COUNT=5", 0, "01-15 01-30 02-15 03-15 03-30"},
+	} {
+		p := recurring(t, c.start, ny, c.rule)
+		if got := starts(p.Occurrences(Always, c.limit), "01-02"); got != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.rule, c.expected, got)
+		}
+	}
+	p := recurring(t, "1997-09-02T09:00", ny, "FREQ=MINUTELY;INTERVAL=20;BYHOUR=9,10,11,12,13,14,15,16")
+	if got := starts(p.Occurrences(Between(p.Start, p.Start.Add(24*time.Hour)), 0), "15:04"); !strings.HasPrefix(got, "09:00 09:20 09:40 10:00") || !strings.HasSuffix(got, "16:00 16:20 16:40") || len(strings.Fields(got)) != 24 {
+		t.Errorf("unexpected minutely occurrences %s", got)
+	}
+	p = recurring(t, "1997-09-02T09:00", ny, "FREQ=HOURLY;INTERVAL=3;UNTIL=19970902T210000Z")
+	if got := starts(p.Occurrences(Always, 0), "15:04"); got != "09:00 12:00 15:00" {
+		t.Errorf("unexpected hourly occurrences %s", got)
+	}
+}
+
+func TestOccurrencesAcrossDST(t *testing.T) {
+	brussels := mustLoad(t, "Europe/Brussels")
+	p := recurring(t, "2019-03-29T09:00", brussels, "FREQ=DAILY;COUNT=4")
+	if got := starts(p.Occurrences(Always, 0), time.RFC3339); got != "2019-03-29T09:00:00+01:00 2019-03-30T09:00:00+01:00 2019-03-31T09:00:00+02:00 2019-04-01T09:00:00+02:00" {
+		t.Errorf("expected the same clock time across DST, got %s", got)
+	}
+	//02:30 doesn't exist on 2019-03-31, and occurs twice on 2019-10-27
+	p = recurring(t, "2019-03-30T02:30", brussels, "FREQ=DAILY;COUNT=2")
+	if got := starts(p.Occurrences(Always, 0), time.RFC3339); got != "2019-03-30T02:30:00+01:00 2019-03-31T03:30:00+02:00" {
+		t.Errorf("expected a skipped clock time to move forward, got %s", got)
+	}
+	p = recurring(t, "2019-10-26T02:30", brussels, "FREQ=DAILY;COUNT=2")
+	if got := starts(p.Occurrences(Always, 0), time.RFC3339); got != "2019-10-26T02:30:00+02:00 2019-10-27T02:30:00+02:00" {
+		t.Errorf("expected the first of a repeated clock time, got %s", got)
+	}
+	//an all-day period stays a calendar day long, also when the day is 23 hours
+	start := time.Date(2019, 3, 30, 0, 0, 0, 0, brussels)
+	allDay := Period{Start: start, End: start.AddDate(0, 0, 1), Recurrence: &Recurrence{Rule: RRule{Freq: Daily, Count: 2}}}
+	if occs := allDay.Occurrences(Always, 0); len(occs) != 2 || !occs[1].End.Equal(time.Date(2019, 4, 1, 0, 0, 0, 0, brussels)) {
+		t.Errorf("unexpected all-day occurrences %v", occs)
+	}
+}
+
+func TestRecurringContains(t *testing.T) {
+	brussels := mustLoad(t, "Europe/Brussels")
+	monday := time.Date(2019, 7, 29, 9, 0, 0, 0, brussels)
+	standUp := Period{Start: monday, End: monday.Add(15 * time.Minute), TimeZone: "Europe/Brussels", Recurrence: &Recurrence{
+		Rule:    RRule{Freq: Weekly, ByDay: []WeekdayNum{{Weekday: time.Monday}, {Weekday: time.Wednesday}}},
+		ExDates: []time.Time{monday.AddDate(0, 0, 7)},
+	}}
+	for _, c := range []struct {
+		t        time.Time
+		expected bool
+	}{
+		{monday.Add(10 * time.Minute), true},
+		{monday.Add(20 * time.Minute), false},
+		{monday.AddDate(0, 0, 2).Add(5 * time.Minute), true},
+		{monday.AddDate(0, 0, 1).Add(5 * time.Minute), false},
+		{monday.AddDate(0, 0, 7).Add(5 * time.Minute), false},
+		//after the clocks went back, the stand-up is still at 9:00
+		{time.Date(2019, 11, 4, 9, 5, 0, 0, brussels), true},
+		{time.Date(2019, 11, 4, 8, 5, 0, 0, brussels), false},
+		{time.Date(2030, 1, 7, 9, 5, 0, 0, brussels), true},
+		{monday.Add(-time.Hour), false},
+	} {
+		if standUp.Contains(c.t) != c.expected {
+			t.Errorf("%s: expected %v", c.t, c.expected)
+		}
+	}
+	if !standUp.Overlaps(Between(time.Date(2019, 12, 4, 0, 0, 0, 0, brussels), time.Date(2019, 12, 5, 0, 0, 0, 0, brussels))) {
+		t.Errorf("expected an occurrence on Wednesday")
+	}
+	if standUp.Overlaps(Between(time.Date(2019, 12, 5, 0, 0, 0, 0, brussels), time.Date(2019, 12, 7, 0, 0, 0, 0, brussels))) {
+		t.Errorf("expected no occurrence on Thursday and Friday")
+	}
+	review := Period{Start: monday.Add(-time.Hour), End: monday.Add(5 * time.Minute), Recurrence: &Recurrence{Rule: RRule{Freq: Monthly, ByDay: []WeekdayNum{{N: -1, Weekday: time.Monday}}}}}
+	if !standUp.Overlaps(review) || !review.Overlaps(standUp) {
+		t.Errorf("expected recurring periods to overlap")
+	}
+	if span := standUp.Span(); span.HasEnd() || !span.Start.Equal(monday) {
+		t.Errorf("unexpected span %s", span)
+	}
+}
+
+func TestParseRRule(t *testing.T) {
+	for in, expected := range map[string]string{
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE":                "FREQ=WEEKLY;BYDAY=MO,WE",
+		"freq=monthly;byday=-1fr;count=10":             "FREQ=MONTHLY;COUNT=10;BYDAY=-1FR",
+		"FREQ=YEARLY;UNTIL=20301231;BYMONTH=+3":        "FREQ=YEARLY;UNTIL=20301231;BYMONTH=3",
+		"FREQ=DAILY;INTERVAL=2;UNTIL=20301231T120000Z": "FREQ=DAILY;INTERVAL=2;UNTIL=20301231T120000Z",
+		"FREQ=WEEKLY;WKST=SU;BYDAY=TU":                 "FREQ=WEEKLY;BYDAY=TU;WKST=SU",
+	} {
+		r, err := ParseRRule(in)
+		if err != nil || r.String() != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", in, expected, r, err)
+		}
+	}
+	for _, in := range []string{"", "BYDAY=MO", "FREQ=FORTNIGHTLY", "FREQ=DAILY;COUNT=2;UNTIL=20301231", "FREQ=WEEKLY;BYDAY=1MO", "FREQ=MONTHLY;BYWEEKNO=1", "FREQ=WEEKLY;BYMONTHDAY=1", "FREQ=DAILY;BYHOUR=24", "FREQ=DAILY;BYSETPOS=1", "FREQ=DAILY;FREQ=DAILY", "FREQ=DAILY;X-NAME=1", "FREQ=MONTHLY;BYDAY=0MO"} {
+		if r, err := ParseRRule(in); err == nil {
+			t.Errorf("%q: expected an error, got %q", in, r)
+		}
+	}
+}
+
+func TestRecurringPeriodJSON(t *testing.T) {
+	in := `{"startTime":"2019-07-29T09:00:00+02:00","endTime":"2019-07-29T09:15:00+02:00","timeZone":"Europe/Brussels","recurrence":{"rrule":"FREQ=WEEKLY;BYDAY=MO","exdates":["2019-08-05T09:00:00+02:00"]}}`
+	p := Period{}
+	if err := json.Unmarshal([]byte(in), &p); err != nil {
+		t.Skip(err)
+	}
+	if p.Recurrence == nil || p.Recurrence.Rule.Freq != Weekly || len(p.Recurrence.ExDates) != 1 {
+		t.Fatalf("unexpected recurrence %v", p.Recurrence)
+	}
+	if bts, err := json.Marshal(p); err != nil || string(bts) != in {
+		t.Errorf("unexpected JSON %s (%v)", bts, err)
+	}
+	for _, in := range []string{
+		`{"startTime":"2019-07-29T09:00:00Z","recurrence":{"rrule":"FREQ=WEEKLY"}}`,
+		`{"startTime":"2019-07-29T09:00:00Z","endTime":"2019-07-29T10:00:00Z","recurrence":{"rrule":"FREQ=SOMETIMES"}}`,
+	} {
+		if err := json.Unmarshal([]byte(in), &p); err == nil {
+			t.Errorf("%s: expected an error", in)
+		}
+	}
+}
@@ -0,0 +1,636 @@
+//Package jsonschema validates JSON documents against JSON Schemas. It supports the keywords of draft-04 up
+//to draft 2020-12 that describe structure (types, properties, items, combinators, $ref, enum and const,
+//numeric and string bounds and the common formats), which is what the ALDB schemas and attribute set
+//manifests use. Keywords it doesn't know are ignored, as the specification requires.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	ErrorCodeInvalidSchema   = "common-jsonschema-invalid-schema"
+	ErrorCodeSchemaNotFound  = "common-jsonschema-schema-not-found"
+	ErrorCodeInvalidInstance = "common-jsonschema-invalid-instance"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidSchema, Status: http.StatusBadRequest, Message: errcode.En("invalid JSON schema")},
+		errcode.Code{Code: ErrorCodeSchemaNotFound, Status: http.StatusBadRequest, Message: errcode.En("JSON schema {uri} not found")},
+		errcode.Code{Code: ErrorCodeInvalidInstance, Status: http.StatusBadRequest, Message: errcode.En("invalid JSON")},
+	)
+}
+
+//Violation describes a part of an instance that doesn't satisfy its schema.
+type Violation struct {
+	//InstancePointer is the JSON pointer (RFC 6901) of the offending value in the instance, "" for the root.
+	InstancePointer string
+	//SchemaLocation is the absolute URI (with a JSON pointer fragment) of the schema keyword that failed.
+	SchemaLocation string
+	Message        string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.InstancePointer, v.Message)
+}
+
+//Loader retrieves the (JSON) contents of a schema document given its absolute URI without fragment.
+type Loader func(uri string) ([]byte, error)
+
+//Validator validates instances against a set of schema documents, which are added upfront or retrieved
+//with a Loader when they are referenced. It is safe for concurrent use.
+type Validator struct {
+	loader Loader
+
+	mu   sync.Mutex
+	docs map[string]interface{}
+}
+
+//NewValidator creates a Validator that uses loader (which may be nil) for schema documents that weren't
+//added with AddDocument.
+func NewValidator(loader Loader) *Validator {
+	return &Validator{loader: loader, docs: map[string]interface{}{}}
+}
+
+//AddDocument registers a schema document under the given absolute URI.
+func (v *Validator) AddDocument(uri string, schemaJSON []byte) error {
+	doc, err := decode(schemaJSON)
+	if err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidSchema, "cannot parse schema document", map[string]interface{}{"uri": uri})
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.docs[stripFragment(uri)] = doc
+	return nil
+}
+
+//Validate validates the JSON instance against the schema at schemaURI, which may have a JSON pointer
+//fragment (e.g. "http://aldb.org/activity.schema.json#/definitions/period"). An error is only returned if
+//the schema can't be used, violations are returned as such.
+func (v *Validator) Validate(schemaURI string, instanceJSON []byte) ([]Violation, error) {
+	instance, err := decode(instanceJSON)
+	if err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalidInstance, "cannot parse instance", nil)
+	}
+	return v.ValidateValue(schemaURI, instance)
+}
+
+//ValidateValue is Validate for an already decoded instance, consisting of nil, bool, float64, json.Number,
+//string, []interface{} and map[string]interface{} values.
+func (v *Validator) ValidateValue(schemaURI string, instance interface{}) ([]Violation, error) {
+	schema, base, err := v.resolve("", schemaURI)
+	if err != nil {
+		return nil, err
+	}
+	vs := &validation{v: v}
+	vs.validate(schema, base, instance, "")
+	if vs.err != nil {
+		return nil, vs.err
+	}
+	return vs.violations, nil
+}
+
+//resolve returns the schema that ref (relative to base) points to, and its absolute location.
+func (v *Validator) resolve(base, ref string) (interface{}, string, error) {
+	abs := ref
+	if len(base) > 0 {
+		baseURL, err := url.Parse(base)
+		if err != nil {
+			return nil, "", aldberr.Wrap(err, ErrorCodeInvalidSchema, "invalid base URI", map[string]interface{}{"uri": base})
+		}
+		refURL, err := url.Parse(ref)
+		if err != nil {
+			return nil, "", aldberr.Wrap(err, ErrorCodeInvalidSchema, "invalid $ref", map[string]interface{}{"ref": ref})
+		}
+		abs = baseURL.ResolveReference(refURL).String()
+		if strings.HasPrefix(ref, "#") {
+			//ResolveReference drops an empty fragment, keep documents identified the same way
+			abs = stripFragment(base) + ref
+		}
+	}
+	docURI := stripFragment(abs)
+	fragment := ""
+	if i := strings.Index(abs, "#"); i >= 0 {
+		fragment = abs[i+1:]
+	}
+
+	v.mu.Lock()
+	doc, found := v.docs[docURI]
+	v.mu.Unlock()
+	if !found {
+		if v.loader == nil {
+			return nil, "", aldberr.New(ErrorCodeSchemaNotFound, "schema document not found", map[string]interface{}{"uri": docURI})
+		}
+		bts, err := v.loader(docURI)
+		if err != nil {
+			return nil, "", aldberr.Wrap(err, ErrorCodeSchemaNotFound, "cannot load schema document", map[string]interface{}{"uri": docURI})
+		}
+		if err := v.AddDocument(docURI, bts); err != nil {
+			return nil, "", err
+		}
+		v.mu.Lock()
+		doc = v.docs[docURI]
+		v.mu.Unlock()
+	}
+
+	schema, err := followPointer(doc, fragment)
+	if err != nil {
+		return nil, "", aldberr.Wrap(err, ErrorCodeSchemaNotFound, "schema not found in document", map[string]interface{}{"uri": abs})
+	}
+	return schema, docURI + "#" + fragment, nil
+}
+
+type validation struct {
+	v          *Validator
+	violations []Violation
+	err        error
+	//depth protects against $ref loops that don't consume the instance
+	depth int
+}
+
+func (vs *validation) fail(location, pointer, format string, args ...interface{}) {
+	vs.violations = append(vs.violations, Violation{InstancePointer: pointer, SchemaLocation: location, Message: fmt.Sprintf(format, args...)})
+}
+
+//validate validates instance (at pointer) against schema (at location).
+func (vs *validation) validate(schema interface{}, location string, instance interface{}, pointer string) {
+	if vs.err != nil {
+		return
+	}
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			vs.fail(location, pointer, "no value is allowed here")
+		}
+		return
+	case map[string]interface{}:
+		vs.validateObjectSchema(s, location, instance, pointer)
+	default:
+		vs.err = aldberr.New(ErrorCodeInvalidSchema, "schema must be an object or a boolean", map[string]interface{}{"location": location})
+	}
+}
+
+func (vs *validation) validateObjectSchema(s map[string]interface{}, location string, instance interface{}, pointer string) {
+	at := func(keyword string, more ...string) string {
+		out := location + "/" + escapePointer(keyword)
+		for _, m := range more {
+			out += "/" + escapePointer(m)
+		}
+		return out
+	}
+
+	if ref, isRef := s["$ref"].(string); isRef {
+		vs.depth++
+		defer func() { vs.depth-- }()
+		if vs.depth > 100 {
+			vs.err = aldberr.New(ErrorCodeInvalidSchema, "$ref nesting too deep", map[string]interface{}{"location": location})
+			return
+		}
+		refSchema, refLocation, err := vs.v.resolve(location, ref)
+		if err != nil {
+			vs.err = err
+			return
+		}
+		//sibling keywords are applied too, as in draft 2019-09 and later
+		vs.validate(refSchema, refLocation, instance, pointer)
+	}
+
+	if t, found := s["type"]; found {
+		types := []string{}
+		switch tt := t.(type) {
+		case string:
+			types = append(types, tt)
+		case []interface{}:
+			for _, e := range tt {
+				if str, isStr := e.(string); isStr {
+					types = append(types, str)
+				}
+			}
+		}
+		ok := false
+		for _, typ := range types {
+			if hasType(instance, typ) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			vs.fail(at("type"), pointer, "expected %s, got %s", strings.Join(types, " or "), typeOf(instance))
+			return
+		}
+	}
+	if enum, found := s["enum"].([]interface{}); found {
+		ok := false
+		for _, e := range enum {
+			if equal(e, instance) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			vs.fail(at("enum"), pointer, "value is not one of the allowed values")
+		}
+	}
+	if c, found := s["const"]; found && !equal(c, instance) {
+		vs.fail(at("const"), pointer, "value is not the required constant")
+	}
+
+	for _, kw := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, found := s[kw].([]interface{})
+		if !found {
+			continue
+		}
+		passed := 0
+		for i, sub := range subs {
+			if vs.passes(sub, at(kw, strconv.Itoa(i)), instance, pointer, kw == "allOf") {
+				passed++
+			}
+		}
+		switch {
+		case kw == "anyOf" && passed == 0:
+			vs.fail(at(kw), pointer, "value doesn't match any of the schemas")
+		case kw == "oneOf" && passed != 1:
+			vs.fail(at(kw), pointer, "value matches %d of the schemas instead of exactly one", passed)
+		}
+	}
+	if not, found := s["not"]; found && vs.passes(not, at("not"), instance, pointer, false) {
+		vs.fail(at("not"), pointer, "value matches a schema it must not match")
+	}
+
+	switch it := instance.(type) {
+	case map[string]interface{}:
+		vs.validateObject(s, at, it, pointer)
+	case []interface{}:
+		vs.validateArray(s, at, it, pointer)
+	case string:
+		vs.validateString(s, at, it, pointer)
+	case float64, json.Number:
+		vs.validateNumber(s, at, toFloat(it), pointer)
+	}
+}
+
+//passes validates instance against schema. Violations are kept if keep is set, discarded otherwise.
+func (vs *validation) passes(schema interface{}, location string, instance interface{}, pointer string, keep bool) bool {
+	before := len(vs.violations)
+	vs.validate(schema, location, instance, pointer)
+	ok := len(vs.violations) == before
+	if !keep {
+		vs.violations = vs.violations[:before]
+	}
+	return ok
+}
+
+func (vs *validation) validateObject(s map[string]interface{}, at func(string, ...string) string, obj map[string]interface{}, pointer string) {
+	if required, found := s["required"].([]interface{}); found {
+		for _, r := range required {
+			if name, isStr := r.(string); isStr {
+				if _, present := obj[name]; !present {
+					vs.fail(at("required"), pointer, "missing required property %s", name)
+				}
+			}
+		}
+	}
+	if n, found := number(s["minProperties"]); found && float64(len(obj)) < n {
+		vs.fail(at("minProperties"), pointer, "expected at least %v properties", n)
+	}
+	if n, found := number(s["maxProperties"]); found && float64(len(obj)) > n {
+		vs.fail(at("maxProperties"), pointer, "expected at most %v properties", n)
+	}
+
+	props, _ := s["properties"].(map[string]interface{})
+	patternProps, _ := s["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := s["additionalProperties"]
+	for _, name := range sortedKeys(obj) {
+		value := obj[name]
+		childPointer := pointer + "/" + escapePointer(name)
+		matched := false
+		if propSchema, found := props[name]; found {
+			matched = true
+			vs.validate(propSchema, at("properties", name), value, childPointer)
+		}
+		for _, pattern := range sortedKeys(patternProps) {
+			re, err := compile(pattern)
+			if err != nil {
+				vs.err = aldberr.Wrap(err, ErrorCodeInvalidSchema, "invalid pattern", map[string]interface{}{"pattern": pattern})
+				return
+			}
+			if re.MatchString(name) {
+				matched = true
+				vs.validate(patternProps[pattern], at("patternProperties", pattern), value, childPointer)
+			}
+		}
+		if !matched && hasAdditional {
+			if allowed, isBool := additional.(bool); isBool && !allowed {
+				vs.fail(at("additionalProperties"), childPointer, "property %s is not allowed", name)
+			} else if !isBool {
+				vs.validate(additional, at("additionalProperties"), value, childPointer)
+			}
+		}
+	}
+}
+
+func (vs *validation) validateArray(s map[string]interface{}, at func(string, ...string) string, arr []interface{}, pointer string) {
+	if n, found := number(s["minItems"]); found && float64(len(arr)) < n {
+		vs.fail(at("minItems"), pointer, "expected at least %v items", n)
+	}
+	if n, found := number(s["maxItems"]); found && float64(len(arr)) > n {
+		vs.fail(at("maxItems"), pointer, "expected at most %v items", n)
+	}
+	if unique, _ := s["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := 0; j < i; j++ {
+				if equal(arr[i], arr[j]) {
+					vs.fail(at("uniqueItems"), pointer, "items %d and %d are equal", j, i)
+				}
+			}
+		}
+	}
+	switch items := s["items"].(type) {
+	case nil:
+	case []interface{}:
+		for i := range arr {
+			if i < len(items) {
+				vs.validate(items[i], at("items", strconv.Itoa(i)), arr[i], pointer+"/"+strconv.Itoa(i))
+			}
+		}
+	default:
+		for i := range arr {
+			vs.validate(items, at("items"), arr[i], pointer+"/"+strconv.Itoa(i))
+		}
+	}
+}
+
+func (vs *validation) validateString(s map[string]interface{}, at func(string, ...string) string, str string, pointer string) {
+	length := float64(utf8.RuneCountInString(str))
+	if n, found := number(s["minLength"]); found && length < n {
+		vs.fail(at("minLength"), pointer, "expected at least %v characters", n)
+	}
+	if n, found := number(s["maxLength"]); found && length > n {
+		vs.fail(at("maxLength"), pointer, "expected at most %v characters", n)
+	}
+	if pattern, found := s["pattern"].(string); found {
+		re, err := compile(pattern)
+		if err != nil {
+			vs.err = aldberr.Wrap(err, ErrorCodeInvalidSchema, "invalid pattern", map[string]interface{}{"pattern": pattern})
+			return
+		}
+		if !re.MatchString(str) {
+			vs.fail(at("pattern"), pointer, "value doesn't match pattern %s", pattern)
+		}
+	}
+	if format, found := s["format"].(string); found && !validFormat(format, str) {
+		vs.fail(at("format"), pointer, "value is not a valid %s", format)
+	}
+}
+
+func (vs *validation) validateNumber(s map[string]interface{}, at func(string, ...string) string, n float64, pointer string) {
+	exclusiveMin, _ := s["exclusiveMinimum"].(bool)
+	exclusiveMax, _ := s["exclusiveMaximum"].(bool)
+	if min, found := number(s["minimum"]); found && (n < min || (exclusiveMin && n == min)) {
+		vs.fail(at("minimum"), pointer, "value must be at least %v", min)
+	}
+	if max, found := number(s["maximum"]); found && (n > max || (exclusiveMax && n == max)) {
+		vs.fail(at("maximum"), pointer, "value must be at most %v", max)
+	}
+	if min, found := number(s["exclusiveMinimum"]); found && n <= min {
+		vs.fail(at("exclusiveMinimum"), pointer, "value must be greater than %v", min)
+	}
+	if max, found := number(s["exclusiveMaximum"]); found && n >= max {
+		vs.fail(at("exclusiveMaximum"), pointer, "value must be smaller than %v", max)
+	}
+	if m, found := number(s["multipleOf"]); found && m > 0 {
+		if q := n / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			vs.fail(at("multipleOf"), pointer, "value must be a multiple of %v", m)
+		}
+	}
+}
+
+//region helpers
+
+var (
+	regexpCacheMu sync.Mutex
+	regexpCache   = map[string]*regexp.Regexp{}
+)
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	regexpCacheMu.Lock()
+	defer regexpCacheMu.Unlock()
+	if re, found := regexpCache[pattern]; found {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexpCache[pattern] = re
+	return re, nil
+}
+
+func validFormat(format, s string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	case "email":
+		addr, err := mail.ParseAddress(s)
+		return err == nil && addr.Address == s
+	case "uri":
+		u, err := url.Parse(s)
+		return err == nil && u.IsAbs()
+	case "uri-reference":
+		_, err := url.Parse(s)
+		return err == nil
+	default:
+		return true
+	}
+}
+
+func hasType(instance interface{}, typ string) bool {
+	switch typ {
+	case "null":
+		return instance == nil
+	case "boolean":
+		_, ok := instance.(bool)
+		return ok
+	case "object":
+		_, ok := instance.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := instance.([]interface{})
+		return ok
+	case "string":
+		_, ok := instance.(string)
+		return ok
+	case "number":
+		switch instance.(type) {
+		case float64, json.Number:
+			return true
+		}
+		return false
+	case "integer":
+		switch instance.(type) {
+		case float64, json.Number:
+			f := toFloat(instance)
+			return f == math.Trunc(f)
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+func typeOf(instance interface{}) string {
+	switch instance.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64, json.Number:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", instance)
+	}
+}
+
+func toFloat(v interface{}) float64 {
+	switch vt := v.(type) {
+	case float64:
+		return vt
+	case json.Number:
+		f, _ := vt.Float64()
+		return f
+	}
+	return math.NaN()
+}
+
+func number(v interface{}) (float64, bool) {
+	switch v.(type) {
+	case float64, json.Number:
+		return toFloat(v), true
+	}
+	return 0, false
+}
+
+func equal(a, b interface{}) bool {
+	switch at := a.(type) {
+	case float64, json.Number:
+		_, isNum := number(b)
+		return isNum && toFloat(at) == toFloat(b)
+	case map[string]interface{}:
+		bt, ok := b.(map[string]interface{})
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for k := range at {
+			if !equal(at[k], bt[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bt, ok := b.([]interface{})
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for i := range at {
+			if !equal(at[i], bt[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func decode(bts []byte) (interface{}, error) {
+	var out interface{}
+	dec := json.NewDecoder(strings.NewReader(string(bts)))
+	dec.UseNumber()
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func followPointer(doc interface{}, pointer string) (interface{}, error) {
+	if len(pointer) == 0 {
+		return doc, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("unsupported fragment %s", pointer)
+	}
+	cur := doc
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token, err := url.PathUnescape(token)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			next, found := c[token]
+			if !found {
+				return nil, fmt.Errorf("no %s in %s", token, pointer)
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, fmt.Errorf("no %s in %s", token, pointer)
+			}
+			cur = c[i]
+		default:
+			return nil, fmt.Errorf("no %s in %s", token, pointer)
+		}
+	}
+	return cur, nil
+}
+
+//escapePointer escapes a token of a JSON pointer.
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func stripFragment(uri string) string {
+	if i := strings.Index(uri, "#"); i >= 0 {
+		return uri[:i]
+	}
+	return uri
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+//endregion
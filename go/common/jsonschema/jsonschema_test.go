@@ -0,0 +1,87 @@
+package jsonschema
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"email": {"type": "string", "format": "email"},
+		"tags": {"type": "array", "items": {"enum": ["a", "b"]}, "uniqueItems": true},
+		"period": {"$ref": "defs.json#/definitions/period"},
+		"child": {"$ref": "#"},
+		"count": {"type": "integer", "minimum": 0},
+		"kind": {"oneOf": [{"const": "x"}, {"type": "number"}]}
+	},
+	"required": ["name"],
+	"additionalProperties": false
+}`
+
+const testDefs = `{
+	"definitions": {
+		"period": {
+			"type": "object",
+			"properties": {"startTime": {"type": "string", "format": "date-time"}}
+		}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	v := NewValidator(func(uri string) ([]byte, error) {
+		if uri == "https://aldb.test/defs.json" {
+			return []byte(testDefs), nil
+		}
+		return nil, errors.New("not found")
+	})
+	if err := v.AddDocument("https://aldb.test/schema.json", []byte(testSchema)); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		instance string
+		pointers []string
+	}{
+		{`{"name": "n"}`, []string{}},
+		{`{"name": "n", "email": "n@aldb.test", "tags": ["a", "b"], "count": 3, "kind": "x", "period": {"startTime": "2020-01-01T00:00:00Z"}}`, []string{}},
+		{`{}`, []string{""}},
+		{`[]`, []string{""}},
+		{`{"name": ""}`, []string{"/name"}},
+		{`{"name": "n", "other": 1}`, []string{"/other"}},
+		{`{"name": "n", "email": "nope"}`, []string{"/email"}},
+		{`{"name": "n", "tags": ["a", "c", "a"]}`, []string{"/tags", "/tags/1"}},
+		{`{"name": "n", "period": {"startTime": "yesterday"}}`, []string{"/period/startTime"}},
+		{`{"name": "n", "child": {"name": "c", "child": {"name": 1}}}`, []string{"/child/child/name"}},
+		{`{"name": "n", "count": 1.5}`, []string{"/count"}},
+		{`{"name": "n", "count": -1}`, []string{"/count"}},
+		{`{"name": "n", "kind": "y"}`, []string{"/kind"}},
+	}
+	for _, c := range cases {
+		violations, err := v.Validate("https://aldb.test/schema.json", []byte(c.instance))
+		if err != nil {
+			t.Errorf("%s: %v", c.instance, err)
+			continue
+		}
+		pointers := []string{}
+		for _, violation := range violations {
+			pointers = append(pointers, violation.InstancePointer)
+		}
+		if !reflect.DeepEqual(pointers, c.pointers) {
+			t.Errorf("%s: expected violations at %v, got %v", c.instance, c.pointers, violations)
+		}
+	}
+}
+
+func TestValidateUnknownSchema(t *testing.T) {
+	v := NewValidator(nil)
+	_, err := v.Validate("https://aldb.test/missing.json", []byte(`{}`))
+	cErr := aldberr.CanvigaError{}
+	if !errors.As(err, &cErr) || cErr.Code() != ErrorCodeSchemaNotFound {
+		t.Errorf("expected %s, got %v", ErrorCodeSchemaNotFound, err)
+	}
+}
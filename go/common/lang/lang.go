@@ -1,14 +1,20 @@
 package lang
 
 import (
+	"encoding/json"
+
 	"github.com/vital-dhaveloose/aldb/base/aldberr"
 	"github.com/vital-dhaveloose/aldb/util"
 )
 
 const (
-	ErrorCodeLanguageNotFound = "common-lang-not-found"
+	ErrorCodeLanguageNotFound         = "common-lang-not-found"
+	ErrorCodeInvalidLocalizableString = "common-lang-invalid-localizable-string"
+	//ErrorCodeInvalidLang is returned for a language tag that isn't well-formed, see ParseLang.
+	ErrorCodeInvalidLang = "common-lang-invalid-lang"
 )
 
+//Lang is a language tag (BCP 47), e.g. "en-GB", or LangAny. See ParseLang for its canonical form.
 type Lang string
 
 const (
@@ -24,25 +30,57 @@ type Localizable interface {
 
 const (
 	//LocalizeParamKeyStrict indicates that only the specific given language can be used, not LangAny
-	//or similar languages. The default value is false.
+	//or similar languages. The default value is false, in which case the language falls back to its parents
+	//(see Lang.Fallbacks).
 	LocalizeParamKeyStrict = "strict"
 )
 
+//LocalizableString is a string in several languages. LangAny is the string for languages it has no
+//translation for.
 type LocalizableString map[Lang]string
 
 func (s LocalizableString) Localize(lang Lang, params map[string]interface{}) (string, error) {
 	errDet := map[string]interface{}{"lang": string(lang)}
-	str, found := s[lang]
-	if found {
-		return str, nil
-	}
 	strict := util.GetEntryBool(params, LocalizeParamKeyStrict, false)
-	if strict {
+	str, found := Lookup(s, lang, strict)
+	if !found && strict {
 		return "", aldberr.New(ErrorCodeLanguageNotFound, "can't localize LocalizableString: language not found (strict)", errDet)
 	}
-	str, found = s[LangAny]
 	if !found {
 		return "", aldberr.New(ErrorCodeLanguageNotFound, "can't localize LocalizableString: no supported language found", errDet)
 	}
 	return str, nil
 }
+
+//Langs returns the languages of the translations, in order.
+func (s LocalizableString) Langs() []Lang {
+	return Langs(s)
+}
+
+//MarshalJSON encodes the LocalizableString as an object with the languages as keys.
+func (s LocalizableString) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[Lang]string(s))
+}
+
+func (s *LocalizableString) UnmarshalJSON(bts []byte) error {
+	m := map[Lang]string{}
+	if err := json.Unmarshal(bts, &m); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidLocalizableString, "cannot unmarshal LocalizableString", nil)
+	}
+	if _, found := m[""]; found {
+		return aldberr.New(ErrorCodeInvalidLocalizableString, "cannot unmarshal LocalizableString: empty language", nil)
+	}
+	out := make(LocalizableString, len(m))
+	for l, str := range m {
+		c, err := ParseLang(string(l))
+		if err != nil {
+			return aldberr.Wrap(err, ErrorCodeInvalidLocalizableString, "cannot unmarshal LocalizableString: invalid language", map[string]interface{}{"lang": string(l)})
+		}
+		if _, found := out[c]; found {
+			return aldberr.New(ErrorCodeInvalidLocalizableString, "cannot unmarshal LocalizableString: duplicate language", map[string]interface{}{"lang": string(c)})
+		}
+		out[c] = str
+	}
+	*s = out
+	return nil
+}
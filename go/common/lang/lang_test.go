@@ -0,0 +1,147 @@
+package lang
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseLang(t *testing.T) {
+	for in, expected := range map[string]Lang{
+		"*":                     LangAny,
+		"en":                    "en",
+		"EN-gb":                 "en-GB",
+		"zh-hant-tw":            "zh-Hant-TW",
+		"es-419":                "es-419",
+		"sl-rozaj-biske":        "sl-rozaj-biske",
+		"de-CH-1901":            "de-CH-1901",
+		"iw-IL":                 "he-IL",
+		"zh-yue-HK":             "yue-HK",
+		"en-US-u-islamcal":      "en-US-u-islamcal",
+		"sr-latn-rs-X-Private1": "sr-Latn-RS-x-private1",
+		"x-whatever":            "x-whatever",
+	} {
+		l, err := ParseLang(in)
+		if err != nil || l != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", in, expected, l, err)
+		}
+	}
+	for _, in := range []string{"", "e", "en-", "-en", "en_GB", "en-GB-x", "de-419-DE", "a-DE", "ar-a-aaa-b-bbb-a-ccc", "de-1901-1901", "en-verylongsubtag", "en-G\u212a"} {
+		if l, err := ParseLang(in); err == nil {
+			t.Errorf("%q: expected an error, got %q", in, l)
+		}
+	}
+}
+
+func TestFallbacks(t *testing.T) {
+	for in, expected := range map[Lang][]Lang{
+		"en-gb":                  {"en-GB", "en", LangAny},
+		"zh-Hant-CN-x-private1":  {"zh-Hant-CN-x-private1", "zh-Hant-CN", "zh-Hant", "zh", LangAny},
+		"en-US-u-islamcal-extra": {"en-US-u-islamcal-extra", "en-US-u-islamcal", "en-US", "en", LangAny},
+		LangAny:                  {LangAny},
+	} {
+		if fs := in.Fallbacks(); !reflect.DeepEqual(fs, expected) {
+			t.Errorf("%q: expected %v, got %v", in, expected, fs)
+		}
+	}
+}
+
+func TestLocalizableString(t *testing.T) {
+	s := LocalizableString{"en": "colour", "en-US": "color", LangAny: "kleur"}
+	strict := map[string]interface{}{LocalizeParamKeyStrict: true}
+	for _, c := range []struct {
+		lang     Lang
+		params   map[string]interface{}
+		expected string
+		found    bool
+	}{
+		{"en-us", nil, "color", true},
+		{"en-GB", nil, "colour", true},
+		{"en-GB-oxendict", nil, "colour", true},
+		{"nl", nil, "kleur", true},
+		{"en-GB", strict, "", false},
+		{"EN", strict, "colour", true},
+		{LangAny, nil, "kleur", true},
+	} {
+		str, err := s.Localize(c.lang, c.params)
+		if str != c.expected || (err == nil) != c.found {
+			t.Errorf("%q %v: expected %q, got %q (%v)", c.lang, c.params, c.expected, str, err)
+		}
+	}
+	if str, err := (LocalizableString{"nl": "kleur"}).Localize(LangAny, nil); str != "kleur" || err != nil {
+		t.Errorf("expected any language for LangAny, got %q (%v)", str, err)
+	}
+	if _, err := (LocalizableString{"nl": "kleur"}).Localize("en", nil); err == nil {
+		t.Errorf("expected no fallback to another language")
+	}
+}
+
+func TestUnmarshalLocalizableString(t *testing.T) {
+	s := LocalizableString{}
+	if err := json.Unmarshal([]byte(`{"en-gb": "colour", "*": "kleur"}`), &s); err != nil || !reflect.DeepEqual(s, LocalizableString{"en-GB": "colour", LangAny: "kleur"}) {
+		t.Errorf("expected canonical languages, got %v (%v)", s, err)
+	}
+	for _, in := range []string{`{"en_GB": "colour"}`, `{"en-gb": "colour", "en-GB": "colour"}`, `{"": "colour"}`} {
+		if err := json.Unmarshal([]byte(in), &s); err == nil {
+			t.Errorf("%s: expected an error", in)
+		}
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	p := ParseAcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5, nl;q=2, en_GB, it;q=0")
+	expected := Preferences{{"fr-CH", 1}, {"fr", 0.9}, {"en", 0.8}, {"de", 0.7}, {LangAny, 0.5}, {"it", 0}}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("expected %v, got %v", expected, p)
+	}
+	if s := p.String(); s != "fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5, it;q=0" {
+		t.Errorf("unexpected string %q", s)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		header    string
+		available []Lang
+		expected  Lang
+		found     bool
+	}{
+		{"", []Lang{"en", "nl"}, "en", true},
+		{"", []Lang{"en", LangAny}, LangAny, true},
+		{"nl-BE, en;q=0.5", []Lang{"en", "nl"}, "nl", true},
+		{"nl-BE;q=0.4, en;q=0.5", []Lang{"en", "nl"}, "en", true},
+		{"en", []Lang{"en-GB", "nl"}, "en-GB", true},
+		{"fr", []Lang{"en", LangAny}, LangAny, true},
+		{"fr", []Lang{"en", "nl"}, "", false},
+		{"*, en;q=0", []Lang{"en-GB", "nl"}, "nl", true},
+		{"en, *;q=0", []Lang{"nl", "en-GB"}, "en-GB", true},
+		{"nl, *;q=0", []Lang{"en", "de"}, "", false},
+		{"de, en;q=0.5", []Lang{"DE-at", "en"}, "DE-at", true},
+	} {
+		l, found := ParseAcceptLanguage(c.header).Match(c.available)
+		if l != c.expected || found != c.found {
+			t.Errorf("%q %v: expected %q, got %q", c.header, c.available, c.expected, l)
+		}
+	}
+}
+
+//plain is a Localizable that isn't Translated.
+type plain map[Lang]string
+
+func (p plain) Localize(l Lang, params map[string]interface{}) (string, error) {
+	return LocalizableString(p).Localize(l, params)
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, l := range []Localizable{LocalizableString{"en": "colour", "nl": "kleur", LangAny: "color"}, plain{"en": "colour", "nl": "kleur", LangAny: "color"}} {
+		if s, best, err := Negotiate(l, ParseAcceptLanguage("fr, nl;q=0.9, en;q=0.8"), nil); s != "kleur" || best != "nl" || err != nil {
+			t.Errorf("%T: expected nl, got %q in %q (%v)", l, s, best, err)
+		}
+		if s, best, err := Negotiate(l, ParseAcceptLanguage("fr"), nil); s != "color" || best != LangAny || err != nil {
+			t.Errorf("%T: expected LangAny, got %q in %q (%v)", l, s, best, err)
+		}
+	}
+	if _, _, err := Negotiate(LocalizableString{"en": "colour"}, ParseAcceptLanguage("fr"), nil); err == nil {
+		t.Errorf("expected an error without acceptable language")
+	}
+}
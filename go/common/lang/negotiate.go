@@ -0,0 +1,191 @@
+package lang
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//Translated is a Localizable that knows the languages it is available in, e.g. a LocalizableString.
+type Translated interface {
+	Localizable
+	Langs() []Lang
+}
+
+//Preference is a language range with the quality (between 0 and 1) of a text in it, as in an Accept-Language
+//header. A quality of 0 means that the language is not acceptable.
+type Preference struct {
+	Lang    Lang
+	Quality float64
+}
+
+//Preferences are language ranges, most preferred first.
+type Preferences []Preference
+
+//Prefer returns Preferences for the languages, in order, all with quality 1.
+func Prefer(ls ...Lang) Preferences {
+	out := make(Preferences, len(ls))
+	for i, l := range ls {
+		out[i] = Preference{Lang: l.Canonical(), Quality: 1}
+	}
+	return out
+}
+
+//ParseAcceptLanguage parses the value of an Accept-Language header (RFC 9110 section 12.5.4), e.g.
+//"nl-BE, nl;q=0.9, en;q=0.5", and orders its ranges by quality. Ranges that aren't well-formed are left out,
+//as a header is no reason to refuse a request.
+func ParseAcceptLanguage(header string) Preferences {
+	out := Preferences{}
+	for _, item := range strings.Split(header, ",") {
+		params := strings.Split(item, ";")
+		l, err := ParseLang(strings.TrimSpace(params[0]))
+		if err != nil {
+			continue
+		}
+		p := Preference{Lang: l, Quality: 1}
+		for _, param := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if !strings.EqualFold(strings.TrimSpace(k), "q") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || q < 0 || q > 1 {
+				p.Quality = -1
+				break
+			}
+			p.Quality = q
+		}
+		if p.Quality >= 0 {
+			out = append(out, p)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Quality > out[j].Quality })
+	return out
+}
+
+//Match returns the available language that suits the preferences best. For each acceptable range in order, it
+//looks up the range and its parents (see Lang.Fallbacks), and then the languages that the range is a prefix of
+//(e.g. "en-GB" for "en"). When no range matches, it returns LangAny if that is available. Languages that a range
+//with quality 0 is a prefix of are never returned, except for LangAny. Without preferences, any language is
+//acceptable.
+func (p Preferences) Match(available []Lang) (Lang, bool) {
+	acceptable := make([]Lang, 0, len(available))
+	for _, a := range available {
+		if a == LangAny || !p.refuses(a) {
+			acceptable = append(acceptable, a)
+		}
+	}
+	find := func(l Lang) (Lang, bool) {
+		for _, a := range acceptable {
+			if a.Equal(l) {
+				return a, true
+			}
+		}
+		return "", false
+	}
+	if len(p) == 0 {
+		p = Prefer(LangAny)
+	}
+	for _, pref := range p {
+		if pref.Quality <= 0 {
+			continue
+		}
+		if pref.Lang == LangAny {
+			if a, found := find(LangAny); found {
+				return a, true
+			}
+			if len(acceptable) > 0 {
+				return acceptable[0], true
+			}
+			continue
+		}
+		for _, f := range pref.Lang.Fallbacks() {
+			if f == LangAny {
+				break
+			}
+			if a, found := find(f); found {
+				return a, true
+			}
+		}
+		for _, a := range acceptable {
+			if hasPrefix(a, pref.Lang) {
+				return a, true
+			}
+		}
+	}
+	return find(LangAny)
+}
+
+//refuses tells whether a range with quality 0 is a prefix of l. A LangAny range with quality 0 refuses the
+//languages that no other range matches.
+func (p Preferences) refuses(l Lang) bool {
+	for _, pref := range p {
+		if pref.Quality > 0 {
+			continue
+		}
+		if pref.Lang != LangAny && hasPrefix(l, pref.Lang) || pref.Lang == LangAny && !p.mentions(l) {
+			return true
+		}
+	}
+	return false
+}
+
+//mentions tells whether an acceptable range is a prefix of l, or falls back to it.
+func (p Preferences) mentions(l Lang) bool {
+	for _, pref := range p {
+		if pref.Quality > 0 && pref.Lang != LangAny && (hasPrefix(l, pref.Lang) || hasPrefix(pref.Lang, l)) {
+			return true
+		}
+	}
+	return false
+}
+
+//hasPrefix tells whether prefix is l or a language that l falls back to.
+func hasPrefix(l Lang, prefix Lang) bool {
+	c, cp := string(l.Canonical()), string(prefix.Canonical())
+	return c == cp || strings.HasPrefix(c, cp+"-")
+}
+
+//Negotiate localizes l in the language that suits the preferences best, and returns that language. If l is
+//Translated, the language is one of its Langs (see Preferences.Match). Otherwise, the ranges are tried in order,
+//strictly, and then LangAny.
+func Negotiate(l Localizable, p Preferences, params map[string]interface{}) (string, Lang, error) {
+	if t, isTranslated := l.(Translated); isTranslated {
+		best, found := p.Match(t.Langs())
+		if !found {
+			return "", "", aldberr.New(ErrorCodeLanguageNotFound, "cannot negotiate language: no acceptable language", map[string]interface{}{"lang": p.String()})
+		}
+		s, err := l.Localize(best, params)
+		return s, best, err
+	}
+	strictParams := map[string]interface{}{LocalizeParamKeyStrict: true}
+	for k, v := range params {
+		if k != LocalizeParamKeyStrict {
+			strictParams[k] = v
+		}
+	}
+	for _, pref := range p {
+		if pref.Quality <= 0 || pref.Lang == LangAny {
+			continue
+		}
+		if s, err := l.Localize(pref.Lang, strictParams); err == nil {
+			return s, pref.Lang, nil
+		}
+	}
+	s, err := l.Localize(LangAny, params)
+	return s, LangAny, err
+}
+
+//String formats the preferences as the value of an Accept-Language header.
+func (p Preferences) String() string {
+	items := make([]string, len(p))
+	for i, pref := range p {
+		items[i] = string(pref.Lang)
+		if pref.Quality != 1 {
+			items[i] += ";q=" + strconv.FormatFloat(pref.Quality, 'f', -1, 64)
+		}
+	}
+	return strings.Join(items, ", ")
+}
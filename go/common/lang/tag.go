@@ -0,0 +1,247 @@
+package lang
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//deprecatedLanguages maps deprecated primary language subtags to their preferred value (RFC 5646 section 4.5).
+var deprecatedLanguages = map[string]string{
+	"in": "id",
+	"iw": "he",
+	"ji": "yi",
+	"jw": "jv",
+	"mo": "ro",
+}
+
+//ParseLang parses a well-formed language tag (BCP 47, RFC 5646) or LangAny, and returns it in its canonical
+//form: the language and the extended language in lower case, the script in title case, the region in upper
+//case and the other subtags in lower case, e.g. "en-GB", "zh-Hant-TW" or "sr-Latn-RS-x-private". Deprecated
+//languages are replaced by their preferred value (e.g. "iw" by "he"), and so are extended languages (e.g.
+//"zh-yue" by "yue"). Grandfathered tags are not supported.
+func ParseLang(s string) (Lang, error) {
+	errDet := map[string]interface{}{"lang": s}
+	if s == string(LangAny) {
+		return LangAny, nil
+	}
+	subtags := strings.Split(s, "-")
+	for i, st := range subtags {
+		if len(st) == 0 || len(st) > 8 || !isAlphanum(st) {
+			return "", aldberr.New(ErrorCodeInvalidLang, "invalid language tag: invalid subtag", errDet)
+		}
+		subtags[i] = strings.ToLower(st)
+	}
+	if subtags[0] == "x" {
+		//a private use tag
+		if len(subtags) == 1 {
+			return "", aldberr.New(ErrorCodeInvalidLang, "invalid language tag: empty private use", errDet)
+		}
+		return Lang(strings.Join(subtags, "-")), nil
+	}
+
+	i := 0
+	language := subtags[i]
+	if len(language) < 2 || !isAlpha(language) {
+		return "", aldberr.New(ErrorCodeInvalidLang, "invalid language tag: invalid language", errDet)
+	}
+	if preferred, found := deprecatedLanguages[language]; found {
+		language = preferred
+	}
+	out := []string{language}
+	i++
+	//extended languages, only after a language of 2 or 3 letters
+	extlangs := 0
+	for len(language) <= 3 && i < len(subtags) && len(subtags[i]) == 3 && isAlpha(subtags[i]) {
+		if extlangs++; extlangs > 3 {
+			return "", aldberr.New(ErrorCodeInvalidLang, "invalid language tag: too many extended languages", errDet)
+		}
+		out = append(out, subtags[i])
+		i++
+	}
+	if extlangs == 1 {
+		//the extended language is the preferred value
+		out = out[1:]
+	}
+	if i < len(subtags) && len(subtags[i]) == 4 && isAlpha(subtags[i]) {
+		out = append(out, strings.ToUpper(subtags[i][:1])+subtags[i][1:])
+		i++
+	}
+	if i < len(subtags) && (len(subtags[i]) == 2 && isAlpha(subtags[i]) || len(subtags[i]) == 3 && isDigit(subtags[i])) {
+		out = append(out, strings.ToUpper(subtags[i]))
+		i++
+	}
+	variants := map[string]bool{}
+	for i < len(subtags) && (len(subtags[i]) >= 5 || len(subtags[i]) == 4 && isDigit(subtags[i][:1])) {
+		if variants[subtags[i]] {
+			return "", aldberr.New(ErrorCodeInvalidLang, "invalid language tag: duplicate variant", errDet)
+		}
+		variants[subtags[i]] = true
+		out = append(out, subtags[i])
+		i++
+	}
+	singletons := map[string]bool{}
+	for i < len(subtags) && len(subtags[i]) == 1 {
+		singleton := subtags[i]
+		if singleton == "x" {
+			if i == len(subtags)-1 {
+				return "", aldberr.New(ErrorCodeInvalidLang, "invalid language tag: empty private use", errDet)
+			}
+			out = append(out, subtags[i:]...)
+			i = len(subtags)
+			break
+		}
+		if singletons[singleton] {
+			return "", aldberr.New(ErrorCodeInvalidLang, "invalid language tag: duplicate extension", errDet)
+		}
+		singletons[singleton] = true
+		out = append(out, singleton)
+		i++
+		start := i
+		for i < len(subtags) && len(subtags[i]) >= 2 {
+			out = append(out, subtags[i])
+			i++
+		}
+		if i == start {
+			return "", aldberr.New(ErrorCodeInvalidLang, "invalid language tag: empty extension", errDet)
+		}
+	}
+	if i < len(subtags) {
+		errDet["subtag"] = subtags[i]
+		return "", aldberr.New(ErrorCodeInvalidLang, "invalid language tag: unexpected subtag", errDet)
+	}
+	return Lang(strings.Join(out, "-")), nil
+}
+
+//Canonical returns the canonical form of l (see ParseLang), or l itself if it isn't a well-formed tag.
+func (l Lang) Canonical() Lang {
+	c, err := ParseLang(string(l))
+	if err != nil {
+		return l
+	}
+	return c
+}
+
+//Equal tells whether l and o are the same language, regardless of case.
+func (l Lang) Equal(o Lang) bool {
+	return l == o || l.Canonical() == o.Canonical()
+}
+
+//Parent returns the language l falls back to, by removing its last subtag as in the lookup of RFC 4647
+//section 3.4, e.g. "zh-Hant" for "zh-Hant-TW" and "en" for "en-GB". The parent of a tag without subtags is
+//LangAny, which has no parent ("").
+func (l Lang) Parent() Lang {
+	if l == LangAny || len(l) == 0 {
+		return ""
+	}
+	s := string(l.Canonical())
+	i := strings.LastIndexByte(s, '-')
+	if i < 0 {
+		return LangAny
+	}
+	s = s[:i]
+	//a singleton (the start of an extension or private use) is never the last subtag
+	if i = strings.LastIndexByte(s, '-'); i >= 0 && len(s)-i == 2 {
+		s = s[:i]
+	}
+	if len(s) == 1 {
+		return LangAny
+	}
+	return Lang(s)
+}
+
+//Base returns the primary language subtag of l, e.g. "zh" for "zh-Hant-TW", or "" for LangAny and private use
+//tags.
+func (l Lang) Base() string {
+	s := string(l.Canonical())
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s = s[:i]
+	}
+	if s == string(LangAny) || s == "x" {
+		return ""
+	}
+	return s
+}
+
+//Fallbacks returns the languages that a text in language l can be looked up in, most specific first: l itself
+//and its parents up to LangAny, e.g. "en-GB", "en", "*".
+func (l Lang) Fallbacks() []Lang {
+	out := []Lang{}
+	for f := l.Canonical(); len(f) > 0; f = f.Parent() {
+		out = append(out, f)
+	}
+	return out
+}
+
+//Lookup returns the entry of m for language l. With strict it only returns the entry of l itself, otherwise
+//it falls back to the parents of l (see Lang.Fallbacks), and for LangAny to any entry. The keys of m are
+//compared regardless of case.
+func Lookup[T any](m map[Lang]T, l Lang, strict bool) (T, bool) {
+	if v, found := m[l]; found {
+		return v, true
+	}
+	var zero T
+	if len(m) == 0 {
+		return zero, false
+	}
+	langs := Langs(m)
+	find := func(f Lang) (T, bool) {
+		for _, key := range langs {
+			if key.Equal(f) {
+				return m[key], true
+			}
+		}
+		return zero, false
+	}
+	if strict {
+		return find(l)
+	}
+	for _, f := range l.Fallbacks() {
+		if v, found := find(f); found {
+			return v, true
+		}
+	}
+	if l == LangAny {
+		return m[langs[0]], true
+	}
+	return zero, false
+}
+
+//Langs returns the languages of m, in order.
+func Langs[T any](m map[Lang]T) []Lang {
+	out := make([]Lang, 0, len(m))
+	for l := range m {
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func isAlpha(s string) bool {
+	for _, c := range s {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//isAlphanum tells whether s only has ASCII letters (of either case) and digits.
+func isAlphanum(s string) bool {
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
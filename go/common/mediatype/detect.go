@@ -0,0 +1,305 @@
+package mediatype
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//SniffLen is the number of bytes at the start of content that Detect looks at.
+const SniffLen = 512
+
+var (
+	//OctetStream is the media type of content of an unknown type.
+	OctetStream = MustParse("application/octet-stream")
+	//TextPlain is the media type of content that is text of an unknown type.
+	TextPlain = MustParse("text/plain")
+)
+
+//Detector recognizes content of a media type by its first bytes (at most SniffLen), e.g. a Magic number.
+type Detector func(head []byte) (MediaType, bool)
+
+var (
+	registryMu sync.RWMutex
+	detectors  []Detector
+	//parents maps media types to the more general types that they are a kind of, see IsA.
+	parents = map[string][]string{}
+)
+
+//Register adds detectors to the registry. Detectors that are registered later are tried first, so that they can
+//refine the built-in ones (e.g. recognize a kind of ZIP archive).
+func Register(ds ...Detector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	detectors = append(detectors, ds...)
+}
+
+//RegisterParent records that content of media type mt is also content of media type parent, e.g. that
+//"application/epub+zip" is a kind of "application/zip". See IsA.
+func RegisterParent(mt, parent MediaType) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	parents[mt.Essence()] = append(parents[mt.Essence()], parent.Essence())
+}
+
+//Magic returns a Detector of content that has the magic bytes at the offset. In mask, if any, the zero bits are
+//bits of the content that aren't compared.
+func Magic(mt MediaType, offset int, magic []byte, mask []byte) Detector {
+	return func(head []byte) (MediaType, bool) {
+		if len(head) < offset+len(magic) {
+			return MediaType{}, false
+		}
+		for i, b := range magic {
+			c := head[offset+i]
+			if i < len(mask) {
+				c, b = c&mask[i], b&mask[i]
+			}
+			if c != b {
+				return MediaType{}, false
+			}
+		}
+		return mt, true
+	}
+}
+
+//Detect returns the media type of content that starts with head, using the registered detectors. It returns
+//TextPlain for text of an unknown type (content without binary bytes, see http.DetectContentType for HTML), and
+//OctetStream if it doesn't recognize the content.
+func Detect(head []byte) MediaType {
+	if len(head) > SniffLen {
+		head = head[:SniffLen]
+	}
+	registryMu.RLock()
+	ds := detectors
+	registryMu.RUnlock()
+	for i := len(ds) - 1; i >= 0; i-- {
+		if mt, found := ds[i](head); found {
+			return mt
+		}
+	}
+	if bytes.HasPrefix(head, []byte{0xfe, 0xff}) || bytes.HasPrefix(head, []byte{0xff, 0xfe}) {
+		//UTF-16
+		return TextPlain
+	}
+	for _, b := range head {
+		isBinary := b <= 0x08 || b == 0x0b || b >= 0x0e && b <= 0x1a || b >= 0x1c && b <= 0x1f
+		if isBinary {
+			return OctetStream
+		}
+	}
+	if mt, err := Parse(http.DetectContentType(head)); err == nil && mt.Essence() == "text/html" {
+		return mt.WithoutParameters()
+	}
+	return TextPlain
+}
+
+//DetectReader detects the media type of the content of r, and returns a reader of the whole content.
+func DetectReader(r io.Reader) (MediaType, io.Reader, error) {
+	head := make([]byte, SniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return MediaType{}, nil, aldberr.Wrap(err, ErrorCodeInvalidMediaType, "cannot read content to detect its media type", nil)
+	}
+	head = head[:n]
+	return Detect(head), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+//IsA tells whether content of media type mt is also content of media type parent, ignoring parameters: parent
+//is mt or one of its registered parents, or the parent of a type with a suffix (e.g. "application/json" for
+//"application/ld+json"). Every type is a kind of OctetStream, and every "text/*" type a kind of TextPlain.
+func (mt MediaType) IsA(parent MediaType) bool {
+	essence, parentEssence := mt.Essence(), parent.Essence()
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	visited := map[string]bool{}
+	queue := []string{essence}
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+		if visited[e] {
+			continue
+		}
+		visited[e] = true
+		if e == parentEssence || parentEssence == OctetStream.Essence() {
+			return true
+		}
+		if strings.HasPrefix(e, "text/") {
+			queue = append(queue, TextPlain.Essence())
+		}
+		if i := strings.LastIndexByte(e, '+'); i > 0 {
+			queue = append(queue, "application/"+e[i+1:])
+		}
+		queue = append(queue, parents[e]...)
+	}
+	return false
+}
+
+//Check checks that content that starts with head is of the declared media type, or of a type that is a kind of
+//it (see IsA), or of a more general type than it. Content that isn't recognized is of any type.
+func Check(declared MediaType, head []byte) error {
+	detected := Detect(head)
+	if detected.Essence() == OctetStream.Essence() || detected.IsA(declared) || declared.IsA(detected) {
+		return nil
+	}
+	return aldberr.New(ErrorCodeMismatch, "content is not of the declared media type", map[string]interface{}{"declared": declared.Essence(), "detected": detected.Essence()})
+}
+
+func trimIncompleteRune(bts []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(bts); i++ {
+		if utf8.RuneStart(bts[len(bts)-i]) {
+			if !utf8.FullRune(bts[len(bts)-i:]) {
+				return bts[:len(bts)-i]
+			}
+			break
+		}
+	}
+	return bts
+}
+
+//region built-in detectors
+
+func init() {
+	Register(
+		Magic(MustParse("image/png"), 0, []byte("\x89PNG\r\n\x1a\n"), nil),
+		Magic(MustParse("image/jpeg"), 0, []byte{0xff, 0xd8, 0xff}, nil),
+		Magic(MustParse("image/gif"), 0, []byte("GIF87a"), nil),
+		Magic(MustParse("image/gif"), 0, []byte("GIF89a"), nil),
+		Magic(MustParse("image/webp"), 0, []byte("RIFF\x00\x00\x00\x00WEBPVP"), []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
+		Magic(MustParse("image/bmp"), 0, []byte("BM\x00\x00\x00\x00\x00\x00\x00\x00"), []byte{0xff, 0xff, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}),
+		Magic(MustParse("image/tiff"), 0, []byte("II*\x00"), nil),
+		Magic(MustParse("image/tiff"), 0, []byte("MM\x00*"), nil),
+		Magic(MustParse("audio/wav"), 0, []byte("RIFF\x00\x00\x00\x00WAVE"), []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}),
+		Magic(MustParse("audio/mpeg"), 0, []byte("ID3"), nil),
+		Magic(MustParse("audio/ogg"), 0, []byte("OggS"), nil),
+		Magic(MustParse("audio/flac"), 0, []byte("fLaC"), nil),
+		detectISOBMFF,
+		Magic(MustParse("video/webm"), 0, []byte{0x1a, 0x45, 0xdf, 0xa3}, nil),
+		Magic(MustParse("application/pdf"), 0, []byte("%PDF-"), nil),
+		Magic(MustParse("application/zip"), 0, []byte("PK\x03\x04"), nil),
+		Magic(MustParse("application/gzip"), 0, []byte{0x1f, 0x8b, 0x08}, nil),
+		Magic(MustParse("application/x-7z-compressed"), 0, []byte("7z\xbc\xaf\x27\x1c"), nil),
+		Magic(MustParse("application/wasm"), 0, []byte("\x00asm"), nil),
+		detectOpenDocument,
+		detectOfficeOpenXML,
+		detectXML,
+		detectJSON,
+	)
+	for _, zip := range []string{
+		"application/vnd.oasis.opendocument.text",
+		"application/vnd.oasis.opendocument.spreadsheet",
+		"application/vnd.oasis.opendocument.presentation",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	} {
+		RegisterParent(MustParse(zip), MustParse("application/zip"))
+	}
+	//an MP4 file with only audio is an MP4 file too, and HEIC is HEIF with HEVC coded images
+	RegisterParent(MustParse("audio/mp4"), MustParse("video/mp4"))
+	RegisterParent(MustParse("image/heic"), MustParse("image/heif"))
+	RegisterParent(MustParse("image/heic-sequence"), MustParse("image/heif-sequence"))
+	for _, text := range []string{"application/json", "application/xml", "application/javascript", "application/x-yaml", "application/yaml"} {
+		RegisterParent(MustParse(text), TextPlain)
+	}
+}
+
+//isoBrands maps the major brands of ISO base media files (MP4, QuickTime, 3GP, HEIF, ...) to their media type.
+var isoBrands = map[string]string{
+	"isom": "video/mp4", "iso2": "video/mp4", "iso4": "video/mp4", "iso5": "video/mp4", "iso6": "video/mp4",
+	"mp41": "video/mp4", "mp42": "video/mp4", "avc1": "video/mp4", "dash": "video/mp4", "mmp4": "video/mp4",
+	"M4V ": "video/mp4", "M4A ": "audio/mp4", "M4B ": "audio/mp4", "M4P ": "audio/mp4", "F4A ": "audio/mp4",
+	"qt  ": "video/quicktime",
+	"3gp4": "video/3gpp", "3gp5": "video/3gpp", "3gp6": "video/3gpp", "3gp7": "video/3gpp", "3gs7": "video/3gpp",
+	"3g2a": "video/3gpp2", "3g2b": "video/3gpp2", "3g2c": "video/3gpp2",
+	"heic": "image/heic", "heix": "image/heic", "heim": "image/heic", "heis": "image/heic",
+	"hevc": "image/heic-sequence", "hevx": "image/heic-sequence",
+	"mif1": "image/heif", "msf1": "image/heif-sequence",
+	"avif": "image/avif", "avis": "image/avif",
+}
+
+//detectISOBMFF recognizes ISO base media files by the major brand of their ftyp box. Files with an unknown
+//brand aren't recognized, as they may be of any of the formats that are based on it.
+func detectISOBMFF(head []byte) (MediaType, bool) {
+	if len(head) < 12 || string(head[4:8]) != "ftyp" {
+		return MediaType{}, false
+	}
+	if mt, found := isoBrands[string(head[8:12])]; found {
+		return MustParse(mt), true
+	}
+	return MediaType{}, false
+}
+
+//detectOpenDocument recognizes OpenDocument files by their mimetype entry, which is the first one of the ZIP
+//archive.
+func detectOpenDocument(head []byte) (MediaType, bool) {
+	const prefix = "PK\x03\x04"
+	if !bytes.HasPrefix(head, []byte(prefix)) || len(head) < 30 {
+		return MediaType{}, false
+	}
+	rest := head[30:]
+	if !bytes.HasPrefix(rest, []byte("mimetype")) {
+		return MediaType{}, false
+	}
+	rest = rest[len("mimetype"):]
+	for _, t := range []string{"text", "spreadsheet", "presentation"} {
+		name := "application/vnd.oasis.opendocument." + t
+		if bytes.HasPrefix(rest, []byte(name)) {
+			return MustParse(name), true
+		}
+	}
+	return MediaType{}, false
+}
+
+//detectOfficeOpenXML recognizes Office Open XML files by the names of the entries in the start of the ZIP
+//archive.
+func detectOfficeOpenXML(head []byte) (MediaType, bool) {
+	if !bytes.HasPrefix(head, []byte("PK\x03\x04")) || !bytes.Contains(head, []byte("[Content_Types].xml")) && !bytes.Contains(head, []byte("_rels/.rels")) {
+		return MediaType{}, false
+	}
+	for dir, t := range map[string]string{"word/": "wordprocessingml.document", "xl/": "spreadsheetml.sheet", "ppt/": "presentationml.presentation"} {
+		if bytes.Contains(head, []byte(dir)) {
+			return MustParse("application/vnd.openxmlformats-officedocument." + t), true
+		}
+	}
+	return MediaType{}, false
+}
+
+//detectXML recognizes XML documents by their declaration, and SVG images by their root element.
+func detectXML(head []byte) (MediaType, bool) {
+	text := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	isXML := bytes.HasPrefix(text, []byte("<?xml"))
+	if bytes.Contains(text, []byte("<svg")) && (isXML || bytes.HasPrefix(text, []byte("<svg"))) {
+		return MustParse("image/svg+xml"), true
+	}
+	if isXML {
+		return MustParse("application/xml"), true
+	}
+	return MediaType{}, false
+}
+
+//detectJSON recognizes JSON objects and arrays. As only the start of the content is known, it checks that the
+//start of the content is a valid start of a JSON document.
+func detectJSON(head []byte) (MediaType, bool) {
+	text := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(text) < 2 || text[0] != '{' && text[0] != '[' || !utf8.Valid(trimIncompleteRune(text)) {
+		return MediaType{}, false
+	}
+	rest := bytes.TrimLeft(text[1:], " \t\r\n")
+	if len(rest) == 0 {
+		return MediaType{}, false
+	}
+	switch c := rest[0]; {
+	case text[0] == '{' && (c == '"' || c == '}'):
+		return MustParse("application/json"), true
+	case text[0] == '[' && (c == '{' || c == '[' || c == '"' || c == ']' || c == '-' || c >= '0' && c <= '9' || c == 't' || c == 'f' || c == 'n'):
+		return MustParse("application/json"), true
+	}
+	return MediaType{}, false
+}
+
+//endregion
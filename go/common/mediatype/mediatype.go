@@ -1,20 +1,211 @@
+//Package mediatype models media types (RFC 6838), e.g. "application/vnd.api+json; charset=utf-8", and detects
+//them from content (see Detect).
 package mediatype
 
-import "mime"
+import (
+	"mime"
+	"net/http"
+	"strings"
 
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	ErrorCodeInvalidMediaType = "common-mediatype-invalid"
+	//ErrorCodeMismatch is returned when content is not of its declared media type, see Check.
+	ErrorCodeMismatch = "common-mediatype-mismatch"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidMediaType, Status: http.StatusBadRequest, Message: errcode.En("invalid media type")},
+		errcode.Code{Code: ErrorCodeMismatch, Status: http.StatusBadRequest, Message: errcode.En("the content is {detected}, not {declared}")},
+	)
+}
+
+//Wildcard is the Type or Subtype of a media range that matches any type or subtype, e.g. "image/*".
+const Wildcard = "*"
+
+//MediaType is a media type, or a media range with wildcards (see Matches). Its names are in lower case, as they
+//are case-insensitive.
 type MediaType struct {
-	Type       string
+	//Type is the top-level type, e.g. "application".
+	Type string
+	//Subtype is the subtype without its suffix, e.g. "vnd.api" for "application/vnd.api+json".
+	Subtype string
+	//Suffix is the structured syntax suffix without "+", e.g. "json" for "application/vnd.api+json".
+	Suffix string
+	//Parameters are the parameters by their names, e.g. "charset".
 	Parameters map[string]string
 }
 
-func MediaTypeMustParse(raw string) MediaType {
-	mediatype, params, err := mime.ParseMediaType(raw)
+//Parse parses a media type or a media range, e.g. "text/plain; charset=UTF-8", "image/*" or
+//"application/*+json". Type and subtype names must be restricted names (RFC 6838 section 4.2).
+func Parse(s string) (MediaType, error) {
+	errDet := map[string]interface{}{"raw": s}
+	essence, params, err := mime.ParseMediaType(s)
+	if err != nil {
+		return MediaType{}, aldberr.Wrap(err, ErrorCodeInvalidMediaType, "cannot parse media type", errDet)
+	}
+	typ, subtype, found := strings.Cut(essence, "/")
+	if !found {
+		return MediaType{}, aldberr.New(ErrorCodeInvalidMediaType, "cannot parse media type: no subtype", errDet)
+	}
+	mt := MediaType{Type: typ, Subtype: subtype}
+	if i := strings.LastIndexByte(subtype, '+'); i > 0 {
+		mt.Subtype, mt.Suffix = subtype[:i], subtype[i+1:]
+	}
+	switch {
+	case mt.Type == Wildcard && mt.Subtype != Wildcard:
+		return MediaType{}, aldberr.New(ErrorCodeInvalidMediaType, "cannot parse media type: only */* has a wildcard type", errDet)
+	case mt.Type != Wildcard && !isRestrictedName(mt.Type):
+		return MediaType{}, aldberr.New(ErrorCodeInvalidMediaType, "cannot parse media type: invalid type", errDet)
+	case mt.Subtype != Wildcard && !isRestrictedName(mt.Subtype):
+		return MediaType{}, aldberr.New(ErrorCodeInvalidMediaType, "cannot parse media type: invalid subtype", errDet)
+	case strings.Contains(subtype, "+") && !isRestrictedName(mt.Suffix):
+		return MediaType{}, aldberr.New(ErrorCodeInvalidMediaType, "cannot parse media type: invalid suffix", errDet)
+	}
+	if len(params) > 0 {
+		mt.Parameters = params
+	}
+	return mt, nil
+}
+
+//MustParse is like Parse, but panics if s isn't a valid media type. It is meant for constants.
+func MustParse(s string) MediaType {
+	mt, err := Parse(s)
 	if err != nil {
 		panic(err)
 	}
-	//TODO not correct!
-	return MediaType{
-		Type:       mediatype,
-		Parameters: params,
+	return mt
+}
+
+//Deprecated: MediaTypeMustParse is MustParse.
+func MediaTypeMustParse(raw string) MediaType {
+	return MustParse(raw)
+}
+
+//isRestrictedName checks the restricted-name of RFC 6838 section 4.2: up to 127 letters, digits and
+//"!#$&-^_.+", starting with a letter or digit.
+func isRestrictedName(s string) bool {
+	if len(s) == 0 || len(s) > 127 {
+		return false
+	}
+	for i, c := range s {
+		isLetterOrDigit := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !isLetterOrDigit && (i == 0 || !strings.ContainsRune("!#$&-^_.+", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+//IsZero tells whether mt is the zero MediaType, e.g. a media type that wasn't given.
+func (mt MediaType) IsZero() bool {
+	return len(mt.Type) == 0 && len(mt.Subtype) == 0
+}
+
+//Essence returns the media type without parameters, e.g. "application/vnd.api+json".
+func (mt MediaType) Essence() string {
+	if mt.IsZero() {
+		return ""
+	}
+	out := strings.ToLower(mt.Type + "/" + mt.Subtype)
+	if len(mt.Suffix) > 0 {
+		out += "+" + strings.ToLower(mt.Suffix)
+	}
+	return out
+}
+
+//WithoutParameters returns mt without parameters.
+func (mt MediaType) WithoutParameters() MediaType {
+	mt.Parameters = nil
+	return mt
+}
+
+//Parameter returns the value of a parameter, of which the name is case-insensitive.
+func (mt MediaType) Parameter(name string) (string, bool) {
+	v, found := mt.Parameters[strings.ToLower(name)]
+	return v, found
+}
+
+//IsWildcard tells whether mt is a media range, e.g. "*/*" or "image/*".
+func (mt MediaType) IsWildcard() bool {
+	return mt.Type == Wildcard || mt.Subtype == Wildcard
+}
+
+//Equal tells whether mt and o are the same media type, with the same parameters. Names are compared
+//regardless of case, and so is the value of the charset parameter.
+func (mt MediaType) Equal(o MediaType) bool {
+	if mt.Essence() != o.Essence() || len(mt.Parameters) != len(o.Parameters) {
+		return false
+	}
+	for k, v := range mt.Parameters {
+		if ov, found := o.Parameter(k); !found || !parameterValueEqual(k, v, ov) {
+			return false
+		}
+	}
+	return true
+}
+
+//Matches tells whether o is in the media range mt: its type and subtype are those of mt or mt has wildcards
+//for them, and it has the parameters of mt. A range with a suffix, e.g. "application/*+json", only matches
+//types with that suffix, and the type of the structured syntax itself ("application/json").
+func (mt MediaType) Matches(o MediaType) bool {
+	if mt.Type != Wildcard && !strings.EqualFold(mt.Type, o.Type) {
+		return false
+	}
+	switch {
+	case mt.Subtype != Wildcard:
+		if !strings.EqualFold(mt.Subtype, o.Subtype) || !strings.EqualFold(mt.Suffix, o.Suffix) {
+			return false
+		}
+	case len(mt.Suffix) > 0:
+		hasSuffix := strings.EqualFold(mt.Suffix, o.Suffix)
+		isSyntax := len(o.Suffix) == 0 && strings.EqualFold(mt.Suffix, o.Subtype)
+		if !hasSuffix && !isSyntax {
+			return false
+		}
+	}
+	for k, v := range mt.Parameters {
+		if ov, found := o.Parameter(k); !found || !parameterValueEqual(k, v, ov) {
+			return false
+		}
+	}
+	return true
+}
+
+func parameterValueEqual(name, v, o string) bool {
+	if strings.EqualFold(name, "charset") {
+		return strings.EqualFold(v, o)
+	}
+	return v == o
+}
+
+//String formats the media type, with its parameters in order, e.g. "text/plain; charset=utf-8". The zero
+//MediaType is "".
+func (mt MediaType) String() string {
+	if mt.IsZero() {
+		return ""
+	}
+	return mime.FormatMediaType(mt.Essence(), mt.Parameters)
+}
+
+func (mt MediaType) MarshalText() ([]byte, error) {
+	return []byte(mt.String()), nil
+}
+
+//UnmarshalText parses the media type, or gives the zero MediaType for "".
+func (mt *MediaType) UnmarshalText(bts []byte) error {
+	if len(bts) == 0 {
+		*mt = MediaType{}
+		return nil
+	}
+	parsed, err := Parse(string(bts))
+	if err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidMediaType, "cannot unmarshal MediaType", map[string]interface{}{"raw": string(bts)})
 	}
+	*mt = parsed
+	return nil
 }
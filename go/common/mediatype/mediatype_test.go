@@ -0,0 +1,200 @@
+package mediatype
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+func TestParse(t *testing.T) {
+	for in, expected := range map[string]string{
+		"text/plain":                          "text/plain",
+		"Text/HTML; Charset=UTF-8":            "text/html; charset=UTF-8",
+		"application/vnd.api+json":            "application/vnd.api+json",
+		"application/*+json":                  "application/*+json",
+		"image/*":                             "image/*",
+		"*/*":                                 "*/*",
+		`multipart/form-data; boundary="a b"`: `multipart/form-data; boundary="a b"`,
+		"application/vnd.ms-excel.sheet.macroEnabled.12": "application/vnd.ms-excel.sheet.macroenabled.12",
+	} {
+		mt, err := Parse(in)
+		if err != nil || mt.String() != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", in, expected, mt.String(), err)
+		}
+	}
+	for _, in := range []string{"", "text", "text/", "*/plain", "text/pl ain", "-text/plain", "application/json+", "text/plain; charset"} {
+		if mt, err := Parse(in); err == nil {
+			t.Errorf("%q: expected an error, got %q", in, mt)
+		}
+	}
+	mt := MustParse("application/vnd.api+json; Profile=x")
+	if mt.Type != "application" || mt.Subtype != "vnd.api" || mt.Suffix != "json" {
+		t.Errorf("unexpected parts %#v", mt)
+	}
+	if v, found := mt.Parameter("PROFILE"); !found || v != "x" {
+		t.Errorf("expected a case-insensitive parameter name, got %q", v)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	for _, c := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{"text/plain", "TEXT/Plain", true},
+		{"text/plain; charset=utf-8", "text/plain; CHARSET=UTF-8", true},
+		{"text/plain; format=flowed", "text/plain; format=Flowed", false},
+		{"text/plain", "text/plain; charset=utf-8", false},
+		{"application/ld+json", "application/json", false},
+	} {
+		if MustParse(c.a).Equal(MustParse(c.b)) != c.equal {
+			t.Errorf("%q = %q: expected %v", c.a, c.b, c.equal)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	for _, c := range []struct {
+		mediaRange, mt string
+		matches        bool
+	}{
+		{"*/*", "image/png", true},
+		{"image/*", "IMAGE/png", true},
+		{"image/*", "text/plain", false},
+		{"application/*+json", "application/ld+json", true},
+		{"application/*+json", "application/json", true},
+		{"application/*+json", "application/xml", false},
+		{"text/plain", "text/plain; charset=utf-8", true},
+		{"text/plain; charset=utf-8", "text/plain", false},
+		{"text/plain; charset=utf-8", "text/plain; charset=UTF-8; format=flowed", true},
+		{"application/json", "application/ld+json", false},
+	} {
+		if MustParse(c.mediaRange).Matches(MustParse(c.mt)) != c.matches {
+			t.Errorf("%q in %q: expected %v", c.mt, c.mediaRange, c.matches)
+		}
+	}
+}
+
+func TestUnmarshalText(t *testing.T) {
+	mt := MustParse("text/plain")
+	if err := mt.UnmarshalText(nil); err != nil || !mt.IsZero() || mt.String() != "" {
+		t.Errorf("expected the zero MediaType, got %q (%v)", mt, err)
+	}
+	if err := mt.UnmarshalText([]byte("text")); !aldberr.IsCode(err, ErrorCodeInvalidMediaType) {
+		t.Errorf("expected %s, got %v", ErrorCodeInvalidMediaType, err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for in, expected := range map[string]string{
+		"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR": "image/png",
+		"\xff\xd8\xff\xe0\x00\x10JFIF":        "image/jpeg",
+		"GIF89a\x01\x00":                      "image/gif",
+		"RIFF\x24\x00\x00\x00WEBPVP8 ":        "image/webp",
+		"\x00\x00\x00\x18ftypmp42":            "video/mp4",
+		"\x00\x00\x00\x18ftypheic":            "image/heic",
+		"\x00\x00\x00\x14ftypqt  ":            "video/quicktime",
+		"\x00\x00\x00\x1cftypavif":            "image/avif",
+		"\x00\x00\x00\x20ftypM4A ":            "audio/mp4",
+		"\x00\x00\x00\x18ftyp3gp5":            "video/3gpp",
+		"%PDF-1.7\n":                          "application/pdf",
+		"PK\x03\x04" + strings.Repeat("\x00", 26) + "mimetypeapplication/vnd.oasis.opendocument.text": "application/vnd.oasis.opendocument.text",
+		"PK\x03\x04\x14\x00[Content_Types].xml\x00word/document.xml":                                  "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"PK\x03\x04\x14\x00\x00\x00":                                    "application/zip",
+		`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg">`: "image/svg+xml",
+		`<?xml version="1.0"?><note/>`:                                  "application/xml",
+		` {"id": "x"}`:                                                  "application/json",
+		"[1, 2":                                                         "application/json",
+		"[link](https://example.com)":                                   "text/plain",
+		"<!DOCTYPE html><html>":                                         "text/html",
+		"BMW cars":                                                      "text/plain",
+		"h\xc3\xa9":                                                     "text/plain",
+		"\x00\x01binary":                                                "application/octet-stream",
+		"":                                                              "text/plain",
+	} {
+		if mt := Detect([]byte(in)); mt.String() != expected {
+			t.Errorf("%q: expected %q, got %q", in, expected, mt)
+		}
+	}
+}
+
+func TestDetectReader(t *testing.T) {
+	content := "%PDF-1.7\n" + strings.Repeat("x", 2*SniffLen)
+	mt, r, err := DetectReader(strings.NewReader(content))
+	if err != nil || mt.Essence() != "application/pdf" {
+		t.Fatalf("expected application/pdf, got %q (%v)", mt, err)
+	}
+	if bts, _ := io.ReadAll(r); string(bts) != content {
+		t.Errorf("expected the whole content, got %d bytes", len(bts))
+	}
+}
+
+func TestRegister(t *testing.T) {
+	epub := MustParse("application/epub+zip")
+	Register(func(head []byte) (MediaType, bool) {
+		return epub, strings.Contains(string(head), "mimetypeapplication/epub+zip")
+	})
+	RegisterParent(epub, MustParse("application/zip"))
+	head := []byte("PK\x03\x04" + strings.Repeat("\x00", 26) + "mimetypeapplication/epub+zip")
+	if mt := Detect(head); !mt.Equal(epub) {
+		t.Errorf("expected the registered detector to be tried first, got %q", mt)
+	}
+	if err := Check(MustParse("application/zip"), head); err != nil {
+		t.Errorf("expected an EPUB to be a ZIP archive: %v", err)
+	}
+}
+
+func TestIsA(t *testing.T) {
+	for _, c := range []struct {
+		mt, parent string
+		isA        bool
+	}{
+		{"image/png", "image/png", true},
+		{"image/png", "application/octet-stream", true},
+		{"text/markdown", "text/plain", true},
+		{"application/ld+json", "application/json", true},
+		{"application/ld+json", "text/plain", true},
+		{"application/vnd.oasis.opendocument.text", "application/zip", true},
+		{"image/svg+xml", "text/plain", true},
+		{"text/plain", "text/markdown", false},
+		{"image/png", "image/jpeg", false},
+	} {
+		if MustParse(c.mt).IsA(MustParse(c.parent)) != c.isA {
+			t.Errorf("%q is a %q: expected %v", c.mt, c.parent, c.isA)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+	for _, c := range []struct {
+		declared string
+		head     []byte
+		ok       bool
+	}{
+		{"image/png", png, true},
+		{"application/octet-stream", png, true},
+		{"text/markdown", []byte("# Notes"), true},
+		{"application/json", []byte(`{"a": 1}`), true},
+		{"application/geo+json", []byte(`{"type": "Point"}`), true},
+		{"text/csv", []byte{0x00, 0x01, 0x02}, true},
+		{"text/markdown", png, false},
+		{"image/jpeg", png, false},
+		{"image/png", []byte("# Notes"), false},
+		{"video/quicktime", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00"), true},
+		{"image/avif", []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00"), true},
+		{"audio/mp4", []byte("\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00"), true},
+		{"audio/mp4", []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00"), true},
+		{"video/3gpp", []byte("\x00\x00\x00\x18ftyp3gp5\x00\x00\x00\x00"), true},
+		{"image/heic", []byte("\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00"), true},
+		{"video/mp4", []byte("\x00\x00\x00\x18ftypcrx \x00\x00\x00\x00"), true},
+		{"video/mp4", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00"), false},
+	} {
+		err := Check(MustParse(c.declared), c.head)
+		if (err == nil) != c.ok || (err != nil && !aldberr.IsCode(err, ErrorCodeMismatch)) {
+			t.Errorf("%q as %q: expected ok %v, got %v", c.head, c.declared, c.ok, err)
+		}
+	}
+}
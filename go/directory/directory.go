@@ -0,0 +1,142 @@
+//Package directory keeps the entities that participate in activities (persons, organisations and groups),
+//by their participation.EntityRef, and the members of the groups.
+//
+//A group can have any entity as member, including other groups, so that e.g. "Team Blue" can be a member of
+//"Engineering". The members of a group are resolved transitively: the members of Team Blue are then also
+//members of Engineering. Memberships never form a cycle.
+package directory
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	ErrorCodeNotFound = "directory-not-found"
+	//ErrorCodeAlreadyExists is returned when an entity is created with the EntityRef of an existing one.
+	ErrorCodeAlreadyExists  = "directory-already-exists"
+	ErrorCodeInvalidRequest = "directory-invalid-request"
+	//ErrorCodeEmailInUse is returned when an entity gets an email address of another entity. The details
+	//contain the "email" and the "entity" that has it.
+	ErrorCodeEmailInUse = "directory-email-in-use"
+	//ErrorCodeMembershipCycle is returned when members are set that would make a group (indirectly) a member
+	//of itself.
+	ErrorCodeMembershipCycle = "directory-membership-cycle"
+	ErrorCodeInvalidConfig   = "directory-invalid-config"
+	//ErrorCodeFilesystem is returned when a change cannot be written to the file of the directory (see
+	//ReadMemoryDirectory), in which case the change is not made.
+	ErrorCodeFilesystem = "directory-fs-error"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeNotFound, Status: http.StatusNotFound, Message: errcode.En("{entity} not found")},
+		errcode.Code{Code: ErrorCodeAlreadyExists, Status: http.StatusConflict, Message: errcode.En("{entity} already exists")},
+		errcode.Code{Code: ErrorCodeInvalidRequest, Status: http.StatusBadRequest, Message: errcode.En("invalid directory request")},
+		errcode.Code{Code: ErrorCodeEmailInUse, Status: http.StatusConflict, Message: errcode.En("{email} is already used by {entity}")},
+		errcode.Code{Code: ErrorCodeMembershipCycle, Status: http.StatusConflict, Message: errcode.En("{group} would become a member of itself")},
+		errcode.Code{Code: ErrorCodeInvalidConfig, Status: http.StatusInternalServerError, Message: errcode.En("invalid directory configuration")},
+		errcode.Code{Code: ErrorCodeFilesystem, Status: http.StatusInternalServerError, Message: errcode.En("cannot write the directory to the filesystem")},
+	)
+}
+
+//Directory stores entities, which are a *participation.Person, a *participation.Organisation or a
+//*participation.Group with a complete EntityRef, and the members of the groups. An email address (without
+//case) belongs to at most one entity. Implementations must be safe for concurrent use.
+//
+//Entities going in and out of a Directory are copies: a Directory never keeps or hands out pointers into its
+//own state.
+type Directory interface {
+	Create(ctx context.Context, req CreateRequest) (CreateResponse, error)
+	Read(ctx context.Context, req ReadRequest) (ReadResponse, error)
+	List(ctx context.Context, req ListRequest) (ListResponse, error)
+	Update(ctx context.Context, req UpdateRequest) (UpdateResponse, error)
+	Delete(ctx context.Context, req DeleteRequest) (DeleteResponse, error)
+	SetMembers(ctx context.Context, req SetMembersRequest) (SetMembersResponse, error)
+	ReadMembers(ctx context.Context, req ReadMembersRequest) (ReadMembersResponse, error)
+	ReadGroups(ctx context.Context, req ReadGroupsRequest) (ReadGroupsResponse, error)
+}
+
+type CreateRequest struct {
+	//ToCreate is the new entity, of which the EntityRef must not be in the Directory yet.
+	ToCreate participation.Entity
+}
+
+type CreateResponse struct {
+	Created participation.Entity
+}
+
+type ReadRequest struct {
+	Ref participation.EntityRef
+}
+
+type ReadResponse struct {
+	Entity participation.Entity
+}
+
+type ListRequest struct {
+	//Email, if not empty, only lists the entity with the email address (without case), if any.
+	Email string
+}
+
+type ListResponse struct {
+	//Entities contains the entities that match the request, sorted by the name of their EntityRef.
+	Entities []participation.Entity
+}
+
+//UpdateRequest replaces an entity by one of the same type, e.g. a group cannot become a person.
+type UpdateRequest struct {
+	ToUpdate participation.Entity
+}
+
+type UpdateResponse struct {
+	Updated participation.Entity
+}
+
+//DeleteRequest deletes an entity, together with its memberships: it is removed from the groups it is a member
+//of, and if it is a group, its members are no longer members through it.
+type DeleteRequest struct {
+	Ref participation.EntityRef
+}
+
+type DeleteResponse struct {
+}
+
+//SetMembersRequest replaces the (direct) members of a group.
+type SetMembersRequest struct {
+	Group participation.EntityRef
+	//Members are the entities in the Directory that are the members of the Group, in order and each once.
+	Members []participation.EntityRef
+}
+
+type SetMembersResponse struct {
+	//Members are the direct members of the group.
+	Members []participation.Entity
+}
+
+type ReadMembersRequest struct {
+	Group participation.EntityRef
+	//Transitive also reads the members of the groups that are members, and of theirs, ...
+	Transitive bool
+}
+
+type ReadMembersResponse struct {
+	//Members contains the members of the group, in order, each once. Transitive members come after the direct
+	//ones (breadth-first).
+	Members []participation.Entity
+}
+
+type ReadGroupsRequest struct {
+	Member participation.EntityRef
+	//Transitive also reads the groups of which the groups are members, and theirs, ...
+	Transitive bool
+}
+
+type ReadGroupsResponse struct {
+	//Groups contains the groups the entity is a member of, each once, sorted by the name of their EntityRef for
+	//the direct groups. Transitive groups come after the direct ones (breadth-first).
+	Groups []participation.Entity
+}
@@ -0,0 +1,257 @@
+package directory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+)
+
+func entity(id string) participation.EntityRef {
+	return participation.EntityRef{EntityId: id}
+}
+
+func group(id, name string) *participation.Group {
+	return &participation.Group{Ref: entity(id), Name: lang.LocalizableString{lang.LangAny: name}}
+}
+
+func code(err error) string {
+	c, _ := aldberr.CodeOf(err)
+	return c
+}
+
+func names(es []participation.Entity) []string {
+	out := []string{}
+	for _, e := range es {
+		out = append(out, e.EntityRef().EntityId)
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//newTestDirectory creates engineering > (team-blue > (alice, bob), carol), and doe.
+func newTestDirectory(t *testing.T) *MemoryDirectory {
+	t.Helper()
+	ctx := context.Background()
+	d := NewMemoryDirectory()
+	for _, e := range []participation.Entity{
+		&participation.Person{Ref: entity("alice"), Name: participation.PersonName{Given: "Alice"}, Email: "alice@doe.eu"},
+		&participation.Person{Ref: entity("bob"), Name: participation.PersonName{Given: "Bob"}},
+		&participation.Person{Ref: entity("carol"), Name: participation.PersonName{Given: "Carol"}},
+		&participation.Organisation{Ref: entity("doe"), Name: participation.LocalizableOrganisationName{lang.LangAny: {Short: "Doe"}}, Email: "info@doe.eu"},
+		group("team-blue", "Team Blue"),
+		group("engineering", "Engineering"),
+	} {
+		if _, err := d.Create(ctx, CreateRequest{ToCreate: e}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, req := range []SetMembersRequest{
+		{Group: entity("team-blue"), Members: []participation.EntityRef{entity("alice"), entity("bob"), entity("alice")}},
+		{Group: entity("engineering"), Members: []participation.EntityRef{entity("team-blue"), entity("carol")}},
+	} {
+		if _, err := d.SetMembers(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+func TestEntities(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDirectory(t)
+
+	res, err := d.List(ctx, ListRequest{})
+	if err != nil || !equal(names(res.Entities), []string{"alice", "bob", "carol", "doe", "engineering", "team-blue"}) {
+		t.Errorf("unexpected entities %v (%v)", names(res.Entities), err)
+	}
+	if res, err := d.List(ctx, ListRequest{Email: "Alice@Doe.eu"}); err != nil || !equal(names(res.Entities), []string{"alice"}) {
+		t.Errorf("unexpected lookup by email %v (%v)", names(res.Entities), err)
+	}
+	if res, err := d.List(ctx, ListRequest{Email: "nobody@doe.eu"}); err != nil || len(res.Entities) != 0 {
+		t.Errorf("unexpected lookup by unknown email %v (%v)", names(res.Entities), err)
+	}
+
+	read, err := d.Read(ctx, ReadRequest{Ref: entity("alice")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//entities are copies
+	read.Entity.(*participation.Person).Email = "changed@doe.eu"
+	alice := &participation.Person{Ref: entity("alice"), Name: participation.PersonName{Given: "Alice", Family: "Doe"}, Email: "alice@doe.eu"}
+	alice.Contact.Emails = []participation.ContactPoint{{Value: "alice@home.eu"}}
+	if _, err := d.Update(ctx, UpdateRequest{ToUpdate: alice}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := d.List(ctx, ListRequest{Email: "alice@home.eu"}); err != nil || !equal(names(res.Entities), []string{"alice"}) {
+		t.Errorf("expected the other email addresses to be found, got %v (%v)", names(res.Entities), err)
+	}
+
+	for _, c := range []struct {
+		err  error
+		code string
+	}{
+		{errOf(d.Create(ctx, CreateRequest{ToCreate: &participation.Person{Ref: entity("alice")}})), ErrorCodeAlreadyExists},
+		{errOf(d.Create(ctx, CreateRequest{ToCreate: &participation.Person{}})), ErrorCodeInvalidRequest},
+		{errOf(d.Create(ctx, CreateRequest{ToCreate: &participation.User{}})), ErrorCodeInvalidRequest},
+		{errOf(d.Create(ctx, CreateRequest{ToCreate: &participation.Person{Ref: entity("dave"), Email: "ALICE@doe.eu"}})), ErrorCodeEmailInUse},
+		{errOf(d.Read(ctx, ReadRequest{Ref: entity("dave")})), ErrorCodeNotFound},
+		{errOf(d.Update(ctx, UpdateRequest{ToUpdate: &participation.Person{Ref: entity("dave")}})), ErrorCodeNotFound},
+		{errOf(d.Update(ctx, UpdateRequest{ToUpdate: group("alice", "Alice")})), ErrorCodeInvalidRequest},
+		{errOf(d.Update(ctx, UpdateRequest{ToUpdate: &participation.Person{Ref: entity("bob"), Email: "info@doe.eu"}})), ErrorCodeEmailInUse},
+		{errOf(d.Delete(ctx, DeleteRequest{Ref: entity("dave")})), ErrorCodeNotFound},
+	} {
+		if code(c.err) != c.code {
+			t.Errorf("expected %s, got %v", c.code, c.err)
+		}
+	}
+}
+
+func TestMembers(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDirectory(t)
+
+	for _, c := range []struct {
+		group      string
+		transitive bool
+		expected   []string
+	}{
+		{"team-blue", false, []string{"alice", "bob"}},
+		{"engineering", false, []string{"team-blue", "carol"}},
+		{"engineering", true, []string{"team-blue", "carol", "alice", "bob"}},
+	} {
+		res, err := d.ReadMembers(ctx, ReadMembersRequest{Group: entity(c.group), Transitive: c.transitive})
+		if err != nil || !equal(names(res.Members), c.expected) {
+			t.Errorf("%s (transitive %v): expected %v, got %v (%v)", c.group, c.transitive, c.expected, names(res.Members), err)
+		}
+	}
+	for _, c := range []struct {
+		member     string
+		transitive bool
+		expected   []string
+	}{
+		{"alice", false, []string{"team-blue"}},
+		{"alice", true, []string{"team-blue", "engineering"}},
+		{"carol", true, []string{"engineering"}},
+		{"doe", true, []string{}},
+	} {
+		res, err := d.ReadGroups(ctx, ReadGroupsRequest{Member: entity(c.member), Transitive: c.transitive})
+		if err != nil || !equal(names(res.Groups), c.expected) {
+			t.Errorf("%s (transitive %v): expected %v, got %v (%v)", c.member, c.transitive, c.expected, names(res.Groups), err)
+		}
+	}
+
+	for _, c := range []struct {
+		req  SetMembersRequest
+		code string
+	}{
+		{SetMembersRequest{Group: entity("team-blue"), Members: []participation.EntityRef{entity("engineering")}}, ErrorCodeMembershipCycle},
+		{SetMembersRequest{Group: entity("team-blue"), Members: []participation.EntityRef{entity("team-blue")}}, ErrorCodeMembershipCycle},
+		{SetMembersRequest{Group: entity("team-blue"), Members: []participation.EntityRef{entity("dave")}}, ErrorCodeNotFound},
+		{SetMembersRequest{Group: entity("doe"), Members: []participation.EntityRef{entity("alice")}}, ErrorCodeInvalidRequest},
+	} {
+		if _, err := d.SetMembers(ctx, c.req); code(err) != c.code {
+			t.Errorf("%+v: expected %s, got %v", c.req, c.code, err)
+		}
+	}
+
+	if _, err := d.Delete(ctx, DeleteRequest{Ref: entity("team-blue")}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := d.ReadMembers(ctx, ReadMembersRequest{Group: entity("engineering"), Transitive: true}); err != nil || !equal(names(res.Members), []string{"carol"}) {
+		t.Errorf("expected the members of a deleted group to leave, got %v (%v)", names(res.Members), err)
+	}
+	if res, err := d.ReadGroups(ctx, ReadGroupsRequest{Member: entity("alice")}); err != nil || len(res.Groups) != 0 {
+		t.Errorf("expected no groups, got %v (%v)", names(res.Groups), err)
+	}
+}
+
+func TestReadMemoryDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "directory.json")
+	content := `{"entities": [
+		{"entity": "entities/alice", "givenName": "Alice", "email": "alice@doe.eu"},
+		{"entity": "entities/team-blue", "kind": "group", "display": "Team Blue"}],
+	 "members": {"entities/team-blue": ["entities/alice"]}}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := ReadMemoryDirectory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := d.ReadGroups(context.Background(), ReadGroupsRequest{Member: entity("alice")})
+	if err != nil || len(res.Groups) != 1 || res.Groups[0].DisplayName(lang.LangEn) != "Team Blue" {
+		t.Errorf("unexpected groups %v (%v)", res.Groups, err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"members": {"entities/team-blue": ["entities/alice"]}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadMemoryDirectory(path); code(err) != ErrorCodeInvalidConfig {
+		t.Errorf("expected %s, got %v", ErrorCodeInvalidConfig, err)
+	}
+}
+
+func TestMemoryDirectoryFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "directory.json")
+	if err := os.WriteFile(path, []byte(`{"entities": [{"entity": "entities/team-blue", "kind": "group", "display": "Team Blue"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := ReadMemoryDirectory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &participation.Person{Ref: entity("alice"), Name: participation.PersonName{Given: "Alice"}, Email: "alice@doe.eu"}
+	if _, err := d.Create(ctx, CreateRequest{ToCreate: alice}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.SetMembers(ctx, SetMembersRequest{Group: entity("team-blue"), Members: []participation.EntityRef{entity("alice")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	reread, err := ReadMemoryDirectory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := reread.ReadMembers(ctx, ReadMembersRequest{Group: entity("team-blue")}); err != nil || !equal(names(res.Members), []string{"alice"}) {
+		t.Errorf("expected the changes to be written to the file, got %v (%v)", names(res.Members), err)
+	}
+	if res, err := reread.List(ctx, ListRequest{Email: "alice@doe.eu"}); err != nil || len(res.Entities) != 1 || res.Entities[0].DisplayName(lang.LangEn) != "Alice" {
+		t.Errorf("expected alice in the file, got %v (%v)", res.Entities, err)
+	}
+
+	//a directory in the way of the temporary file makes writing fail
+	if err := os.Mkdir(path+".tmp", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Create(ctx, CreateRequest{ToCreate: &participation.Person{Ref: entity("bob"), Email: "bob@doe.eu"}})
+	if code(err) != ErrorCodeFilesystem {
+		t.Errorf("expected %s, got %v", ErrorCodeFilesystem, err)
+	}
+	if _, err := d.Read(ctx, ReadRequest{Ref: entity("bob")}); code(err) != ErrorCodeNotFound {
+		t.Errorf("expected a change that isn't written not to be made, got %v", err)
+	}
+	if res, err := d.List(ctx, ListRequest{Email: "bob@doe.eu"}); err != nil || len(res.Entities) != 0 {
+		t.Errorf("expected the email address of bob to be free, got %v (%v)", res.Entities, err)
+	}
+}
+
+func errOf[T any](_ T, err error) error {
+	return err
+}
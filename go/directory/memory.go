@@ -0,0 +1,482 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//MemoryDirectory is a Directory that keeps everything in memory.
+type MemoryDirectory struct {
+	mu sync.RWMutex
+	//entities maps the names of the EntityRefs to the entities.
+	entities map[string]participation.Entity
+	//members maps the names of groups to their members, in order.
+	members map[string][]participation.EntityRef
+	//emails maps lower case email addresses to the names of the entities that have them.
+	emails map[string]string
+	//path is the file the directory was read from, to which changes are written (see ReadMemoryDirectory).
+	path string
+}
+
+//memoryState is what a change of a MemoryDirectory can replace, to restore when it cannot be written.
+type memoryState struct {
+	entities map[string]participation.Entity
+	members  map[string][]participation.EntityRef
+	emails   map[string]string
+}
+
+//directoryJSON is the form of the file of ReadMemoryDirectory.
+type directoryJSON struct {
+	Entities []json.RawMessage                                     `json:"entities"`
+	Members  map[participation.EntityRef][]participation.EntityRef `json:"members"`
+}
+
+func NewMemoryDirectory() *MemoryDirectory {
+	return &MemoryDirectory{entities: map[string]participation.Entity{}, members: map[string][]participation.EntityRef{}, emails: map[string]string{}}
+}
+
+//ReadMemoryDirectory reads a MemoryDirectory from a JSON file with the entities, in the JSON form of
+//participators (see participation.MarshalEntity), and the members of the groups by name, e.g.
+//
+//	{"entities": [
+//		{"entity": "entities/alice", "givenName": "Alice", "familyName": "Doe", "email": "alice@doe.eu"},
+//		{"entity": "entities/team-blue", "kind": "group", "display": "Team Blue"}],
+//	 "members": {"entities/team-blue": ["entities/alice"]}}
+//
+//Changes are written back to the file, which is replaced at once so that it is never half written, and a
+//change that cannot be written is not made (see ErrorCodeFilesystem). The file must therefore not be changed
+//by others while the directory is used.
+func ReadMemoryDirectory(path string) (*MemoryDirectory, error) {
+	errDet := map[string]interface{}{"path": path}
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalidConfig, "cannot read directory", errDet)
+	}
+	dj := directoryJSON{}
+	if err := json.Unmarshal(bts, &dj); err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalidConfig, "cannot unmarshal directory", errDet)
+	}
+	ctx := context.Background()
+	out := NewMemoryDirectory()
+	for _, raw := range dj.Entities {
+		e, err := participation.UnmarshalEntity(raw)
+		if err == nil {
+			_, err = out.Create(ctx, CreateRequest{ToCreate: e})
+		}
+		if err != nil {
+			return nil, aldberr.Wrap(err, ErrorCodeInvalidConfig, "invalid entity in directory", errDet)
+		}
+	}
+	for group, members := range dj.Members {
+		if _, err := out.SetMembers(ctx, SetMembersRequest{Group: group, Members: members}); err != nil {
+			return nil, aldberr.Wrap(err, ErrorCodeInvalidConfig, "invalid members in directory", errDet)
+		}
+	}
+	out.path = path
+	return out, nil
+}
+
+func (d *MemoryDirectory) Create(ctx context.Context, req CreateRequest) (CreateResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return CreateResponse{}, err
+	}
+	name, err := check(req.ToCreate)
+	if err != nil {
+		return CreateResponse{}, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, found := d.entities[name]; found {
+		return CreateResponse{}, aldberr.New(ErrorCodeAlreadyExists, "entity already exists", map[string]interface{}{"entity": name})
+	}
+	if err := d.checkEmails(name, req.ToCreate); err != nil {
+		return CreateResponse{}, err
+	}
+	before := d.snapshot()
+	d.put(name, participation.CloneEntity(req.ToCreate))
+	if err := d.save(before); err != nil {
+		return CreateResponse{}, err
+	}
+	return CreateResponse{Created: participation.CloneEntity(req.ToCreate)}, nil
+}
+
+func (d *MemoryDirectory) Read(ctx context.Context, req ReadRequest) (ReadResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ReadResponse{}, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	e, err := d.get(req.Ref)
+	if err != nil {
+		return ReadResponse{}, err
+	}
+	return ReadResponse{Entity: participation.CloneEntity(e)}, nil
+}
+
+func (d *MemoryDirectory) List(ctx context.Context, req ListRequest) (ListResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ListResponse{}, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	out := []participation.Entity{}
+	if len(req.Email) > 0 {
+		if name, found := d.emails[strings.ToLower(req.Email)]; found {
+			out = append(out, participation.CloneEntity(d.entities[name]))
+		}
+		return ListResponse{Entities: out}, nil
+	}
+	names := make([]string, 0, len(d.entities))
+	for name := range d.entities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out = append(out, participation.CloneEntity(d.entities[name]))
+	}
+	return ListResponse{Entities: out}, nil
+}
+
+func (d *MemoryDirectory) Update(ctx context.Context, req UpdateRequest) (UpdateResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return UpdateResponse{}, err
+	}
+	name, err := check(req.ToUpdate)
+	if err != nil {
+		return UpdateResponse{}, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	existing, err := d.get(req.ToUpdate.EntityRef())
+	if err != nil {
+		return UpdateResponse{}, err
+	}
+	if kind(existing) != kind(req.ToUpdate) {
+		return UpdateResponse{}, aldberr.New(ErrorCodeInvalidRequest, "cannot change the type of an entity", map[string]interface{}{"entity": name, "type": kind(existing)})
+	}
+	if err := d.checkEmails(name, req.ToUpdate); err != nil {
+		return UpdateResponse{}, err
+	}
+	before := d.snapshot()
+	d.remove(name)
+	d.put(name, participation.CloneEntity(req.ToUpdate))
+	if err := d.save(before); err != nil {
+		return UpdateResponse{}, err
+	}
+	return UpdateResponse{Updated: participation.CloneEntity(req.ToUpdate)}, nil
+}
+
+func (d *MemoryDirectory) Delete(ctx context.Context, req DeleteRequest) (DeleteResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return DeleteResponse{}, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.get(req.Ref); err != nil {
+		return DeleteResponse{}, err
+	}
+	before := d.snapshot()
+	name := req.Ref.ToName()
+	d.remove(name)
+	delete(d.members, name)
+	for group, members := range d.members {
+		kept := make([]participation.EntityRef, 0, len(members))
+		for _, m := range members {
+			if m != req.Ref {
+				kept = append(kept, m)
+			}
+		}
+		d.setMembers(group, kept)
+	}
+	if err := d.save(before); err != nil {
+		return DeleteResponse{}, err
+	}
+	return DeleteResponse{}, nil
+}
+
+func (d *MemoryDirectory) SetMembers(ctx context.Context, req SetMembersRequest) (SetMembersResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return SetMembersResponse{}, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.getGroup(req.Group); err != nil {
+		return SetMembersResponse{}, err
+	}
+	members := []participation.EntityRef{}
+	out := []participation.Entity{}
+	seen := map[participation.EntityRef]bool{}
+	for _, m := range req.Members {
+		e, err := d.get(m)
+		if err != nil {
+			return SetMembersResponse{}, err
+		}
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		//the group would be a member of itself if it is the member or one of its transitive members
+		if m == req.Group || d.isTransitiveMember(req.Group, m) {
+			return SetMembersResponse{}, aldberr.New(ErrorCodeMembershipCycle, "membership would make a cycle", map[string]interface{}{"group": req.Group.ToName(), "member": m.ToName()})
+		}
+		members = append(members, m)
+		out = append(out, participation.CloneEntity(e))
+	}
+	before := d.snapshot()
+	d.setMembers(req.Group.ToName(), members)
+	if err := d.save(before); err != nil {
+		return SetMembersResponse{}, err
+	}
+	return SetMembersResponse{Members: out}, nil
+}
+
+func (d *MemoryDirectory) ReadMembers(ctx context.Context, req ReadMembersRequest) (ReadMembersResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ReadMembersResponse{}, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if _, err := d.getGroup(req.Group); err != nil {
+		return ReadMembersResponse{}, err
+	}
+	out := []participation.Entity{}
+	seen := map[participation.EntityRef]bool{req.Group: true}
+	for queue := []participation.EntityRef{req.Group}; len(queue) > 0; queue = queue[1:] {
+		for _, m := range d.members[queue[0].ToName()] {
+			if seen[m] {
+				continue
+			}
+			seen[m] = true
+			out = append(out, participation.CloneEntity(d.entities[m.ToName()]))
+			if req.Transitive {
+				queue = append(queue, m)
+			}
+		}
+	}
+	return ReadMembersResponse{Members: out}, nil
+}
+
+func (d *MemoryDirectory) ReadGroups(ctx context.Context, req ReadGroupsRequest) (ReadGroupsResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ReadGroupsResponse{}, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if _, err := d.get(req.Member); err != nil {
+		return ReadGroupsResponse{}, err
+	}
+	out := []participation.Entity{}
+	seen := map[string]bool{req.Member.ToName(): true}
+	for queue := []participation.EntityRef{req.Member}; len(queue) > 0; queue = queue[1:] {
+		for _, group := range d.groupsOf(queue[0]) {
+			if seen[group] {
+				continue
+			}
+			seen[group] = true
+			g := d.entities[group]
+			out = append(out, participation.CloneEntity(g))
+			if req.Transitive {
+				queue = append(queue, g.EntityRef())
+			}
+		}
+	}
+	return ReadGroupsResponse{Groups: out}, nil
+}
+
+//get returns the entity r refers to, or an ErrorCodeNotFound error. It must be called with the lock held.
+func (d *MemoryDirectory) get(r participation.EntityRef) (participation.Entity, error) {
+	if !r.IsComplete() {
+		return nil, aldberr.New(ErrorCodeInvalidRequest, "incomplete entity ref", map[string]interface{}{"entity": r.ToName()})
+	}
+	e, found := d.entities[r.ToName()]
+	if !found {
+		return nil, aldberr.New(ErrorCodeNotFound, "entity not found", map[string]interface{}{"entity": r.ToName()})
+	}
+	return e, nil
+}
+
+//getGroup is get for a group. It must be called with the lock held.
+func (d *MemoryDirectory) getGroup(r participation.EntityRef) (*participation.Group, error) {
+	e, err := d.get(r)
+	if err != nil {
+		return nil, err
+	}
+	g, isGroup := e.(*participation.Group)
+	if !isGroup {
+		return nil, aldberr.New(ErrorCodeInvalidRequest, "entity is not a group", map[string]interface{}{"entity": r.ToName(), "type": kind(e)})
+	}
+	return g, nil
+}
+
+//groupsOf returns the names of the groups r is a direct member of, sorted. It must be called with the lock
+//held.
+func (d *MemoryDirectory) groupsOf(r participation.EntityRef) []string {
+	out := []string{}
+	for group, members := range d.members {
+		for _, m := range members {
+			if m == r {
+				out = append(out, group)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+//isTransitiveMember checks that m is a direct or transitive member of group. It must be called with the lock
+//held.
+func (d *MemoryDirectory) isTransitiveMember(m, group participation.EntityRef) bool {
+	seen := map[participation.EntityRef]bool{group: true}
+	for queue := []participation.EntityRef{group}; len(queue) > 0; queue = queue[1:] {
+		for _, candidate := range d.members[queue[0].ToName()] {
+			if candidate == m {
+				return true
+			}
+			if !seen[candidate] {
+				seen[candidate] = true
+				queue = append(queue, candidate)
+			}
+		}
+	}
+	return false
+}
+
+//checkEmails returns an ErrorCodeEmailInUse error if another entity than the one with the name has an email
+//address of e. It must be called with the lock held.
+func (d *MemoryDirectory) checkEmails(name string, e participation.Entity) error {
+	for _, email := range emailsOf(e) {
+		if other, found := d.emails[strings.ToLower(email)]; found && other != name {
+			return aldberr.New(ErrorCodeEmailInUse, "email address already in use", map[string]interface{}{"email": email, "entity": other})
+		}
+	}
+	return nil
+}
+
+//put must be called with the write lock held.
+func (d *MemoryDirectory) put(name string, e participation.Entity) {
+	d.entities[name] = e
+	for _, email := range emailsOf(e) {
+		d.emails[strings.ToLower(email)] = name
+	}
+}
+
+//remove removes the entity, but not its memberships. It must be called with the write lock held.
+func (d *MemoryDirectory) remove(name string) {
+	for _, email := range emailsOf(d.entities[name]) {
+		delete(d.emails, strings.ToLower(email))
+	}
+	delete(d.entities, name)
+}
+
+//setMembers must be called with the write lock held.
+func (d *MemoryDirectory) setMembers(group string, members []participation.EntityRef) {
+	if len(members) == 0 {
+		delete(d.members, group)
+		return
+	}
+	d.members[group] = members
+}
+
+//snapshot returns the current state, to restore if a change cannot be written (see save). The entities and
+//member lists are replaced rather than changed, so copying the maps suffices. It must be called with the write
+//lock held.
+func (d *MemoryDirectory) snapshot() memoryState {
+	if len(d.path) == 0 {
+		return memoryState{}
+	}
+	out := memoryState{
+		entities: make(map[string]participation.Entity, len(d.entities)),
+		members:  make(map[string][]participation.EntityRef, len(d.members)),
+		emails:   make(map[string]string, len(d.emails)),
+	}
+	for name, e := range d.entities {
+		out.entities[name] = e
+	}
+	for group, members := range d.members {
+		out.members[group] = members
+	}
+	for email, name := range d.emails {
+		out.emails[email] = name
+	}
+	return out
+}
+
+//save writes the directory to its file, if it has one, or else restores the state before the change. It must
+//be called with the write lock held.
+func (d *MemoryDirectory) save(before memoryState) error {
+	if len(d.path) == 0 {
+		return nil
+	}
+	if err := d.writeFile(); err != nil {
+		d.entities, d.members, d.emails = before.entities, before.members, before.emails
+		return aldberr.Wrap(err, ErrorCodeFilesystem, "cannot write directory", map[string]interface{}{"path": d.path})
+	}
+	return nil
+}
+
+//writeFile replaces the file of the directory through a temporary file. It must be called with the lock held.
+func (d *MemoryDirectory) writeFile() error {
+	dj := directoryJSON{Entities: make([]json.RawMessage, 0, len(d.entities)), Members: map[participation.EntityRef][]participation.EntityRef{}}
+	names := make([]string, 0, len(d.entities))
+	for name := range d.entities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		bts, err := participation.MarshalEntity(d.entities[name])
+		if err != nil {
+			return err
+		}
+		dj.Entities = append(dj.Entities, bts)
+	}
+	for group, members := range d.members {
+		dj.Members[d.entities[group].EntityRef()] = members
+	}
+	bts, err := json.MarshalIndent(dj, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, append(bts, '\n'), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+//check returns the name of the entity if it is of a supported type and has a complete EntityRef.
+func check(e participation.Entity) (string, error) {
+	switch e.(type) {
+	case *participation.Person, *participation.Organisation, *participation.Group:
+	default:
+		return "", aldberr.New(ErrorCodeInvalidRequest, "unsupported entity type", map[string]interface{}{"type": kind(e)})
+	}
+	//a nil entity has an empty EntityRef
+	if r := e.EntityRef(); !r.IsComplete() {
+		return "", aldberr.New(ErrorCodeInvalidRequest, "entity has no complete entity ref", map[string]interface{}{"entity": r.ToName()})
+	}
+	return e.EntityRef().ToName(), nil
+}
+
+func kind(e participation.Entity) string {
+	return fmt.Sprintf("%T", e)
+}
+
+func emailsOf(e participation.Entity) []string {
+	if withEmails, ok := e.(interface{ Emails() []string }); ok {
+		return withEmails.Emails()
+	}
+	return nil
+}
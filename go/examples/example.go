@@ -1,6 +1,7 @@
 package examples
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"testing"
@@ -13,6 +14,7 @@ import (
 	"github.com/vital-dhaveloose/aldb/common/datetime"
 	"github.com/vital-dhaveloose/aldb/common/lang"
 	"github.com/vital-dhaveloose/aldb/common/mediatype"
+	"github.com/vital-dhaveloose/aldb/manifest"
 	"github.com/vital-dhaveloose/aldb/ref"
 )
 
@@ -36,8 +38,8 @@ func CreateExampleData() activity.Activity {
 		Ref: participation.EntityRef{Host: "viwi.eu", EntityId: "vital.dhaveloose"},
 	}
 
-	msxProjectRef := ref.ActivityRef{Id: urlMustParse("aldb.clientcorp.eu/activities/project-x")}
-	msxProjectStart, _ := time.Parse(time.RFC3339, "2019-07-25")
+	msxProjectRef := ref.ActivityRef{Id: urlMustParse("https://aldb.clientcorp.eu/activities/project-x")}
+	msxProjectStart, _ := time.Parse("2006-01-02", "2019-07-25")
 	msxProject := activity.Activity{
 		ActivityRef: msxProjectRef,
 		Label:       lang.LocalizableString{lang.LangAny: "Project X"},
@@ -63,8 +65,8 @@ func CreateExampleData() activity.Activity {
 		},
 	}
 
-	rndRef := ref.ActivityRef{Id: urlMustParse("aldb.clientcorp.eu/activities/rnd")}
-	rndStart, _ := time.Parse(time.RFC3339, "2020-07-25")
+	rndRef := ref.ActivityRef{Id: urlMustParse("https://aldb.clientcorp.eu/activities/rnd")}
+	rndStart, _ := time.Parse("2006-01-02", "2020-07-25")
 	rndProject := activity.Activity{
 		ActivityRef: rndRef,
 		Label:       lang.LocalizableString{lang.LangAny: "R&D"},
@@ -93,7 +95,7 @@ func CreateExampleData() activity.Activity {
 		},
 	}
 
-	someDocumentRef := ref.ActivityRef{Id: urlMustParse("aldb.clientcorp.eu/activities/doc-3")}
+	someDocumentRef := ref.ActivityRef{Id: urlMustParse("https://aldb.clientcorp.eu/activities/doc-3")}
 	someDocument := activity.Activity{
 		ActivityRef: someDocumentRef,
 		Label:       lang.LocalizableString{lang.LangAny: "some document"},
@@ -106,7 +108,7 @@ func CreateExampleData() activity.Activity {
 		},
 		AttributeSets: map[string]attributes.AttributeSet{
 			"text-attrs": {
-				Manifest: &attributes.Manifest{ManifestRef: ref.ManifestRef{Id: urlMustParse("aldb.org/attribute-manifests/text")}},
+				Manifest: &attributes.Manifest{ManifestRef: ref.ManifestRef{Id: urlMustParse("https://aldb.org/attribute-manifests/text")}},
 				Attributes: map[string]interface{}{
 					"language": "en-gb",
 				},
@@ -116,11 +118,11 @@ func CreateExampleData() activity.Activity {
 			&rndProject,
 		},
 		Blob: &blob.Blob{
-			Manifest: &blob.BlobManifest{
-				MediaType: mediatype.MediaTypeMustParse("text/plain; charset=UTF-8"),
-				Size:      17,
-			},
-			Bytes: []byte("This is contents!"),
+			Renditions: []blob.Rendition{{
+				Function: blob.RenditionFunctionMain,
+				Manifest: blob.BlobManifest{MediaType: mediatype.MustParse("text/plain; charset=UTF-8")},
+				Content:  blob.Bytes([]byte("This is contents!")),
+			}},
 		},
 	}
 
@@ -128,6 +130,33 @@ func CreateExampleData() activity.Activity {
 	return someDocument
 }
 
+//CreateExampleManifests returns the manifests of the attribute sets in the example data.
+func CreateExampleManifests() []manifest.Manifest {
+	return []manifest.Manifest{
+		{
+			ManifestRef: ref.ManifestRef{Id: urlMustParse("http://projo.com/schemas/project")},
+			Schema: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"totalBudget": {
+						"type": "object",
+						"properties": {
+							"currency": {"type": "string", "pattern": "^[A-Z]{3}$"},
+							"amount": {"type": "number", "minimum": 0}
+						},
+						"required": ["currency", "amount"]
+					},
+					"priorityClass": {"enum": ["low", "normal", "high"]}
+				}
+			}`),
+		},
+		{
+			ManifestRef: ref.ManifestRef{Id: urlMustParse("https://aldb.org/attribute-manifests/text")},
+			Schema:      json.RawMessage(`{"type": "object", "properties": {"language": {"type": "string"}}}`),
+		},
+	}
+}
+
 func urlMustParse(raw string) *url.URL {
 	u, err := url.Parse(raw)
 	if err != nil {
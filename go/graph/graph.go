@@ -0,0 +1,439 @@
+//Package graph maintains the is-part-of relation between activities (Subs and Supers) as a directed acyclic
+//graph, of which the roots are typically lives and organisations.
+package graph
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+const (
+	//ErrorCodeCycle is returned when a change would make an activity (indirectly) part of itself. The details
+	//contain the "path" of activity ids that forms the cycle, starting and ending with the same id.
+	ErrorCodeCycle      = "graph-cycle"
+	ErrorCodeInvalidRef = "graph-invalid-ref"
+	ErrorCodeNotFound   = "graph-not-found"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeCycle, Status: http.StatusConflict, Message: errcode.En("the change would make an activity part of itself")},
+		errcode.Code{Code: ErrorCodeInvalidRef, Status: http.StatusBadRequest, Message: errcode.En("invalid activity reference")},
+		errcode.Code{Code: ErrorCodeNotFound, Status: http.StatusNotFound, Message: errcode.En("activity not found")},
+	)
+}
+
+//Graph is an in-memory directed acyclic graph of activities, in which an edge goes from a Super to a Sub.
+//Every edge is both a Sub link and a Super link, so the Subs and Supers of the activities are always each
+//other's mirror image. Activities are identified by their version-less ActivityRef. A Graph is safe for
+//concurrent use.
+//
+//Nodes are stored in slices and referenced by index, so that graphs with hundreds of thousands of
+//activities remain compact. Apart from Roots, queries only visit the part of the graph they need (e.g. the
+//ancestors of an activity), so their cost doesn't grow with the size of the graph.
+type Graph struct {
+	mu    sync.RWMutex
+	index map[string]int
+	nodes []node
+	//free contains indexes of removed nodes, for reuse.
+	free []int
+}
+
+type node struct {
+	id     string
+	subs   []int
+	supers []int
+}
+
+func New() *Graph {
+	return &Graph{index: map[string]int{}}
+}
+
+//SetLinks replaces all links of the activity r by the given Subs and Supers, adding the activity if it isn't
+//in the graph yet. It returns an ErrorCodeCycle error (and leaves the graph unchanged) if the new links
+//would create a cycle.
+func (g *Graph) SetLinks(r ref.ActivityRef, subs, supers []ref.ActivityRef) error {
+	return g.setLinks(r, subs, supers, true)
+}
+
+//CheckLinks returns the error SetLinks would return, without changing the graph.
+func (g *Graph) CheckLinks(r ref.ActivityRef, subs, supers []ref.ActivityRef) error {
+	return g.setLinks(r, subs, supers, false)
+}
+
+func (g *Graph) setLinks(r ref.ActivityRef, subs, supers []ref.ActivityRef, apply bool) error {
+	id, err := key(r)
+	if err != nil {
+		return err
+	}
+	subIds, err := keys(subs)
+	if err != nil {
+		return err
+	}
+	superIds, err := keys(supers)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	created := []int{}
+	ensure := func(id string) int {
+		if n, found := g.index[id]; found {
+			return n
+		}
+		n := g.add(id)
+		created = append(created, n)
+		return n
+	}
+	n := ensure(id)
+	oldSubs := append([]int{}, g.nodes[n].subs...)
+	oldSupers := append([]int{}, g.nodes[n].supers...)
+	restore := func() {
+		g.unlinkAll(n)
+		for _, s := range oldSubs {
+			g.link(n, s)
+		}
+		for _, s := range oldSupers {
+			g.link(s, n)
+		}
+		for _, c := range created {
+			g.remove(c)
+		}
+	}
+
+	g.unlinkAll(n)
+	for _, subId := range subIds {
+		sub := ensure(subId)
+		if path := g.pathDown(sub, n); path != nil {
+			restore()
+			return cycleError(g.ids(append([]int{n}, path...)))
+		}
+		g.link(n, sub)
+	}
+	for _, superId := range superIds {
+		super := ensure(superId)
+		if path := g.pathDown(n, super); path != nil {
+			restore()
+			return cycleError(g.ids(append([]int{super}, path...)))
+		}
+		g.link(super, n)
+	}
+	if !apply {
+		restore()
+	}
+	return nil
+}
+
+//Remove removes the activity and all of its links from the graph.
+func (g *Graph) Remove(r ref.ActivityRef) {
+	id, err := key(r)
+	if err != nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n, found := g.index[id]
+	if !found {
+		return
+	}
+	g.remove(n)
+}
+
+//Contains returns whether the activity is in the graph, i.e. if it was added or is linked to.
+func (g *Graph) Contains(r ref.ActivityRef) bool {
+	id, err := key(r)
+	if err != nil {
+		return false
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, found := g.index[id]
+	return found
+}
+
+//Len returns the number of activities in the graph.
+func (g *Graph) Len() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.index)
+}
+
+//Subs returns the direct Subs of the activity, sorted by id.
+func (g *Graph) Subs(r ref.ActivityRef) ([]ref.ActivityRef, error) {
+	return g.query(r, func(n int) []int { return g.nodes[n].subs })
+}
+
+//Supers returns the direct Supers of the activity, sorted by id.
+func (g *Graph) Supers(r ref.ActivityRef) ([]ref.ActivityRef, error) {
+	return g.query(r, func(n int) []int { return g.nodes[n].supers })
+}
+
+//Ancestors returns all activities that the activity is (indirectly) part of, sorted by id.
+func (g *Graph) Ancestors(r ref.ActivityRef) ([]ref.ActivityRef, error) {
+	return g.query(r, func(n int) []int { return g.reach(n, upward) })
+}
+
+//Descendants returns all activities that are (indirectly) part of the activity, sorted by id.
+func (g *Graph) Descendants(r ref.ActivityRef) ([]ref.ActivityRef, error) {
+	return g.query(r, func(n int) []int { return g.reach(n, downward) })
+}
+
+//RootsOf returns the ancestors of the activity that have no Supers themselves, or the activity itself if it
+//has no Supers, sorted by id.
+func (g *Graph) RootsOf(r ref.ActivityRef) ([]ref.ActivityRef, error) {
+	return g.query(r, func(n int) []int {
+		out := []int{}
+		for _, a := range append(g.reach(n, upward), n) {
+			if len(g.nodes[a].supers) == 0 {
+				out = append(out, a)
+			}
+		}
+		return out
+	})
+}
+
+//Roots returns all activities without Supers, sorted by id.
+func (g *Graph) Roots() []ref.ActivityRef {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	out := []int{}
+	for _, n := range g.index {
+		if len(g.nodes[n].supers) == 0 {
+			out = append(out, n)
+		}
+	}
+	return g.refs(out)
+}
+
+//LowestCommonAncestors returns the common ancestors of a and b (where an activity counts as its own
+//ancestor) that have no Sub that is a common ancestor too, sorted by id. As the graph is not a tree, there
+//can be several.
+func (g *Graph) LowestCommonAncestors(a, b ref.ActivityRef) ([]ref.ActivityRef, error) {
+	aId, err := key(a)
+	if err != nil {
+		return nil, err
+	}
+	bId, err := key(b)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	aN, found := g.index[aId]
+	if !found {
+		return nil, notFound(aId)
+	}
+	bN, found := g.index[bId]
+	if !found {
+		return nil, notFound(bId)
+	}
+
+	ofA := map[int]bool{aN: true}
+	for _, n := range g.reach(aN, upward) {
+		ofA[n] = true
+	}
+	common := map[int]bool{}
+	if ofA[bN] {
+		common[bN] = true
+	}
+	for _, n := range g.reach(bN, upward) {
+		if ofA[n] {
+			common[n] = true
+		}
+	}
+	out := []int{}
+	for n := range common {
+		lowest := true
+		for _, sub := range g.nodes[n].subs {
+			if common[sub] {
+				lowest = false
+				break
+			}
+		}
+		if lowest {
+			out = append(out, n)
+		}
+	}
+	return g.refs(out), nil
+}
+
+//region internals
+
+type direction bool
+
+const (
+	upward   = direction(true)
+	downward = direction(false)
+)
+
+func (g *Graph) query(r ref.ActivityRef, f func(n int) []int) ([]ref.ActivityRef, error) {
+	id, err := key(r)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	n, found := g.index[id]
+	if !found {
+		return nil, notFound(id)
+	}
+	return g.refs(f(n)), nil
+}
+
+func (g *Graph) add(id string) int {
+	n := len(g.nodes)
+	if len(g.free) > 0 {
+		n = g.free[len(g.free)-1]
+		g.free = g.free[:len(g.free)-1]
+		g.nodes[n] = node{id: id}
+	} else {
+		g.nodes = append(g.nodes, node{id: id})
+	}
+	g.index[id] = n
+	return n
+}
+
+func (g *Graph) remove(n int) {
+	g.unlinkAll(n)
+	delete(g.index, g.nodes[n].id)
+	g.nodes[n] = node{}
+	g.free = append(g.free, n)
+}
+
+func (g *Graph) link(super, sub int) {
+	for _, s := range g.nodes[super].subs {
+		if s == sub {
+			return
+		}
+	}
+	g.nodes[super].subs = append(g.nodes[super].subs, sub)
+	g.nodes[sub].supers = append(g.nodes[sub].supers, super)
+}
+
+func (g *Graph) unlinkAll(n int) {
+	for _, sub := range g.nodes[n].subs {
+		g.nodes[sub].supers = without(g.nodes[sub].supers, n)
+	}
+	for _, super := range g.nodes[n].supers {
+		g.nodes[super].subs = without(g.nodes[super].subs, n)
+	}
+	g.nodes[n].subs = nil
+	g.nodes[n].supers = nil
+}
+
+//reach returns all nodes reachable from n (excluding n) in the given direction.
+func (g *Graph) reach(n int, dir direction) []int {
+	seen := map[int]bool{n: true}
+	out := []int{}
+	queue := []int{n}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		next := g.nodes[cur].subs
+		if dir == upward {
+			next = g.nodes[cur].supers
+		}
+		for _, m := range next {
+			if !seen[m] {
+				seen[m] = true
+				out = append(out, m)
+				queue = append(queue, m)
+			}
+		}
+	}
+	return out
+}
+
+//pathDown returns the path from -> ... -> to following Sub links, or nil if there is none. It searches
+//upwards from to, as activities typically have far fewer ancestors than descendants.
+func (g *Graph) pathDown(from, to int) []int {
+	if from == to {
+		return []int{from}
+	}
+	next := map[int]int{to: -1}
+	queue := []int{to}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, super := range g.nodes[cur].supers {
+			if _, seen := next[super]; seen {
+				continue
+			}
+			next[super] = cur
+			if super == from {
+				path := []int{}
+				for p := from; p != -1; p = next[p] {
+					path = append(path, p)
+				}
+				return path
+			}
+			queue = append(queue, super)
+		}
+	}
+	return nil
+}
+
+func (g *Graph) ids(ns []int) []string {
+	out := make([]string, len(ns))
+	for i, n := range ns {
+		out[i] = g.nodes[n].id
+	}
+	return out
+}
+
+func (g *Graph) refs(ns []int) []ref.ActivityRef {
+	ids := g.ids(ns)
+	sort.Strings(ids)
+	out := make([]ref.ActivityRef, len(ids))
+	for i, id := range ids {
+		u, _ := url.Parse(id)
+		out[i] = ref.ActivityRef{Id: u}
+	}
+	return out
+}
+
+func without(ns []int, n int) []int {
+	for i := range ns {
+		if ns[i] == n {
+			return append(ns[:i:i], ns[i+1:]...)
+		}
+	}
+	return ns
+}
+
+func key(r ref.ActivityRef) (string, error) {
+	if r.Id == nil || len(r.Id.String()) == 0 {
+		return "", aldberr.New(ErrorCodeInvalidRef, "activity ref has no id", nil)
+	}
+	return r.Id.String(), nil
+}
+
+func keys(rs []ref.ActivityRef) ([]string, error) {
+	out := make([]string, len(rs))
+	for i := range rs {
+		id, err := key(rs[i])
+		if err != nil {
+			return nil, err
+		}
+		out[i] = id
+	}
+	return out, nil
+}
+
+func cycleError(path []string) error {
+	return aldberr.New(ErrorCodeCycle, "links would create a cycle: "+strings.Join(path, " -> "), map[string]interface{}{"path": path})
+}
+
+func notFound(id string) error {
+	return aldberr.New(ErrorCodeNotFound, "activity not found in graph", map[string]interface{}{"id": id})
+}
+
+//endregion
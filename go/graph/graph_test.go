@@ -0,0 +1,197 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+	"github.com/vital-dhaveloose/aldb/store/storetest"
+)
+
+func TestStoreConformance(t *testing.T) {
+	storetest.RunConformanceTests(t, func(t *testing.T) store.Store {
+		s, err := NewStore(context.Background(), store.NewMemoryStore())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestSetLinksRejectsCycles(t *testing.T) {
+	g := New()
+	mustSetLinks(t, g, "life", nil, nil)
+	mustSetLinks(t, g, "work", nil, []string{"life"})
+	mustSetLinks(t, g, "project", nil, []string{"work"})
+
+	err := g.SetLinks(r("life"), refs("work"), refs("project"))
+	ce := aldberr.CanvigaError{}
+	if !errors.As(err, &ce) || ce.Code() != ErrorCodeCycle {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+	expectedPath := []string{id("project"), id("life"), id("work"), id("project")}
+	if !reflect.DeepEqual(ce.Details()["path"], expectedPath) {
+		t.Errorf("expected path %v, got %v", expectedPath, ce.Details()["path"])
+	}
+	assertRefs(t, must(g.Supers(r("life"))), nil)
+	assertRefs(t, must(g.Subs(r("life"))), []string{"work"})
+
+	if err := g.SetLinks(r("work"), refs("work"), nil); err == nil {
+		t.Errorf("expected an activity that is its own sub to be rejected")
+	}
+	if err := g.CheckLinks(r("new"), refs("life"), refs("project")); err == nil {
+		t.Errorf("expected cycle through a new activity to be rejected")
+	}
+	if g.Contains(r("new")) {
+		t.Errorf("expected CheckLinks to leave the graph unchanged")
+	}
+}
+
+func TestLinksAreMirrored(t *testing.T) {
+	g := New()
+	mustSetLinks(t, g, "project", []string{"budget", "planning"}, nil)
+	assertRefs(t, must(g.Supers(r("budget"))), []string{"project"})
+
+	mustSetLinks(t, g, "planning", nil, []string{"work"})
+	assertRefs(t, must(g.Subs(r("project"))), []string{"budget"})
+	assertRefs(t, must(g.Subs(r("work"))), []string{"planning"})
+}
+
+func TestQueries(t *testing.T) {
+	//      life    corp
+	//       |     /    \
+	//      work--+     park
+	//     /    \      /
+	//  hobby   project
+	g := New()
+	mustSetLinks(t, g, "work", []string{"hobby", "project"}, []string{"life", "corp"})
+	mustSetLinks(t, g, "park", []string{"project"}, []string{"corp"})
+
+	assertRefs(t, must(g.Ancestors(r("project"))), []string{"corp", "life", "park", "work"})
+	assertRefs(t, must(g.Descendants(r("corp"))), []string{"hobby", "park", "project", "work"})
+	assertRefs(t, g.Roots(), []string{"corp", "life"})
+	assertRefs(t, must(g.RootsOf(r("hobby"))), []string{"corp", "life"})
+	assertRefs(t, must(g.RootsOf(r("life"))), []string{"life"})
+	assertRefs(t, must(g.LowestCommonAncestors(r("hobby"), r("project"))), []string{"work"})
+	assertRefs(t, must(g.LowestCommonAncestors(r("project"), r("park"))), []string{"park"})
+	assertRefs(t, must(g.LowestCommonAncestors(r("hobby"), r("park"))), []string{"corp"})
+	assertRefs(t, must(g.LowestCommonAncestors(r("life"), r("park"))), nil)
+
+	g.Remove(r("work"))
+	assertRefs(t, must(g.Ancestors(r("project"))), []string{"corp", "park"})
+}
+
+func TestStoreMirrorsLinks(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewStore(ctx, store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := storetest.NewActivity("parent", "", "parent")
+	child := storetest.NewActivity("child", "", "child")
+	for _, a := range []activity.Activity{child, parent} {
+		if _, err := s.Create(ctx, store.CreateRequest{ToCreate: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parent.Version = ""
+	parent.Subs = []*activity.Activity{&child}
+	if _, err := s.Create(ctx, store.CreateRequest{ToCreate: parent}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := s.Read(ctx, store.ReadRequest{Ref: storetest.Ref("child", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Activity.Supers) != 1 || resp.Activity.Supers[0].Id.String() != parent.Id.String() {
+		t.Errorf("expected the parent as mirrored Super, got %v", resp.Activity.Supers)
+	}
+
+	child.Version = ""
+	child.Subs = []*activity.Activity{&parent}
+	_, err = s.Create(ctx, store.CreateRequest{ToCreate: child})
+	storetest.AssertErrorCode(t, err, ErrorCodeCycle)
+}
+
+func BenchmarkLargeGraph(b *testing.B) {
+	//a tree of 300k activities with a fan-out of 10, plus some cross links
+	const n = 300000
+	g := New()
+	for i := 1; i < n; i++ {
+		supers := refs(fmt.Sprint((i - 1) / 10))
+		if i%1000 == 0 {
+			supers = append(supers, r(fmt.Sprint(i/2-1)))
+		}
+		if err := g.SetLinks(r(fmt.Sprint(i)), nil, supers); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		leafN := n - 1 - i%1000
+		leaf := r(fmt.Sprint(leafN))
+		if _, err := g.Ancestors(leaf); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := g.LowestCommonAncestors(leaf, r(fmt.Sprint(n/2+i%1000))); err != nil {
+			b.Fatal(err)
+		}
+		if err := g.CheckLinks(leaf, refs("0"), refs(fmt.Sprint((leafN-1)/10))); err == nil {
+			b.Fatal("expected a cycle")
+		}
+	}
+}
+
+func id(name string) string {
+	return "https://aldb.test/activities/" + name
+}
+
+func r(name string) ref.ActivityRef {
+	u, _ := url.Parse(id(name))
+	return ref.ActivityRef{Id: u}
+}
+
+func refs(names ...string) []ref.ActivityRef {
+	out := make([]ref.ActivityRef, len(names))
+	for i, name := range names {
+		out[i] = r(name)
+	}
+	return out
+}
+
+func mustSetLinks(t *testing.T, g *Graph, name string, subs, supers []string) {
+	t.Helper()
+	if err := g.SetLinks(r(name), refs(subs...), refs(supers...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func must(rs []ref.ActivityRef, err error) []ref.ActivityRef {
+	if err != nil {
+		panic(err)
+	}
+	return rs
+}
+
+func assertRefs(t *testing.T, actual []ref.ActivityRef, expectedNames []string) {
+	t.Helper()
+	actualIds := []string{}
+	for _, a := range actual {
+		actualIds = append(actualIds, a.Id.String())
+	}
+	expectedIds := []string{}
+	for _, name := range expectedNames {
+		expectedIds = append(expectedIds, id(name))
+	}
+	if !reflect.DeepEqual(actualIds, expectedIds) {
+		t.Errorf("expected %v, got %v", expectedIds, actualIds)
+	}
+}
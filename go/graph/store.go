@@ -0,0 +1,208 @@
+package graph
+
+import (
+	"context"
+	"sync"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+//Store is a store.Store that keeps a Graph of the latest versions of the activities in another Store. It
+//rejects versions whose links would create a cycle, and it makes Subs and Supers each other's mirror image:
+//when a version of A lists B as Sub, the latest version of B is read with A as Super, even if B's own latest
+//version doesn't list A. The links of an activity are replaced by the ones of every new version of it, so
+//the latest write wins. Older versions are read with the links as they were written.
+type Store struct {
+	inner store.Store
+	graph *Graph
+	//writeMu serializes writes, so that the links checked before a write are still valid after it.
+	writeMu sync.Mutex
+}
+
+//NewStore wraps inner, building the Graph from the latest versions of the activities in it.
+func NewStore(ctx context.Context, inner store.Store) (*Store, error) {
+	resp, err := inner.List(ctx, store.ListRequest{})
+	if err != nil {
+		return nil, err
+	}
+	g := New()
+	//first add all nodes with their own links, then re-apply the Super links that were overwritten by Sub
+	//links of activities added later, so that the result doesn't depend on the order of the activities
+	for _, a := range resp.Activities {
+		if err := g.SetLinks(a.ActivityRef, linkRefs(a.Subs), linkRefs(a.Supers)); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range resp.Activities {
+		subs, _ := g.Subs(a.ActivityRef)
+		supers, _ := g.Supers(a.ActivityRef)
+		if err := g.SetLinks(a.ActivityRef, union(subs, linkRefs(a.Subs)), union(supers, linkRefs(a.Supers))); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{inner: inner, graph: g}, nil
+}
+
+//Graph returns the graph of the latest versions, for queries.
+func (s *Store) Graph() *Graph {
+	return s.graph
+}
+
+//Create checks the links of the new version against the Graph before creating it. Omitted (nil) Subs and
+//Supers are taken from the Graph rather than carried over from the previous version, so that they include
+//the mirrored links.
+func (s *Store) Create(ctx context.Context, req store.CreateRequest) (store.CreateResponse, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	toCreate := req.ToCreate
+	if toCreate.Id != nil && s.graph.Contains(toCreate.ActivityRef) {
+		if toCreate.Subs == nil {
+			subs, _ := s.graph.Subs(toCreate.ActivityRef)
+			toCreate.Subs = stubs(subs)
+		}
+		if toCreate.Supers == nil {
+			supers, _ := s.graph.Supers(toCreate.ActivityRef)
+			toCreate.Supers = stubs(supers)
+		}
+	}
+	if toCreate.Id != nil {
+		if err := s.graph.CheckLinks(toCreate.ActivityRef, linkRefs(toCreate.Subs), linkRefs(toCreate.Supers)); err != nil {
+			return store.CreateResponse{}, err
+		}
+	}
+	resp, err := s.inner.Create(ctx, store.CreateRequest{ToCreate: toCreate})
+	if err != nil {
+		return store.CreateResponse{}, err
+	}
+	if err := s.graph.SetLinks(resp.Created.ActivityRef, linkRefs(resp.Created.Subs), linkRefs(resp.Created.Supers)); err != nil {
+		return store.CreateResponse{}, err
+	}
+	return resp, nil
+}
+
+func (s *Store) Read(ctx context.Context, req store.ReadRequest) (store.ReadResponse, error) {
+	resp, err := s.inner.Read(ctx, req)
+	if err != nil {
+		return store.ReadResponse{}, err
+	}
+	if len(req.Ref.Version) == 0 {
+		resp.Activity = s.mirror(resp.Activity)
+	}
+	return resp, nil
+}
+
+func (s *Store) List(ctx context.Context, req store.ListRequest) (store.ListResponse, error) {
+	resp, err := s.inner.List(ctx, req)
+	if err != nil {
+		return store.ListResponse{}, err
+	}
+	for i := range resp.Activities {
+		resp.Activities[i] = s.mirror(resp.Activities[i])
+	}
+	return resp, nil
+}
+
+//Delete removes the activity from the Graph when all of its versions are deleted. When only a version is
+//deleted, the links of the remaining latest version are applied.
+func (s *Store) Delete(ctx context.Context, req store.DeleteRequest) (store.DeleteResponse, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	resp, err := s.inner.Delete(ctx, req)
+	if err != nil {
+		return store.DeleteResponse{}, err
+	}
+	latest, err := s.inner.Read(ctx, store.ReadRequest{Ref: ref.ActivityRef{Id: req.Ref.Id}})
+	if err != nil {
+		s.graph.Remove(req.Ref)
+		return resp, nil
+	}
+	if len(req.Ref.Version) > 0 {
+		if err := s.graph.SetLinks(latest.Activity.ActivityRef, linkRefs(latest.Activity.Subs), linkRefs(latest.Activity.Supers)); err != nil {
+			return store.DeleteResponse{}, err
+		}
+	}
+	return resp, nil
+}
+
+func (s *Store) ReadSubs(ctx context.Context, req store.ReadLinksRequest) (store.ReadLinksResponse, error) {
+	if len(req.Ref.Version) > 0 {
+		return s.inner.ReadSubs(ctx, req)
+	}
+	return s.readLinks(ctx, req.Ref, s.graph.Subs)
+}
+
+func (s *Store) ReadSupers(ctx context.Context, req store.ReadLinksRequest) (store.ReadLinksResponse, error) {
+	if len(req.Ref.Version) > 0 {
+		return s.inner.ReadSupers(ctx, req)
+	}
+	return s.readLinks(ctx, req.Ref, s.graph.Supers)
+}
+
+func (s *Store) History(ctx context.Context, req store.HistoryRequest) (store.HistoryResponse, error) {
+	return s.inner.History(ctx, req)
+}
+
+func (s *Store) readLinks(ctx context.Context, r ref.ActivityRef, getLinks func(r ref.ActivityRef) ([]ref.ActivityRef, error)) (store.ReadLinksResponse, error) {
+	if _, err := s.inner.Read(ctx, store.ReadRequest{Ref: r}); err != nil {
+		return store.ReadLinksResponse{}, err
+	}
+	links, err := getLinks(r)
+	if err != nil {
+		//the activity has no links
+		return store.ReadLinksResponse{Activities: []activity.Activity{}}, nil
+	}
+	out := make([]activity.Activity, 0, len(links))
+	for _, link := range links {
+		resp, err := s.Read(ctx, store.ReadRequest{Ref: link})
+		if err != nil {
+			return store.ReadLinksResponse{}, err
+		}
+		out = append(out, resp.Activity)
+	}
+	return store.ReadLinksResponse{Activities: out}, nil
+}
+
+//mirror replaces the Subs and Supers of a latest version by the ones in the Graph.
+func (s *Store) mirror(a activity.Activity) activity.Activity {
+	if subs, err := s.graph.Subs(a.ActivityRef); err == nil {
+		a.Subs = stubs(subs)
+	}
+	if supers, err := s.graph.Supers(a.ActivityRef); err == nil {
+		a.Supers = stubs(supers)
+	}
+	return a
+}
+
+func linkRefs(as []*activity.Activity) []ref.ActivityRef {
+	stubs := store.LinkStubs(as)
+	out := make([]ref.ActivityRef, len(stubs))
+	for i := range stubs {
+		out[i] = stubs[i].ActivityRef
+	}
+	return out
+}
+
+func stubs(rs []ref.ActivityRef) []*activity.Activity {
+	if len(rs) == 0 {
+		return nil
+	}
+	out := make([]*activity.Activity, len(rs))
+	for i := range rs {
+		out[i] = &activity.Activity{ActivityRef: ref.ActivityRef{Id: rs[i].Id}}
+	}
+	return out
+}
+
+func union(a, b []ref.ActivityRef) []ref.ActivityRef {
+	seen := map[string]bool{}
+	out := []ref.ActivityRef{}
+	for _, r := range append(append([]ref.ActivityRef{}, a...), b...) {
+		if id := r.Id.String(); !seen[id] {
+			seen[id] = true
+			out = append(out, r)
+		}
+	}
+	return out
+}
@@ -0,0 +1,226 @@
+package ical
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/common/contentline"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+	"github.com/vital-dhaveloose/aldb/common/mediatype"
+)
+
+//MaxDescriptionSize is the number of bytes of the blob of an activity that Export writes as the description of
+//its event at most.
+const MaxDescriptionSize = 64 << 10
+
+//ProdId is the PRODID of exported calendars.
+const ProdId = "-//vital-dhaveloose//aldb//EN"
+
+type ExportOptions struct {
+	//Languages are the preferred languages of the texts, e.g. from the Accept-Language header of a request.
+	Languages lang.Preferences
+	//Now is the DTSTAMP of the events, the current time if zero.
+	Now time.Time
+}
+
+//Export writes a calendar named after the label of root with an event for root and for each of activities
+//that has a Period with a start and an end, in that order. Activities without are left out. Times are written
+//in UTC, or in the TimeZone of their period, which is then described by a VTIMEZONE. An event is a whole-day
+//event if it starts and ends at midnight. The description of an event is the main rendition of its blob if
+//that is text (see MaxDescriptionSize), and its participants are the persons that have an email address.
+func Export(w io.Writer, root activity.Activity, activities []activity.Activity, opts ExportOptions) error {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	cal := &contentline.Component{Name: "VCALENDAR"}
+	cal.Add("VERSION", "2.0")
+	cal.Add("PRODID", ProdId)
+	cal.Add("CALSCALE", "GREGORIAN")
+	cal.Add("METHOD", "PUBLISH")
+	if name, l, found := localize(root.Label, opts.Languages); found {
+		cal.AddText("X-WR-CALNAME", name).Params = langParam(l)
+	}
+
+	//zones contains the earliest start of the events in every time zone by name, of which the rules are
+	//described
+	zones := map[string]time.Time{}
+	locs := map[string]*time.Location{}
+	events := []*contentline.Component{}
+	for _, a := range append([]activity.Activity{root}, activities...) {
+		if !a.Period.HasStart() || !a.Period.HasEnd() {
+			continue
+		}
+		ev, loc, err := exportEvent(a, opts)
+		if err != nil {
+			return err
+		}
+		if first, found := zones[loc.String()]; len(zoneName(loc)) > 0 && (!found || a.Period.Start.Before(first)) {
+			zones[loc.String()], locs[loc.String()] = a.Period.Start, loc
+		}
+		events = append(events, ev)
+	}
+	names := make([]string, 0, len(zones))
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cal.Components = append(cal.Components, vtimezone(locs[name], zones[name]))
+	}
+	cal.Components = append(cal.Components, events...)
+	return contentline.Encode(w, cal)
+}
+
+//exportEvent returns the VEVENT of an activity and the location in which its times are written.
+func exportEvent(a activity.Activity, opts ExportOptions) (*contentline.Component, *time.Location, error) {
+	loc := time.UTC
+	if len(a.Period.TimeZone) > 0 {
+		l, err := a.Period.Location()
+		if err != nil {
+			return nil, nil, err
+		}
+		loc = l
+	}
+	start, end := a.Period.Start.In(loc), a.Period.End.In(loc)
+	allDay := isMidnight(start) && isMidnight(end) && end.After(start)
+
+	ev := &contentline.Component{Name: "VEVENT"}
+	ev.AddText("UID", UID(a.ActivityRef))
+	ev.Add("DTSTAMP", opts.Now.UTC().Format(layoutUTC))
+	ev.Add("DTSTART", formatTime(start, allDay)).Params = timeParams(loc, allDay)
+	if !end.Equal(start) {
+		ev.Add("DTEND", formatTime(end, allDay)).Params = timeParams(loc, allDay)
+	}
+	if rec := a.Period.Recurrence; rec != nil {
+		ev.Add("RRULE", rec.Rule.String())
+		if len(rec.ExDates) > 0 {
+			exdates := make([]string, len(rec.ExDates))
+			for i, ex := range rec.ExDates {
+				exdates[i] = formatTime(ex.In(loc), allDay)
+			}
+			ev.Add("EXDATE", strings.Join(exdates, ",")).Params = timeParams(loc, allDay)
+		}
+	}
+	if summary, l, found := localize(a.Label, opts.Languages); found {
+		ev.AddText("SUMMARY", summary).Params = langParam(l)
+	}
+	description, err := readDescription(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(description) > 0 {
+		ev.AddText("DESCRIPTION", description)
+	}
+	exportParticipants(ev, a.Participations, preferredLang(opts.Languages))
+	return ev, loc, nil
+}
+
+//formatTime formats t as a DATE or a DATE-TIME, which is in UTC if t is.
+func formatTime(t time.Time, isDate bool) string {
+	switch {
+	case isDate:
+		return t.Format(layoutDate)
+	case t.Location() == time.UTC:
+		return t.Format(layoutUTC)
+	}
+	return t.Format(layoutDateTime)
+}
+
+func timeParams(loc *time.Location, isDate bool) map[string][]string {
+	switch {
+	case isDate:
+		return map[string][]string{"VALUE": {"DATE"}}
+	case len(zoneName(loc)) > 0:
+		return map[string][]string{"TZID": {loc.String()}}
+	}
+	return nil
+}
+
+func isMidnight(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+}
+
+//localize returns the text of l in the preferred language, or else in any language.
+func localize(l lang.Localizable, prefs lang.Preferences) (string, lang.Lang, bool) {
+	if l == nil {
+		return "", "", false
+	}
+	s, language, err := lang.Negotiate(l, prefs, nil)
+	if err != nil || len(s) == 0 {
+		s, language, err = lang.Negotiate(l, nil, nil)
+	}
+	return s, language, err == nil && len(s) > 0
+}
+
+func langParam(l lang.Lang) map[string][]string {
+	if len(l) == 0 || l == lang.LangAny {
+		return nil
+	}
+	return map[string][]string{"LANGUAGE": {string(l)}}
+}
+
+//preferredLang returns the most preferred acceptable language, or LangAny.
+func preferredLang(prefs lang.Preferences) lang.Lang {
+	for _, pref := range prefs {
+		if pref.Quality > 0 {
+			return pref.Lang
+		}
+	}
+	return lang.LangAny
+}
+
+//readDescription returns the main rendition of the blob of a if it is text, cut at MaxDescriptionSize.
+func readDescription(a activity.Activity) (string, error) {
+	main, found := a.Blob.Main()
+	if !found || main.Content == nil || !main.Manifest.MediaType.IsA(mediatype.TextPlain) {
+		return "", nil
+	}
+	rc, err := main.Content.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	bts, err := io.ReadAll(io.LimitReader(rc, MaxDescriptionSize))
+	if err != nil {
+		return "", err
+	}
+	return strings.ToValidUTF8(string(bts), ""), nil
+}
+
+//exportParticipants adds the first participant with RoleOrganiser as ORGANIZER and the other ones as
+//ATTENDEEs. Participants are left out if they don't have an email address.
+func exportParticipants(ev *contentline.Component, ps []participation.Participation, l lang.Lang) {
+	hasOrganiser := false
+	for _, p := range ps {
+		person, isPerson := p.Entity.(*participation.Person)
+		if !isPerson || person == nil || len(person.Email) == 0 {
+			continue
+		}
+		roleId := ""
+		if p.Role != nil {
+			roleId = p.Role.ParticipationRoleId
+		}
+		name := "ATTENDEE"
+		if roleId == RoleOrganiser.ParticipationRoleId && !hasOrganiser {
+			name, hasOrganiser = "ORGANIZER", true
+		}
+		prop := ev.Add(name, "mailto:"+person.Email)
+		if cn := person.DisplayName(l); cn != person.Email {
+			prop.SetParam("CN", cn)
+		}
+		if name == "ORGANIZER" {
+			continue
+		}
+		role := "REQ-PARTICIPANT"
+		for value, r := range attendeeRoles {
+			if r.ParticipationRoleId == roleId {
+				role = value
+			}
+		}
+		prop.SetParam("ROLE", role)
+	}
+}
@@ -0,0 +1,88 @@
+//Package ical converts between activities and iCalendar (RFC 5545), so that calendars can be imported as
+//activities and activities can be subscribed to from calendar applications.
+//
+//An event (VEVENT) is an activity of which the Period is the time of the event, including its recurrence
+//(RRULE and EXDATE), the Label is its SUMMARY and the Blob is its DESCRIPTION as plain text. Its ORGANIZER and
+//ATTENDEEs are Participations of persons with the roles below. The UID of an event is the id of its
+//activity (see UID), so that an exported event is the same event in every feed and every version of the
+//activity, and importing it again updates the same activity.
+package ical
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/common/mediatype"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+const (
+	ErrorCodeInvalidCalendar = "ical-invalid-calendar"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidCalendar, Status: http.StatusBadRequest, Message: errcode.En("invalid iCalendar data")},
+	)
+}
+
+//MediaType is the media type of iCalendar data.
+var MediaType = mediatype.MustParse("text/calendar; charset=utf-8")
+
+//The roles of the participations in events. An attendee without ROLE parameter is a RoleAttendee, and
+//participations with other roles are exported as attendees.
+var (
+	RoleOrganiser      = participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: "http://uius.org/apps/calendar/roles/organiser"}}
+	RoleChair          = participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: "http://uius.org/apps/calendar/roles/chair"}}
+	RoleAttendee       = participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: "http://uius.org/apps/calendar/roles/attendee"}}
+	RoleOptional       = participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: "http://uius.org/apps/calendar/roles/optional-attendee"}}
+	RoleNonParticipant = participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: "http://uius.org/apps/calendar/roles/non-participant"}}
+)
+
+//attendeeRoles maps the values of the ROLE parameter of an ATTENDEE to roles.
+var attendeeRoles = map[string]participation.ParticipationRole{
+	"CHAIR":           RoleChair,
+	"REQ-PARTICIPANT": RoleAttendee,
+	"OPT-PARTICIPANT": RoleOptional,
+	"NON-PARTICIPANT": RoleNonParticipant,
+}
+
+//UID returns the UID of the event of an activity, which is the id of the activity without its version.
+func UID(r ref.ActivityRef) string {
+	if r.Id == nil {
+		return ""
+	}
+	return r.Id.String()
+}
+
+//activityId returns the id of the activity for an event: the UID itself if it is the id of an activity (see
+//UID), otherwise the UID in the "events" of base. An occurrence of a recurring event that is changed (which has
+//a RECURRENCE-ID) is a separate activity, of which the id ends in the recurrence id.
+func activityId(base *url.URL, uid, recurrenceId string) (*url.URL, error) {
+	errDet := map[string]interface{}{"uid": uid}
+	if u, err := url.Parse(uid); err == nil && u.IsAbs() && len(u.Host) > 0 {
+		if len(recurrenceId) == 0 {
+			return u, nil
+		}
+		base, uid = u, ""
+	}
+	if base == nil {
+		return nil, aldberr.New(ErrorCodeInvalidCalendar, "cannot derive an activity id for the event: no base id", errDet)
+	}
+	raw := strings.TrimSuffix(base.String(), "/")
+	if len(uid) > 0 {
+		raw += "/events/" + url.PathEscape(uid)
+	}
+	if len(recurrenceId) > 0 {
+		raw += "/occurrences/" + url.PathEscape(recurrenceId)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalidCalendar, "cannot derive an activity id for the event", errDet)
+	}
+	return u, nil
+}
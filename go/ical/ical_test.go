@@ -0,0 +1,236 @@
+package ical
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+var calendar = strings.Join([]string{
+	"BEGIN:VCALENDAR",
+	"VERSION:2.0",
+	"PRODID:-//Example//Calendar//EN",
+	"BEGIN:VTIMEZONE",
+	"TZID:/example.org/Europe/Brussels",
+	"END:VTIMEZONE",
+	"BEGIN:VEVENT",
+	"UID:standup@example.org",
+	"DTSTART;TZID=/example.org/Europe/Brussels:20190304T093000",
+	"DTEND;TZID=/example.org/Europe/Brussels:20190304T094500",
+	"RRULE:FREQ=WEEKLY;BYDAY=MO,WE",
+	"EXDATE;TZID=/example.org/Europe/Brussels:20190306T093000,20190311T093000",
+	"SUMMARY;LANGUAGE=en-GB:Stand-up",
+	"DESCRIPTION:What did you do?\\nWhat will you do?",
+	"ORGANIZER;CN=Jane Doe:mailto:jane@doe.eu",
+	"ATTENDEE;ROLE=CHAIR;CN=John Doe:mailto:john@doe.eu",
+	"ATTENDEE;ROLE=OPT-PARTICIPANT:mailto:max@doe.eu",
+	"ATTENDEE;CUTYPE=ROOM;CN=Room 1:mailto:room1@doe.eu",
+	"END:VEVENT",
+	"BEGIN:VEVENT",
+	"UID:standup@example.org",
+	"RECURRENCE-ID;TZID=/example.org/Europe/Brussels:20190313T093000",
+	"DTSTART;TZID=/example.org/Europe/Brussels:20190313T110000",
+	"DURATION:PT15M",
+	"SUMMARY:Stand-up (late)",
+	"END:VEVENT",
+	"BEGIN:VEVENT",
+	"UID:https://aldb.test/activities/holiday",
+	"DTSTART;VALUE=DATE:20190415",
+	"SUMMARY:Holiday",
+	"END:VEVENT",
+	"BEGIN:VTODO",
+	"UID:todo@example.org",
+	"END:VTODO",
+	"END:VCALENDAR",
+	"",
+}, "\r\n")
+
+func TestImport(t *testing.T) {
+	brussels, err := time.LoadLocation("Europe/Brussels")
+	if err != nil {
+		t.Skip(err)
+	}
+	team := ref.ActivityRef{Id: mustParseURL("https://aldb.test/activities/team")}
+	as, err := Import(strings.NewReader(calendar), ImportOptions{Super: team, Location: brussels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(as) != 3 {
+		t.Fatalf("expected 3 activities, got %d", len(as))
+	}
+
+	standup := as[0]
+	if id := standup.Id.String(); id != "https://aldb.test/activities/team/events/standup@example.org" {
+		t.Errorf("unexpected id %s", id)
+	}
+	if len(standup.Supers) != 1 || standup.Supers[0].Id.String() != team.Id.String() {
+		t.Errorf("unexpected supers %v", standup.Supers)
+	}
+	if l, ok := standup.Label.(lang.LocalizableString); !ok || l["en-GB"] != "Stand-up" {
+		t.Errorf("unexpected label %v", standup.Label)
+	}
+	p := standup.Period
+	if p.TimeZone != "Europe/Brussels" || !p.Start.Equal(time.Date(2019, 3, 4, 8, 30, 0, 0, time.UTC)) || p.End.Sub(p.Start) != 15*time.Minute {
+		t.Errorf("unexpected period %s", p)
+	}
+	if p.Recurrence == nil || len(p.Recurrence.ExDates) != 3 {
+		t.Fatalf("expected 2 excluded dates and a changed occurrence, got %+v", p.Recurrence)
+	}
+	//the clocks go forward on 2019-03-31, the stand-up stays at 9:30
+	if !p.Contains(time.Date(2019, 4, 1, 7, 35, 0, 0, time.UTC)) || p.Contains(time.Date(2019, 3, 6, 8, 35, 0, 0, time.UTC)) || p.Contains(time.Date(2019, 3, 13, 8, 35, 0, 0, time.UTC)) {
+		t.Errorf("unexpected occurrences of %s", p)
+	}
+	if bts, err := blob.ReadAll(mustMain(t, standup.Blob).Content); err != nil || string(bts) != "What did you do?\nWhat will you do?" {
+		t.Errorf("unexpected description %q (%v)", bts, err)
+	}
+	roles := []string{RoleOrganiser.ParticipationRoleId, RoleChair.ParticipationRoleId, RoleOptional.ParticipationRoleId}
+	if len(standup.Participations) != len(roles) {
+		t.Fatalf("expected %d participations, got %v", len(roles), standup.Participations)
+	}
+	for i, pp := range standup.Participations {
+		if pp.Role.ParticipationRoleId != roles[i] || pp.ParticipationId != string(rune('1'+i)) {
+			t.Errorf("unexpected participation %d: %+v", i, pp)
+		}
+	}
+	if name := standup.Participations[0].Entity.DisplayName(lang.LangEn); name != "Jane Doe" {
+		t.Errorf("unexpected organiser %q", name)
+	}
+	if person := standup.Participations[2].Entity.(*participation.Person); person.Email != "max@doe.eu" {
+		t.Errorf("unexpected attendee %+v", person)
+	}
+
+	late := as[1]
+	if id := late.Id.String(); id != "https://aldb.test/activities/team/events/standup@example.org/occurrences/20190313T083000Z" {
+		t.Errorf("unexpected id %s", id)
+	}
+	if late.Period.Recurrence != nil || !late.Period.Start.Equal(time.Date(2019, 3, 13, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected period %s", late.Period)
+	}
+
+	holiday := as[2]
+	if id := holiday.Id.String(); id != "https://aldb.test/activities/holiday" {
+		t.Errorf("unexpected id %s", id)
+	}
+	if holiday.Period.String() != "2019-04-15T00:00:00+02:00/2019-04-16T00:00:00+02:00" {
+		t.Errorf("unexpected period %s", holiday.Period)
+	}
+
+	for _, in := range []string{
+		"BEGIN:VCARD\r\nEND:VCARD\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20190415T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nDTSTART;TZID=Mars/Olympus:20190415T100000\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nDTSTART:20190415T100000Z\r\nDTEND:20190415T090000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if _, err := Import(strings.NewReader(in), ImportOptions{Super: team}); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Brussels"); err != nil {
+		t.Skip(err)
+	}
+	team := ref.ActivityRef{Id: mustParseURL("https://aldb.test/activities/team")}
+	as, err := Import(strings.NewReader(calendar), ImportOptions{Super: team})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := as[0]
+	root.Label = lang.LocalizableString{"en-GB": "Stand-up", "nl-BE": "Dagelijkse vergadering"}
+	buf := &bytes.Buffer{}
+	now := time.Date(2019, 7, 25, 10, 0, 0, 0, time.UTC)
+	if err := Export(buf, root, as[1:], ExportOptions{Languages: lang.Prefer("nl"), Now: now}); err != nil {
+		t.Fatal(err)
+	}
+	feed := buf.String()
+	for _, line := range []string{
+		"X-WR-CALNAME;LANGUAGE=nl-BE:Dagelijkse vergadering",
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/Brussels\r\nBEGIN:DAYLIGHT\r\nDTSTART:20180325T020000\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\nTZNAME:CEST\r\nRRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU\r\nEND:DAYLIGHT",
+		"UID:https://aldb.test/activities/team/events/standup@example.org",
+		"DTSTAMP:20190725T100000Z",
+		"DTSTART;TZID=Europe/Brussels:20190304T093000",
+		"EXDATE;TZID=Europe/Brussels:20190306T093000,20190311T093000,20190313T093000",
+		"ORGANIZER;CN=Jane Doe:mailto:jane@doe.eu",
+		"ATTENDEE;CN=John Doe;ROLE=CHAIR:mailto:john@doe.eu",
+		"ATTENDEE;ROLE=OPT-PARTICIPANT:mailto:max@doe.eu",
+		"DTSTART;VALUE=DATE:20190415\r\nDTEND;VALUE=DATE:20190416",
+	} {
+		if !strings.Contains(feed, line+"\r\n") {
+			t.Errorf("expected %q in feed:\n%s", line, feed)
+		}
+	}
+
+	//importing an exported calendar updates the same activities
+	again, err := Import(strings.NewReader(feed), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != len(as) {
+		t.Fatalf("expected %d activities, got %d", len(as), len(again))
+	}
+	for i, a := range again {
+		if a.Id.String() != as[i].Id.String() || a.Period.String() != as[i].Period.String() || a.Period.TimeZone != as[i].Period.TimeZone {
+			t.Errorf("unexpected round trip of %s: %s (%s)", as[i].Id, a.Period, a.Period.TimeZone)
+		}
+	}
+}
+
+func TestVTimezone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	tz := vtimezone(newYork, time.Date(2019, 7, 25, 0, 0, 0, 0, time.UTC))
+	if len(tz.Components) != 2 {
+		t.Fatalf("expected 2 observances, got %d", len(tz.Components))
+	}
+	for i, expected := range []string{"FREQ=YEARLY;BYMONTH=3;BYDAY=2SU", "FREQ=YEARLY;BYMONTH=11;BYDAY=1SU"} {
+		if p, _ := tz.Components[i].Prop("RRULE"); p.Value != expected {
+			t.Errorf("unexpected rule %q, expected %q", p.Value, expected)
+		}
+	}
+	if p, _ := tz.Components[1].Prop("DTSTART"); p.Value != "20181104T020000" {
+		t.Errorf("unexpected start %q", p.Value)
+	}
+	//an event before the first change of the clocks in its year still comes after the start of the observances
+	brussels, err := time.LoadLocation("Europe/Brussels")
+	if err != nil {
+		t.Skip(err)
+	}
+	tz = vtimezone(brussels, time.Date(2024, 1, 15, 9, 0, 0, 0, brussels))
+	for i, expected := range []string{"20230326T020000", "20231029T030000"} {
+		if p, _ := tz.Components[i].Prop("DTSTART"); p.Value != expected {
+			t.Errorf("unexpected start %q, expected %q", p.Value, expected)
+		}
+	}
+	tz = vtimezone(time.FixedZone("Fixed", -(5*3600+30*60)), time.Now())
+	if p, _ := tz.Components[0].Prop("TZOFFSETTO"); len(tz.Components) != 1 || p.Value != "-0530" {
+		t.Errorf("unexpected time zone without changes %+v", tz.Components)
+	}
+}
+
+func mustParseURL(raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func mustMain(t *testing.T, b *blob.Blob) blob.Rendition {
+	t.Helper()
+	main, found := b.Main()
+	if !found {
+		t.Fatal("expected a blob")
+	}
+	return main
+}
@@ -0,0 +1,299 @@
+package ical
+
+import (
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/contentline"
+	"github.com/vital-dhaveloose/aldb/common/datetime"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+	"github.com/vital-dhaveloose/aldb/common/mediatype"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+const (
+	layoutDate     = "20060102"
+	layoutDateTime = "20060102T150405"
+	layoutUTC      = "20060102T150405Z"
+)
+
+//descriptionMediaType is the media type of the blob that the DESCRIPTION of an event becomes.
+var descriptionMediaType = mediatype.MustParse("text/plain; charset=utf-8")
+
+type ImportOptions struct {
+	//Super is the activity that the events become Subs of, if its Id is set.
+	Super ref.ActivityRef
+	//Base is the id from which the ids of the activities of events are derived, if their UID isn't the id of
+	//an activity (see UID): an event with UID "42" becomes "<Base>/events/42". It defaults to the id of Super.
+	Base *url.URL
+	//Location is the time zone of dates and of times without time zone ("floating" times), UTC if nil.
+	Location *time.Location
+	//Lang is the language of texts without LANGUAGE parameter, LangAny if empty.
+	Lang lang.Lang
+}
+
+//Import reads the events of the calendars in r as activities, in the order of the events, without Version
+//(so creating them creates a new version of activities that were imported before). A changed occurrence of a
+//recurring event (with a RECURRENCE-ID) becomes a separate activity, which is excluded from the recurrence of
+//the event. Other components than events (e.g. to-dos) are ignored, and so are attendees that are rooms or
+//resources. The common name (CN) of a participant becomes the given name of the person, as it can't be split
+//in parts reliably.
+func Import(r io.Reader, opts ImportOptions) ([]activity.Activity, error) {
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	if len(opts.Lang) == 0 {
+		opts.Lang = lang.LangAny
+	}
+	if opts.Base == nil {
+		opts.Base = opts.Super.Id
+	}
+	cs, err := contentline.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	events := []*contentline.Component{}
+	for _, c := range cs {
+		if c.Name != "VCALENDAR" {
+			return nil, aldberr.New(ErrorCodeInvalidCalendar, "cannot import calendar: expected VCALENDAR", map[string]interface{}{"component": c.Name})
+		}
+		events = append(events, c.Children("VEVENT")...)
+	}
+
+	out := make([]activity.Activity, 0, len(events))
+	//recurring is the index in out of every recurring event by UID, overrides the recurrence ids by index
+	recurring := map[string]int{}
+	overrides := map[string][]time.Time{}
+	for _, ev := range events {
+		a, uid, rid, err := importEvent(ev, opts)
+		if err != nil {
+			return nil, err
+		}
+		if !rid.IsZero() {
+			overrides[uid] = append(overrides[uid], rid)
+		} else if a.Period.Recurrence != nil {
+			recurring[uid] = len(out)
+		}
+		out = append(out, a)
+	}
+	for uid, rids := range overrides {
+		if i, found := recurring[uid]; found {
+			out[i].Period.Recurrence.ExDates = append(out[i].Period.Recurrence.ExDates, rids...)
+		}
+	}
+	return out, nil
+}
+
+//importEvent returns the activity of a VEVENT, its UID and its RECURRENCE-ID (zero if it has none).
+func importEvent(ev *contentline.Component, opts ImportOptions) (activity.Activity, string, time.Time, error) {
+	uidProp, found := ev.Prop("UID")
+	uid := uidProp.Text()
+	if !found || len(uid) == 0 {
+		return activity.Activity{}, "", time.Time{}, aldberr.New(ErrorCodeInvalidCalendar, "cannot import event: no UID", nil)
+	}
+	errDet := map[string]interface{}{"uid": uid}
+	rid, ridRaw := time.Time{}, ""
+	if p, found := ev.Prop("RECURRENCE-ID"); found {
+		t, isDate, _, err := parseTime(p, p.Value, opts.Location)
+		if err != nil {
+			return activity.Activity{}, "", time.Time{}, err
+		}
+		rid, ridRaw = t, t.UTC().Format(layoutUTC)
+		if isDate {
+			ridRaw = t.Format(layoutDate)
+		}
+	}
+	id, err := activityId(opts.Base, uid, ridRaw)
+	if err != nil {
+		return activity.Activity{}, "", time.Time{}, err
+	}
+	a := activity.Activity{ActivityRef: ref.ActivityRef{Id: id}}
+	if opts.Super.Id != nil {
+		a.Supers = []*activity.Activity{{ActivityRef: ref.ActivityRef{Id: opts.Super.Id}}}
+	}
+
+	if a.Period, err = importPeriod(ev, opts.Location, !rid.IsZero()); err != nil {
+		return activity.Activity{}, "", time.Time{}, aldberr.Wrap(err, ErrorCodeInvalidCalendar, "cannot import event: invalid time", errDet)
+	}
+	if p, found := ev.Prop("SUMMARY"); found {
+		l, err := textLang(p, opts.Lang)
+		if err != nil {
+			return activity.Activity{}, "", time.Time{}, err
+		}
+		a.Label = lang.LocalizableString{l: p.Text()}
+	}
+	if p, found := ev.Prop("DESCRIPTION"); found && len(p.Text()) > 0 {
+		a.Blob = &blob.Blob{Renditions: []blob.Rendition{{
+			Function: blob.RenditionFunctionMain,
+			Manifest: blob.BlobManifest{MediaType: descriptionMediaType},
+			Content:  blob.Bytes([]byte(p.Text())),
+		}}}
+	}
+
+	participants := []contentline.Property{}
+	if p, found := ev.Prop("ORGANIZER"); found {
+		participants = append(participants, p)
+	}
+	participants = append(participants, ev.Props("ATTENDEE")...)
+	for _, p := range participants {
+		role := RoleOrganiser
+		if p.Name == "ATTENDEE" {
+			role = RoleAttendee
+			if r, found := attendeeRoles[strings.ToUpper(p.Param("ROLE"))]; found {
+				role = r
+			}
+		}
+		person, ok := importPerson(p)
+		if !ok {
+			continue
+		}
+		a.Participations = append(a.Participations, participation.Participation{
+			ParticipationRef: ref.ParticipationRef{ActivityRef: ref.ActivityRef{Id: id}, ParticipationId: strconv.Itoa(len(a.Participations) + 1)},
+			Entity:           person,
+			Role:             &role,
+		})
+	}
+	return a, uid, rid, nil
+}
+
+//importPeriod returns the period of an event from its DTSTART, and its DTEND or DURATION. An event without
+//either lasts a day if it starts at a date, and is an instant otherwise. The recurrence (RRULE and EXDATE) is
+//ignored for a changed occurrence.
+func importPeriod(ev *contentline.Component, loc *time.Location, isOccurrence bool) (datetime.Period, error) {
+	startProp, found := ev.Prop("DTSTART")
+	if !found {
+		return datetime.Period{}, aldberr.New(ErrorCodeInvalidCalendar, "no DTSTART", nil)
+	}
+	start, isDate, startLoc, err := parseTime(startProp, startProp.Value, loc)
+	if err != nil {
+		return datetime.Period{}, err
+	}
+	p := datetime.Period{Start: start, TimeZone: zoneName(startLoc)}
+	if endProp, found := ev.Prop("DTEND"); found {
+		if p.End, _, _, err = parseTime(endProp, endProp.Value, loc); err != nil {
+			return datetime.Period{}, err
+		}
+	} else if durProp, found := ev.Prop("DURATION"); found {
+		d, err := datetime.ParseDuration(durProp.Value)
+		if err != nil {
+			return datetime.Period{}, err
+		}
+		p.End = d.AddTo(start)
+	} else if isDate {
+		p.End = start.AddDate(0, 0, 1)
+	} else {
+		p.End = start
+	}
+	if p.End.Equal(p.Start) {
+		p.EndInclusive = true
+	}
+
+	if ruleProp, found := ev.Prop("RRULE"); found && !isOccurrence {
+		rule, err := datetime.ParseRRule(ruleProp.Value)
+		if err != nil {
+			return datetime.Period{}, err
+		}
+		p.Recurrence = &datetime.Recurrence{Rule: rule}
+		for _, exProp := range ev.Props("EXDATE") {
+			for _, raw := range strings.Split(exProp.Value, ",") {
+				ex, _, _, err := parseTime(exProp, raw, loc)
+				if err != nil {
+					return datetime.Period{}, err
+				}
+				p.Recurrence.ExDates = append(p.Recurrence.ExDates, ex)
+			}
+		}
+	}
+	return p, p.Validate()
+}
+
+//parseTime parses a DATE or DATE-TIME value of property p, and returns whether it is a date and the
+//location it is in: UTC for times in UTC, the location of the TZID parameter, or else loc.
+func parseTime(p contentline.Property, value string, loc *time.Location) (time.Time, bool, *time.Location, error) {
+	errDet := map[string]interface{}{"property": p.Name, "value": value}
+	if strings.EqualFold(p.Param("VALUE"), "DATE") || len(value) == len(layoutDate) {
+		t, err := time.ParseInLocation(layoutDate, value, loc)
+		if err != nil {
+			return time.Time{}, false, nil, aldberr.Wrap(err, ErrorCodeInvalidCalendar, "invalid date", errDet)
+		}
+		return t, true, loc, nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(layoutUTC, value)
+		if err != nil {
+			return time.Time{}, false, nil, aldberr.Wrap(err, ErrorCodeInvalidCalendar, "invalid time", errDet)
+		}
+		return t, false, time.UTC, nil
+	}
+	if tzid := p.Param("TZID"); len(tzid) > 0 {
+		l, err := loadLocation(tzid)
+		if err != nil {
+			return time.Time{}, false, nil, err
+		}
+		loc = l
+	}
+	t, err := time.ParseInLocation(layoutDateTime, value, loc)
+	if err != nil {
+		return time.Time{}, false, nil, aldberr.Wrap(err, ErrorCodeInvalidCalendar, "invalid time", errDet)
+	}
+	return t, false, loc, nil
+}
+
+//loadLocation returns the location of a TZID, which is usually the name of an IANA time zone, possibly with a
+//prefix (e.g. "/mozilla.org/20050126_1/Europe/Brussels").
+func loadLocation(tzid string) (*time.Location, error) {
+	segments := strings.Split(strings.Trim(tzid, "/"), "/")
+	for n := len(segments); n > 0; n-- {
+		name := strings.Join(segments[len(segments)-n:], "/")
+		if name == "Local" {
+			continue
+		}
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc, nil
+		}
+	}
+	return nil, aldberr.New(ErrorCodeInvalidCalendar, "unknown time zone", map[string]interface{}{"tzid": tzid})
+}
+
+//zoneName returns the name of loc as the TimeZone of a period, which is empty for UTC.
+func zoneName(loc *time.Location) string {
+	if loc == nil || loc == time.UTC || loc.String() == "UTC" || loc.String() == "Local" {
+		return ""
+	}
+	return loc.String()
+}
+
+//textLang returns the language of the LANGUAGE parameter of p, or else def.
+func textLang(p contentline.Property, def lang.Lang) (lang.Lang, error) {
+	if raw := p.Param("LANGUAGE"); len(raw) > 0 {
+		return lang.ParseLang(raw)
+	}
+	return def, nil
+}
+
+//importPerson returns the person of an ORGANIZER or ATTENDEE, which has an email address if the value is a
+//mailto URI. It returns false for rooms and resources, and for participants without name or email address.
+func importPerson(p contentline.Property) (*participation.Person, bool) {
+	switch strings.ToUpper(p.Param("CUTYPE")) {
+	case "ROOM", "RESOURCE":
+		return nil, false
+	}
+	email := ""
+	if scheme, address, found := strings.Cut(p.Value, ":"); found && strings.EqualFold(scheme, "mailto") {
+		if unescaped, err := url.PathUnescape(address); err == nil {
+			email = unescaped
+		}
+	}
+	cn := p.Param("CN")
+	if len(email) == 0 && len(cn) == 0 {
+		return nil, false
+	}
+	return &participation.Person{Name: participation.PersonName{Given: cn}, Email: email}, true
+}
@@ -0,0 +1,99 @@
+package ical
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/common/contentline"
+)
+
+var weekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+//vtimezone describes the rules of a time zone from before at on, assuming that its clocks change at the
+//same time every year (e.g. "the last Sunday of March"), as they do in most time zones. The observances start
+//in the year before the one of at: a time before the first observance has no defined offset (RFC 5545
+//section 3.6.5), and at may be before the first change of the clocks in its own year.
+func vtimezone(loc *time.Location, at time.Time) *contentline.Component {
+	tz := &contentline.Component{Name: "VTIMEZONE"}
+	tz.Add("TZID", loc.String())
+	year := at.In(loc).Year() - 1
+	transitions := transitionsIn(loc, year)
+	if len(transitions) == 0 {
+		name, offset := time.Date(year, 1, 1, 0, 0, 0, 0, loc).Zone()
+		std := &contentline.Component{Name: "STANDARD"}
+		std.Add("DTSTART", "19700101T000000")
+		std.Add("TZOFFSETFROM", formatOffset(offset))
+		std.Add("TZOFFSETTO", formatOffset(offset))
+		std.AddText("TZNAME", name)
+		tz.Components = append(tz.Components, std)
+		return tz
+	}
+	for _, t := range transitions {
+		_, from := t.Add(-time.Second).Zone()
+		name, to := t.Zone()
+		kind := "STANDARD"
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		//the start of an observance is in local time before the change
+		local := t.In(time.FixedZone("", from))
+		obs := &contentline.Component{Name: kind}
+		obs.Add("DTSTART", local.Format(layoutDateTime))
+		obs.Add("TZOFFSETFROM", formatOffset(from))
+		obs.Add("TZOFFSETTO", formatOffset(to))
+		obs.AddText("TZNAME", name)
+		obs.Add("RRULE", yearlyRule(local))
+		tz.Components = append(tz.Components, obs)
+	}
+	return tz
+}
+
+//transitionsIn returns the times in the year at which the offset of loc changes.
+func transitionsIn(loc *time.Location, year int) []time.Time {
+	out := []time.Time{}
+	t := time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+	end := time.Date(year+1, 1, 1, 0, 0, 0, 0, loc)
+	for t.Before(end) {
+		next := t.Add(24 * time.Hour)
+		_, offset := t.Zone()
+		if _, nextOffset := next.Zone(); nextOffset != offset {
+			//the offset at lo is the old one, at hi the new one
+			lo, hi := t.Unix(), next.Unix()
+			for hi-lo > 1 {
+				mid := lo + (hi-lo)/2
+				if _, o := time.Unix(mid, 0).In(loc).Zone(); o == offset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			out = append(out, time.Unix(hi, 0).In(loc))
+		}
+		t = next
+	}
+	return out
+}
+
+//yearlyRule returns the rule of a change of the clocks at the weekday of t in its month, e.g. the last Sunday of
+//March.
+func yearlyRule(t time.Time) string {
+	n := strconv.Itoa((t.Day()-1)/7 + 1)
+	if t.AddDate(0, 0, 7).Month() != t.Month() {
+		n = "-1"
+	}
+	return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%s%s", t.Month(), n, weekdays[t.Weekday()])
+}
+
+//formatOffset formats an offset in seconds east of UTC as a UTC-OFFSET, e.g. "+0100".
+func formatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign, seconds = '-', -seconds
+	}
+	out := fmt.Sprintf("%c%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		out += fmt.Sprintf("%02d", seconds%60)
+	}
+	return out
+}
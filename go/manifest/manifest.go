@@ -0,0 +1,170 @@
+//Package manifest keeps a registry of attribute set manifests, which are versioned JSON Schemas, and
+//validates attribute sets against them.
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+const (
+	ErrorCodeNotFound       = "manifest-not-found"
+	ErrorCodeInvalidRequest = "manifest-invalid-request"
+	ErrorCodeInvalidSchema  = "manifest-invalid-schema"
+	//ErrorCodeVersionNotIncreasing is returned when a new version doesn't come after the latest version.
+	ErrorCodeVersionNotIncreasing = "manifest-version-not-increasing"
+	//ErrorCodeInvalidAttributes is returned when attribute sets don't satisfy their manifests. The details
+	//contain the "violations", each with the JSON "pointer" of the offending value in the activity, the
+	//"manifest" and a "message".
+	ErrorCodeInvalidAttributes = "manifest-invalid-attributes"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeNotFound, Status: http.StatusNotFound, Message: errcode.En("manifest not found")},
+		errcode.Code{Code: ErrorCodeInvalidRequest, Status: http.StatusBadRequest, Message: errcode.En("invalid manifest request")},
+		errcode.Code{Code: ErrorCodeInvalidSchema, Status: http.StatusBadRequest, Message: errcode.En("invalid manifest schema")},
+		errcode.Code{Code: ErrorCodeVersionNotIncreasing, Status: http.StatusConflict, Message: errcode.En("the manifest version doesn't come after the latest version")},
+		errcode.Code{Code: ErrorCodeInvalidAttributes, Status: http.StatusBadRequest, Message: errcode.En("attribute sets don't satisfy their manifests")},
+	)
+}
+
+//Manifest is a published version of the JSON Schema that the attributes of an attribute set must satisfy.
+type Manifest struct {
+	ref.ManifestRef
+	Schema json.RawMessage
+}
+
+type manifestJSON struct {
+	Id      string          `json:"id"`
+	Version string          `json:"version,omitempty"`
+	Schema  json.RawMessage `json:"schema"`
+}
+
+func (m Manifest) MarshalJSON() ([]byte, error) {
+	mj := manifestJSON{Version: m.Version, Schema: m.Schema}
+	if m.Id != nil {
+		mj.Id = m.Id.String()
+	}
+	return json.Marshal(mj)
+}
+
+func (m *Manifest) UnmarshalJSON(bts []byte) error {
+	mj := manifestJSON{}
+	if err := json.Unmarshal(bts, &mj); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidRequest, "cannot unmarshal Manifest", nil)
+	}
+	out := Manifest{ManifestRef: ref.ManifestRef{Version: mj.Version}, Schema: mj.Schema}
+	if len(mj.Id) > 0 {
+		id, err := url.Parse(mj.Id)
+		if err != nil {
+			return aldberr.Wrap(err, ErrorCodeInvalidRequest, "cannot unmarshal Manifest: invalid id", map[string]interface{}{"id": mj.Id})
+		}
+		out.Id = id
+	}
+	*m = out
+	return nil
+}
+
+//Registry keeps the versions of manifests. Implementations must be safe for concurrent use.
+//
+//Like activity versions, manifest versions are immutable and ordered lexicographically (see
+//ref.ManifestRef), the greatest one is the latest version.
+type Registry interface {
+	Publish(ctx context.Context, req PublishRequest) (PublishResponse, error)
+	Read(ctx context.Context, req ReadRequest) (ReadResponse, error)
+	List(ctx context.Context, req ListRequest) (ListResponse, error)
+	History(ctx context.Context, req HistoryRequest) (HistoryResponse, error)
+}
+
+//PublishRequest publishes a new version of a manifest, which becomes its latest version.
+type PublishRequest struct {
+	//ToPublish is the new version. Its Id and Schema are required. If its Version is empty, the Registry
+	//generates one with ref.NextVersion, otherwise the version must come after the latest version.
+	ToPublish Manifest
+}
+
+type PublishResponse struct {
+	Published Manifest
+}
+
+type ReadRequest struct {
+	//Ref is the manifest to read. If Version is empty, the latest version is read.
+	Ref ref.ManifestRef
+}
+
+type ReadResponse struct {
+	Manifest Manifest
+}
+
+type ListRequest struct {
+}
+
+type ListResponse struct {
+	//Manifests contains the latest version of every manifest, sorted by id.
+	Manifests []Manifest
+}
+
+type HistoryRequest struct {
+	//Ref is the manifest of which to read the history. Its Version is ignored.
+	Ref ref.ManifestRef
+}
+
+type HistoryResponse struct {
+	//Versions contains every version of the manifest, oldest first.
+	Versions []Manifest
+}
+
+//checkSchema returns an error if the schema of m is not a JSON Schema document.
+func checkSchema(m Manifest) error {
+	det := map[string]interface{}{"id": manifestKeyOrEmpty(m.ManifestRef)}
+	if len(m.Schema) == 0 {
+		return aldberr.New(ErrorCodeInvalidSchema, "manifest has no schema", det)
+	}
+	var schema interface{}
+	if err := json.Unmarshal(m.Schema, &schema); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidSchema, "manifest schema is not valid JSON", det)
+	}
+	switch schema.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return aldberr.New(ErrorCodeInvalidSchema, "manifest schema must be an object or a boolean", det)
+	}
+	return nil
+}
+
+func manifestKey(r ref.ManifestRef) (string, error) {
+	if r.Id == nil || len(r.Id.String()) == 0 {
+		return "", aldberr.New(ErrorCodeInvalidRequest, "manifest ref has no id", nil)
+	}
+	return r.Id.String(), nil
+}
+
+func manifestKeyOrEmpty(r ref.ManifestRef) string {
+	if r.Id == nil {
+		return ""
+	}
+	return r.Id.String()
+}
+
+func notFound(r ref.ManifestRef) error {
+	return aldberr.New(ErrorCodeNotFound, "manifest not found", map[string]interface{}{"id": manifestKeyOrEmpty(r), "version": r.Version})
+}
+
+func clone(m Manifest) Manifest {
+	out := m
+	if m.Id != nil {
+		id := *m.Id
+		out.Id = &id
+	}
+	if m.Schema != nil {
+		out.Schema = append(json.RawMessage{}, m.Schema...)
+	}
+	return out
+}
@@ -0,0 +1,242 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/attributes"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+const projectSchema = `{
+	"type": "object",
+	"properties": {
+		"totalBudget": {"$ref": "https://projo.com/schemas/money"},
+		"priorityClass": {"enum": ["low", "normal", "high"]}
+	},
+	"required": ["priorityClass"]
+}`
+
+const moneySchema = `{
+	"type": "object",
+	"properties": {"currency": {"type": "string", "pattern": "^[A-Z]{3}$"}, "amount": {"type": "number", "minimum": 0}},
+	"required": ["currency", "amount"]
+}`
+
+func TestRegistries(t *testing.T) {
+	fsRegistry, err := NewFilesystemRegistry(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, r := range map[string]Registry{"memory": NewMemoryRegistry(), "filesystem": fsRegistry} {
+		t.Run(name, func(t *testing.T) {
+			testRegistry(t, r)
+		})
+	}
+}
+
+func testRegistry(t *testing.T, r Registry) {
+	ctx := context.Background()
+	first := publish(t, r, "https://projo.com/schemas/project", "", `{"type":"object"}`)
+	if len(first.Version) == 0 {
+		t.Fatal("expected a generated version")
+	}
+	second := publish(t, r, "https://projo.com/schemas/project", "", projectSchema)
+	if second.Version <= first.Version {
+		t.Errorf("expected increasing versions, got %s after %s", second.Version, first.Version)
+	}
+	publish(t, r, "https://projo.com/schemas/money", "0001", moneySchema)
+
+	_, err := r.Publish(ctx, PublishRequest{ToPublish: manifestOf("https://projo.com/schemas/money", "0001", moneySchema)})
+	expectCode(t, err, ErrorCodeVersionNotIncreasing)
+	_, err = r.Publish(ctx, PublishRequest{ToPublish: manifestOf("https://projo.com/schemas/x", "", `[1]`)})
+	expectCode(t, err, ErrorCodeInvalidSchema)
+	_, err = r.Publish(ctx, PublishRequest{ToPublish: manifestOf("https://projo.com/schemas/x", "", `{`)})
+	expectCode(t, err, ErrorCodeInvalidSchema)
+	_, err = r.Publish(ctx, PublishRequest{ToPublish: Manifest{Schema: json.RawMessage(`{}`)}})
+	expectCode(t, err, ErrorCodeInvalidRequest)
+
+	latest, err := r.Read(ctx, ReadRequest{Ref: ref.ManifestRef{Id: second.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Manifest.Version != second.Version {
+		t.Errorf("expected latest version %s, got %s", second.Version, latest.Manifest.Version)
+	}
+	old, err := r.Read(ctx, ReadRequest{Ref: first.ManifestRef})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(old.Manifest.Schema) != `{"type":"object"}` {
+		t.Errorf("unexpected schema %s", old.Manifest.Schema)
+	}
+	_, err = r.Read(ctx, ReadRequest{Ref: ref.ManifestRef{Id: first.Id, Version: "nope"}})
+	expectCode(t, err, ErrorCodeNotFound)
+
+	list, err := r.List(ctx, ListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Manifests) != 2 || list.Manifests[0].Id.String() != "https://projo.com/schemas/money" || list.Manifests[1].Version != second.Version {
+		t.Errorf("unexpected list %v", list.Manifests)
+	}
+	history, err := r.History(ctx, HistoryRequest{Ref: first.ManifestRef})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Versions) != 2 || history.Versions[0].Version != first.Version {
+		t.Errorf("unexpected history %v", history.Versions)
+	}
+}
+
+func TestFilesystemRegistryReload(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewFilesystemRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, r, "https://projo.com/schemas/money", "0001", moneySchema)
+	publish(t, r, "https://projo.com/schemas/money", "0002", moneySchema)
+
+	reloaded, err := NewFilesystemRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := reloaded.History(context.Background(), HistoryRequest{Ref: refOf("https://projo.com/schemas/money", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Versions) != 2 || history.Versions[1].Version != "0002" {
+		t.Errorf("unexpected history after reload %v", history.Versions)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemoryRegistry()
+	v1 := publish(t, r, "https://projo.com/schemas/project", "0001", `{"type":"object"}`)
+	publish(t, r, "https://projo.com/schemas/project", "0002", projectSchema)
+	publish(t, r, "https://projo.com/schemas/money", "0001", moneySchema)
+
+	cases := []struct {
+		name     string
+		sets     map[string]attributes.AttributeSet
+		pointers []string
+	}{
+		{"valid", map[string]attributes.AttributeSet{
+			"projo-attrs": set("https://projo.com/schemas/project", "", nil, map[string]interface{}{"priorityClass": "normal", "totalBudget": map[string]interface{}{"currency": "EUR", "amount": 456000}}),
+		}, nil},
+		{"invalid", map[string]attributes.AttributeSet{
+			"projo-attrs": set("https://projo.com/schemas/project", "", nil, map[string]interface{}{"priorityClass": "urgent", "totalBudget": map[string]interface{}{"currency": "euro", "amount": -1}}),
+		}, []string{
+			"/attributeSets/projo-attrs/attributes/priorityClass",
+			"/attributeSets/projo-attrs/attributes/totalBudget/amount",
+			"/attributeSets/projo-attrs/attributes/totalBudget/currency",
+		}},
+		{"missing required", map[string]attributes.AttributeSet{
+			"a/b": set("https://projo.com/schemas/project", "", nil, nil),
+		}, []string{"/attributeSets/a~1b/attributes"}},
+		{"pinned old version", map[string]attributes.AttributeSet{
+			"projo-attrs": set("https://projo.com/schemas/project", v1.Version, nil, map[string]interface{}{"priorityClass": "urgent"}),
+		}, nil},
+		{"unknown version", map[string]attributes.AttributeSet{
+			"projo-attrs": set("https://projo.com/schemas/project", "9999", nil, nil),
+		}, []string{"/attributeSets/projo-attrs/manifest"}},
+		{"unregistered", map[string]attributes.AttributeSet{
+			"other": set("https://other.com/schemas/x", "", nil, map[string]interface{}{"anything": true}),
+			"none":  {Attributes: map[string]interface{}{"anything": true}},
+		}, nil},
+		{"inline", map[string]attributes.AttributeSet{
+			"inline": set("", "", json.RawMessage(`{"properties":{"n":{"type":"integer"}}}`), map[string]interface{}{"n": 1.5}),
+		}, []string{"/attributeSets/inline/attributes/n"}},
+		{"inline references registry", map[string]attributes.AttributeSet{
+			"inline": set("https://app.test/m", "", json.RawMessage(`{"properties":{"price":{"$ref":"https://projo.com/schemas/money"}}}`), map[string]interface{}{"price": map[string]interface{}{"currency": "EUR"}}),
+		}, []string{"/attributeSets/inline/attributes/price"}},
+	}
+	for _, c := range cases {
+		err := Validate(ctx, r, c.sets)
+		if len(c.pointers) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", c.name, err)
+			}
+			continue
+		}
+		cErr := expectCode(t, err, ErrorCodeInvalidAttributes)
+		pointers := []string{}
+		for _, v := range cErr.Details()["violations"].([]map[string]interface{}) {
+			pointers = append(pointers, v["pointer"].(string))
+		}
+		if !reflect.DeepEqual(pointers, c.pointers) {
+			t.Errorf("%s: expected violations at %v, got %v", c.name, c.pointers, pointers)
+		}
+	}
+
+	err := Validate(ctx, r, map[string]attributes.AttributeSet{"x": set("", "", json.RawMessage(`{`), nil)})
+	expectCode(t, err, ErrorCodeInvalidSchema)
+}
+
+func TestValidatingStore(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemoryRegistry()
+	publish(t, r, "https://projo.com/schemas/project", "", projectSchema)
+	publish(t, r, "https://projo.com/schemas/money", "", moneySchema)
+	s := NewValidatingStore(store.NewMemoryStore(), r)
+
+	a := activity.Activity{}
+	a.Id, _ = url.Parse("https://aldb.test/activities/project")
+	a.AttributeSets = map[string]attributes.AttributeSet{
+		"projo-attrs": set("https://projo.com/schemas/project", "", nil, map[string]interface{}{"priorityClass": "none"}),
+	}
+	_, err := s.Create(ctx, store.CreateRequest{ToCreate: a})
+	expectCode(t, err, ErrorCodeInvalidAttributes)
+
+	a.AttributeSets["projo-attrs"].Attributes["priorityClass"] = "high"
+	if _, err := s.Create(ctx, store.CreateRequest{ToCreate: a}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(ctx, store.CreateRequest{ToCreate: activity.Activity{ActivityRef: a.ActivityRef}}); err != nil {
+		t.Errorf("carrying over attribute sets should not fail: %v", err)
+	}
+}
+
+func publish(t *testing.T, r Registry, id, version, schema string) Manifest {
+	t.Helper()
+	resp, err := r.Publish(context.Background(), PublishRequest{ToPublish: manifestOf(id, version, schema)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Published
+}
+
+func manifestOf(id, version, schema string) Manifest {
+	return Manifest{ManifestRef: refOf(id, version), Schema: json.RawMessage(schema)}
+}
+
+func refOf(id, version string) ref.ManifestRef {
+	u, _ := url.Parse(id)
+	return ref.ManifestRef{Id: u, Version: version}
+}
+
+func set(manifestId, version string, schema json.RawMessage, attrs map[string]interface{}) attributes.AttributeSet {
+	m := &attributes.Manifest{Schema: schema}
+	if len(manifestId) > 0 {
+		m.ManifestRef = refOf(manifestId, version)
+	}
+	return attributes.AttributeSet{Manifest: m, Attributes: attrs}
+}
+
+func expectCode(t *testing.T, err error, code string) aldberr.CanvigaError {
+	t.Helper()
+	cErr := aldberr.CanvigaError{}
+	if !errors.As(err, &cErr) || cErr.Code() != code {
+		t.Fatalf("expected error %s, got %v", code, err)
+	}
+	return cErr
+}
@@ -0,0 +1,250 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+const (
+	ErrorCodeFilesystem = "manifest-fs-error"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeFilesystem, Status: http.StatusInternalServerError, Message: errcode.En("cannot access the manifests on the filesystem")},
+	)
+}
+
+//MemoryRegistry is a Registry that keeps everything in memory.
+type MemoryRegistry struct {
+	mu sync.RWMutex
+	//versions maps manifest ids to their versions in order, the last one is the latest.
+	versions map[string][]Manifest
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{versions: map[string][]Manifest{}}
+}
+
+func (r *MemoryRegistry) Publish(ctx context.Context, req PublishRequest) (PublishResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return PublishResponse{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, err := r.prepare(req.ToPublish)
+	if err != nil {
+		return PublishResponse{}, err
+	}
+	r.add(m)
+	return PublishResponse{Published: clone(m)}, nil
+}
+
+func (r *MemoryRegistry) Read(ctx context.Context, req ReadRequest) (ReadResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ReadResponse{}, err
+	}
+	id, err := manifestKey(req.Ref)
+	if err != nil {
+		return ReadResponse{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	versions := r.versions[id]
+	if len(versions) == 0 {
+		return ReadResponse{}, notFound(req.Ref)
+	}
+	if len(req.Ref.Version) == 0 {
+		return ReadResponse{Manifest: clone(versions[len(versions)-1])}, nil
+	}
+	for _, m := range versions {
+		if m.Version == req.Ref.Version {
+			return ReadResponse{Manifest: clone(m)}, nil
+		}
+	}
+	return ReadResponse{}, notFound(req.Ref)
+}
+
+func (r *MemoryRegistry) List(ctx context.Context, req ListRequest) (ListResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ListResponse{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.versions))
+	for id := range r.versions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	out := make([]Manifest, len(ids))
+	for i, id := range ids {
+		versions := r.versions[id]
+		out[i] = clone(versions[len(versions)-1])
+	}
+	return ListResponse{Manifests: out}, nil
+}
+
+func (r *MemoryRegistry) History(ctx context.Context, req HistoryRequest) (HistoryResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return HistoryResponse{}, err
+	}
+	id, err := manifestKey(req.Ref)
+	if err != nil {
+		return HistoryResponse{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	versions := r.versions[id]
+	if len(versions) == 0 {
+		return HistoryResponse{}, notFound(req.Ref)
+	}
+	out := make([]Manifest, len(versions))
+	for i := range versions {
+		out[i] = clone(versions[i])
+	}
+	return HistoryResponse{Versions: out}, nil
+}
+
+//prepare checks m and fills in its version. It must be called with the write lock held.
+func (r *MemoryRegistry) prepare(m Manifest) (Manifest, error) {
+	id, err := manifestKey(m.ManifestRef)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if err := checkSchema(m); err != nil {
+		return Manifest{}, err
+	}
+	m = clone(m)
+	latest := ""
+	if versions := r.versions[id]; len(versions) > 0 {
+		latest = versions[len(versions)-1].Version
+	}
+	if len(m.Version) == 0 {
+		m.Version = ref.NextVersion(latest)
+	} else if m.Version <= latest {
+		return Manifest{}, aldberr.New(ErrorCodeVersionNotIncreasing, "version must come after the latest version", map[string]interface{}{"id": id, "version": m.Version, "latest": latest})
+	}
+	return m, nil
+}
+
+//add must be called with the write lock held.
+func (r *MemoryRegistry) add(m Manifest) {
+	id := m.Id.String()
+	r.versions[id] = append(r.versions[id], m)
+}
+
+//FilesystemRegistry is a Registry that keeps every version of a manifest as a JSON file, in a directory per
+//manifest: <root>/<escaped id>/<version>.json.
+type FilesystemRegistry struct {
+	root string
+	//mem contains everything that is on disk.
+	mem *MemoryRegistry
+}
+
+//NewFilesystemRegistry creates a FilesystemRegistry on the given root directory, creating it if needed,
+//and reads the manifests in it.
+func NewFilesystemRegistry(root string) (*FilesystemRegistry, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fsError(err, "cannot create root directory", root)
+	}
+	r := &FilesystemRegistry{root: root, mem: NewMemoryRegistry()}
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fsError(err, "cannot read directory", root)
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		dirPath := filepath.Join(root, dir.Name())
+		files, err := os.ReadDir(dirPath)
+		if err != nil {
+			return nil, fsError(err, "cannot read directory", dirPath)
+		}
+		versions := []Manifest{}
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+				continue
+			}
+			path := filepath.Join(dirPath, f.Name())
+			m := Manifest{}
+			bts, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fsError(err, "cannot read manifest", path)
+			}
+			if err := json.Unmarshal(bts, &m); err != nil {
+				return nil, fsError(err, "cannot parse manifest", path)
+			}
+			if m.Id == nil || len(m.Version) == 0 {
+				return nil, fsError(nil, "manifest file without id or version", path)
+			}
+			versions = append(versions, m)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+		for _, m := range versions {
+			r.mem.add(m)
+		}
+	}
+	return r, nil
+}
+
+//Publish writes the new version to disk before making it available.
+func (r *FilesystemRegistry) Publish(ctx context.Context, req PublishRequest) (PublishResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return PublishResponse{}, err
+	}
+	r.mem.mu.Lock()
+	defer r.mem.mu.Unlock()
+	m, err := r.mem.prepare(req.ToPublish)
+	if err != nil {
+		return PublishResponse{}, err
+	}
+	dir := filepath.Join(r.root, url.PathEscape(m.Id.String()))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return PublishResponse{}, fsError(err, "cannot create directory", dir)
+	}
+	path := filepath.Join(dir, url.PathEscape(m.Version)+".json")
+	bts, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return PublishResponse{}, fsError(err, "cannot encode manifest", path)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(bts, '\n'), 0o644); err != nil {
+		return PublishResponse{}, fsError(err, "cannot write manifest", path)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return PublishResponse{}, fsError(err, "cannot write manifest", path)
+	}
+	r.mem.add(m)
+	return PublishResponse{Published: clone(m)}, nil
+}
+
+func (r *FilesystemRegistry) Read(ctx context.Context, req ReadRequest) (ReadResponse, error) {
+	return r.mem.Read(ctx, req)
+}
+
+func (r *FilesystemRegistry) List(ctx context.Context, req ListRequest) (ListResponse, error) {
+	return r.mem.List(ctx, req)
+}
+
+func (r *FilesystemRegistry) History(ctx context.Context, req HistoryRequest) (HistoryResponse, error) {
+	return r.mem.History(ctx, req)
+}
+
+func fsError(err error, msg, path string) error {
+	if err == nil {
+		return aldberr.New(ErrorCodeFilesystem, msg, map[string]interface{}{"path": path})
+	}
+	return aldberr.Wrap(err, ErrorCodeFilesystem, msg, map[string]interface{}{"path": path})
+}
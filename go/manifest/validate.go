@@ -0,0 +1,153 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/activity/attributes"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/jsonschema"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+//inlineSchemaURI identifies inline schemas of manifests without id.
+const inlineSchemaURI = "urn:aldb:inline-manifest"
+
+//Validate validates attribute sets, keyed by set id, against the schemas of their manifests: the inline
+//Schema of the manifest if any, otherwise the version of the manifest in r that the ManifestRef refers to.
+//Attribute sets without manifest, or of which the manifest doesn't pin a Version and isn't in r, are not
+//validated. Other schemas that a schema references with $ref are resolved in r as well, by id.
+//
+//All violations are returned in a single error with code ErrorCodeInvalidAttributes, of which the details
+//contain the "violations" with a JSON pointer relative to the activity, e.g.
+///attributeSets/projo-attrs/attributes/totalBudget/amount.
+func Validate(ctx context.Context, r Registry, sets map[string]attributes.AttributeSet) error {
+	setIds := make([]string, 0, len(sets))
+	for setId := range sets {
+		setIds = append(setIds, setId)
+	}
+	sort.Strings(setIds)
+
+	violations := []map[string]interface{}{}
+	for _, setId := range setIds {
+		as := sets[setId]
+		if as.Manifest == nil {
+			continue
+		}
+		uri, schema, found, err := schemaOf(ctx, r, *as.Manifest)
+		if err != nil {
+			return err
+		}
+		pointer := "/attributeSets/" + escapePointer(setId)
+		if !found {
+			if len(as.Manifest.Version) > 0 {
+				violations = append(violations, map[string]interface{}{
+					"pointer":  pointer + "/manifest",
+					"manifest": uri,
+					"message":  "unknown manifest version " + as.Manifest.Version,
+				})
+			}
+			continue
+		}
+
+		v := jsonschema.NewValidator(registryLoader(ctx, r))
+		if err := v.AddDocument(uri, schema); err != nil {
+			return aldberr.Wrap(err, ErrorCodeInvalidSchema, "invalid manifest schema", map[string]interface{}{"manifest": uri})
+		}
+		attrs := as.Attributes
+		if attrs == nil {
+			attrs = map[string]interface{}{}
+		}
+		instance, err := json.Marshal(attrs)
+		if err != nil {
+			return aldberr.Wrap(err, ErrorCodeInvalidAttributes, "cannot encode attributes", map[string]interface{}{"attributeSetId": setId})
+		}
+		result, err := v.Validate(uri, instance)
+		if err != nil {
+			return aldberr.Wrap(err, ErrorCodeInvalidSchema, "cannot validate against manifest schema", map[string]interface{}{"manifest": uri})
+		}
+		for _, viol := range result {
+			violations = append(violations, map[string]interface{}{
+				"pointer":  pointer + "/attributes" + viol.InstancePointer,
+				"manifest": uri,
+				"message":  viol.Message,
+			})
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(violations))
+	for i, viol := range violations {
+		msgs[i] = viol["pointer"].(string) + ": " + viol["message"].(string)
+	}
+	return aldberr.New(ErrorCodeInvalidAttributes, "attributes don't satisfy their manifests: "+strings.Join(msgs, "; "), map[string]interface{}{"violations": violations})
+}
+
+//schemaOf returns the schema that m refers to and the URI identifying it.
+func schemaOf(ctx context.Context, r Registry, m attributes.Manifest) (uri string, schema []byte, found bool, err error) {
+	uri = inlineSchemaURI
+	if m.Id != nil && len(m.Id.String()) > 0 {
+		uri = m.Id.String()
+	}
+	if len(m.Schema) > 0 {
+		return uri, m.Schema, true, nil
+	}
+	if m.Id == nil || r == nil {
+		return uri, nil, false, nil
+	}
+	resp, err := r.Read(ctx, ReadRequest{Ref: m.ManifestRef})
+	if err != nil {
+		if aldberr.IsCode(err, ErrorCodeNotFound) {
+			return uri, nil, false, nil
+		}
+		return uri, nil, false, err
+	}
+	return uri, resp.Manifest.Schema, true, nil
+}
+
+//registryLoader loads the latest version of referenced manifests.
+func registryLoader(ctx context.Context, r Registry) jsonschema.Loader {
+	return func(uri string) ([]byte, error) {
+		if r == nil {
+			return nil, aldberr.New(ErrorCodeNotFound, "manifest not found", map[string]interface{}{"id": uri})
+		}
+		id, err := url.Parse(uri)
+		if err != nil {
+			return nil, aldberr.Wrap(err, ErrorCodeInvalidRequest, "invalid manifest id", map[string]interface{}{"id": uri})
+		}
+		resp, err := r.Read(ctx, ReadRequest{Ref: ref.ManifestRef{Id: id}})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Manifest.Schema, nil
+	}
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+//ValidatingStore is a store.Store that validates the attribute sets of every new version against their
+//manifests (see Validate) before creating it.
+type ValidatingStore struct {
+	store.Store
+	registry Registry
+}
+
+func NewValidatingStore(inner store.Store, r Registry) *ValidatingStore {
+	return &ValidatingStore{Store: inner, registry: r}
+}
+
+//Create validates the attribute sets of ToCreate. Attribute sets that are carried over from the parent
+//version (because ToCreate.AttributeSets is nil) were validated when they were written.
+func (s *ValidatingStore) Create(ctx context.Context, req store.CreateRequest) (store.CreateResponse, error) {
+	if err := Validate(ctx, s.registry, req.ToCreate.AttributeSets); err != nil {
+		return store.CreateResponse{}, err
+	}
+	return s.Store.Create(ctx, req)
+}
@@ -0,0 +1,485 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vital-dhaveloose/aldb/activity/attributes"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	//ErrorCodeInvalidSyntax is returned for strings that are not valid filters. The details contain the
+	//"position" (byte offset) in the "input" where the problem was found.
+	ErrorCodeInvalidSyntax = "query-invalid-syntax"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidSyntax, Status: http.StatusBadRequest, Message: errcode.En("invalid filter syntax at position {position}")},
+	)
+}
+
+//Parse parses a filter. The grammar is:
+//
+//	filter     = or
+//	or         = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | primary
+//	primary    = "(" filter ")" | scope | "exists" path | path operator literal
+//	scope      = "set" name "{" filter "}" | "manifest" string "{" filter "}"
+//	path       = [ name ":" ] attribute
+//	operator   = "=" | "!=" | "<" | "<=" | ">" | ">="
+//	literal    = string | number | "true" | "false" | "null"
+//	name       = identifier | string
+//
+//An identifier consists of letters, digits, "_" and "-" and starts with a letter or "_". The keywords and,
+//or, not, exists, set, manifest, true, false and null are not identifiers, write them as strings to use
+//them as names. Strings are double-quoted with backslash escapes, numbers are written as in JSON. An
+//attribute is an attributes.Path without spaces, e.g. totalBudget.amount, tags[-1] or labels["en-GB"], of
+//which the first key must be quoted (["and"]) if it is a keyword.
+//
+//A path refers to an attribute in the attribute set before the ":", or in any attribute set if there is
+//no set id. A predicate holds if it holds for any of the values the path refers to, "[*]" refers to every
+//element of an array. Within a scope, paths only refer to attributes of the scoped attribute set, e.g.
+//
+//	manifest "https://projo.com/schemas/project" { priorityClass = "normal" and totalBudget.amount > 100000 }
+//
+//holds for activities with an attribute set of that manifest that has both attributes, while
+//
+//	projo-attrs:priorityClass = "normal" and totalBudget.amount > 100000
+//
+//holds if the attribute set with id projo-attrs has the priority class and any attribute set has the budget.
+//See Compare for the semantics of the operators.
+func Parse(s string) (Filter, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{input: s, tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, p.errorAt(t.pos, "unexpected %s", t.describe())
+	}
+	return f, nil
+}
+
+//MustParse is Parse that panics on errors, for filters that are known to be valid.
+func MustParse(s string) Filter {
+	f, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+var keywords = map[string]bool{
+	"and": true, "or": true, "not": true, "exists": true, "set": true, "manifest": true,
+	"true": true, "false": true, "null": true,
+}
+
+//region Lexer
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	pos  int
+	//end is the position after the token
+	end int
+	//text is the identifier, the unquoted string, the number or the punctuation
+	text string
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokenEnd:
+		return "end of input"
+	case tokenString:
+		return strconv.Quote(t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+func syntaxError(input string, pos int, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	return aldberr.New(ErrorCodeInvalidSyntax, fmt.Sprintf("%s at position %d", msg, pos), map[string]interface{}{"position": pos, "input": input})
+}
+
+func lex(s string) ([]token, error) {
+	out := []token{}
+	pos := 0
+	for {
+		for pos < len(s) {
+			r, size := utf8.DecodeRuneInString(s[pos:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			pos += size
+		}
+		if pos >= len(s) {
+			return append(out, token{kind: tokenEnd, pos: pos, end: pos}), nil
+		}
+		start := pos
+		r, _ := utf8.DecodeRuneInString(s[pos:])
+		switch {
+		case r == '"':
+			end := pos + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, syntaxError(s, start, "unterminated string")
+			}
+			str, err := strconv.Unquote(s[start : end+1])
+			if err != nil {
+				return nil, syntaxError(s, start, "invalid string")
+			}
+			out = append(out, token{kind: tokenString, pos: start, end: end + 1, text: str})
+			pos = end + 1
+		case r == '-' || (r >= '0' && r <= '9'):
+			end := pos + 1
+			for end < len(s) && strings.ContainsRune("0123456789.eE+-", rune(s[end])) {
+				if (s[end] == '+' || s[end] == '-') && s[end-1] != 'e' && s[end-1] != 'E' {
+					break
+				}
+				end++
+			}
+			if _, err := strconv.ParseFloat(s[start:end], 64); err != nil {
+				return nil, syntaxError(s, start, "invalid number %q", s[start:end])
+			}
+			out = append(out, token{kind: tokenNumber, pos: start, end: end, text: s[start:end]})
+			pos = end
+		case r == '_' || unicode.IsLetter(r):
+			end := pos
+			for end < len(s) {
+				r, size := utf8.DecodeRuneInString(s[end:])
+				if r != '_' && r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				end += size
+			}
+			out = append(out, token{kind: tokenIdentifier, pos: start, end: end, text: s[start:end]})
+			pos = end
+		default:
+			text := ""
+			for _, punct := range []string{"!=", "<=", ">=", "=", "<", ">", "(", ")", "{", "}", "[", "]", ".", ":", "*"} {
+				if strings.HasPrefix(s[pos:], punct) {
+					text = punct
+					break
+				}
+			}
+			if len(text) == 0 {
+				return nil, syntaxError(s, start, "unexpected '%c'", r)
+			}
+			out = append(out, token{kind: tokenPunct, pos: start, end: start + len(text), text: text})
+			pos += len(text)
+		}
+	}
+}
+
+//endregion
+
+//region Parser
+
+type parser struct {
+	input  string
+	tokens []token
+	next   int
+}
+
+func (p *parser) errorAt(pos int, format string, args ...interface{}) error {
+	return syntaxError(p.input, pos, format, args...)
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEnd {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(word string) bool {
+	return p.peek().is(tokenIdentifier, word)
+}
+
+func (p *parser) expectPunct(punct string) error {
+	if t := p.peek(); !t.is(tokenPunct, punct) {
+		return p.errorAt(t.pos, "expected '%s', found %s", punct, t.describe())
+	}
+	p.advance()
+	return nil
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	operands := []Filter{first}
+	for p.isKeyword("or") {
+		p.advance()
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, next)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return &Or{Operands: operands}, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	operands := []Filter{first}
+	for p.isKeyword("and") {
+		p.advance()
+		next, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, next)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return &And{Operands: operands}, nil
+}
+
+func (p *parser) parseUnary() (Filter, error) {
+	if p.isKeyword("not") {
+		p.advance()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Filter, error) {
+	t := p.peek()
+	switch {
+	case t.is(tokenPunct, "("):
+		p.advance()
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return f, nil
+	case t.is(tokenIdentifier, "set"):
+		p.advance()
+		setId, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		f, err := p.parseScopeBody()
+		if err != nil {
+			return nil, err
+		}
+		return &SetScope{SetId: setId, Filter: f}, nil
+	case t.is(tokenIdentifier, "manifest"):
+		p.advance()
+		id := p.advance()
+		if id.kind != tokenString {
+			return nil, p.errorAt(id.pos, "expected a manifest id string, found %s", id.describe())
+		}
+		f, err := p.parseScopeBody()
+		if err != nil {
+			return nil, err
+		}
+		return &ManifestScope{ManifestId: id.text, Filter: f}, nil
+	case t.is(tokenIdentifier, "exists"):
+		p.advance()
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		return &Exists{Path: path}, nil
+	}
+
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	opToken := p.advance()
+	op := Operator(opToken.text)
+	switch {
+	case opToken.kind != tokenPunct:
+		return nil, p.errorAt(opToken.pos, "expected an operator, found %s", opToken.describe())
+	case op == OperatorEqual, op == OperatorNotEqual, op.IsOrdering():
+	default:
+		return nil, p.errorAt(opToken.pos, "expected an operator, found %s", opToken.describe())
+	}
+	valueToken := p.peek()
+	value, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	if op.IsOrdering() {
+		if _, isNumber := value.(float64); !isNumber {
+			if _, isString := value.(string); !isString {
+				return nil, p.errorAt(valueToken.pos, "operator %s needs a number or a string, found %s", op, valueToken.describe())
+			}
+		}
+	}
+	return &Compare{Path: path, Operator: op, Value: value}, nil
+}
+
+func (p *parser) parseScopeBody() (Filter, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct("}"); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *parser) parseName() (string, error) {
+	t := p.advance()
+	switch {
+	case t.kind == tokenString:
+		return t.text, nil
+	case t.kind == tokenIdentifier && !keywords[t.text]:
+		return t.text, nil
+	}
+	return "", p.errorAt(t.pos, "expected a name, found %s", t.describe())
+}
+
+//parsePath parses the set id, if any, and the attribute path, which is the text of the tokens that follow
+//without space and are parsed as an attributes.Path.
+func (p *parser) parsePath() (Path, error) {
+	out := Path{}
+	if t := p.peek(); t.kind != tokenEnd && p.tokens[p.next+1].is(tokenPunct, ":") {
+		setId, err := p.parseName()
+		if err != nil {
+			return Path{}, err
+		}
+		p.advance()
+		out.SetId = setId
+	}
+	first := p.peek()
+	if !first.is(tokenPunct, "[") && (first.kind != tokenIdentifier || keywords[first.text]) {
+		return Path{}, p.errorAt(first.pos, "expected a path, found %s", first.describe())
+	}
+	end := first.pos
+	for t := p.peek(); t.pos == end && t.kind != tokenEnd && (t.kind != tokenPunct || strings.Contains(".[]*", t.text)); t = p.peek() {
+		end = t.end
+		p.advance()
+	}
+	attribute, err := attributes.ParsePath(p.input[first.pos:end])
+	if err != nil {
+		pos := first.pos
+		cErr := aldberr.CanvigaError{}
+		if errors.As(err, &cErr) {
+			if at, isInt := cErr.Details()["position"].(int); isInt {
+				pos += at
+			}
+		}
+		return Path{}, p.errorAt(pos, "invalid attribute path %q", p.input[first.pos:end])
+	}
+	out.Attribute = attribute
+	return out, nil
+}
+
+func (p *parser) parseLiteral() (interface{}, error) {
+	t := p.advance()
+	switch {
+	case t.kind == tokenString:
+		return t.text, nil
+	case t.kind == tokenNumber:
+		f, _ := strconv.ParseFloat(t.text, 64)
+		return f, nil
+	case t.is(tokenIdentifier, "true"):
+		return true, nil
+	case t.is(tokenIdentifier, "false"):
+		return false, nil
+	case t.is(tokenIdentifier, "null"):
+		return nil, nil
+	}
+	return nil, p.errorAt(t.pos, "expected a string, number, true, false or null, found %s", t.describe())
+}
+
+//endregion
+
+//region Printing
+
+func isIdentifier(s string) bool {
+	if len(s) == 0 || keywords[s] {
+		return false
+	}
+	for i, r := range s {
+		if r == '_' || unicode.IsLetter(r) || (i > 0 && (r == '-' || unicode.IsDigit(r))) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func formatName(s string) string {
+	if isIdentifier(s) {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+func formatLiteral(v interface{}) string {
+	if n, isNumber := toNumber(v); isNumber {
+		return strconv.FormatFloat(n, 'g', -1, 64)
+	}
+	switch l := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(l)
+	case string:
+		return strconv.Quote(l)
+	}
+	return strconv.Quote(fmt.Sprint(v))
+}
+
+//endregion
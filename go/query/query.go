@@ -0,0 +1,380 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/attributes"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+//Filter is a predicate over the attribute sets of an activity, see Parse for its string representation.
+type Filter interface {
+	//MatchSets returns whether the filter holds for the given attribute sets, keyed by set id.
+	MatchSets(sets map[string]attributes.AttributeSet) bool
+	String() string
+}
+
+//Matches returns whether the attribute sets of a satisfy f. A nil Filter matches every activity.
+func Matches(f Filter, a activity.Activity) bool {
+	if f == nil {
+		return true
+	}
+	return f.MatchSets(a.AttributeSets)
+}
+
+//Select returns the activities that satisfy f, in their original order.
+func Select(f Filter, activities []activity.Activity) []activity.Activity {
+	out := []activity.Activity{}
+	for _, a := range activities {
+		if Matches(f, a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+//List returns the latest version of every activity in s that satisfies f, sorted by id. It works with any
+//store.Store, by filtering the result of List.
+func List(ctx context.Context, s store.Store, f Filter) ([]activity.Activity, error) {
+	res, err := s.List(ctx, store.ListRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return Select(f, res.Activities), nil
+}
+
+//region Logic
+
+//And holds if all of its Operands hold.
+type And struct {
+	Operands []Filter
+}
+
+func (f *And) MatchSets(sets map[string]attributes.AttributeSet) bool {
+	for _, o := range f.Operands {
+		if !o.MatchSets(sets) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *And) String() string {
+	parts := make([]string, len(f.Operands))
+	for i, o := range f.Operands {
+		if _, isOr := o.(*Or); isOr {
+			parts[i] = "(" + o.String() + ")"
+		} else {
+			parts[i] = o.String()
+		}
+	}
+	return strings.Join(parts, " and ")
+}
+
+//Or holds if any of its Operands holds.
+type Or struct {
+	Operands []Filter
+}
+
+func (f *Or) MatchSets(sets map[string]attributes.AttributeSet) bool {
+	for _, o := range f.Operands {
+		if o.MatchSets(sets) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Or) String() string {
+	parts := make([]string, len(f.Operands))
+	for i, o := range f.Operands {
+		parts[i] = o.String()
+	}
+	return strings.Join(parts, " or ")
+}
+
+//Not holds if its Operand doesn't.
+type Not struct {
+	Operand Filter
+}
+
+func (f *Not) MatchSets(sets map[string]attributes.AttributeSet) bool {
+	return !f.Operand.MatchSets(sets)
+}
+
+func (f *Not) String() string {
+	switch f.Operand.(type) {
+	case *And, *Or:
+		return "not (" + f.Operand.String() + ")"
+	}
+	return "not " + f.Operand.String()
+}
+
+//endregion
+
+//region Scopes
+
+//SetScope holds if its Filter holds for the attribute set with id SetId.
+type SetScope struct {
+	SetId  string
+	Filter Filter
+}
+
+func (f *SetScope) MatchSets(sets map[string]attributes.AttributeSet) bool {
+	as, found := sets[f.SetId]
+	if !found {
+		return false
+	}
+	return f.Filter.MatchSets(map[string]attributes.AttributeSet{f.SetId: as})
+}
+
+func (f *SetScope) String() string {
+	return "set " + formatName(f.SetId) + " { " + f.Filter.String() + " }"
+}
+
+//ManifestScope holds if its Filter holds for one of the attribute sets with manifest ManifestId. All paths in
+//Filter are evaluated against the same attribute set.
+type ManifestScope struct {
+	ManifestId string
+	Filter     Filter
+}
+
+func (f *ManifestScope) MatchSets(sets map[string]attributes.AttributeSet) bool {
+	for _, setId := range sortedSetIds(sets) {
+		as := sets[setId]
+		if as.Manifest == nil || as.Manifest.Id == nil || as.Manifest.Id.String() != f.ManifestId {
+			continue
+		}
+		if f.Filter.MatchSets(map[string]attributes.AttributeSet{setId: as}) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ManifestScope) String() string {
+	return "manifest " + strconv.Quote(f.ManifestId) + " { " + f.Filter.String() + " }"
+}
+
+//endregion
+
+//region Predicates
+
+//Exists holds if Path refers to an attribute, even if its value is null.
+type Exists struct {
+	Path Path
+}
+
+func (f *Exists) MatchSets(sets map[string]attributes.AttributeSet) bool {
+	return len(f.Path.Resolve(sets)) > 0
+}
+
+func (f *Exists) String() string {
+	return "exists " + f.Path.String()
+}
+
+type Operator string
+
+const (
+	OperatorEqual          Operator = "="
+	OperatorNotEqual       Operator = "!="
+	OperatorLess           Operator = "<"
+	OperatorLessOrEqual    Operator = "<="
+	OperatorGreater        Operator = ">"
+	OperatorGreaterOrEqual Operator = ">="
+)
+
+//IsOrdering returns whether the operator compares the order of values, which is only defined for numbers
+//and strings.
+func (o Operator) IsOrdering() bool {
+	switch o {
+	case OperatorLess, OperatorLessOrEqual, OperatorGreater, OperatorGreaterOrEqual:
+		return true
+	}
+	return false
+}
+
+//Compare holds if Path refers to an attribute of which the value compares to Value as specified by Operator.
+//
+//Comparisons are typed: Value is nil, a bool, a float64 or a string, and only values of the same type are
+//equal or ordered (numbers of any Go numeric type compare as float64). So "!=" holds for values of another
+//type, while the ordering operators don't. Strings are ordered byte-wise, unless both are RFC 3339
+//timestamps: those are compared as instants, so that timestamps with other offsets or fractions of seconds
+//are ordered chronologically. A missing attribute satisfies no comparison, not even "!=".
+type Compare struct {
+	Path     Path
+	Operator Operator
+	Value    interface{}
+}
+
+func (f *Compare) MatchSets(sets map[string]attributes.AttributeSet) bool {
+	for _, v := range f.Path.Resolve(sets) {
+		if compareValues(v, f.Operator, f.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Compare) String() string {
+	return f.Path.String() + " " + string(f.Operator) + " " + formatLiteral(f.Value)
+}
+
+//parseTimes parses a and b as RFC 3339 timestamps, if both are.
+func parseTimes(a, b string) (time.Time, time.Time, bool) {
+	at, err := time.Parse(time.RFC3339Nano, a)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	bt, err := time.Parse(time.RFC3339Nano, b)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return at, bt, true
+}
+
+func compareValues(actual interface{}, op Operator, expected interface{}) bool {
+	if n, isNumber := toNumber(actual); isNumber {
+		actual = n
+	}
+	if n, isNumber := toNumber(expected); isNumber {
+		expected = n
+	}
+	switch e := expected.(type) {
+	case float64:
+		a, isNumber := actual.(float64)
+		if !isNumber {
+			return op == OperatorNotEqual
+		}
+		return compareOrdered(a < e, a == e, op)
+	case string:
+		a, isString := actual.(string)
+		if !isString {
+			return op == OperatorNotEqual
+		}
+		if at, et, areTimes := parseTimes(a, e); areTimes {
+			return compareOrdered(at.Before(et), at.Equal(et), op)
+		}
+		return compareOrdered(a < e, a == e, op)
+	case bool:
+		a, isBool := actual.(bool)
+		if !isBool || op.IsOrdering() {
+			return op == OperatorNotEqual
+		}
+		return (a == e) == (op == OperatorEqual)
+	case nil:
+		if op.IsOrdering() {
+			return false
+		}
+		return (actual == nil) == (op == OperatorEqual)
+	}
+	return false
+}
+
+func compareOrdered(less, equal bool, op Operator) bool {
+	switch op {
+	case OperatorEqual:
+		return equal
+	case OperatorNotEqual:
+		return !equal
+	case OperatorLess:
+		return less
+	case OperatorLessOrEqual:
+		return less || equal
+	case OperatorGreater:
+		return !less && !equal
+	case OperatorGreaterOrEqual:
+		return !less
+	}
+	return false
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+//endregion
+
+//region Path
+
+//Path refers to attributes of attribute sets.
+type Path struct {
+	//SetId is the id of the attribute set, or empty to refer to the attributes of every attribute set.
+	SetId string
+	//Attribute is the path of the attributes within the set. An attributes.AnyIndexSegment refers to every
+	//element of an array.
+	Attribute attributes.Path
+}
+
+//Resolve returns the values that p refers to in the given attribute sets, ordered by set id.
+func (p Path) Resolve(sets map[string]attributes.AttributeSet) []interface{} {
+	out := []interface{}{}
+	for _, setId := range sortedSetIds(sets) {
+		if len(p.SetId) > 0 && setId != p.SetId {
+			continue
+		}
+		for _, v := range sets[setId].GetAll(p.Attribute) {
+			out = append(out, v.CastAny())
+		}
+	}
+	return out
+}
+
+//String writes the attribute path as an attributes.Path, but with a first key that is a keyword quoted, so
+//that it parses again.
+func (p Path) String() string {
+	sb := strings.Builder{}
+	if len(p.SetId) > 0 {
+		sb.WriteString(formatName(p.SetId))
+		sb.WriteString(":")
+	}
+	head, tail, ok := p.Attribute.HeadTail()
+	if _, isIndex := head.Index(); !ok || isIndex || head.IsAnyIndex() || !keywords[head.Key()] {
+		sb.WriteString(p.Attribute.String())
+		return sb.String()
+	}
+	sb.WriteString("[" + strconv.Quote(head.Key()) + "]")
+	if rest := tail.String(); len(rest) > 0 && rest[0] != '[' {
+		sb.WriteString(".")
+	}
+	sb.WriteString(tail.String())
+	return sb.String()
+}
+
+func sortedSetIds(sets map[string]attributes.AttributeSet) []string {
+	out := make([]string, 0, len(sets))
+	for id := range sets {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out
+}
+
+//endregion
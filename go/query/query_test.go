@@ -0,0 +1,174 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/attributes"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+func TestFilter(t *testing.T) {
+	project := projoActivity(map[string]interface{}{
+		"totalBudget":   map[string]interface{}{"currency": "EUR", "amount": float64(456000)},
+		"priorityClass": "normal",
+		"tags":          []interface{}{"internal", "rnd"},
+		"archived":      false,
+		"owner":         nil,
+		"start":         "2020-03-01T00:00:00Z",
+	})
+	project.AttributeSets["notes"] = attributeSet("", map[string]interface{}{"amount": 3, "text": "hi"})
+
+	cases := []struct {
+		filter  string
+		printed string
+		matches bool
+	}{
+		{`projo-attrs:priorityClass = "normal"`, ``, true},
+		{`projo-attrs:priorityClass = "normal" and totalBudget.amount > 100000`, ``, true},
+		{`projo-attrs:priorityClass = "high" or totalBudget.amount > 100000`, ``, true},
+		{`priorityClass != "normal"`, ``, false},
+		{`priorityClass != 1`, ``, true},
+		{`missing != 1`, ``, false},
+		{`not missing = 1`, ``, true},
+		{`totalBudget.amount >= 456000`, ``, true},
+		{`totalBudget.amount < 456000`, ``, false},
+		{`totalBudget.amount <= 4.56e5`, `totalBudget.amount <= 456000`, true},
+		{`totalBudget.amount > "100"`, ``, false},
+		{`totalBudget.currency >= "EUR"`, ``, true},
+		{`start < "2021-01-01"`, ``, true},
+		{`start = "2020-03-01T01:00:00+01:00"`, ``, true},
+		{`start > "2020-02-29T23:30:00-01:00"`, ``, false},
+		{`start < "2020-03-01T00:00:00.5Z"`, ``, true},
+		{`notes:amount = 3`, ``, true},
+		{`amount = 3`, ``, true},
+		{`projo-attrs:amount = 3`, ``, false},
+		{`archived = false`, ``, true},
+		{`archived != true`, ``, true},
+		{`owner = null`, ``, true},
+		{`exists owner`, ``, true},
+		{`exists projo-attrs:totalBudget.amount`, ``, true},
+		{`exists notes:totalBudget`, ``, false},
+		{`tags[0] = "internal"`, ``, true},
+		{`tags[-1] = "rnd"`, ``, true},
+		{`tags[2] = "rnd"`, ``, false},
+		{`tags[*] = "rnd"`, ``, true},
+		{`tags[*] = "external"`, ``, false},
+		{`set notes { amount = 3 and text = "hi" }`, ``, true},
+		{`set notes { amount = 3 and priorityClass = "normal" }`, ``, false},
+		{`manifest "https://projo.com/schemas/project" { priorityClass = "normal" and totalBudget.amount > 100000 }`, ``, true},
+		{`manifest "https://projo.com/schemas/project" { amount = 3 }`, ``, false},
+		{`manifest "https://projo.com/schemas/other" { exists priorityClass }`, ``, false},
+		{`not (archived = true or owner != null)`, ``, true},
+		{`(archived = true or owner = null) and not tags[0] = "x"`, ``, true},
+		{`archived = true or owner = null and tags[0] = "x"`, ``, false},
+		{`["and"] = 1 or ["odd key"].x = "\"q\""`, ``, false},
+		{`"projo-attrs":tags[-1] = "rnd"`, `projo-attrs:tags[-1] = "rnd"`, true},
+		{`totalBudget["amount"] > 100000`, `totalBudget.amount > 100000`, true},
+	}
+	for _, c := range cases {
+		f, err := Parse(c.filter)
+		if err != nil {
+			t.Errorf("%s: %v", c.filter, err)
+			continue
+		}
+		printed := c.printed
+		if len(printed) == 0 {
+			printed = c.filter
+		}
+		if f.String() != printed {
+			t.Errorf("expected %s to print as %s, got %s", c.filter, printed, f.String())
+		}
+		if reparsed, err := Parse(f.String()); err != nil || reparsed.String() != f.String() {
+			t.Errorf("%s doesn't round trip: %v", f.String(), err)
+		}
+		if Matches(f, project) != c.matches {
+			t.Errorf("%s: expected match %v", c.filter, c.matches)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		input    string
+		position int
+	}{
+		{``, 0},
+		{`a`, 1},
+		{`a = `, 4},
+		{`a == 1`, 3},
+		{`a = b`, 4},
+		{`a < true`, 4},
+		{`a = 1 and`, 9},
+		{`(a = 1`, 6},
+		{`a = "x`, 4},
+		{`a = 1.2.3`, 4},
+		{`a[x] = 1`, 1},
+		{`a[1.5] = 1`, 3},
+		{`a .b = 1`, 2},
+		{`a:"b" = 1`, 2},
+		{`set { a = 1 }`, 4},
+		{`manifest x { a = 1 }`, 9},
+		{`set s a = 1`, 6},
+		{`a = 1 b = 2`, 6},
+		{`a # 1`, 2},
+		{`and = 1`, 0},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.input)
+		cErr := aldberr.CanvigaError{}
+		if !errors.As(err, &cErr) || cErr.Code() != ErrorCodeInvalidSyntax {
+			t.Errorf("%q: expected a syntax error, got %v", c.input, err)
+			continue
+		}
+		if cErr.Details()["position"] != c.position {
+			t.Errorf("%q: expected error at position %d, got %v (%s)", c.input, c.position, cErr.Details()["position"], cErr.Message())
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	for id, amount := range map[string]float64{"https://aldb.test/activities/a": 123000, "https://aldb.test/activities/b": 456000} {
+		a := projoActivity(map[string]interface{}{"totalBudget": map[string]interface{}{"amount": amount}})
+		a.Id, _ = url.Parse(id)
+		if _, err := s.Create(ctx, store.CreateRequest{ToCreate: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, err := List(ctx, s, MustParse(`totalBudget.amount > 200000`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Id.String() != "https://aldb.test/activities/b" {
+		t.Errorf("unexpected activities %v", found)
+	}
+	all, err := List(ctx, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Errorf("expected all activities for a nil filter, got %d", len(all))
+	}
+}
+
+func projoActivity(attrs map[string]interface{}) activity.Activity {
+	a := activity.Activity{}
+	a.AttributeSets = map[string]attributes.AttributeSet{"projo-attrs": attributeSet("https://projo.com/schemas/project", attrs)}
+	return a
+}
+
+func attributeSet(manifestId string, attrs map[string]interface{}) attributes.AttributeSet {
+	out := attributes.AttributeSet{Attributes: attrs}
+	if len(manifestId) > 0 {
+		id, _ := url.Parse(manifestId)
+		out.Manifest = &attributes.Manifest{ManifestRef: ref.ManifestRef{Id: id}}
+	}
+	return out
+}
@@ -1,19 +1,109 @@
 package ref
 
-import "net/url"
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
 
 type ActivityRef struct {
-	Id      *url.URL
+	Id *url.URL
+	//Version identifies a version of the activity amongst its other versions. Versions are ordered by plain
+	//byte-wise (lexicographic) comparison of their identifiers: every new version of an activity must have
+	//an identifier that is greater than the one of the previous version. An empty Version refers to the
+	//latest version.
 	Version string
 }
 
-//func (r ActivityRef) MarshalJSON() ([]byte, error) {
-//	if r.Id == nil {
-//		return []byte(""), nil
-//	}
-//	s := r.Id.String()
-//	if len(r.Version) > 0 {
-//		s = s + "/" + r.Version
-//	}
-//	return []byte(s), nil
-//}
+var versionClock = struct {
+	mu   sync.Mutex
+	last uint64
+}{}
+
+//NextVersion generates a version identifier that is greater than after and than every identifier that was
+//generated before by this process. Generated identifiers are 16 lowercase hexadecimal digits encoding the
+//current time in nanoseconds, so they also sort well across processes with synchronised clocks. If after
+//is not smaller than that (e.g. because it wasn't generated), "0" is appended to after instead.
+func NextVersion(after string) string {
+	versionClock.mu.Lock()
+	now := uint64(time.Now().UnixNano())
+	if now <= versionClock.last {
+		now = versionClock.last + 1
+	}
+	versionClock.last = now
+	versionClock.mu.Unlock()
+
+	v := fmt.Sprintf("%016x", now)
+	if v <= after {
+		return after + "0"
+	}
+	return v
+}
+
+//region Ref
+
+//ActivityPattern is the pattern of the names of an ActivityRef.
+var ActivityPattern = MustPattern("activities/{activityId}[/versions/{version}]")
+
+func (r ActivityRef) ToName() string {
+	return PatternName(&r)
+}
+
+func (r *ActivityRef) FromName(name string) error {
+	return FromPatternName(r, r == nil, name)
+}
+
+//IsComplete checks that the Id is set, the Version is optional.
+func (r ActivityRef) IsComplete() bool {
+	return IsPatternComplete(&r)
+}
+
+func (r ActivityRef) MarshalText() ([]byte, error) {
+	return MarshalName(&r, r == ActivityRef{})
+}
+
+func (r *ActivityRef) UnmarshalText(text []byte) error {
+	return r.FromName(string(text))
+}
+
+func (r ActivityRef) Pattern() Pattern {
+	return ActivityPattern
+}
+
+func (r ActivityRef) IdMap() map[string]string {
+	return map[string]string{"activityId": uriString(r.Id), "version": r.Version}
+}
+
+func (r *ActivityRef) FromIdMap(ids map[string]string) error {
+	id, err := parseURI(ids["activityId"], "activity")
+	if err != nil {
+		return err
+	}
+	*r = ActivityRef{Id: id, Version: ids["version"]}
+	return nil
+}
+
+//uriString returns the string of u, or "" if u is nil.
+func uriString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+//parseURI parses the id of a resource of the given kind, returning nil for "".
+func parseURI(id, kind string) (*url.URL, error) {
+	if len(id) == 0 {
+		return nil, nil
+	}
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalidName, kind+" id in name is not a URI", map[string]interface{}{"id": id})
+	}
+	return u, nil
+}
+
+//endregion
@@ -1,6 +1,8 @@
 package ref
 
-import "net/url"
+import (
+	"net/url"
+)
 
 type AttributeSetRef struct {
 	ActivityRef
@@ -9,4 +11,94 @@ type AttributeSetRef struct {
 
 type ManifestRef struct {
 	Id *url.URL
+	//Version identifies a version of the manifest, ordered like the versions of an ActivityRef. An empty
+	//Version refers to the latest version.
+	Version string
 }
+
+//region Ref
+
+//AttributeSetPattern is the pattern of the names of an AttributeSetRef.
+var AttributeSetPattern = ActivityPattern.Child("attribute-sets/{attributeSetId}")
+
+//ManifestPattern is the pattern of the names of a ManifestRef.
+var ManifestPattern = MustPattern("manifests/{manifestId}[/versions/{version}]")
+
+func (r AttributeSetRef) ToName() string {
+	return PatternName(&r)
+}
+
+func (r *AttributeSetRef) FromName(name string) error {
+	return FromPatternName(r, r == nil, name)
+}
+
+func (r AttributeSetRef) IsComplete() bool {
+	return IsPatternComplete(&r)
+}
+
+func (r AttributeSetRef) MarshalText() ([]byte, error) {
+	return MarshalName(&r, r == AttributeSetRef{})
+}
+
+func (r *AttributeSetRef) UnmarshalText(text []byte) error {
+	return r.FromName(string(text))
+}
+
+func (r AttributeSetRef) Pattern() Pattern {
+	return AttributeSetPattern
+}
+
+func (r AttributeSetRef) IdMap() map[string]string {
+	out := r.ActivityRef.IdMap()
+	out["attributeSetId"] = r.AttributeSetId
+	return out
+}
+
+func (r *AttributeSetRef) FromIdMap(ids map[string]string) error {
+	ar := ActivityRef{}
+	if err := ar.FromIdMap(ids); err != nil {
+		return err
+	}
+	*r = AttributeSetRef{ActivityRef: ar, AttributeSetId: ids["attributeSetId"]}
+	return nil
+}
+
+func (r ManifestRef) ToName() string {
+	return PatternName(&r)
+}
+
+func (r *ManifestRef) FromName(name string) error {
+	return FromPatternName(r, r == nil, name)
+}
+
+//IsComplete checks that the Id is set, the Version is optional.
+func (r ManifestRef) IsComplete() bool {
+	return IsPatternComplete(&r)
+}
+
+func (r ManifestRef) MarshalText() ([]byte, error) {
+	return MarshalName(&r, r == ManifestRef{})
+}
+
+func (r *ManifestRef) UnmarshalText(text []byte) error {
+	return r.FromName(string(text))
+}
+
+func (r ManifestRef) Pattern() Pattern {
+	return ManifestPattern
+}
+
+func (r ManifestRef) IdMap() map[string]string {
+	return map[string]string{"manifestId": uriString(r.Id), "version": r.Version}
+}
+
+func (r *ManifestRef) FromIdMap(ids map[string]string) error {
+	id, err := parseURI(ids["manifestId"], "manifest")
+	if err != nil {
+		return err
+	}
+	*r = ManifestRef{Id: id, Version: ids["version"]}
+	return nil
+}
+
+//endregion
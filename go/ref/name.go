@@ -0,0 +1,77 @@
+package ref
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//NameSegment is a segment of a name: the name of a collection and the (unescaped) id of a resource in it.
+type NameSegment struct {
+	Collection string
+	Id         string
+}
+
+//FormatName joins the segments into a name, escaping the ids.
+func FormatName(segments ...NameSegment) string {
+	sb := strings.Builder{}
+	for i, s := range segments {
+		if i > 0 {
+			sb.WriteByte('/')
+		}
+		sb.WriteString(s.Collection)
+		sb.WriteByte('/')
+		sb.WriteString(url.PathEscape(s.Id))
+	}
+	return sb.String()
+}
+
+//ParseName splits a name into its segments, unescaping the ids. Collection names consist of lowercase
+//letters, digits and dashes, and ids must not be empty.
+func ParseName(name string) ([]NameSegment, error) {
+	parts := strings.Split(name, "/")
+	if len(parts)%2 != 0 {
+		return nil, invalidName(name, "name must consist of collections each followed by an id")
+	}
+	out := make([]NameSegment, 0, len(parts)/2)
+	for i := 0; i < len(parts); i += 2 {
+		if !isCollection(parts[i]) {
+			return nil, invalidName(name, "invalid collection in name").Det("collection", parts[i])
+		}
+		id, err := url.PathUnescape(parts[i+1])
+		if err != nil {
+			return nil, aldberr.Wrap(err, ErrorCodeInvalidName, "invalid escaping of id in name", map[string]interface{}{"name": name})
+		}
+		if len(id) == 0 {
+			return nil, invalidName(name, "empty id in name").Det("collection", parts[i])
+		}
+		out = append(out, NameSegment{Collection: parts[i], Id: id})
+	}
+	return out, nil
+}
+
+//MarshalName returns the name of r as text, r being the value of which isZero tells whether it is the zero
+//value. The zero value is written as "", other incomplete refs cannot be written.
+func MarshalName(r Ref, isZero bool) ([]byte, error) {
+	if !r.IsComplete() && !isZero {
+		return nil, aldberr.New(ErrorCodeInvalidName, "cannot marshal an incomplete ref", nil)
+	}
+	return []byte(r.ToName()), nil
+}
+
+func isCollection(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func invalidName(name, msg string) aldberr.CanvigaError {
+	return aldberr.New(ErrorCodeInvalidName, msg, map[string]interface{}{"name": name})
+}
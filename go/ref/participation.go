@@ -4,3 +4,49 @@ type ParticipationRef struct {
 	ActivityRef
 	ParticipationId string
 }
+
+//region Ref
+
+//ParticipationPattern is the pattern of the names of a ParticipationRef.
+var ParticipationPattern = ActivityPattern.Child("participations/{participationId}")
+
+func (r ParticipationRef) ToName() string {
+	return PatternName(&r)
+}
+
+func (r *ParticipationRef) FromName(name string) error {
+	return FromPatternName(r, r == nil, name)
+}
+
+func (r ParticipationRef) IsComplete() bool {
+	return IsPatternComplete(&r)
+}
+
+func (r ParticipationRef) MarshalText() ([]byte, error) {
+	return MarshalName(&r, r == ParticipationRef{})
+}
+
+func (r *ParticipationRef) UnmarshalText(text []byte) error {
+	return r.FromName(string(text))
+}
+
+func (r ParticipationRef) Pattern() Pattern {
+	return ParticipationPattern
+}
+
+func (r ParticipationRef) IdMap() map[string]string {
+	out := r.ActivityRef.IdMap()
+	out["participationId"] = r.ParticipationId
+	return out
+}
+
+func (r *ParticipationRef) FromIdMap(ids map[string]string) error {
+	ar := ActivityRef{}
+	if err := ar.FromIdMap(ids); err != nil {
+		return err
+	}
+	*r = ParticipationRef{ActivityRef: ar, ParticipationId: ids["participationId"]}
+	return nil
+}
+
+//endregion
@@ -0,0 +1,318 @@
+package ref
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	ErrorCodeInvalidPattern = "ref-invalid-pattern"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidPattern, Status: http.StatusInternalServerError, Message: errcode.En("invalid reference pattern")},
+	)
+}
+
+//Pattern describes the names of a reference type, e.g. "activities/{activityId}[/versions/{version}]". Like a
+//name, a pattern is a sequence of segments, each being the name of a collection followed by a {field}
+//holding the id of a resource in it. Segments in square brackets are optional: they are left out of a name
+//if any of their fields is empty. Optional parts cannot be nested.
+type Pattern struct {
+	source   string
+	segments []patternSegment
+	groups   int
+}
+
+type patternSegment struct {
+	collection string
+	field      string
+	//group is the number (starting from 1) of the optional part that contains the segment, or 0 if the
+	//segment is required.
+	group int
+}
+
+//NewPattern parses a pattern, see Pattern for the syntax. Field names must be unique within a pattern.
+func NewPattern(pattern string) (Pattern, error) {
+	out := Pattern{source: pattern}
+	tokens := strings.Split(pattern, "/")
+	if len(tokens)%2 != 0 {
+		return Pattern{}, invalidPattern(pattern, "pattern must consist of collections each followed by a field")
+	}
+	group, start := 0, 0
+	openGroup := func(at int) error {
+		if group != 0 {
+			return invalidPattern(pattern, "optional parts cannot be nested")
+		}
+		if at%2 != 0 {
+			return invalidPattern(pattern, "optional part must start with a collection")
+		}
+		out.groups++
+		group, start = out.groups, at
+		return nil
+	}
+	closeGroup := func(last int) error {
+		if group == 0 {
+			return invalidPattern(pattern, "unbalanced ']' in pattern")
+		}
+		if last%2 == 0 || last < start {
+			return invalidPattern(pattern, "optional part must end with a field")
+		}
+		group = 0
+		return nil
+	}
+	fields := map[string]bool{}
+	for i, token := range tokens {
+		for len(token) > 0 && (token[0] == '[' || token[0] == ']') {
+			var err error
+			if token[0] == '[' {
+				err = openGroup(i)
+			} else {
+				err = closeGroup(i - 1)
+			}
+			if err != nil {
+				return Pattern{}, err
+			}
+			token = token[1:]
+		}
+		trailing := ""
+		for len(token) > 0 && (token[len(token)-1] == '[' || token[len(token)-1] == ']') {
+			trailing = token[len(token)-1:] + trailing
+			token = token[:len(token)-1]
+		}
+
+		if i%2 == 0 {
+			if !isCollection(token) {
+				return Pattern{}, invalidPattern(pattern, "invalid collection in pattern").Det("collection", token)
+			}
+			out.segments = append(out.segments, patternSegment{collection: token, group: group})
+		} else {
+			field, isField := patternField(token)
+			if !isField {
+				return Pattern{}, invalidPattern(pattern, "expected a {field} after a collection").Det("segment", token)
+			}
+			if fields[field] {
+				return Pattern{}, invalidPattern(pattern, "duplicate field in pattern").Det("field", field)
+			}
+			fields[field] = true
+			last := &out.segments[len(out.segments)-1]
+			if last.group != group {
+				return Pattern{}, invalidPattern(pattern, "optional part must contain whole segments").Det("field", field)
+			}
+			last.field = field
+		}
+
+		for _, c := range trailing {
+			var err error
+			if c == '[' {
+				err = openGroup(i + 1)
+			} else {
+				err = closeGroup(i)
+			}
+			if err != nil {
+				return Pattern{}, err
+			}
+		}
+	}
+	if group != 0 {
+		return Pattern{}, invalidPattern(pattern, "unclosed '[' in pattern")
+	}
+	return out, nil
+}
+
+//MustPattern is NewPattern for patterns that are known to be valid, e.g. in package variables. It panics if
+//the pattern is invalid.
+func MustPattern(pattern string) Pattern {
+	p, err := NewPattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+//Child returns the pattern of the resources in a collection of the resources of p, e.g. the child
+//"attribute-sets/{attributeSetId}" of the pattern of activities. It panics if the result is invalid, like
+//MustPattern.
+func (p Pattern) Child(pattern string) Pattern {
+	return MustPattern(p.source + "/" + pattern)
+}
+
+func (p Pattern) String() string {
+	return p.source
+}
+
+//Fields returns the names of the fields of p, in order.
+func (p Pattern) Fields() []string {
+	out := make([]string, 0, len(p.segments))
+	for _, s := range p.segments {
+		out = append(out, s.field)
+	}
+	return out
+}
+
+//IsComplete checks that ids has a non-empty value for every required field of p.
+func (p Pattern) IsComplete(ids map[string]string) bool {
+	for _, s := range p.segments {
+		if s.group == 0 && len(ids[s.field]) == 0 {
+			return false
+		}
+	}
+	return len(p.segments) > 0
+}
+
+//Format returns the name with the given ids (by field name), leaving out the optional parts of which an id
+//is missing. It returns "" if a required id is missing.
+func (p Pattern) Format(ids map[string]string) string {
+	if !p.IsComplete(ids) {
+		return ""
+	}
+	included := map[int]bool{}
+	for g := 1; g <= p.groups; g++ {
+		included[g] = true
+	}
+	for _, s := range p.segments {
+		if s.group != 0 && len(ids[s.field]) == 0 {
+			included[s.group] = false
+		}
+	}
+	segments := []NameSegment{}
+	for _, s := range p.segments {
+		if s.group == 0 || included[s.group] {
+			segments = append(segments, NameSegment{Collection: s.collection, Id: ids[s.field]})
+		}
+	}
+	return FormatName(segments...)
+}
+
+//Match parses a name that follows p into its ids by field name. The fields of optional parts that the name
+//leaves out are set to "". An error with code ErrorCodeInvalidName is returned if the name doesn't follow
+//p.
+func (p Pattern) Match(name string) (map[string]string, error) {
+	segments, err := ParseName(name)
+	if err != nil {
+		return nil, err
+	}
+	//the form with all optional parts comes last, and is tried first
+	forms := p.forms()
+	for i := len(forms) - 1; i >= 0; i-- {
+		if ids, matches := forms[i].match(segments); matches {
+			for _, field := range p.Fields() {
+				if _, found := ids[field]; !found {
+					ids[field] = ""
+				}
+			}
+			return ids, nil
+		}
+	}
+	return nil, invalidName(name, "name doesn't follow the pattern").Det("pattern", p.source)
+}
+
+//Routes returns the HTTP route patterns for the names of p, one for every combination of optional parts,
+//e.g. "/activities/{activityId}" and "/activities/{activityId}/versions/{version}", in the syntax of the
+//path templates of api/api.json. Every {field} matches a single path segment, of which the id is the
+//path-unescaped value.
+func (p Pattern) Routes() []string {
+	out := []string{}
+	for _, f := range p.forms() {
+		sb := strings.Builder{}
+		for _, s := range f {
+			sb.WriteString("/" + s.collection + "/{" + s.field + "}")
+		}
+		out = append(out, sb.String())
+	}
+	return out
+}
+
+//patternForm is a pattern without optional parts.
+type patternForm []patternSegment
+
+//forms returns a form for every combination of optional parts of p, starting with the one without optional
+//parts and ending with the one with all of them.
+func (p Pattern) forms() []patternForm {
+	out := make([]patternForm, 0, 1<<p.groups)
+	for mask := 0; mask < 1<<p.groups; mask++ {
+		f := patternForm{}
+		for _, s := range p.segments {
+			if s.group == 0 || mask&(1<<(s.group-1)) != 0 {
+				f = append(f, s)
+			}
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func (f patternForm) match(segments []NameSegment) (map[string]string, bool) {
+	if len(segments) != len(f) {
+		return nil, false
+	}
+	out := map[string]string{}
+	for i, s := range f {
+		if segments[i].Collection != s.collection {
+			return nil, false
+		}
+		out[s.field] = segments[i].Id
+	}
+	return out, true
+}
+
+func patternField(token string) (string, bool) {
+	if len(token) < 3 || token[0] != '{' || token[len(token)-1] != '}' {
+		return "", false
+	}
+	field := token[1 : len(token)-1]
+	if strings.ContainsAny(field, "{}[]") {
+		return "", false
+	}
+	return field, true
+}
+
+func invalidPattern(pattern, msg string) aldberr.CanvigaError {
+	return aldberr.New(ErrorCodeInvalidPattern, msg, map[string]interface{}{"pattern": pattern})
+}
+
+//region PatternRef
+
+//PatternRef is a reference of which the names follow a Pattern. A reference type implements Ref by
+//implementing PatternRef and calling PatternName, IsPatternComplete and FromPatternName.
+type PatternRef interface {
+	//Pattern returns the pattern of the names of the reference type.
+	Pattern() Pattern
+	//IdMap returns the ids of the reference by field name of the pattern, "" for ids that aren't set.
+	IdMap() map[string]string
+	//FromIdMap sets the reference to the one with the given ids by field name, or to the zero value if no
+	//ids are given.
+	FromIdMap(ids map[string]string) error
+}
+
+//PatternName implements Ref.ToName for a PatternRef.
+func PatternName(r PatternRef) string {
+	return r.Pattern().Format(r.IdMap())
+}
+
+//IsPatternComplete implements Ref.IsComplete for a PatternRef.
+func IsPatternComplete(r PatternRef) bool {
+	return r.Pattern().IsComplete(r.IdMap())
+}
+
+//FromPatternName implements Ref.FromName for a PatternRef r, isNil telling whether r is a nil pointer.
+func FromPatternName(r PatternRef, isNil bool, name string) error {
+	if isNil {
+		return aldberr.New(ErrorCodeInvalidName, fmt.Sprintf("cannot parse name into a nil %T", r), map[string]interface{}{"name": name})
+	}
+	if len(name) == 0 {
+		return r.FromIdMap(map[string]string{})
+	}
+	ids, err := r.Pattern().Match(name)
+	if err != nil {
+		return err
+	}
+	return r.FromIdMap(ids)
+}
+
+//endregion
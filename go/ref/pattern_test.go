@@ -0,0 +1,87 @@
+package ref
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+func TestPattern(t *testing.T) {
+	p := MustPattern("activities/{activityId}/versions/{version}/attribute-sets/{setId}")
+	ids := map[string]string{"activityId": "https://aldb.test/a", "version": "1", "setId": "x/y"}
+	name := p.Format(ids)
+	if expected := "activities/https:%2F%2Faldb.test%2Fa/versions/1/attribute-sets/x%2Fy"; name != expected {
+		t.Errorf("expected %s, got %s", expected, name)
+	}
+	matched, err := p.Match(name)
+	if err != nil || !reflect.DeepEqual(matched, ids) {
+		t.Errorf("expected %v, got %v (%v)", ids, matched, err)
+	}
+	if name := p.Format(map[string]string{"activityId": "a", "setId": "x"}); name != "" {
+		t.Errorf("expected no name for missing ids, got %s", name)
+	}
+	if !reflect.DeepEqual(p.Fields(), []string{"activityId", "version", "setId"}) {
+		t.Errorf("unexpected fields %v", p.Fields())
+	}
+
+	optional := MustPattern("[hosts/{host}/]entities/{entityId}").Child("user-contexts/{contextId}")
+	for name, expected := range map[string]map[string]string{
+		"entities/e/user-contexts/c":                {"host": "", "entityId": "e", "contextId": "c"},
+		"hosts/h/entities/e/user-contexts/c":        {"host": "h", "entityId": "e", "contextId": "c"},
+		"hosts/h%2Fx/entities/e/user-contexts/c%20": {"host": "h/x", "entityId": "e", "contextId": "c "},
+	} {
+		ids, err := optional.Match(name)
+		if err != nil || !reflect.DeepEqual(ids, expected) {
+			t.Errorf("expected %s to give %v, got %v (%v)", name, expected, ids, err)
+		}
+		if formatted := optional.Format(ids); formatted != name {
+			t.Errorf("expected %s, got %s", name, formatted)
+		}
+	}
+	for _, name := range []string{"hosts/h/user-contexts/c", "entities/e", "hosts/h/hosts/h/entities/e/user-contexts/c"} {
+		_, err := optional.Match(name)
+		cErr := aldberr.CanvigaError{}
+		if !errors.As(err, &cErr) || cErr.Code() != ErrorCodeInvalidName {
+			t.Errorf("expected %s not to match, got %v", name, err)
+		}
+	}
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		"activities",
+		"activities/id",
+		"activities/{}",
+		"Activities/{id}",
+		"activities/{id}/versions/{id}",
+		"activities[/{id}]",
+		"activities/{id}[/versions]/{version}",
+		"activities/{id}[/versions/{version}",
+		"activities/{id}]",
+		"[a/{a}[/b/{b}]]",
+	} {
+		_, err := NewPattern(pattern)
+		cErr := aldberr.CanvigaError{}
+		if !errors.As(err, &cErr) || cErr.Code() != ErrorCodeInvalidPattern {
+			t.Errorf("expected '%s' to be invalid, got %v", pattern, err)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	for p, expected := range map[string][]string{
+		ActivityPattern.String(): {"/activities/{activityId}", "/activities/{activityId}/versions/{version}"},
+		AttributeSetPattern.String(): {
+			"/activities/{activityId}/attribute-sets/{attributeSetId}",
+			"/activities/{activityId}/versions/{version}/attribute-sets/{attributeSetId}",
+		},
+		"a/{a}[/b/{b}][/c/{c}]": {"/a/{a}", "/a/{a}/b/{b}", "/a/{a}/c/{c}", "/a/{a}/b/{b}/c/{c}"},
+	} {
+		if routes := MustPattern(p).Routes(); !reflect.DeepEqual(routes, expected) {
+			t.Errorf("expected routes %v for %s, got %v", expected, p, routes)
+		}
+	}
+}
@@ -1,7 +1,51 @@
+//Package ref contains references to the resources of the model, which can be written as names.
+//
+//A name is a relative URI: a sequence of segments, each being the name of a collection followed by the
+//percent-encoded (see url.PathEscape) id of a resource in it. Resources in a collection of another resource
+//append their segment to its name, and a version of a resource is referred to with a "versions" segment:
+//
+//	activities/{activity id}
+//	activities/{activity id}/versions/{version}
+//	activities/{activity id}[/versions/{version}]/attribute-sets/{attribute set id}
+//	activities/{activity id}[/versions/{version}]/participations/{participation id}
+//	manifests/{manifest id}[/versions/{version}]
+//	[hosts/{host}/]entities/{entity id}
+//	[hosts/{host}/]entities/{entity id}/user-contexts/{user context id}
+//	participation-roles/{participation role id}
+//
+//e.g. "activities/https:%2F%2Faldb.test%2Factivities%2Fproject/versions/0001". The names of activities and
+//manifests are the paths of their resources in the REST API (see package server), without the leading
+//slash.
+//
+//The grammar of a reference type is declared as a Pattern, e.g. ActivityPattern, which parses and formats its
+//names and gives the HTTP routes of its resources. Every reference type implements PatternRef, Ref (with
+//pointer receivers for FromName and FromIdMap) and encoding.TextMarshaler, so references are written as their
+//name in JSON.
 package ref
 
+import (
+	"net/http"
+
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	ErrorCodeInvalidName = "ref-invalid-name"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidName, Status: http.StatusBadRequest, Message: errcode.En("invalid reference name")},
+	)
+}
+
+//Ref is a reference that can be written as a name.
 type Ref interface {
+	//ToName returns the canonical name of the reference, or "" if it isn't complete.
 	ToName() string
+	//FromName sets the reference to the one with the given name, or to the zero value for "". An error
+	//with code ErrorCodeInvalidName is returned if the name doesn't follow the grammar for this type.
 	FromName(name string) error
+	//IsComplete checks that the ids required to name the reference are set.
 	IsComplete() bool
 }
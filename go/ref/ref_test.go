@@ -0,0 +1,227 @@
+package ref
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+func TestNames(t *testing.T) {
+	id, _ := url.Parse("https://aldb.test/activities/project")
+	ar := ActivityRef{Id: id, Version: "0001"}
+	for _, c := range []struct {
+		ref      Ref
+		expected string
+	}{
+		{&ActivityRef{Id: id}, "activities/https:%2F%2Faldb.test%2Factivities%2Fproject"},
+		{&ar, "activities/https:%2F%2Faldb.test%2Factivities%2Fproject/versions/0001"},
+		{&AttributeSetRef{ActivityRef: ar, AttributeSetId: "budget"}, "activities/https:%2F%2Faldb.test%2Factivities%2Fproject/versions/0001/attribute-sets/budget"},
+		{&ParticipationRef{ActivityRef: ActivityRef{Id: id}, ParticipationId: "lead one"}, "activities/https:%2F%2Faldb.test%2Factivities%2Fproject/participations/lead%20one"},
+		{&ManifestRef{Id: id}, "manifests/https:%2F%2Faldb.test%2Factivities%2Fproject"},
+		{&ActivityRef{Version: "0001"}, ""},
+		{&AttributeSetRef{ActivityRef: ar}, ""},
+	} {
+		if name := c.ref.ToName(); name != c.expected {
+			t.Errorf("expected name '%s', got '%s'", c.expected, name)
+		}
+	}
+
+	parsed := AttributeSetRef{}
+	if err := parsed.FromName("activities/https:%2F%2Faldb.test%2Factivities%2Fproject/versions/0001/attribute-sets/budget"); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Id.String() != id.String() || parsed.Version != "0001" || parsed.AttributeSetId != "budget" {
+		t.Errorf("unexpected ref %+v", parsed)
+	}
+	if err := parsed.FromName(""); err != nil || parsed != (AttributeSetRef{}) {
+		t.Errorf("expected the empty name to give the zero value, got %+v (%v)", parsed, err)
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	for _, name := range []string{
+		"activities",
+		"activities/",
+		"activities/x/attribute-sets",
+		"Activities/x",
+		"activities/%zz",
+		"manifests/x",
+		"activities/x/participations/p",
+		"activities/x/attribute-sets/a/attribute-sets/b",
+		"activities/x/versions/1/versions/2/attribute-sets/a",
+	} {
+		r := AttributeSetRef{}
+		err := r.FromName(name)
+		cErr := aldberr.CanvigaError{}
+		if !errors.As(err, &cErr) || cErr.Code() != ErrorCodeInvalidName {
+			t.Errorf("expected '%s' to be invalid, got %v", name, err)
+		}
+	}
+
+	var nilRef *ActivityRef
+	if err := nilRef.FromName("activities/x"); err == nil {
+		t.Error("expected an error for a nil receiver")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	type doc struct {
+		Parent   ActivityRef     `json:"parent"`
+		Set      AttributeSetRef `json:"set"`
+		Optional ManifestRef     `json:"optional"`
+	}
+	id, _ := url.Parse("urn:aldb:x")
+	in := doc{Parent: ActivityRef{Id: id}, Set: AttributeSetRef{ActivityRef: ActivityRef{Id: id, Version: "2"}, AttributeSetId: "a"}}
+	bts, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"parent":"activities/urn:aldb:x","set":"activities/urn:aldb:x/versions/2/attribute-sets/a","optional":""}`
+	if string(bts) != expected {
+		t.Errorf("expected %s, got %s", expected, bts)
+	}
+	out := doc{}
+	if err := json.Unmarshal(bts, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Set.ToName() != in.Set.ToName() || out.Parent.ToName() != in.Parent.ToName() || out.Optional.Id != nil {
+		t.Errorf("unexpected round trip %+v", out)
+	}
+
+	if _, err := json.Marshal(doc{Set: AttributeSetRef{AttributeSetId: "a"}}); err == nil {
+		t.Error("expected an error for an incomplete ref")
+	}
+}
+
+//region fuzzing
+
+func FuzzParseName(f *testing.F) {
+	f.Add("activities/x/versions/1")
+	f.Add("a/%2F/b/%20")
+	f.Fuzz(func(t *testing.T, name string) {
+		segments, err := ParseName(name)
+		if err != nil {
+			return
+		}
+		again, err := ParseName(FormatName(segments...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(again) != len(segments) {
+			t.Fatalf("expected %v, got %v", segments, again)
+		}
+		for i := range segments {
+			if again[i] != segments[i] {
+				t.Fatalf("expected %v, got %v", segments, again)
+			}
+		}
+	})
+}
+
+func FuzzActivityRef(f *testing.F) {
+	f.Add("https://aldb.test/activities/project", "0001")
+	f.Add("urn:aldb:x", "")
+	f.Fuzz(func(t *testing.T, id, version string) {
+		ar, ok := fuzzActivityRef(id, version)
+		if !ok {
+			return
+		}
+		out := ActivityRef{}
+		roundTrip(t, &ar, &out)
+		assertSameActivityRef(t, ar, out)
+	})
+}
+
+func FuzzAttributeSetRef(f *testing.F) {
+	f.Add("https://aldb.test/activities/project", "0001", "budget")
+	f.Fuzz(func(t *testing.T, id, version, setId string) {
+		ar, ok := fuzzActivityRef(id, version)
+		if !ok || len(setId) == 0 {
+			return
+		}
+		in := AttributeSetRef{ActivityRef: ar, AttributeSetId: setId}
+		out := AttributeSetRef{}
+		roundTrip(t, &in, &out)
+		assertSameActivityRef(t, in.ActivityRef, out.ActivityRef)
+		if out.AttributeSetId != in.AttributeSetId {
+			t.Fatalf("expected attribute set '%s', got '%s'", in.AttributeSetId, out.AttributeSetId)
+		}
+	})
+}
+
+func FuzzParticipationRef(f *testing.F) {
+	f.Add("https://aldb.test/activities/project", "", "lead")
+	f.Fuzz(func(t *testing.T, id, version, participationId string) {
+		ar, ok := fuzzActivityRef(id, version)
+		if !ok || len(participationId) == 0 {
+			return
+		}
+		in := ParticipationRef{ActivityRef: ar, ParticipationId: participationId}
+		out := ParticipationRef{}
+		roundTrip(t, &in, &out)
+		assertSameActivityRef(t, in.ActivityRef, out.ActivityRef)
+		if out.ParticipationId != in.ParticipationId {
+			t.Fatalf("expected participation '%s', got '%s'", in.ParticipationId, out.ParticipationId)
+		}
+	})
+}
+
+func FuzzManifestRef(f *testing.F) {
+	f.Add("https://projo.com/manifests/project", "2")
+	f.Fuzz(func(t *testing.T, id, version string) {
+		ar, ok := fuzzActivityRef(id, version)
+		if !ok {
+			return
+		}
+		in := ManifestRef{Id: ar.Id, Version: ar.Version}
+		out := ManifestRef{}
+		roundTrip(t, &in, &out)
+		if out.Id.String() != in.Id.String() || out.Version != in.Version {
+			t.Fatalf("expected %s, got %s", in.ToName(), out.ToName())
+		}
+	})
+}
+
+//fuzzActivityRef returns the complete ActivityRef with the given id and version, if there is one.
+func fuzzActivityRef(id, version string) (ActivityRef, bool) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return ActivityRef{}, false
+	}
+	ar := ActivityRef{Id: u, Version: version}
+	return ar, ar.IsComplete()
+}
+
+//roundTrip checks that out can be read from both the name and the text of in.
+func roundTrip(t *testing.T, in Ref, out interface {
+	Ref
+	UnmarshalText(text []byte) error
+}) {
+	t.Helper()
+	name := in.ToName()
+	if err := out.FromName(name); err != nil {
+		t.Fatalf("cannot parse '%s': %v", name, err)
+	}
+	if out.ToName() != name {
+		t.Fatalf("expected name '%s', got '%s'", name, out.ToName())
+	}
+	text, err := in.(interface{ MarshalText() ([]byte, error) }).MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.UnmarshalText(text); err != nil || out.ToName() != name {
+		t.Fatalf("expected text '%s' to give name '%s', got '%s' (%v)", text, name, out.ToName(), err)
+	}
+}
+
+func assertSameActivityRef(t *testing.T, expected, actual ActivityRef) {
+	t.Helper()
+	if actual.Id.String() != expected.Id.String() || actual.Version != expected.Version {
+		t.Fatalf("expected %s, got %s", expected.ToName(), actual.ToName())
+	}
+}
+
+//endregion
@@ -0,0 +1,82 @@
+package selection
+
+//region Interval
+
+//Boundary is the lower or upper boundary of an Interval. An open boundary doesn't constrain the interval,
+//a closed one has a value that is either included in the interval or not.
+type Boundary[A any] struct {
+	closed   bool
+	value    A
+	included bool
+}
+
+func (b Boundary[A]) Value() (val A, inclusive bool, closed bool) {
+	return b.value, b.included, b.closed
+}
+
+func (b Boundary[A]) IsOpen() bool {
+	return !b.closed
+}
+
+func Incl[A any](value A) Boundary[A] {
+	return Boundary[A]{closed: true, value: value, included: true}
+}
+
+func Excl[A any](value A) Boundary[A] {
+	return Boundary[A]{closed: true, value: value, included: false}
+}
+
+func Open[A any]() Boundary[A] {
+	return Boundary[A]{closed: false}
+}
+
+type Interval[A any] struct {
+	Lower, Upper Boundary[A]
+}
+
+func (i Interval[A]) Contains(a A, compare CompareFunc[A]) bool {
+	return PassesOver(a, i.Lower, compare) && PassesUnder(a, i.Upper, compare)
+}
+
+//PassesOver returns whether value is above (or on, if included) the lower boundary.
+func PassesOver[A any](value A, boundary Boundary[A], compare CompareFunc[A]) bool {
+	if boundary.IsOpen() {
+		return true
+	}
+	if compare == nil {
+		compare = CompareKnownTypes[A]
+	}
+	valueOverBoundary, equal := compare(boundary.value, value)
+	return (equal && boundary.included) || valueOverBoundary
+}
+
+//PassesUnder returns whether value is below (or on, if included) the upper boundary.
+func PassesUnder[A any](value A, boundary Boundary[A], compare CompareFunc[A]) bool {
+	if boundary.IsOpen() {
+		return true
+	}
+	if compare == nil {
+		compare = CompareKnownTypes[A]
+	}
+	valueUnderBoundary, equal := compare(value, boundary.value)
+	return (equal && boundary.included) || valueUnderBoundary
+}
+
+type CompareFunc[A any] func(left, right A) (leftIsSmaller, equal bool)
+
+//CompareKnownTypes compares strings (byte-wise, like versions are ordered) and ints. Values of other types
+//are never smaller nor equal.
+func CompareKnownTypes[A any](left, right A) (leftIsSmaller bool, equal bool) {
+	switch l := any(left).(type) {
+	case string:
+		r := any(right).(string)
+		return l < r, l == r
+	case int:
+		r := any(right).(int)
+		return l < r, l == r
+	default:
+		return false, false
+	}
+}
+
+//endregion
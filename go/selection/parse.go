@@ -0,0 +1,462 @@
+package selection
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+const (
+	//ErrorCodeSyntax is returned for strings that are not valid selectors. The details contain the "position"
+	//(byte offset) in the "input" where the problem was found.
+	ErrorCodeSyntax = "selection-syntax"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeSyntax, Status: http.StatusBadRequest, Message: errcode.En("invalid selector syntax at position {position}")},
+	)
+}
+
+//Parse parses a selector of strings. The grammar is:
+//
+//	selector     = intersection
+//	intersection = term { "&" term }
+//	term         = "*" | set | "!" set | interval | regex | index | value | "(" selector { "," selector } ")"
+//	set          = "{" [ element { "," element } ] "}"
+//	element      = value | index
+//	interval     = ( "[" | "]" ) [ bound ] "," [ bound ] ( "[" | "]" )
+//	bound        = value | index
+//	index        = "#" [ "-" ] digit { digit }
+//	regex        = "^" ... "$"
+//	value        = bare | quoted
+//
+//For example "*" selects everything, "{}" nothing, "foo" only foo, "{foo, bar}" foo and bar, "!{foo, bar}"
+//everything but foo and bar, "[foo,bar[" everything from foo (included) to bar (excluded), "#0" the first
+//and "#-1" the last item, "^fo+$" everything matching the regex. A set can mix values and indexes, e.g.
+//"{foo, #-1}" selects foo and the last item. A list of selectors in parentheses selects what any of them
+//selects, selectors joined by "&" select what all of them select.
+//
+//A bare value is a sequence of characters other than whitespace and {}[](),&@" that doesn't start with one
+//of !#^*, so that URIs can mostly be written as is. Other values are written as a double-quoted string with
+//backslash escapes. A regex ends at the first "$" outside brackets that is followed by the end of the
+//input, whitespace or a delimiter.
+func Parse(s string) (Selector[string], error) {
+	p := &parser{input: s}
+	sel, err := p.parseIntersection()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectEnd(); err != nil {
+		return nil, err
+	}
+	return sel, nil
+}
+
+//MustParse is Parse that panics on errors, for selectors that are known to be valid.
+func MustParse(s string) Selector[string] {
+	sel, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return sel
+}
+
+const (
+	delimiters    = `{}[](),&@"`
+	reservedFirst = `!#^*`
+)
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) errorAt(pos int, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	return aldberr.New(ErrorCodeSyntax, fmt.Sprintf("%s at position %d", msg, pos), map[string]interface{}{"position": pos, "input": p.input})
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) {
+		r, size := utf8.DecodeRuneInString(p.input[p.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		p.pos += size
+	}
+}
+
+//peek returns the next non-space byte, or 0 at the end of the input.
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *parser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorAt(p.pos, "expected '%c', found %s", c, p.describeNext())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) expectEnd() error {
+	if p.peek() != 0 {
+		return p.errorAt(p.pos, "unexpected %s", p.describeNext())
+	}
+	return nil
+}
+
+func (p *parser) describeNext() string {
+	if p.pos >= len(p.input) {
+		return "end of input"
+	}
+	r, _ := utf8.DecodeRuneInString(p.input[p.pos:])
+	return fmt.Sprintf("'%c'", r)
+}
+
+func (p *parser) parseIntersection() (Selector[string], error) {
+	first, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	subs := []Selector[string]{first}
+	for p.peek() == '&' {
+		p.pos++
+		next, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, next)
+	}
+	if len(subs) == 1 {
+		return first, nil
+	}
+	return &IntersectionSelector[string]{SubSelectors: subs}, nil
+}
+
+func (p *parser) parseTerm() (Selector[string], error) {
+	switch c := p.peek(); c {
+	case '*':
+		p.pos++
+		return &AllSelector[string]{}, nil
+	case '{':
+		return p.parseSet()
+	case '!':
+		p.pos++
+		return p.parseNot()
+	case '[', ']':
+		return p.parseInterval()
+	case '^':
+		return p.parseRegex()
+	case '#':
+		i, err := p.parseIndex()
+		if err != nil {
+			return nil, err
+		}
+		return &OneIndexSelector[string]{Index: i}, nil
+	case '(':
+		return p.parseList()
+	case 0:
+		return nil, p.errorAt(p.pos, "expected a selector, found end of input")
+	default:
+		if strings.IndexByte(delimiters, c) >= 0 && c != '"' {
+			return nil, p.errorAt(p.pos, "expected a selector, found %s", p.describeNext())
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return &OneSelector[string]{Value: v}, nil
+	}
+}
+
+//parseElements parses the comma-separated values and indexes up to the closing '}'.
+func (p *parser) parseElements() (values []string, indexes []int, err error) {
+	if err := p.expect('{'); err != nil {
+		return nil, nil, err
+	}
+	if p.peek() == '}' {
+		p.pos++
+		return nil, nil, nil
+	}
+	for {
+		if p.peek() == '#' {
+			i, err := p.parseIndex()
+			if err != nil {
+				return nil, nil, err
+			}
+			indexes = append(indexes, i)
+		} else {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, nil, err
+			}
+			values = append(values, v)
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return values, indexes, nil
+		default:
+			return nil, nil, p.errorAt(p.pos, "expected ',' or '}', found %s", p.describeNext())
+		}
+	}
+}
+
+func (p *parser) parseSet() (Selector[string], error) {
+	values, indexes, err := p.parseElements()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(values) == 0 && len(indexes) == 0:
+		return &NoneSelector[string]{}, nil
+	case len(indexes) == 0:
+		return &SetSelector[string]{Set: values}, nil
+	case len(values) == 0:
+		return &IndexSetSelector[string]{IndexSet: indexes}, nil
+	default:
+		return &UnionSelector[string]{SubSelectors: []Selector[string]{
+			&SetSelector[string]{Set: values},
+			&IndexSetSelector[string]{IndexSet: indexes},
+		}}, nil
+	}
+}
+
+func (p *parser) parseNot() (Selector[string], error) {
+	start := p.pos
+	values, indexes, err := p.parseElements()
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) > 0 {
+		return nil, p.errorAt(start, "indexes are not supported in '!{...}'")
+	}
+	if values == nil {
+		values = []string{}
+	}
+	return &NotSelector[string]{BlackList: values}, nil
+}
+
+func (p *parser) parseList() (Selector[string], error) {
+	p.pos++
+	subs := []Selector[string]{}
+	for {
+		sub, err := p.parseIntersection()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			if len(subs) == 1 {
+				return subs[0], nil
+			}
+			return &UnionSelector[string]{SubSelectors: subs}, nil
+		default:
+			return nil, p.errorAt(p.pos, "expected ',' or ')', found %s", p.describeNext())
+		}
+	}
+}
+
+//bound is a parsed interval boundary.
+type bound struct {
+	present bool
+	isIndex bool
+	index   int
+	value   string
+	pos     int
+}
+
+func (p *parser) parseBound() (bound, error) {
+	b := bound{pos: p.pos}
+	switch c := p.peek(); {
+	case c == ',' || c == '[' || c == ']':
+		return b, nil
+	case c == '#':
+		b.pos = p.pos
+		i, err := p.parseIndex()
+		return bound{present: true, isIndex: true, index: i, pos: b.pos}, err
+	default:
+		b.pos = p.pos
+		v, err := p.parseValue()
+		return bound{present: true, value: v, pos: b.pos}, err
+	}
+}
+
+func (p *parser) parseInterval() (Selector[string], error) {
+	fromIncluded := p.input[p.pos] == '['
+	p.pos++
+	from, err := p.parseBound()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(','); err != nil {
+		return nil, err
+	}
+	to, err := p.parseBound()
+	if err != nil {
+		return nil, err
+	}
+	var toIncluded bool
+	switch p.peek() {
+	case ']':
+		toIncluded = true
+	case '[':
+		toIncluded = false
+	default:
+		return nil, p.errorAt(p.pos, "expected '[' or ']', found %s", p.describeNext())
+	}
+	p.pos++
+
+	if from.present && to.present && from.isIndex != to.isIndex {
+		return nil, p.errorAt(to.pos, "cannot mix an index and a value in an interval")
+	}
+	if from.isIndex || to.isIndex {
+		return &IndexIntervalSelector[string]{
+			From: toBoundary(from.present, from.index, fromIncluded),
+			To:   toBoundary(to.present, to.index, toIncluded),
+		}, nil
+	}
+	return &IntervalSelector[string]{
+		From: toBoundary(from.present, from.value, fromIncluded),
+		To:   toBoundary(to.present, to.value, toIncluded),
+	}, nil
+}
+
+func toBoundary[A any](present bool, value A, included bool) Boundary[A] {
+	switch {
+	case !present:
+		return Open[A]()
+	case included:
+		return Incl(value)
+	default:
+		return Excl(value)
+	}
+}
+
+func (p *parser) parseRegex() (Selector[string], error) {
+	start := p.pos
+	depth := 0
+	inClass := false
+	for i := start + 1; i < len(p.input); i++ {
+		c := p.input[i]
+		switch {
+		case c == '\\':
+			i++
+		case inClass:
+			if c == ']' && p.input[i-1] != '[' && !(p.input[i-1] == '^' && p.input[i-2] == '[') {
+				inClass = false
+			}
+		case c == '[':
+			inClass = true
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			depth--
+		case c == '$' && depth <= 0 && endsToken(p.input, i+1):
+			re, err := regexp.Compile(p.input[start : i+1])
+			if err != nil {
+				return nil, p.errorAt(start, "invalid regex: %s", err.Error())
+			}
+			p.pos = i + 1
+			return &RegexSelector{Regex: re}, nil
+		}
+	}
+	return nil, p.errorAt(start, "unterminated regex, expected '$'")
+}
+
+//endsToken returns whether a token ending just before i is followed by the end of the input, whitespace or
+//a delimiter.
+func endsToken(s string, i int) bool {
+	if i >= len(s) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsSpace(r) || strings.ContainsRune(delimiters, r)
+}
+
+func (p *parser) parseIndex() (int, error) {
+	start := p.pos
+	p.pos++ //'#'
+	digitsStart := p.pos
+	if p.pos < len(p.input) && p.input[p.pos] == '-' {
+		p.pos++
+	}
+	n := 0
+	for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+		p.pos++
+		n++
+	}
+	if n == 0 {
+		return 0, p.errorAt(p.pos, "expected digits after '#'")
+	}
+	i, err := strconv.Atoi(p.input[digitsStart:p.pos])
+	if err != nil {
+		return 0, p.errorAt(start, "invalid index: %s", err.Error())
+	}
+	return i, nil
+}
+
+func (p *parser) parseValue() (string, error) {
+	c := p.peek()
+	start := p.pos
+	if c == '"' {
+		for i := start + 1; i < len(p.input); i++ {
+			switch p.input[i] {
+			case '\\':
+				i++
+			case '"':
+				v, err := strconv.Unquote(p.input[start : i+1])
+				if err != nil {
+					return "", p.errorAt(start, "invalid quoted value")
+				}
+				p.pos = i + 1
+				return v, nil
+			}
+		}
+		return "", p.errorAt(start, "unterminated quoted value, expected '\"'")
+	}
+	if c == 0 {
+		return "", p.errorAt(p.pos, "expected a value, found end of input")
+	}
+	if strings.IndexByte(reservedFirst, c) >= 0 || strings.IndexByte(delimiters, c) >= 0 {
+		return "", p.errorAt(p.pos, "expected a value, found %s", p.describeNext())
+	}
+	for p.pos < len(p.input) && !endsToken(p.input, p.pos) {
+		_, size := utf8.DecodeRuneInString(p.input[p.pos:])
+		p.pos += size
+	}
+	return p.input[start:p.pos], nil
+}
+
+//quoteIfNeeded returns s as it has to be written in a selector: as is if it's a valid bare value, quoted
+//otherwise.
+func quoteIfNeeded(s string) string {
+	if len(s) == 0 || strings.IndexByte(reservedFirst, s[0]) >= 0 {
+		return strconv.Quote(s)
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) || strings.ContainsRune(delimiters, r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,527 @@
+//Package selection contains selectors: expressions that select some items from an ordered list, such as
+//activity ids or the versions of an activity. Selectors have a compact string syntax, see Parse.
+package selection
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Selector[C comparable] interface {
+	//SelectFrom returns the selected items of cs, in the order of cs. It returns nil for nil input.
+	SelectFrom(cs []C) []C
+	//String returns the selector in the syntax accepted by Parse.
+	String() string
+}
+
+//ByIndexSelector is a Selector that selects items based on their index in the ordered input. Indexes start
+//at 0 for the first item and can be negative to count from the end: -1 is the last item.
+type ByIndexSelector[C comparable] interface {
+	Selector[C]
+	//SelectMapFrom returns the selected items by the index used to select them.
+	SelectMapFrom(cs []C) map[int]C
+}
+
+//region AllSelector
+
+//AllSelector is a Selector that will select all inputs. Its string representation is "*".
+type AllSelector[C comparable] struct{}
+
+var _ ByIndexSelector[string] = &AllSelector[string]{}
+
+func (s *AllSelector[C]) SelectFrom(cs []C) []C {
+	if cs == nil {
+		return nil
+	}
+	out := make([]C, len(cs))
+	copy(out, cs)
+	return out
+}
+
+func (s *AllSelector[C]) SelectMapFrom(cs []C) map[int]C {
+	if cs == nil {
+		return nil
+	}
+	out := make(map[int]C, len(cs))
+	for i := range cs {
+		out[i] = cs[i]
+	}
+	return out
+}
+
+func (s *AllSelector[C]) String() string {
+	return "*"
+}
+
+//endregion
+
+//region NoneSelector
+
+//NoneSelector is a Selector that will accept nothing. Its string representation is "{}".
+type NoneSelector[C comparable] struct{}
+
+var _ ByIndexSelector[string] = &NoneSelector[string]{}
+
+func (s *NoneSelector[C]) SelectFrom(cs []C) []C {
+	if cs == nil {
+		return nil
+	}
+	return []C{}
+}
+
+func (s *NoneSelector[C]) SelectMapFrom(cs []C) map[int]C {
+	if cs == nil {
+		return nil
+	}
+	return map[int]C{}
+}
+
+func (s *NoneSelector[C]) String() string {
+	return "{}"
+}
+
+//endregion
+
+//region OneSelector
+
+//OneSelector is a Selector that will accept exactly one input: its Value. Its string representation is the
+//value itself, quoted if needed (see Parse).
+type OneSelector[C comparable] struct {
+	Value C
+}
+
+var _ Selector[string] = &OneSelector[string]{}
+
+func (s *OneSelector[C]) SelectFrom(cs []C) []C {
+	return filter(cs, func(c C) bool {
+		return c == s.Value
+	})
+}
+
+func (s *OneSelector[C]) String() string {
+	return formatValue(s.Value)
+}
+
+//endregion
+
+//region SetSelector
+
+//SetSelector is a Selector that will accept only values of its Set. Its string representation is
+//"{<item1>, <item2>, ..., <itemN>}".
+type SetSelector[C comparable] struct {
+	Set []C
+}
+
+var _ Selector[string] = &SetSelector[string]{}
+
+func (s *SetSelector[C]) SelectFrom(cs []C) []C {
+	in := toSet(s.Set)
+	return filter(cs, func(c C) bool {
+		return in[c]
+	})
+}
+
+func (s *SetSelector[C]) String() string {
+	return "{" + joinValues(s.Set) + "}"
+}
+
+//endregion
+
+//region NotSelector
+
+//NotSelector is a Selector that will accept only values not on its BlackList. Its string representation is
+//"!{<item1>, <item2>, ..., <itemN>}".
+type NotSelector[C comparable] struct {
+	BlackList []C
+}
+
+var _ Selector[string] = &NotSelector[string]{}
+
+func (s *NotSelector[C]) SelectFrom(cs []C) []C {
+	out := toSet(s.BlackList)
+	return filter(cs, func(c C) bool {
+		return !out[c]
+	})
+}
+
+func (s *NotSelector[C]) String() string {
+	return "!{" + joinValues(s.BlackList) + "}"
+}
+
+//endregion
+
+//region IntervalSelector
+
+//IntervalSelector is a Selector that will accept only values between its boundaries. Its string
+//representation is "[<from>,<to>]". If the bracket of a boundary is flipped (i.e. ']' instead of '[' or vice
+//versa) the value of the boundary is not included in the selection. If a boundary value is empty (e.g.
+//"[a,[" or "],z]") the boundary is open.
+type IntervalSelector[C comparable] struct {
+	From, To Boundary[C]
+	//Compare is used to compare values to the boundary values. If nil, CompareKnownTypes is used, meaning
+	//that C must be supported by that function.
+	Compare CompareFunc[C]
+}
+
+var _ Selector[string] = &IntervalSelector[string]{}
+
+func (s *IntervalSelector[C]) SelectFrom(cs []C) []C {
+	i := Interval[C]{Lower: s.From, Upper: s.To}
+	return filter(cs, func(c C) bool {
+		return i.Contains(c, s.Compare)
+	})
+}
+
+func (s *IntervalSelector[C]) String() string {
+	return intervalString(s.From, s.To, formatValue[C])
+}
+
+//endregion
+
+//region RegexSelector
+
+//RegexSelector is a Selector for strings that only selects strings matching its Regex. Its string
+//representation is the regex, starting with '^' and ending with '$'.
+type RegexSelector struct {
+	Regex *regexp.Regexp
+}
+
+var _ Selector[string] = &RegexSelector{}
+
+func (s *RegexSelector) SelectFrom(cs []string) []string {
+	return filter(cs, func(c string) bool {
+		return s.Regex.MatchString(c)
+	})
+}
+
+func (s *RegexSelector) String() string {
+	str := s.Regex.String()
+	if !strings.HasPrefix(str, "^") {
+		str = "^" + str
+	}
+	if !strings.HasSuffix(str, "$") {
+		str = str + "$"
+	}
+	return str
+}
+
+//endregion
+
+//region UnionSelector
+
+//UnionSelector selects the items that are selected by any of its SubSelectors. Its string representation is
+//"(<sub1>, <sub2>, ..., <subN>)", or "{...}" if all SubSelectors select values or indexes.
+type UnionSelector[C comparable] struct {
+	SubSelectors []Selector[C]
+}
+
+var _ Selector[string] = &UnionSelector[string]{}
+
+func (s *UnionSelector[C]) SelectFrom(cs []C) []C {
+	if cs == nil {
+		return nil
+	}
+	selected := map[C]bool{}
+	for _, sub := range s.SubSelectors {
+		for _, c := range sub.SelectFrom(cs) {
+			selected[c] = true
+		}
+	}
+	return filter(cs, func(c C) bool {
+		return selected[c]
+	})
+}
+
+func (s *UnionSelector[C]) String() string {
+	if len(s.SubSelectors) == 0 {
+		return "{}"
+	}
+	elements := []string{}
+	for _, sub := range s.SubSelectors {
+		switch st := sub.(type) {
+		case *OneSelector[C], *OneIndexSelector[C]:
+			elements = append(elements, st.String())
+		case *SetSelector[C]:
+			elements = append(elements, joinValues(st.Set))
+		case *IndexSetSelector[C]:
+			elements = append(elements, joinIndexes(st.IndexSet))
+		default:
+			elements = nil
+		}
+		if elements == nil {
+			break
+		}
+	}
+	if elements != nil {
+		return "{" + strings.Join(elements, ", ") + "}"
+	}
+	strs := make([]string, len(s.SubSelectors))
+	for i, sub := range s.SubSelectors {
+		strs[i] = sub.String()
+	}
+	return "(" + strings.Join(strs, ", ") + ")"
+}
+
+//endregion
+
+//region IntersectionSelector
+
+//IntersectionSelector selects the items that are selected by all of its SubSelectors, which are applied one
+//after the other (so index-based SubSelectors apply to the output of the previous ones). Its string
+//representation is "<sub1> & <sub2> & ... & <subN>".
+type IntersectionSelector[C comparable] struct {
+	SubSelectors []Selector[C]
+}
+
+var _ Selector[string] = &IntersectionSelector[string]{}
+
+func (s *IntersectionSelector[C]) SelectFrom(cs []C) []C {
+	if cs == nil {
+		return nil
+	}
+	out := make([]C, len(cs))
+	copy(out, cs)
+	for _, sub := range s.SubSelectors {
+		if len(out) == 0 {
+			break
+		}
+		out = sub.SelectFrom(out)
+	}
+	return out
+}
+
+func (s *IntersectionSelector[C]) String() string {
+	if len(s.SubSelectors) == 0 {
+		return "*"
+	}
+	strs := make([]string, len(s.SubSelectors))
+	for i, sub := range s.SubSelectors {
+		strs[i] = sub.String()
+		if _, isIntersection := sub.(*IntersectionSelector[C]); isIntersection {
+			strs[i] = "(" + strs[i] + ")"
+		}
+	}
+	return strings.Join(strs, " & ")
+}
+
+//endregion
+
+//region OneIndexSelector
+
+//OneIndexSelector selects the item at Index. Its string representation is "#<index>", e.g. "#-1" for the
+//last item.
+type OneIndexSelector[C comparable] struct {
+	Index int
+}
+
+var _ ByIndexSelector[string] = &OneIndexSelector[string]{}
+
+func (s *OneIndexSelector[C]) SelectFrom(cs []C) []C {
+	return valuesByIndex(s.SelectMapFrom(cs), len(cs), cs == nil)
+}
+
+func (s *OneIndexSelector[C]) SelectMapFrom(cs []C) map[int]C {
+	if cs == nil {
+		return nil
+	}
+	actI, outOfBounds := toActualIndex(s.Index, len(cs))
+	if outOfBounds {
+		return map[int]C{}
+	}
+	return map[int]C{s.Index: cs[actI]}
+}
+
+func (s *OneIndexSelector[C]) String() string {
+	return "#" + strconv.Itoa(s.Index)
+}
+
+//endregion
+
+//region IndexSetSelector
+
+//IndexSetSelector selects the items at the indexes of IndexSet. Its string representation is
+//"{#<index1>, ..., #<indexN>}".
+type IndexSetSelector[C comparable] struct {
+	IndexSet []int
+}
+
+var _ ByIndexSelector[string] = &IndexSetSelector[string]{}
+
+func (s *IndexSetSelector[C]) SelectFrom(cs []C) []C {
+	return valuesByIndex(s.SelectMapFrom(cs), len(cs), cs == nil)
+}
+
+func (s *IndexSetSelector[C]) SelectMapFrom(cs []C) map[int]C {
+	if cs == nil {
+		return nil
+	}
+	out := map[int]C{}
+	for _, extI := range s.IndexSet {
+		actI, outOfBounds := toActualIndex(extI, len(cs))
+		if outOfBounds {
+			continue
+		}
+		out[extI] = cs[actI]
+	}
+	return out
+}
+
+func (s *IndexSetSelector[C]) String() string {
+	return "{" + joinIndexes(s.IndexSet) + "}"
+}
+
+//endregion
+
+//region IndexIntervalSelector
+
+//IndexIntervalSelector selects the items with an index between its boundaries, see IntervalSelector. A
+//negative and a positive boundary can be combined, e.g. "[#1,#-1]" selects all items but the first one.
+type IndexIntervalSelector[C comparable] struct {
+	From, To Boundary[int]
+}
+
+var _ ByIndexSelector[string] = &IndexIntervalSelector[string]{}
+
+func (s *IndexIntervalSelector[C]) SelectFrom(cs []C) []C {
+	return valuesByIndex(s.SelectMapFrom(cs), len(cs), cs == nil)
+}
+
+func (s *IndexIntervalSelector[C]) SelectMapFrom(cs []C) map[int]C {
+	if cs == nil {
+		return nil
+	}
+	out := map[int]C{}
+	from, to := s.From, s.To
+	for actI := range cs {
+		if passesIndex(actI, len(cs), from, to) {
+			extI := actI
+			if from.closed && from.value < 0 || !from.closed && to.closed && to.value < 0 {
+				//report the index in the form the selector uses
+				extI = actI - len(cs)
+			}
+			out[extI] = cs[actI]
+		}
+	}
+	return out
+}
+
+//passesIndex returns whether the item at actual index actI of n items is between the boundaries, which can
+//be negative indexes.
+func passesIndex(actI, n int, from, to Boundary[int]) bool {
+	actual := func(b Boundary[int]) Boundary[int] {
+		if b.closed && b.value < 0 {
+			b.value += n
+		}
+		return b
+	}
+	return Interval[int]{Lower: actual(from), Upper: actual(to)}.Contains(actI, nil)
+}
+
+func (s *IndexIntervalSelector[C]) String() string {
+	return intervalString(s.From, s.To, func(i int) string {
+		return "#" + strconv.Itoa(i)
+	})
+}
+
+//endregion
+
+//region helpers
+
+func filter[C any](cs []C, keep func(c C) bool) []C {
+	if cs == nil {
+		return nil
+	}
+	out := make([]C, 0, len(cs))
+	for _, c := range cs {
+		if keep(c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func toSet[C comparable](cs []C) map[C]bool {
+	out := make(map[C]bool, len(cs))
+	for _, c := range cs {
+		out[c] = true
+	}
+	return out
+}
+
+func toActualIndex(extI int, n int) (actI int, outOfBounds bool) {
+	if extI < -n || extI >= n {
+		return 0, true
+	}
+	if extI < 0 {
+		return extI + n, false
+	}
+	return extI, false
+}
+
+//valuesByIndex returns the values of m in the order of the items they were selected from.
+func valuesByIndex[C any](m map[int]C, n int, isNil bool) []C {
+	if isNil {
+		return nil
+	}
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ai, _ := toActualIndex(keys[i], n)
+		aj, _ := toActualIndex(keys[j], n)
+		return ai < aj
+	})
+	out := make([]C, 0, len(keys))
+	seen := map[int]bool{}
+	for _, k := range keys {
+		if a, _ := toActualIndex(k, n); !seen[a] {
+			seen[a] = true
+			out = append(out, m[k])
+		}
+	}
+	return out
+}
+
+func formatValue[C any](c C) string {
+	return quoteIfNeeded(fmt.Sprint(c))
+}
+
+func joinValues[C any](cs []C) string {
+	strs := make([]string, len(cs))
+	for i := range cs {
+		strs[i] = formatValue(cs[i])
+	}
+	return strings.Join(strs, ", ")
+}
+
+func joinIndexes(is []int) string {
+	strs := make([]string, len(is))
+	for i := range is {
+		strs[i] = "#" + strconv.Itoa(is[i])
+	}
+	return strings.Join(strs, ", ")
+}
+
+func intervalString[A any](from, to Boundary[A], format func(a A) string) string {
+	fromStr := "]"
+	if from.closed {
+		if from.included {
+			fromStr = "["
+		}
+		fromStr += format(from.value)
+	}
+	toStr := "["
+	if to.closed {
+		if to.included {
+			toStr = "]"
+		}
+		toStr = format(to.value) + toStr
+	}
+	return fromStr + "," + toStr
+}
+
+//endregion
@@ -0,0 +1,247 @@
+package selection
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+var months = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+func TestParseAndSelect(t *testing.T) {
+	cases := []struct {
+		input    string
+		printed  string
+		selected []string
+	}{
+		{`*`, `*`, months},
+		{`{}`, `{}`, []string{}},
+		{`may`, `may`, []string{"may"}},
+		{`{may, jan}`, `{may, jan}`, []string{"jan", "may"}},
+		{`!{jan,feb ,mar}`, `!{jan, feb, mar}`, months[3:]},
+		{`[jan,jun[`, `[jan,jun[`, []string{"jan", "jul"}},
+		{`]jan,jun]`, `]jan,jun]`, []string{"jul", "jun"}},
+		{`[oct,[`, `[oct,[`, []string{"oct", "sep"}},
+		{`],b]`, `],b]`, []string{"aug", "apr"}},
+		{`#0`, `#0`, []string{"jan"}},
+		{`#-1`, `#-1`, []string{"dec"}},
+		{`#12`, `#12`, []string{}},
+		{`{#-1, #0}`, `{#-1, #0}`, []string{"jan", "dec"}},
+		{`[#-3,#-1[`, `[#-3,#-1[`, []string{"oct", "nov"}},
+		{`[#1,#-1]`, `[#1,#-1]`, months[1:]},
+		{`],#1]`, `],#1]`, []string{"jan", "feb"}},
+		{`^ju.$`, `^ju.$`, []string{"jun", "jul"}},
+		{`^(a|s).+$`, `^(a|s).+$`, []string{"apr", "aug", "sep"}},
+		{`{may, #-1}`, `{may, #-1}`, []string{"may", "dec"}},
+		{`(may, ^j.*$)`, `(may, ^j.*$)`, []string{"jan", "may", "jun", "jul"}},
+		{`(may)`, `may`, []string{"may"}},
+		{`^.*r$ & [#-2,[`, `^.*r$ & [#-2,[`, []string{"mar", "apr"}},
+		{`!{jan} & #0`, `!{jan} & #0`, []string{"feb"}},
+		{`"a b"`, `"a b"`, []string{}},
+		{`"may"`, `may`, []string{"may"}},
+		{`{"#1", "x,y"}`, `{"#1", "x,y"}`, []string{}},
+		{`https://doe.eu/activities/42#frag`, `https://doe.eu/activities/42#frag`, []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			sel, err := Parse(c.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sel.String() != c.printed {
+				t.Errorf("expected %s to print as %s, got %s", c.input, c.printed, sel.String())
+			}
+			reparsed, err := Parse(sel.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reparsed.String() != sel.String() {
+				t.Errorf("printed form %s doesn't round trip: %s", sel.String(), reparsed.String())
+			}
+			selected := sel.SelectFrom(months)
+			if !sameElements(selected, c.selected) {
+				t.Errorf("expected %v, got %v", c.selected, selected)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		input    string
+		position int
+	}{
+		{``, 0},
+		{`{a, b`, 5},
+		{`{a b}`, 3},
+		{`!a`, 1},
+		{`!{#1}`, 1},
+		{`[a,#1]`, 3},
+		{`[a b]`, 3},
+		{`[a,b`, 4},
+		{`#`, 1},
+		{`#-x`, 2},
+		{`^abc`, 0},
+		{`^a(b$`, 0},
+		{`"abc`, 0},
+		{`(a, b`, 5},
+		{`()`, 1},
+		{`a b`, 2},
+		{`a & `, 4},
+		{`a@b`, 1},
+		{`}`, 0},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.input)
+		cErr := aldberr.CanvigaError{}
+		if !errors.As(err, &cErr) || cErr.Code() != ErrorCodeSyntax {
+			t.Errorf("%q: expected a syntax error, got %v", c.input, err)
+			continue
+		}
+		if cErr.Details()["position"] != c.position {
+			t.Errorf("%q: expected error at position %d, got %v (%s)", c.input, c.position, cErr.Details()["position"], cErr.Message())
+		}
+	}
+}
+
+func TestUnionSelector(t *testing.T) {
+	s := UnionSelector[string]{
+		SubSelectors: []Selector[string]{
+			&NoneSelector[string]{},
+			&OneSelector[string]{Value: "foo"},
+			&SetSelector[string]{Set: []string{"bar", "zol"}},
+			&IntervalSelector[string]{From: Incl("x"), To: Open[string]()},
+		},
+	}
+	selected := s.SelectFrom([]string{"foo", "bar", "zol", "x", "xylophone", "y", "yoga", "zebra", "car", "bird", "word", "", "fish"})
+	expected := []string{"foo", "bar", "zol", "x", "xylophone", "y", "yoga", "zebra"}
+	if !reflect.DeepEqual(selected, expected) {
+		t.Errorf("expected %v, got %v", expected, selected)
+	}
+}
+
+func TestIndexSelectMap(t *testing.T) {
+	s := IndexIntervalSelector[string]{From: Incl(-4), To: Excl(-2)}
+	selection := s.SelectMapFrom([]string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"})
+	if !reflect.DeepEqual(selection, map[int]string{-4: "thu", -3: "fri"}) {
+		t.Errorf("unexpected selection %v", selection)
+	}
+	if s.SelectFrom(nil) != nil {
+		t.Error("expected nil for nil input")
+	}
+}
+
+func TestSeparatedVersionRefSelector(t *testing.T) {
+	refs := []ref.ActivityRef{
+		vref("foo", "12"), vref("foo", "13"), vref("foo", "14"),
+		vref("bar", "14"),
+		vref("zol", "13"), vref("zol", "14"),
+	}
+	cases := []struct {
+		input    string
+		printed  string
+		selected []ref.ActivityRef
+	}{
+		{`{foo, bar}@[#-2,#-1]`, `{foo, bar}@[#-2,#-1]`, []ref.ActivityRef{vref("bar", "14"), vref("foo", "13"), vref("foo", "14")}},
+		{`(foo, bar)@(#-2, #-1)`, `{foo, bar}@{#-2, #-1}`, []ref.ActivityRef{vref("bar", "14"), vref("foo", "13"), vref("foo", "14")}},
+		{`*`, `*`, []ref.ActivityRef{vref("bar", "14"), vref("foo", "14"), vref("zol", "14")}},
+		{`zol@#-1`, `zol`, []ref.ActivityRef{vref("zol", "14")}},
+		{`*@13`, `*@13`, []ref.ActivityRef{vref("foo", "13"), vref("zol", "13")}},
+		{`!{foo} @ #0`, `!{foo}@#0`, []ref.ActivityRef{vref("bar", "14"), vref("zol", "13")}},
+	}
+	for _, c := range cases {
+		s, err := ParseVersionRefSelector(c.input)
+		if err != nil {
+			t.Errorf("%s: %v", c.input, err)
+			continue
+		}
+		if s.String() != c.printed {
+			t.Errorf("expected %s to print as %s, got %s", c.input, c.printed, s.String())
+		}
+		if selected := s.SelectFrom(refs); !reflect.DeepEqual(selected, c.selected) {
+			t.Errorf("%s: expected %v, got %v", c.input, c.selected, selected)
+		}
+	}
+
+	if _, err := ParseVersionRefSelector(`foo@`); err == nil {
+		t.Error("expected an error for a missing version selector")
+	}
+}
+
+func TestSeparatedVersionRefSelectorJSON(t *testing.T) {
+	type request struct {
+		Selector SeparatedVersionRefSelector `json:"selector"`
+	}
+	req := request{}
+	if err := json.Unmarshal([]byte(`{"selector":"https://doe.eu/activities/1@[#-2,["}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	bts, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bts) != `{"selector":"https://doe.eu/activities/1@[#-2,["}` {
+		t.Errorf("unexpected JSON %s", bts)
+	}
+	if err := json.Unmarshal([]byte(`{"selector":"{a"}`), &req); err == nil {
+		t.Error("expected an error for an invalid selector")
+	}
+	if _, err := json.Marshal(request{}); err == nil {
+		t.Error("expected an error for an empty selector")
+	}
+}
+
+func TestActivityRefConversion(t *testing.T) {
+	id, _ := url.Parse("https://doe.eu/activities/a@b")
+	for _, r := range []ref.ActivityRef{{Id: id}, {Id: id, Version: "0001"}} {
+		s := FromActivityRef(r)
+		parsed, err := ParseVersionRefSelector(s.String())
+		if err != nil {
+			t.Fatalf("%s: %v", s.String(), err)
+		}
+		back, ok := parsed.ActivityRef()
+		if !ok || back.Id.String() != r.Id.String() || back.Version != r.Version {
+			t.Errorf("expected %v, got %v (%v) from %s", r, back, ok, s.String())
+		}
+	}
+	if _, ok := mustParseVersionRefSelector(t, `{a, b}`).ActivityRef(); ok {
+		t.Error("a selector of several activities is not an ActivityRef")
+	}
+}
+
+func mustParseVersionRefSelector(t *testing.T, s string) SeparatedVersionRefSelector {
+	out, err := ParseVersionRefSelector(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func vref(id, version string) ref.ActivityRef {
+	u, _ := url.Parse(id)
+	return ref.ActivityRef{Id: u, Version: version}
+}
+
+func sameElements(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := map[string]int{}
+	for _, s := range a {
+		count[s]++
+	}
+	for _, s := range b {
+		count[s]--
+	}
+	for _, n := range count {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package selection
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+const (
+	ErrorCodeInvalidSelector = "selection-invalid"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidSelector, Status: http.StatusBadRequest, Message: errcode.En("invalid selector")},
+	)
+}
+
+//SeparatedVersionRefSelector selects versions of activities by selecting activity ids and, separately, the
+//versions of every selected activity. Versions are ordered as in ref.ActivityRef, so "#-1" is the latest
+//version.
+//
+//Its string representation is "<activities>@<versions>", e.g. "(a, b)@[#-2,#-1]" selects the last two
+//versions of a and b. "@<versions>" can be omitted to select the latest version, just like an ActivityRef
+//without Version refers to the latest version.
+type SeparatedVersionRefSelector struct {
+	ActivityIdSelector Selector[string]
+	VersionSelector    Selector[string]
+}
+
+//ParseVersionRefSelector parses the string representation of a SeparatedVersionRefSelector, in which both
+//parts have the syntax described at Parse.
+func ParseVersionRefSelector(s string) (SeparatedVersionRefSelector, error) {
+	p := &parser{input: s}
+	activities, err := p.parseIntersection()
+	if err != nil {
+		return SeparatedVersionRefSelector{}, err
+	}
+	versions := Selector[string](&OneIndexSelector[string]{Index: -1})
+	if p.peek() == '@' {
+		p.pos++
+		if versions, err = p.parseIntersection(); err != nil {
+			return SeparatedVersionRefSelector{}, err
+		}
+	}
+	if err := p.expectEnd(); err != nil {
+		return SeparatedVersionRefSelector{}, err
+	}
+	return SeparatedVersionRefSelector{ActivityIdSelector: activities, VersionSelector: versions}, nil
+}
+
+//FromActivityRef returns the selector that selects exactly the version r refers to.
+func FromActivityRef(r ref.ActivityRef) SeparatedVersionRefSelector {
+	out := SeparatedVersionRefSelector{
+		ActivityIdSelector: &OneSelector[string]{},
+		VersionSelector:    &OneIndexSelector[string]{Index: -1},
+	}
+	if r.Id != nil {
+		out.ActivityIdSelector = &OneSelector[string]{Value: r.Id.String()}
+	}
+	if len(r.Version) > 0 {
+		out.VersionSelector = &OneSelector[string]{Value: r.Version}
+	}
+	return out
+}
+
+//ActivityRef returns the ActivityRef that the selector is equivalent to, if it selects a single activity and
+//either a single version or the latest one.
+func (s SeparatedVersionRefSelector) ActivityRef() (ref.ActivityRef, bool) {
+	activity, isOne := s.ActivityIdSelector.(*OneSelector[string])
+	if !isOne {
+		return ref.ActivityRef{}, false
+	}
+	id, err := url.Parse(activity.Value)
+	if err != nil {
+		return ref.ActivityRef{}, false
+	}
+	switch vs := s.VersionSelector.(type) {
+	case *OneSelector[string]:
+		return ref.ActivityRef{Id: id, Version: vs.Value}, true
+	case *OneIndexSelector[string]:
+		if vs.Index == -1 {
+			return ref.ActivityRef{Id: id}, true
+		}
+	}
+	return ref.ActivityRef{}, false
+}
+
+//SelectFrom returns the selected refs, sorted by id and then by version. refs should contain all versions
+//of the activities, refs without Id or Version are ignored.
+func (s SeparatedVersionRefSelector) SelectFrom(refs []ref.ActivityRef) []ref.ActivityRef {
+	if refs == nil {
+		return nil
+	}
+	versionsById := map[string][]string{}
+	idURLs := map[string]*url.URL{}
+	for _, r := range refs {
+		if r.Id == nil || len(r.Version) == 0 {
+			continue
+		}
+		id := r.Id.String()
+		versionsById[id] = append(versionsById[id], r.Version)
+		idURLs[id] = r.Id
+	}
+	ids := make([]string, 0, len(versionsById))
+	for id := range versionsById {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	out := []ref.ActivityRef{}
+	for _, id := range s.ActivityIdSelector.SelectFrom(ids) {
+		versions := dedup(versionsById[id])
+		for _, v := range s.VersionSelector.SelectFrom(versions) {
+			u := *idURLs[id]
+			out = append(out, ref.ActivityRef{Id: &u, Version: v})
+		}
+	}
+	return out
+}
+
+func (s SeparatedVersionRefSelector) String() string {
+	if s.ActivityIdSelector == nil || s.VersionSelector == nil {
+		return "<nil>"
+	}
+	out := s.ActivityIdSelector.String()
+	if vs, isIndex := s.VersionSelector.(*OneIndexSelector[string]); !isIndex || vs.Index != -1 {
+		out += "@" + s.VersionSelector.String()
+	}
+	return out
+}
+
+//MarshalJSON encodes the selector as a JSON string in its string representation.
+func (s SeparatedVersionRefSelector) MarshalJSON() ([]byte, error) {
+	if s.ActivityIdSelector == nil || s.VersionSelector == nil {
+		return nil, aldberr.New(ErrorCodeInvalidSelector, "cannot marshal incomplete SeparatedVersionRefSelector", nil)
+	}
+	return json.Marshal(s.String())
+}
+
+func (s *SeparatedVersionRefSelector) UnmarshalJSON(bts []byte) error {
+	str := ""
+	if err := json.Unmarshal(bts, &str); err != nil {
+		return aldberr.Wrap(err, ErrorCodeInvalidSelector, "cannot unmarshal SeparatedVersionRefSelector: not a string", nil)
+	}
+	parsed, err := ParseVersionRefSelector(str)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+//dedup sorts the versions and removes duplicates.
+func dedup(versions []string) []string {
+	sort.Strings(versions)
+	out := versions[:0]
+	for i, v := range versions {
+		if i == 0 || v != versions[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/access"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+)
+
+//authorizing is implemented by stores that check permissions, notably access.Store.
+type authorizing interface {
+	Authorizer() *access.Authorizer
+}
+
+//authorize checks the permission of the entity of the request for the activity, if the store checks
+//permissions. Handlers only need it for work they do before (or besides) calling the store, e.g. receiving
+//an upload.
+func (s *Server) authorize(r *http.Request, rr resourceRequest, p access.Permission) error {
+	a, isAuthorizing := s.store.(authorizing)
+	if !isAuthorizing {
+		return nil
+	}
+	return a.Authorizer().CheckContext(r.Context(), rr.ref(""), p)
+}
+
+//getAccess explains whether an entity (by default the one of the request) has a permission (by default
+//read) for the activity, at a time (by default now). Explaining the access of another entity requires
+//access.PermissionAdminister.
+func (s *Server) getAccess(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	a, isAuthorizing := s.store.(authorizing)
+	if !isAuthorizing {
+		writeError(w, r, aldberr.New(ErrorCodeRouteNotFound, "access control is not enabled", map[string]interface{}{"path": r.URL.Path}))
+		return
+	}
+	req := access.ExplainRequest{Activity: rr.ref(""), Permission: access.PermissionRead}
+	q := r.URL.Query()
+	if raw := q.Get("permission"); len(raw) > 0 {
+		req.Permission = access.Permission(raw)
+		if !req.Permission.IsValid() {
+			writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "unknown permission", map[string]interface{}{"permission": raw}))
+			return
+		}
+	}
+	if raw := q.Get("at"); len(raw) > 0 {
+		at, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			writeError(w, r, aldberr.Wrap(err, ErrorCodeInvalidRequest, "invalid at parameter", map[string]interface{}{"at": raw}))
+			return
+		}
+		req.At = at
+	}
+	self, authenticated := access.EntityFrom(r.Context())
+	req.Entity = self
+	if raw := q.Get("entity"); len(raw) > 0 {
+		if err := req.Entity.FromName(raw); err != nil || !req.Entity.IsComplete() {
+			writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "invalid entity parameter", map[string]interface{}{"entity": raw}))
+			return
+		}
+	}
+	if !authenticated || req.Entity != self {
+		if err := a.Authorizer().CheckContext(r.Context(), req.Activity, access.PermissionAdminister); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	if !req.Entity.IsComplete() {
+		writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "entity parameter is required", nil))
+		return
+	}
+	res, err := a.Authorizer().Explain(r.Context(), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res.Explanation)
+}
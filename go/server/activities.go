@@ -0,0 +1,254 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/datetime"
+	"github.com/vital-dhaveloose/aldb/query"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+//listActivities lists the latest version of every activity that satisfies the optional filter parameter, see
+//query.Parse for its syntax, and that is current at the time or during the period of the optional current
+//parameter.
+func (s *Server) listActivities(w http.ResponseWriter, r *http.Request) {
+	var filter query.Filter
+	if raw := r.URL.Query().Get("filter"); len(raw) > 0 {
+		f, err := query.Parse(raw)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		filter = f
+	}
+	req := store.ListRequest{}
+	if raw := r.URL.Query().Get("current"); len(raw) > 0 {
+		p, err := parseCurrent(raw)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		req.Current = &p
+	}
+	res, err := s.store.List(r.Context(), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, localizeActivities(languagesFrom(r.Context()), query.Select(filter, res.Activities)))
+}
+
+//parseCurrent parses an RFC 3339 time or an ISO 8601 time interval, e.g. "2019-07-25/..".
+func parseCurrent(raw string) (datetime.Period, error) {
+	if strings.Contains(raw, "/") {
+		return datetime.ParseInterval(raw, nil)
+	}
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return datetime.Period{}, aldberr.Wrap(err, datetime.ErrorCodeInvalidPeriod, "invalid current parameter: not a time or an interval", map[string]interface{}{"current": raw})
+	}
+	return datetime.At(t), nil
+}
+
+//postActivity creates the first version of an activity.
+func (s *Server) postActivity(w http.ResponseWriter, r *http.Request) {
+	a := activity.Activity{}
+	if err := readJSON(r, &a); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if a.Id == nil || len(a.Id.String()) == 0 {
+		writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "activity has no id", nil))
+		return
+	}
+	rr := resourceRequest{id: a.Id.String()}
+	if _, found, err := s.latest(r, rr); err != nil {
+		writeError(w, r, err)
+		return
+	} else if found {
+		writeError(w, r, aldberr.New(store.ErrorCodeAlreadyExists, "activity already exists", map[string]interface{}{"id": rr.id}))
+		return
+	}
+	s.create(w, r, a, http.StatusCreated)
+}
+
+func (s *Server) getActivity(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	res, err := s.store.Read(r.Context(), store.ReadRequest{Ref: rr.ref(r.URL.Query().Get("version"))})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeActivity(w, r, http.StatusOK, res.Activity)
+}
+
+//putActivity creates a new version of the activity, or its first version if it doesn't exist yet.
+func (s *Server) putActivity(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	a, err := readActivity(r, rr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	latest, found, err := s.latest(r, rr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := checkIfMatch(r, latest, found); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	status := http.StatusOK
+	if !found {
+		status = http.StatusCreated
+	}
+	s.create(w, r, a, status)
+}
+
+func (s *Server) deleteActivity(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	if _, err := s.store.Delete(r.Context(), store.DeleteRequest{Ref: rr.ref("")}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listVersions(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	res, err := s.store.History(r.Context(), store.HistoryRequest{Ref: rr.ref("")})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, localizeActivities(languagesFrom(r.Context()), nonNilActivities(res.Versions)))
+}
+
+//postVersion creates a new version of an existing activity.
+func (s *Server) postVersion(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	a, err := readActivity(r, rr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	latest, found, err := s.latest(r, rr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !found {
+		writeError(w, r, aldberr.New(store.ErrorCodeNotFound, "activity not found", map[string]interface{}{"id": rr.id}))
+		return
+	}
+	if err := checkIfMatch(r, latest, found); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.create(w, r, a, http.StatusCreated)
+}
+
+func (s *Server) getVersion(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	res, err := s.store.Read(r.Context(), store.ReadRequest{Ref: rr.ref(rr.sub)})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeActivity(w, r, http.StatusOK, res.Activity)
+}
+
+//putVersion creates the version with the given version string. As versions are immutable, it fails if the
+//version already exists.
+func (s *Server) putVersion(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	a, err := readActivity(r, rr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(a.Version) > 0 && a.Version != rr.sub {
+		writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "version in body doesn't match the path", map[string]interface{}{"version": a.Version}))
+		return
+	}
+	a.Version = rr.sub
+	latest, found, err := s.latest(r, rr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := checkIfMatch(r, latest, found); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.create(w, r, a, http.StatusCreated)
+}
+
+func (s *Server) deleteVersion(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	if _, err := s.store.Delete(r.Context(), store.DeleteRequest{Ref: rr.ref(rr.sub)}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//region helpers
+
+//readActivity reads an activity from the body, of which the id must be empty or the id of the resource.
+func readActivity(r *http.Request, rr resourceRequest) (activity.Activity, error) {
+	a := activity.Activity{}
+	if err := readJSON(r, &a); err != nil {
+		return activity.Activity{}, err
+	}
+	if a.Id != nil && len(a.Id.String()) > 0 && a.Id.String() != rr.id {
+		return activity.Activity{}, aldberr.New(ErrorCodeInvalidRequest, "id in body doesn't match the path", map[string]interface{}{"id": a.Id.String()})
+	}
+	a.ActivityRef = rr.ref(a.Version)
+	return a, nil
+}
+
+//create creates a, writing the new version with the given status.
+func (s *Server) create(w http.ResponseWriter, r *http.Request, a activity.Activity, status int) {
+	res, err := s.store.Create(r.Context(), store.CreateRequest{ToCreate: a})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if status == http.StatusCreated {
+		w.Header().Set("Location", ActivityPath(res.Created.Id.String())+"/versions/"+url.PathEscape(res.Created.Version))
+	}
+	writeActivity(w, r, status, res.Created)
+}
+
+//latest reads the latest version of the activity, returning false if it doesn't exist.
+func (s *Server) latest(r *http.Request, rr resourceRequest) (activity.Activity, bool, error) {
+	res, err := s.store.Read(r.Context(), store.ReadRequest{Ref: rr.ref("")})
+	if err != nil {
+		if aldberr.IsCode(err, store.ErrorCodeNotFound) {
+			return activity.Activity{}, false, nil
+		}
+		return activity.Activity{}, false, err
+	}
+	return res.Activity, true, nil
+}
+
+//checkIfMatch checks the If-Match header of the request against the latest version. The check is not atomic
+//with the write that follows it, concurrent writes can still create diverging versions.
+func checkIfMatch(r *http.Request, latest activity.Activity, found bool) error {
+	v := ifMatch(r)
+	if len(v) == 0 {
+		return nil
+	}
+	if !found || (v != "*" && v != latest.Version) {
+		return aldberr.New(ErrorCodePreconditionFailed, "activity is not at the expected version", map[string]interface{}{"expected": v, "latest": latest.Version})
+	}
+	return nil
+}
+
+func nonNilActivities(as []activity.Activity) []activity.Activity {
+	if as == nil {
+		return []activity.Activity{}
+	}
+	return as
+}
+
+//endregion
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/access"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/auth"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/datetime"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+)
+
+//UserResponse is the body of GET /user.
+type UserResponse struct {
+	//Entity is a participation.Person or a participation.Organisation.
+	Entity      participation.Entity `json:"entity"`
+	UserContext UserContextResponse  `json:"userContext"`
+}
+
+type UserContextResponse struct {
+	Id           string                      `json:"id"`
+	Organisation *participation.Organisation `json:"organisation,omitempty"`
+	ValidPeriod  *datetime.Period            `json:"validPeriod,omitempty"`
+	Description  lang.LocalizableString      `json:"description,omitempty"`
+}
+
+//AuditRecord is a line of the audit log (see Server.SetAuditLog).
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	//RequestId is the id of the request, see RequestIdHeader.
+	RequestId string `json:"requestId"`
+	//User and UserContext are the user of the request, if it was authenticated.
+	User        *participation.EntityRef      `json:"user,omitempty"`
+	UserContext *participation.UserContextRef `json:"userContext,omitempty"`
+}
+
+//SetAuthentication makes the Server attribute requests to users. Requests without credentials remain
+//anonymous, which an access.Store refuses.
+func (s *Server) SetAuthentication(a *auth.Authentication) {
+	s.authentication = a
+}
+
+//SetAuditLog makes the Server write an AuditRecord per line to w for every request that isn't a successful
+//read.
+func (s *Server) SetAuditLog(w io.Writer) {
+	s.auditLog = w
+}
+
+//authenticate attributes the request to its user, if the Server has an Authentication. The user is then
+//available to handlers with auth.UserFrom, and its entity is checked by an access.Store.
+func (s *Server) authenticate(r *http.Request) (*http.Request, error) {
+	if s.authentication == nil {
+		return r, nil
+	}
+	u, found, err := s.authentication.Authenticate(r)
+	if err != nil || !found {
+		return r, err
+	}
+	ctx := auth.WithUser(r.Context(), u)
+	ctx = access.WithEntity(ctx, u.Entity.EntityRef())
+	return r.WithContext(ctx), nil
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	u, found := auth.UserFrom(r.Context())
+	if !found {
+		writeError(w, r, aldberr.New(access.ErrorCodeUnauthenticated, "request is not authenticated", nil))
+		return
+	}
+	p := languagesFrom(r.Context())
+	res := UserResponse{Entity: participation.Localized(u.Entity, p), UserContext: UserContextResponse{Id: u.Context.UserContextId, Description: u.Context.Description}}
+	if u.Context.Organisation.Ref.IsComplete() {
+		res.UserContext.Organisation = participation.Localized(&u.Context.Organisation, p).(*participation.Organisation)
+	}
+	if u.Context.ValidPeriod != (datetime.Period{}) {
+		res.UserContext.ValidPeriod = &u.Context.ValidPeriod
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+//audit writes an AuditRecord of every request that isn't a successful read to the audit log, if any.
+func (s *Server) audit(r *http.Request, status int) {
+	isRead := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+	if s.auditLog == nil || isRead && status < http.StatusBadRequest {
+		return
+	}
+	rec := AuditRecord{Time: time.Now().UTC(), Method: r.Method, Path: r.URL.EscapedPath(), Status: status, RequestId: RequestIdFrom(r.Context())}
+	if u, found := auth.UserFrom(r.Context()); found {
+		e := u.Entity.EntityRef()
+		rec.User, rec.UserContext = &e, &u.Context.UserContextRef
+	}
+	bts, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	s.auditLog.Write(append(bts, '\n'))
+}
+
+//statusRecorder remembers the status of a response for the audit log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(bts []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(bts)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/mediatype"
+	"github.com/vital-dhaveloose/aldb/ical"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+//getCalendar serves the activity and the activities that are (indirectly) part of it as an iCalendar feed (see
+//ical.Export), which calendar applications can subscribe to.
+func (s *Server) getCalendar(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	root, ok := s.readForPart(w, r, rr)
+	if !ok {
+		return
+	}
+	subtree, err := s.subtree(r, rr.ref(r.URL.Query().Get("version")))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err := ical.Export(buf, root, subtree, ical.ExportOptions{Languages: languagesFrom(r.Context())}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", ical.MediaType.String())
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(buf.Bytes())
+	}
+}
+
+//subtree returns the latest versions of the activities that are (indirectly) part of root, in breadth-first
+//order, each once. The Subs are the ones of the latest versions (which include the mirrored links, see
+//graph.Store), except for root if it has a Version.
+func (s *Server) subtree(r *http.Request, root ref.ActivityRef) ([]activity.Activity, error) {
+	out := []activity.Activity{}
+	seen := map[string]bool{root.Id.String(): true}
+	for queue := []ref.ActivityRef{root}; len(queue) > 0; queue = queue[1:] {
+		res, err := s.store.ReadSubs(r.Context(), store.ReadLinksRequest{Ref: queue[0]})
+		if err != nil {
+			return nil, err
+		}
+		for _, sub := range res.Activities {
+			if seen[sub.Id.String()] {
+				continue
+			}
+			seen[sub.Id.String()] = true
+			out = append(out, sub)
+			queue = append(queue, ref.ActivityRef{Id: sub.Id})
+		}
+	}
+	return out, nil
+}
+
+//postCalendar imports the events of the iCalendar body as Subs of the activity (see ical.Import), creating a
+//new version of the events that were imported before. The optional timeZone query parameter is the time zone
+//of dates and floating times, UTC by default. The events are created one by one, so an error can leave the
+//ones before it created.
+func (s *Server) postCalendar(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	if raw := r.Header.Get("Content-Type"); len(raw) > 0 {
+		mt, err := mediatype.Parse(raw)
+		if err != nil || mt.Essence() != ical.MediaType.Essence() {
+			writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "expected a body of type "+ical.MediaType.Essence(), map[string]interface{}{"contentType": raw}))
+			return
+		}
+	}
+	opts := ical.ImportOptions{Super: rr.ref("")}
+	if raw := r.URL.Query().Get("timeZone"); len(raw) > 0 {
+		loc, err := time.LoadLocation(raw)
+		if err != nil || raw == "Local" {
+			writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "unknown time zone", map[string]interface{}{"timeZone": raw}))
+			return
+		}
+		opts.Location = loc
+	}
+	if _, found, err := s.latest(r, rr); err != nil || !found {
+		if err == nil {
+			err = aldberr.New(store.ErrorCodeNotFound, "activity not found", map[string]interface{}{"id": rr.id})
+		}
+		writeError(w, r, err)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	events, err := ical.Import(bytes.NewReader(body), opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	created := make([]activity.Activity, 0, len(events))
+	for _, ev := range events {
+		res, err := s.store.Create(r.Context(), store.CreateRequest{ToCreate: ev})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		created = append(created, res.Created)
+	}
+	writeJSON(w, http.StatusOK, localizeActivities(languagesFrom(r.Context()), created))
+}
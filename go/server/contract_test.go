@@ -0,0 +1,1178 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/access"
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/auth"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/common/datetime"
+	"github.com/vital-dhaveloose/aldb/common/jsonschema"
+	"github.com/vital-dhaveloose/aldb/directory"
+	"github.com/vital-dhaveloose/aldb/graph"
+	"github.com/vital-dhaveloose/aldb/manifest"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+	"github.com/vital-dhaveloose/aldb/store/storetest"
+)
+
+//contract checks responses against the OpenAPI document in api/api.json.
+type contract struct {
+	uri       string
+	spec      map[string]interface{}
+	validator *jsonschema.Validator
+	//covered contains the "METHOD template status" of every checked response.
+	covered map[string]bool
+}
+
+func loadContract(t *testing.T) *contract {
+	path, err := filepath.Abs("../../api/api.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &contract{uri: "file://" + filepath.ToSlash(path), covered: map[string]bool{}}
+	if err := json.Unmarshal(bts, &c.spec); err != nil {
+		t.Fatal(err)
+	}
+	c.validator = jsonschema.NewValidator(func(uri string) ([]byte, error) {
+		return os.ReadFile(filepath.FromSlash(strings.TrimPrefix(uri, "file://")))
+	})
+	return c
+}
+
+func (c *contract) paths() map[string]interface{} {
+	return c.spec["paths"].(map[string]interface{})
+}
+
+//template returns the path template of the spec that matches the (escaped) request path.
+func (c *contract) template(escapedPath string) (string, bool) {
+	segments := strings.Split(strings.Trim(escapedPath, "/"), "/")
+	for tpl := range c.paths() {
+		tplSegments := strings.Split(strings.Trim(tpl, "/"), "/")
+		if len(tplSegments) != len(segments) {
+			continue
+		}
+		match := true
+		for i := range tplSegments {
+			if !strings.HasPrefix(tplSegments[i], "{") && tplSegments[i] != segments[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return tpl, true
+		}
+	}
+	return "", false
+}
+
+//check verifies that the response is declared by the spec and that its body satisfies the declared schema.
+func (c *contract) check(t *testing.T, method, escapedPath string, res *http.Response, body []byte) {
+	t.Helper()
+	tpl, found := c.template(escapedPath)
+	if !found {
+		t.Errorf("%s %s: path not in spec", method, escapedPath)
+		return
+	}
+	op, found := c.paths()[tpl].(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+	if !found {
+		t.Errorf("%s %s: operation not in spec", method, tpl)
+		return
+	}
+	status := http.StatusText(res.StatusCode)
+	code := strings.TrimSpace(strings.Split(res.Status, " ")[0])
+	location := "#/paths/" + escapePointer(tpl) + "/" + strings.ToLower(method) + "/responses/" + code
+	response, found := op["responses"].(map[string]interface{})[code].(map[string]interface{})
+	if !found {
+		t.Errorf("%s %s: response %s (%s) not in spec: %s", method, tpl, code, status, body)
+		return
+	}
+	if ref, isRef := response["$ref"].(string); isRef {
+		location = ref
+		response = c.resolve(ref)
+	}
+	c.covered[method+" "+tpl+" "+code] = true
+
+	if headers, found := response["headers"].(map[string]interface{}); found {
+		for name := range headers {
+			if len(res.Header.Get(name)) == 0 {
+				t.Errorf("%s %s: response %s has no %s header", method, tpl, code, name)
+			}
+		}
+	}
+	content, _ := response["content"].(map[string]interface{})
+	if len(content) == 0 {
+		if len(body) > 0 {
+			t.Errorf("%s %s: response %s has a body, but none is declared", method, tpl, code)
+		}
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		t.Errorf("%s %s: invalid Content-Type %q", method, tpl, res.Header.Get("Content-Type"))
+		return
+	}
+	if _, found := content[mediaType]; !found {
+		if _, any := content["*/*"]; !any {
+			t.Errorf("%s %s: response %s has undeclared Content-Type %s", method, tpl, code, mediaType)
+		}
+		return
+	}
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return
+	}
+	violations, err := c.validator.Validate(c.uri+location+"/content/"+escapePointer(mediaType)+"/schema", body)
+	if err != nil {
+		t.Errorf("%s %s: cannot validate response %s: %v", method, tpl, code, err)
+		return
+	}
+	for _, v := range violations {
+		t.Errorf("%s %s: response %s violates schema at %s: %s (%s)", method, tpl, code, v.InstancePointer, v.Message, v.SchemaLocation)
+	}
+}
+
+func (c *contract) resolve(ref string) map[string]interface{} {
+	var cur interface{} = c.spec
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		cur = cur.(map[string]interface{})[strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")]
+	}
+	return cur.(map[string]interface{})
+}
+
+//operations returns "METHOD template" for every operation in the spec, sorted.
+func (c *contract) operations() []string {
+	out := []string{}
+	for tpl, item := range c.paths() {
+		for method := range item.(map[string]interface{}) {
+			if method != "parameters" {
+				out = append(out, strings.ToUpper(method)+" "+tpl)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+//step is a request of the contract test. "{location}" in its path is replaced by the Location header of the
+//last response that had one.
+type step struct {
+	method      string
+	path        string
+	body        string
+	contentType string
+	ifMatch     string
+	headers     map[string]string
+	status      int
+}
+
+func TestContract(t *testing.T) {
+	c := loadContract(t)
+	gs, err := graph.NewStore(context.Background(), store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := manifest.NewMemoryRegistry()
+	server := New(manifest.NewValidatingStore(gs, registry), registry)
+	server.SetUploadDir(t.TempDir())
+	server.SetDirectory(directory.NewMemoryDirectory(), ref.ActivityRef{})
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	project := ActivityPath("https://aldb.test/activities/project")
+	task := ActivityPath("https://aldb.test/activities/task")
+	projo := url.PathEscape("https://projo.com/schemas/project")
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	ics := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:kick-off\r\nDTSTART:20200106T090000Z\r\nDTEND:20200106T100000Z\r\nSUMMARY:Kick-off\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+	steps := []step{
+		{method: "GET", path: "/user", status: 401},
+		{method: "GET", path: "/activities", status: 200},
+		{method: "POST", path: "/activities", body: `{"id":"https://aldb.test/activities/project","label":{"en-GB":"Project"},"period":{"startTime":"2020-01-01T00:00:00Z"}}`, status: 201},
+		{method: "POST", path: "/activities", body: `{"id":"https://aldb.test/activities/project"}`, status: 409},
+		{method: "POST", path: "/activities", body: `{"label":{"en-GB":"No id"}}`, status: 400},
+		{method: "POST", path: "/activities", body: `{"id":`, status: 400},
+		{method: "PUT", path: task, body: `{"label":{"en-GB":"Task"},"supers":[{"id":"https://aldb.test/activities/project"}]}`, status: 201},
+		{method: "PUT", path: task, body: `{"label":{"en-GB":"Task v2"}}`, status: 200},
+		{method: "PUT", path: task, body: `{"label":{"en-GB":"Task v3"}}`, ifMatch: `"0"`, status: 412},
+		{method: "PUT", path: project, body: `{"label":{"en-GB":"Cycle"},"supers":[{"id":"https://aldb.test/activities/task"}]}`, status: 409},
+		{method: "PUT", path: project, body: `{"id":"https://aldb.test/activities/other"}`, status: 400},
+		{method: "GET", path: "/activities", status: 200},
+		{method: "GET", path: project, status: 200},
+		{method: "GET", path: ActivityPath("https://aldb.test/activities/missing"), status: 404},
+		{method: "GET", path: task + "/versions", status: 200},
+		{method: "GET", path: ActivityPath("https://aldb.test/activities/missing") + "/versions", status: 404},
+		{method: "POST", path: task + "/versions", body: `{"label":{"en-GB":"Task v3"}}`, status: 201},
+		{method: "POST", path: ActivityPath("https://aldb.test/activities/missing") + "/versions", body: `{}`, status: 404},
+		{method: "POST", path: task + "/versions", body: `{"label":`, status: 400},
+		{method: "POST", path: task + "/versions", body: `{}`, ifMatch: `"0"`, status: 412},
+		{method: "PUT", path: task + "/versions/zzzz", body: `{"label":{"en-GB":"Task vz"}}`, status: 201},
+		{method: "PUT", path: task + "/versions/0", body: `{}`, status: 409},
+		{method: "PUT", path: task + "/versions/zzzz1", body: `{"version":"other"}`, status: 400},
+		{method: "PUT", path: task + "/versions/zzzz1", body: `{}`, ifMatch: `"0"`, status: 412},
+		{method: "GET", path: task + "/versions/zzzz", status: 200},
+		{method: "GET", path: task + "/versions/0", status: 404},
+		{method: "DELETE", path: task + "/versions/zzzz", status: 204},
+		{method: "DELETE", path: task + "/versions/zzzz", status: 404},
+
+		{method: "GET", path: "/manifests", status: 200},
+		{method: "POST", path: "/manifests", body: `{"id":"https://projo.com/schemas/project","version":"0001","schema":{"type":"object"}}`, status: 201},
+		{method: "POST", path: "/manifests", body: `{"id":"https://projo.com/schemas/project","schema":{"properties":{"priority":{"enum":["low","normal","high"]}}}}`, status: 201},
+		{method: "POST", path: "/manifests", body: `{"id":"https://projo.com/schemas/project","version":"0001","schema":{}}`, status: 409},
+		{method: "POST", path: "/manifests", body: `{"id":"https://projo.com/schemas/other"}`, status: 400},
+		{method: "GET", path: "/manifests", status: 200},
+		{method: "GET", path: "/manifests/" + projo, status: 200},
+		{method: "GET", path: "/manifests/" + url.PathEscape("https://projo.com/schemas/missing"), status: 404},
+		{method: "GET", path: "/manifests/" + projo + "/versions", status: 200},
+		{method: "GET", path: "/manifests/" + url.PathEscape("https://projo.com/schemas/missing") + "/versions", status: 404},
+		{method: "GET", path: "/manifests/" + projo + "/versions/0001", status: 200},
+		{method: "GET", path: "/manifests/" + projo + "/versions/0000", status: 404},
+
+		{method: "GET", path: project + "/attribute-sets", status: 200},
+		{method: "GET", path: ActivityPath("https://aldb.test/activities/missing") + "/attribute-sets", status: 404},
+		{method: "POST", path: project + "/attribute-sets", body: `{"manifest":{"id":"https://projo.com/schemas/project"},"attributes":{"priority":"normal"}}`, status: 201},
+		{method: "POST", path: project + "/attribute-sets", body: `{"manifest":{"id":"https://projo.com/schemas/project"}}`, status: 409},
+		{method: "POST", path: project + "/attribute-sets", body: `{"attributes":{}}`, status: 400},
+		{method: "POST", path: ActivityPath("https://aldb.test/activities/missing") + "/attribute-sets", body: `{"manifest":{"id":"https://projo.com/schemas/project"}}`, status: 404},
+		{method: "POST", path: project + "/attribute-sets", body: `{"manifest":{"id":"https://projo.com/schemas/x"}}`, ifMatch: `"0"`, status: 412},
+		{method: "GET", path: project + "/attribute-sets/" + projo, status: 200},
+		{method: "GET", path: project + "/attribute-sets/other", status: 404},
+		{method: "PUT", path: project + "/attribute-sets/" + projo, body: `{"manifest":{"id":"https://projo.com/schemas/project"},"attributes":{"priority":"high"}}`, status: 200},
+		{method: "PUT", path: project + "/attribute-sets/" + projo, body: `{"manifest":{"id":"https://projo.com/schemas/project"},"attributes":{"priority":"urgent"}}`, status: 400},
+		{method: "PUT", path: project + "/attribute-sets/notes", body: `{"attributes":{"text":"hi"}}`, status: 201},
+		{method: "PUT", path: project + "/attribute-sets/notes", body: `[]`, status: 400},
+		{method: "PUT", path: ActivityPath("https://aldb.test/activities/missing") + "/attribute-sets/notes", body: `{}`, status: 404},
+		{method: "PUT", path: project + "/attribute-sets/notes", body: `{}`, ifMatch: `"0"`, status: 412},
+		{method: "DELETE", path: project + "/attribute-sets/notes", status: 204},
+		{method: "DELETE", path: project + "/attribute-sets/notes", status: 404},
+		{method: "DELETE", path: project + "/attribute-sets/" + projo, ifMatch: `"0"`, status: 412},
+		{method: "GET", path: project + "/attribute-sets/notes", status: 404},
+		{method: "GET", path: "/activities?filter=" + url.QueryEscape(`manifest "https://projo.com/schemas/project" { priority = "high" }`), status: 200},
+		{method: "GET", path: "/activities?filter=" + url.QueryEscape(`priority = high`), status: 400},
+		{method: "GET", path: "/activities?current=" + url.QueryEscape("2019-07-25T10:00:00+02:00"), status: 200},
+		{method: "GET", path: "/activities?current=" + url.QueryEscape("2019-07-25/.."), status: 200},
+		{method: "GET", path: "/activities?current=yesterday", status: 400},
+		{method: "GET", path: "/activities?current=" + url.QueryEscape("2019-07-25/2019-07-01"), status: 400},
+
+		{method: "GET", path: project + "/blob", status: 404},
+		{method: "PUT", path: project + "/blob", body: "# Notes", contentType: "text/markdown", status: 201},
+		{method: "PUT", path: project + "/blob", body: "# Notes v2", contentType: "text/markdown; charset=utf-8", status: 200},
+		{method: "PUT", path: project + "/blob", body: "x", contentType: "text", status: 400},
+		{method: "PUT", path: project + "/blob", body: png, contentType: "text/markdown", status: 400},
+		{method: "PUT", path: ActivityPath("https://aldb.test/activities/missing") + "/blob", body: "x", contentType: "text/plain", status: 404},
+		{method: "PUT", path: project + "/blob", body: "x", contentType: "text/plain", ifMatch: `"0"`, status: 412},
+		{method: "GET", path: project + "/blob", status: 200},
+		{method: "GET", path: project + "/blob", headers: map[string]string{"Range": "bytes=0-1"}, status: 206},
+		{method: "GET", path: project + "/blob", headers: map[string]string{"Range": "bytes=100-200"}, status: 416},
+		{method: "PUT", path: project + "/blob?rendition=thumbnail", body: png, contentType: "image/png", status: 201},
+		{method: "GET", path: project + "/blob?rendition=thumbnail", status: 200},
+		{method: "GET", path: project + "/blob?rendition=ocr-text", status: 404},
+		{method: "GET", path: project + "/blob?rendition=" + url.QueryEscape("Ocr Text"), status: 400},
+		{method: "PUT", path: project + "/blob", body: "# Notes v3", contentType: "text/markdown", headers: map[string]string{"Repr-Digest": digest("# Notes v3")}, status: 200},
+		{method: "PUT", path: project + "/blob", body: "# Notes v4", contentType: "text/markdown", headers: map[string]string{"Repr-Digest": digest("# Notes v3")}, status: 400},
+		{method: "PUT", path: project + "/blob?rendition=thumbnail", body: png, contentType: "image/png", status: 201},
+		{method: "DELETE", path: project + "/blob?rendition=thumbnail", status: 204},
+		{method: "DELETE", path: project + "/blob?rendition=thumbnail", status: 404},
+
+		{method: "POST", path: project + "/blob/uploads", body: `{"rendition":"Ocr Text","mediaType":"text/plain"}`, status: 400},
+		{method: "POST", path: ActivityPath("https://aldb.test/activities/missing") + "/blob/uploads", body: `{"mediaType":"text/plain"}`, status: 404},
+		{method: "POST", path: project + "/blob/uploads", body: `{"mediaType":"text/plain"}`, status: 201},
+		{method: "GET", path: "{location}", status: 200},
+		{method: "PATCH", path: "{location}", body: "hello ", contentType: "application/octet-stream", headers: map[string]string{"Upload-Offset": "0"}, status: 204},
+		{method: "PATCH", path: "{location}", body: "hello ", contentType: "application/octet-stream", headers: map[string]string{"Upload-Offset": "0"}, status: 409},
+		{method: "PATCH", path: "{location}", body: "world", contentType: "application/octet-stream", status: 400},
+		{method: "PATCH", path: "{location}", body: "world", contentType: "application/octet-stream", headers: map[string]string{"Upload-Offset": "6"}, status: 204},
+		{method: "PUT", path: "{location}", ifMatch: `"0"`, status: 412},
+		{method: "PUT", path: "{location}", status: 200},
+		{method: "GET", path: "{location}", status: 404},
+		{method: "PATCH", path: "{location}", body: "x", contentType: "application/octet-stream", headers: map[string]string{"Upload-Offset": "11"}, status: 404},
+		{method: "PUT", path: "{location}", status: 404},
+		{method: "POST", path: project + "/blob/uploads", body: `{"rendition":"thumbnail"}`, status: 201},
+		{method: "DELETE", path: "{location}", status: 204},
+		{method: "DELETE", path: "{location}", status: 404},
+
+		{method: "PUT", path: project + "/blob", body: png, status: 200},
+		{method: "DELETE", path: project + "/blob", ifMatch: `"0"`, status: 412},
+		{method: "DELETE", path: project + "/blob", status: 204},
+		{method: "DELETE", path: project + "/blob", status: 404},
+
+		{method: "GET", path: project + "/participations", status: 200},
+		{method: "GET", path: ActivityPath("https://aldb.test/activities/missing") + "/participations", status: 404},
+		{method: "POST", path: project + "/participations", body: `{"participator":{"givenName":"John","familyName":"Doe","email":"john@doe.eu"},"roles":["https://projo.com/roles/lead"]}`, status: 201},
+		{method: "POST", path: project + "/participations", body: `{"participator":{"display":"Team Blue"},"roles":["https://projo.com/roles/member","https://projo.com/roles/reviewer"]}`, status: 201},
+		{method: "POST", path: project + "/participations", body: `{"roles":"https://projo.com/roles/member"}`, status: 400},
+		{method: "POST", path: ActivityPath("https://aldb.test/activities/missing") + "/participations", body: `{}`, status: 404},
+		{method: "POST", path: project + "/participations", body: `{}`, ifMatch: `"0"`, status: 412},
+		{method: "PUT", path: project + "/participations", body: `[{"participator":{"display":"Team Blue"},"roles":["https://projo.com/roles/member"],"period":{"startTime":"2020-02-01T00:00:00Z"}}]`, status: 200},
+		{method: "PUT", path: project + "/participations", body: `{}`, status: 400},
+		{method: "PUT", path: ActivityPath("https://aldb.test/activities/missing") + "/participations", body: `[]`, status: 404},
+		{method: "PUT", path: project + "/participations", body: `[]`, ifMatch: `"0"`, status: 412},
+		{method: "GET", path: project + "/participations", status: 200},
+		{method: "DELETE", path: project + "/participations", ifMatch: `"0"`, status: 412},
+		{method: "DELETE", path: project + "/participations", status: 204},
+		{method: "DELETE", path: ActivityPath("https://aldb.test/activities/missing") + "/participations", status: 404},
+
+		{method: "POST", path: project + "/calendar", body: ics, contentType: "text/calendar", status: 200},
+		{method: "POST", path: project + "/calendar", body: "BEGIN:VCALENDAR\r\n", contentType: "text/calendar", status: 400},
+		{method: "POST", path: project + "/calendar", body: ics, status: 400},
+		{method: "POST", path: project + "/calendar?timeZone=Mars", body: ics, contentType: "text/calendar", status: 400},
+		{method: "POST", path: ActivityPath("https://aldb.test/activities/missing") + "/calendar", body: ics, contentType: "text/calendar", status: 404},
+		{method: "GET", path: project + "/calendar", status: 200},
+		{method: "GET", path: ActivityPath("https://aldb.test/activities/missing") + "/calendar", status: 404},
+
+		{method: "GET", path: project + "/access", status: 404},
+
+		{method: "GET", path: "/entities", status: 200},
+		{method: "POST", path: "/entities", body: `{"entity":"entities/alice","givenName":"Alice","email":"alice@doe.eu"}`, status: 201},
+		{method: "GET", path: "{location}", status: 200},
+		{method: "POST", path: "/entities", body: `{"entity":"entities/alice","givenName":"Alice"}`, status: 409},
+		{method: "POST", path: "/entities", body: `{"givenName":"Nobody"}`, status: 400},
+		{method: "POST", path: "/entities", body: `{"entity":"entities/team-blue","kind":"group","display":"Team Blue"}`, status: 201},
+		{method: "GET", path: "/entities?email=ALICE@doe.eu", status: 200},
+		{method: "POST", path: "/entities", body: "BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Alice Doe\r\nEMAIL:alice@doe.eu\r\nEND:VCARD\r\nBEGIN:VCARD\r\nVERSION:4.0\r\nFN:Dan\r\nEMAIL:dan@doe.eu\r\nEND:VCARD\r\n", contentType: "text/vcard", status: 200},
+		{method: "POST", path: "/entities", body: "BEGIN:VCARD\r\n", contentType: "text/vcard", status: 400},
+		{method: "GET", path: "/entities", headers: map[string]string{"Accept": "text/vcard"}, status: 200},
+		{method: "GET", path: "/entities/missing", status: 404},
+		{method: "PUT", path: "/entities/bob", body: `{"givenName":"Bob"}`, status: 201},
+		{method: "PUT", path: "/entities/bob", body: `{"givenName":"Bob","familyName":"Doe"}`, status: 200},
+		{method: "PUT", path: "/entities/bob", body: `{"entity":"entities/other","givenName":"Bob"}`, status: 400},
+		{method: "PUT", path: "/entities/bob", body: `{"givenName":"Bob","email":"Alice@doe.eu"}`, status: 409},
+		{method: "PUT", path: "/entities/team-blue/members", body: `["entities/alice","entities/bob"]`, status: 200},
+		{method: "PUT", path: "/entities/team-blue/members", body: `["entities/team-blue"]`, status: 409},
+		{method: "PUT", path: "/entities/team-blue/members", body: `["entities/missing"]`, status: 404},
+		{method: "PUT", path: "/entities/alice/members", body: `[]`, status: 400},
+		{method: "GET", path: "/entities/team-blue/members", status: 200},
+		{method: "GET", path: "/entities/team-blue/members?transitive=maybe", status: 400},
+		{method: "GET", path: "/entities/missing/members", status: 404},
+		{method: "GET", path: "/entities/alice/groups?transitive=true", status: 200},
+		{method: "GET", path: "/entities/alice/groups?transitive=maybe", status: 400},
+		{method: "GET", path: "/entities/missing/groups", status: 404},
+		{method: "GET", path: "/hosts/viwi.eu/entities/carol", status: 404},
+		{method: "PUT", path: "/hosts/viwi.eu/entities/carol", body: `{"givenName":"Carol"}`, status: 201},
+		{method: "PUT", path: "/hosts/viwi.eu/entities/carol", body: `{"entity":"entities/carol","givenName":"Carol"}`, status: 400},
+		{method: "PUT", path: "/hosts/viwi.eu/entities/carol", body: `{"entity":"hosts/viwi.eu/entities/carol","givenName":"Carol","email":"carol@viwi.eu"}`, status: 200},
+		{method: "PUT", path: "/hosts/viwi.eu/entities/carol", body: `{"givenName":"Carol","email":"alice@doe.eu"}`, status: 409},
+		{method: "GET", path: "/hosts/viwi.eu/entities/carol", status: 200},
+		{method: "DELETE", path: "/hosts/viwi.eu/entities/carol", status: 204},
+		{method: "DELETE", path: "/hosts/viwi.eu/entities/carol", status: 404},
+		{method: "DELETE", path: "/entities/bob", status: 204},
+		{method: "DELETE", path: "/entities/bob", status: 404},
+
+		{method: "GET", path: project, status: 200},
+		{method: "DELETE", path: task, status: 204},
+		{method: "DELETE", path: task, status: 404},
+	}
+	location := ""
+	for _, s := range steps {
+		s.path = strings.ReplaceAll(s.path, "{location}", location)
+		req, err := http.NewRequest(s.method, srv.URL+s.path, strings.NewReader(s.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.contentType) > 0 {
+			req.Header.Set("Content-Type", s.contentType)
+		} else if len(s.body) > 0 && !strings.HasSuffix(s.path, "/blob") {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if len(s.ifMatch) > 0 {
+			req.Header.Set("If-Match", s.ifMatch)
+		}
+		for k, v := range s.headers {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := readAll(t, res)
+		if res.StatusCode != s.status {
+			t.Errorf("%s %s: expected status %d, got %d: %s", s.method, s.path, s.status, res.StatusCode, body)
+		}
+		c.check(t, s.method, req.URL.EscapedPath(), res, body)
+		if l := res.Header.Get("Location"); len(l) > 0 {
+			location = l
+		}
+	}
+
+	for _, op := range c.operations() {
+		covered := false
+		for k := range c.covered {
+			if strings.HasPrefix(k, op+" ") {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			t.Errorf("operation %s is not covered by the contract test", op)
+		}
+	}
+}
+
+func TestContractRoundTripsBlob(t *testing.T) {
+	gs, err := graph.NewStore(context.Background(), store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(New(gs, nil))
+	defer srv.Close()
+
+	path := ActivityPath("https://aldb.test/activities/doc")
+	res, err := http.Post(srv.URL+"/activities", "application/json", strings.NewReader(`{"id":"https://aldb.test/activities/doc"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, res)
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+path+"/blob", strings.NewReader("\x00\x01binary"))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, res)
+
+	if res, err = http.Get(srv.URL + path + "/blob"); err != nil {
+		t.Fatal(err)
+	}
+	if body := readAll(t, res); string(body) != "\x00\x01binary" || res.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("unexpected blob %q of type %s", body, res.Header.Get("Content-Type"))
+	}
+	if res.Header.Get("Repr-Digest") != digest("\x00\x01binary") {
+		t.Errorf("unexpected Repr-Digest %s", res.Header.Get("Repr-Digest"))
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+path+"/blob", nil)
+	req.Header.Set("Range", "bytes=2-")
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if body := readAll(t, res); string(body) != "binary" || res.Header.Get("Content-Range") != "bytes 2-7/8" {
+		t.Errorf("unexpected range %q (%s)", body, res.Header.Get("Content-Range"))
+	}
+
+	//without a Content-Type, the media type is detected, and the content read for it isn't lost
+	pdf := "%PDF-1.7\n" + strings.Repeat("x", 1000)
+	req, _ = http.NewRequest(http.MethodPut, srv.URL+path+"/blob", strings.NewReader(pdf))
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, res)
+	if res, err = http.Get(srv.URL + path + "/blob"); err != nil {
+		t.Fatal(err)
+	}
+	if body := readAll(t, res); string(body) != pdf || res.Header.Get("Content-Type") != "application/pdf" {
+		t.Errorf("unexpected detected blob of %d bytes of type %s", len(body), res.Header.Get("Content-Type"))
+	}
+}
+
+func TestUploadResumes(t *testing.T) {
+	gs, err := graph.NewStore(context.Background(), store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := New(gs, nil)
+	server.SetUploadDir(t.TempDir())
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+	do := func(method, path, offset, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if len(offset) > 0 {
+			req.Header.Set("Upload-Offset", offset)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	path := ActivityPath("https://aldb.test/activities/doc")
+	readAll(t, do(http.MethodPost, "/activities", "", `{"id":"https://aldb.test/activities/doc"}`))
+	res := do(http.MethodPost, path+"/blob/uploads", "", `{"mediaType":"text/plain"}`)
+	readAll(t, res)
+	upload := res.Header.Get("Location")
+	readAll(t, do(http.MethodPatch, upload, "0", "resumable "))
+
+	//a client that lost track of the offset asks for it, and sends the rest from there
+	res = do(http.MethodGet, upload, "", "")
+	status := UploadStatus{}
+	if err := json.Unmarshal(readAll(t, res), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Offset != 10 || res.Header.Get("Upload-Offset") != "10" {
+		t.Fatalf("unexpected offset %d (%s)", status.Offset, res.Header.Get("Upload-Offset"))
+	}
+	readAll(t, do(http.MethodPatch, upload, "10", "upload"))
+	if res = do(http.MethodPut, upload, "", ""); res.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status %d completing upload: %s", res.StatusCode, readAll(t, res))
+	}
+	manifest := blob.BlobManifest{}
+	if err := json.Unmarshal(readAll(t, res), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Size != 16 || manifest.MediaType.String() != "text/plain" {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+
+	res = do(http.MethodGet, path+"/blob", "", "")
+	if body := readAll(t, res); string(body) != "resumable upload" {
+		t.Errorf("unexpected blob %q", body)
+	}
+}
+
+func TestListActivitiesFilter(t *testing.T) {
+	srv := httptest.NewServer(New(store.NewMemoryStore(), nil))
+	defer srv.Close()
+
+	for _, body := range []string{
+		`{"id":"https://aldb.test/activities/a","attributeSets":{"projo-attrs":{"attributes":{"totalBudget":{"amount":123000}}}}}`,
+		`{"id":"https://aldb.test/activities/b","attributeSets":{"projo-attrs":{"attributes":{"totalBudget":{"amount":456000}}}},"period":{"startTime":"2019-07-01T00:00:00Z","endTime":"2019-08-01T00:00:00Z"}}`,
+	} {
+		res, err := http.Post(srv.URL+"/activities", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAll(t, res)
+	}
+	res, err := http.Get(srv.URL + "/activities?filter=" + url.QueryEscape("projo-attrs:totalBudget.amount > 200000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := []activity.Activity{}
+	if err := json.Unmarshal(readAll(t, res), &found); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Id.String() != "https://aldb.test/activities/b" {
+		t.Errorf("unexpected activities %v", found)
+	}
+
+	if res, err = http.Get(srv.URL + "/activities?current=" + url.QueryEscape("2019-08-01/..")); err != nil {
+		t.Fatal(err)
+	}
+	found = []activity.Activity{}
+	if err := json.Unmarshal(readAll(t, res), &found); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Id.String() != "https://aldb.test/activities/a" {
+		t.Errorf("unexpected current activities %v", found)
+	}
+}
+
+func TestCalendarRoundTrips(t *testing.T) {
+	gs, err := graph.NewStore(context.Background(), store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(New(gs, nil))
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/activities", "application/json", strings.NewReader(`{"id":"https://aldb.test/activities/team","label":{"en-GB":"Team"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, res)
+	team := srv.URL + ActivityPath("https://aldb.test/activities/team")
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR", "VERSION:2.0",
+		"BEGIN:VEVENT", "UID:standup@example.com", "DTSTART;TZID=Europe/Brussels:20200106T093000", "DURATION:PT15M",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE,FR", "SUMMARY:Stand-up", "DESCRIPTION:Yesterday\\, today\\, blockers",
+		"ORGANIZER;CN=Jane Doe:mailto:jane@doe.eu", "END:VEVENT",
+		"END:VCALENDAR", "",
+	}, "\r\n")
+	res, err = http.Post(team+"/calendar?timeZone=Europe/Brussels", "text/calendar", strings.NewReader(ics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := []activity.Activity{}
+	if err := json.Unmarshal(readAll(t, res), &created); err != nil || len(created) != 1 {
+		t.Fatalf("unexpected import %v (%v)", created, err)
+	}
+	if id := created[0].Id.String(); id != "https://aldb.test/activities/team/events/standup@example.com" {
+		t.Errorf("unexpected id %s", id)
+	}
+
+	if res, err = http.Get(team + "/calendar"); err != nil {
+		t.Fatal(err)
+	}
+	feed := string(readAll(t, res))
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+		t.Errorf("unexpected Content-Type %s", ct)
+	}
+	for _, line := range []string{
+		"X-WR-CALNAME;LANGUAGE=en-GB:Team",
+		"UID:https://aldb.test/activities/team/events/standup@example.com",
+		"DTSTART;TZID=Europe/Brussels:20200106T093000",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE,FR",
+		"DESCRIPTION:Yesterday\\, today\\, blockers",
+		"ORGANIZER;CN=Jane Doe:mailto:jane@doe.eu",
+		"BEGIN:VTIMEZONE",
+	} {
+		if !strings.Contains(feed, line+"\r\n") {
+			t.Errorf("expected %q in feed:\n%s", line, feed)
+		}
+	}
+}
+
+func TestAddressBookRoundTrips(t *testing.T) {
+	gs, err := graph.NewStore(context.Background(), store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := New(gs, nil)
+	d := directory.NewMemoryDirectory()
+	server.SetDirectory(d, ref.ActivityRef{})
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/entities", "application/json", strings.NewReader(`{"entity":"entities/alice","givenName":"Alice","email":"alice@doe.eu"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, res)
+	vcards := strings.Join([]string{
+		"BEGIN:VCARD", "VERSION:4.0", "FN:Alice Doe", "EMAIL:ALICE@doe.eu", "TEL;VALUE=uri:tel:+32-2-123-45-67", "END:VCARD",
+		"BEGIN:VCARD", "VERSION:4.0", "KIND:group", "FN:Team Blue", "EMAIL:blue@doe.eu", "END:VCARD",
+		"",
+	}, "\r\n")
+	res, err = http.Post(srv.URL+"/entities", "text/vcard", strings.NewReader(vcards))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readAll(t, res)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the address book to be imported, got %d: %s", res.StatusCode, body)
+	}
+	list, err := d.List(context.Background(), directory.ListRequest{})
+	if err != nil || len(list.Entities) != 2 {
+		t.Fatalf("expected alice to be merged and the group to be created, got %v (%v)", list.Entities, err)
+	}
+	alice, err := d.Read(context.Background(), directory.ReadRequest{Ref: participation.EntityRef{EntityId: "alice"}})
+	if err != nil || len(alice.Entity.(*participation.Person).Contact.Phones) != 1 {
+		t.Errorf("expected alice to get the phone number of her card, got %v (%v)", alice.Entity, err)
+	}
+	if team, err := d.List(context.Background(), directory.ListRequest{Email: "blue@doe.eu"}); err != nil || len(team.Entities) != 1 || !team.Entities[0].EntityRef().IsComplete() {
+		t.Errorf("expected the group to be created with a new entity ref, got %v (%v)", team.Entities, err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/entities", nil)
+	req.Header.Set("Accept", "application/json;q=0.5, text/vcard")
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	cards := string(readAll(t, res))
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/vcard") {
+		t.Errorf("unexpected Content-Type %s", ct)
+	}
+	for _, line := range []string{"UID;VALUE=text:entities/alice", "KIND:group", "FN:Team Blue"} {
+		if !strings.Contains(cards, line+"\r\n") {
+			t.Errorf("expected %q in address book:\n%s", line, cards)
+		}
+	}
+}
+
+func readAll(t *testing.T, res *http.Response) []byte {
+	t.Helper()
+	defer res.Body.Close()
+	bts, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bts
+}
+
+//digest returns the Repr-Digest header for content.
+func digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return formatDigest(hex.EncodeToString(sum[:]))
+}
+
+func TestRefRoutes(t *testing.T) {
+	c := loadContract(t)
+	routes := append(ref.ActivityPattern.Routes(), ref.ManifestPattern.Routes()...)
+	routes = append(routes, participation.EntityPattern.Routes()...)
+	//attribute sets of a specific version aren't exposed
+	routes = append(routes, ref.AttributeSetPattern.Routes()[0])
+	for _, route := range routes {
+		if _, found := c.template(route); !found {
+			t.Errorf("route %s is not in the spec", route)
+		}
+	}
+	if path := ActivityPath("https://aldb.test/a"); path != "/activities/https:%2F%2Faldb.test%2Fa" {
+		t.Errorf("unexpected path %s", path)
+	}
+}
+
+func TestAccess(t *testing.T) {
+	c := loadContract(t)
+	gs, err := graph.NewStore(context.Background(), store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := access.Policy{Roles: map[string]access.Role{
+		"https://aldb.test/roles/lead":     {Permissions: []access.Permission{access.PermissionAdminister}},
+		"https://aldb.test/roles/excluded": {Permissions: []access.Permission{access.PermissionRead}, Deny: true},
+	}}
+	as := access.NewStore(gs, policy)
+	participant := func(id, role string) participation.Participation {
+		return participation.Participation{
+			Entity: &participation.Person{Ref: participation.EntityRef{EntityId: id}},
+			Role:   &participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: "https://aldb.test/roles/" + role}},
+		}
+	}
+	project := storetest.NewActivity("project", "", "Project")
+	project.Participations = []participation.Participation{participant("alice", "lead")}
+	task := storetest.NewActivity("task", "", "Task")
+	task.Supers = []*activity.Activity{{ActivityRef: storetest.Ref("project", "")}}
+	task.Participations = []participation.Participation{participant("bob", "excluded")}
+	for _, a := range []activity.Activity{project, task} {
+		if _, err := as.Create(access.AsSystem(context.Background()), store.CreateRequest{ToCreate: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := New(as, nil)
+	//authentication is left to the application, here the entity id is taken from a header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get("X-Entity"); len(id) > 0 {
+			r = r.WithContext(access.WithEntity(r.Context(), participation.EntityRef{EntityId: id}))
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	projectPath := ActivityPath("https://aldb.test/activities/project")
+	taskPath := ActivityPath("https://aldb.test/activities/task")
+	for _, s := range []struct {
+		entity string
+		path   string
+		status int
+	}{
+		{"", projectPath, 401},
+		{"bob", taskPath, 403},
+		{"alice", taskPath, 200},
+		{"bob", taskPath + "/access", 200},
+		{"bob", taskPath + "/access?entity=entities/alice", 403},
+		{"alice", taskPath + "/access?entity=entities/bob&permission=write", 200},
+		{"alice", taskPath + "/access?permission=fly", 400},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+s.path, nil)
+		if len(s.entity) > 0 {
+			req.Header.Set("X-Entity", s.entity)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := readAll(t, res)
+		if res.StatusCode != s.status {
+			t.Errorf("GET %s as %q: expected %d, got %d: %s", s.path, s.entity, s.status, res.StatusCode, body)
+			continue
+		}
+		c.check(t, http.MethodGet, strings.Split(s.path, "?")[0], res, body)
+		if s.status != 200 || !strings.HasSuffix(strings.Split(s.path, "?")[0], "/access") {
+			continue
+		}
+		x := access.Explanation{}
+		if err := json.Unmarshal(body, &x); err != nil {
+			t.Fatal(err)
+		}
+		if x.Allowed || x.Decisive == nil || x.Decisive.Role != "https://aldb.test/roles/excluded" {
+			t.Errorf("expected bob to be denied by the excluded role, got %s", body)
+		}
+	}
+}
+
+func TestUploadAccess(t *testing.T) {
+	c := loadContract(t)
+	gs, err := graph.NewStore(context.Background(), store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := access.Policy{Roles: map[string]access.Role{
+		"https://aldb.test/roles/lead":  {Permissions: []access.Permission{access.PermissionAdminister}},
+		"https://aldb.test/roles/guest": {Permissions: []access.Permission{access.PermissionRead}},
+	}}
+	as := access.NewStore(gs, policy)
+	project := storetest.NewActivity("project", "", "Project")
+	for id, role := range map[string]string{"alice": "lead", "bob": "guest"} {
+		project.Participations = append(project.Participations, participation.Participation{
+			Entity: &participation.Person{Ref: participation.EntityRef{EntityId: id}},
+			Role:   &participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: "https://aldb.test/roles/" + role}},
+		})
+	}
+	if _, err := as.Create(access.AsSystem(context.Background()), store.CreateRequest{ToCreate: project}); err != nil {
+		t.Fatal(err)
+	}
+	server := New(as, nil)
+	server.SetUploadDir(t.TempDir())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get("X-Entity"); len(id) > 0 {
+			r = r.WithContext(access.WithEntity(r.Context(), participation.EntityRef{EntityId: id}))
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	location := ""
+	for _, s := range []struct {
+		entity string
+		method string
+		path   string
+		status int
+	}{
+		{"alice", "POST", ActivityPath("https://aldb.test/activities/project") + "/blob/uploads", 201},
+		{"", "GET", "{location}", 401},
+		{"bob", "GET", "{location}", 403},
+		{"bob", "DELETE", "{location}", 403},
+		{"alice", "GET", "{location}", 200},
+		{"alice", "DELETE", "{location}", 204},
+	} {
+		s.path = strings.ReplaceAll(s.path, "{location}", location)
+		body := ""
+		if s.method == "POST" {
+			body = `{"mediaType":"text/plain"}`
+		}
+		req, _ := http.NewRequest(s.method, srv.URL+s.path, strings.NewReader(body))
+		if len(body) > 0 {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if len(s.entity) > 0 {
+			req.Header.Set("X-Entity", s.entity)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resBody := readAll(t, res)
+		if res.StatusCode != s.status {
+			t.Errorf("%s %s as %q: expected %d, got %d: %s", s.method, s.path, s.entity, s.status, res.StatusCode, resBody)
+			continue
+		}
+		c.check(t, s.method, req.URL.EscapedPath(), res, resBody)
+		if l := res.Header.Get("Location"); len(l) > 0 {
+			location = l
+		}
+	}
+}
+
+func TestDirectoryAccess(t *testing.T) {
+	c := loadContract(t)
+	gs, err := graph.NewStore(context.Background(), store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := access.Policy{Roles: map[string]access.Role{
+		"https://aldb.test/roles/lead":   {Permissions: []access.Permission{access.PermissionAdminister}},
+		"https://aldb.test/roles/member": {Permissions: []access.Permission{access.PermissionRead}},
+	}}
+	as := access.NewStore(gs, policy)
+	d := directory.NewMemoryDirectory()
+	as.Authorizer().SetDirectory(d)
+	org := storetest.NewActivity("org", "", "Organisation")
+	org.Participations = []participation.Participation{{
+		Entity: &participation.Person{Ref: participation.EntityRef{EntityId: "alice"}},
+		Role:   &participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: "https://aldb.test/roles/lead"}},
+	}}
+	project := storetest.NewActivity("project", "", "Project")
+	project.Participations = []participation.Participation{{
+		Entity: &participation.Group{Ref: participation.EntityRef{EntityId: "team-blue"}},
+		Role:   &participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: "https://aldb.test/roles/member"}},
+	}}
+	for _, a := range []activity.Activity{org, project} {
+		if _, err := as.Create(access.AsSystem(context.Background()), store.CreateRequest{ToCreate: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := New(as, nil)
+	server.SetDirectory(d, storetest.Ref("org", ""))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get("X-Entity"); len(id) > 0 {
+			r = r.WithContext(access.WithEntity(r.Context(), participation.EntityRef{EntityId: id}))
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	projectPath := ActivityPath("https://aldb.test/activities/project")
+	for _, s := range []struct {
+		entity string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"", "GET", "/entities", "", 401},
+		{"bob", "GET", "/entities", "", 200},
+		{"bob", "POST", "/entities", `{"entity":"entities/bob","givenName":"Bob"}`, 403},
+		{"alice", "POST", "/entities", `{"entity":"entities/bob","givenName":"Bob"}`, 201},
+		{"alice", "PUT", "/entities/team-blue", `{"kind":"group","display":"Team Blue"}`, 201},
+		{"bob", "GET", projectPath, "", 403},
+		{"bob", "PUT", "/entities/team-blue/members", `["entities/bob"]`, 403},
+		{"alice", "PUT", "/entities/team-blue/members", `["entities/bob"]`, 200},
+		{"bob", "GET", "/entities/bob/groups", "", 200},
+		//the members of a group get the roles of its participations
+		{"bob", "GET", projectPath, "", 200},
+		{"bob", "DELETE", "/entities/team-blue", "", 403},
+		{"alice", "DELETE", "/entities/team-blue", "", 204},
+		{"bob", "GET", projectPath, "", 403},
+	} {
+		req, _ := http.NewRequest(s.method, srv.URL+s.path, strings.NewReader(s.body))
+		if len(s.body) > 0 {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if len(s.entity) > 0 {
+			req.Header.Set("X-Entity", s.entity)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := readAll(t, res)
+		if res.StatusCode != s.status {
+			t.Errorf("%s %s as %q: expected %d, got %d: %s", s.method, s.path, s.entity, s.status, res.StatusCode, body)
+			continue
+		}
+		c.check(t, s.method, s.path, res, body)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	c := loadContract(t)
+	alice := &participation.Person{Ref: participation.EntityRef{EntityId: "alice"}, Name: participation.PersonName{Given: "Alice"}}
+	users := auth.NewUsers(auth.Account{
+		Entity: alice,
+		Contexts: []participation.UserContext{
+			{UserContextRef: participation.UserContextRef{UserContextId: "old"}, ValidPeriod: datetime.Period{End: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}},
+			{UserContextRef: participation.UserContextRef{UserContextId: "doe"}, Organisation: participation.Organisation{Ref: participation.EntityRef{EntityId: "doe"}}},
+		},
+		TokenDigests: []string{auth.Digest("alice-token")},
+	})
+	server := New(store.NewMemoryStore(), nil)
+	server.SetAuthentication(auth.New(users, auth.NewTokenAuthenticator(users)))
+	auditLog := &bytes.Buffer{}
+	server.SetAuditLog(auditLog)
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	for _, s := range []struct {
+		method, path, token, userContext, body string
+		status                                 int
+	}{
+		{method: "GET", path: "/user", status: 401},
+		{method: "GET", path: "/user", token: "alice-token", status: 200},
+		{method: "GET", path: "/user", token: "alice-token", userContext: "old", status: 401},
+		{method: "POST", path: "/activities", token: "mallory-token", body: `{"id":"https://aldb.test/activities/a"}`, status: 401},
+		{method: "POST", path: "/activities", token: "alice-token", body: `{"id":"https://aldb.test/activities/a"}`, status: 201},
+	} {
+		req, _ := http.NewRequest(s.method, srv.URL+s.path, strings.NewReader(s.body))
+		if len(s.token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+s.token)
+		}
+		if len(s.userContext) > 0 {
+			req.Header.Set(auth.UserContextHeader, s.userContext)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := readAll(t, res)
+		if res.StatusCode != s.status {
+			t.Errorf("%s %s: expected %d, got %d: %s", s.method, s.path, s.status, res.StatusCode, body)
+			continue
+		}
+		c.check(t, s.method, s.path, res, body)
+		if s.status == 401 && len(res.Header.Get("WWW-Authenticate")) == 0 {
+			t.Errorf("%s %s: expected a WWW-Authenticate header", s.method, s.path)
+		}
+		if s.path == "/user" && s.status == 200 {
+			user := map[string]interface{}{}
+			if err := json.Unmarshal(body, &user); err != nil {
+				t.Fatal(err)
+			}
+			if user["userContext"].(map[string]interface{})["id"] != "doe" {
+				t.Errorf("expected the valid user context to be selected, got %s", body)
+			}
+		}
+	}
+
+	records := []AuditRecord{}
+	for _, line := range strings.Split(strings.TrimSpace(auditLog.String()), "\n") {
+		rec := AuditRecord{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 4 {
+		t.Fatalf("expected the failed reads and the writes to be audited, got %s", auditLog)
+	}
+	if last := records[3]; last.Status != 201 || last.User == nil || last.User.EntityId != "alice" || last.UserContext.UserContextId != "doe" {
+		t.Errorf("unexpected audit record %+v", last)
+	}
+	if records[2].Status != 401 || records[2].User != nil {
+		t.Errorf("unexpected audit record %+v", records[2])
+	}
+}
+
+func TestProblems(t *testing.T) {
+	srv := httptest.NewServer(New(store.NewMemoryStore(), nil))
+	defer srv.Close()
+	get := func(path, requestId string) (*http.Response, errcode.Problem) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if len(requestId) > 0 {
+			req.Header.Set(RequestIdHeader, requestId)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := errcode.Problem{}
+		if err := json.Unmarshal(readAll(t, res), &p); err != nil {
+			t.Fatal(err)
+		}
+		return res, p
+	}
+
+	path := ActivityPath("https://aldb.test/activities/missing")
+	res, p := get(path, "req-42")
+	if res.Header.Get("Content-Type") != errcode.ProblemMediaType || res.Header.Get(RequestIdHeader) != "req-42" {
+		t.Errorf("unexpected headers %v", res.Header)
+	}
+	if p.Status != http.StatusNotFound || p.Code != store.ErrorCodeNotFound || p.Type != errcode.TypeURI(store.ErrorCodeNotFound) ||
+		p.Title != "activity not found" || p.Instance != path || p.RequestId != "req-42" {
+		t.Errorf("unexpected problem %+v", p)
+	}
+	res, p = get("/nowhere", "not a valid id")
+	if id := res.Header.Get(RequestIdHeader); len(id) == 0 || id == "not a valid id" || p.RequestId != id || p.Status != http.StatusNotFound {
+		t.Errorf("expected a generated request id, got %q in %+v", id, p)
+	}
+}
+
+func TestInternalProblems(t *testing.T) {
+	logged := &bytes.Buffer{}
+	log.SetOutput(logged)
+	defer log.SetOutput(os.Stderr)
+
+	req := httptest.NewRequest(http.MethodGet, "/activities", nil)
+	req.Header.Set(RequestIdHeader, "req-7")
+	rec := httptest.NewRecorder()
+	req = withRequestId(rec, req)
+	writeError(rec, req, fmt.Errorf("cannot list: %w", errors.New("open /var/lib/aldb/secret: permission denied")))
+	p := errcode.Problem{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != http.StatusInternalServerError || p.Code != ErrorCodeInternal || p.RequestId != "req-7" {
+		t.Errorf("unexpected problem %+v", p)
+	}
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("expected the message of the error not to be disclosed, got %s", rec.Body.String())
+	}
+	if line := logged.String(); !strings.Contains(line, "req-7") || !strings.Contains(line, "/var/lib/aldb/secret") {
+		t.Errorf("expected the error to be logged with the request id, got %q", line)
+	}
+
+	//the message and details of coded errors with status 500 aren't disclosed either
+	logged.Reset()
+	rec = httptest.NewRecorder()
+	writeError(rec, req, aldberr.New(store.ErrorCodeFilesystem, "cannot read /var/lib/aldb/secret", map[string]interface{}{"path": "/var/lib/aldb/secret"}))
+	p = errcode.Problem{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != http.StatusInternalServerError || p.Code != store.ErrorCodeFilesystem || p.Details != nil || !strings.Contains(p.Detail, "req-7") {
+		t.Errorf("unexpected problem %+v", p)
+	}
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("expected the message and details of the error not to be disclosed, got %s", rec.Body.String())
+	}
+	if line := logged.String(); !strings.Contains(line, "req-7") || !strings.Contains(line, "path:/var/lib/aldb/secret") {
+		t.Errorf("expected the error and its details to be logged with the request id, got %q", line)
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	c := loadContract(t)
+	s := store.NewMemoryStore()
+	a := storetest.NewActivity("project", "", "Project")
+	a.Participations = []participation.Participation{{Entity: &participation.Organisation{
+		Ref:  participation.EntityRef{EntityId: "ec"},
+		Name: participation.LocalizableOrganisationName{"en": {Short: "Commission"}, "nl": {Short: "Commissie"}},
+	}}, {Entity: &participation.Person{
+		Ref:  participation.EntityRef{EntityId: "kovacs"},
+		Name: participation.PersonName{Given: "János", Family: "Kovács"},
+	}}}
+	if _, err := s.Create(context.Background(), store.CreateRequest{ToCreate: a}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(New(s, nil))
+	defer srv.Close()
+	get := func(path, acceptLanguage string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if len(acceptLanguage) > 0 {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := readAll(t, res)
+		c.check(t, http.MethodGet, path, res, body)
+		return res, body
+	}
+
+	path := ActivityPath(storetest.Ref("project", "").Id.String())
+	for acceptLanguage, expected := range map[string][]string{
+		"":                {"Commission", "János Kovács"},
+		"nl-BE, en;q=0.5": {"Commissie", "János Kovács"},
+		"fr, en-GB;q=0.8": {"Commission", "János Kovács"},
+		"hu, en;q=0.8":    {"Commission", "Kovács János"},
+	} {
+		for _, p := range []string{path, path + "/participations", "/activities"} {
+			res, body := get(p, acceptLanguage)
+			for _, display := range expected {
+				if !bytes.Contains(body, []byte(`"display":"`+display+`"`)) {
+					t.Errorf("%s in %q: expected %q, got %s", p, acceptLanguage, display, body)
+				}
+			}
+			if res.Header.Get("Vary") != "Accept-Language" {
+				t.Errorf("%s: expected to vary by Accept-Language, got %v", p, res.Header)
+			}
+		}
+	}
+	for acceptLanguage, expected := range map[string]string{"en-GB": "en", "nl": "", "": ""} {
+		res, _ := get(ActivityPath("https://aldb.test/activities/missing"), acceptLanguage)
+		if cl := res.Header.Get("Content-Language"); cl != expected {
+			t.Errorf("%q: expected Content-Language %q, got %q", acceptLanguage, expected, cl)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/access"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/mediatype"
+	"github.com/vital-dhaveloose/aldb/directory"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+//SetDirectory makes the Server serve the entities of d and the members of its groups. If the store checks
+//permissions, reading the directory requires an authenticated entity, and changing it requires
+//access.PermissionAdminister for the admin activity (e.g. the activity of the organisation), as the members
+//of a group get the roles of its participations. Without an admin activity, the directory cannot be changed
+//over HTTP then.
+func (s *Server) SetDirectory(d directory.Directory, admin ref.ActivityRef) {
+	s.directory = d
+	s.directoryAdmin = admin
+}
+
+//EntityPath returns the path of the entity resource, which is the name of the EntityRef.
+func EntityPath(r participation.EntityRef) string {
+	return "/" + r.ToName()
+}
+
+type entityHandler func(http.ResponseWriter, *http.Request, participation.EntityRef)
+
+func (h entityHandler) with(er participation.EntityRef) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, er)
+	}
+}
+
+func (s *Server) serveEntities(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) == 1 && segments[0] == "entities" {
+		s.route(w, r, routes{http.MethodGet: s.listEntities, http.MethodPost: s.postEntities})
+		return
+	}
+	var er participation.EntityRef
+	switch {
+	case len(segments) >= 2 && segments[0] == "entities":
+		er, segments = participation.EntityRef{EntityId: segments[1]}, segments[2:]
+	case len(segments) >= 4 && segments[0] == "hosts" && segments[2] == "entities":
+		er, segments = participation.EntityRef{Host: segments[1], EntityId: segments[3]}, segments[4:]
+	default:
+		writeError(w, r, aldberr.New(ErrorCodeRouteNotFound, "no such resource", map[string]interface{}{"path": r.URL.Path}))
+		return
+	}
+	switch {
+	case len(segments) == 0:
+		s.route(w, r, routes{
+			http.MethodGet:    entityHandler(s.getEntity).with(er),
+			http.MethodPut:    entityHandler(s.putEntity).with(er),
+			http.MethodDelete: entityHandler(s.deleteEntity).with(er),
+		})
+	case len(segments) == 1 && segments[0] == "members":
+		s.route(w, r, routes{
+			http.MethodGet: entityHandler(s.listMembers).with(er),
+			http.MethodPut: entityHandler(s.putMembers).with(er),
+		})
+	case len(segments) == 1 && segments[0] == "groups":
+		s.route(w, r, routes{http.MethodGet: entityHandler(s.listGroups).with(er)})
+	default:
+		writeError(w, r, aldberr.New(ErrorCodeRouteNotFound, "no such resource", map[string]interface{}{"path": r.URL.Path}))
+	}
+}
+
+//listEntities lists the entities of the directory, or the one with the email address of the email query
+//parameter, as vCards if the Accept header prefers those (see participation.EncodeVCards).
+func (s *Server) listEntities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	if err := s.authorizeDirectory(r, false); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res, err := s.directory.List(r.Context(), directory.ListRequest{Email: r.URL.Query().Get("email")})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !prefersVCard(r) {
+		writeJSON(w, http.StatusOK, localizeEntities(r, res.Entities))
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err := participation.EncodeVCards(buf, res.Entities...); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", participation.VCardMediaType.String())
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(buf.Bytes())
+	}
+}
+
+//postEntities creates an entity, or imports an address book if the body is of type text/vcard.
+func (s *Server) postEntities(w http.ResponseWriter, r *http.Request) {
+	if mt, err := mediatype.Parse(r.Header.Get("Content-Type")); err == nil && mt.Essence() == participation.VCardMediaType.Essence() {
+		s.importEntities(w, r)
+		return
+	}
+	s.postEntity(w, r)
+}
+
+//postEntity creates an entity, the EntityRef is taken from the body.
+func (s *Server) postEntity(w http.ResponseWriter, r *http.Request) {
+	e, err := readEntity(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.authorizeDirectory(r, true); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res, err := s.directory.Create(r.Context(), directory.CreateRequest{ToCreate: e})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", EntityPath(res.Created.EntityRef()))
+	writeJSON(w, http.StatusCreated, participation.Localized(res.Created, languagesFrom(r.Context())))
+}
+
+//importEntities imports the vCards of the body into the directory (see participation.ImportAddressBook):
+//entities that are duplicates of existing ones are merged into those, the other ones are created, with a new
+//EntityRef if their vCard has none. The entities are saved one by one, so an error can leave the ones before it
+//saved.
+func (s *Server) importEntities(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.authorizeDirectory(r, true); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	existing, err := s.directory.List(r.Context(), directory.ListRequest{})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	imported, err := participation.ImportAddressBook(bytes.NewReader(body), existing.Entities)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	saved := make([]participation.Entity, 0, len(imported))
+	for _, ie := range imported {
+		if ie.Existing != nil {
+			res, err := s.directory.Update(r.Context(), directory.UpdateRequest{ToUpdate: ie.Entity})
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			saved = append(saved, res.Updated)
+			continue
+		}
+		if !ie.Entity.EntityRef().IsComplete() {
+			idBytes := make([]byte, 16)
+			if _, err := rand.Read(idBytes); err != nil {
+				writeError(w, r, aldberr.Wrap(err, ErrorCodeInternal, "cannot generate entity id", nil))
+				return
+			}
+			setEntityRef(ie.Entity, participation.EntityRef{EntityId: hex.EncodeToString(idBytes)})
+		}
+		res, err := s.directory.Create(r.Context(), directory.CreateRequest{ToCreate: ie.Entity})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		saved = append(saved, res.Created)
+	}
+	writeJSON(w, http.StatusOK, localizeEntities(r, saved))
+}
+
+func (s *Server) getEntity(w http.ResponseWriter, r *http.Request, er participation.EntityRef) {
+	if err := s.authorizeDirectory(r, false); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res, err := s.directory.Read(r.Context(), directory.ReadRequest{Ref: er})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, participation.Localized(res.Entity, languagesFrom(r.Context())))
+}
+
+//putEntity replaces the entity, or creates it if it doesn't exist. The EntityRef in the body is optional, but
+//must be the one of the path if given.
+func (s *Server) putEntity(w http.ResponseWriter, r *http.Request, er participation.EntityRef) {
+	e, err := readEntity(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if body := e.EntityRef(); body != (participation.EntityRef{}) && body != er {
+		writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "entity in body differs from path", map[string]interface{}{"entity": body.ToName(), "path": er.ToName()}))
+		return
+	}
+	setEntityRef(e, er)
+	if err := s.authorizeDirectory(r, true); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res, err := s.directory.Update(r.Context(), directory.UpdateRequest{ToUpdate: e})
+	if isDirectoryNotFound(err) {
+		created, err := s.directory.Create(r.Context(), directory.CreateRequest{ToCreate: e})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Location", EntityPath(er))
+		writeJSON(w, http.StatusCreated, participation.Localized(created.Created, languagesFrom(r.Context())))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, participation.Localized(res.Updated, languagesFrom(r.Context())))
+}
+
+//deleteEntity deletes the entity and its memberships.
+func (s *Server) deleteEntity(w http.ResponseWriter, r *http.Request, er participation.EntityRef) {
+	if err := s.authorizeDirectory(r, true); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err := s.directory.Delete(r.Context(), directory.DeleteRequest{Ref: er}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//listMembers lists the members of a group, also the ones of its member groups if the transitive query
+//parameter is true.
+func (s *Server) listMembers(w http.ResponseWriter, r *http.Request, er participation.EntityRef) {
+	transitive, err := transitiveFrom(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.authorizeDirectory(r, false); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res, err := s.directory.ReadMembers(r.Context(), directory.ReadMembersRequest{Group: er, Transitive: transitive})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, localizeEntities(r, res.Members))
+}
+
+//putMembers replaces the members of a group by the entities of the names in the body.
+func (s *Server) putMembers(w http.ResponseWriter, r *http.Request, er participation.EntityRef) {
+	members := []participation.EntityRef{}
+	if err := readJSON(r, &members); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.authorizeDirectory(r, true); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res, err := s.directory.SetMembers(r.Context(), directory.SetMembersRequest{Group: er, Members: members})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, localizeEntities(r, res.Members))
+}
+
+//listGroups lists the groups the entity is a member of, also the groups of those if the transitive query
+//parameter is true.
+func (s *Server) listGroups(w http.ResponseWriter, r *http.Request, er participation.EntityRef) {
+	transitive, err := transitiveFrom(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.authorizeDirectory(r, false); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res, err := s.directory.ReadGroups(r.Context(), directory.ReadGroupsRequest{Member: er, Transitive: transitive})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, localizeEntities(r, res.Groups))
+}
+
+//authorizeDirectory checks that the entity of the request may read the directory, or change it if write, when
+//the store checks permissions (see SetDirectory).
+func (s *Server) authorizeDirectory(r *http.Request, write bool) error {
+	a, isAuthorizing := s.store.(authorizing)
+	if !isAuthorizing {
+		return nil
+	}
+	e, authenticated := access.EntityFrom(r.Context())
+	if !write {
+		if !authenticated {
+			return aldberr.New(access.ErrorCodeUnauthenticated, "request is not authenticated", nil)
+		}
+		return nil
+	}
+	if s.directoryAdmin.Id == nil {
+		return aldberr.New(access.ErrorCodeDenied, "permission denied: the directory has no admin activity", map[string]interface{}{
+			"entity":     e.ToName(),
+			"activity":   "",
+			"permission": string(access.PermissionAdminister),
+		})
+	}
+	return a.Authorizer().CheckContext(r.Context(), ref.ActivityRef{Id: s.directoryAdmin.Id}, access.PermissionAdminister)
+}
+
+func readEntity(r *http.Request) (participation.Entity, error) {
+	bts, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	return participation.UnmarshalEntity(bts)
+}
+
+func setEntityRef(e participation.Entity, er participation.EntityRef) {
+	switch et := e.(type) {
+	case *participation.Person:
+		et.Ref = er
+	case *participation.Organisation:
+		et.Ref = er
+	case *participation.Group:
+		et.Ref = er
+	}
+}
+
+//prefersVCard tells whether the Accept header of the request has text/vcard, with a quality that is at least
+//the one of application/json.
+func prefersVCard(r *http.Request) bool {
+	vcard, json := 0.0, 0.0
+	for _, raw := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, err := mediatype.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if rawQ, found := mt.Parameter("q"); found {
+			if q, err = strconv.ParseFloat(rawQ, 64); err != nil {
+				continue
+			}
+		}
+		switch mt.Essence() {
+		case participation.VCardMediaType.Essence():
+			vcard = q
+		case "application/json":
+			json = q
+		}
+	}
+	return vcard > 0 && vcard >= json
+}
+
+func transitiveFrom(r *http.Request) (bool, error) {
+	raw := r.URL.Query().Get("transitive")
+	if len(raw) == 0 {
+		return false, nil
+	}
+	transitive, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, aldberr.New(ErrorCodeInvalidRequest, "invalid transitive parameter", map[string]interface{}{"transitive": raw})
+	}
+	return transitive, nil
+}
+
+func localizeEntities(r *http.Request, es []participation.Entity) []participation.Entity {
+	p := languagesFrom(r.Context())
+	out := make([]participation.Entity, len(es))
+	for i, e := range es {
+		out[i] = participation.Localized(e, p)
+	}
+	return out
+}
+
+func isDirectoryNotFound(err error) bool {
+	return aldberr.IsCode(err, directory.ErrorCodeNotFound)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+)
+
+//withLanguages remembers the languages of the Accept-Language header of the request, see languagesFrom.
+func withLanguages(r *http.Request) *http.Request {
+	p := lang.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	return r.WithContext(context.WithValue(r.Context(), languagesKey, p))
+}
+
+//languagesFrom returns the languages the client prefers, which are empty if it has no preference.
+func languagesFrom(ctx context.Context) lang.Preferences {
+	p, _ := ctx.Value(languagesKey).(lang.Preferences)
+	return p
+}
+
+//localizeActivity returns a with the localizables that are written as a single string (the display names of
+//entities and labels that aren't a lang.LocalizableString) in the language the client prefers. Labels that
+//are a lang.LocalizableString are written with all their translations, so that clients can edit them.
+func localizeActivity(p lang.Preferences, a activity.Activity) activity.Activity {
+	if _, isLs := a.Label.(lang.LocalizableString); a.Label != nil && !isLs {
+		if s, l, err := lang.Negotiate(a.Label, p, nil); err == nil {
+			a.Label = lang.LocalizableString{l: s}
+		}
+	}
+	a.Participations = localizeParticipations(p, a.Participations)
+	return a
+}
+
+func localizeActivities(p lang.Preferences, as []activity.Activity) []activity.Activity {
+	out := make([]activity.Activity, len(as))
+	for i, a := range as {
+		out[i] = localizeActivity(p, a)
+	}
+	return out
+}
+
+func localizeParticipations(p lang.Preferences, ps []participation.Participation) []participation.Participation {
+	if ps == nil {
+		return nil
+	}
+	out := make([]participation.Participation, len(ps))
+	for i, pp := range ps {
+		pp.Entity = participation.Localized(pp.Entity, p)
+		out[i] = pp
+	}
+	return out
+}
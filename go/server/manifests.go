@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/manifest"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+func (s *Server) serveManifests(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) == 1 {
+		s.route(w, r, routes{http.MethodGet: s.listManifests, http.MethodPost: s.postManifest})
+		return
+	}
+	manifest, version := ref.ManifestPattern.Routes()[0], ref.ManifestPattern.Routes()[1]
+	for _, template := range []string{manifest, manifest + "/versions", version} {
+		ids, matches := matchRoute(template, segments)
+		if !matches {
+			continue
+		}
+		id, err := url.Parse(ids["manifestId"])
+		if err != nil || len(ids["manifestId"]) == 0 {
+			writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "invalid manifest id", map[string]interface{}{"id": ids["manifestId"]}))
+			return
+		}
+		mr := ref.ManifestRef{Id: id, Version: ids["version"]}
+		if template == manifest+"/versions" {
+			s.route(w, r, routes{http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.listManifestVersions(w, r, mr) }})
+		} else {
+			s.route(w, r, routes{http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.getManifest(w, r, mr) }})
+		}
+		return
+	}
+	writeError(w, r, aldberr.New(ErrorCodeRouteNotFound, "no such resource", map[string]interface{}{"path": r.URL.Path}))
+}
+
+func (s *Server) listManifests(w http.ResponseWriter, r *http.Request) {
+	res, err := s.manifests.List(r.Context(), manifest.ListRequest{})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNilManifests(res.Manifests))
+}
+
+//postManifest publishes a new version of a manifest, the id is taken from the body.
+func (s *Server) postManifest(w http.ResponseWriter, r *http.Request) {
+	m := manifest.Manifest{}
+	if err := readJSON(r, &m); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res, err := s.manifests.Publish(r.Context(), manifest.PublishRequest{ToPublish: m})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	published := res.Published
+	w.Header().Set("Location", ManifestPath(published.Id.String())+"/versions/"+url.PathEscape(published.Version))
+	writeManifest(w, http.StatusCreated, published)
+}
+
+//getManifest reads the version of the manifest that mr refers to, the latest one if it has no Version.
+func (s *Server) getManifest(w http.ResponseWriter, r *http.Request, mr ref.ManifestRef) {
+	res, err := s.manifests.Read(r.Context(), manifest.ReadRequest{Ref: mr})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeManifest(w, http.StatusOK, res.Manifest)
+}
+
+func (s *Server) listManifestVersions(w http.ResponseWriter, r *http.Request, mr ref.ManifestRef) {
+	res, err := s.manifests.History(r.Context(), manifest.HistoryRequest{Ref: mr})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNilManifests(res.Versions))
+}
+
+//writeManifest writes the manifest with its version as ETag.
+func writeManifest(w http.ResponseWriter, status int, m manifest.Manifest) {
+	w.Header().Set("ETag", `"`+m.Version+`"`)
+	writeJSON(w, status, m)
+}
+
+func nonNilManifests(ms []manifest.Manifest) []manifest.Manifest {
+	if ms == nil {
+		return []manifest.Manifest{}
+	}
+	return ms
+}
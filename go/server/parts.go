@@ -0,0 +1,475 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/attributes"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/mediatype"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+//region attribute sets
+
+func (s *Server) listAttributeSets(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	a, ok := s.readForPart(w, r, rr)
+	if !ok {
+		return
+	}
+	sets := a.AttributeSets
+	if sets == nil {
+		sets = map[string]attributes.AttributeSet{}
+	}
+	writePart(w, http.StatusOK, a, sets)
+}
+
+//postAttributeSet adds an attribute set, using the id of its manifest as attribute set id.
+func (s *Server) postAttributeSet(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	as := attributes.AttributeSet{}
+	if err := readJSON(r, &as); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if as.Manifest == nil || as.Manifest.Id == nil || len(as.Manifest.Id.String()) == 0 {
+		writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "attribute set has no manifest id", nil))
+		return
+	}
+	setId := as.Manifest.Id.String()
+	created, ok := s.update(w, r, rr, func(latest activity.Activity, next *activity.Activity) error {
+		if _, found := latest.AttributeSets[setId]; found {
+			return aldberr.New(ErrorCodePartExists, "attribute set already exists", map[string]interface{}{"setId": setId})
+		}
+		next.AttributeSets = withAttributeSet(latest.AttributeSets, setId, &as)
+		return nil
+	})
+	if !ok {
+		return
+	}
+	w.Header().Set("Location", ActivityPath(rr.id)+"/attribute-sets/"+url.PathEscape(setId))
+	writePart(w, http.StatusCreated, created, created.AttributeSets[setId])
+}
+
+func (s *Server) getAttributeSet(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	a, ok := s.readForPart(w, r, rr)
+	if !ok {
+		return
+	}
+	as, found := a.AttributeSets[rr.sub]
+	if !found {
+		writeError(w, r, partNotFound(rr, "attribute set"))
+		return
+	}
+	writePart(w, http.StatusOK, a, as)
+}
+
+func (s *Server) putAttributeSet(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	as := attributes.AttributeSet{}
+	if err := readJSON(r, &as); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	status := http.StatusOK
+	created, ok := s.update(w, r, rr, func(latest activity.Activity, next *activity.Activity) error {
+		if _, found := latest.AttributeSets[rr.sub]; !found {
+			status = http.StatusCreated
+		}
+		next.AttributeSets = withAttributeSet(latest.AttributeSets, rr.sub, &as)
+		return nil
+	})
+	if !ok {
+		return
+	}
+	writePart(w, status, created, created.AttributeSets[rr.sub])
+}
+
+func (s *Server) deleteAttributeSet(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	_, ok := s.update(w, r, rr, func(latest activity.Activity, next *activity.Activity) error {
+		if _, found := latest.AttributeSets[rr.sub]; !found {
+			return partNotFound(rr, "attribute set")
+		}
+		next.AttributeSets = withAttributeSet(latest.AttributeSets, rr.sub, nil)
+		return nil
+	})
+	if ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+//withAttributeSet returns a copy of sets in which setId is set to as, or removed if as is nil.
+func withAttributeSet(sets map[string]attributes.AttributeSet, setId string, as *attributes.AttributeSet) map[string]attributes.AttributeSet {
+	out := make(map[string]attributes.AttributeSet, len(sets)+1)
+	for k, v := range sets {
+		out[k] = v
+	}
+	if as == nil {
+		delete(out, setId)
+	} else {
+		out[setId] = *as
+	}
+	return out
+}
+
+//endregion
+
+//region blob
+
+//getBlob serves the content of a rendition of the blob, the main one unless the "rendition" query parameter
+//names another one. Range requests are supported for content that can seek, which is all content that
+//comes from a Store.
+func (s *Server) getBlob(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	fn, err := renditionParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	a, ok := s.readForPart(w, r, rr)
+	if !ok {
+		return
+	}
+	rendition, found := a.Blob.Rendition(fn)
+	if !found || rendition.Content == nil {
+		rr.sub = string(fn)
+		writeError(w, r, partNotFound(rr, "blob rendition"))
+		return
+	}
+	rc, err := rendition.Content.Open()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer rc.Close()
+	contentType := mediatype.OctetStream.String()
+	if !rendition.Manifest.MediaType.IsZero() {
+		contentType = rendition.Manifest.MediaType.String()
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+a.Version+`"`)
+	if digest := formatDigest(rendition.Manifest.SHA256); len(digest) > 0 {
+		w.Header().Set("Repr-Digest", digest)
+	}
+	if rs, isSeeker := rc.(io.ReadSeeker); isSeeker {
+		http.ServeContent(w, r, "", time.Time{}, rs)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(rendition.Manifest.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.Copy(w, rc)
+	}
+}
+
+//putBlob replaces a rendition of the blob by the request body, which is streamed into the store (so its size
+//isn't limited by MaxBodySize). The Content-Type of the request becomes the media type of the rendition, or
+//it is detected from the content if there is none (see contentMediaType). The content must match the SHA-256
+//in the Repr-Digest header, if any.
+func (s *Server) putBlob(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	fn, err := renditionParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	mt := mediatype.MediaType{}
+	if err := mt.UnmarshalText([]byte(r.Header.Get("Content-Type"))); err != nil {
+		writeError(w, r, aldberr.Wrap(err, ErrorCodeInvalidRequest, "invalid Content-Type", nil))
+		return
+	}
+	sha, err := parseDigest(r.Header.Get("Repr-Digest"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	head := make([]byte, mediatype.SniffLen)
+	n, err := io.ReadFull(r.Body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		writeError(w, r, aldberr.Wrap(err, ErrorCodeInvalidRequest, "cannot read request body", nil))
+		return
+	}
+	head = head[:n]
+	if mt, err = contentMediaType(mt, head); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.writeRendition(w, r, rr, blob.Rendition{
+		Function: fn,
+		Manifest: blob.BlobManifest{MediaType: mt, SHA256: sha},
+		Content:  blob.FromReader(io.MultiReader(bytes.NewReader(head), r.Body)),
+	})
+}
+
+//contentMediaType returns the media type of content that starts with head: the declared one if the content
+//agrees with it (see mediatype.Check), or the detected one if none was declared.
+func contentMediaType(declared mediatype.MediaType, head []byte) (mediatype.MediaType, error) {
+	if declared.IsZero() {
+		return mediatype.Detect(head), nil
+	}
+	if err := mediatype.Check(declared, head); err != nil {
+		return mediatype.MediaType{}, err
+	}
+	return declared, nil
+}
+
+//writeRendition creates a new version of the activity with the given rendition and writes its manifest.
+//Writing the main rendition removes the other ones, as they were derived from the previous content. Other
+//renditions can only be added to a blob that has a main rendition.
+func (s *Server) writeRendition(w http.ResponseWriter, r *http.Request, rr resourceRequest, rendition blob.Rendition) bool {
+	status := http.StatusOK
+	created, ok := s.update(w, r, rr, func(latest activity.Activity, next *activity.Activity) error {
+		if _, found := latest.Blob.Rendition(rendition.Function); !found {
+			status = http.StatusCreated
+		}
+		if rendition.Function == blob.RenditionFunctionMain {
+			next.Blob = &blob.Blob{Renditions: []blob.Rendition{rendition}}
+			return nil
+		}
+		if _, found := latest.Blob.Main(); !found {
+			return partNotFound(rr, "blob")
+		}
+		b := carriedOver(latest.Blob).WithRendition(rendition)
+		next.Blob = &b
+		return nil
+	})
+	if !ok {
+		return false
+	}
+	written, _ := created.Blob.Rendition(rendition.Function)
+	writePart(w, status, created, written.Manifest)
+	return true
+}
+
+//deleteBlob removes the blob, or only one of its renditions if the "rendition" query parameter names
+//another one than the main rendition.
+func (s *Server) deleteBlob(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	fn, err := renditionParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	_, ok := s.update(w, r, rr, func(latest activity.Activity, next *activity.Activity) error {
+		if _, found := latest.Blob.Rendition(fn); !found {
+			rr.sub = string(fn)
+			return partNotFound(rr, "blob rendition")
+		}
+		if fn == blob.RenditionFunctionMain {
+			next.Blob = &blob.Blob{}
+			return nil
+		}
+		b := carriedOver(latest.Blob).WithoutRendition(fn)
+		next.Blob = &b
+		return nil
+	})
+	if ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+//carriedOver returns a copy of b with the Content of its renditions removed, so that the store carries over
+//the content of the parent version instead of writing it again (see store.PrepareVersion).
+func carriedOver(b *blob.Blob) blob.Blob {
+	out := blob.Blob{Renditions: make([]blob.Rendition, len(b.Renditions))}
+	for i, r := range b.Renditions {
+		r.Content = nil
+		out.Renditions[i] = r
+	}
+	return out
+}
+
+//renditionParam returns the rendition function in the "rendition" query parameter, or the main one.
+func renditionParam(r *http.Request) (blob.RenditionFunction, error) {
+	return parseRendition(r.URL.Query().Get("rendition"))
+}
+
+//parseRendition checks a rendition function, "" being the main one.
+func parseRendition(raw string) (blob.RenditionFunction, error) {
+	if len(raw) == 0 {
+		return blob.RenditionFunctionMain, nil
+	}
+	for _, c := range raw {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", aldberr.New(ErrorCodeInvalidRequest, "invalid rendition", map[string]interface{}{"rendition": raw})
+		}
+	}
+	return blob.RenditionFunction(raw), nil
+}
+
+//formatDigest returns the value of a Repr-Digest header (RFC 9530) for a hex encoded SHA-256 checksum.
+func formatDigest(sha string) string {
+	sum, err := hex.DecodeString(sha)
+	if err != nil || len(sum) == 0 {
+		return ""
+	}
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum) + ":"
+}
+
+//parseDigest returns the hex encoded SHA-256 checksum in a Repr-Digest header (RFC 9530), or "" if it has
+//none. Other algorithms are ignored.
+func parseDigest(header string) (string, error) {
+	for _, member := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(member), "=")
+		if !strings.EqualFold(key, "sha-256") {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(strings.Trim(value, ":"))
+		if err != nil || len(sum) != sha256.Size {
+			return "", aldberr.New(ErrorCodeInvalidRequest, "invalid sha-256 in Repr-Digest", map[string]interface{}{"header": header})
+		}
+		return hex.EncodeToString(sum), nil
+	}
+	return "", nil
+}
+
+//endregion
+
+//region participations
+
+func (s *Server) listParticipations(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	a, ok := s.readForPart(w, r, rr)
+	if !ok {
+		return
+	}
+	writePart(w, http.StatusOK, a, nonNilParticipations(localizeParticipations(languagesFrom(r.Context()), a.Participations)))
+}
+
+//postParticipation adds a participation to the activity, as one participation per role (see
+//participation.UnmarshalParticipation).
+func (s *Server) postParticipation(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	bts, err := readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ps, err := participation.UnmarshalParticipation(bts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for i := range ps {
+		ps[i].ActivityRef = rr.ref("")
+	}
+	created, ok := s.update(w, r, rr, func(latest activity.Activity, next *activity.Activity) error {
+		next.Participations = append(append([]participation.Participation{}, latest.Participations...), ps...)
+		return nil
+	})
+	if !ok {
+		return
+	}
+	added := localizeParticipations(languagesFrom(r.Context()), created.Participations)[len(created.Participations)-len(ps):]
+	body, err := participation.MarshalParticipation(added)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", ActivityPath(rr.id)+"/participations")
+	writePart(w, http.StatusCreated, created, json.RawMessage(body))
+}
+
+//putParticipations replaces all participations of the activity.
+func (s *Server) putParticipations(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	ps := participation.Participations{}
+	if err := readJSON(r, &ps); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for i := range ps {
+		ps[i].ActivityRef = rr.ref("")
+	}
+	created, ok := s.update(w, r, rr, func(latest activity.Activity, next *activity.Activity) error {
+		next.Participations = nonNilParticipations(ps)
+		return nil
+	})
+	if !ok {
+		return
+	}
+	writePart(w, http.StatusOK, created, nonNilParticipations(localizeParticipations(languagesFrom(r.Context()), created.Participations)))
+}
+
+func (s *Server) deleteParticipations(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	_, ok := s.update(w, r, rr, func(latest activity.Activity, next *activity.Activity) error {
+		next.Participations = []participation.Participation{}
+		return nil
+	})
+	if ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+//nonNilParticipations returns ps as participation.Participations, so that participations with several roles
+//are written as one.
+func nonNilParticipations(ps []participation.Participation) participation.Participations {
+	if ps == nil {
+		return participation.Participations{}
+	}
+	return ps
+}
+
+//endregion
+
+//region helpers
+
+//readForPart reads the version of the activity in the "version" query parameter, or the latest version. It
+//writes the error and returns false if it cannot be read.
+func (s *Server) readForPart(w http.ResponseWriter, r *http.Request, rr resourceRequest) (activity.Activity, bool) {
+	res, err := s.store.Read(r.Context(), store.ReadRequest{Ref: rr.ref(r.URL.Query().Get("version"))})
+	if err != nil {
+		writeError(w, r, err)
+		return activity.Activity{}, false
+	}
+	return res.Activity, true
+}
+
+//update creates a new version of the activity from its latest version, as changed by change. Only the Label
+//and the Period are copied from the latest version into next, the other parts are carried over by the Store
+//unless change sets them. It writes the error and returns false if the update fails.
+func (s *Server) update(w http.ResponseWriter, r *http.Request, rr resourceRequest, change func(latest activity.Activity, next *activity.Activity) error) (activity.Activity, bool) {
+	latest, found, err := s.latest(r, rr)
+	if err == nil && !found {
+		err = aldberr.New(store.ErrorCodeNotFound, "activity not found", map[string]interface{}{"id": rr.id})
+	}
+	if err == nil {
+		err = checkIfMatch(r, latest, found)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return activity.Activity{}, false
+	}
+	next := activity.Activity{
+		ActivityRef:    rr.ref(""),
+		ParentVersions: []string{latest.Version},
+		Label:          latest.Label,
+		Period:         latest.Period,
+	}
+	if err := change(latest, &next); err != nil {
+		writeError(w, r, err)
+		return activity.Activity{}, false
+	}
+	res, err := s.store.Create(r.Context(), store.CreateRequest{ToCreate: next})
+	if err != nil {
+		writeError(w, r, err)
+		return activity.Activity{}, false
+	}
+	return res.Created, true
+}
+
+//writePart writes part of the activity a, with the version of a as ETag.
+func writePart(w http.ResponseWriter, status int, a activity.Activity, part interface{}) {
+	w.Header().Set("ETag", `"`+a.Version+`"`)
+	writeJSON(w, status, part)
+}
+
+func partNotFound(rr resourceRequest, what string) error {
+	return aldberr.New(ErrorCodePartNotFound, what+" not found", map[string]interface{}{"id": rr.id, "part": rr.sub})
+}
+
+//endregion
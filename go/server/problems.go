@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+)
+
+//RequestIdHeader is the header with the id of a request. The id of the client is used if it has at most 128
+//letters, digits, '-', '_' and '.', otherwise the Server generates one. The id is in the response, its
+//problems and the audit log.
+const RequestIdHeader = "X-Request-Id"
+
+type contextKey int
+
+const (
+	requestIdKey contextKey = iota
+	languagesKey
+)
+
+//RequestIdFrom returns the id of the request with the context, see RequestIdHeader.
+func RequestIdFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+//withRequestId gives the request an id, and writes it in the response header.
+func withRequestId(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(RequestIdHeader)
+	if !isValidRequestId(id) {
+		bts := make([]byte, 12)
+		rand.Read(bts)
+		id = hex.EncodeToString(bts)
+	}
+	w.Header().Set(RequestIdHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIdKey, id))
+}
+
+func isValidRequestId(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		isLetterOrDigit := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !isLetterOrDigit && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+//writeError writes err as a problem (see errcode.Problem). Errors that aren't an aldberr.CanvigaError are
+//internal errors. The message and details of errors with status 500 or more aren't disclosed: they are
+//logged with the request id and the errors they wrap, so that they can be found from the problem.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	cause := err
+	cErr := aldberr.CanvigaError{}
+	if !errors.As(err, &cErr) {
+		err = aldberr.Wrap(err, ErrorCodeInternal, "internal error", nil)
+	}
+	p := errcode.NewProblem(err, languagesFrom(r.Context()))
+	p.Instance = r.URL.EscapedPath()
+	p.RequestId = RequestIdFrom(r.Context())
+	if p.Status >= http.StatusInternalServerError {
+		p.Detail = "the error is logged with request id " + p.RequestId
+		if details := cErr.Details(); len(details) > 0 {
+			log.Printf("request %s: %s %s: %s %v", p.RequestId, r.Method, p.Instance, errorChain(cause), details)
+		} else {
+			log.Printf("request %s: %s %s: %s", p.RequestId, r.Method, p.Instance, errorChain(cause))
+		}
+	}
+	if p.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="aldb"`)
+	}
+	writeProblem(w, p)
+}
+
+//errorChain describes err and the errors it wraps, as the message of an aldberr.CanvigaError leaves out the
+//error it wraps.
+func errorChain(err error) string {
+	out := err.Error()
+	for {
+		cErr := aldberr.CanvigaError{}
+		if !errors.As(err, &cErr) || cErr.Unwrap() == nil {
+			return out
+		}
+		err = cErr.Unwrap()
+		out += ": " + err.Error()
+	}
+}
+
+func writeProblem(w http.ResponseWriter, p errcode.Problem) {
+	bts, err := json.Marshal(p)
+	if err != nil {
+		//details that cannot be marshalled are left out
+		p.Details = nil
+		bts, _ = json.Marshal(p)
+	}
+	w.Header().Set("Content-Type", errcode.ProblemMediaType)
+	if p.Lang != lang.LangAny && len(p.Lang) > 0 {
+		w.Header().Set("Content-Language", string(p.Lang))
+	}
+	w.WriteHeader(p.Status)
+	w.Write(bts)
+}
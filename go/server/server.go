@@ -0,0 +1,381 @@
+//Package server exposes a store.Store over HTTP, as described by api/api.json.
+//
+//Activity ids are URIs, so in paths they are percent-encoded as a single segment (see ActivityPath), e.g.
+///activities/https:%2F%2Fdoe.eu%2Factivities%2F42. Every write creates a new version of the activity (see
+//store.CreateRequest); the version is exposed as the ETag of activity resources and can be passed in If-Match
+//to make a write conditional on it being the latest version. Errors are written as problem details (RFC 7807,
+//see errcode.Problem), with the HTTP status that is registered for their code. Texts that are written in a single
+//language are in the language that suits the Accept-Language header of the request best (see
+//lang.Preferences.Match).
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/auth"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/directory"
+	"github.com/vital-dhaveloose/aldb/manifest"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+const (
+	ErrorCodeInvalidRequest = "server-invalid-request"
+	ErrorCodeRouteNotFound  = "server-route-not-found"
+	//ErrorCodePartNotFound is returned when an activity exists, but doesn't have the requested part (e.g. an
+	//attribute set or a blob).
+	ErrorCodePartNotFound = "server-part-not-found"
+	//ErrorCodePartExists is returned when a part is posted that the activity already has.
+	ErrorCodePartExists         = "server-part-exists"
+	ErrorCodeMethodNotAllowed   = "server-method-not-allowed"
+	ErrorCodePreconditionFailed = "server-precondition-failed"
+	ErrorCodeInternal           = "server-internal"
+	//ErrorCodeUploadOffsetMismatch is returned when a chunk of an upload doesn't start where the previous one
+	//ended.
+	ErrorCodeUploadOffsetMismatch = "server-upload-offset-mismatch"
+	//ErrorCodeUploadBusy is returned when a chunk is sent while another request writes to the same upload.
+	ErrorCodeUploadBusy = "server-upload-busy"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeInvalidRequest, Status: http.StatusBadRequest, Message: errcode.En("invalid request")},
+		errcode.Code{Code: ErrorCodeRouteNotFound, Status: http.StatusNotFound, Message: errcode.En("no such resource")},
+		errcode.Code{Code: ErrorCodePartNotFound, Status: http.StatusNotFound, Message: errcode.En("the activity doesn't have the part")},
+		errcode.Code{Code: ErrorCodePartExists, Status: http.StatusConflict, Message: errcode.En("the activity already has the part")},
+		errcode.Code{Code: ErrorCodeMethodNotAllowed, Status: http.StatusMethodNotAllowed, Message: errcode.En("method not allowed")},
+		errcode.Code{Code: ErrorCodePreconditionFailed, Status: http.StatusPreconditionFailed, Message: errcode.En("the precondition of the request failed")},
+		errcode.Code{Code: ErrorCodeInternal, Status: http.StatusInternalServerError, Message: errcode.En("internal error")},
+		errcode.Code{Code: ErrorCodeUploadOffsetMismatch, Status: http.StatusConflict, Message: errcode.En("the chunk doesn't start at the offset of the upload")},
+		errcode.Code{Code: ErrorCodeUploadBusy, Status: http.StatusConflict, Message: errcode.En("another request is writing to the upload")},
+	)
+}
+
+//MaxBodySize is the maximum size in bytes of a request body, except for blob content which is streamed.
+const MaxBodySize = 32 << 20
+
+//Server is an http.Handler that serves the ALDB API on top of a Store and a manifest Registry.
+type Server struct {
+	store     store.Store
+	manifests manifest.Registry
+
+	uploadDir   string
+	uploadsMu   sync.Mutex
+	busyUploads map[string]bool
+
+	authentication *auth.Authentication
+	auditLog       io.Writer
+	auditMu        sync.Mutex
+
+	directory      directory.Directory
+	directoryAdmin ref.ActivityRef
+}
+
+//New creates a Server. The manifest routes are not served if manifests is nil. Wrap s in a
+//manifest.ValidatingStore to validate attribute sets against the manifests.
+func New(s store.Store, manifests manifest.Registry) *Server {
+	return &Server{
+		store:       s,
+		manifests:   manifests,
+		uploadDir:   filepath.Join(os.TempDir(), "aldb-uploads"),
+		busyUploads: map[string]bool{},
+	}
+}
+
+//ActivityPath returns the path of the activity resource with the given id, which is the name of its
+//ref.ActivityRef (see ref.ActivityPattern).
+func ActivityPath(id string) string {
+	return "/" + ref.ActivityPattern.Format(map[string]string{"activityId": id})
+}
+
+//ManifestPath returns the path of the manifest resource with the given id, which is the name of its
+//ref.ManifestRef (see ref.ManifestPattern).
+func ManifestPath(id string) string {
+	return "/" + ref.ManifestPattern.Format(map[string]string{"manifestId": id})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sr := &statusRecorder{ResponseWriter: w}
+	r = withRequestId(sr, r)
+	r = withLanguages(r)
+	sr.Header().Set("Vary", "Accept-Language")
+	r, err := s.authenticate(r)
+	if err != nil {
+		writeError(sr, r, err)
+	} else {
+		s.serve(sr, r)
+	}
+	s.audit(r, sr.status)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	segments, err := splitPath(r.URL.EscapedPath())
+	switch {
+	case err != nil || len(segments) == 0:
+	case segments[0] == "user" && len(segments) == 1:
+		s.route(w, r, routes{http.MethodGet: s.getUser})
+		return
+	case segments[0] == "activities":
+		s.serveActivities(w, r, segments)
+		return
+	case segments[0] == "manifests" && s.manifests != nil:
+		s.serveManifests(w, r, segments)
+		return
+	case (segments[0] == "entities" || segments[0] == "hosts") && s.directory != nil:
+		s.serveEntities(w, r, segments)
+		return
+	}
+	writeError(w, r, aldberr.New(ErrorCodeRouteNotFound, "no such resource", map[string]interface{}{"path": r.URL.Path}))
+}
+
+func (s *Server) serveActivities(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) == 1 {
+		s.route(w, r, routes{http.MethodGet: s.listActivities, http.MethodPost: s.postActivity})
+		return
+	}
+	for _, pr := range s.activityRoutes() {
+		ids, matches := matchRoute(pr.template, segments)
+		if !matches {
+			continue
+		}
+		id := ids["activityId"]
+		if _, err := url.Parse(id); err != nil || len(id) == 0 {
+			writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "invalid activity id", map[string]interface{}{"id": id}))
+			return
+		}
+		rr := resourceRequest{id: id, sub: ids[pr.sub]}
+		s.route(w, r, pr.routes(rr))
+		return
+	}
+	writeError(w, r, aldberr.New(ErrorCodeRouteNotFound, "no such resource", map[string]interface{}{"path": r.URL.Path}))
+}
+
+//activityRoutes returns the routes of an activity and its parts, derived from ref.ActivityPattern and
+//ref.AttributeSetPattern.
+func (s *Server) activityRoutes() []pathRoute {
+	activity, version := ref.ActivityPattern.Routes()[0], ref.ActivityPattern.Routes()[1]
+	//attribute sets of a specific version aren't exposed
+	attributeSet := ref.AttributeSetPattern.Routes()[0]
+	return []pathRoute{
+		{template: activity, routes: func(rr resourceRequest) routes {
+			return routes{
+				http.MethodGet:    rr.with(s.getActivity),
+				http.MethodPut:    rr.with(s.putActivity),
+				http.MethodDelete: rr.with(s.deleteActivity),
+			}
+		}},
+		{template: activity + "/versions", routes: func(rr resourceRequest) routes {
+			return routes{
+				http.MethodGet:  rr.with(s.listVersions),
+				http.MethodPost: rr.with(s.postVersion),
+			}
+		}},
+		{template: version, sub: "version", routes: func(rr resourceRequest) routes {
+			return routes{
+				http.MethodGet:    rr.with(s.getVersion),
+				http.MethodPut:    rr.with(s.putVersion),
+				http.MethodDelete: rr.with(s.deleteVersion),
+			}
+		}},
+		{template: activity + "/attribute-sets", routes: func(rr resourceRequest) routes {
+			return routes{
+				http.MethodGet:  rr.with(s.listAttributeSets),
+				http.MethodPost: rr.with(s.postAttributeSet),
+			}
+		}},
+		{template: attributeSet, sub: "attributeSetId", routes: func(rr resourceRequest) routes {
+			return routes{
+				http.MethodGet:    rr.with(s.getAttributeSet),
+				http.MethodPut:    rr.with(s.putAttributeSet),
+				http.MethodDelete: rr.with(s.deleteAttributeSet),
+			}
+		}},
+		{template: activity + "/blob", routes: func(rr resourceRequest) routes {
+			return routes{
+				http.MethodGet:    rr.with(s.getBlob),
+				http.MethodPut:    rr.with(s.putBlob),
+				http.MethodDelete: rr.with(s.deleteBlob),
+			}
+		}},
+		{template: activity + "/blob/uploads", routes: func(rr resourceRequest) routes {
+			return routes{http.MethodPost: rr.with(s.postUpload)}
+		}},
+		{template: activity + "/blob/uploads/{uploadId}", sub: "uploadId", routes: func(rr resourceRequest) routes {
+			return routes{
+				http.MethodGet:    rr.with(s.getUpload),
+				http.MethodPatch:  rr.with(s.patchUpload),
+				http.MethodPut:    rr.with(s.putUpload),
+				http.MethodDelete: rr.with(s.deleteUpload),
+			}
+		}},
+		{template: activity + "/calendar", routes: func(rr resourceRequest) routes {
+			return routes{
+				http.MethodGet:  rr.with(s.getCalendar),
+				http.MethodPost: rr.with(s.postCalendar),
+			}
+		}},
+		{template: activity + "/access", routes: func(rr resourceRequest) routes {
+			return routes{http.MethodGet: rr.with(s.getAccess)}
+		}},
+		{template: activity + "/participations", routes: func(rr resourceRequest) routes {
+			return routes{
+				http.MethodGet:    rr.with(s.listParticipations),
+				http.MethodPost:   rr.with(s.postParticipation),
+				http.MethodPut:    rr.with(s.putParticipations),
+				http.MethodDelete: rr.with(s.deleteParticipations),
+			}
+		}},
+	}
+}
+
+//region routing
+
+type routes map[string]http.HandlerFunc
+
+//pathRoute is a path template, in the syntax of ref.Pattern.Routes, with the routes of a resource of which the
+//id of the part (see resourceRequest) is the field sub of the template.
+type pathRoute struct {
+	template string
+	sub      string
+	routes   func(rr resourceRequest) routes
+}
+
+//matchRoute matches the (unescaped) segments of a path with a template of ref.Pattern.Routes, returning the
+//ids by field name.
+func matchRoute(template string, segments []string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(template, "/"), "/")
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+	ids := map[string]string{}
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			ids[part[1:len(part)-1]] = segments[i]
+		} else if part != segments[i] {
+			return nil, false
+		}
+	}
+	return ids, true
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request, rs routes) {
+	if h, found := rs[r.Method]; found {
+		h(w, r)
+		return
+	}
+	if h, found := rs[http.MethodGet]; found && r.Method == http.MethodHead {
+		h(w, r)
+		return
+	}
+	allowed := []string{}
+	for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if _, found := rs[m]; found {
+			allowed = append(allowed, m)
+		}
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, r, aldberr.New(ErrorCodeMethodNotAllowed, "method not allowed", map[string]interface{}{"method": r.Method}))
+}
+
+//resourceRequest contains the path parameters of a request for a part of an activity.
+type resourceRequest struct {
+	//id is the (unescaped) id of the activity.
+	id string
+	//sub is the (unescaped) id of the part of the activity, e.g. a version or an attribute set id.
+	sub string
+}
+
+func (rr resourceRequest) with(h func(http.ResponseWriter, *http.Request, resourceRequest)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, rr)
+	}
+}
+
+func (rr resourceRequest) ref(version string) ref.ActivityRef {
+	u, _ := url.Parse(rr.id)
+	return ref.ActivityRef{Id: u, Version: version}
+}
+
+func splitPath(escaped string) ([]string, error) {
+	out := []string{}
+	for _, seg := range strings.Split(strings.Trim(escaped, "/"), "/") {
+		if len(seg) == 0 {
+			continue
+		}
+		unescaped, err := url.PathUnescape(seg)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, unescaped)
+	}
+	return out, nil
+}
+
+//endregion
+
+//region reading and writing
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	bts, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("cannot encode response: %v", err)
+		writeProblem(w, errcode.NewProblem(aldberr.Wrap(err, ErrorCodeInternal, "cannot encode response", nil), nil))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bts)
+}
+
+//writeActivity writes the activity with its version as ETag, localized for the client (see localizeActivity).
+func writeActivity(w http.ResponseWriter, r *http.Request, status int, a activity.Activity) {
+	w.Header().Set("ETag", `"`+a.Version+`"`)
+	writeJSON(w, status, localizeActivity(languagesFrom(r.Context()), a))
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	bts, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize+1))
+	if err != nil {
+		return nil, aldberr.Wrap(err, ErrorCodeInvalidRequest, "cannot read request body", nil)
+	}
+	if len(bts) > MaxBodySize {
+		return nil, aldberr.New(ErrorCodeInvalidRequest, "request body too large", map[string]interface{}{"maxSize": MaxBodySize})
+	}
+	return bts, nil
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	bts, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bts, v); err != nil {
+		cErr := aldberr.CanvigaError{}
+		if errors.As(err, &cErr) {
+			return cErr
+		}
+		return aldberr.Wrap(err, ErrorCodeInvalidRequest, "invalid JSON body: "+err.Error(), nil)
+	}
+	return nil
+}
+
+//ifMatch returns the version in the If-Match header, if any. "*" is returned as is.
+func ifMatch(r *http.Request) string {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	v = strings.TrimPrefix(v, "W/")
+	return strings.Trim(v, `"`)
+}
+
+//endregion
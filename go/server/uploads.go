@@ -0,0 +1,317 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/vital-dhaveloose/aldb/access"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/mediatype"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+//Uploads let clients send large blob content in chunks, and resume after a connection is lost:
+//
+//	POST   /activities/{id}/blob/uploads             starts an upload of a rendition, see UploadRequest
+//	GET    /activities/{id}/blob/uploads/{uploadId}  returns the UploadStatus, e.g. to find where to resume
+//	PATCH  /activities/{id}/blob/uploads/{uploadId}  appends the body at the offset in the Upload-Offset header
+//	PUT    /activities/{id}/blob/uploads/{uploadId}  completes the upload, creating a new version
+//	DELETE /activities/{id}/blob/uploads/{uploadId}  abandons the upload
+//
+//The content of unfinished uploads is kept in files in the upload directory (see Server.SetUploadDir), so
+//uploads survive restarts of the server.
+
+//UploadRequest is the body of a request to start an upload.
+type UploadRequest struct {
+	//Rendition is the function of the rendition to upload, the main one if empty.
+	Rendition blob.RenditionFunction `json:"rendition,omitempty"`
+	//MediaType is the media type of the content, which is detected when the upload completes if empty.
+	MediaType mediatype.MediaType `json:"mediaType"`
+}
+
+//UploadStatus describes an unfinished upload.
+type UploadStatus struct {
+	Id         string                 `json:"id"`
+	ActivityId string                 `json:"activityId"`
+	Rendition  blob.RenditionFunction `json:"rendition"`
+	MediaType  mediatype.MediaType    `json:"mediaType"`
+	//Offset is the number of bytes received so far, where the next chunk must start.
+	Offset int64 `json:"offset"`
+}
+
+//SetUploadDir sets the directory in which the content of unfinished uploads is kept, by default
+//"aldb-uploads" in the temporary directory of the OS. It is created when the first upload starts.
+func (s *Server) SetUploadDir(dir string) {
+	s.uploadDir = dir
+}
+
+func (s *Server) postUpload(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	req := UploadRequest{}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	fn, err := parseRendition(string(req.Rendition))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	req.Rendition = fn
+	if err := s.authorize(r, rr, access.PermissionWrite); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, found, err := s.latest(r, rr); err != nil || !found {
+		if err == nil {
+			err = aldberr.New(store.ErrorCodeNotFound, "activity not found", map[string]interface{}{"id": rr.id})
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		writeError(w, r, aldberr.Wrap(err, ErrorCodeInternal, "cannot generate upload id", nil))
+		return
+	}
+	status := UploadStatus{Id: hex.EncodeToString(idBytes), ActivityId: rr.id, Rendition: req.Rendition, MediaType: req.MediaType}
+	if err := os.MkdirAll(s.uploadDir, 0o755); err != nil {
+		writeError(w, r, uploadError(err, "cannot create upload directory", s.uploadDir))
+		return
+	}
+	if err := os.WriteFile(s.uploadPath(status.Id, uploadContentSuffix), nil, 0o644); err != nil {
+		writeError(w, r, uploadError(err, "cannot create upload", s.uploadDir))
+		return
+	}
+	bts, _ := json.Marshal(status)
+	if err := os.WriteFile(s.uploadPath(status.Id, uploadMetaSuffix), bts, 0o644); err != nil {
+		writeError(w, r, uploadError(err, "cannot create upload", s.uploadDir))
+		return
+	}
+	w.Header().Set("Location", ActivityPath(rr.id)+"/blob/uploads/"+status.Id)
+	writeUploadStatus(w, http.StatusCreated, status)
+}
+
+//getUpload requires the permission to write the activity, like the other operations on its uploads.
+func (s *Server) getUpload(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	status, err := s.readUpload(rr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.authorize(r, rr, access.PermissionWrite); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUploadStatus(w, http.StatusOK, status)
+}
+
+//patchUpload appends the body to the upload. The Upload-Offset header must be the current offset, so that
+//chunks cannot be applied twice or out of order. If the body is cut off, what was received is kept and the
+//client can resume from the offset in the response.
+func (s *Server) patchUpload(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	release, err := s.lockUpload(rr.sub)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer release()
+	status, err := s.readUpload(rr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.authorize(r, rr, access.PermissionWrite); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		writeError(w, r, aldberr.New(ErrorCodeInvalidRequest, "missing or invalid Upload-Offset", nil))
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(status.Offset, 10))
+	if offset != status.Offset {
+		writeError(w, r, aldberr.New(ErrorCodeUploadOffsetMismatch, "Upload-Offset is not the current offset of the upload", map[string]interface{}{"offset": status.Offset}))
+		return
+	}
+	path := s.uploadPath(status.Id, uploadContentSuffix)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		writeError(w, r, uploadError(err, "cannot open upload", path))
+		return
+	}
+	written, copyErr := io.Copy(f, r.Body)
+	closeErr := f.Close()
+	w.Header().Set("Upload-Offset", strconv.FormatInt(status.Offset+written, 10))
+	switch {
+	case copyErr != nil:
+		writeError(w, r, aldberr.Wrap(copyErr, ErrorCodeInvalidRequest, "upload chunk was cut off", map[string]interface{}{"offset": status.Offset + written}))
+	case closeErr != nil:
+		writeError(w, r, uploadError(closeErr, "cannot write upload", path))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+//putUpload completes the upload: a new version of the activity is created with the uploaded content as
+//rendition, like putBlob does. The request body is ignored, If-Match and Repr-Digest are honoured. The media
+//type of the upload is checked against its content, or detected from it.
+func (s *Server) putUpload(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	release, err := s.lockUpload(rr.sub)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer release()
+	status, err := s.readUpload(rr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	sha, err := parseDigest(r.Header.Get("Repr-Digest"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	path := s.uploadPath(status.Id, uploadContentSuffix)
+	head, err := readHead(path, mediatype.SniffLen)
+	if err != nil {
+		writeError(w, r, uploadError(err, "cannot read upload", path))
+		return
+	}
+	mt, err := contentMediaType(status.MediaType, head)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ok := s.writeRendition(w, r, rr, blob.Rendition{
+		Function: status.Rendition,
+		Manifest: blob.BlobManifest{MediaType: mt, SHA256: sha},
+		Content:  blob.File(path),
+	})
+	if ok {
+		s.removeUpload(status.Id)
+	}
+}
+
+func (s *Server) deleteUpload(w http.ResponseWriter, r *http.Request, rr resourceRequest) {
+	release, err := s.lockUpload(rr.sub)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer release()
+	status, err := s.readUpload(rr)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.authorize(r, rr, access.PermissionWrite); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.removeUpload(status.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//region helpers
+
+const (
+	uploadMetaSuffix    = ".json"
+	uploadContentSuffix = ".part"
+)
+
+//readUpload reads the status of the upload with id rr.sub, which must belong to activity rr.id.
+func (s *Server) readUpload(rr resourceRequest) (UploadStatus, error) {
+	if !isUploadId(rr.sub) {
+		return UploadStatus{}, partNotFound(rr, "upload")
+	}
+	bts, err := os.ReadFile(s.uploadPath(rr.sub, uploadMetaSuffix))
+	if os.IsNotExist(err) {
+		return UploadStatus{}, partNotFound(rr, "upload")
+	}
+	if err != nil {
+		return UploadStatus{}, uploadError(err, "cannot read upload", s.uploadPath(rr.sub, uploadMetaSuffix))
+	}
+	status := UploadStatus{}
+	if err := json.Unmarshal(bts, &status); err != nil {
+		return UploadStatus{}, uploadError(err, "cannot read upload", s.uploadPath(rr.sub, uploadMetaSuffix))
+	}
+	if status.ActivityId != rr.id {
+		return UploadStatus{}, partNotFound(rr, "upload")
+	}
+	info, err := os.Stat(s.uploadPath(rr.sub, uploadContentSuffix))
+	if err != nil {
+		return UploadStatus{}, uploadError(err, "cannot read upload", s.uploadPath(rr.sub, uploadContentSuffix))
+	}
+	status.Offset = info.Size()
+	return status, nil
+}
+
+//lockUpload makes sure that only one request at a time writes to an upload. The returned function releases
+//the lock.
+func (s *Server) lockUpload(id string) (func(), error) {
+	s.uploadsMu.Lock()
+	defer s.uploadsMu.Unlock()
+	if s.busyUploads[id] {
+		return nil, aldberr.New(ErrorCodeUploadBusy, "upload is being written by another request", map[string]interface{}{"upload": id})
+	}
+	s.busyUploads[id] = true
+	return func() {
+		s.uploadsMu.Lock()
+		defer s.uploadsMu.Unlock()
+		delete(s.busyUploads, id)
+	}, nil
+}
+
+//readHead reads the first n bytes of a file, or less if it is shorter.
+func readHead(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, n)
+	read, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:read], nil
+}
+
+func (s *Server) removeUpload(id string) {
+	_ = os.Remove(s.uploadPath(id, uploadMetaSuffix))
+	_ = os.Remove(s.uploadPath(id, uploadContentSuffix))
+}
+
+func (s *Server) uploadPath(id, suffix string) string {
+	return filepath.Join(s.uploadDir, id+suffix)
+}
+
+func writeUploadStatus(w http.ResponseWriter, status int, us UploadStatus) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(us.Offset, 10))
+	writeJSON(w, status, us)
+}
+
+//isUploadId checks that id is a generated upload id, so that it is safe to use in a file name.
+func isUploadId(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func uploadError(err error, msg, path string) error {
+	return aldberr.Wrap(err, ErrorCodeInternal, msg, map[string]interface{}{"path": path})
+}
+
+//endregion
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/blobstore"
+)
+
+//DefaultBlobGracePeriod is a grace period for CollectBlobs that is far longer than any create takes.
+const DefaultBlobGracePeriod = time.Hour
+
+//ReferencedBlobs returns the SHA256 of the content of every rendition of every version of every activity in s.
+func ReferencedBlobs(ctx context.Context, s Store) (map[string]bool, error) {
+	list, err := s.List(ctx, ListRequest{})
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]bool{}
+	for _, a := range list.Activities {
+		history, err := s.History(ctx, HistoryRequest{Ref: a.ActivityRef})
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range history.Versions {
+			if v.Blob == nil {
+				continue
+			}
+			for _, r := range v.Blob.Renditions {
+				if len(r.Manifest.SHA256) > 0 {
+					out[r.Manifest.SHA256] = true
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+//CollectBlobs deletes the content in bs that isn't referenced by any version in s, sparing the content that
+//was stored less than grace ago as it may belong to a version that is being created (see blobstore.Collect).
+//s must be the only Store that keeps its content in bs.
+func CollectBlobs(ctx context.Context, s Store, bs blobstore.Store, grace time.Duration) (blobstore.CollectReport, error) {
+	before := time.Now().Add(-grace)
+	referenced, err := ReferencedBlobs(ctx, s)
+	if err != nil {
+		return blobstore.CollectReport{}, err
+	}
+	return blobstore.Collect(ctx, bs, referenced, before)
+}
+
+//VerifyBlobs re-hashes the content in bs, and reports corrupt content and content referenced by s that is
+//missing (see blobstore.Verify).
+func VerifyBlobs(ctx context.Context, s Store, bs blobstore.Store) (blobstore.VerifyReport, error) {
+	referenced, err := ReferencedBlobs(ctx, s)
+	if err != nil {
+		return blobstore.VerifyReport{}, err
+	}
+	return blobstore.Verify(ctx, bs, referenced)
+}
@@ -0,0 +1,90 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/blobstore"
+	"github.com/vital-dhaveloose/aldb/store"
+	"github.com/vital-dhaveloose/aldb/store/storetest"
+)
+
+type blobsStore interface {
+	store.Store
+	Blobs() blobstore.Store
+}
+
+func TestCollectBlobs(t *testing.T) {
+	fs, err := store.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]blobsStore{"Memory": store.NewMemoryStore(), "Filesystem": fs} {
+		t.Run(name, func(t *testing.T) {
+			testCollectBlobs(t, s)
+		})
+	}
+}
+
+func testCollectBlobs(t *testing.T, s blobsStore) {
+	ctx := context.Background()
+	doc := storetest.NewActivity("doc", "1", "Document")
+	doc.Blob = storetest.NewBlob("image/png", "picture")
+	deck := storetest.NewActivity("deck", "1", "Slide deck")
+	deck.Blob = storetest.NewBlob("image/png", "picture")
+	next := storetest.NewActivity("doc", "2", "Document")
+	next.Blob = storetest.NewBlob("image/png", "edited picture")
+	for _, a := range []activity.Activity{doc, deck, next} {
+		if _, err := s.Create(ctx, store.CreateRequest{ToCreate: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countBlobs(t, s); n != 2 {
+		t.Fatalf("expected the same picture to be stored once, got %d contents", n)
+	}
+
+	if _, err := s.Delete(ctx, store.DeleteRequest{Ref: storetest.Ref("doc", "")}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := store.CollectBlobs(ctx, s, s.Blobs(), store.DefaultBlobGracePeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Deleted) != 0 {
+		t.Errorf("expected recent content to be spared, got %+v", report)
+	}
+	report, err = store.CollectBlobs(ctx, s, s.Blobs(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Deleted) != 1 || report.Kept != 1 {
+		t.Errorf("expected the edited picture to be deleted, got %+v", report)
+	}
+
+	read, err := s.Read(ctx, store.ReadRequest{Ref: storetest.Ref("deck", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, _ := read.Activity.Blob.Main()
+	if bts, err := blob.ReadAll(main.Content); err != nil || string(bts) != "picture" {
+		t.Errorf("expected the shared picture to be kept, got '%s' (%v)", bts, err)
+	}
+	verified, err := store.VerifyBlobs(ctx, s, s.Blobs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.Verified != 1 || len(verified.Problems) != 0 {
+		t.Errorf("unexpected verify report %+v", verified)
+	}
+}
+
+func countBlobs(t *testing.T, s blobsStore) int {
+	t.Helper()
+	res, err := s.Blobs().List(context.Background(), blobstore.ListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(res.Infos)
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/attributes"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+//Detach returns a deep copy of a that shares no mutable state with it, with Subs and Supers replaced by
+//activities that only contain the version-less ActivityRef of the linked activity. Store implementations
+//use it to make sure that they never keep or hand out pointers into their own state.
+func Detach(a activity.Activity) activity.Activity {
+	out := a
+	out.ActivityRef = cloneActivityRef(a.ActivityRef)
+	if a.ParentVersions != nil {
+		out.ParentVersions = append([]string{}, a.ParentVersions...)
+	}
+	if ls, isLs := a.Label.(lang.LocalizableString); isLs && ls != nil {
+		label := make(lang.LocalizableString, len(ls))
+		for l, str := range ls {
+			label[l] = str
+		}
+		out.Label = label
+	}
+	out.Period = a.Period.Clone()
+	if a.Participations != nil {
+		out.Participations = make([]participation.Participation, len(a.Participations))
+		for i, p := range a.Participations {
+			p.ActivityRef = cloneActivityRef(p.ActivityRef)
+			p.Entity = participation.CloneEntity(p.Entity)
+			if p.Role != nil {
+				role := *p.Role
+				p.Role = &role
+			}
+			p.Period = p.Period.Clone()
+			out.Participations[i] = p
+		}
+	}
+	out.Subs = LinkStubs(a.Subs)
+	out.Supers = LinkStubs(a.Supers)
+	if a.AttributeSets != nil {
+		out.AttributeSets = make(map[string]attributes.AttributeSet, len(a.AttributeSets))
+		for id, as := range a.AttributeSets {
+			clone := attributes.AttributeSet{Attributes: cloneValue(as.Attributes).(map[string]interface{})}
+			if as.Manifest != nil {
+				m := *as.Manifest
+				if m.Id != nil {
+					mid := *m.Id
+					m.Id = &mid
+				}
+				if m.Schema != nil {
+					m.Schema = append([]byte{}, m.Schema...)
+				}
+				clone.Manifest = &m
+			}
+			out.AttributeSets[id] = clone
+		}
+	}
+	if a.Blob != nil {
+		//the Content of renditions is immutable, so it can be shared
+		b := blob.Blob{}
+		if a.Blob.Renditions != nil {
+			b.Renditions = make([]blob.Rendition, len(a.Blob.Renditions))
+			for i, r := range a.Blob.Renditions {
+				if r.Manifest.MediaType.Parameters != nil {
+					params := make(map[string]string, len(r.Manifest.MediaType.Parameters))
+					for k, v := range r.Manifest.MediaType.Parameters {
+						params[k] = v
+					}
+					r.Manifest.MediaType.Parameters = params
+				}
+				b.Renditions[i] = r
+			}
+		}
+		out.Blob = &b
+	}
+	return out
+}
+
+//LinkStubs returns activities that only contain the version-less ActivityRef of the given activities,
+//skipping nil entries and activities without an Id.
+func LinkStubs(as []*activity.Activity) []*activity.Activity {
+	if as == nil {
+		return nil
+	}
+	out := make([]*activity.Activity, 0, len(as))
+	for _, a := range as {
+		if a == nil || a.Id == nil {
+			continue
+		}
+		out = append(out, &activity.Activity{ActivityRef: cloneActivityRef(ref.ActivityRef{Id: a.Id})})
+	}
+	return out
+}
+
+func cloneActivityRef(r ref.ActivityRef) ref.ActivityRef {
+	if r.Id != nil {
+		id := *r.Id
+		if id.User != nil {
+			user := *id.User
+			id.User = &user
+		}
+		r.Id = &id
+	}
+	return r
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch vt := v.(type) {
+	case map[string]interface{}:
+		if vt == nil {
+			return vt
+		}
+		out := make(map[string]interface{}, len(vt))
+		for k, e := range vt {
+			out[k] = cloneValue(e)
+		}
+		return out
+	case []interface{}:
+		if vt == nil {
+			return vt
+		}
+		out := make([]interface{}, len(vt))
+		for i, e := range vt {
+			out[i] = cloneValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
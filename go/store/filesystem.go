@@ -0,0 +1,875 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/attributes"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/blobstore"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+const (
+	ErrorCodeFilesystem = "store-fs-error"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeFilesystem, Status: http.StatusInternalServerError, Message: errcode.En("cannot access the activities on the filesystem")},
+	)
+}
+
+const (
+	fsActivityFileSuffix = ".activity.json"
+	fsAttrsDirSuffix     = ".attrs"
+	fsBlobFileSuffix     = ".blob"
+	fsVersionsDirSuffix  = ".versions"
+	fsJSONSuffix         = ".json"
+	fsBlobsDir           = ".blobs"
+)
+
+//FilesystemStore is a Store that keeps its data in a directory tree that can be read (and edited) with
+//ordinary tools, following deprecated-aldb-prototype/on_filesystem.md. Every activity is a "node" with a
+//base path, e.g. "green-corp/odinson-offshore-wind-park", consisting of:
+//
+//	<base>.activity.json     the latest version, without attribute sets and blob content
+//	<base>.attrs/<set>.json  one sidecar file per attribute set of the latest version
+//	<base>.blob[.<ext>]      the content of the main blob rendition of the latest version, as a plain file
+//	<base>.versions/         a JSON snapshot per version, named <sequence>-<version>.json
+//	<base>/                  the folder containing the nodes of which this activity is the primary Super
+//
+//The content of all blob renditions of all versions is kept in a blobstore.FilesystemStore in the .blobs
+//directory of the root, so that content is stored once however many versions and activities share it (see
+//Blobs).
+//
+//The primary Super of an activity is the first of its Supers. The base name of a node is derived from the
+//last path segment of the activity id. The mapping of ids to paths is found by scanning the tree, so nodes
+//can be moved around while the store is in use: see Scan for detecting edits made behind its back.
+//
+//Blob content is streamed to disk before the store is locked, so large blobs don't block other requests.
+type FilesystemStore struct {
+	root  string
+	blobs *blobstore.FilesystemStore
+
+	//mu is a plain mutex, as reads may need to rescan the tree and update the index.
+	mu sync.Mutex
+	//index maps activity ids to base paths relative to root, using forward slashes.
+	index map[string]string
+	//digests maps the absolute paths of the plain blob files that were written or hashed to their checksum,
+	//with the size and modification time they had then, to detect edits without hashing them again.
+	digests map[string]fsDigest
+}
+
+type fsDigest struct {
+	sha256  string
+	size    int64
+	modTime time.Time
+}
+
+//NewFilesystemStore creates a FilesystemStore on the given root directory, creating it if needed. Problems
+//found while scanning the existing tree are not fatal, use Scan to retrieve them.
+func NewFilesystemStore(root string) (*FilesystemStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fsError(err, "cannot create root directory", root)
+	}
+	blobs, err := blobstore.NewFilesystemStore(filepath.Join(root, fsBlobsDir))
+	if err != nil {
+		return nil, err
+	}
+	s := &FilesystemStore{root: root, blobs: blobs, index: map[string]string{}, digests: map[string]fsDigest{}}
+	if _, err := s.scan(false); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//Blobs returns the store of the blob content, of which the unreferenced content can be removed with
+//CollectBlobs.
+func (s *FilesystemStore) Blobs() blobstore.Store {
+	return s.blobs
+}
+
+func (s *FilesystemStore) Create(ctx context.Context, req CreateRequest) (CreateResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return CreateResponse{}, err
+	}
+	id, err := activityKey(req.ToCreate.ActivityRef)
+	if err != nil {
+		return CreateResponse{}, err
+	}
+	toCreate := Detach(req.ToCreate)
+	err = ingestBlob(toCreate.Blob, func(r blob.Rendition) (blob.Rendition, error) {
+		res, err := s.blobs.Put(ctx, blobstore.PutRequest{Rendition: r})
+		return res.Rendition, err
+	})
+	if err != nil {
+		return CreateResponse{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	base, exists, err := s.lookup(id)
+	if err != nil {
+		return CreateResponse{}, err
+	}
+	var versions []fsVersion
+	if exists {
+		if versions, err = s.readVersions(base); err != nil {
+			return CreateResponse{}, err
+		}
+	}
+	versionIds := make([]string, len(versions))
+	for i := range versions {
+		versionIds[i] = versions[i].version
+	}
+	toCreate, err = PrepareVersion(toCreate, versionIds, func(version string) (activity.Activity, error) {
+		i, _ := findVersion(versions, version)
+		return s.readSnapshot(base, versions[i])
+	})
+	if err != nil {
+		return CreateResponse{}, err
+	}
+
+	target := s.targetBase(id, toCreate, base)
+	seq := 0
+	if len(versions) > 0 {
+		seq = versions[len(versions)-1].seq + 1
+	}
+	v := fsVersion{seq: seq, version: toCreate.Version}
+	if exists && target != base {
+		if err := s.moveNode(base, target); err != nil {
+			return CreateResponse{}, err
+		}
+	}
+	if err := s.writeSnapshot(target, v, toCreate); err != nil {
+		return CreateResponse{}, err
+	}
+	if err := s.explode(target, toCreate); err != nil {
+		return CreateResponse{}, err
+	}
+	s.index[id] = target
+	return CreateResponse{Created: Detach(toCreate)}, nil
+}
+
+func (s *FilesystemStore) Read(ctx context.Context, req ReadRequest) (ReadResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ReadResponse{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.read(req.Ref)
+	if err != nil {
+		return ReadResponse{}, err
+	}
+	return ReadResponse{Activity: Detach(a)}, nil
+}
+
+func (s *FilesystemStore) List(ctx context.Context, req ListRequest) (ListResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ListResponse{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.scan(false); err != nil {
+		return ListResponse{}, err
+	}
+	ids := make([]string, 0, len(s.index))
+	for id := range s.index {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	out := make([]activity.Activity, 0, len(ids))
+	for _, id := range ids {
+		a, err := s.readExploded(s.index[id])
+		if err != nil {
+			return ListResponse{}, err
+		}
+		if req.Matches(a) {
+			out = append(out, Detach(a))
+		}
+	}
+	return ListResponse{Activities: out}, nil
+}
+
+func (s *FilesystemStore) Delete(ctx context.Context, req DeleteRequest) (DeleteResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return DeleteResponse{}, err
+	}
+	id, err := activityKey(req.Ref)
+	if err != nil {
+		return DeleteResponse{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	base, exists, err := s.lookup(id)
+	if err != nil {
+		return DeleteResponse{}, err
+	}
+	if !exists {
+		return DeleteResponse{}, notFound(req.Ref)
+	}
+	if len(req.Ref.Version) == 0 {
+		return DeleteResponse{}, s.removeNode(id, base)
+	}
+	versions, err := s.readVersions(base)
+	if err != nil {
+		return DeleteResponse{}, err
+	}
+	i, found := findVersion(versions, req.Ref.Version)
+	if !found {
+		return DeleteResponse{}, notFound(req.Ref)
+	}
+	if len(versions) == 1 {
+		return DeleteResponse{}, s.removeNode(id, base)
+	}
+	if err := os.Remove(s.snapshotPath(base, versions[i])); err != nil {
+		return DeleteResponse{}, fsError(err, "cannot delete version", s.snapshotPath(base, versions[i]))
+	}
+	if i == len(versions)-1 {
+		latest, err := s.readSnapshot(base, versions[i-1])
+		if err != nil {
+			return DeleteResponse{}, err
+		}
+		if err := s.explode(base, latest); err != nil {
+			return DeleteResponse{}, err
+		}
+	}
+	return DeleteResponse{}, nil
+}
+
+func (s *FilesystemStore) ReadSubs(ctx context.Context, req ReadLinksRequest) (ReadLinksResponse, error) {
+	return s.readLinks(ctx, req, func(a activity.Activity) []*activity.Activity { return a.Subs })
+}
+
+func (s *FilesystemStore) ReadSupers(ctx context.Context, req ReadLinksRequest) (ReadLinksResponse, error) {
+	return s.readLinks(ctx, req, func(a activity.Activity) []*activity.Activity { return a.Supers })
+}
+
+func (s *FilesystemStore) readLinks(ctx context.Context, req ReadLinksRequest, getLinks func(a activity.Activity) []*activity.Activity) (ReadLinksResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ReadLinksResponse{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.read(req.Ref)
+	if err != nil {
+		return ReadLinksResponse{}, err
+	}
+	links := LinkStubs(getLinks(a))
+	out := make([]activity.Activity, len(links))
+	for i, link := range links {
+		linked, err := s.read(link.ActivityRef)
+		if err != nil {
+			return ReadLinksResponse{}, err
+		}
+		out[i] = Detach(linked)
+	}
+	return ReadLinksResponse{Activities: out}, nil
+}
+
+func (s *FilesystemStore) History(ctx context.Context, req HistoryRequest) (HistoryResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return HistoryResponse{}, err
+	}
+	id, err := activityKey(req.Ref)
+	if err != nil {
+		return HistoryResponse{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	base, exists, err := s.lookup(id)
+	if err != nil {
+		return HistoryResponse{}, err
+	}
+	if !exists {
+		return HistoryResponse{}, notFound(req.Ref)
+	}
+	versions, err := s.readVersions(base)
+	if err != nil {
+		return HistoryResponse{}, err
+	}
+	latest, err := s.readExploded(base)
+	if err != nil {
+		return HistoryResponse{}, err
+	}
+	out := make([]activity.Activity, len(versions))
+	for i, v := range versions {
+		a := latest
+		if v.version != latest.Version {
+			if a, err = s.readSnapshot(base, v); err != nil {
+				return HistoryResponse{}, err
+			}
+		}
+		out[i] = Detach(a)
+	}
+	return HistoryResponse{Versions: out}, nil
+}
+
+//region reading and writing nodes
+
+type fsVersion struct {
+	seq     int
+	version string
+}
+
+//lookup returns the base path of the activity with the given id. If the indexed node is gone, the tree is
+//rescanned once to find out whether it was moved. Must be called with the write lock held.
+func (s *FilesystemStore) lookup(id string) (base string, exists bool, err error) {
+	base, exists = s.index[id]
+	if exists && fileExists(s.abs(base+fsActivityFileSuffix)) {
+		return base, true, nil
+	}
+	if _, err := s.scan(false); err != nil {
+		return "", false, err
+	}
+	base, exists = s.index[id]
+	return base, exists, nil
+}
+
+//read must be called with the write lock held, as it may need to rescan the tree.
+func (s *FilesystemStore) read(r ref.ActivityRef) (activity.Activity, error) {
+	id, err := activityKey(r)
+	if err != nil {
+		return activity.Activity{}, err
+	}
+	base, exists, err := s.lookup(id)
+	if err != nil {
+		return activity.Activity{}, err
+	}
+	if !exists {
+		return activity.Activity{}, notFound(r)
+	}
+	latest, err := s.readExploded(base)
+	if err != nil {
+		return activity.Activity{}, err
+	}
+	if len(r.Version) == 0 || r.Version == latest.Version {
+		return latest, nil
+	}
+	versions, err := s.readVersions(base)
+	if err != nil {
+		return activity.Activity{}, err
+	}
+	i, found := findVersion(versions, r.Version)
+	if !found {
+		return activity.Activity{}, notFound(r)
+	}
+	return s.readSnapshot(base, versions[i])
+}
+
+func (s *FilesystemStore) readExploded(base string) (activity.Activity, error) {
+	a := activity.Activity{}
+	if err := readJSONFile(s.abs(base+fsActivityFileSuffix), &a); err != nil {
+		return activity.Activity{}, err
+	}
+
+	attrsDir := s.abs(base + fsAttrsDirSuffix)
+	entries, err := os.ReadDir(attrsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return activity.Activity{}, fsError(err, "cannot read attribute sets", attrsDir)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), fsJSONSuffix) {
+			continue
+		}
+		setId, err := url.PathUnescape(strings.TrimSuffix(entry.Name(), fsJSONSuffix))
+		if err != nil {
+			return activity.Activity{}, fsError(err, "invalid attribute set file name", filepath.Join(attrsDir, entry.Name()))
+		}
+		as := attributes.AttributeSet{}
+		if err := readJSONFile(filepath.Join(attrsDir, entry.Name()), &as); err != nil {
+			return activity.Activity{}, err
+		}
+		if a.AttributeSets == nil {
+			a.AttributeSets = map[string]attributes.AttributeSet{}
+		}
+		a.AttributeSets[setId] = as
+	}
+
+	if err := s.attachContents(&a); err != nil {
+		return activity.Activity{}, err
+	}
+	//the plain blob file may have been edited, it takes precedence over the content of the latest version. An
+	//edit that wasn't hashed by Scan yet is read without a checksum, and is hashed when the next write ingests
+	//it.
+	blobPath, found, err := s.findBlobFile(base)
+	if err != nil {
+		return activity.Activity{}, err
+	}
+	if found {
+		if a.Blob == nil {
+			a.Blob = &blob.Blob{}
+		}
+		main, _ := a.Blob.Main()
+		sum, size, err := s.blobDigest(base, blobPath, main.Manifest, false)
+		if err != nil {
+			return activity.Activity{}, err
+		}
+		main.Function = blob.RenditionFunctionMain
+		main.Manifest.Size = size
+		main.Manifest.SHA256 = sum
+		main.Content = blob.File(blobPath)
+		*a.Blob = a.Blob.WithRendition(main)
+	}
+	return a, nil
+}
+
+//blobDigest returns the checksum and the size of the plain blob file at path of the node at base. The file is
+//taken to be unchanged if it has the size and modification time it had when it was last written or hashed,
+//or, for files not seen since the store was opened, the size of main and a modification time no later than
+//the one of the activity file, which is written after it. Otherwise the file is hashed if hash is set, and
+//has an empty checksum if not. Must be called with the write lock held.
+func (s *FilesystemStore) blobDigest(base, path string, main blob.BlobManifest, hash bool) (string, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, fsError(err, "cannot read blob", path)
+	}
+	if d, found := s.digests[path]; found {
+		if d.size == info.Size() && d.modTime.Equal(info.ModTime()) {
+			return d.sha256, d.size, nil
+		}
+	} else if len(main.SHA256) > 0 && main.Size == info.Size() {
+		written, err := os.Stat(s.abs(base + fsActivityFileSuffix))
+		if err == nil && !info.ModTime().After(written.ModTime()) {
+			s.digests[path] = fsDigest{sha256: main.SHA256, size: info.Size(), modTime: info.ModTime()}
+			return main.SHA256, info.Size(), nil
+		}
+	}
+	if !hash {
+		return "", info.Size(), nil
+	}
+	sum, size, err := fileDigest(path)
+	if err != nil {
+		return "", 0, err
+	}
+	s.digests[path] = fsDigest{sha256: sum, size: size, modTime: info.ModTime()}
+	return sum, size, nil
+}
+
+//fileDigest returns the hex encoded SHA-256 checksum and the size of the file at path.
+func fileDigest(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, fsError(err, "cannot read blob", path)
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fsError(err, "cannot read blob", path)
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+//explode writes a as the latest version of the node at base: the activity file, the attribute set
+//sidecars and the blob file. Sidecars and blob files of the previous latest version are removed.
+func (s *FilesystemStore) explode(base string, a activity.Activity) error {
+	if err := os.MkdirAll(filepath.Dir(s.abs(base)), 0o755); err != nil {
+		return fsError(err, "cannot create directory", filepath.Dir(s.abs(base)))
+	}
+
+	attrsDir := s.abs(base + fsAttrsDirSuffix)
+	if err := os.RemoveAll(attrsDir); err != nil {
+		return fsError(err, "cannot clear attribute sets", attrsDir)
+	}
+	if len(a.AttributeSets) > 0 {
+		if err := os.MkdirAll(attrsDir, 0o755); err != nil {
+			return fsError(err, "cannot create directory", attrsDir)
+		}
+	}
+	for setId, as := range a.AttributeSets {
+		if err := writeJSONFile(filepath.Join(attrsDir, url.PathEscape(setId)+fsJSONSuffix), as); err != nil {
+			return err
+		}
+	}
+
+	if err := s.explodeBlob(base, a); err != nil {
+		return err
+	}
+	stripped := a
+	stripped.AttributeSets = nil
+	return writeJSONFile(s.abs(base+fsActivityFileSuffix), stripped)
+}
+
+//explodeBlob writes the content of the main rendition of a to the plain blob file. The existing file is
+//kept if the content didn't change, to avoid copying large blobs for every version.
+func (s *FilesystemStore) explodeBlob(base string, a activity.Activity) error {
+	main, hasMain := a.Blob.Main()
+	blobPath := s.abs(base + fsBlobFileSuffix + blobExtension(main.Manifest))
+	oldBlobPath, found, err := s.findBlobFile(base)
+	if err != nil {
+		return err
+	}
+	if found && hasMain && oldBlobPath == blobPath && len(main.Manifest.SHA256) > 0 {
+		previous := activity.Activity{}
+		if err := readJSONFile(s.abs(base+fsActivityFileSuffix), &previous); err == nil {
+			if previousMain, found := previous.Blob.Main(); found && previousMain.Manifest.SHA256 == main.Manifest.SHA256 {
+				return nil
+			}
+		}
+	}
+	if found {
+		if err := os.Remove(oldBlobPath); err != nil {
+			return fsError(err, "cannot remove blob", oldBlobPath)
+		}
+		delete(s.digests, oldBlobPath)
+	}
+	if !hasMain || main.Content == nil {
+		return nil
+	}
+	if err := copyContent(main.Content, blobPath); err != nil {
+		return err
+	}
+	if info, err := os.Stat(blobPath); err == nil && len(main.Manifest.SHA256) > 0 {
+		s.digests[blobPath] = fsDigest{sha256: main.Manifest.SHA256, size: info.Size(), modTime: info.ModTime()}
+	}
+	return nil
+}
+
+//attachContents sets the Content of the renditions of a to the stored content with their SHA256. Renditions of
+//which the content is missing are left without Content, Verify reports them.
+func (s *FilesystemStore) attachContents(a *activity.Activity) error {
+	if a.Blob == nil {
+		return nil
+	}
+	b := blob.Blob{Renditions: make([]blob.Rendition, len(a.Blob.Renditions))}
+	for i, r := range a.Blob.Renditions {
+		r.Content = nil
+		if len(r.Manifest.SHA256) > 0 {
+			got, err := s.blobs.Get(context.Background(), blobstore.GetRequest{SHA256: r.Manifest.SHA256})
+			if err != nil && !aldberr.IsCode(err, blobstore.ErrorCodeNotFound) && !aldberr.IsCode(err, blobstore.ErrorCodeInvalidDigest) {
+				return err
+			}
+			r.Content = got.Content
+		}
+		b.Renditions[i] = r
+	}
+	a.Blob = &b
+	return nil
+}
+
+func (s *FilesystemStore) readVersions(base string) ([]fsVersion, error) {
+	dir := s.abs(base + fsVersionsDirSuffix)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fsError(err, "cannot read versions", dir)
+	}
+	out := make([]fsVersion, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, fsJSONSuffix) {
+			continue
+		}
+		seqStr, escapedVersion, found := strings.Cut(strings.TrimSuffix(name, fsJSONSuffix), "-")
+		seq, seqErr := strconv.Atoi(seqStr)
+		version, versionErr := url.PathUnescape(escapedVersion)
+		if !found || seqErr != nil || versionErr != nil {
+			return nil, fsError(nil, "invalid version file name", filepath.Join(dir, name))
+		}
+		out = append(out, fsVersion{seq: seq, version: version})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].seq < out[j].seq })
+	return out, nil
+}
+
+func (s *FilesystemStore) snapshotPath(base string, v fsVersion) string {
+	return filepath.Join(s.abs(base+fsVersionsDirSuffix), fmt.Sprintf("%06d-%s%s", v.seq, url.PathEscape(v.version), fsJSONSuffix))
+}
+
+func (s *FilesystemStore) readSnapshot(base string, v fsVersion) (activity.Activity, error) {
+	a := activity.Activity{}
+	if err := readJSONFile(s.snapshotPath(base, v), &a); err != nil {
+		return activity.Activity{}, err
+	}
+	if err := s.attachContents(&a); err != nil {
+		return activity.Activity{}, err
+	}
+	return a, nil
+}
+
+func (s *FilesystemStore) writeSnapshot(base string, v fsVersion, a activity.Activity) error {
+	dir := s.abs(base + fsVersionsDirSuffix)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fsError(err, "cannot create directory", dir)
+	}
+	return writeJSONFile(s.snapshotPath(base, v), a)
+}
+
+func (s *FilesystemStore) findBlobFile(base string) (string, bool, error) {
+	prefix := s.abs(base + fsBlobFileSuffix)
+	entries, err := os.ReadDir(filepath.Dir(prefix))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fsError(err, "cannot read directory", filepath.Dir(prefix))
+	}
+	name := filepath.Base(prefix)
+	for _, entry := range entries {
+		if !entry.IsDir() && (entry.Name() == name || strings.HasPrefix(entry.Name(), name+".")) {
+			return filepath.Join(filepath.Dir(prefix), entry.Name()), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+//targetBase returns the base path a should have: inside the folder of its primary Super if that one is
+//known, in the root otherwise. The name of an existing node is kept.
+func (s *FilesystemStore) targetBase(id string, a activity.Activity, current string) string {
+	dir := ""
+	if supers := LinkStubs(a.Supers); len(supers) > 0 {
+		if superBase, found := s.index[supers[0].Id.String()]; found {
+			dir = superBase
+		}
+	}
+	name := ""
+	if len(current) > 0 {
+		name = pathBaseName(current)
+	} else {
+		name = s.newName(dir, id)
+	}
+	if len(dir) == 0 {
+		return name
+	}
+	return dir + "/" + name
+}
+
+//newName derives a readable base name for a new node in dir from the last path segment of its id,
+//adding a hash of the id if that name is already taken.
+func (s *FilesystemStore) newName(dir string, id string) string {
+	name := ""
+	if u, err := url.Parse(id); err == nil {
+		segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+		name = segments[len(segments)-1]
+		if len(name) == 0 {
+			name = u.Host
+		}
+	}
+	name = sanitizeName(name)
+	taken := func(n string) bool {
+		base := n
+		if len(dir) > 0 {
+			base = dir + "/" + n
+		}
+		for _, b := range s.index {
+			if b == base {
+				return true
+			}
+		}
+		return fileExists(s.abs(base+fsActivityFileSuffix)) || fileExists(s.abs(base))
+	}
+	if len(name) > 0 && !taken(name) {
+		return name
+	}
+	sum := sha256.Sum256([]byte(id))
+	if len(name) == 0 {
+		return hex.EncodeToString(sum[:8])
+	}
+	return name + "-" + hex.EncodeToString(sum[:4])
+}
+
+func (s *FilesystemStore) moveNode(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(s.abs(to)), 0o755); err != nil {
+		return fsError(err, "cannot create directory", filepath.Dir(s.abs(to)))
+	}
+	blobPath, found, err := s.findBlobFile(from)
+	if err != nil {
+		return err
+	}
+	pieces := [][2]string{
+		{s.abs(from + fsActivityFileSuffix), s.abs(to + fsActivityFileSuffix)},
+		{s.abs(from + fsAttrsDirSuffix), s.abs(to + fsAttrsDirSuffix)},
+		{s.abs(from + fsVersionsDirSuffix), s.abs(to + fsVersionsDirSuffix)},
+		{s.abs(from), s.abs(to)},
+	}
+	if found {
+		pieces = append(pieces, [2]string{blobPath, s.abs(to) + strings.TrimPrefix(blobPath, s.abs(from))})
+	}
+	for _, piece := range pieces {
+		if !fileExists(piece[0]) {
+			continue
+		}
+		if err := os.Rename(piece[0], piece[1]); err != nil {
+			return fsError(err, "cannot move node", piece[0])
+		}
+	}
+	//nodes inside the moved folder moved along
+	for id, base := range s.index {
+		if strings.HasPrefix(base, from+"/") {
+			s.index[id] = to + strings.TrimPrefix(base, from)
+		}
+	}
+	return nil
+}
+
+//removeNode removes all files of the node at base. Its folder is only removed if it is empty: nodes that
+//have this activity as primary Super are left in place (and will be reported by Scan).
+func (s *FilesystemStore) removeNode(id, base string) error {
+	blobPath, found, err := s.findBlobFile(base)
+	if err != nil {
+		return err
+	}
+	if found {
+		if err := os.Remove(blobPath); err != nil {
+			return fsError(err, "cannot remove blob", blobPath)
+		}
+	}
+	for _, p := range []string{s.abs(base + fsAttrsDirSuffix), s.abs(base + fsVersionsDirSuffix), s.abs(base + fsActivityFileSuffix)} {
+		if err := os.RemoveAll(p); err != nil {
+			return fsError(err, "cannot remove node", p)
+		}
+	}
+	if entries, err := os.ReadDir(s.abs(base)); err == nil && len(entries) == 0 {
+		_ = os.Remove(s.abs(base))
+	}
+	delete(s.index, id)
+	return nil
+}
+
+func (s *FilesystemStore) abs(rel string) string {
+	return filepath.Join(s.root, filepath.FromSlash(rel))
+}
+
+//endregion
+
+func findVersion(versions []fsVersion, version string) (int, bool) {
+	for i := range versions {
+		if versions[i].version == version {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+//preferredExtensions overrides the first extension known by the mime package for common media types.
+var preferredExtensions = map[string]string{
+	"text/plain":    ".txt",
+	"text/markdown": ".md",
+	"text/html":     ".html",
+	"image/jpeg":    ".jpg",
+}
+
+func blobExtension(m blob.BlobManifest) string {
+	if m.MediaType.IsZero() {
+		return ""
+	}
+	if ext, found := preferredExtensions[m.MediaType.Essence()]; found {
+		return ext
+	}
+	exts, err := mime.ExtensionsByType(m.MediaType.Essence())
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	sort.Strings(exts)
+	return exts[0]
+}
+
+func sanitizeName(name string) string {
+	out := strings.Builder{}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			out.WriteRune(r)
+		default:
+			out.WriteRune('_')
+		}
+	}
+	if out.Len() > 100 {
+		return out.String()[:100]
+	}
+	return out.String()
+}
+
+func pathBaseName(base string) string {
+	if i := strings.LastIndex(base, "/"); i >= 0 {
+		return base[i+1:]
+	}
+	return base
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func readJSONFile(path string, v interface{}) error {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return fsError(err, "cannot read file", path)
+	}
+	if err := json.Unmarshal(bts, v); err != nil {
+		return fsError(err, "cannot parse file", path)
+	}
+	return nil
+}
+
+//copyContent writes c to the file at path, through a temporary file.
+func copyContent(c blob.Content, path string) error {
+	rc, err := c.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fsError(err, "cannot write blob content", path)
+	}
+	_, err = io.Copy(f, rc)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fsError(err, "cannot write blob content", path)
+	}
+	return nil
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	bts, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fsError(err, "cannot encode file", path)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(bts, '\n'), 0o644); err != nil {
+		return fsError(err, "cannot write file", path)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fsError(err, "cannot write file", path)
+	}
+	return nil
+}
+
+func fsError(err error, msg, path string) error {
+	return aldberr.Wrap(err, ErrorCodeFilesystem, msg, map[string]interface{}{"path": path})
+}
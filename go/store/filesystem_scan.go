@@ -0,0 +1,225 @@
+package store
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+)
+
+//Codes of the problems reported by FilesystemStore.Scan.
+const (
+	//ErrorCodeFsNodeMoved indicates that the files of an activity were moved (e.g. a folder was renamed).
+	ErrorCodeFsNodeMoved = "store-fs-node-moved"
+	//ErrorCodeFsNodeDeleted indicates that the files of an activity were deleted.
+	ErrorCodeFsNodeDeleted = "store-fs-node-deleted"
+	//ErrorCodeFsNodeInvalid indicates that an activity file cannot be parsed or has no id.
+	ErrorCodeFsNodeInvalid = "store-fs-node-invalid"
+	//ErrorCodeFsDuplicateId indicates that several activity files have the same id. Only the first one (in
+	//lexical order of the paths) is used.
+	ErrorCodeFsDuplicateId = "store-fs-duplicate-id"
+	//ErrorCodeFsBrokenSuper indicates that an activity lists a Super that doesn't exist.
+	ErrorCodeFsBrokenSuper = "store-fs-broken-super"
+	//ErrorCodeFsMisplaced indicates that an activity isn't in the folder of its primary Super.
+	ErrorCodeFsMisplaced = "store-fs-misplaced"
+	//ErrorCodeFsOrphan indicates a folder, sidecar, blob or versions directory without an activity file.
+	ErrorCodeFsOrphan = "store-fs-orphan"
+	//ErrorCodeFsBlobEdited indicates that the plain blob file of an activity doesn't match the checksum of its
+	//main rendition, because it was edited. The edit is read as the content of the latest version, and is
+	//stored as a new version by the next write of the activity.
+	ErrorCodeFsBlobEdited = "store-fs-blob-edited"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeFsNodeMoved, Status: http.StatusInternalServerError, Message: errcode.En("the files of an activity were moved")},
+		errcode.Code{Code: ErrorCodeFsNodeDeleted, Status: http.StatusInternalServerError, Message: errcode.En("the files of an activity were deleted")},
+		errcode.Code{Code: ErrorCodeFsNodeInvalid, Status: http.StatusInternalServerError, Message: errcode.En("an activity file cannot be parsed")},
+		errcode.Code{Code: ErrorCodeFsDuplicateId, Status: http.StatusInternalServerError, Message: errcode.En("several activity files have the same id")},
+		errcode.Code{Code: ErrorCodeFsBrokenSuper, Status: http.StatusInternalServerError, Message: errcode.En("an activity file lists a super that doesn't exist")},
+		errcode.Code{Code: ErrorCodeFsMisplaced, Status: http.StatusInternalServerError, Message: errcode.En("an activity isn't in the folder of its primary super")},
+		errcode.Code{Code: ErrorCodeFsOrphan, Status: http.StatusInternalServerError, Message: errcode.En("a file or folder without an activity file")},
+		errcode.Code{Code: ErrorCodeFsBlobEdited, Status: http.StatusInternalServerError, Message: errcode.En("the blob file of an activity was edited")},
+	)
+}
+
+type ScanReport struct {
+	//Problems found in the tree, each with a path and, where relevant, an id in its details.
+	Problems []aldberr.CanvigaError
+}
+
+//Scan walks the directory tree, rebuilds the mapping of ids to paths and reports the problems caused by
+//edits made behind the store's back. Moved activities are picked up from their new location, all other
+//problems are only reported. Plain blob files of which the size or modification time changed are hashed to
+//find out whether they were edited.
+func (s *FilesystemStore) Scan(ctx context.Context) (ScanReport, error) {
+	if err := ctx.Err(); err != nil {
+		return ScanReport{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scan(true)
+}
+
+//scan must be called with the write lock held. Plain blob files that look edited are only hashed, and
+//reported, if hash is set, as the other scans are only interested in the mapping of ids to paths.
+func (s *FilesystemStore) scan(hash bool) (ScanReport, error) {
+	report := ScanReport{}
+	problem := func(code, msg, path string, det map[string]interface{}) {
+		if det == nil {
+			det = map[string]interface{}{}
+		}
+		det["path"] = path
+		report.Problems = append(report.Problems, aldberr.New(code, msg, det))
+	}
+
+	bases := []string{}
+	others := []string{}
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(s.root, path)
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if rel == fsBlobsDir {
+			return filepath.SkipDir
+		}
+		if d.IsDir() && (strings.HasSuffix(rel, fsAttrsDirSuffix) || strings.HasSuffix(rel, fsVersionsDirSuffix)) {
+			others = append(others, rel)
+			return filepath.SkipDir
+		}
+		if strings.HasSuffix(rel, fsActivityFileSuffix) {
+			bases = append(bases, strings.TrimSuffix(rel, fsActivityFileSuffix))
+		} else if !strings.HasSuffix(rel, ".tmp") {
+			others = append(others, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return ScanReport{}, fsError(err, "cannot scan directory", s.root)
+	}
+	sort.Strings(bases)
+
+	index := map[string]string{}
+	nodes := map[string]activity.Activity{}
+	isBase := map[string]bool{}
+	for _, base := range bases {
+		isBase[base] = true
+		a := activity.Activity{}
+		if err := readJSONFile(s.abs(base+fsActivityFileSuffix), &a); err != nil {
+			problem(ErrorCodeFsNodeInvalid, "activity file cannot be parsed", base+fsActivityFileSuffix, map[string]interface{}{"error": err.Error()})
+			continue
+		}
+		id, err := activityKey(a.ActivityRef)
+		if err != nil {
+			problem(ErrorCodeFsNodeInvalid, "activity file has no id", base+fsActivityFileSuffix, nil)
+			continue
+		}
+		if other, found := index[id]; found {
+			problem(ErrorCodeFsDuplicateId, "activity file has the same id as another one", base+fsActivityFileSuffix, map[string]interface{}{"id": id, "otherPath": other + fsActivityFileSuffix})
+			continue
+		}
+		index[id] = base
+		nodes[id] = a
+	}
+
+	oldIds := make([]string, 0, len(s.index))
+	for id := range s.index {
+		oldIds = append(oldIds, id)
+	}
+	sort.Strings(oldIds)
+	for _, id := range oldIds {
+		oldBase := s.index[id]
+		newBase, found := index[id]
+		if !found {
+			problem(ErrorCodeFsNodeDeleted, "activity was deleted", oldBase+fsActivityFileSuffix, map[string]interface{}{"id": id})
+		} else if newBase != oldBase {
+			problem(ErrorCodeFsNodeMoved, "activity was moved", newBase+fsActivityFileSuffix, map[string]interface{}{"id": id, "previousPath": oldBase + fsActivityFileSuffix})
+		}
+	}
+
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		base := index[id]
+		supers := LinkStubs(nodes[id].Supers)
+		for _, super := range supers {
+			if _, found := index[super.Id.String()]; !found {
+				problem(ErrorCodeFsBrokenSuper, "activity has a Super that doesn't exist", base+fsActivityFileSuffix, map[string]interface{}{"id": id, "superId": super.Id.String()})
+			}
+		}
+		expectedDir := ""
+		if len(supers) > 0 {
+			if superBase, found := index[supers[0].Id.String()]; found {
+				expectedDir = superBase
+			}
+		}
+		dir := ""
+		if i := strings.LastIndex(base, "/"); i >= 0 {
+			dir = base[:i]
+		}
+		if dir != expectedDir {
+			problem(ErrorCodeFsMisplaced, "activity is not in the folder of its primary Super", base+fsActivityFileSuffix, map[string]interface{}{"id": id, "expectedFolder": expectedDir})
+		}
+		if main, hasMain := nodes[id].Blob.Main(); hash && hasMain && len(main.Manifest.SHA256) > 0 {
+			blobPath, found, err := s.findBlobFile(base)
+			if err != nil {
+				return ScanReport{}, err
+			}
+			if found {
+				sum, _, err := s.blobDigest(base, blobPath, main.Manifest, true)
+				if err != nil {
+					return ScanReport{}, err
+				}
+				if sum != main.Manifest.SHA256 {
+					rel, _ := filepath.Rel(s.root, blobPath)
+					problem(ErrorCodeFsBlobEdited, "blob file doesn't match the checksum of the latest version", filepath.ToSlash(rel), map[string]interface{}{"id": id, "expected": main.Manifest.SHA256, "actual": sum})
+				}
+			}
+		}
+	}
+
+	for _, other := range others {
+		owner, isNodePart := nodePartOwner(other)
+		if !isNodePart {
+			if !dirExists(s.abs(other)) {
+				//unrelated files are tolerated
+				continue
+			}
+			owner = other
+		}
+		if !isBase[owner] {
+			problem(ErrorCodeFsOrphan, "file or folder doesn't belong to an activity", other, nil)
+		}
+	}
+
+	s.index = index
+	return report, nil
+}
+
+//nodePartOwner returns the base path of the node that rel is a part of, if rel is named like an attribute
+//sets directory, a versions directory or a blob file.
+func nodePartOwner(rel string) (string, bool) {
+	for _, suffix := range []string{fsAttrsDirSuffix, fsVersionsDirSuffix, fsBlobFileSuffix} {
+		if strings.HasSuffix(rel, suffix) {
+			return strings.TrimSuffix(rel, suffix), true
+		}
+	}
+	name := pathBaseName(rel)
+	if i := strings.Index(name, fsBlobFileSuffix+"."); i > 0 {
+		return strings.TrimSuffix(rel, name) + name[:i], true
+	}
+	return "", false
+}
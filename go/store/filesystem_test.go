@@ -0,0 +1,236 @@
+package store_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/mediatype"
+	"github.com/vital-dhaveloose/aldb/store"
+	"github.com/vital-dhaveloose/aldb/store/storetest"
+)
+
+func TestFilesystemStoreConformance(t *testing.T) {
+	storetest.RunConformanceTests(t, func(t *testing.T) store.Store {
+		s, err := store.NewFilesystemStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestFilesystemStoreLayout(t *testing.T) {
+	root := t.TempDir()
+	s, err := store.NewFilesystemStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corp := storetest.NewActivity("green-corp", "1", "Green Corp")
+	park := storetest.NewActivity("odinson", "", "Odinson")
+	park.Supers = []*activity.Activity{&corp}
+	park.Blob = storetest.NewBlob("text/plain", "plain text")
+	for _, a := range []activity.Activity{corp, park} {
+		if _, err := s.Create(context.Background(), store.CreateRequest{ToCreate: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	thumb := park.Blob.WithRendition(blob.Rendition{
+		Function: blob.RenditionFunctionThumbnail,
+		Manifest: blob.BlobManifest{MediaType: mediatype.MustParse("image/png")},
+		Content:  blob.Bytes([]byte("png")),
+	})
+	next := activity.Activity{ActivityRef: storetest.Ref("odinson", "")}
+	next.Blob = &thumb
+	if _, err := s.Create(context.Background(), store.CreateRequest{ToCreate: next}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{
+		"green-corp.activity.json",
+		"green-corp.attrs/test-attrs.json",
+		"green-corp.versions/000000-1.json",
+		"green-corp/odinson.activity.json",
+		"green-corp/odinson.attrs/test-attrs.json",
+		"green-corp/odinson.blob.txt",
+	} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("expected %s to exist: %v", p, err)
+		}
+	}
+	//the content of all versions is kept once, by digest
+	contents, err := filepath.Glob(filepath.Join(root, ".blobs/sha256/*/*"))
+	if err != nil || len(contents) != 2 {
+		t.Errorf("expected 2 content files (main and thumbnail), got %v (%v)", contents, err)
+	}
+	bts, err := os.ReadFile(filepath.Join(root, "green-corp/odinson.blob.txt"))
+	if err != nil || string(bts) != "plain text" {
+		t.Errorf("expected the blob as a plain file, got '%s' (%v)", bts, err)
+	}
+}
+
+func TestFilesystemStoreBlobEdit(t *testing.T) {
+	root := t.TempDir()
+	s, err := store.NewFilesystemStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	park := storetest.NewActivity("odinson", "", "Odinson")
+	park.Blob = storetest.NewBlob("text/plain", "plain text")
+	if _, err := s.Create(ctx, store.CreateRequest{ToCreate: park}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "odinson.blob.txt"), []byte("edited text"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.Scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, report, map[string]int{store.ErrorCodeFsBlobEdited: 1})
+
+	//the edit is read with a digest of its own, and the next write stores it
+	sum := sha256.Sum256([]byte("edited text"))
+	read, err := s.Read(ctx, store.ReadRequest{Ref: storetest.Ref("odinson", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main, _ := read.Activity.Blob.Main(); main.Manifest.SHA256 != hex.EncodeToString(sum[:]) || main.Manifest.Size != 11 {
+		t.Errorf("expected the manifest of the edit, got %+v", main.Manifest)
+	}
+	next := read.Activity
+	next.Version, next.ParentVersions = "", nil
+	created, err := s.Create(ctx, store.CreateRequest{ToCreate: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main, _ := created.Created.Blob.Main(); main.Manifest.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the edit in the new version, got %+v", main.Manifest)
+	}
+	if report, err = s.Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, report, map[string]int{store.ErrorCodeFsBlobEdited: 0})
+	bts, err := os.ReadFile(filepath.Join(root, "odinson.blob.txt"))
+	if err != nil || string(bts) != "edited text" {
+		t.Errorf("expected the edit to stay in the plain file, got '%s' (%v)", bts, err)
+	}
+	contents, err := filepath.Glob(filepath.Join(root, ".blobs/sha256/*/*"))
+	if err != nil || len(contents) != 2 {
+		t.Errorf("expected 2 content files (original and edit), got %v (%v)", contents, err)
+	}
+
+	//without a Scan, an edit is read without a checksum and hashed by the next write
+	if s, err = store.NewFilesystemStore(root); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = s.Read(ctx, store.ReadRequest{Ref: storetest.Ref("odinson", "")}); err != nil {
+		t.Fatal(err)
+	}
+	if main, _ := read.Activity.Blob.Main(); main.Manifest.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the unchanged blob file to keep its checksum, got %+v", main.Manifest)
+	}
+	if err := os.WriteFile(filepath.Join(root, "odinson.blob.txt"), []byte("edited again"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = s.Read(ctx, store.ReadRequest{Ref: storetest.Ref("odinson", "")}); err != nil {
+		t.Fatal(err)
+	}
+	if main, _ := read.Activity.Blob.Main(); main.Manifest.SHA256 != "" || main.Manifest.Size != 12 {
+		t.Errorf("expected the edit without a checksum, got %+v", main.Manifest)
+	}
+	next = read.Activity
+	next.Version, next.ParentVersions = "", nil
+	if created, err = s.Create(ctx, store.CreateRequest{ToCreate: next}); err != nil {
+		t.Fatal(err)
+	}
+	sum = sha256.Sum256([]byte("edited again"))
+	if main, _ := created.Created.Blob.Main(); main.Manifest.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the second edit in the new version, got %+v", main.Manifest)
+	}
+}
+
+func TestFilesystemStoreScanDetectsExternalEdits(t *testing.T) {
+	root := t.TempDir()
+	s, err := store.NewFilesystemStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	corp := storetest.NewActivity("green-corp", "", "Green Corp")
+	park := storetest.NewActivity("odinson", "", "Odinson")
+	park.Supers = []*activity.Activity{&corp}
+	legal := storetest.NewActivity("legal", "", "Legal")
+	legal.Supers = []*activity.Activity{&park}
+	for _, a := range []activity.Activity{corp, park, legal} {
+		if _, err := s.Create(ctx, store.CreateRequest{ToCreate: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//renaming a folder moves the activities in it, and leaves the folder without its activity file
+	if err := os.Rename(filepath.Join(root, "green-corp"), filepath.Join(root, "green")); err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.Scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, report, map[string]int{
+		store.ErrorCodeFsNodeMoved:   2,
+		store.ErrorCodeFsMisplaced:   1,
+		store.ErrorCodeFsOrphan:      1,
+		store.ErrorCodeFsBrokenSuper: 0,
+	})
+	resp, err := s.Read(ctx, store.ReadRequest{Ref: storetest.Ref("legal", "")})
+	if err != nil {
+		t.Fatalf("expected moved activity to be readable: %v", err)
+	}
+	if len(resp.Activity.Supers) != 1 {
+		t.Errorf("expected one super, got %d", len(resp.Activity.Supers))
+	}
+
+	//deleting an activity file loses the activity and breaks the links to it
+	if err := os.Remove(filepath.Join(root, "green/odinson.activity.json")); err != nil {
+		t.Fatal(err)
+	}
+	report, err = s.Scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, report, map[string]int{
+		store.ErrorCodeFsNodeDeleted: 1,
+		store.ErrorCodeFsBrokenSuper: 1,
+		store.ErrorCodeFsOrphan:      4,
+	})
+	_, err = s.Read(ctx, store.ReadRequest{Ref: storetest.Ref("odinson", "")})
+	storetest.AssertErrorCode(t, err, store.ErrorCodeNotFound)
+}
+
+func assertProblems(t *testing.T, report store.ScanReport, expected map[string]int) {
+	t.Helper()
+	counts := map[string]int{}
+	for _, p := range report.Problems {
+		counts[p.Code()]++
+	}
+	for code, n := range expected {
+		if counts[code] != n {
+			t.Errorf("expected %d problems with code %s, got %d in %v", n, code, counts[code], describe(report.Problems))
+		}
+	}
+}
+
+func describe(problems []aldberr.CanvigaError) []string {
+	out := make([]string, len(problems))
+	for i, p := range problems {
+		out[i] = p.Error() + " " + p.Details()["path"].(string)
+	}
+	return out
+}
@@ -0,0 +1,240 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/blobstore"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+//MemoryStore is a Store that keeps everything in memory. It is the reference implementation of Store. Blob
+//content is kept in a blobstore.MemoryStore, see Blobs.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	activities map[string]*memoryActivity
+	blobs      *blobstore.MemoryStore
+}
+
+type memoryActivity struct {
+	//versions in order, the last one is the latest.
+	versions []activity.Activity
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{activities: map[string]*memoryActivity{}, blobs: blobstore.NewMemoryStore()}
+}
+
+//Blobs returns the store of the blob content, of which the unreferenced content can be removed with
+//CollectBlobs.
+func (s *MemoryStore) Blobs() blobstore.Store {
+	return s.blobs
+}
+
+func (s *MemoryStore) Create(ctx context.Context, req CreateRequest) (CreateResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return CreateResponse{}, err
+	}
+	id, err := activityKey(req.ToCreate.ActivityRef)
+	if err != nil {
+		return CreateResponse{}, err
+	}
+	toCreate := Detach(req.ToCreate)
+	err = ingestBlob(toCreate.Blob, func(r blob.Rendition) (blob.Rendition, error) {
+		res, err := s.blobs.Put(ctx, blobstore.PutRequest{Rendition: r})
+		return res.Rendition, err
+	})
+	if err != nil {
+		return CreateResponse{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ma, found := s.activities[id]
+	if !found {
+		ma = &memoryActivity{}
+	}
+	toCreate, err = PrepareVersion(toCreate, ma.versionIds(), func(version string) (activity.Activity, error) {
+		i, _ := ma.version(version)
+		return ma.versions[i], nil
+	})
+	if err != nil {
+		return CreateResponse{}, err
+	}
+	ma.versions = append(ma.versions, toCreate)
+	s.activities[id] = ma
+	return CreateResponse{Created: Detach(toCreate)}, nil
+}
+
+func (s *MemoryStore) Read(ctx context.Context, req ReadRequest) (ReadResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ReadResponse{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, err := s.read(req.Ref)
+	if err != nil {
+		return ReadResponse{}, err
+	}
+	return ReadResponse{Activity: Detach(a)}, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, req ListRequest) (ListResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ListResponse{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, 0, len(s.activities))
+	for id := range s.activities {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	out := make([]activity.Activity, 0, len(ids))
+	for _, id := range ids {
+		if a := s.activities[id].latest(); req.Matches(a) {
+			out = append(out, Detach(a))
+		}
+	}
+	return ListResponse{Activities: out}, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, req DeleteRequest) (DeleteResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return DeleteResponse{}, err
+	}
+	id, err := activityKey(req.Ref)
+	if err != nil {
+		return DeleteResponse{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ma, found := s.activities[id]
+	if !found {
+		return DeleteResponse{}, notFound(req.Ref)
+	}
+	if len(req.Ref.Version) == 0 {
+		delete(s.activities, id)
+		return DeleteResponse{}, nil
+	}
+	i, found := ma.version(req.Ref.Version)
+	if !found {
+		return DeleteResponse{}, notFound(req.Ref)
+	}
+	ma.versions = append(ma.versions[:i:i], ma.versions[i+1:]...)
+	if len(ma.versions) == 0 {
+		delete(s.activities, id)
+	}
+	return DeleteResponse{}, nil
+}
+
+func (s *MemoryStore) ReadSubs(ctx context.Context, req ReadLinksRequest) (ReadLinksResponse, error) {
+	return s.readLinks(ctx, req, func(a activity.Activity) []*activity.Activity { return a.Subs })
+}
+
+func (s *MemoryStore) ReadSupers(ctx context.Context, req ReadLinksRequest) (ReadLinksResponse, error) {
+	return s.readLinks(ctx, req, func(a activity.Activity) []*activity.Activity { return a.Supers })
+}
+
+func (s *MemoryStore) readLinks(ctx context.Context, req ReadLinksRequest, getLinks func(a activity.Activity) []*activity.Activity) (ReadLinksResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ReadLinksResponse{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, err := s.read(req.Ref)
+	if err != nil {
+		return ReadLinksResponse{}, err
+	}
+	links := getLinks(a)
+	out := make([]activity.Activity, len(links))
+	for i, link := range links {
+		linked, err := s.read(link.ActivityRef)
+		if err != nil {
+			return ReadLinksResponse{}, err
+		}
+		out[i] = Detach(linked)
+	}
+	return ReadLinksResponse{Activities: out}, nil
+}
+
+func (s *MemoryStore) History(ctx context.Context, req HistoryRequest) (HistoryResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return HistoryResponse{}, err
+	}
+	id, err := activityKey(req.Ref)
+	if err != nil {
+		return HistoryResponse{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ma, found := s.activities[id]
+	if !found {
+		return HistoryResponse{}, notFound(req.Ref)
+	}
+	out := make([]activity.Activity, len(ma.versions))
+	for i := range ma.versions {
+		out[i] = Detach(ma.versions[i])
+	}
+	return HistoryResponse{Versions: out}, nil
+}
+
+//read must be called with at least a read lock held.
+func (s *MemoryStore) read(r ref.ActivityRef) (activity.Activity, error) {
+	id, err := activityKey(r)
+	if err != nil {
+		return activity.Activity{}, err
+	}
+	ma, found := s.activities[id]
+	if !found {
+		return activity.Activity{}, notFound(r)
+	}
+	if len(r.Version) == 0 {
+		return ma.latest(), nil
+	}
+	i, found := ma.version(r.Version)
+	if !found {
+		return activity.Activity{}, notFound(r)
+	}
+	return ma.versions[i], nil
+}
+
+func (ma *memoryActivity) latest() activity.Activity {
+	return ma.versions[len(ma.versions)-1]
+}
+
+func (ma *memoryActivity) versionIds() []string {
+	out := make([]string, len(ma.versions))
+	for i := range ma.versions {
+		out[i] = ma.versions[i].Version
+	}
+	return out
+}
+
+func (ma *memoryActivity) version(version string) (int, bool) {
+	for i := range ma.versions {
+		if ma.versions[i].Version == version {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func activityKey(r ref.ActivityRef) (string, error) {
+	if r.Id == nil || len(r.Id.String()) == 0 {
+		return "", aldberr.New(ErrorCodeInvalidRequest, "activity ref has no id", nil)
+	}
+	return r.Id.String(), nil
+}
+
+func notFound(r ref.ActivityRef) error {
+	det := map[string]interface{}{"version": r.Version}
+	if r.Id != nil {
+		det["id"] = r.Id.String()
+	}
+	return aldberr.New(ErrorCodeNotFound, "activity not found", det)
+}
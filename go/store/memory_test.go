@@ -0,0 +1,14 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/vital-dhaveloose/aldb/store"
+	"github.com/vital-dhaveloose/aldb/store/storetest"
+)
+
+func TestMemoryStoreConformance(t *testing.T) {
+	storetest.RunConformanceTests(t, func(t *testing.T) store.Store {
+		return store.NewMemoryStore()
+	})
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/base/errcode"
+	"github.com/vital-dhaveloose/aldb/common/datetime"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+const (
+	ErrorCodeNotFound       = "store-not-found"
+	ErrorCodeAlreadyExists  = "store-already-exists"
+	ErrorCodeInvalidRequest = "store-invalid-request"
+	//ErrorCodeVersionNotIncreasing is returned when a new version doesn't come after the latest version.
+	ErrorCodeVersionNotIncreasing = "store-version-not-increasing"
+)
+
+func init() {
+	errcode.Register(
+		errcode.Code{Code: ErrorCodeNotFound, Status: http.StatusNotFound, Message: errcode.En("activity not found")},
+		errcode.Code{Code: ErrorCodeAlreadyExists, Status: http.StatusConflict, Message: errcode.En("the activity already exists")},
+		errcode.Code{Code: ErrorCodeInvalidRequest, Status: http.StatusBadRequest, Message: errcode.En("invalid store request")},
+		errcode.Code{Code: ErrorCodeVersionNotIncreasing, Status: http.StatusConflict, Message: errcode.En("the version doesn't come after the latest version")},
+	)
+}
+
+//Store persists versions of activities. Implementations must be safe for concurrent use.
+//
+//Versions are immutable: a version is never changed once it's created, every change creates a new version.
+//The versions of an activity are ordered lexicographically by their Version (see ref.ActivityRef), the
+//greatest one is the latest version.
+//
+//Activities going in and out of a Store are values: a Store never keeps or hands out pointers into its own
+//state. Subs and Supers of the Activities returned by a Store only have their ActivityRef (without Version)
+//filled in, use ReadSubs and ReadSupers to resolve them.
+type Store interface {
+	Create(ctx context.Context, req CreateRequest) (CreateResponse, error)
+	Read(ctx context.Context, req ReadRequest) (ReadResponse, error)
+	List(ctx context.Context, req ListRequest) (ListResponse, error)
+	Delete(ctx context.Context, req DeleteRequest) (DeleteResponse, error)
+	ReadSubs(ctx context.Context, req ReadLinksRequest) (ReadLinksResponse, error)
+	ReadSupers(ctx context.Context, req ReadLinksRequest) (ReadLinksResponse, error)
+	History(ctx context.Context, req HistoryRequest) (HistoryResponse, error)
+}
+
+//CreateRequest creates a new version of an activity, which becomes its latest version.
+//
+//If ToCreate.ParentVersions is nil, the parent of the new version is the latest version (if any). Parts of
+//the activity that are nil in ToCreate are carried over from the (first) parent version: the AttributeSets
+//map, the Blob, the Participations, the Subs and the Supers. To remove such a part, pass an empty non-nil
+//value (an empty map or slice, or a Blob without Renditions). The Label and the Period are never
+//carried over, they are always taken from ToCreate.
+type CreateRequest struct {
+	//ToCreate is the new version of the activity. Its Id is required. If its Version is empty, the Store
+	//generates one with ref.NextVersion, otherwise the version must come after the latest version.
+	ToCreate activity.Activity
+}
+
+type CreateResponse struct {
+	Created activity.Activity
+}
+
+type ReadRequest struct {
+	//Ref is the activity to read. If Version is empty, the latest version is read.
+	Ref ref.ActivityRef
+}
+
+type ReadResponse struct {
+	Activity activity.Activity
+}
+
+type ListRequest struct {
+	//Current, if not nil, only lists the activities that are current at some time during the period (see
+	//activity.Activity.IsCurrentDuring). Use datetime.At for the activities that are current at a time.
+	Current *datetime.Period
+}
+
+//Matches tells whether the activity a is listed for the request.
+func (req ListRequest) Matches(a activity.Activity) bool {
+	return req.Current == nil || a.IsCurrentDuring(*req.Current)
+}
+
+type ListResponse struct {
+	//Activities contains the latest version of every activity that Matches the request, sorted by id.
+	Activities []activity.Activity
+}
+
+type DeleteRequest struct {
+	//Ref is the activity to delete. If Version is empty, all versions of the activity are deleted.
+	Ref ref.ActivityRef
+}
+
+type DeleteResponse struct {
+}
+
+type HistoryRequest struct {
+	//Ref is the activity of which to read the history. Its Version is ignored.
+	Ref ref.ActivityRef
+}
+
+type HistoryResponse struct {
+	//Versions contains every version of the activity, oldest first.
+	Versions []activity.Activity
+}
+
+type ReadLinksRequest struct {
+	//Ref is the activity of which to resolve the links. If Version is empty, the latest version is used.
+	Ref ref.ActivityRef
+}
+
+type ReadLinksResponse struct {
+	//Activities contains the latest version of every linked activity, in the order of the links.
+	Activities []activity.Activity
+}
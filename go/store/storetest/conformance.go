@@ -0,0 +1,604 @@
+//Package storetest contains the conformance tests that every store.Store implementation must pass.
+package storetest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/attributes"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/common/datetime"
+	"github.com/vital-dhaveloose/aldb/common/lang"
+	"github.com/vital-dhaveloose/aldb/common/mediatype"
+	"github.com/vital-dhaveloose/aldb/ref"
+	"github.com/vital-dhaveloose/aldb/store"
+)
+
+//NewStoreFunc creates a new, empty Store for a single test.
+type NewStoreFunc func(t *testing.T) store.Store
+
+//RunConformanceTests runs the conformance test suite against the Store implementation created by newStore.
+func RunConformanceTests(t *testing.T, newStore NewStoreFunc) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s store.Store)
+	}{
+		{"CreateAndRead", testCreateAndRead},
+		{"CreateGeneratesVersion", testCreateGeneratesVersion},
+		{"CreateRequiresId", testCreateRequiresId},
+		{"CreateExistingVersion", testCreateExistingVersion},
+		{"CreateVersionNotIncreasing", testCreateVersionNotIncreasing},
+		{"CreateCarriesOver", testCreateCarriesOver},
+		{"CreateParentVersions", testCreateParentVersions},
+		{"Blob", testBlob},
+		{"BlobRenditions", testBlobRenditions},
+		{"History", testHistory},
+		{"ReadNotFound", testReadNotFound},
+		{"List", testList},
+		{"ListCurrent", testListCurrent},
+		{"DeleteVersion", testDeleteVersion},
+		{"DeleteActivity", testDeleteActivity},
+		{"ReadLinks", testReadLinks},
+		{"Isolation", testIsolation},
+		{"Concurrency", testConcurrency},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+func testCreateAndRead(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	mustCreate(t, s, NewActivity("a", "1", "first"))
+	mustCreate(t, s, NewActivity("a", "2", "second"))
+
+	latest := mustRead(t, s, Ref("a", ""))
+	if latest.Version != "2" || label(latest) != "second" {
+		t.Errorf("expected latest version 2 'second', got %s '%s'", latest.Version, label(latest))
+	}
+	first := mustRead(t, s, Ref("a", "1"))
+	if first.Version != "1" || label(first) != "first" {
+		t.Errorf("expected version 1 'first', got %s '%s'", first.Version, label(first))
+	}
+	if first.AttributeSets["test-attrs"].Attributes["nested"].(map[string]interface{})["value"] != "first" {
+		t.Errorf("attributes were not stored")
+	}
+	_, err := s.Read(ctx, store.ReadRequest{Ref: Ref("a", "3")})
+	AssertErrorCode(t, err, store.ErrorCodeNotFound)
+}
+
+func testCreateGeneratesVersion(t *testing.T, s store.Store) {
+	created1 := mustCreate(t, s, NewActivity("a", "", "first"))
+	created2 := mustCreate(t, s, NewActivity("a", "", "second"))
+	if len(created1.Version) == 0 || len(created2.Version) == 0 {
+		t.Fatalf("expected generated versions, got '%s' and '%s'", created1.Version, created2.Version)
+	}
+	if created1.Version >= created2.Version {
+		t.Errorf("expected increasing versions, got '%s' and '%s'", created1.Version, created2.Version)
+	}
+	if len(created1.ParentVersions) != 0 {
+		t.Errorf("expected no parent for the first version, got %v", created1.ParentVersions)
+	}
+	if len(created2.ParentVersions) != 1 || created2.ParentVersions[0] != created1.Version {
+		t.Errorf("expected parent %s, got %v", created1.Version, created2.ParentVersions)
+	}
+	if latest := mustRead(t, s, Ref("a", "")); latest.Version != created2.Version {
+		t.Errorf("expected latest version %s, got %s", created2.Version, latest.Version)
+	}
+}
+
+func testCreateRequiresId(t *testing.T, s store.Store) {
+	_, err := s.Create(context.Background(), store.CreateRequest{ToCreate: activity.Activity{}})
+	AssertErrorCode(t, err, store.ErrorCodeInvalidRequest)
+}
+
+func testCreateExistingVersion(t *testing.T, s store.Store) {
+	mustCreate(t, s, NewActivity("a", "1", "first"))
+	_, err := s.Create(context.Background(), store.CreateRequest{ToCreate: NewActivity("a", "1", "again")})
+	AssertErrorCode(t, err, store.ErrorCodeAlreadyExists)
+	if a := mustRead(t, s, Ref("a", "1")); label(a) != "first" {
+		t.Errorf("existing version was overwritten")
+	}
+}
+
+func testCreateVersionNotIncreasing(t *testing.T, s store.Store) {
+	mustCreate(t, s, NewActivity("a", "2", "second"))
+	_, err := s.Create(context.Background(), store.CreateRequest{ToCreate: NewActivity("a", "1", "first")})
+	AssertErrorCode(t, err, store.ErrorCodeVersionNotIncreasing)
+	created := mustCreate(t, s, NewActivity("a", "", "generated"))
+	if created.Version <= "2" {
+		t.Errorf("expected generated version to come after 2, got %s", created.Version)
+	}
+}
+
+func testCreateCarriesOver(t *testing.T, s store.Store) {
+	parent := NewActivity("parent", "", "parent")
+	mustCreate(t, s, parent)
+	first := NewActivity("a", "1", "first")
+	first.Supers = []*activity.Activity{&parent}
+	first.Blob = NewBlob("text/plain", "text")
+	mustCreate(t, s, first)
+
+	second := activity.Activity{ActivityRef: Ref("a", "2"), Label: lang.LocalizableString{lang.LangAny: "second"}}
+	mustCreate(t, s, second)
+	read := mustRead(t, s, Ref("a", "2"))
+	if label(read) != "second" {
+		t.Errorf("expected label to be taken from the new version, got %s", label(read))
+	}
+	if _, found := read.AttributeSets["test-attrs"]; !found {
+		t.Errorf("expected attribute sets to be carried over")
+	}
+	if content(t, read, blob.RenditionFunctionMain) != "text" {
+		t.Errorf("expected blob to be carried over")
+	}
+	if len(read.Supers) != 1 {
+		t.Errorf("expected supers to be carried over")
+	}
+
+	third := activity.Activity{
+		ActivityRef:   Ref("a", "3"),
+		AttributeSets: map[string]attributes.AttributeSet{},
+		Blob:          &blob.Blob{},
+		Supers:        []*activity.Activity{},
+	}
+	mustCreate(t, s, third)
+	read = mustRead(t, s, Ref("a", "3"))
+	if len(read.AttributeSets) > 0 || read.Blob != nil || len(read.Supers) > 0 {
+		t.Errorf("expected empty values to remove the parts, got %v", read)
+	}
+	if read = mustRead(t, s, Ref("a", "1")); read.Blob == nil || len(read.AttributeSets) == 0 {
+		t.Errorf("expected old version to be unchanged")
+	}
+}
+
+func testCreateParentVersions(t *testing.T, s store.Store) {
+	mustCreate(t, s, NewActivity("a", "1", "first"))
+	mustCreate(t, s, NewActivity("a", "2", "second"))
+	merge := NewActivity("a", "3", "merge")
+	merge.ParentVersions = []string{"1", "2"}
+	mustCreate(t, s, merge)
+	if read := mustRead(t, s, Ref("a", "3")); len(read.ParentVersions) != 2 {
+		t.Errorf("expected 2 parent versions, got %v", read.ParentVersions)
+	}
+
+	orphan := NewActivity("a", "4", "orphan")
+	orphan.ParentVersions = []string{"0"}
+	_, err := s.Create(context.Background(), store.CreateRequest{ToCreate: orphan})
+	AssertErrorCode(t, err, store.ErrorCodeInvalidRequest)
+}
+
+func testBlob(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	a := NewActivity("a", "1", "first")
+	a.Blob = &blob.Blob{Renditions: []blob.Rendition{{
+		Function: blob.RenditionFunctionMain,
+		Manifest: blob.BlobManifest{MediaType: mediatype.MustParse("text/plain")},
+		Content:  blob.FromReader(strings.NewReader("streamed")),
+	}}}
+	created := mustCreate(t, s, a)
+	main, _ := created.Blob.Main()
+	if main.Manifest.Size != 8 || main.Manifest.SHA256 != sha256Hex("streamed") {
+		t.Errorf("expected size and checksum to be computed, got %d %s", main.Manifest.Size, main.Manifest.SHA256)
+	}
+	if c := content(t, mustRead(t, s, Ref("a", "1")), blob.RenditionFunctionMain); c != "streamed" {
+		t.Errorf("expected streamed content, got '%s'", c)
+	}
+	rc, err := main.Content.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, isSeeker := rc.(io.Seeker); !isSeeker {
+		t.Errorf("expected content from the store to be seekable")
+	}
+
+	//the content can be omitted when writing back what was read, if the checksum matches
+	described := Ref("a", "2")
+	read := mustRead(t, s, Ref("a", "1"))
+	read.ActivityRef = described
+	read.ParentVersions = nil
+	read.Blob.Renditions[0].Content = nil
+	mustCreate(t, s, read)
+	if c := content(t, mustRead(t, s, described), blob.RenditionFunctionMain); c != "streamed" {
+		t.Errorf("expected content of the parent version, got '%s'", c)
+	}
+	read.ActivityRef = Ref("a", "3")
+	read.Blob.Renditions[0].Manifest.SHA256 = sha256Hex("other")
+	_, err = s.Create(ctx, store.CreateRequest{ToCreate: read})
+	AssertErrorCode(t, err, store.ErrorCodeInvalidRequest)
+
+	mismatch := NewActivity("a", "3", "third")
+	mismatch.Blob = NewBlob("text/plain", "text")
+	mismatch.Blob.Renditions[0].Manifest.SHA256 = sha256Hex("other")
+	_, err = s.Create(ctx, store.CreateRequest{ToCreate: mismatch})
+	AssertErrorCode(t, err, blob.ErrorCodeChecksumMismatch)
+	if latest := mustRead(t, s, Ref("a", "")); latest.Version != "2" {
+		t.Errorf("expected failed create not to create a version, got latest %s", latest.Version)
+	}
+}
+
+func testBlobRenditions(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	a := NewActivity("a", "1", "first")
+	b := NewBlob("image/png", "png").WithRendition(blob.Rendition{
+		Function: blob.RenditionFunctionThumbnail,
+		Manifest: blob.BlobManifest{MediaType: mediatype.MustParse("image/png")},
+		Content:  blob.Bytes([]byte("thumb")),
+	})
+	a.Blob = &b
+	mustCreate(t, s, a)
+
+	//replacing the thumbnail carries over the main content
+	next := activity.Activity{ActivityRef: Ref("a", "2")}
+	read := mustRead(t, s, Ref("a", "1"))
+	nb := read.Blob.WithRendition(blob.Rendition{
+		Function: blob.RenditionFunctionThumbnail,
+		Manifest: blob.BlobManifest{MediaType: mediatype.MustParse("image/png")},
+		Content:  blob.Bytes([]byte("thumb2")),
+	})
+	next.Blob = &nb
+	mustCreate(t, s, next)
+
+	for version, expected := range map[string][2]string{"1": {"png", "thumb"}, "2": {"png", "thumb2"}} {
+		read := mustRead(t, s, Ref("a", version))
+		if len(read.Blob.Renditions) != 2 {
+			t.Errorf("expected 2 renditions in version %s, got %d", version, len(read.Blob.Renditions))
+		}
+		if main := content(t, read, blob.RenditionFunctionMain); main != expected[0] {
+			t.Errorf("expected main rendition '%s' in version %s, got '%s'", expected[0], version, main)
+		}
+		if thumb := content(t, read, blob.RenditionFunctionThumbnail); thumb != expected[1] {
+			t.Errorf("expected thumbnail '%s' in version %s, got '%s'", expected[1], version, thumb)
+		}
+	}
+
+	if _, err := s.Delete(ctx, store.DeleteRequest{Ref: Ref("a", "2")}); err != nil {
+		t.Fatal(err)
+	}
+	if thumb := content(t, mustRead(t, s, Ref("a", "")), blob.RenditionFunctionThumbnail); thumb != "thumb" {
+		t.Errorf("expected thumbnail of version 1 after deleting version 2, got '%s'", thumb)
+	}
+
+	duplicate := NewActivity("b", "1", "b")
+	duplicate.Blob = &blob.Blob{Renditions: []blob.Rendition{NewBlob("text/plain", "x").Renditions[0], NewBlob("text/plain", "y").Renditions[0]}}
+	_, err := s.Create(ctx, store.CreateRequest{ToCreate: duplicate})
+	AssertErrorCode(t, err, store.ErrorCodeInvalidRequest)
+}
+
+func testHistory(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	mustCreate(t, s, NewActivity("a", "", "first"))
+	mustCreate(t, s, NewActivity("a", "", "second"))
+	mustCreate(t, s, NewActivity("a", "", "third"))
+
+	resp, err := s.History(ctx, store.HistoryRequest{Ref: Ref("a", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Versions) != 3 {
+		t.Fatalf("expected 3 versions, got %d", len(resp.Versions))
+	}
+	for i, expected := range []string{"first", "second", "third"} {
+		if label(resp.Versions[i]) != expected {
+			t.Errorf("expected version %d to be %s, got %s", i, expected, label(resp.Versions[i]))
+		}
+		if i > 0 && resp.Versions[i].ParentVersions[0] != resp.Versions[i-1].Version {
+			t.Errorf("expected version %d to have the previous version as parent", i)
+		}
+	}
+	_, err = s.History(ctx, store.HistoryRequest{Ref: Ref("nope", "")})
+	AssertErrorCode(t, err, store.ErrorCodeNotFound)
+}
+
+func testReadNotFound(t *testing.T, s store.Store) {
+	_, err := s.Read(context.Background(), store.ReadRequest{Ref: Ref("nope", "")})
+	AssertErrorCode(t, err, store.ErrorCodeNotFound)
+}
+
+func testList(t *testing.T, s store.Store) {
+	mustCreate(t, s, NewActivity("b", "1", "b1"))
+	mustCreate(t, s, NewActivity("a", "1", "a1"))
+	mustCreate(t, s, NewActivity("b", "2", "b2"))
+
+	resp, err := s.List(context.Background(), store.ListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Activities) != 2 {
+		t.Fatalf("expected 2 activities, got %d", len(resp.Activities))
+	}
+	if label(resp.Activities[0]) != "a1" || label(resp.Activities[1]) != "b2" {
+		t.Errorf("expected [a1 b2], got [%s %s]", label(resp.Activities[0]), label(resp.Activities[1]))
+	}
+}
+
+func testListCurrent(t *testing.T, s store.Store) {
+	day := func(d int) time.Time {
+		return time.Date(2019, 7, d, 0, 0, 0, 0, time.UTC)
+	}
+	for _, a := range []struct {
+		id     string
+		period datetime.Period
+	}{
+		{"always", datetime.Always},
+		{"july", datetime.Between(day(1), day(31))},
+		{"since", datetime.Since(day(25))},
+		{"until", datetime.Until(day(10))},
+		{"past", datetime.Between(day(1), day(5))},
+		{"mondays", datetime.Period{Start: day(1).Add(9 * time.Hour), End: day(1).Add(10 * time.Hour), Recurrence: &datetime.Recurrence{Rule: datetime.RRule{Freq: datetime.Weekly}}}},
+	} {
+		toCreate := NewActivity(a.id, "1", a.id)
+		toCreate.Period = a.period
+		mustCreate(t, s, toCreate)
+	}
+	for _, c := range []struct {
+		current  datetime.Period
+		expected string
+	}{
+		{datetime.At(day(2)), "always july past until"},
+		{datetime.At(day(10)), "always july"},
+		{datetime.At(day(8).Add(9*time.Hour + 30*time.Minute)), "always july mondays until"},
+		{datetime.Between(day(20), day(26)), "always july mondays since"},
+		{datetime.Between(day(23), day(26)), "always july since"},
+		{datetime.Since(day(31)), "always mondays since"},
+	} {
+		c := c
+		resp, err := s.List(context.Background(), store.ListRequest{Current: &c.current})
+		if err != nil {
+			t.Fatal(err)
+		}
+		labels := []string{}
+		for _, a := range resp.Activities {
+			labels = append(labels, label(a))
+		}
+		if strings.Join(labels, " ") != c.expected {
+			t.Errorf("%s: expected [%s], got %v", c.current, c.expected, labels)
+		}
+	}
+}
+
+func testDeleteVersion(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	mustCreate(t, s, NewActivity("a", "1", "first"))
+	mustCreate(t, s, NewActivity("a", "2", "second"))
+
+	if _, err := s.Delete(ctx, store.DeleteRequest{Ref: Ref("a", "2")}); err != nil {
+		t.Fatal(err)
+	}
+	if latest := mustRead(t, s, Ref("a", "")); latest.Version != "1" {
+		t.Errorf("expected latest version 1 after delete, got %s", latest.Version)
+	}
+	_, err := s.Delete(ctx, store.DeleteRequest{Ref: Ref("a", "2")})
+	AssertErrorCode(t, err, store.ErrorCodeNotFound)
+}
+
+func testDeleteActivity(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	mustCreate(t, s, NewActivity("a", "1", "first"))
+	mustCreate(t, s, NewActivity("a", "2", "second"))
+
+	if _, err := s.Delete(ctx, store.DeleteRequest{Ref: Ref("a", "")}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Read(ctx, store.ReadRequest{Ref: Ref("a", "1")})
+	AssertErrorCode(t, err, store.ErrorCodeNotFound)
+	_, err = s.Delete(ctx, store.DeleteRequest{Ref: Ref("a", "")})
+	AssertErrorCode(t, err, store.ErrorCodeNotFound)
+}
+
+func testReadLinks(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	parent := NewActivity("parent", "", "parent")
+	mustCreate(t, s, parent)
+	child := NewActivity("child", "", "child")
+	child.Supers = []*activity.Activity{&parent}
+	mustCreate(t, s, child)
+	parent.Subs = []*activity.Activity{&child}
+	mustCreate(t, s, parent)
+
+	read := mustRead(t, s, Ref("child", ""))
+	if len(read.Supers) != 1 || read.Supers[0].Id.String() != parent.Id.String() || len(read.Supers[0].Version) > 0 {
+		t.Fatalf("expected a version-less reference to the parent in Supers, got %v", read.Supers)
+	}
+	if read.Supers[0].Label != nil {
+		t.Errorf("expected Supers to contain references only")
+	}
+
+	supers, err := s.ReadSupers(ctx, store.ReadLinksRequest{Ref: Ref("child", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(supers.Activities) != 1 || label(supers.Activities[0]) != "parent" {
+		t.Errorf("expected parent to be resolved, got %v", supers.Activities)
+	}
+	subs, err := s.ReadSubs(ctx, store.ReadLinksRequest{Ref: Ref("parent", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs.Activities) != 1 || label(subs.Activities[0]) != "child" {
+		t.Errorf("expected child to be resolved, got %v", subs.Activities)
+	}
+	subs, err = s.ReadSubs(ctx, store.ReadLinksRequest{Ref: Ref("child", "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs.Activities) != 0 {
+		t.Errorf("expected no subs, got %v", subs.Activities)
+	}
+}
+
+func testIsolation(t *testing.T, s store.Store) {
+	a := NewActivity("a", "1", "original")
+	created := mustCreate(t, s, a)
+	a.AttributeSets["test-attrs"].Attributes["nested"].(map[string]interface{})["value"] = "changed"
+	a.Label.(lang.LocalizableString)[lang.LangAny] = "changed"
+	created.AttributeSets["test-attrs"].Attributes["nested"].(map[string]interface{})["value"] = "changed"
+
+	read := mustRead(t, s, Ref("a", "1"))
+	if read.AttributeSets["test-attrs"].Attributes["nested"].(map[string]interface{})["value"] != "original" {
+		t.Errorf("store shares attributes with its callers")
+	}
+	if label(read) != "original" {
+		t.Errorf("store shares the label with its callers")
+	}
+	read.AttributeSets["test-attrs"].Attributes["nested"].(map[string]interface{})["value"] = "changed"
+	if reread := mustRead(t, s, Ref("a", "1")); reread.AttributeSets["test-attrs"].Attributes["nested"].(map[string]interface{})["value"] != "original" {
+		t.Errorf("store shares attributes with its callers")
+	}
+
+	b := NewActivity("b", "1", "participations")
+	start := time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC)
+	b.Participations = []participation.Participation{{
+		Entity: &participation.Person{
+			Ref:     participation.EntityRef{EntityId: "alice"},
+			Name:    participation.PersonName{Given: "Alice"},
+			Contact: participation.ContactDetails{Phones: []participation.ContactPoint{{Value: "+32 2 123 45 67", Types: []string{"work"}}}},
+		},
+		Role: &participation.ParticipationRole{ParticipationRoleRef: participation.ParticipationRoleRef{ParticipationRoleId: "lead"}},
+		Period: datetime.Period{Start: start, End: start.Add(time.Hour), Recurrence: &datetime.Recurrence{
+			Rule:    datetime.RRule{Freq: datetime.Weekly, Count: 3},
+			ExDates: []time.Time{start.AddDate(0, 0, 7)},
+		}},
+	}}
+	mustCreate(t, s, b)
+	read = mustRead(t, s, Ref("b", "1"))
+	p := read.Participations[0]
+	p.Entity.(*participation.Person).Contact.Phones[0].Types[0] = "home"
+	p.Role.ParticipationRoleId = "guest"
+	p.Period.Recurrence.ExDates[0] = start
+	reread := mustRead(t, s, Ref("b", "1")).Participations[0]
+	if reread.Entity.(*participation.Person).Contact.Phones[0].Types[0] != "work" {
+		t.Errorf("store shares the entities of participations with its callers")
+	}
+	if reread.Role.ParticipationRoleId != "lead" {
+		t.Errorf("store shares the roles of participations with its callers")
+	}
+	if !reread.Period.Recurrence.ExDates[0].Equal(start.AddDate(0, 0, 7)) {
+		t.Errorf("store shares the recurrences of participations with its callers")
+	}
+}
+
+func testConcurrency(t *testing.T, s store.Store) {
+	const n = 20
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.Background()
+			if _, err := s.Create(ctx, store.CreateRequest{ToCreate: NewActivity("a", "", fmt.Sprintf("v%d", i))}); err != nil {
+				errs <- err
+			}
+			if _, err := s.List(ctx, store.ListRequest{}); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+//NewActivity creates an Activity with the given id (relative to https://aldb.test/activities/), version
+//and label, and with an attribute set "test-attrs" containing the label in a nested value.
+func NewActivity(id, version, lbl string) activity.Activity {
+	return activity.Activity{
+		ActivityRef: Ref(id, version),
+		Label:       lang.LocalizableString{lang.LangAny: lbl},
+		AttributeSets: map[string]attributes.AttributeSet{
+			"test-attrs": {
+				Manifest:   &attributes.Manifest{ManifestRef: ref.ManifestRef{Id: &url.URL{Scheme: "https", Host: "aldb.test", Path: "/manifests/test"}}},
+				Attributes: map[string]interface{}{"nested": map[string]interface{}{"value": lbl}},
+			},
+		},
+	}
+}
+
+//NewBlob creates a Blob with a main rendition of the given media type and content.
+func NewBlob(mediaType, content string) *blob.Blob {
+	return &blob.Blob{Renditions: []blob.Rendition{{
+		Function: blob.RenditionFunctionMain,
+		Manifest: blob.BlobManifest{MediaType: mediatype.MustParse(mediaType)},
+		Content:  blob.Bytes([]byte(content)),
+	}}}
+}
+
+//Ref creates an ActivityRef with the given id (relative to https://aldb.test/activities/) and version.
+func Ref(id, version string) ref.ActivityRef {
+	return ref.ActivityRef{Id: &url.URL{Scheme: "https", Host: "aldb.test", Path: "/activities/" + id}, Version: version}
+}
+
+//AssertErrorCode fails the test if err isn't an aldberr.CanvigaError with the given code.
+func AssertErrorCode(t *testing.T, err error, code string) {
+	t.Helper()
+	actual, isCoded := aldberr.CodeOf(err)
+	if !isCoded {
+		t.Errorf("expected error with code %s, got %v", code, err)
+		return
+	}
+	if !aldberr.IsCode(err, code) {
+		t.Errorf("expected error with code %s, got %s", code, actual)
+	}
+}
+
+func mustCreate(t *testing.T, s store.Store, a activity.Activity) activity.Activity {
+	t.Helper()
+	resp, err := s.Create(context.Background(), store.CreateRequest{ToCreate: a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Created
+}
+
+func mustRead(t *testing.T, s store.Store, r ref.ActivityRef) activity.Activity {
+	t.Helper()
+	resp, err := s.Read(context.Background(), store.ReadRequest{Ref: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Activity
+}
+
+//content returns the content of a rendition of the blob of a, or "" if it has none.
+func content(t *testing.T, a activity.Activity, fn blob.RenditionFunction) string {
+	t.Helper()
+	r, found := a.Blob.Rendition(fn)
+	if !found || r.Content == nil {
+		return ""
+	}
+	bts, err := blob.ReadAll(r.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bts)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func label(a activity.Activity) string {
+	if a.Label == nil {
+		return ""
+	}
+	s, _ := a.Label.Localize(lang.LangAny, nil)
+	return s
+}
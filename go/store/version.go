@@ -0,0 +1,166 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/vital-dhaveloose/aldb/activity"
+	"github.com/vital-dhaveloose/aldb/activity/blob"
+	"github.com/vital-dhaveloose/aldb/activity/participation"
+	"github.com/vital-dhaveloose/aldb/base/aldberr"
+	"github.com/vital-dhaveloose/aldb/ref"
+)
+
+//PrepareVersion applies the rules of CreateRequest to toCreate, given the existing versions of the activity
+//(in order, oldest first) and a function to read one of them. It generates or checks the Version, sets the
+//ParentVersions and carries over the omitted parts from the first parent version. Store implementations
+//call it before persisting a new version.
+//
+//Renditions of the Blob without Content get the content of the same rendition of the first parent version,
+//so that an activity that was read (e.g. as JSON, without content) can be written back. Stores must write
+//the Content of the other renditions, computing their Size and SHA256 with blob.Ingest.
+func PrepareVersion(toCreate activity.Activity, versions []string, readVersion func(version string) (activity.Activity, error)) (activity.Activity, error) {
+	det := map[string]interface{}{"version": toCreate.Version}
+	if toCreate.Id != nil {
+		det["id"] = toCreate.Id.String()
+	}
+	latest := ""
+	if len(versions) > 0 {
+		latest = versions[len(versions)-1]
+	}
+	exists := func(v string) bool {
+		for i := range versions {
+			if versions[i] == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(toCreate.Version) == 0 {
+		toCreate.Version = ref.NextVersion(latest)
+	} else if exists(toCreate.Version) {
+		return activity.Activity{}, aldberr.New(ErrorCodeAlreadyExists, "cannot create activity version: version already exists", det)
+	} else if toCreate.Version <= latest {
+		return activity.Activity{}, aldberr.New(ErrorCodeVersionNotIncreasing, "cannot create activity version: version must come after the latest version", det).Det("latest", latest)
+	}
+
+	if toCreate.ParentVersions == nil && len(latest) > 0 {
+		toCreate.ParentVersions = []string{latest}
+	}
+	for _, parent := range toCreate.ParentVersions {
+		if !exists(parent) {
+			return activity.Activity{}, aldberr.New(ErrorCodeInvalidRequest, "cannot create activity version: parent version doesn't exist", det).Det("parent", parent)
+		}
+	}
+	if len(toCreate.ParentVersions) == 0 {
+		if err := resolveContents(toCreate, nil, det); err != nil {
+			return activity.Activity{}, err
+		}
+		return normalizeVersion(toCreate), nil
+	}
+
+	parent, err := readVersion(toCreate.ParentVersions[0])
+	if err != nil {
+		return activity.Activity{}, err
+	}
+	if toCreate.AttributeSets == nil {
+		toCreate.AttributeSets = parent.AttributeSets
+	}
+	if toCreate.Blob == nil {
+		toCreate.Blob = parent.Blob
+	}
+	if toCreate.Participations == nil {
+		toCreate.Participations = make([]participation.Participation, len(parent.Participations))
+		for i, p := range parent.Participations {
+			p.ActivityRef = toCreate.ActivityRef
+			toCreate.Participations[i] = p
+		}
+	}
+	if toCreate.Subs == nil {
+		toCreate.Subs = parent.Subs
+	}
+	if toCreate.Supers == nil {
+		toCreate.Supers = parent.Supers
+	}
+	if err := resolveContents(toCreate, &parent, det); err != nil {
+		return activity.Activity{}, err
+	}
+	return normalizeVersion(toCreate), nil
+}
+
+//resolveContents gives the renditions of toCreate without Content the content of the same rendition of the
+//parent, of which the checksum must match if one is given. The renditions are changed in place.
+func resolveContents(toCreate activity.Activity, parent *activity.Activity, det map[string]interface{}) error {
+	if toCreate.Blob == nil {
+		return nil
+	}
+	for i, r := range toCreate.Blob.Renditions {
+		if r.Content != nil {
+			continue
+		}
+		var pr blob.Rendition
+		found := false
+		if parent != nil {
+			pr, found = parent.Blob.Rendition(r.Function)
+		}
+		if !found || pr.Content == nil || (len(r.Manifest.SHA256) > 0 && !strings.EqualFold(r.Manifest.SHA256, pr.Manifest.SHA256)) {
+			return aldberr.New(ErrorCodeInvalidRequest, "cannot create activity version: blob rendition has no content", det).Det("function", string(r.Function))
+		}
+		r.Content = pr.Content
+		r.Manifest.Size, r.Manifest.SHA256 = pr.Manifest.Size, pr.Manifest.SHA256
+		if r.Manifest.MediaType.IsZero() {
+			r.Manifest.MediaType = pr.Manifest.MediaType
+		}
+		toCreate.Blob.Renditions[i] = r
+	}
+	return nil
+}
+
+//ingestBlob checks the renditions of b and replaces the ones that have Content by the result of ingest,
+//which writes the content into the Store (see blob.Ingest). Renditions without Content are left for
+//PrepareVersion. The renditions are changed in place, so b must be owned by the Store.
+func ingestBlob(b *blob.Blob, ingest func(r blob.Rendition) (blob.Rendition, error)) error {
+	if b == nil {
+		return nil
+	}
+	seen := map[blob.RenditionFunction]bool{}
+	for i, r := range b.Renditions {
+		if len(r.Function) == 0 {
+			return aldberr.New(ErrorCodeInvalidRequest, "blob rendition has no function", nil)
+		}
+		if seen[r.Function] {
+			return aldberr.New(ErrorCodeInvalidRequest, "blob has several renditions with the same function", map[string]interface{}{"function": string(r.Function)})
+		}
+		seen[r.Function] = true
+		if r.Content == nil {
+			continue
+		}
+		ingested, err := ingest(r)
+		if err != nil {
+			return err
+		}
+		b.Renditions[i] = ingested
+	}
+	return nil
+}
+
+//normalizeVersion turns the empty values used to remove parts into nil, so that they aren't carried over
+//as "empty" again by the next version.
+func normalizeVersion(a activity.Activity) activity.Activity {
+	if len(a.AttributeSets) == 0 {
+		a.AttributeSets = nil
+	}
+	if a.Blob != nil && len(a.Blob.Renditions) == 0 {
+		a.Blob = nil
+	}
+	if len(a.Participations) == 0 {
+		a.Participations = nil
+	}
+	if len(a.Subs) == 0 {
+		a.Subs = nil
+	}
+	if len(a.Supers) == 0 {
+		a.Supers = nil
+	}
+	return a
+}